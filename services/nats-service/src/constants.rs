@@ -40,67 +40,76 @@ end
 return multiplier
 "#;
 
-pub const LUA_SCRIPT_BATCH_IP_COUNTER_SCRIPT: &str = r#"
+/// How long the audit of a ballot is remembered, a redelivered ballot within
+/// it is not counted again. Covers redeliveries while mongo is unavailable.
+pub const AUDIT_MARKER_EXPIRE_SECONDS: u64 = 24 * 60 * 60;
+
+/// How often stored ballots are checked for a lost audit request.
+pub const AUDIT_SWEEP_INTERVAL: Duration = Duration::from_secs(60);
+/// A ballot still pending this long after it was stored is handed to the
+/// audit again.
+pub const AUDIT_STALE_AFTER: Duration = Duration::from_secs(10 * 60);
+
+pub const LUA_SCRIPT_BATCH_AUDIT_SCRIPT: &str = r#"
+-- KEYS: empty (we use ARGV for dynamic key generation)
+-- ARGV: expire_seconds, max_ip_limit, base_multiplier, low_multiplier, marker_expire_seconds,
+--       then topic_id, ballot_id, ip_counter_key, win_id, lose_id per ballot
+-- Returns multiplier1, counted1, multiplier2, counted2, ...
+-- A ballot audited before keeps its multiplier and is not counted again, so
+-- a redelivered batch neither moves the ip counters nor the scores.
 local expire_seconds = ARGV[1]
 local max_ip_limit = tonumber(ARGV[2])
 local base_multiplier = tonumber(ARGV[3])
 local low_multiplier = tonumber(ARGV[4])
+local marker_expire_seconds = ARGV[5]
+
+-- 确保参数数量是5的倍数
+if (#ARGV - 5) % 5 ~= 0 then
+    return redis.error_reply("invalid argument count: must be 5 plus a multiple of 5")
+end
 
 local results = {}
 
-for i = 1, #KEYS do
-    local key = KEYS[i]
-    
-    local current = redis.call('INCR', key)
-    redis.call('EXPIRE', key, expire_seconds)
-    
-    local multiplier
-    if max_ip_limit < 0 or current <= max_ip_limit then
-        multiplier = base_multiplier
+for i = 6, #ARGV, 5 do
+    local topic_id = ARGV[i]
+    local marker_key = topic_id .. ":audited:" .. ARGV[i + 1]
+    local ip_counter_key = ARGV[i + 2]
+    local win_id = tonumber(ARGV[i + 3])
+    local lose_id = tonumber(ARGV[i + 4])
+
+    local audited = redis.call("GET", marker_key)
+    if audited then
+        table.insert(results, tonumber(audited))
+        table.insert(results, 0)
     else
-        multiplier = low_multiplier
+        local current = redis.call("INCR", ip_counter_key)
+        redis.call("EXPIRE", ip_counter_key, expire_seconds)
+
+        local multiplier
+        if max_ip_limit < 0 or current <= max_ip_limit then
+            multiplier = base_multiplier
+        else
+            multiplier = low_multiplier
+        end
+        redis.call("SET", marker_key, multiplier, "EX", marker_expire_seconds)
+
+        local op_stats_key = topic_id .. ":op_stats"
+        local op_matrix_key = topic_id .. ":op_matrix"
+
+        redis.call("HINCRBY", op_stats_key, win_id..":win", multiplier)
+        redis.call("HINCRBY", op_stats_key, lose_id..":lose", multiplier)
+        redis.call("HINCRBY", op_matrix_key, win_id..":"..lose_id, multiplier)
+        redis.call("HINCRBY", op_matrix_key, lose_id..":"..win_id, -multiplier)
+        redis.call("INCR", topic_id .. ":valid_ballots_count")
+
+        table.insert(results, multiplier)
+        table.insert(results, 1)
     end
-    
-    results[i] = multiplier
 end
 
 return results
 "#;
 
-pub const LUA_SCRIPT_BATCH_SCORE_UPDATE_SCRIPT: &str = r#"
--- KEYS: empty (we use ARGV for dynamic key generation)
--- ARGV: topic_id1, win_id1, lose_id1, multiplier1, topic_id2, win_id2, lose_id2, multiplier2, ...
--- Each score update takes 4 arguments: topic_id, win_id, lose_id, multiplier
-
--- local valid_ballots_count = KEYS[1]
-local arg_count = #ARGV
-
--- 确保参数数量是4的倍数
-if arg_count % 4 ~= 0 then
-    return redis.error_reply("invalid argument count: must be multiple of 4")
-end
-
-for i = 1, arg_count, 4 do
-    local topic_id = ARGV[i]
-    local win_id = tonumber(ARGV[i + 1])
-    local lose_id = tonumber(ARGV[i + 2])
-    local multiplier = tonumber(ARGV[i + 3])
-
-    local op_stats_key = topic_id .. ":op_stats"
-    local op_matrix_key = topic_id .. ":op_matrix"
-
-    redis.call("HINCRBY", op_stats_key, win_id..":win", multiplier)
-    redis.call("HINCRBY", op_stats_key, lose_id..":lose", multiplier)
-    redis.call("HINCRBY", op_matrix_key, win_id..":"..lose_id, multiplier)
-    redis.call("HINCRBY", op_matrix_key, lose_id..":"..win_id, -multiplier)
-
-    local valid_ballots_key = topic_id .. ":valid_ballots_count"
-    redis.call("INCR", valid_ballots_key)
-end
-
-return 1
-"#;
-
 pub const LUA_SCRIPT_GET_DEL_MANY: &str = r#"
 local results = {}
 for i, key in ipairs(KEYS) do
@@ -1,12 +1,13 @@
 use std::{borrow::Cow, sync::Arc};
 
 use futures::StreamExt as _;
-use share::{config::AppConfig, models::api::BallotSkipRequest};
+use share::{models::api::BallotSkipRequest, reload::ConfigWatch, signal::DrainGuard};
 
 use crate::{
     AppDatabase,
     constants::{CONSUMER_BATCH_SIZE, CONSUMER_RETRY_DELAY},
     error::AppError,
+    script::CachedScript,
 };
 
 use super::normalize_subject;
@@ -15,7 +16,8 @@ pub async fn ballot_skip_consumer(
     filter_subject: Cow<'static, str>,
     stream: async_nats::jetstream::stream::Stream,
     database: Arc<AppDatabase>,
-    _app_config: Arc<AppConfig>,
+    _app_config: ConfigWatch,
+    drain: DrainGuard,
 ) -> Result<(), AppError> {
     let normalized_subject = normalize_subject(&filter_subject);
     let process_name = format!("{normalized_subject}-consumer");
@@ -34,23 +36,21 @@ pub async fn ballot_skip_consumer(
         .get_multiplexed_async_connection()
         .await?;
 
-    std::thread::Builder::new()
-        .name(process_name.to_string())
-        .spawn(move || {
-            let runtime = tokio::runtime::Builder::new_multi_thread()
-                .enable_all()
-                .thread_name_fn(move || {
-                    static ATOMIC_ID: std::sync::atomic::AtomicUsize =
-                        std::sync::atomic::AtomicUsize::new(0);
-                    let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
-                    format!("{process_name}-{id}")
-                })
-                .build()
-                .unwrap();
-
-            runtime.block_on(async {
+    share::task::spawn_thread(process_name.clone(), move || {
+        let runtime = tokio::runtime::Builder::new_multi_thread()
+            .enable_all()
+            .thread_name_fn(move || {
+                static ATOMIC_ID: std::sync::atomic::AtomicUsize =
+                    std::sync::atomic::AtomicUsize::new(0);
+                let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+                format!("{process_name}-{id}")
+            })
+            .build()
+            .unwrap();
+
+        runtime.block_on(async {
                 tokio::select! {
-                    res = process_ballot_skip(&consumer, &mut conn, &database.redis.del_multiple_script) => {
+                    res = process_ballot_skip(&consumer, &mut conn, &database.redis.del_multiple_script, &drain) => {
                         if let Err(e) = res {
                             tracing::error!("error in process_ballot_skip: {}", e);
                             tokio::time::sleep(CONSUMER_RETRY_DELAY).await;
@@ -58,7 +58,7 @@ pub async fn ballot_skip_consumer(
                     },
                 }
             });
-        })?;
+    })?;
 
     Ok(())
 }
@@ -68,12 +68,19 @@ async fn process_ballot_skip(
         async_nats::jetstream::consumer::pull::Config,
     >,
     conn: &mut redis::aio::MultiplexedConnection,
-    del_multiple_script: &redis::Script,
+    del_multiple_script: &CachedScript,
+    drain: &DrainGuard,
 ) -> Result<(), AppError> {
     let mut count = 0;
     let mut batch_messages = Vec::with_capacity(CONSUMER_BATCH_SIZE);
 
     loop {
+        // the previous batch is flushed and acked, nothing is lost by stopping
+        if drain.is_shutting_down() {
+            tracing::info!("ballot skip consumer drained");
+            return Ok(());
+        }
+
         let mut messages = consumer
             .fetch()
             .max_messages(CONSUMER_BATCH_SIZE)
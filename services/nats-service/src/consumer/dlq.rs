@@ -3,7 +3,7 @@ use std::{borrow::Cow, sync::Arc};
 use base64::{Engine as _, engine::general_purpose};
 use futures::StreamExt as _;
 use serde::{Deserialize, Serialize};
-use share::config::AppConfig;
+use share::{reload::ConfigWatch, signal::DrainGuard};
 
 use crate::{
     constants::{CONSUMER_BATCH_SIZE, CONSUMER_RETRY_DELAY},
@@ -27,7 +27,8 @@ pub async fn dlq_consumer(
     filter_subject: Cow<'_, str>,
     stream: async_nats::jetstream::stream::Stream,
     database: Arc<AppDatabase>,
-    _app_config: Arc<AppConfig>,
+    _app_config: ConfigWatch,
+    drain: DrainGuard,
 ) -> Result<(), AppError> {
     let normalized_subject = normalize_subject(&filter_subject);
     let process_name = format!("{normalized_subject}-consumer");
@@ -40,31 +41,29 @@ pub async fn dlq_consumer(
         })
         .await?;
 
-    std::thread::Builder::new()
-        .name(process_name.to_string())
-        .spawn(move || {
-            let runtime = tokio::runtime::Builder::new_multi_thread()
-                .enable_all()
-                .thread_name_fn(move || {
-                    static ATOMIC_ID: std::sync::atomic::AtomicUsize =
-                        std::sync::atomic::AtomicUsize::new(0);
-                    let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
-                    format!("{process_name}-{id}")
-                })
-                .build()
-                .unwrap();
-
-            runtime.block_on(async {
-                tokio::select! {
-                    res = process_dead_letter_queue(&consumer, &database) => {
-                        if let Err(e) = res {
-                            tracing::error!("error in process_dead_letter_queue: {}", e);
-                            tokio::time::sleep(CONSUMER_RETRY_DELAY).await;
-                        }
-                    },
-                }
-            });
-        })?;
+    share::task::spawn_thread(process_name.clone(), move || {
+        let runtime = tokio::runtime::Builder::new_multi_thread()
+            .enable_all()
+            .thread_name_fn(move || {
+                static ATOMIC_ID: std::sync::atomic::AtomicUsize =
+                    std::sync::atomic::AtomicUsize::new(0);
+                let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+                format!("{process_name}-{id}")
+            })
+            .build()
+            .unwrap();
+
+        runtime.block_on(async {
+            tokio::select! {
+                res = process_dead_letter_queue(&consumer, &database, &drain) => {
+                    if let Err(e) = res {
+                        tracing::error!("error in process_dead_letter_queue: {}", e);
+                        tokio::time::sleep(CONSUMER_RETRY_DELAY).await;
+                    }
+                },
+            }
+        });
+    })?;
 
     Ok(())
 }
@@ -74,10 +73,16 @@ async fn process_dead_letter_queue(
         async_nats::jetstream::consumer::pull::Config,
     >,
     database: &AppDatabase,
+    drain: &DrainGuard,
 ) -> Result<(), AppError> {
     let mut messages_groups = Vec::with_capacity(CONSUMER_BATCH_SIZE);
 
     loop {
+        if drain.is_shutting_down() {
+            tracing::info!("dlq consumer drained");
+            return Ok(());
+        }
+
         let mut messages = consumer.fetch().max_messages(10).messages().await?;
 
         while let Some(message) = messages.next().await {
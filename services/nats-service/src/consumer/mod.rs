@@ -1,13 +1,16 @@
 mod ballot_skip;
 mod dlq;
 mod save_score;
+mod vote_audit;
 
 use std::{borrow::Cow, collections::HashMap, pin::Pin, sync::Arc};
 
 use ballot_skip::ballot_skip_consumer;
 use dlq::dlq_consumer;
 use save_score::save_score_consumer;
-use share::config::AppConfig;
+use share::{reload::ConfigWatch, signal::DrainGuard};
+pub use vote_audit::requeue_pending_ballots;
+use vote_audit::vote_audit_consumer;
 
 use crate::db::AppDatabase;
 
@@ -20,7 +23,8 @@ type ConsumerStarter =
         filter_subject: Cow<'static, str>,
         stream: async_nats::jetstream::stream::Stream,
         database: Arc<AppDatabase>,
-        app_config: Arc<AppConfig>,
+        app_config: ConfigWatch,
+        drain: DrainGuard,
     ) -> Pin<Box<dyn futures::Future<Output = eyre::Result<()>> + Send + 'static>>;
 
 #[derive(Debug)]
@@ -36,9 +40,9 @@ pub fn available_consumers() -> HashMap<&'static str, ConsumerConfig> {
                 $name,
                 ConsumerConfig {
                     name: $name,
-                    starter: |subject, stream, db, vote_config| {
+                    starter: |subject, stream, db, vote_config, drain| {
                         Box::pin(async move {
-                            if let Err(e) = $func(subject, stream, db, vote_config).await {
+                            if let Err(e) = $func(subject, stream, db, vote_config, drain).await {
                                 tracing::error!("{} failed: {}", $name, e);
                             }
                             Ok(())
@@ -52,6 +56,7 @@ pub fn available_consumers() -> HashMap<&'static str, ConsumerConfig> {
     HashMap::from([
         consumer!("ballot_skip", ballot_skip_consumer),
         consumer!("save_score", save_score_consumer),
+        consumer!("vote_audit", vote_audit_consumer),
         consumer!("dlq", dlq_consumer),
     ])
 }
@@ -1,24 +1,25 @@
-use std::{
-    borrow::Cow,
-    collections::{HashMap, HashSet},
-    sync::Arc,
-};
+use std::{borrow::Cow, collections::HashMap, sync::Arc};
 
 use base64::{Engine as _, engine::general_purpose};
 use futures::StreamExt as _;
 use redis::AsyncCommands as _;
 use share::{
-    config::{AppConfig, VoteConfig},
+    config::{AppConfig, BallotFlushConfig},
+    events::{DomainEvent, DomainEventKind},
     models::database::{
         Ballot, GroupwiseBallot, PairwiseBallot, PluralityBallot, SetwiseBallot, StoredBallot,
+        VoteAudit,
     },
+    reload::ConfigWatch,
+    signal::DrainGuard,
 };
 
 use crate::{
     AppDatabase,
     constants::{CONSUMER_BATCH_SIZE, CONSUMER_RETRY_DELAY, DLQ_MAX_RETRIES, DLQ_RETRY_DELAY},
-    consumer::dlq::DeadLetterMessage,
+    consumer::{dlq::DeadLetterMessage, vote_audit::request_audit},
     error::AppError,
+    script::CachedScript,
 };
 
 use super::normalize_subject;
@@ -33,7 +34,8 @@ pub async fn save_score_consumer(
     filter_subject: Cow<'static, str>,
     stream: async_nats::jetstream::stream::Stream,
     database: Arc<AppDatabase>,
-    app_config: Arc<AppConfig>,
+    app_config: ConfigWatch,
+    drain: DrainGuard,
 ) -> Result<(), AppError> {
     let normalized_subject = normalize_subject(&filter_subject);
     let process_name = format!("{normalized_subject}-consumer");
@@ -52,23 +54,21 @@ pub async fn save_score_consumer(
         .get_multiplexed_async_connection()
         .await?;
 
-    std::thread::Builder::new()
-        .name(process_name.to_string())
-        .spawn(move || {
-            let runtime = tokio::runtime::Builder::new_multi_thread()
-                .enable_all()
-                .thread_name_fn(move || {
-                    static ATOMIC_ID: std::sync::atomic::AtomicUsize =
-                        std::sync::atomic::AtomicUsize::new(0);
-                    let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
-                    format!("{process_name}-{id}")
-                })
-                .build()
-                .unwrap();
-
-            runtime.block_on(async {
+    share::task::spawn_thread(process_name.clone(), move || {
+        let runtime = tokio::runtime::Builder::new_multi_thread()
+            .enable_all()
+            .thread_name_fn(move || {
+                static ATOMIC_ID: std::sync::atomic::AtomicUsize =
+                    std::sync::atomic::AtomicUsize::new(0);
+                let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+                format!("{process_name}-{id}")
+            })
+            .build()
+            .unwrap();
+
+        runtime.block_on(async {
                 tokio::select! {
-                    res = process_save_score_messages(&consumer, &mut conn, &database, &app_config) => {
+                    res = process_save_score_messages(&consumer, &mut conn, &database, &app_config, &drain) => {
                         if let Err(e) = res {
                             tracing::error!("error in process_save_score_messages: {}", e);
                             tokio::time::sleep(CONSUMER_RETRY_DELAY).await;
@@ -76,7 +76,7 @@ pub async fn save_score_consumer(
                     },
                 }
             });
-        })?;
+    })?;
 
     Ok(())
 }
@@ -185,12 +185,19 @@ async fn process_save_score_messages(
     >,
     conn: &mut redis::aio::MultiplexedConnection,
     database: &AppDatabase,
-    app_config: &AppConfig,
+    config: &ConfigWatch,
+    drain: &DrainGuard,
 ) -> Result<(), AppError> {
     let mut count = 0;
     let mut ballot_groups = BallotMessageGroup::with_capacity(CONSUMER_BATCH_SIZE);
 
     loop {
+        // the previous batch is flushed and acked, nothing is lost by stopping
+        if drain.is_shutting_down() {
+            tracing::info!("save score consumer drained");
+            return Ok(());
+        }
+
         let mut messages = consumer
             .fetch()
             .max_messages(CONSUMER_BATCH_SIZE)
@@ -221,6 +228,9 @@ async fn process_save_score_messages(
         }
 
         let (pairwise, setwise, groupwise, plurality) = ballot_groups.take_all();
+        // once per batch, so a reload applies from the next batch on
+        let current = config.current();
+        let app_config = current.as_ref();
 
         if !setwise.is_empty() {
             let result = process_setwise_ballot_batch(&setwise, conn, database, app_config).await;
@@ -271,9 +281,7 @@ async fn process_save_score_messages(
             Err(e) => {
                 tracing::error!("batch processing failed: {}", e);
                 for msg in pairwise.iter() {
-                    if let Err(e) =
-                        process_single_pairwise_fallback(msg, conn, database, app_config).await
-                    {
+                    if let Err(e) = process_single_pairwise_fallback(msg, conn, database).await {
                         tracing::error!("fallback processing failed: {}", e);
                     }
                 }
@@ -282,6 +290,39 @@ async fn process_save_score_messages(
     }
 }
 
+/// Writes a batch with unordered bulk inserts. The server does not stop or
+/// serialise on individual documents of an unordered insert, and the chunks
+/// of all topics in the batch are in flight together, which keeps up with
+/// the ballot rate when a popular topic opens.
+#[tracing::instrument(
+    name = "mongo.insert_ballots",
+    skip_all,
+    fields(otel.kind = "client", topics = grouped_ballots.len()),
+)]
+async fn flush_ballots(
+    database: &AppDatabase,
+    grouped_ballots: &HashMap<String, Vec<StoredBallot>>,
+    config: &BallotFlushConfig,
+) -> Result<(), AppError> {
+    let chunk_size = config.chunk_size.max(1);
+    let inserts = grouped_ballots.iter().flat_map(|(topic_id, ballots)| {
+        let collection = database
+            .mongo_database
+            .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
+        ballots.chunks(chunk_size).map(move |chunk| {
+            let collection = collection.clone();
+            async move { collection.insert_many(chunk).ordered(false).await }
+        })
+    });
+
+    let mut results = futures::stream::iter(inserts).buffer_unordered(config.concurrency.max(1));
+    while let Some(result) = results.next().await {
+        result?;
+    }
+
+    Ok(())
+}
+
 async fn process_pairwise_ballot_batch(
     ballots: &[PairwiseBallotItem<'_>],
     conn: &mut redis::aio::MultiplexedConnection,
@@ -292,7 +333,6 @@ async fn process_pairwise_ballot_batch(
         return Ok(BatchProcessResult::default());
     }
 
-    let vote_config = &app_config.vote;
     let mut failed_messages = Vec::new();
     let mut ignored_messages = Vec::new();
 
@@ -300,18 +340,9 @@ async fn process_pairwise_ballot_batch(
     let validation_results =
         validate_pairwise_ballots(ballots, &database.redis.get_del_many_script, conn).await?;
 
-    // 第二步：批量计算IP倍数
-    let ip_multipliers = calculate_pairwise_multipliers(
-        ballots,
-        vote_config,
-        &database.redis.batch_ip_counter_script,
-        conn,
-    )
-    .await?;
-
-    // 第三步：过滤有效的ballot并准备批量操作
+    // 第二步：过滤有效的ballot
     let mut valid_ballots = Vec::new();
-    let mut score_updates = HashMap::new(); // (win_id, lose_id) -> total_multiplier
+    let mut events = Vec::new();
 
     for item in ballots.iter() {
         // 验证ballot code
@@ -324,6 +355,10 @@ async fn process_pairwise_ballot_batch(
                         failed_messages.push(item.message.clone());
                         continue;
                     }
+                    // a retried ballot has its code consumed already, it was reported before
+                    if !is_retry(&item.message) {
+                        events.push(rejected_event(&item.ballot, "invalid_ballot_code"));
+                    }
                     ignored_messages.push(item.message.clone());
                     continue;
                 }
@@ -349,48 +384,26 @@ async fn process_pairwise_ballot_batch(
                 item.ballot.lose,
                 item.ballot.info.ballot_id
             );
+            events.push(rejected_event(&item.ballot, "invalid_participants"));
             failed_messages.push(item.message.clone());
             continue;
         }
 
-        let multiplier = ip_multipliers
-            .get(item.ballot.info.ip.as_ref())
-            .copied()
-            .unwrap_or(vote_config.low_multiplier);
-
-        *score_updates
-            .entry((
-                item.ballot.info.topic_id.to_string(),
-                item.ballot.win,
-                item.ballot.lose,
-            ))
-            .or_insert(0) += multiplier;
-
         valid_ballots.push(item);
     }
 
-    // 第四步：批量执行分数更新
-    batch_update_scores(
-        score_updates,
-        &database.redis.batch_score_update_script,
-        conn,
-    )
-    .await?;
+    database.publish_events(events).await;
 
-    // 第五步：批量插入MongoDB
+    // 第三步：批量插入MongoDB，等待审核
     // 先按照topic_id分组
     let mut grouped_ballots: HashMap<String, Vec<StoredBallot>> = HashMap::new();
 
     for item in valid_ballots.iter() {
         let topic_id = item.ballot.info.topic_id.to_string();
-        let multiplier = ip_multipliers
-            .get(item.ballot.info.ip.as_ref())
-            .copied()
-            .unwrap_or(vote_config.low_multiplier);
-
         let stored_ballot = StoredBallot {
             ballot: Ballot::Pairwise(item.ballot.clone()),
-            multiplier,
+            multiplier: 0,
+            audit: VoteAudit::Pending,
         };
 
         grouped_ballots
@@ -399,22 +412,23 @@ async fn process_pairwise_ballot_batch(
             .push(stored_ballot);
     }
 
-    for (topic_id, ballots) in grouped_ballots.into_iter() {
-        let ballot_collection = database
-            .mongo_database
-            .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
+    flush_ballots(database, &grouped_ballots, &app_config.ballot_flush).await?;
 
-        ballot_collection.insert_many(&ballots).await?;
+    // 第四步：交给 vote_audit 评分计票，失败的由 requeue_pending_ballots 补发
+    for msg in valid_ballots.iter() {
+        if let Err(e) = request_audit(&database.jetstream, &msg.message.payload).await {
+            tracing::error!("failed to request the audit of a ballot: {}", e);
+        }
     }
 
-    // 第六步：确认所有成功处理的消息
+    // 第五步：确认所有成功处理的消息
     for msg in valid_ballots.iter() {
         if let Err(e) = msg.message.double_ack().await {
             tracing::error!("failed to double_ack successful message: {}", e);
         }
     }
 
-    // 第七步：确认所有需要丢掉的消息
+    // 第六步：确认所有需要丢掉的消息
     for msg in ignored_messages.iter() {
         if let Err(e) = msg.double_ack().await {
             tracing::error!("failed to double_ack ignored message: {}", e);
@@ -427,9 +441,24 @@ async fn process_pairwise_ballot_batch(
     })
 }
 
+fn is_retry(message: &async_nats::jetstream::Message) -> bool {
+    message
+        .headers
+        .as_ref()
+        .is_some_and(|headers| headers.get("X-Retry-Count").is_some())
+}
+
+fn rejected_event(ballot: &PairwiseBallot<'_>, reason: &str) -> DomainEvent {
+    DomainEvent::new(DomainEventKind::VoteRejected {
+        topic_id: ballot.info.topic_id.to_string(),
+        ballot_id: ballot.info.ballot_id.to_string(),
+        reason: reason.to_string(),
+    })
+}
+
 async fn validate_pairwise_ballots(
     ballots: &[PairwiseBallotItem<'_>],
-    get_del_many_script: &redis::Script,
+    get_del_many_script: &CachedScript,
     conn: &mut redis::aio::MultiplexedConnection,
 ) -> Result<HashMap<String, Result<(i32, i32), AppError>>, AppError> {
     if ballots.is_empty() {
@@ -485,78 +514,11 @@ async fn validate_pairwise_ballots(
     Ok(results)
 }
 
-async fn calculate_pairwise_multipliers(
-    ballots: &[PairwiseBallotItem<'_>],
-    vote_config: &VoteConfig,
-    batch_ip_counter_script: &redis::Script,
-    conn: &mut redis::aio::MultiplexedConnection,
-) -> Result<HashMap<String, i32>, AppError> {
-    if ballots.is_empty() {
-        return Ok(HashMap::new());
-    }
-
-    let mut results = HashMap::new();
-
-    let ips: HashSet<&str> = ballots.iter().map(|b| b.ballot.info.ip.as_ref()).collect();
-
-    let keys: Vec<String> = ballots
-        .iter()
-        .map(|b| {
-            let info = &b.ballot.info;
-            format!("{}:ip_counter:{}", info.topic_id.as_ref(), info.ip.as_ref())
-        })
-        .collect();
-
-    let ips_vec: Vec<&str> = ips.into_iter().collect();
-
-    let script_results: Vec<i32> = batch_ip_counter_script
-        .key(&keys)
-        .arg(vote_config.ip_counter_expire_seconds)
-        .arg(vote_config.max_ip_limit)
-        .arg(vote_config.base_multiplier)
-        .arg(vote_config.low_multiplier)
-        .invoke_async(conn)
-        .await?;
-
-    for (ip, multiplier) in ips_vec.into_iter().zip(script_results.into_iter()) {
-        results.insert(ip.to_string(), multiplier);
-    }
-
-    Ok(results)
-}
-
-async fn batch_update_scores(
-    updates: HashMap<(String, i32, i32), i32>, // ((topic_id, win_id, lose_id), total_multiplier)
-    batch_score_update_script: &redis::Script,
-    conn: &mut redis::aio::MultiplexedConnection,
-) -> Result<(), AppError> {
-    if updates.is_empty() {
-        return Ok(());
-    }
-
-    // 准备参数：topic_id1, win_id1, lose_id1, multiplier1, topic_id2, win_id2, lose_id2, multiplier2, ...
-    let mut args = Vec::with_capacity(updates.len() * 4);
-    for ((topic_id, win_id, lose_id), multiplier) in updates {
-        args.push(topic_id);
-        args.push(win_id.to_string());
-        args.push(lose_id.to_string());
-        args.push(multiplier.to_string());
-    }
-
-    // 执行批量分数更新脚本
-    let _results: () = batch_score_update_script
-        .arg(&args)
-        .invoke_async(conn)
-        .await?;
-
-    Ok(())
-}
-
 async fn process_setwise_ballot_batch(
     ballots: &[SetwiseBallotItem<'_>],
     _conn: &mut redis::aio::MultiplexedConnection,
     database: &AppDatabase,
-    _app_config: &AppConfig,
+    app_config: &AppConfig,
 ) -> Result<BatchProcessResult, AppError> {
     tracing::debug!("Processing setwise ballot batch, but this feature is not implemented yet.");
 
@@ -567,6 +529,7 @@ async fn process_setwise_ballot_batch(
         let stored_ballot = StoredBallot {
             ballot: Ballot::Setwise(item.ballot.clone()),
             multiplier: 1, // Placeholder multiplier, adjust as needed
+            audit: VoteAudit::Pending,
         };
 
         grouped_ballots
@@ -575,13 +538,7 @@ async fn process_setwise_ballot_batch(
             .push(stored_ballot);
     }
 
-    for (topic_id, ballots) in grouped_ballots.into_iter() {
-        let ballot_collection = database
-            .mongo_database
-            .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
-
-        ballot_collection.insert_many(&ballots).await?;
-    }
+    flush_ballots(database, &grouped_ballots, &app_config.ballot_flush).await?;
 
     tracing::debug!(
         "Processed {} setwise ballots, but no score updates were made.",
@@ -598,7 +555,7 @@ async fn process_groupwise_ballot_batch(
     ballots: &[GroupwiseBallotItem<'_>],
     _conn: &mut redis::aio::MultiplexedConnection,
     database: &AppDatabase,
-    _app_config: &AppConfig,
+    app_config: &AppConfig,
 ) -> Result<BatchProcessResult, AppError> {
     tracing::debug!("Processing groupwise ballot batch, but this feature is not implemented yet.");
 
@@ -609,6 +566,7 @@ async fn process_groupwise_ballot_batch(
         let stored_ballot = StoredBallot {
             ballot: Ballot::Groupwise(item.ballot.clone()),
             multiplier: 1, // Placeholder multiplier, adjust as needed
+            audit: VoteAudit::Pending,
         };
 
         grouped_ballots
@@ -617,13 +575,7 @@ async fn process_groupwise_ballot_batch(
             .push(stored_ballot);
     }
 
-    for (topic_id, ballots) in grouped_ballots.into_iter() {
-        let ballot_collection = database
-            .mongo_database
-            .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
-
-        ballot_collection.insert_many(&ballots).await?;
-    }
+    flush_ballots(database, &grouped_ballots, &app_config.ballot_flush).await?;
 
     tracing::debug!(
         "Processed {} groupwise ballots, but no score updates were made.",
@@ -640,7 +592,7 @@ async fn process_plurality_ballot_batch(
     ballots: &[PluralityBallotItem<'_>],
     _conn: &mut redis::aio::MultiplexedConnection,
     database: &AppDatabase,
-    _app_config: &AppConfig,
+    app_config: &AppConfig,
 ) -> Result<BatchProcessResult, AppError> {
     tracing::debug!("Processing plurality ballot batch, but this feature is not implemented yet.");
 
@@ -651,6 +603,7 @@ async fn process_plurality_ballot_batch(
         let stored_ballot = StoredBallot {
             ballot: Ballot::Plurality(item.ballot.clone()),
             multiplier: 1, // Placeholder multiplier, adjust as needed
+            audit: VoteAudit::Pending,
         };
 
         grouped_ballots
@@ -659,13 +612,7 @@ async fn process_plurality_ballot_batch(
             .push(stored_ballot);
     }
 
-    for (topic_id, ballots) in grouped_ballots.into_iter() {
-        let ballot_collection = database
-            .mongo_database
-            .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
-
-        ballot_collection.insert_many(&ballots).await?;
-    }
+    flush_ballots(database, &grouped_ballots, &app_config.ballot_flush).await?;
 
     tracing::debug!(
         "Processed {} plurality ballots, but no score updates were made.",
@@ -683,9 +630,8 @@ async fn process_single_pairwise_fallback(
     msg: &PairwiseBallotItem<'_>,
     conn: &mut redis::aio::MultiplexedConnection,
     database: &AppDatabase,
-    app_config: &AppConfig,
 ) -> Result<(), AppError> {
-    match process_single_ballot(&msg.ballot, conn, database, app_config).await {
+    match process_single_ballot(&msg.ballot, &msg.message.payload, conn, database).await {
         Ok(_) => {
             msg.message.double_ack().await?;
         }
@@ -775,12 +721,10 @@ async fn handle_failed_messages(
 
 async fn process_single_ballot(
     ballot: &PairwiseBallot<'_>,
+    payload: &[u8],
     conn: &mut redis::aio::MultiplexedConnection,
     database: &AppDatabase,
-    app_config: &AppConfig,
 ) -> Result<(), AppError> {
-    let vote_config = &app_config.vote;
-
     let (ballot_left, ballot_right) = validate_ballot(ballot.info.ballot_id.as_ref(), conn).await?;
 
     let valid_ids = [ballot_left, ballot_right];
@@ -797,31 +741,22 @@ async fn process_single_ballot(
         return Err(AppError::InvalidParticipants);
     }
 
-    let multiplier = calculate_multiplier(
-        ballot.info.ip.as_ref(),
-        vote_config,
-        &database.redis.ip_counter_script,
-        conn,
-    )
-    .await?;
-
-    let _: () = database
-        .redis
-        .score_update_script
-        .arg(ballot.win)
-        .arg(ballot.lose)
-        .arg(multiplier)
-        .invoke_async(conn)
-        .await?;
-
-    let ballot = Ballot::Pairwise(ballot.clone());
-    let stored_ballot = StoredBallot { ballot, multiplier };
+    let topic_id = ballot.info.topic_id.to_string();
+    let stored_ballot = StoredBallot {
+        ballot: Ballot::Pairwise(ballot.clone()),
+        multiplier: 0,
+        audit: VoteAudit::Pending,
+    };
 
     let ballot_collection = database
         .mongo_database
-        .collection::<StoredBallot>("ballots");
+        .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
     ballot_collection.insert_one(&stored_ballot).await?;
 
+    if let Err(e) = request_audit(&database.jetstream, payload).await {
+        tracing::error!("failed to request the audit of a ballot: {}", e);
+    }
+
     Ok(())
 }
 
@@ -853,23 +788,3 @@ async fn validate_ballot(
         None => Err(AppError::InvalidBallotCode(code.to_string())),
     }
 }
-
-async fn calculate_multiplier(
-    ip: &str,
-    vote_config: &VoteConfig,
-    ip_counter_script: &redis::Script,
-    conn: &mut redis::aio::MultiplexedConnection,
-) -> Result<i32, AppError> {
-    let counter_key = format!("ip_counter:{ip}");
-
-    let multiplier: i32 = ip_counter_script
-        .key(&counter_key)
-        .arg(vote_config.ip_counter_expire_seconds)
-        .arg(vote_config.max_ip_limit)
-        .arg(vote_config.base_multiplier)
-        .arg(vote_config.low_multiplier)
-        .invoke_async(conn)
-        .await?;
-
-    Ok(multiplier)
-}
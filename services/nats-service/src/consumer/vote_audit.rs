@@ -0,0 +1,645 @@
+//! Second stage of a pairwise vote. `save_score` stores a ballot with a valid
+//! code as pending and hands it over here, so the fraud scoring is off the
+//! save path. The audit counts the ballot into the rankings and then sets
+//! the flag and the multiplier of the stored ballot, a ballot is counted
+//! once however often it is delivered.
+
+use std::{borrow::Cow, collections::HashMap, sync::Arc};
+
+use chrono::{DateTime, Utc};
+use futures::{StreamExt as _, TryStreamExt as _};
+use mongodb::{
+    ClientSession,
+    bson::{Document, doc, oid::ObjectId},
+};
+use redis::AsyncCommands as _;
+use share::{
+    client_ip::limit_key,
+    config::{AppConfig, VoteConfig},
+    events::{DomainEvent, DomainEventKind},
+    jobs::claim_slot,
+    models::{
+        database::{Ballot, OutboxEvent, PairwiseBallot, StoredBallot, VoteAudit},
+        live::{LiveScoreDelta, LiveScoreUpdate, live_subject},
+    },
+    reload::ConfigWatch,
+    signal::DrainGuard,
+};
+
+use crate::{
+    AppDatabase,
+    constants::{
+        AUDIT_MARKER_EXPIRE_SECONDS, AUDIT_STALE_AFTER, AUDIT_SWEEP_INTERVAL, CONSUMER_BATCH_SIZE,
+        CONSUMER_RETRY_DELAY,
+    },
+    error::AppError,
+    script::CachedScript,
+};
+
+use super::normalize_subject;
+
+/// `save_score` republishes the original ballot payload here.
+pub const VOTE_AUDIT_SUBJECT: &str = "ark-vote.vote_audit";
+
+/// Unix timestamp up to which stored ballots were swept for a lost audit
+/// request, kept in redis so a restart neither skips the ballots stored
+/// while no instance ran nor sweeps from scratch.
+const SWEPT_UNTIL_KEY: &str = "vote_audit:swept_until";
+
+pub async fn vote_audit_consumer(
+    filter_subject: Cow<'static, str>,
+    stream: async_nats::jetstream::stream::Stream,
+    database: Arc<AppDatabase>,
+    app_config: ConfigWatch,
+    drain: DrainGuard,
+) -> Result<(), AppError> {
+    let normalized_subject = normalize_subject(&filter_subject);
+    let process_name = format!("{normalized_subject}-consumer");
+
+    let consumer = stream
+        .create_consumer(async_nats::jetstream::consumer::pull::Config {
+            durable_name: Some(normalized_subject),
+            filter_subject: filter_subject.to_string(),
+            ..Default::default()
+        })
+        .await?;
+
+    let mut conn = database
+        .redis
+        .client
+        .get_multiplexed_async_connection()
+        .await?;
+
+    share::task::spawn_thread(process_name.clone(), move || {
+        let runtime = tokio::runtime::Builder::new_multi_thread()
+            .enable_all()
+            .thread_name_fn(move || {
+                static ATOMIC_ID: std::sync::atomic::AtomicUsize =
+                    std::sync::atomic::AtomicUsize::new(0);
+                let id = ATOMIC_ID.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+                format!("{process_name}-{id}")
+            })
+            .build()
+            .unwrap();
+
+        runtime.block_on(async {
+                tokio::select! {
+                    res = process_vote_audit_messages(&consumer, &mut conn, &database, &app_config, &drain) => {
+                        if let Err(e) = res {
+                            tracing::error!("error in process_vote_audit_messages: {}", e);
+                            tokio::time::sleep(CONSUMER_RETRY_DELAY).await;
+                        }
+                    },
+                }
+            });
+    })?;
+
+    Ok(())
+}
+
+/// Hands a stored ballot to the `vote_audit` consumer. A ballot which could
+/// not be handed over stays pending until [`requeue_pending_ballots`] finds
+/// it.
+pub(super) async fn request_audit(
+    jetstream: &async_nats::jetstream::Context,
+    payload: &[u8],
+) -> Result<(), AppError> {
+    jetstream
+        .publish(VOTE_AUDIT_SUBJECT, payload.to_vec().into())
+        .await?
+        .await?;
+    Ok(())
+}
+
+/// The first object id the driver could generate at `at`, the ballots get
+/// theirs on insert so they are ordered by storage time.
+fn boundary(at: DateTime<Utc>) -> ObjectId {
+    let mut bytes = [0; 12];
+    bytes[..4].copy_from_slice(&(at.timestamp().max(0) as u32).to_be_bytes());
+    ObjectId::from_bytes(bytes)
+}
+
+/// Hands the ballots still pending [`AUDIT_STALE_AFTER`] after they were
+/// stored to the audit again, run as a background job on every instance.
+/// One instance per [`AUDIT_SWEEP_INTERVAL`] claims the sweep, which goes on
+/// from where the last one stopped.
+pub async fn requeue_pending_ballots(database: Arc<AppDatabase>) {
+    let mut interval = tokio::time::interval(AUDIT_SWEEP_INTERVAL);
+    interval.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Delay);
+    loop {
+        interval.tick().await;
+        // the range is swept again, a ballot requeued twice is counted once
+        if let Err(e) = sweep_pending_ballots(&database, Utc::now()).await {
+            tracing::error!("failed to requeue pending ballots: {}", e);
+        }
+    }
+}
+
+async fn sweep_pending_ballots(database: &AppDatabase, now: DateTime<Utc>) -> Result<(), AppError> {
+    let mut conn = database
+        .redis
+        .client
+        .get_multiplexed_async_connection()
+        .await?;
+    let slot = sweep_slot(now);
+    if !claim_slot(
+        &mut conn,
+        "requeue_pending_ballots",
+        slot,
+        AUDIT_SWEEP_INTERVAL,
+    )
+    .await?
+    {
+        return Ok(());
+    }
+
+    let swept_until: Option<i64> = conn.get(SWEPT_UNTIL_KEY).await?;
+    let swept_until = swept_until.and_then(|at| DateTime::from_timestamp(at, 0));
+    let Some((from, until)) = sweep_range(swept_until, now) else {
+        return Ok(());
+    };
+
+    // the markers of these expired, one counted by an earlier audit which
+    // failed to store its result is counted a second time
+    let markers_since = audit_markers_since(now);
+    let unmarked = requeue_stored_between(database, from, until.min(markers_since)).await?;
+    if unmarked > 0 {
+        tracing::error!(
+            "requeued {} ballots pending since before the audit markers of the last {}s",
+            unmarked,
+            AUDIT_MARKER_EXPIRE_SECONDS
+        );
+    }
+    let requeued = requeue_stored_between(database, from.max(markers_since), until).await?;
+    if requeued > 0 {
+        tracing::warn!("requeued {} ballots without an audit", requeued);
+    }
+
+    let _: () = conn.set(SWEPT_UNTIL_KEY, until.timestamp()).await?;
+    Ok(())
+}
+
+/// Start of the [`AUDIT_SWEEP_INTERVAL`] `now` falls in, the slot one
+/// instance claims.
+fn sweep_slot(now: DateTime<Utc>) -> DateTime<Utc> {
+    let interval = AUDIT_SWEEP_INTERVAL.as_secs().max(1) as i64;
+    let start = now.timestamp() - now.timestamp().rem_euclid(interval);
+    DateTime::from_timestamp(start, 0).unwrap_or(now)
+}
+
+/// Ballots audited since then still have their audit marker.
+fn audit_markers_since(now: DateTime<Utc>) -> DateTime<Utc> {
+    now - chrono::Duration::seconds(AUDIT_MARKER_EXPIRE_SECONDS as i64)
+}
+
+/// Storage times the sweep at `now` looks at: from where the last one
+/// stopped, or from the oldest audit markers when none ran yet, up to
+/// [`AUDIT_STALE_AFTER`] ago. `None` when that is empty.
+fn sweep_range(
+    swept_until: Option<DateTime<Utc>>,
+    now: DateTime<Utc>,
+) -> Option<(DateTime<Utc>, DateTime<Utc>)> {
+    let from = swept_until.unwrap_or_else(|| audit_markers_since(now));
+    let until = now - chrono::Duration::from_std(AUDIT_STALE_AFTER).unwrap_or_default();
+    (from < until).then_some((from, until))
+}
+
+async fn requeue_stored_between(
+    database: &AppDatabase,
+    from: DateTime<Utc>,
+    until: DateTime<Utc>,
+) -> Result<usize, AppError> {
+    if from >= until {
+        return Ok(0);
+    }
+
+    let collections = database
+        .mongo_database
+        .list_collection_names()
+        .filter(doc! { "name": { "$regex": "^ballots_" } })
+        .await?;
+
+    let mut count = 0;
+    for collection in collections {
+        let mut pending = database
+            .mongo_database
+            .collection::<Document>(&collection)
+            .find(doc! {
+                "_id": { "$gte": boundary(from), "$lt": boundary(until) },
+                "audit": mongodb::bson::to_bson(&VoteAudit::Pending)?,
+            })
+            .await?;
+        while let Some(stored) = pending.try_next().await? {
+            let ballot: Ballot = mongodb::bson::from_document(stored)?;
+            request_audit(&database.jetstream, &serde_json::to_vec(&ballot)?).await?;
+            count += 1;
+        }
+    }
+
+    Ok(count)
+}
+
+struct AuditItem<'a> {
+    ballot: PairwiseBallot<'a>,
+    message: async_nats::jetstream::Message,
+}
+
+async fn process_vote_audit_messages(
+    consumer: &async_nats::jetstream::consumer::Consumer<
+        async_nats::jetstream::consumer::pull::Config,
+    >,
+    conn: &mut redis::aio::MultiplexedConnection,
+    database: &AppDatabase,
+    config: &ConfigWatch,
+    drain: &DrainGuard,
+) -> Result<(), AppError> {
+    let mut count = 0;
+    let mut items = Vec::with_capacity(CONSUMER_BATCH_SIZE);
+
+    loop {
+        // the previous batch is counted and acked, nothing is lost by stopping
+        if drain.is_shutting_down() {
+            tracing::info!("vote audit consumer drained");
+            return Ok(());
+        }
+
+        let mut messages = consumer
+            .fetch()
+            .max_messages(CONSUMER_BATCH_SIZE)
+            .messages()
+            .await?;
+
+        while let Some(message) = messages.next().await {
+            let message = match message {
+                Ok(message) => message,
+                Err(e) => {
+                    tracing::error!("error fetching vote audit message: {}", e);
+                    continue;
+                }
+            };
+            match serde_json::from_slice::<Ballot>(&message.payload) {
+                Ok(Ballot::Pairwise(ballot)) => items.push(AuditItem { ballot, message }),
+                Ok(_) | Err(_) => {
+                    tracing::warn!("vote audit message is not a pairwise ballot, acknowledging.");
+                    if let Err(e) = message.double_ack().await {
+                        tracing::error!("failed to double_ack invalid message: {}", e);
+                    }
+                }
+            }
+        }
+
+        if items.is_empty() {
+            tokio::time::sleep(std::time::Duration::from_millis(100)).await;
+            continue;
+        }
+
+        let current = config.current();
+        // unacked messages are redelivered, ballots counted by the failed attempt
+        // are not counted again
+        match audit_pairwise_batch(&items, conn, database, current.as_ref()).await {
+            Ok(()) => {
+                count += items.len();
+                tracing::debug!("audited {} votes", count);
+            }
+            Err(e) => tracing::error!("failed to audit {} votes: {}", items.len(), e),
+        }
+        items.clear();
+    }
+}
+
+/// Safe to run again on a redelivered batch: the audit script remembers the
+/// multiplier of every ballot it counted, so only the ballots new to it move
+/// the ip counters, the rankings and the live feed. The stored audits and the
+/// events are written for the whole batch, the earlier attempt may have
+/// failed before them.
+async fn audit_pairwise_batch(
+    items: &[AuditItem<'_>],
+    conn: &mut redis::aio::MultiplexedConnection,
+    database: &AppDatabase,
+    app_config: &AppConfig,
+) -> Result<(), AppError> {
+    let vote_config = &app_config.vote;
+
+    // 第一步：按IP计算倍数并计分，排名只统计审核过的票
+    let audited =
+        audit_and_count(items, vote_config, &database.redis.batch_audit_script, conn).await?;
+
+    // 第二步：汇总新计分的票与审核结果
+    let mut score_updates = HashMap::new(); // (topic_id, win_id, lose_id) -> total_multiplier
+    let mut audits: HashMap<(&str, VoteAudit, i32), Vec<&str>> = HashMap::new();
+    let mut events = Vec::with_capacity(items.len());
+    for (item, &(multiplier, counted)) in items.iter().zip(&audited) {
+        let info = &item.ballot.info;
+        if counted {
+            *score_updates
+                .entry((info.topic_id.to_string(), item.ballot.win, item.ballot.lose))
+                .or_insert(0) += multiplier;
+        }
+
+        let audit = VoteAudit::from_multiplier(multiplier, vote_config.base_multiplier);
+        audits
+            .entry((info.topic_id.as_ref(), audit, multiplier))
+            .or_default()
+            .push(info.ballot_id.as_ref());
+
+        events.push(DomainEvent::new(DomainEventKind::VoteAccepted {
+            topic_id: info.topic_id.to_string(),
+            ballot_id: info.ballot_id.to_string(),
+            win: item.ballot.win,
+            lose: item.ballot.lose,
+            multiplier,
+        }));
+    }
+
+    let live_updates = collect_live_updates(&score_updates, items, &audited);
+    publish_live_updates(&database.nats, live_updates).await;
+
+    // 第三步：回写审核结果，启用 outbox 时事件与其在同一事务中写入
+    match &database.outbox {
+        Some(outbox) => {
+            let mut session = database.mongo_database.client().start_session().await?;
+            session.start_transaction().await?;
+            write_audits(database, audits, Some(&mut session)).await?;
+            outbox
+                .insert_many(events.into_iter().map(OutboxEvent::new))
+                .session(&mut session)
+                .await?;
+            session.commit_transaction().await?;
+        }
+        None => {
+            write_audits(database, audits, None).await?;
+            database.events.publish_all(events).await;
+        }
+    }
+
+    // 第四步：确认消息
+    for item in items {
+        if let Err(e) = item.message.double_ack().await {
+            tracing::error!("failed to double_ack audited message: {}", e);
+        }
+    }
+
+    Ok(())
+}
+
+/// Only pending ballots are written, one audited before keeps its result.
+async fn write_audits(
+    database: &AppDatabase,
+    audits: HashMap<(&str, VoteAudit, i32), Vec<&str>>,
+    mut session: Option<&mut ClientSession>,
+) -> Result<(), AppError> {
+    for ((topic_id, audit, multiplier), ballot_ids) in audits {
+        let ballots = database
+            .mongo_database
+            .collection::<StoredBallot>(&format!("ballots_{}", topic_id));
+        let mut update = ballots.update_many(
+            doc! {
+                "info.ballot_id": { "$in": ballot_ids },
+                "audit": mongodb::bson::to_bson(&VoteAudit::Pending)?,
+            },
+            doc! { "$set": {
+                "audit": mongodb::bson::to_bson(&audit)?,
+                "multiplier": multiplier,
+            } },
+        );
+        if let Some(session) = session.as_deref_mut() {
+            update = update.session(session);
+        }
+        update.await?;
+    }
+
+    Ok(())
+}
+
+/// The multiplier of every ballot in the order of `items`, and whether this
+/// call counted it. A ballot counted before keeps its multiplier.
+async fn audit_and_count(
+    items: &[AuditItem<'_>],
+    vote_config: &VoteConfig,
+    batch_audit_script: &CachedScript,
+    conn: &mut redis::aio::MultiplexedConnection,
+) -> Result<Vec<(i32, bool)>, AppError> {
+    // 准备参数：topic_id1, ballot_id1, ip_counter_key1, win_id1, lose_id1, ...
+    let mut args = Vec::with_capacity(items.len() * 5);
+    for item in items {
+        let info = &item.ballot.info;
+        args.push(info.topic_id.to_string());
+        args.push(info.ballot_id.to_string());
+        args.push(format!(
+            "{}:ip_counter:{}",
+            info.topic_id.as_ref(),
+            limit_key(&info.ip, vote_config.ipv6_prefix_len)
+        ));
+        args.push(item.ballot.win.to_string());
+        args.push(item.ballot.lose.to_string());
+    }
+
+    let results: Vec<i32> = batch_audit_script
+        .arg(vote_config.ip_counter_expire_seconds)
+        .arg(vote_config.max_ip_limit)
+        .arg(vote_config.base_multiplier)
+        .arg(vote_config.low_multiplier)
+        .arg(AUDIT_MARKER_EXPIRE_SECONDS)
+        .arg(&args)
+        .invoke_async(conn)
+        .await?;
+
+    Ok(results
+        .chunks_exact(2)
+        .map(|result| (result[0], result[1] == 1))
+        .collect())
+}
+
+fn collect_live_updates(
+    score_updates: &HashMap<(String, i32, i32), i32>,
+    items: &[AuditItem<'_>],
+    audited: &[(i32, bool)],
+) -> HashMap<String, LiveScoreUpdate> {
+    let mut live_updates: HashMap<String, LiveScoreUpdate> = HashMap::new();
+
+    for ((topic_id, win, lose), multiplier) in score_updates {
+        live_updates
+            .entry(topic_id.clone())
+            .or_insert_with(|| LiveScoreUpdate {
+                topic_id: topic_id.clone(),
+                deltas: Vec::new(),
+                ballots: 0,
+            })
+            .deltas
+            .push(LiveScoreDelta {
+                win: *win,
+                lose: *lose,
+                multiplier: *multiplier,
+            });
+    }
+
+    for (item, _) in items
+        .iter()
+        .zip(audited)
+        .filter(|(_, (_, counted))| *counted)
+    {
+        if let Some(update) = live_updates.get_mut(item.ballot.info.topic_id.as_ref()) {
+            update.ballots += 1;
+        }
+    }
+
+    live_updates
+}
+
+// live updates are best effort, a dropped message only delays the live feed
+async fn publish_live_updates(
+    nats: &async_nats::Client,
+    live_updates: HashMap<String, LiveScoreUpdate>,
+) {
+    for (topic_id, update) in live_updates {
+        let payload = match serde_json::to_vec(&update) {
+            Ok(payload) => payload,
+            Err(e) => {
+                tracing::warn!("failed to serialize live update: {}", e);
+                continue;
+            }
+        };
+
+        if let Err(e) = nats.publish(live_subject(&topic_id), payload.into()).await {
+            tracing::warn!("failed to publish live update for {}: {}", topic_id, e);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use redis::AsyncCommands as _;
+
+    use super::*;
+    use crate::constants::LUA_SCRIPT_BATCH_AUDIT_SCRIPT;
+
+    fn at(timestamp: i64) -> DateTime<Utc> {
+        DateTime::from_timestamp(timestamp, 0).unwrap()
+    }
+
+    #[test]
+    fn boundary_orders_ids_by_storage_time() {
+        let stored_at = 1_700_000_000u32;
+        let mut bytes = [0xff; 12];
+        bytes[..4].copy_from_slice(&stored_at.to_be_bytes());
+        let id = ObjectId::from_bytes(bytes);
+
+        let from = boundary(at(stored_at as i64));
+        assert_eq!(from.timestamp().timestamp_millis(), stored_at as i64 * 1000);
+        assert!(from <= id);
+        assert!(id < boundary(at(stored_at as i64 + 1)));
+        assert_eq!(boundary(at(-1)), ObjectId::from_bytes([0; 12]));
+    }
+
+    #[test]
+    fn sweep_range_goes_on_from_the_last_sweep() {
+        let now = at(1_700_000_000);
+        let stale_until = now - chrono::Duration::from_std(AUDIT_STALE_AFTER).unwrap();
+
+        // nothing swept yet, only ballots which still have their markers
+        assert_eq!(
+            sweep_range(None, now),
+            Some((audit_markers_since(now), stale_until))
+        );
+        assert_eq!(
+            sweep_range(Some(now - chrono::Duration::hours(1)), now),
+            Some((now - chrono::Duration::hours(1), stale_until))
+        );
+        // after an outage the ballots stored during it are swept as well
+        let outage = now - chrono::Duration::days(3);
+        assert_eq!(sweep_range(Some(outage), now), Some((outage, stale_until)));
+        assert_eq!(sweep_range(Some(stale_until), now), None);
+    }
+
+    #[test]
+    fn sweep_slot_is_shared_within_an_interval() {
+        let interval = AUDIT_SWEEP_INTERVAL.as_secs() as i64;
+        let start = at(1_700_000_000 - 1_700_000_000 % interval);
+        assert_eq!(sweep_slot(start), start);
+        assert_eq!(
+            sweep_slot(start + chrono::Duration::seconds(interval - 1)),
+            start
+        );
+        assert_ne!(
+            sweep_slot(start + chrono::Duration::seconds(interval)),
+            start
+        );
+    }
+
+    async fn audit(
+        script: &CachedScript,
+        conn: &mut redis::aio::MultiplexedConnection,
+        topic_id: &str,
+        ballots: &[(&str, i32, i32)],
+    ) -> Vec<i32> {
+        let mut args = Vec::new();
+        for (ballot_id, win, lose) in ballots {
+            args.push(topic_id.to_string());
+            args.push(ballot_id.to_string());
+            args.push(format!("{topic_id}:ip_counter:voter"));
+            args.push(win.to_string());
+            args.push(lose.to_string());
+        }
+        // at most one ballot per address at the base multiplier of 10
+        script
+            .arg(60)
+            .arg(1)
+            .arg(10)
+            .arg(1)
+            .arg(60)
+            .arg(&args)
+            .invoke_async(conn)
+            .await
+            .unwrap()
+    }
+
+    /// Needs a redis server, run with
+    /// `ARK_VOTE_TEST_REDIS_URL=redis://127.0.0.1/ cargo test -- --ignored`.
+    #[tokio::test]
+    #[ignore = "needs a redis server"]
+    async fn audit_script_counts_a_redelivered_ballot_once() {
+        let url = std::env::var("ARK_VOTE_TEST_REDIS_URL")
+            .unwrap_or_else(|_| "redis://127.0.0.1/".to_string());
+        let mut conn = redis::Client::open(url)
+            .unwrap()
+            .get_multiplexed_async_connection()
+            .await
+            .unwrap();
+        let script = CachedScript::new("batch_audit", LUA_SCRIPT_BATCH_AUDIT_SCRIPT);
+        let topic_id = format!("audit-test-{}", std::process::id());
+
+        let first = audit(&script, &mut conn, &topic_id, &[("a", 1, 2), ("b", 1, 2)]).await;
+        assert_eq!(first, [10, 1, 1, 1]);
+
+        // the batch is redelivered with a new ballot
+        let again = audit(
+            &script,
+            &mut conn,
+            &topic_id,
+            &[("a", 1, 2), ("b", 1, 2), ("c", 2, 1)],
+        )
+        .await;
+        assert_eq!(again, [10, 0, 1, 0, 1, 1]);
+
+        let stats: HashMap<String, i64> =
+            conn.hgetall(format!("{topic_id}:op_stats")).await.unwrap();
+        assert_eq!(stats["1:win"], 11);
+        assert_eq!(stats["2:win"], 1);
+        let matrix: HashMap<String, i64> =
+            conn.hgetall(format!("{topic_id}:op_matrix")).await.unwrap();
+        assert_eq!(matrix["1:2"], 10);
+        let counted: i64 = conn
+            .get(format!("{topic_id}:valid_ballots_count"))
+            .await
+            .unwrap();
+        assert_eq!(counted, 3);
+        let counter: i64 = conn
+            .get(format!("{topic_id}:ip_counter:voter"))
+            .await
+            .unwrap();
+        assert_eq!(counter, 3);
+
+        let keys: Vec<String> = conn.keys(format!("{topic_id}:*")).await.unwrap();
+        let _: () = conn.del(keys).await.unwrap();
+    }
+}
@@ -1,17 +1,62 @@
+use mongodb::Collection;
+use share::{
+    events::{DomainEvent, EventBus},
+    models::database::OutboxEvent,
+};
+
+use crate::script::CachedScript;
+
 #[derive(Clone)]
 pub struct RedisService {
     pub client: redis::Client,
-    pub score_update_script: redis::Script,
-    pub ip_counter_script: redis::Script,
-    pub batch_ip_counter_script: redis::Script,
-    pub batch_score_update_script: redis::Script,
-    pub get_del_many_script: redis::Script,
-    pub del_multiple_script: redis::Script,
+    pub score_update_script: CachedScript,
+    pub ip_counter_script: CachedScript,
+    pub batch_audit_script: CachedScript,
+    pub get_del_many_script: CachedScript,
+    pub del_multiple_script: CachedScript,
+}
+
+impl RedisService {
+    pub fn scripts(&self) -> [&CachedScript; 5] {
+        [
+            &self.score_update_script,
+            &self.ip_counter_script,
+            &self.batch_audit_script,
+            &self.get_del_many_script,
+            &self.del_multiple_script,
+        ]
+    }
 }
 
 #[derive(Clone)]
 pub struct AppDatabase {
     pub redis: RedisService,
     pub mongo_database: mongodb::Database,
+    pub nats: async_nats::Client,
     pub jetstream: async_nats::jetstream::Context,
+    pub events: EventBus,
+    /// Set with `[events.outbox]`, events are written here in the transaction
+    /// of their change and the web service relays them to `events`.
+    pub outbox: Option<Collection<OutboxEvent>>,
+}
+
+impl AppDatabase {
+    /// Events without a write of their own, e.g. rejected ballots which are
+    /// never stored.
+    pub async fn publish_events(&self, events: Vec<DomainEvent>) {
+        let Some(outbox) = &self.outbox else {
+            self.events.publish_all(events).await;
+            return;
+        };
+        if events.is_empty() {
+            return;
+        }
+        let count = events.len();
+        if let Err(e) = outbox
+            .insert_many(events.into_iter().map(OutboxEvent::new))
+            .await
+        {
+            tracing::warn!("failed to write {} events to the outbox: {}", count, e);
+        }
+    }
 }
@@ -30,6 +30,10 @@ pub enum AppError {
     ),
     #[error("mongodb error: {0}")]
     MongoDB(#[from] mongodb::error::Error),
+    #[error("bson serialization error: {0}")]
+    BsonSer(#[from] mongodb::bson::ser::Error),
+    #[error("bson deserialization error: {0}")]
+    BsonDe(#[from] mongodb::bson::de::Error),
     #[error("i/o error: {0}")]
     Io(#[from] std::io::Error),
 }
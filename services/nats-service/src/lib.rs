@@ -4,18 +4,27 @@ mod constants;
 mod consumer;
 mod db;
 mod error;
+mod script;
 
 use eyre::{Context, Result};
-use share::config::AppConfig;
+use share::{
+    config::AppConfig,
+    events::EventBus,
+    http_client::HttpClient,
+    jobs::{JobManager, Stage},
+    models::database::OutboxEvent,
+    reload::ConfigWatch,
+    secrets::SecretResolver,
+};
 
 use crate::{
     constants::{
-        LUA_SCRIPT_BATCH_IP_COUNTER_SCRIPT, LUA_SCRIPT_BATCH_SCORE_UPDATE_SCRIPT,
-        LUA_SCRIPT_DEL_MUTIPLE, LUA_SCRIPT_GET_DEL_MANY, LUA_SCRIPT_IP_COUNTER,
-        LUA_SCRIPT_UPDATE_SCORES,
+        LUA_SCRIPT_BATCH_AUDIT_SCRIPT, LUA_SCRIPT_DEL_MUTIPLE, LUA_SCRIPT_GET_DEL_MANY,
+        LUA_SCRIPT_IP_COUNTER, LUA_SCRIPT_UPDATE_SCORES,
     },
-    consumer::available_consumers,
+    consumer::{available_consumers, requeue_pending_ballots},
     db::{AppDatabase, RedisService},
+    script::CachedScript,
 };
 
 pub struct NatsService {
@@ -30,29 +39,74 @@ impl NatsService {
     }
 
     pub async fn run(self, mut shutdown_rx: share::signal::ShutdownRx) -> Result<()> {
-        let database = self.setup_database().await?;
+        let jobs = JobManager::new();
+        let database = self.setup_database(&jobs).await?;
 
         let stream = self.create_jetstream_setup(&database.jetstream).await?;
 
-        self.start_consumers(&stream, &database).await?;
+        // the tunable sections and rotated secrets are re-read on SIGHUP, from
+        // the admin server and every `secrets.refresh_secs`
+        let http_client =
+            HttpClient::new(&self.config.http_client).context("failed to build the http client")?;
+        let reload = ConfigWatch::new(self.config.as_ref().clone(), share::config::CONFIG_PATH)
+            .with_secrets(SecretResolver::new(&self.config.secrets, http_client));
+        reload.spawn_reloader();
+
+        // every consumer thread holds a guard until its last batch is written
+        let (drain, drain_waiter) = share::signal::drain(&shutdown_rx);
+        self.start_consumers(&stream, &database, &reload, drain)
+            .await?;
+
+        // pairwise ballots whose audit request was lost are handed over again
+        let consumers = &self.config.nats.consumers;
+        if consumers
+            .iter()
+            .any(|c| c.enabled && c.name == "vote_audit")
+        {
+            jobs.spawn(
+                "requeue_pending_ballots",
+                Stage::Worker,
+                requeue_pending_ballots(database.clone()),
+            );
+        }
 
         tracing::info!("nats service started successfully");
+        share::systemd::ready();
 
         shutdown_rx
             .changed()
             .await
             .context("failed to receive shutdown signal")?;
+        share::systemd::stopping();
 
-        tracing::info!("shutting down nats consumer");
+        tracing::info!("shutting down nats consumer, finishing the current batches");
+        let shutdown_timeout = self.config.server.shutdown_timeout();
+        if !drain_waiter.wait(shutdown_timeout).await {
+            // unacked messages are redelivered, the batch is counted again
+            // only if it was written but not acked
+            tracing::warn!(
+                "consumers did not finish their batches within {:?}",
+                shutdown_timeout
+            );
+        }
+
+        jobs.shutdown(shutdown_timeout).await;
+        database.nats.drain().await?;
+        database.mongo_database.client().clone().shutdown().await;
+        tracing::info!("nats consumer stopped");
         Ok(())
     }
 
-    async fn setup_database(&self) -> Result<Arc<AppDatabase>> {
+    async fn setup_database(&self, jobs: &JobManager) -> Result<Arc<AppDatabase>> {
         let nats_client = async_nats::connect(&self.config.nats.url)
             .await
             .context("failed to connect to nats")?;
 
-        let jetstream = async_nats::jetstream::new(nats_client);
+        let jetstream = async_nats::jetstream::new(nats_client.clone());
+
+        let events = EventBus::connect(&self.config.events, &nats_client)
+            .await
+            .context("failed to connect event bus")?;
 
         let database_config = &self.config.database;
 
@@ -64,19 +118,42 @@ impl NatsService {
             .context("failed to connect to MongoDB")?;
 
         let mongo_database = mongodb_client.database(&database_config.mongodb_database);
+        let outbox = self
+            .config
+            .events
+            .outbox
+            .enabled
+            .then(|| mongo_database.collection::<OutboxEvent>("event_outbox"));
+
+        let redis = RedisService {
+            client: redis_client,
+            score_update_script: CachedScript::new("update_scores", LUA_SCRIPT_UPDATE_SCORES),
+            ip_counter_script: CachedScript::new("ip_counter", LUA_SCRIPT_IP_COUNTER),
+            batch_audit_script: CachedScript::new("batch_audit", LUA_SCRIPT_BATCH_AUDIT_SCRIPT),
+            get_del_many_script: CachedScript::new("get_del_many", LUA_SCRIPT_GET_DEL_MANY),
+            del_multiple_script: CachedScript::new("del_multiple", LUA_SCRIPT_DEL_MUTIPLE),
+        };
+        let mut conn = redis
+            .client
+            .get_multiplexed_async_connection()
+            .await
+            .context("failed to connect to redis")?;
+        script::preload(&redis.scripts(), &mut conn)
+            .await
+            .context("failed to load lua scripts")?;
+        jobs.spawn(
+            "script_stats",
+            Stage::Worker,
+            script::log_stats(redis.scripts().into_iter().cloned().collect()),
+        );
 
         Ok(Arc::new(AppDatabase {
-            redis: RedisService {
-                client: redis_client,
-                score_update_script: redis::Script::new(LUA_SCRIPT_UPDATE_SCORES),
-                ip_counter_script: redis::Script::new(LUA_SCRIPT_IP_COUNTER),
-                batch_ip_counter_script: redis::Script::new(LUA_SCRIPT_BATCH_IP_COUNTER_SCRIPT),
-                batch_score_update_script: redis::Script::new(LUA_SCRIPT_BATCH_SCORE_UPDATE_SCRIPT),
-                get_del_many_script: redis::Script::new(LUA_SCRIPT_GET_DEL_MANY),
-                del_multiple_script: redis::Script::new(LUA_SCRIPT_DEL_MUTIPLE),
-            },
+            redis,
             mongo_database,
+            nats: nats_client,
             jetstream,
+            events,
+            outbox,
         }))
     }
 
@@ -84,6 +161,8 @@ impl NatsService {
         &self,
         stream: &async_nats::jetstream::stream::Stream,
         database: &Arc<AppDatabase>,
+        reload: &ConfigWatch,
+        drain: share::signal::DrainGuard,
     ) -> Result<()> {
         let consumers_config = &self.config.nats.consumers;
         let available = available_consumers();
@@ -104,7 +183,7 @@ impl NatsService {
                         consumer.name,
                         subject
                     );
-                    (consumer.starter)(subject, stream, db, self.config.clone()).await?;
+                    (consumer.starter)(subject, stream, db, reload.clone(), drain.clone()).await?;
                 }
                 None => {
                     tracing::warn!("no consumer found for: {}", config.name);
@@ -133,13 +212,14 @@ impl NatsService {
     ) -> Result<async_nats::jetstream::stream::Stream> {
         let stream_config = &self.config.nats.stream_config;
 
-        #[cfg(debug_assertions)]
-        self.cleanup_existing_stream(jetstream, &stream_config.name)
-            .await;
+        if self.config.environment.is_dev() {
+            self.cleanup_existing_stream(jetstream, &stream_config.name)
+                .await;
+        }
 
         tracing::debug!("creating jetstream stream with config: {:?}", stream_config);
-        let stream = jetstream
-            .create_stream(async_nats::jetstream::stream::Config {
+        let mut stream = jetstream
+            .get_or_create_stream(async_nats::jetstream::stream::Config {
                 name: stream_config.name.clone(),
                 retention: stream_config.retention,
                 subjects: stream_config.subjects.clone(),
@@ -151,10 +231,35 @@ impl NatsService {
             .await
             .context("failed to create JetStream")?;
 
+        // a stream kept from an earlier deploy lacks the subjects added since,
+        // publishing to them would fail
+        let mut config = stream.cached_info().config.clone();
+        let missing: Vec<String> = stream_config
+            .subjects
+            .iter()
+            .filter(|subject| !config.subjects.contains(subject))
+            .cloned()
+            .collect();
+        if !missing.is_empty() {
+            tracing::info!(
+                "adding subjects {:?} to stream '{}'",
+                missing,
+                stream_config.name
+            );
+            config.subjects.extend(missing);
+            jetstream
+                .update_stream(&config)
+                .await
+                .context("failed to update the JetStream subjects")?;
+            stream = jetstream
+                .get_stream(&stream_config.name)
+                .await
+                .context("failed to get JetStream")?;
+        }
+
         Ok(stream)
     }
 
-    #[cfg(debug_assertions)]
     async fn cleanup_existing_stream(
         &self,
         jetstream: &async_nats::jetstream::Context,
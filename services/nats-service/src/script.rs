@@ -0,0 +1,164 @@
+use std::{
+    sync::{
+        Arc,
+        atomic::{AtomicU64, Ordering},
+    },
+    time::Duration,
+};
+
+use redis::{ErrorKind, FromRedisValue, RedisResult, ToRedisArgs, aio::ConnectionLike};
+
+const STATS_LOG_INTERVAL: Duration = Duration::from_secs(300);
+
+#[derive(Default)]
+struct ScriptStats {
+    calls: AtomicU64,
+    /// Calls which found the script missing from the redis script cache.
+    reloads: AtomicU64,
+}
+
+/// A lua script which is sent once and then called by its sha. Scripts are
+/// preloaded on startup, a redis restart or `SCRIPT FLUSH` costs one reload
+/// which shows up in the logged reuse rate.
+#[derive(Clone)]
+pub struct CachedScript {
+    name: &'static str,
+    code: Arc<str>,
+    /// Only for its sha1, calls are built here to tell misses apart.
+    script: redis::Script,
+    stats: Arc<ScriptStats>,
+}
+
+impl CachedScript {
+    pub fn new(name: &'static str, code: &str) -> Self {
+        Self {
+            name,
+            code: code.into(),
+            script: redis::Script::new(code),
+            stats: Arc::new(ScriptStats::default()),
+        }
+    }
+
+    pub fn key<T: ToRedisArgs>(&self, key: T) -> ScriptCall<'_> {
+        let mut call = self.call();
+        call.key(key);
+        call
+    }
+
+    pub fn arg<T: ToRedisArgs>(&self, arg: T) -> ScriptCall<'_> {
+        let mut call = self.call();
+        call.arg(arg);
+        call
+    }
+
+    fn call(&self) -> ScriptCall<'_> {
+        ScriptCall {
+            script: self,
+            keys: Vec::new(),
+            args: Vec::new(),
+        }
+    }
+
+    pub async fn load<C: ConnectionLike>(&self, conn: &mut C) -> RedisResult<()> {
+        let _: String = redis::cmd("SCRIPT")
+            .arg("LOAD")
+            .arg(&*self.code)
+            .query_async(conn)
+            .await?;
+        Ok(())
+    }
+
+    /// Share of the calls since startup which reused the cached script.
+    fn reuse_rate(&self) -> Option<f64> {
+        let calls = self.stats.calls.load(Ordering::Relaxed);
+        let reloads = self.stats.reloads.load(Ordering::Relaxed);
+        (calls > 0).then(|| 1.0 - reloads as f64 / calls as f64)
+    }
+}
+
+pub struct ScriptCall<'a> {
+    script: &'a CachedScript,
+    keys: Vec<Vec<u8>>,
+    args: Vec<Vec<u8>>,
+}
+
+impl ScriptCall<'_> {
+    pub fn key<T: ToRedisArgs>(&mut self, key: T) -> &mut Self {
+        self.keys.extend(key.to_redis_args());
+        self
+    }
+
+    pub fn arg<T: ToRedisArgs>(&mut self, arg: T) -> &mut Self {
+        self.args.extend(arg.to_redis_args());
+        self
+    }
+
+    fn evalsha(&self) -> redis::Cmd {
+        let mut cmd = redis::cmd("EVALSHA");
+        cmd.arg(self.script.script.get_hash())
+            .arg(self.keys.len())
+            .arg(&self.keys)
+            .arg(&self.args);
+        cmd
+    }
+
+    #[tracing::instrument(
+        name = "redis.evalsha",
+        skip_all,
+        fields(otel.kind = "client", script = self.script.name),
+    )]
+    pub async fn invoke_async<T: FromRedisValue, C: ConnectionLike>(
+        &self,
+        conn: &mut C,
+    ) -> RedisResult<T> {
+        let stats = &self.script.stats;
+        stats.calls.fetch_add(1, Ordering::Relaxed);
+
+        let cmd = self.evalsha();
+        match cmd.query_async(conn).await {
+            Err(e) if e.kind() == ErrorKind::NoScriptError => {
+                stats.reloads.fetch_add(1, Ordering::Relaxed);
+                tracing::debug!(
+                    "script {} missing from redis, loading it again",
+                    self.script.name
+                );
+                self.script.load(conn).await?;
+                cmd.query_async(conn).await
+            }
+            result => result,
+        }
+    }
+}
+
+/// Loads every script so the first calls do not miss the script cache.
+pub async fn preload<C: ConnectionLike>(
+    scripts: &[&CachedScript],
+    conn: &mut C,
+) -> RedisResult<()> {
+    for script in scripts {
+        script.load(conn).await?;
+    }
+    tracing::debug!("preloaded {} lua scripts", scripts.len());
+    Ok(())
+}
+
+/// Logs the calls and the reuse rate of every script now and then.
+pub async fn log_stats(scripts: Vec<CachedScript>) {
+    let mut interval = tokio::time::interval(STATS_LOG_INTERVAL);
+    interval.tick().await;
+    loop {
+        interval.tick().await;
+        for script in &scripts {
+            let Some(reuse_rate) = script.reuse_rate() else {
+                continue;
+            };
+            tracing::info!(
+                script = script.name,
+                calls = script.stats.calls.load(Ordering::Relaxed),
+                reloads = script.stats.reloads.load(Ordering::Relaxed),
+                reuse_rate,
+                "lua script statistics"
+            );
+        }
+    }
+}
@@ -91,6 +91,7 @@ pub async fn ballot_create_fn(
                 ballot_id,
                 left,
                 right,
+                nonce: None,
             };
 
             Ok(web::Json(ApiResponse {
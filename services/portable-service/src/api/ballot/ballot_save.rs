@@ -94,6 +94,7 @@ pub async fn ballot_save_fn(
             ballot_id,
             winner,
             loser,
+            ..
         }) => {
             if winner == loser {
                 tracing::error!(
@@ -107,6 +108,23 @@ pub async fn ballot_save_fn(
                     message: ApiMsg::BallotWinnerCannotBeLoser,
                 }));
             }
+            let in_pool = state
+                .topic_service
+                .get_candidate_pool(&topic_id, &state.character_infos)
+                .await
+                .is_some_and(|pool| pool.contains(&winner) && pool.contains(&loser));
+            if !in_pool {
+                tracing::error!(
+                    "Ballot options not in candidate pool: winner={}, loser={}",
+                    winner,
+                    loser
+                );
+                return Ok(web::Json(ApiResponse {
+                    status: 400,
+                    data: ApiData::Empty,
+                    message: ApiMsg::OptionNotInCandidatePool,
+                }));
+            }
 
             let (ballot_left, ballot_right) = (store_value.0, store_value.1);
 
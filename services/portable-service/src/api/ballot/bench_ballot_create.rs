@@ -94,6 +94,7 @@ pub async fn bench_ballot_create_fn(
                 ballot_id,
                 left,
                 right,
+                nonce: None,
             };
 
             Ok(web::Json(ApiResponse {
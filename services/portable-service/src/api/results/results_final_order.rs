@@ -192,6 +192,8 @@ pub async fn results_final_order_fn(
             })
             .collect(),
         count: total_valid_ballots.unwrap_or(0),
+        // the portable server has no operator metadata to group by
+        groups: None,
     });
 
     let mut cached = state
@@ -32,6 +32,7 @@ pub async fn topic_create_fn(
         description: req.description,
         topic_type: req.topic_type,
         candidate_pool: req.candidate_pool,
+        excluded_operator_ids: req.excluded_operator_ids,
         created_at: Utc::now(),
         updated_at: None,
         open_time: req.open_time,
@@ -124,6 +124,7 @@ impl PortableService {
                 let charid = parts.next()?.parse::<i32>().ok()?;
                 Some(CharacterInfo {
                     id: charid,
+                    faction: data.faction().map(str::to_string),
                     name: data.name,
                     rarity: data.rarity,
                     profession: data.profession,
@@ -134,7 +135,8 @@ impl PortableService {
             .collect();
         tracing::debug!("Character infos loaded: {}", character_infos.len());
 
-        let character_portraits = utils::fetch_portrait_image_url().await?;
+        let http_client = share::http_client::HttpClient::new(&self.config.http_client)?;
+        let character_portraits = utils::fetch_portrait_image_url(&http_client).await?;
         tracing::debug!("Character portraits fetched");
 
         let topic_service = Arc::new(TopicService::new(database.mongo_database.clone()));
@@ -173,13 +175,16 @@ impl PortableService {
                 .await
                 .unwrap();
             let operators_info = api::generate_operators_info(&candidate_pool, &character_infos);
-            tokio::spawn(timeseries::update_operator_statistics(
-                topic_service.clone(),
-                database.mongo_database.clone(),
-                database.redis.connection.clone(),
-                database.redis.final_order_script.clone(),
-                operators_info,
-            ));
+            share::task::spawn(
+                "operator_statistics",
+                timeseries::update_operator_statistics(
+                    topic_service.clone(),
+                    database.mongo_database.clone(),
+                    database.redis.connection.clone(),
+                    database.redis.final_order_script.clone(),
+                    operators_info,
+                ),
+            );
         }
 
         actix_web::HttpServer::new(move || {
@@ -15,6 +15,7 @@ use share::{
     config::{AppConfig, VoteConfig},
     models::database::{
         Ballot, GroupwiseBallot, PairwiseBallot, PluralityBallot, SetwiseBallot, StoredBallot,
+        VoteAudit,
     },
 };
 
@@ -470,6 +471,7 @@ impl BallotProcessor {
             let ballots = ballots.to_vec();
             let ip_multipliers = ip_multipliers.clone();
             let low_multiplier = vote_config.low_multiplier;
+            let base_multiplier = vote_config.base_multiplier;
 
             let mut score_updates = Vec::with_capacity(ballots.len()); // ((topic_id, win_id, lose_id), total_multiplier)
             let mut grouped_ballots: HashMap<String, Vec<StoredBallot>> = HashMap::new();
@@ -485,9 +487,11 @@ impl BallotProcessor {
                     multiplier,
                 ));
 
+                // scored inline, the portable build has no audit stage
                 let stored_ballot = StoredBallot {
                     ballot: Ballot::Pairwise(item.clone()),
                     multiplier,
+                    audit: VoteAudit::from_multiplier(multiplier, base_multiplier),
                 };
 
                 grouped_ballots
@@ -76,7 +76,7 @@ pub async fn update_operator_statistics(
         let op_stats_fields = Arc::clone(&op_stats_all_fields);
         let collection = target_collection.clone();
 
-        tokio::spawn(async move {
+        share::task::spawn("operator_statistics_batch", async move {
             if let Err(e) = update_single_batch(
                 connection,
                 &script,
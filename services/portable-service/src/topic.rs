@@ -138,10 +138,10 @@ impl TopicService {
         };
         let refresh_lock = Arc::new(AsyncRwLock::new(()));
 
-        tokio::spawn(Self::cache_updater(
-            topic_collection.clone(),
-            topic_cache.clone(),
-        ));
+        share::task::spawn(
+            "topic_cache_updater",
+            Self::cache_updater(topic_collection.clone(), topic_cache.clone()),
+        );
 
         Self {
             topic_collection,
@@ -266,7 +266,7 @@ impl TopicService {
 
         match self.get_topic(topic_id).await {
             Ok(Some(topic)) => {
-                let pool = topic.candidate_pool.generate_pool(character_infos);
+                let pool = topic.generate_pool(character_infos);
                 if !pool.is_empty() {
                     self.cache.cache_topic_pool(topic_id, pool.clone());
                     Some(pool)
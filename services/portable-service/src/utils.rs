@@ -1,7 +1,10 @@
 use std::{collections::HashMap, fs, io::Read as _};
 
 use serde::{Deserialize, Serialize};
-use share::models::{api::CharacterPortrait, excel::CharacterData};
+use share::{
+    http_client::HttpClient,
+    models::{api::CharacterPortrait, excel::CharacterData},
+};
 
 use crate::error::AppError;
 
@@ -37,11 +40,14 @@ struct TorappuApiFileStruct {
     children: Vec<TorappuApiFileData>,
 }
 
-pub async fn fetch_portrait_image_url() -> Result<HashMap<i32, CharacterPortrait>, AppError> {
+pub async fn fetch_portrait_image_url(
+    http: &HttpClient,
+) -> Result<HashMap<i32, CharacterPortrait>, AppError> {
     const PORTRAIT_IMAGE_STORE_URL: &str =
         "https://torappu.prts.wiki/api/v1/files/raw%2Fchar_portrait";
 
-    let response = reqwest::get(PORTRAIT_IMAGE_STORE_URL)
+    let response = http
+        .send(http.get(PORTRAIT_IMAGE_STORE_URL))
         .await?
         .json::<TorappuApiFileStruct>()
         .await?;
@@ -95,6 +101,8 @@ pub async fn fetch_portrait_image_url() -> Result<HashMap<i32, CharacterPortrait
                 name: stripped_name.clone(),
                 cn_name: name,
                 avatar: vec![avatar_url],
+                display_name: None,
+                image: None,
             });
     }
 
@@ -20,6 +20,10 @@ use std::sync::{
 use std::time::Instant;
 use tokio::sync::{Semaphore, mpsc};
 
+mod loadtest;
+
+pub use loadtest::{LoadTestOptions, LoadTester};
+
 #[derive(Debug)]
 enum StatEvent {
     Success {
@@ -64,6 +68,9 @@ impl ServiceTester {
 
         let data = ResultsFinalOrderRequest {
             topic_id: "crisis_v2_season_4_1_benchtest".to_string(),
+            lang: Default::default(),
+            filter: None,
+            group_by: None,
         };
         let init_data = self.results_final_order(&client, &data).await?;
         let init_score: i64 = init_data.items.iter().map(|i| i.win + i.lose).sum();
@@ -173,6 +180,9 @@ impl ServiceTester {
                 &client,
                 &ResultsFinalOrderRequest {
                     topic_id: "crisis_v2_season_4_1_benchtest".to_string(),
+                    lang: Default::default(),
+                    filter: None,
+                    group_by: None,
                 },
             )
             .await?;
@@ -241,13 +251,14 @@ impl ServiceTester {
             }
         };
 
-        let (left, right, ballot_id) = match compare {
+        let (left, right, ballot_id, nonce) = match compare {
             BallotCreateResponse::Pairwise {
                 left,
                 right,
                 ballot_id,
+                nonce,
                 ..
-            } => (left, right, ballot_id),
+            } => (left, right, ballot_id, nonce),
             _ => {
                 tracing::warn!("unexpected compare response type");
                 let _ = tx.send(StatEvent::Error).await;
@@ -262,6 +273,7 @@ impl ServiceTester {
             ballot_id: ballot_id.clone(),
             winner: left,
             loser: right,
+            nonce,
         });
 
         match self.ballot_save(&client, &data).await {
@@ -291,6 +303,9 @@ impl ServiceTester {
             &client,
             &ResultsFinalOrderRequest {
                 topic_id: "crisis_v2_season_4_1_benchtest".to_string(),
+                lang: Default::default(),
+                filter: None,
+                group_by: None,
             },
         )
         .await?;
@@ -298,6 +313,8 @@ impl ServiceTester {
             &client,
             &Results1v1MatrixRequest {
                 topic_id: "crisis_v2_season_4_1_benchtest".to_string(),
+                filter: None,
+                group_by: None,
             },
         )
         .await?;
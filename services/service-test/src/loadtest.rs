@@ -0,0 +1,222 @@
+use std::time::{Duration, Instant};
+
+use eyre::{Context as _, Result};
+use hdrhistogram::Histogram;
+use reqwest::Client;
+use share::models::api::{
+    ApiData, ApiResponse, BallotCreateRequest, BallotCreateResponse, BallotSaveRequest,
+    BallotSaveResponse, PairwiseSaveScore,
+};
+use tokio::task::JoinSet;
+
+/// Settings of one `loadtest` run.
+#[derive(Clone, Debug)]
+pub struct LoadTestOptions {
+    pub base_url: String,
+    pub topic_id: String,
+    /// Simulated voters, each runs its ballots one after another.
+    pub voters: usize,
+    pub duration: Duration,
+    /// Time a voter spends on the captcha before submitting, the server does
+    /// not verify captchas so the step is only a delay.
+    pub captcha_delay: Duration,
+}
+
+/// Latencies in microseconds per step of a vote.
+struct StepHistograms {
+    fetch: Histogram<u64>,
+    submit: Histogram<u64>,
+    vote: Histogram<u64>,
+    errors: u64,
+}
+
+impl StepHistograms {
+    fn new() -> Result<Self> {
+        Ok(Self {
+            fetch: Histogram::new(3)?,
+            submit: Histogram::new(3)?,
+            vote: Histogram::new(3)?,
+            errors: 0,
+        })
+    }
+
+    fn merge(&mut self, other: &StepHistograms) -> Result<()> {
+        self.fetch.add(&other.fetch)?;
+        self.submit.add(&other.submit)?;
+        self.vote.add(&other.vote)?;
+        self.errors += other.errors;
+        Ok(())
+    }
+}
+
+fn report(step: &str, hist: &Histogram<u64>) {
+    if hist.is_empty() {
+        tracing::info!(step, "no samples");
+        return;
+    }
+
+    tracing::info!(
+        step,
+        count = hist.len(),
+        p50 = hist.value_at_quantile(0.50),
+        p90 = hist.value_at_quantile(0.90),
+        p95 = hist.value_at_quantile(0.95),
+        p99 = hist.value_at_quantile(0.99),
+        p99_9 = hist.value_at_quantile(0.999),
+        max = hist.max(),
+        mean = hist.mean(),
+        "latency statistics (μs)"
+    );
+}
+
+/// Simulates voters going through the ballot flow (fetch a ballot, solve the
+/// captcha, submit the vote) against a running instance. Unlike
+/// [`crate::ServiceTester`] it does not check the results, it measures
+/// capacity.
+pub struct LoadTester {
+    options: LoadTestOptions,
+}
+
+impl LoadTester {
+    pub fn new(options: LoadTestOptions) -> Self {
+        Self { options }
+    }
+
+    pub async fn run(self) -> Result<()> {
+        let client = Client::builder()
+            .pool_max_idle_per_host(self.options.voters)
+            .build()?;
+        let deadline = Instant::now() + self.options.duration;
+
+        tracing::info!(
+            voters = self.options.voters,
+            duration_secs = self.options.duration.as_secs(),
+            target = %self.options.base_url,
+            "starting load test"
+        );
+
+        let mut voters = JoinSet::new();
+        for voter in 0..self.options.voters {
+            let client = client.clone();
+            let options = self.options.clone();
+            voters.spawn(async move { run_voter(voter, client, options, deadline).await });
+        }
+
+        let mut total = StepHistograms::new()?;
+        while let Some(result) = voters.join_next().await {
+            total.merge(&result??)?;
+        }
+
+        let elapsed = self.options.duration.as_secs_f64().max(1.0);
+        tracing::info!(
+            votes = total.vote.len(),
+            errors = total.errors,
+            votes_per_sec = total.vote.len() as f64 / elapsed,
+            "load test finished"
+        );
+        report("ballot fetch", &total.fetch);
+        report("vote submit", &total.submit);
+        report("full vote", &total.vote);
+
+        Ok(())
+    }
+}
+
+async fn run_voter(
+    voter: usize,
+    client: Client,
+    options: LoadTestOptions,
+    deadline: Instant,
+) -> Result<StepHistograms> {
+    let mut hists = StepHistograms::new()?;
+
+    while Instant::now() < deadline {
+        let started = Instant::now();
+        let ballot = match fetch_ballot(&client, &options).await {
+            Ok(ballot) => ballot,
+            Err(e) => {
+                tracing::debug!(voter, "ballot fetch failed: {e}");
+                hists.errors += 1;
+                continue;
+            }
+        };
+        hists
+            .fetch
+            .saturating_record(started.elapsed().as_micros() as u64);
+
+        let BallotCreateResponse::Pairwise {
+            left,
+            right,
+            ballot_id,
+            nonce,
+            ..
+        } = ballot
+        else {
+            eyre::bail!("load test topic {} is not pairwise", options.topic_id);
+        };
+        tokio::time::sleep(options.captcha_delay).await;
+
+        let submitted = Instant::now();
+        let save = BallotSaveRequest::Pairwise(PairwiseSaveScore {
+            topic_id: options.topic_id.clone(),
+            ballot_id,
+            winner: left,
+            loser: right,
+            nonce,
+        });
+        if let Err(e) = submit_vote(&client, &options, &save).await {
+            tracing::debug!(voter, "vote submit failed: {e}");
+            hists.errors += 1;
+            continue;
+        }
+        hists
+            .submit
+            .saturating_record(submitted.elapsed().as_micros() as u64);
+        // the captcha is the voter's time, not the server's
+        let vote = started.elapsed().saturating_sub(options.captcha_delay);
+        hists.vote.saturating_record(vote.as_micros() as u64);
+    }
+
+    Ok(hists)
+}
+
+async fn fetch_ballot(client: &Client, options: &LoadTestOptions) -> Result<BallotCreateResponse> {
+    let response = client
+        .post(format!("{}/ballot/new", options.base_url))
+        .json(&BallotCreateRequest {
+            topic_id: options.topic_id.clone(),
+        })
+        .send()
+        .await
+        .context("post ballot_create failed")?
+        .json::<ApiResponse<BallotCreateResponse>>()
+        .await
+        .context("parsing ballot_create response failed")?;
+
+    match response.data {
+        ApiData::Data(data) => Ok(data),
+        ApiData::Empty => Err(eyre::eyre!("ballot_create failed: {}", response.message)),
+    }
+}
+
+async fn submit_vote(
+    client: &Client,
+    options: &LoadTestOptions,
+    save: &BallotSaveRequest,
+) -> Result<()> {
+    let response = client
+        .post(format!("{}/ballot/save", options.base_url))
+        .json(save)
+        .send()
+        .await
+        .context("post ballot_save failed")?
+        .json::<ApiResponse<BallotSaveResponse>>()
+        .await
+        .context("parsing ballot_save response failed")?;
+
+    if response.status == 0 {
+        Ok(())
+    } else {
+        Err(eyre::eyre!("ballot_save failed: {}", response.message))
+    }
+}
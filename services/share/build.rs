@@ -0,0 +1,47 @@
+use std::{
+    process::Command,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+// Read by `share::build_info`. CI passes the commit in GIT_COMMIT_HASH since
+// the docker build context has no .git directory.
+fn main() {
+    println!("cargo:rerun-if-env-changed=GIT_COMMIT_HASH");
+    println!("cargo:rerun-if-env-changed=SOURCE_DATE_EPOCH");
+    println!("cargo:rerun-if-changed=../../.git/HEAD");
+    println!("cargo:rerun-if-changed=../../.git/refs/heads");
+
+    let commit = std::env::var("GIT_COMMIT_HASH")
+        .ok()
+        .filter(|commit| !commit.is_empty())
+        .or_else(|| command_output("git", &["rev-parse", "--short=12", "HEAD"]));
+    if let Some(commit) = commit {
+        println!("cargo:rustc-env=ARK_VOTE_GIT_COMMIT={commit}");
+    }
+
+    // reproducible builds pin the time through SOURCE_DATE_EPOCH
+    let build_time = std::env::var("SOURCE_DATE_EPOCH")
+        .ok()
+        .and_then(|epoch| epoch.parse::<u64>().ok())
+        .unwrap_or_else(|| {
+            SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map(|d| d.as_secs())
+                .unwrap_or_default()
+        });
+    println!("cargo:rustc-env=ARK_VOTE_BUILD_TIME={build_time}");
+
+    let rustc = std::env::var("RUSTC").unwrap_or_else(|_| "rustc".to_string());
+    if let Some(version) = command_output(&rustc, &["--version"]) {
+        println!("cargo:rustc-env=ARK_VOTE_RUSTC_VERSION={version}");
+    }
+}
+
+fn command_output(program: &str, args: &[&str]) -> Option<String> {
+    let output = Command::new(program).args(args).output().ok()?;
+    if !output.status.success() {
+        return None;
+    }
+    let output = String::from_utf8(output.stdout).ok()?;
+    Some(output.trim().to_string())
+}
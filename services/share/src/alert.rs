@@ -0,0 +1,129 @@
+use std::{
+    panic,
+    sync::atomic::{AtomicU64, Ordering::Relaxed},
+    time::{Duration, Instant},
+};
+
+use serde_json::json;
+use tracing::{Event, Level, Subscriber};
+use tracing_subscriber::layer::{Context, Layer};
+
+use crate::{
+    config::{AlertConfig, AlertKind},
+    http_client::HttpClient,
+};
+
+// process wide, the tracing layer and the panic hook have no handle to a
+// monitor instance
+static ERRORS: AtomicU64 = AtomicU64::new(0);
+static PANICS: AtomicU64 = AtomicU64::new(0);
+
+/// Counts ERROR events for the monitor. Installed with the other layers
+/// even when alerting is off, counting alone is two atomic adds.
+pub struct ErrorCountLayer;
+
+impl<S: Subscriber> Layer<S> for ErrorCountLayer {
+    fn on_event(&self, event: &Event<'_>, _ctx: Context<'_, S>) {
+        let metadata = event.metadata();
+        // a failing webhook must not keep its own alert alive
+        if *metadata.level() == Level::ERROR && metadata.target() != module_path!() {
+            ERRORS.fetch_add(1, Relaxed);
+        }
+    }
+}
+
+/// Posts a message to the configured webhook when a window sees more errors
+/// or panics than allowed, so spikes during live events get noticed by the
+/// people in the chat rather than whoever reads the logs next.
+pub struct ErrorMonitor {
+    config: AlertConfig,
+    service: String,
+    http: HttpClient,
+}
+
+impl ErrorMonitor {
+    pub fn new(config: AlertConfig, service: &str, http: HttpClient) -> Self {
+        Self {
+            config,
+            service: service.to_string(),
+            http,
+        }
+    }
+
+    /// Installs the panic counter and checks the counts every window until
+    /// the runtime shuts down.
+    pub fn spawn(self) -> tokio::task::JoinHandle<Option<()>> {
+        let default_hook = panic::take_hook();
+        panic::set_hook(Box::new(move |panic_info| {
+            PANICS.fetch_add(1, Relaxed);
+            default_hook(panic_info);
+        }));
+
+        crate::task::spawn("error_monitor", self.run())
+    }
+
+    async fn run(self) {
+        let window = Duration::from_secs(self.config.window_secs.max(1));
+        let cooldown = Duration::from_secs(self.config.cooldown_secs);
+        let mut last_alert: Option<Instant> = None;
+        let mut interval = tokio::time::interval(window);
+        interval.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Delay);
+        // the first tick is immediate, start with a clean window
+        interval.tick().await;
+        ERRORS.store(0, Relaxed);
+        PANICS.store(0, Relaxed);
+
+        loop {
+            interval.tick().await;
+            let errors = ERRORS.swap(0, Relaxed);
+            let panics = PANICS.swap(0, Relaxed);
+
+            if !self.exceeded(errors, panics) {
+                continue;
+            }
+            if last_alert.is_some_and(|at| at.elapsed() < cooldown) {
+                tracing::debug!(errors, panics, "error spike during alert cooldown");
+                continue;
+            }
+
+            last_alert = Some(Instant::now());
+            if let Err(e) = self.send(errors, panics).await {
+                tracing::error!("failed to send error spike alert: {}", e);
+            }
+        }
+    }
+
+    fn exceeded(&self, errors: u64, panics: u64) -> bool {
+        let over = |count: u64, threshold: u64| threshold > 0 && count >= threshold;
+        over(errors, self.config.error_threshold) || over(panics, self.config.panic_threshold)
+    }
+
+    async fn send(&self, errors: u64, panics: u64) -> Result<(), reqwest::Error> {
+        let text = format!(
+            "[{}] {} errors and {} panics in the last {}s",
+            self.service, errors, panics, self.config.window_secs
+        );
+        tracing::warn!(errors, panics, "error spike, sending alert");
+
+        let body = match self.config.kind {
+            AlertKind::Generic => json!({
+                "service": self.service,
+                "window_secs": self.config.window_secs,
+                "errors": errors,
+                "panics": panics,
+                "text": text,
+            }),
+            AlertKind::Discord => json!({ "content": text }),
+            AlertKind::QqBot => json!({
+                "group_id": self.config.qq_group_id,
+                "message": text,
+            }),
+        };
+
+        self.http
+            .send(self.http.post(&self.config.url).json(&body))
+            .await?
+            .error_for_status()?;
+        Ok(())
+    }
+}
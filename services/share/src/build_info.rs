@@ -0,0 +1,36 @@
+use std::sync::LazyLock;
+
+use chrono::DateTime;
+use serde::Serialize;
+use utoipa::ToSchema;
+
+/// What was built and from where, embedded by `build.rs`.
+#[derive(Clone, Debug, Serialize, ToSchema)]
+pub struct BuildInfo {
+    pub version: String,
+    pub commit: Option<String>,
+    /// RFC 3339, in UTC.
+    pub build_time: Option<String>,
+    pub rustc: Option<String>,
+    pub profile: String,
+}
+
+static BUILD_INFO: LazyLock<BuildInfo> = LazyLock::new(|| BuildInfo {
+    version: env!("CARGO_PKG_VERSION").to_string(),
+    commit: option_env!("ARK_VOTE_GIT_COMMIT").map(ToString::to_string),
+    build_time: option_env!("ARK_VOTE_BUILD_TIME")
+        .and_then(|secs| secs.parse().ok())
+        .and_then(|secs| DateTime::from_timestamp(secs, 0))
+        .map(|time| time.to_rfc3339()),
+    rustc: option_env!("ARK_VOTE_RUSTC_VERSION").map(ToString::to_string),
+    profile: if cfg!(debug_assertions) {
+        "debug"
+    } else {
+        "release"
+    }
+    .to_string(),
+});
+
+pub fn build_info() -> &'static BuildInfo {
+    &BUILD_INFO
+}
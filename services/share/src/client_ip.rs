@@ -0,0 +1,142 @@
+use std::{
+    borrow::Cow,
+    net::{IpAddr, Ipv4Addr, Ipv6Addr},
+};
+
+use hmac::{Hmac, Mac as _};
+use sha2::Sha256;
+
+use crate::config::{IpStorage, VoteConfig};
+
+const TRUNCATED_IPV4_PREFIX_LEN: u32 = 24;
+const TRUNCATED_IPV6_PREFIX_LEN: u32 = 48;
+
+/// The address the per-address vote limits count. An IPv6 voter usually
+/// gets a whole /64 from their provider and could vote from a fresh address
+/// every time, so those are counted by their first `ipv6_prefix_len` bits.
+/// IPv4 addresses mapped by a dual-stack listener count as IPv4, anything
+/// which doesn't parse is counted as is.
+pub fn limit_key(ip: &str, ipv6_prefix_len: u8) -> Cow<'_, str> {
+    match ip.parse::<IpAddr>().map(|ip| ip.to_canonical()) {
+        Ok(IpAddr::V6(v6)) if ipv6_prefix_len < 128 => {
+            let mask = u128::MAX
+                .checked_shl(128 - ipv6_prefix_len as u32)
+                .unwrap_or(0);
+            let network = Ipv6Addr::from(u128::from(v6) & mask);
+            Cow::Owned(format!("{network}/{ipv6_prefix_len}"))
+        }
+        Ok(canonical) => match canonical.to_string() {
+            formatted if formatted == ip => Cow::Borrowed(ip),
+            formatted => Cow::Owned(formatted),
+        },
+        Err(_) => Cow::Borrowed(ip),
+    }
+}
+
+/// What a ballot stores as the voter address under `vote.ip_storage`. The
+/// fraud checks pass it through [`limit_key`], which counts a hash or a
+/// network as is.
+pub fn stored_ip<'a>(ip: &'a str, config: &VoteConfig) -> Cow<'a, str> {
+    match config.ip_storage {
+        IpStorage::Raw => Cow::Borrowed(ip),
+        IpStorage::Truncated => match ip.parse::<IpAddr>().map(|ip| ip.to_canonical()) {
+            Ok(IpAddr::V4(v4)) => {
+                let mask = u32::MAX << (32 - TRUNCATED_IPV4_PREFIX_LEN);
+                let network = Ipv4Addr::from(u32::from(v4) & mask);
+                Cow::Owned(format!("{network}/{TRUNCATED_IPV4_PREFIX_LEN}"))
+            }
+            Ok(IpAddr::V6(v6)) => {
+                let mask = u128::MAX << (128 - TRUNCATED_IPV6_PREFIX_LEN);
+                let network = Ipv6Addr::from(u128::from(v6) & mask);
+                Cow::Owned(format!("{network}/{TRUNCATED_IPV6_PREFIX_LEN}"))
+            }
+            Err(_) => Cow::Borrowed(ip),
+        },
+        IpStorage::Hashed => Cow::Owned(hashed_ip(ip, config)),
+    }
+}
+
+/// The address a voter is known by to bookmarks, consents, comments,
+/// reports and presence. A truncated network is shared with other voters,
+/// so under that storage they are told apart by the keyed hash.
+pub fn voter_key<'a>(ip: &'a str, config: &VoteConfig) -> Cow<'a, str> {
+    match config.ip_storage {
+        IpStorage::Raw => Cow::Borrowed(ip),
+        IpStorage::Truncated | IpStorage::Hashed => Cow::Owned(hashed_ip(ip, config)),
+    }
+}
+
+/// The hashed form of `ip` under `vote.ip_hash_key`, whatever the current
+/// `ip_storage` is.
+fn hashed_ip(ip: &str, config: &VoteConfig) -> String {
+    let mut mac = Hmac::<Sha256>::new_from_slice(config.ip_hash_key.as_bytes())
+        .expect("hmac accepts keys of any length");
+    mac.update(limit_key(ip, config.ipv6_prefix_len).as_bytes());
+    // 128 bits keep collisions out of the counters
+    let hash = mac.finalize().into_bytes();
+    format!("h:{}", hex::encode(&hash[..16]))
+}
+
+/// Every form a ballot may hold `ip` in, whatever `ip_storage` was when it
+/// was cast. A truncated network is shared with other voters and left out.
+pub fn voter_ip_forms(ip: &str, config: &VoteConfig) -> Vec<String> {
+    let mut forms = vec![ip.to_string()];
+    if !config.ip_hash_key.is_empty() {
+        forms.push(hashed_ip(ip, config));
+    }
+    forms
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn ipv6_is_counted_by_prefix() {
+        assert_eq!(limit_key("203.0.113.7", 64), "203.0.113.7");
+        assert_eq!(limit_key("::ffff:203.0.113.7", 64), "203.0.113.7");
+        assert_eq!(
+            limit_key("2001:db8:1:2:aaaa:bbbb:cccc:dddd", 64),
+            "2001:db8:1:2::/64"
+        );
+        assert_eq!(limit_key("2001:db8::1", 128), "2001:db8::1");
+        assert_eq!(limit_key("2001:db8::1", 0), "::/0");
+        assert_eq!(limit_key("unknown", 64), "unknown");
+    }
+
+    #[test]
+    fn stored_ips_keep_voters_apart_without_the_address() {
+        let mut config: VoteConfig = toml::from_str(
+            r#"
+            base_multiplier = 100
+            low_multiplier = 1
+            max_ip_limit = 100
+            ip_counter_expire_seconds = 86400
+            ip_storage = "truncated"
+            ip_hash_key = "test"
+            preset_vote_topic = []
+            "#,
+        )
+        .unwrap();
+        assert_eq!(stored_ip("203.0.113.7", &config), "203.0.113.0/24");
+        assert_eq!(stored_ip("::ffff:203.0.113.7", &config), "203.0.113.0/24");
+        assert_eq!(stored_ip("2001:db8:1:2::1", &config), "2001:db8:1::/48");
+        // one network, two voters
+        assert_ne!(
+            voter_key("203.0.113.7", &config),
+            voter_key("203.0.113.8", &config)
+        );
+        assert!(
+            voter_ip_forms("203.0.113.7", &config)
+                .contains(&voter_key("203.0.113.7", &config).into_owned())
+        );
+
+        config.ip_storage = IpStorage::Hashed;
+        let hashed = stored_ip("2001:db8:1:2::1", &config);
+        assert!(hashed.starts_with("h:") && !hashed.contains("2001"));
+        // the same /64 is the same voter, as for the raw address
+        assert_eq!(hashed, stored_ip("2001:db8:1:2::2", &config));
+        assert_ne!(hashed, stored_ip("2001:db8:1:3::1", &config));
+        assert_eq!(limit_key(&hashed, 64), hashed);
+    }
+}
@@ -0,0 +1,103 @@
+use std::{
+    collections::HashMap,
+    sync::atomic::{AtomicUsize, Ordering},
+};
+
+use parking_lot::Mutex;
+
+static NEXT_STRIPE: AtomicUsize = AtomicUsize::new(0);
+
+thread_local! {
+    /// Threads are spread round robin over the stripes, with one runtime
+    /// worker per core that is as good as per-CPU striping.
+    static STRIPE: usize = NEXT_STRIPE.fetch_add(1, Ordering::Relaxed);
+}
+
+/// Keeps every stripe on its own cache line.
+#[repr(align(128))]
+struct Stripe(Mutex<HashMap<String, u64>>);
+
+/// Counters by key which many threads bump at once. Every thread writes to
+/// its own stripe, so increments only contend when there are more threads
+/// than stripes, and reads add the stripes up.
+pub struct ShardedCounters {
+    stripes: Box<[Stripe]>,
+}
+
+impl Default for ShardedCounters {
+    fn default() -> Self {
+        let parallelism = std::thread::available_parallelism().map_or(8, |n| n.get());
+        Self::new(parallelism)
+    }
+}
+
+impl ShardedCounters {
+    pub fn new(stripes: usize) -> Self {
+        Self {
+            stripes: (0..stripes.max(1).next_power_of_two())
+                .map(|_| Stripe(Mutex::new(HashMap::new())))
+                .collect(),
+        }
+    }
+
+    fn stripe(&self) -> &Stripe {
+        let index = STRIPE.with(|stripe| *stripe) & (self.stripes.len() - 1);
+        &self.stripes[index]
+    }
+
+    pub fn add(&self, key: &str, n: u64) {
+        let mut counts = self.stripe().0.lock();
+        match counts.get_mut(key) {
+            Some(count) => *count += n,
+            None => {
+                counts.insert(key.to_string(), n);
+            }
+        }
+    }
+
+    pub fn get(&self, key: &str) -> u64 {
+        self.stripes
+            .iter()
+            .map(|stripe| stripe.0.lock().get(key).copied().unwrap_or(0))
+            .sum()
+    }
+
+    /// Returns the count of `key` and starts it over from zero.
+    pub fn take(&self, key: &str) -> u64 {
+        self.stripes
+            .iter()
+            .map(|stripe| stripe.0.lock().remove(key).unwrap_or(0))
+            .sum()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use super::*;
+
+    #[test]
+    fn sums_increments_of_every_thread() {
+        let counters = Arc::new(ShardedCounters::new(4));
+        let threads: Vec<_> = (0..8)
+            .map(|_| {
+                let counters = counters.clone();
+                std::thread::spawn(move || {
+                    for _ in 0..1000 {
+                        counters.add("a", 1);
+                    }
+                    counters.add("b", 2);
+                })
+            })
+            .collect();
+        for thread in threads {
+            thread.join().unwrap();
+        }
+
+        assert_eq!(counters.get("a"), 8000);
+        assert_eq!(counters.take("b"), 16);
+        assert_eq!(counters.get("b"), 0);
+        assert_eq!(counters.get("c"), 0);
+    }
+}
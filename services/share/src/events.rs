@@ -0,0 +1,252 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use uuid::Uuid;
+
+use crate::{
+    config::{EventBackend, EventsConfig},
+    models::database::DailyDigest,
+};
+
+/// Envelope of every event published to the message bus.
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct DomainEvent {
+    pub id: Uuid,
+    pub occurred_at: DateTime<Utc>,
+    #[serde(flatten)]
+    pub kind: DomainEventKind,
+}
+
+impl DomainEvent {
+    pub fn new(kind: DomainEventKind) -> Self {
+        Self {
+            id: Uuid::new_v4(),
+            occurred_at: Utc::now(),
+            kind,
+        }
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum DomainEventKind {
+    VoteAccepted {
+        topic_id: String,
+        ballot_id: String,
+        win: i32,
+        lose: i32,
+        multiplier: i32,
+    },
+    VoteRejected {
+        topic_id: String,
+        ballot_id: String,
+        reason: String,
+    },
+    TopicTransition {
+        topic_id: String,
+        from: Option<TopicPhase>,
+        to: TopicPhase,
+    },
+    ResultPublished {
+        topic_id: String,
+        valid_ballots_count: i64,
+    },
+    /// Also of comments held for pre-moderation, a moderation bot takes
+    /// them down or approves them through `/comment/moderate`.
+    CommentPosted {
+        topic_id: String,
+        comment_id: String,
+        parent_id: Option<String>,
+        nickname: String,
+        body: String,
+        pending: bool,
+    },
+    DigestPublished {
+        topic_id: String,
+        digest: DailyDigest,
+    },
+}
+
+impl DomainEventKind {
+    pub fn name(&self) -> &'static str {
+        match self {
+            DomainEventKind::VoteAccepted { .. } => "vote_accepted",
+            DomainEventKind::VoteRejected { .. } => "vote_rejected",
+            DomainEventKind::TopicTransition { .. } => "topic_transition",
+            DomainEventKind::ResultPublished { .. } => "result_published",
+            DomainEventKind::CommentPosted { .. } => "comment_posted",
+            DomainEventKind::DigestPublished { .. } => "digest_published",
+        }
+    }
+
+    pub fn topic_id(&self) -> &str {
+        match self {
+            DomainEventKind::VoteAccepted { topic_id, .. }
+            | DomainEventKind::VoteRejected { topic_id, .. }
+            | DomainEventKind::TopicTransition { topic_id, .. }
+            | DomainEventKind::ResultPublished { topic_id, .. }
+            | DomainEventKind::CommentPosted { topic_id, .. }
+            | DomainEventKind::DigestPublished { topic_id, .. } => topic_id,
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Deserialize, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TopicPhase {
+    WaitingAudit,
+    Approved,
+    Rejected,
+    Open,
+    Closed,
+}
+
+impl TopicPhase {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            TopicPhase::WaitingAudit => "waiting_audit",
+            TopicPhase::Approved => "approved",
+            TopicPhase::Rejected => "rejected",
+            TopicPhase::Open => "open",
+            TopicPhase::Closed => "closed",
+        }
+    }
+
+    pub fn parse(value: &str) -> Option<Self> {
+        match value {
+            "waiting_audit" => Some(TopicPhase::WaitingAudit),
+            "approved" => Some(TopicPhase::Approved),
+            "rejected" => Some(TopicPhase::Rejected),
+            "open" => Some(TopicPhase::Open),
+            "closed" => Some(TopicPhase::Closed),
+            _ => None,
+        }
+    }
+}
+
+#[derive(thiserror::Error, Debug)]
+pub enum EventBusError {
+    #[error("serde JSON error: {0}")]
+    SerdeJson(#[from] serde_json::Error),
+    #[error("nats publish error: {0}")]
+    Nats(#[from] async_nats::PublishError),
+    #[cfg(feature = "kafka")]
+    #[error("kafka error: {0}")]
+    Kafka(#[from] rskafka::client::error::Error),
+    #[error("event backend {0:?} is not compiled in")]
+    Unsupported(EventBackend),
+}
+
+/// Publishes domain events for downstream consumers. Publishing is best
+/// effort, a failing bus never fails the request that produced the event.
+#[derive(Clone)]
+pub enum EventBus {
+    Disabled,
+    Nats {
+        client: async_nats::Client,
+        subject_prefix: String,
+    },
+    #[cfg(feature = "kafka")]
+    Kafka {
+        client: std::sync::Arc<rskafka::client::partition::PartitionClient>,
+    },
+}
+
+impl EventBus {
+    pub async fn connect(
+        config: &EventsConfig,
+        nats: &async_nats::Client,
+    ) -> Result<Self, EventBusError> {
+        if !config.enabled {
+            return Ok(EventBus::Disabled);
+        }
+
+        match config.backend {
+            EventBackend::Nats => Ok(EventBus::Nats {
+                client: nats.clone(),
+                subject_prefix: config.subject_prefix.clone(),
+            }),
+            #[cfg(feature = "kafka")]
+            EventBackend::Kafka => {
+                use rskafka::client::{ClientBuilder, partition::UnknownTopicHandling};
+
+                let client = ClientBuilder::new(config.kafka.brokers.clone())
+                    .build()
+                    .await?;
+                let partition = client
+                    .partition_client(
+                        config.kafka.topic.clone(),
+                        config.kafka.partition,
+                        UnknownTopicHandling::Retry,
+                    )
+                    .await?;
+
+                Ok(EventBus::Kafka {
+                    client: std::sync::Arc::new(partition),
+                })
+            }
+            #[cfg(not(feature = "kafka"))]
+            EventBackend::Kafka => Err(EventBusError::Unsupported(EventBackend::Kafka)),
+        }
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        !matches!(self, EventBus::Disabled)
+    }
+
+    pub async fn publish(&self, event: DomainEvent) {
+        if let Err(e) = self.try_publish(&[event]).await {
+            tracing::warn!("failed to publish domain event: {}", e);
+        }
+    }
+
+    pub async fn publish_all(&self, events: Vec<DomainEvent>) {
+        if events.is_empty() {
+            return;
+        }
+        if let Err(e) = self.try_publish(&events).await {
+            tracing::warn!("failed to publish {} domain events: {}", events.len(), e);
+        }
+    }
+
+    /// Publishes or fails, for callers which keep the events until they are
+    /// out such as the outbox relay.
+    pub async fn try_publish(&self, events: &[DomainEvent]) -> Result<(), EventBusError> {
+        match self {
+            EventBus::Disabled => Ok(()),
+            EventBus::Nats {
+                client,
+                subject_prefix,
+            } => {
+                for event in events {
+                    // ark-vote.events.{event}.{topic} so consumers can filter by either
+                    let subject = format!(
+                        "{subject_prefix}.{}.{}",
+                        event.kind.name(),
+                        event.kind.topic_id()
+                    );
+                    client
+                        .publish(subject, serde_json::to_vec(event)?.into())
+                        .await?;
+                }
+                Ok(())
+            }
+            #[cfg(feature = "kafka")]
+            EventBus::Kafka { client } => {
+                use rskafka::{client::partition::Compression, record::Record};
+
+                let mut records = Vec::with_capacity(events.len());
+                for event in events {
+                    records.push(Record {
+                        key: Some(event.kind.topic_id().as_bytes().to_vec()),
+                        value: Some(serde_json::to_vec(event)?),
+                        headers: [("type".to_string(), event.kind.name().as_bytes().to_vec())]
+                            .into(),
+                        timestamp: event.occurred_at,
+                    });
+                }
+                client.produce(records, Compression::NoCompression).await?;
+                Ok(())
+            }
+        }
+    }
+}
@@ -0,0 +1,194 @@
+//! Lookup of the region of an address in an IP to location CSV in the
+//! layout of the DB-IP lite databases: `start,end,country` per row, or
+//! `start,end,continent,country,province,...` for the city edition. Rows of
+//! IPv4 and IPv6 ranges may be mixed.
+
+use std::{
+    collections::HashMap,
+    net::{IpAddr, Ipv6Addr},
+    sync::Arc,
+};
+
+/// Country code the databases use for unknown and reserved ranges.
+pub const UNKNOWN_COUNTRY: &str = "ZZ";
+
+/// IPv4 as mapped into IPv6, so both share one ordering.
+fn to_u128(ip: IpAddr) -> u128 {
+    match ip {
+        IpAddr::V4(ip) => u128::from(ip.to_ipv6_mapped()),
+        IpAddr::V6(ip) => u128::from(ip),
+    }
+}
+
+/// The fields of a row, double quoted ones may contain commas and `""`.
+fn split_row(line: &str) -> Vec<String> {
+    let mut fields = Vec::new();
+    let mut field = String::new();
+    let mut quoted = false;
+    let mut chars = line.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '"' if quoted && chars.peek() == Some(&'"') => {
+                field.push('"');
+                chars.next();
+            }
+            '"' => quoted = !quoted,
+            ',' if !quoted => fields.push(std::mem::take(&mut field)),
+            c => field.push(c),
+        }
+    }
+    fields.push(field);
+    fields
+}
+
+/// The region key of a row, `CN` or `CN:Guangdong`.
+fn region_key(fields: &[String]) -> Option<String> {
+    let (country, province) = match fields.len() {
+        3 => (fields[2].trim(), ""),
+        n if n >= 5 => (fields[3].trim(), fields[4].trim()),
+        _ => return None,
+    };
+    let country = match country {
+        "" | "-" => UNKNOWN_COUNTRY,
+        country => country,
+    };
+    Some(match province {
+        "" | "-" => country.to_string(),
+        province => format!("{country}:{province}"),
+    })
+}
+
+/// The ranges of a database, immutable once parsed. Reloading the file
+/// parses a new one.
+#[derive(Default)]
+pub struct GeoIpDatabase {
+    /// First and last address and the index of the region, sorted and
+    /// without overlaps.
+    ranges: Vec<(u128, u128, u32)>,
+    regions: Vec<Arc<str>>,
+}
+
+impl GeoIpDatabase {
+    /// Fails on the first row which is not a range, a truncated download
+    /// should not replace a working database.
+    pub fn parse(csv: &str) -> Result<Self, String> {
+        let mut interned: HashMap<String, u32> = HashMap::new();
+        let mut regions: Vec<Arc<str>> = Vec::new();
+        let mut ranges = Vec::new();
+        for (number, line) in csv.lines().enumerate() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let fields = split_row(line);
+            let parsed = (
+                fields
+                    .first()
+                    .and_then(|ip| ip.trim().parse::<IpAddr>().ok()),
+                fields
+                    .get(1)
+                    .and_then(|ip| ip.trim().parse::<IpAddr>().ok()),
+                region_key(&fields),
+            );
+            let (Some(start), Some(end), Some(key)) = parsed else {
+                return Err(format!("line {} is not a range", number + 1));
+            };
+            let (start, end) = (to_u128(start), to_u128(end));
+            if start > end {
+                return Err(format!("line {} ends before it starts", number + 1));
+            }
+            let region = match interned.get(&key) {
+                Some(&region) => region,
+                None => {
+                    let region = regions.len() as u32;
+                    regions.push(Arc::from(key.as_str()));
+                    interned.insert(key, region);
+                    region
+                }
+            };
+            ranges.push((start, end, region));
+        }
+
+        ranges.sort_unstable_by_key(|&(start, _, _)| start);
+        let mut merged: Vec<(u128, u128, u32)> = Vec::with_capacity(ranges.len());
+        for (start, end, region) in ranges {
+            match merged.last_mut() {
+                Some(last) if start <= last.1 => {
+                    return Err(format!(
+                        "the ranges at {} overlap",
+                        Ipv6Addr::from(start).to_canonical()
+                    ));
+                }
+                // neighbours in the same region are one range, the city
+                // edition splits provinces into many
+                Some(last) if last.2 == region && last.1 + 1 == start => last.1 = end,
+                _ => merged.push((start, end, region)),
+            }
+        }
+        merged.shrink_to_fit();
+
+        Ok(Self {
+            ranges: merged,
+            regions,
+        })
+    }
+
+    /// The region key of `ip`, `CN` or `CN:Guangdong`.
+    pub fn lookup(&self, ip: IpAddr) -> Option<&Arc<str>> {
+        let ip = to_u128(ip.to_canonical());
+        let index = self.ranges.partition_point(|&(start, _, _)| start <= ip);
+        let &(_, end, region) = self.ranges.get(index.checked_sub(1)?)?;
+        (ip <= end).then(|| &self.regions[region as usize])
+    }
+
+    /// Ranges after merging neighbours.
+    pub fn len(&self) -> usize {
+        self.ranges.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.ranges.is_empty()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn lookup(database: &GeoIpDatabase, ip: &str) -> Option<String> {
+        database
+            .lookup(ip.parse().unwrap())
+            .map(|region| region.to_string())
+    }
+
+    #[test]
+    fn ranges_are_found_by_address() {
+        let database = GeoIpDatabase::parse(
+            "1.0.0.0,1.0.0.255,OC,AU,Queensland,\"South Brisbane\",-27.4,153.0\n\
+             1.0.1.0,1.0.1.255,AS,CN,Fujian,Fuzhou,26.0,119.3\n\
+             1.0.2.0,1.0.3.255,AS,CN,Fujian,\"Xiamen, Siming\",24.4,118.0\n\
+             10.0.0.0,10.255.255.255,ZZ,ZZ,-,-,0,0\n\
+             2001:db8::,2001:db8::ffff,EU,DE,,,0,0\n",
+        )
+        .unwrap();
+        // the two Fujian ranges are one
+        assert_eq!(database.len(), 4);
+
+        assert_eq!(
+            lookup(&database, "1.0.0.7").as_deref(),
+            Some("AU:Queensland")
+        );
+        assert_eq!(lookup(&database, "1.0.3.1").as_deref(), Some("CN:Fujian"));
+        assert_eq!(
+            lookup(&database, "::ffff:1.0.1.1").as_deref(),
+            Some("CN:Fujian")
+        );
+        assert_eq!(lookup(&database, "10.1.2.3").as_deref(), Some("ZZ"));
+        assert_eq!(lookup(&database, "2001:db8::1").as_deref(), Some("DE"));
+        assert_eq!(lookup(&database, "1.0.4.0"), None);
+        assert_eq!(lookup(&database, "0.255.255.255"), None);
+
+        assert!(GeoIpDatabase::parse("1.0.0.0,1.0.0.255,AU\n1.0.0.9,1.0.1.0,CN\n").is_err());
+        assert!(GeoIpDatabase::parse("1.0.0.0,AU\n").is_err());
+    }
+}
@@ -0,0 +1,86 @@
+use std::{collections::HashMap, sync::Arc, time::Duration};
+
+use parking_lot::Mutex;
+use reqwest::{IntoUrl, RequestBuilder, Response};
+use tokio::sync::Semaphore;
+
+use crate::config::HttpClientConfig;
+
+/// The client of every outbound call. Clones share the connection pool and
+/// the per-host limits, so it is built once per process and handed to the
+/// services.
+#[derive(Clone)]
+pub struct HttpClient {
+    client: reqwest::Client,
+    hosts: Arc<Mutex<HashMap<String, Arc<Semaphore>>>>,
+    max_per_host: usize,
+}
+
+impl HttpClient {
+    pub fn new(config: &HttpClientConfig) -> Result<Self, reqwest::Error> {
+        let mut builder = reqwest::Client::builder()
+            .connect_timeout(Duration::from_millis(config.connect_timeout_ms))
+            .timeout(Duration::from_millis(config.timeout_ms))
+            .pool_idle_timeout(Duration::from_secs(config.pool_idle_timeout_secs))
+            .pool_max_idle_per_host(config.pool_max_idle_per_host)
+            .user_agent(&config.user_agent);
+        if let Some(proxy) = &config.proxy {
+            builder = builder.proxy(reqwest::Proxy::all(proxy)?);
+        }
+
+        Ok(Self {
+            client: builder.build()?,
+            hosts: Arc::new(Mutex::new(HashMap::new())),
+            max_per_host: config.max_per_host,
+        })
+    }
+
+    pub fn get(&self, url: impl IntoUrl) -> RequestBuilder {
+        self.client.get(url)
+    }
+
+    pub fn post(&self, url: impl IntoUrl) -> RequestBuilder {
+        self.client.post(url)
+    }
+
+    /// Runs in a span of its own which the callee continues through the
+    /// `traceparent` header.
+    #[tracing::instrument(
+        name = "http.client",
+        skip_all,
+        fields(otel.kind = "client", method = %request.method(), url = %request.url()),
+    )]
+    async fn execute(
+        &self,
+        client: reqwest::Client,
+        mut request: reqwest::Request,
+    ) -> Result<Response, reqwest::Error> {
+        crate::tracing::inject_current_context(request.headers_mut());
+        client.execute(request).await
+    }
+
+    /// Sends a request built from [`Self::get`] or [`Self::post`] once its
+    /// host has a free slot. The slot is held until the response headers
+    /// arrive, reading the body does not count against the limit.
+    pub async fn send(&self, request: RequestBuilder) -> Result<Response, reqwest::Error> {
+        let (client, request) = request.build_split();
+        let request = request?;
+
+        let host_slots = match request.url().host_str() {
+            Some(host) if self.max_per_host > 0 => Some(
+                self.hosts
+                    .lock()
+                    .entry(host.to_string())
+                    .or_insert_with(|| Arc::new(Semaphore::new(self.max_per_host)))
+                    .clone(),
+            ),
+            _ => None,
+        };
+        let _slot = match &host_slots {
+            Some(slots) => Some(slots.acquire().await.expect("host slots are never closed")),
+            None => None,
+        };
+
+        self.execute(client, request).await
+    }
+}
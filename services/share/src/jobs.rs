@@ -0,0 +1,252 @@
+use std::{collections::HashMap, future::Future, sync::Arc, time::Duration};
+
+use chrono::{DateTime, Utc};
+use parking_lot::Mutex;
+use serde::Serialize;
+use tokio::{sync::watch, task::JoinHandle};
+use utoipa::ToSchema;
+
+/// When a job is stopped on shutdown, the stages stop one after another in
+/// this order.
+#[derive(Copy, Clone, Debug, PartialEq, Eq, PartialOrd, Ord, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Stage {
+    /// Starts new work, e.g. the phase watcher and the syncs.
+    Scheduler,
+    /// Keeps state fresh, e.g. caches and heartbeats.
+    Worker,
+    /// Writes buffered work out, stopped last so it sees everything the
+    /// others produced.
+    Flusher,
+}
+
+/// Resolves once the job is asked to stop, for jobs spawned with
+/// [`JobManager::spawn_graceful`].
+pub struct Stopping(watch::Receiver<bool>);
+
+impl Stopping {
+    pub async fn wait(&mut self) {
+        let _ = self.0.wait_for(|stopping| *stopping).await;
+    }
+}
+
+/// One pass of a recurring job, see [`JobManager::record_run`].
+#[derive(Clone, Debug, Serialize, ToSchema)]
+pub struct JobRun {
+    pub started_at: DateTime<Utc>,
+    pub duration_ms: u64,
+    pub success: bool,
+    pub error: Option<String>,
+}
+
+#[derive(Clone, Debug, Default)]
+struct JobStatus {
+    last_run: Option<JobRun>,
+    next_run: Option<DateTime<Utc>>,
+}
+
+#[derive(Clone, Debug, Serialize, ToSchema)]
+pub struct JobInfo {
+    #[schema(value_type = String)]
+    pub name: &'static str,
+    pub stage: Stage,
+    pub running: bool,
+    /// Only for recurring jobs which report their runs.
+    pub last_run: Option<JobRun>,
+    pub next_run: Option<DateTime<Utc>>,
+}
+
+struct Job {
+    name: &'static str,
+    stage: Stage,
+    stop: watch::Sender<bool>,
+    handle: JoinHandle<Option<()>>,
+}
+
+/// Owns the background loops of a service so shutdown stops them in order
+/// instead of the runtime dropping them wherever they are.
+#[derive(Clone, Default)]
+pub struct JobManager {
+    jobs: Arc<Mutex<Vec<Job>>>,
+    /// By job name, kept apart from `jobs` as a job may report before it is
+    /// pushed there.
+    status: Arc<Mutex<HashMap<&'static str, JobStatus>>>,
+}
+
+impl JobManager {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Runs `job` until it returns or shutdown reaches its stage, it is
+    /// dropped at whatever it awaits then.
+    pub fn spawn<F>(&self, name: &'static str, stage: Stage, job: F)
+    where
+        F: Future<Output = ()> + Send + 'static,
+    {
+        self.spawn_graceful(name, stage, |mut stopping| async move {
+            tokio::select! {
+                _ = job => {}
+                _ = stopping.wait() => {}
+            }
+        });
+    }
+
+    /// Like [`JobManager::spawn`], for jobs that finish their current work
+    /// and return themselves once [`Stopping`] resolves.
+    pub fn spawn_graceful<F, Fut>(&self, name: &'static str, stage: Stage, job: F)
+    where
+        F: FnOnce(Stopping) -> Fut,
+        Fut: Future<Output = ()> + Send + 'static,
+    {
+        let (stop, stopping) = watch::channel(false);
+        let job = job(Stopping(stopping));
+
+        let handle = crate::task::spawn(name, async move {
+            job.await;
+            tracing::debug!(job = name, "background job finished");
+        });
+
+        self.jobs.lock().push(Job {
+            name,
+            stage,
+            stop,
+            handle,
+        });
+        tracing::debug!(job = name, ?stage, "background job started");
+    }
+
+    /// Records a finished pass of the recurring job `name`.
+    pub fn record_run(&self, name: &'static str, run: JobRun) {
+        let outcome = if run.success { "success" } else { "failure" };
+        metrics::counter!("job_runs_total", "job" => name, "outcome" => outcome).increment(1);
+        metrics::histogram!("job_run_duration_seconds", "job" => name)
+            .record(run.duration_ms as f64 / 1000.0);
+
+        self.status.lock().entry(name).or_default().last_run = Some(run);
+    }
+
+    /// When the recurring job `name` runs next, `None` once it won't.
+    pub fn set_next_run(&self, name: &'static str, at: Option<DateTime<Utc>>) {
+        self.status.lock().entry(name).or_default().next_run = at;
+    }
+
+    pub fn list(&self) -> Vec<JobInfo> {
+        let status = self.status.lock();
+        self.jobs
+            .lock()
+            .iter()
+            .map(|job| {
+                let status = status.get(job.name).cloned().unwrap_or_default();
+                JobInfo {
+                    name: job.name,
+                    stage: job.stage,
+                    running: !job.handle.is_finished(),
+                    last_run: status.last_run,
+                    next_run: status.next_run,
+                }
+            })
+            .collect()
+    }
+
+    /// Stops the jobs stage by stage, each stage gets `timeout` before its
+    /// remaining jobs are aborted.
+    pub async fn shutdown(&self, timeout: Duration) {
+        let mut jobs = std::mem::take(&mut *self.jobs.lock());
+
+        for stage in [Stage::Scheduler, Stage::Worker, Stage::Flusher] {
+            let (stopping, rest): (Vec<_>, Vec<_>) =
+                jobs.into_iter().partition(|job| job.stage == stage);
+            jobs = rest;
+            for job in &stopping {
+                let _ = job.stop.send(true);
+            }
+
+            let deadline = tokio::time::Instant::now() + timeout;
+            for mut job in stopping {
+                if tokio::time::timeout_at(deadline, &mut job.handle)
+                    .await
+                    .is_err()
+                {
+                    tracing::warn!(job = job.name, "background job did not stop, aborting");
+                    job.handle.abort();
+                }
+            }
+        }
+    }
+}
+
+/// Takes `slot` of the recurring job `name` unless another instance did, for
+/// work done once per slot across all instances. The claim expires after
+/// `ttl`, so a skewed clock can at worst repeat a run, not lose one.
+pub async fn claim_slot(
+    redis: &mut redis::aio::MultiplexedConnection,
+    name: &str,
+    slot: DateTime<Utc>,
+    ttl: Duration,
+) -> redis::RedisResult<bool> {
+    let claimed: Option<String> = redis::cmd("SET")
+        .arg(format!("scheduler:{}:{}", name, slot.timestamp()))
+        .arg(std::process::id())
+        .arg("NX")
+        .arg("EX")
+        .arg(ttl.as_secs().max(1))
+        .query_async(redis)
+        .await?;
+    Ok(claimed.is_some())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn stages_stop_in_order() {
+        let jobs = JobManager::new();
+        let (order_tx, mut order_rx) = tokio::sync::mpsc::unbounded_channel();
+
+        let flusher = order_tx.clone();
+        jobs.spawn_graceful("flusher", Stage::Flusher, |mut stopping| async move {
+            stopping.wait().await;
+            flusher.send("flusher").unwrap();
+        });
+        jobs.spawn_graceful("scheduler", Stage::Scheduler, |mut stopping| async move {
+            stopping.wait().await;
+            order_tx.send("scheduler").unwrap();
+        });
+        jobs.spawn("stuck", Stage::Worker, std::future::pending());
+
+        jobs.shutdown(Duration::from_secs(1)).await;
+        assert_eq!(order_rx.recv().await, Some("scheduler"));
+        assert_eq!(order_rx.recv().await, Some("flusher"));
+        assert!(jobs.list().is_empty());
+    }
+
+    #[tokio::test]
+    async fn list_reports_recorded_runs() {
+        let jobs = JobManager::new();
+        jobs.spawn("prune", Stage::Scheduler, std::future::pending());
+        jobs.spawn("cache", Stage::Worker, std::future::pending());
+
+        let next = Utc::now() + chrono::Duration::hours(1);
+        jobs.set_next_run("prune", Some(next));
+        jobs.record_run(
+            "prune",
+            JobRun {
+                started_at: Utc::now(),
+                duration_ms: 12,
+                success: false,
+                error: Some("timed out".to_string()),
+            },
+        );
+
+        let list = jobs.list();
+        let prune = list.iter().find(|job| job.name == "prune").unwrap();
+        assert_eq!(prune.next_run, Some(next));
+        assert!(prune.last_run.as_ref().is_some_and(|run| !run.success));
+        let cache = list.iter().find(|job| job.name == "cache").unwrap();
+        assert!(cache.last_run.is_none() && cache.next_run.is_none());
+
+        jobs.shutdown(Duration::from_secs(1)).await;
+    }
+}
@@ -1,5 +1,22 @@
+pub mod alert;
+pub mod build_info;
+pub mod client_ip;
 pub mod config;
+pub mod counter;
+pub mod events;
+pub mod geoip;
+pub mod http_client;
+pub mod jobs;
+pub mod merkle;
 pub mod models;
+pub mod profile;
+pub mod redact;
+pub mod reload;
+pub mod secrets;
 pub mod signal;
 pub mod snowflake;
+pub mod systemd;
+pub mod task;
 pub mod tracing;
+pub mod validate;
+pub mod word_filter;
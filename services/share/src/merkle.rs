@@ -0,0 +1,103 @@
+//! Merkle tree over the accepted ballots of a topic. Leaves and inner nodes
+//! are hashed with distinct prefixes so a node can't pass for a leaf, and an
+//! odd node is carried up a level as is rather than paired with itself.
+
+use serde::Serialize;
+use sha2::{Digest as _, Sha256};
+use utoipa::ToSchema;
+
+pub type Hash = [u8; 32];
+
+pub fn leaf_hash(content: &[u8]) -> Hash {
+    let mut hasher = Sha256::new();
+    hasher.update([0x00]);
+    hasher.update(content);
+    hasher.finalize().into()
+}
+
+fn node_hash(left: &Hash, right: &Hash) -> Hash {
+    let mut hasher = Sha256::new();
+    hasher.update([0x01]);
+    hasher.update(left);
+    hasher.update(right);
+    hasher.finalize().into()
+}
+
+/// Which side of the path a sibling hash goes on.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Side {
+    Left,
+    Right,
+}
+
+fn next_level(level: &[Hash]) -> Vec<Hash> {
+    level
+        .chunks(2)
+        .map(|pair| match pair {
+            [left, right] => node_hash(left, right),
+            [single] => *single,
+            _ => unreachable!(),
+        })
+        .collect()
+}
+
+/// Root of the leaves in their order, the hash of no input for none.
+pub fn root(leaves: &[Hash]) -> Hash {
+    if leaves.is_empty() {
+        return Sha256::digest([]).into();
+    }
+    let mut level = leaves.to_vec();
+    while level.len() > 1 {
+        level = next_level(&level);
+    }
+    level[0]
+}
+
+/// Sibling hashes from leaf `index` up to the root.
+pub fn proof(leaves: &[Hash], mut index: usize) -> Vec<(Side, Hash)> {
+    let mut path = Vec::new();
+    let mut level = leaves.to_vec();
+    while level.len() > 1 {
+        let sibling = index ^ 1;
+        if let Some(hash) = level.get(sibling) {
+            let side = match sibling < index {
+                true => Side::Left,
+                false => Side::Right,
+            };
+            path.push((side, *hash));
+        }
+        level = next_level(&level);
+        index /= 2;
+    }
+    path
+}
+
+/// What a third party runs on a proof.
+pub fn verify(leaf: &Hash, path: &[(Side, Hash)], root: &Hash) -> bool {
+    let computed = path.iter().fold(*leaf, |hash, (side, sibling)| match side {
+        Side::Left => node_hash(sibling, &hash),
+        Side::Right => node_hash(&hash, sibling),
+    });
+    &computed == root
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn every_leaf_proves_against_the_root() {
+        for count in 1..=9u8 {
+            let leaves: Vec<Hash> = (0..count).map(|i| leaf_hash(&[i])).collect();
+            let root = root(&leaves);
+            for (index, leaf) in leaves.iter().enumerate() {
+                assert!(
+                    verify(leaf, &proof(&leaves, index), &root),
+                    "{count}/{index}"
+                );
+            }
+            assert!(!verify(&leaf_hash(b"forged"), &proof(&leaves, 0), &root));
+        }
+    }
+}
@@ -1,15 +1,29 @@
 use std::{collections::HashMap, fmt};
 
-use chrono::{DateTime, Utc};
+use chrono::{DateTime, NaiveDate, Utc};
 use serde::{Deserialize, Serialize};
-use utoipa::ToSchema;
+use utoipa::{IntoParams, ToSchema};
 
-use crate::models::{
-    candidate_pool_preset::CandidatePoolPreset,
-    database::{TopicAuditInfo, VotingTopic},
+use crate::{
+    jobs::JobInfo,
+    merkle::Side,
+    models::{
+        candidate_pool_preset::CandidatePoolPreset,
+        database::{
+            ApiKeyScope, QueuedTask, TaskPriority, TopicAuditInfo, VotingTopic, WebhookDelivery,
+            WebhookEvent,
+        },
+        excel::{CharacterInfo, Language, OperatorNames, ProfessionCategory, RarityRank},
+    },
+};
+
+use super::database::{
+    Announcement, AnnouncementSeverity, CommentStatus, ContentReport, CreateTopicStatus,
+    DailyDigest, MerkleCommitment, OperatorAlias, PublishedDataset, ReportReason, ReportStatus,
+    ReportTargetKind, TopicBookmark, TopicComment, VotingTopicType,
 };
 
-use super::database::{CreateTopicStatus, VotingTopicType};
+pub mod v2;
 
 #[derive(Debug, Deserialize, Serialize, ToSchema)]
 pub enum ApiMsg {
@@ -30,6 +44,53 @@ pub enum ApiMsg {
     BallotNotFound,
     InvalidBallotCode(String),
     EndpointForbidden,
+    ApiKeyMissing,
+    ApiKeyInvalid,
+    ApiKeyScopeForbidden,
+    ApiKeyRateLimited,
+    ApiKeyAlreadyExists,
+    ApiKeyNotFound,
+    SignatureRequired,
+    SignatureInvalid,
+    SignatureExpired,
+    NonceReused,
+    RequestBodyTooLarge,
+    AuditLogExportUnavailable,
+    InvalidTimeRange,
+    BallotNonceInvalid,
+    InvalidIpAddress,
+    DatasetNotFound,
+    MerkleCommitmentNotFound,
+    BallotNotCommitted,
+    VoterExportNotFound,
+    VoterExportPending,
+    ConsentRequired,
+    ConsentVersionOutdated,
+    CommentsDisabled,
+    CommentNotFound,
+    CommentInvalid(String),
+    CommentDeleteTokenInvalid,
+    ReportTargetNotFound,
+    ReportNotFound,
+    ReportDetailTooLong(usize),
+    ContentRejected(String),
+    AnnouncementNotFound,
+    AnnouncementInvalid(String),
+    InvalidRanking,
+    BookmarkNotFound,
+    BookmarkInvalid(String),
+    WebhookNotFound,
+    WebhookInvalidUrl,
+    QueuedTaskNotFound,
+    OptionNotInCandidatePool,
+    OptionImageInvalid(String),
+    OptionImageNotFound,
+    OperatorNotFound,
+    OperatorAliasConflict(i32),
+    OperatorAliasNotFound,
+    ServiceOverloaded,
+    UnderMaintenance,
+    RateLimited,
     Error(String),
 }
 
@@ -61,6 +122,70 @@ impl fmt::Display for ApiMsg {
             ApiMsg::BallotNotFound => write!(f, "Ballot not found"),
             ApiMsg::InvalidBallotCode(msg) => write!(f, "{}", msg),
             ApiMsg::EndpointForbidden => write!(f, "Endpoint forbidden"),
+            ApiMsg::ApiKeyMissing => write!(f, "API key is required"),
+            ApiMsg::ApiKeyInvalid => write!(f, "API key is invalid or disabled"),
+            ApiMsg::ApiKeyScopeForbidden => write!(f, "API key does not have the required scope"),
+            ApiMsg::ApiKeyRateLimited => write!(f, "API key rate limit exceeded"),
+            ApiMsg::ApiKeyAlreadyExists => write!(f, "API key with this name already exists"),
+            ApiMsg::ApiKeyNotFound => write!(f, "API key not found"),
+            ApiMsg::SignatureRequired => write!(f, "Admin requests must be signed"),
+            ApiMsg::SignatureInvalid => write!(f, "Request signature is missing or invalid"),
+            ApiMsg::SignatureExpired => {
+                write!(f, "Request timestamp is outside the allowed window")
+            }
+            ApiMsg::NonceReused => write!(f, "Request nonce was already used"),
+            ApiMsg::RequestBodyTooLarge => write!(f, "Request body is too large"),
+            ApiMsg::AuditLogExportUnavailable => {
+                write!(f, "Audit log export needs audit_log.export_signing_key")
+            }
+            ApiMsg::InvalidTimeRange => write!(f, "Time range must start before it ends"),
+            ApiMsg::BallotNonceInvalid => write!(f, "Ballot nonce is missing or already used"),
+            ApiMsg::InvalidIpAddress => write!(f, "Not an ip address"),
+            ApiMsg::DatasetNotFound => write!(f, "No dataset is published for this topic"),
+            ApiMsg::MerkleCommitmentNotFound => write!(f, "No merkle root is committed yet"),
+            ApiMsg::BallotNotCommitted => {
+                write!(
+                    f,
+                    "Ballot is not accepted or not part of the latest merkle root"
+                )
+            }
+            ApiMsg::VoterExportNotFound => write!(f, "Export not found or expired"),
+            ApiMsg::VoterExportPending => write!(f, "Export is still being assembled"),
+            ApiMsg::ConsentRequired => write!(f, "The current terms have to be accepted first"),
+            ApiMsg::ConsentVersionOutdated => write!(f, "Not the current version of the terms"),
+            ApiMsg::CommentsDisabled => write!(f, "Comments are disabled"),
+            ApiMsg::CommentNotFound => write!(f, "Comment not found"),
+            ApiMsg::CommentInvalid(msg) => write!(f, "Invalid comment: {}", msg),
+            ApiMsg::CommentDeleteTokenInvalid => write!(f, "Delete token does not match"),
+            ApiMsg::ReportTargetNotFound => write!(f, "Reported content not found"),
+            ApiMsg::ReportNotFound => write!(f, "No open report of this content"),
+            ApiMsg::ReportDetailTooLong(max) => {
+                write!(f, "Report detail is over {} characters", max)
+            }
+            ApiMsg::ContentRejected(field) => {
+                write!(f, "The {} contains words that are not allowed", field)
+            }
+            ApiMsg::AnnouncementNotFound => write!(f, "Announcement not found"),
+            ApiMsg::AnnouncementInvalid(msg) => write!(f, "Invalid announcement: {}", msg),
+            ApiMsg::InvalidRanking => write!(f, "Ranking must be comma separated option ids"),
+            ApiMsg::BookmarkNotFound => write!(f, "Bookmark not found"),
+            ApiMsg::BookmarkInvalid(msg) => write!(f, "Invalid bookmark: {}", msg),
+            ApiMsg::WebhookNotFound => write!(f, "Webhook not found"),
+            ApiMsg::WebhookInvalidUrl => write!(f, "Webhook url must be an absolute http(s) url"),
+            ApiMsg::QueuedTaskNotFound => write!(f, "Queued task not found"),
+            ApiMsg::OptionNotInCandidatePool => {
+                write!(f, "Option is not in the candidate pool of the topic")
+            }
+            ApiMsg::OptionImageInvalid(msg) => write!(f, "Invalid option image: {}", msg),
+            ApiMsg::OptionImageNotFound => write!(f, "Option image not found"),
+            ApiMsg::OperatorNotFound => write!(f, "Operator not found"),
+            ApiMsg::OperatorAliasConflict(id) => {
+                write!(f, "Alias already refers to operator {}", id)
+            }
+            ApiMsg::OperatorAliasNotFound => write!(f, "Operator alias not found"),
+            ApiMsg::ServiceOverloaded => write!(f, "Service is overloaded, retry later"),
+            ApiMsg::UnderMaintenance => write!(f, "Service is under maintenance, voting is paused"),
+            ApiMsg::RateLimited => write!(f, "Too many requests, retry later"),
             ApiMsg::Error(msg) => write!(f, "{}", msg),
         }
     }
@@ -94,8 +219,13 @@ impl axum::response::IntoResponse for ApiResponse<()> {
     fn into_response(self) -> axum::response::Response {
         let status = match self.status {
             0 => axum::http::StatusCode::OK,
+            401 => axum::http::StatusCode::UNAUTHORIZED,
+            403 => axum::http::StatusCode::FORBIDDEN,
             404 => axum::http::StatusCode::NOT_FOUND,
+            413 => axum::http::StatusCode::PAYLOAD_TOO_LARGE,
+            429 => axum::http::StatusCode::TOO_MANY_REQUESTS,
             500 => axum::http::StatusCode::INTERNAL_SERVER_ERROR,
+            503 => axum::http::StatusCode::SERVICE_UNAVAILABLE,
             _ if self.status < 500 => axum::http::StatusCode::BAD_REQUEST,
             _ => axum::http::StatusCode::INTERNAL_SERVER_ERROR,
         };
@@ -116,6 +246,9 @@ pub enum BallotCreateResponse {
         ballot_id: String,
         left: i32,
         right: i32,
+        /// Sent back with the vote, each nonce is accepted once.
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        nonce: Option<String>,
     },
     Setwise {
         topic_id: String,
@@ -159,6 +292,10 @@ pub struct PairwiseSaveScore {
     pub ballot_id: String,
     pub winner: i32,
     pub loser: i32,
+    /// The nonce of the ballot, needed when one was issued with it. A
+    /// replayed vote finds it spent.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub nonce: Option<String>,
 }
 
 #[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
@@ -235,6 +372,107 @@ pub struct FinalOrderItem {
 #[derive(Debug, Deserialize, Serialize, ToSchema)]
 pub struct ResultsFinalOrderRequest {
     pub topic_id: String,
+    /// Language of the operator names, CN when omitted.
+    #[serde(default)]
+    pub lang: Language,
+    #[serde(default)]
+    pub filter: Option<ResultsFilter>,
+    #[serde(default)]
+    pub group_by: Option<ResultsGroupBy>,
+}
+
+/// Restricts results to matching operators, unset fields match everything.
+#[derive(Debug, Clone, Default, Deserialize, Serialize, ToSchema)]
+pub struct ResultsFilter {
+    pub rarities: Option<Vec<RarityRank>>,
+    pub professions: Option<Vec<ProfessionCategory>>,
+    pub factions: Option<Vec<String>>,
+}
+
+impl ResultsFilter {
+    pub fn matches(&self, info: &CharacterInfo) -> bool {
+        self.rarities
+            .as_ref()
+            .is_none_or(|rarities| info.matches_rarities(rarities))
+            && self
+                .professions
+                .as_ref()
+                .is_none_or(|professions| info.matches_professions(professions))
+            && self
+                .factions
+                .as_ref()
+                .is_none_or(|factions| info.matches_factions(factions))
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Deserialize, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ResultsGroupBy {
+    Rarity,
+    Profession,
+    Faction,
+}
+
+impl ResultsGroupBy {
+    /// `6` for rarities, `WARRIOR` for professions, `none` for operators
+    /// without a faction.
+    pub fn key_of(&self, info: &CharacterInfo) -> String {
+        match self {
+            ResultsGroupBy::Rarity => info.rarity.to_numeric().to_string(),
+            ResultsGroupBy::Profession => format!("{:?}", info.profession),
+            ResultsGroupBy::Faction => info.faction.clone().unwrap_or_else(|| "none".to_string()),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Deserialize, Serialize, ToSchema)]
+pub struct ResultsAggregatesRequest {
+    pub topic_id: String,
+    pub group_by: ResultsGroupBy,
+    #[serde(default)]
+    pub filter: Option<ResultsFilter>,
+    #[serde(default)]
+    pub lang: Language,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsAggregateMember {
+    pub id: i32,
+    pub name: String,
+    /// Win rate in percent.
+    pub rate: f64,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsAggregate {
+    pub key: String,
+    pub operators: usize,
+    pub win: i64,
+    pub lose: i64,
+    /// Mean of the member win rates, every operator weighs the same.
+    pub average_rate: f64,
+    /// Win rate over all votes of the group's operators.
+    pub overall_rate: f64,
+    pub best: ResultsAggregateMember,
+    pub worst: ResultsAggregateMember,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsAggregatesResponse {
+    pub topic_id: String,
+    pub group_by: ResultsGroupBy,
+    /// Highest average win rate first.
+    pub groups: Vec<ResultsAggregate>,
+    pub count: i64,
+}
+
+/// Operators of one group in final order, with their summed votes.
+#[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsGroup {
+    pub key: String,
+    pub operator_ids: Vec<i32>,
+    pub win: i64,
+    pub lose: i64,
 }
 
 #[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
@@ -242,11 +480,73 @@ pub struct ResultsFinalOrderResponse {
     pub topic_id: String,
     pub items: Vec<FinalOrderItem>,
     pub count: i64,
+    /// Only present when `group_by` was requested.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub groups: Option<Vec<ResultsGroup>>,
+}
+
+#[derive(Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsDatasetRequest {
+    pub topic_id: String,
+}
+
+#[derive(Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsDatasetResponse {
+    /// CSV with a `window_start,winner,loser,ballots` header.
+    pub url: String,
+    #[serde(flatten)]
+    pub dataset: PublishedDataset,
+}
+
+#[derive(Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsMerkleRootRequest {
+    pub topic_id: String,
+}
+
+#[derive(Debug, Serialize, ToSchema)]
+pub struct ResultsMerkleRootResponse {
+    /// The leaf hashes, rebuilding the tree from them has to give `root`.
+    pub leaves_url: String,
+    #[serde(flatten)]
+    pub commitment: MerkleCommitment,
+}
+
+#[derive(Debug, Deserialize, Serialize, ToSchema)]
+pub struct ResultsMerkleProofRequest {
+    pub topic_id: String,
+    /// As returned when the ballot was saved.
+    pub ballot_id: String,
+}
+
+#[derive(Debug, Serialize, ToSchema)]
+pub struct MerkleProofStep {
+    pub side: Side,
+    /// Hex encoded sibling hash.
+    pub hash: String,
+}
+
+/// Inclusion of one ballot in the latest commitment. `sha256(0x00 || leaf)`
+/// combined with the steps from the bottom up, as `sha256(0x01 || left ||
+/// right)`, gives `root`.
+#[derive(Debug, Serialize, ToSchema)]
+pub struct ResultsMerkleProofResponse {
+    /// The hashed bytes of the leaf, json with sorted keys.
+    pub leaf: String,
+    pub leaf_hash: String,
+    pub index: u64,
+    pub path: Vec<MerkleProofStep>,
+    pub root: String,
+    pub committed_at: DateTime<Utc>,
 }
 
 #[derive(Debug, Deserialize, Serialize, ToSchema)]
 pub struct Results1v1MatrixRequest {
     pub topic_id: String,
+    #[serde(default)]
+    pub filter: Option<ResultsFilter>,
+    /// Sums the matchups of every operator pair into `group:group` keys.
+    #[serde(default)]
+    pub group_by: Option<ResultsGroupBy>,
 }
 
 #[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
@@ -266,6 +566,9 @@ pub struct TopicCreateRequest {
     pub description: String,
     pub topic_type: VotingTopicType,
     pub candidate_pool: CandidatePoolPreset,
+    /// Operators left out of the candidate pool.
+    #[serde(default)]
+    pub excluded_operator_ids: Vec<i32>,
 
     pub open_time: DateTime<Utc>,
     pub close_time: DateTime<Utc>,
@@ -299,6 +602,297 @@ pub struct TopicListActiveResponse {
     pub topic_ids: Vec<String>,
 }
 
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct TopicPresenceRequest {
+    pub topic_id: String,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Hash, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ImageSize {
+    /// 64px on the longer edge.
+    Small,
+    /// 128px on the longer edge.
+    Medium,
+    /// 256px on the longer edge.
+    Large,
+    #[default]
+    Original,
+}
+
+impl ImageSize {
+    pub fn max_edge(&self) -> Option<u32> {
+        match self {
+            ImageSize::Small => Some(64),
+            ImageSize::Medium => Some(128),
+            ImageSize::Large => Some(256),
+            ImageSize::Original => None,
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            ImageSize::Small => "small",
+            ImageSize::Medium => "medium",
+            ImageSize::Large => "large",
+            ImageSize::Original => "original",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct OperatorAvatarQuery {
+    /// Index into the operator's avatars, skins follow the default art.
+    #[serde(default)]
+    pub variant: usize,
+    #[serde(default)]
+    pub size: ImageSize,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct ResultsShareCardQuery {
+    pub topic_id: String,
+    /// Options shown, `share_card.default_top` when omitted.
+    #[serde(default)]
+    pub top: Option<usize>,
+    /// A voter's own order of the options as comma separated ids, shown
+    /// with their win rates instead of the topic's top options.
+    #[serde(default)]
+    pub ranking: Option<String>,
+    #[serde(default)]
+    pub lang: Language,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct EmbedOEmbedQuery {
+    /// A topic page, in the form of `embed.topic_url`.
+    pub url: String,
+    /// Only `json` is served.
+    #[serde(default)]
+    pub format: Option<String>,
+    #[serde(default)]
+    pub maxwidth: Option<u32>,
+    #[serde(default)]
+    pub maxheight: Option<u32>,
+}
+
+/// A `photo` response of the oEmbed spec, the share card of the topic.
+/// Served as is, not wrapped in an `ApiResponse`.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OEmbedResponse {
+    pub version: String,
+    #[serde(rename = "type")]
+    pub kind: String,
+    pub title: String,
+    pub provider_name: String,
+    pub provider_url: String,
+    pub url: String,
+    pub width: u32,
+    pub height: u32,
+    pub cache_age: u64,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct FeedsResultsQuery {
+    /// Language of the option names.
+    #[serde(default)]
+    pub lang: Language,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct AffinityQuery {
+    /// Language of the option names.
+    #[serde(default)]
+    pub lang: Language,
+}
+
+/// A matchup the caller decided against everyone else.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AffinityPick {
+    pub winner_id: i32,
+    pub winner_name: String,
+    pub loser_id: i32,
+    pub loser_name: String,
+    /// Net votes of everyone for the loser over the winner.
+    pub crowd_margin: i64,
+}
+
+/// How the pairwise ballots cast from the calling address compare with the
+/// results of the topic.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AffinityResponse {
+    pub topic_id: String,
+    /// Ballots from the address, at most `affinity.max_ballots`.
+    pub ballots: u64,
+    /// Ballots picking the option everyone else prefers.
+    pub agreed: u64,
+    pub disagreed: u64,
+    /// `agreed` over the ballots of a matchup everyone else did not tie, in
+    /// percent. Unset without such ballots.
+    pub agreement_rate: Option<f64>,
+    /// The matchups with the largest margin against the caller's pick first.
+    pub contrarian_picks: Vec<AffinityPick>,
+}
+
+/// Entries recorded in `[from, to)`.
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct AuditLogExportQuery {
+    pub from: DateTime<Utc>,
+    pub to: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OptionImageUploadRequest {
+    pub topic_id: String,
+    pub option_id: i32,
+    /// PNG, JPEG or WebP, base64 encoded.
+    pub image_base64: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OptionImageUploadResponse {
+    pub topic_id: String,
+    pub option_id: i32,
+    pub url: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OptionImageDeleteRequest {
+    pub topic_id: String,
+    pub option_id: i32,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorSearchRequest {
+    /// Part of the CN, EN or JP name, the pinyin or its initials, or one of
+    /// the operator's aliases.
+    pub query: String,
+    #[serde(default)]
+    pub lang: Language,
+    pub limit: Option<usize>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorSearchItem {
+    pub id: i32,
+    /// Name in the requested language.
+    pub name: String,
+    pub names: OperatorNames,
+    pub aliases: Vec<String>,
+    pub avatar: Vec<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorAliasAddRequest {
+    pub operator_id: i32,
+    pub alias: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorAliasRemoveRequest {
+    pub alias: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorAliasListRequest {
+    /// Lists the aliases of every operator when absent.
+    pub operator_id: Option<i32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorAliasListResponse {
+    pub aliases: Vec<OperatorAlias>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorSearchResponse {
+    pub operators: Vec<OperatorSearchItem>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct TopicPresenceResponse {
+    pub topic_id: String,
+    /// Open live connections plus clients active within the last minute.
+    pub viewers: usize,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct SystemConfigResponse {
+    pub environment: String,
+    /// The app config as in `config/app.toml`, with secrets redacted.
+    #[schema(value_type = Object)]
+    pub config: serde_json::Value,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct MaintenanceStatus {
+    pub enabled: bool,
+    pub message: String,
+    /// Unix timestamp of when the admin endpoint switched it on.
+    pub since: Option<i64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct MaintenanceSetRequest {
+    pub enabled: bool,
+    #[serde(default)]
+    pub message: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct SystemSloResponse {
+    pub latency_target_ms: u64,
+    pub availability_target: f64,
+    pub routes: Vec<RouteSloReport>,
+}
+
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct SystemJobsResponse {
+    pub jobs: Vec<JobInfo>,
+}
+
+/// What one run of the `[retention]` policies deleted, or would delete on a
+/// dry run.
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct RetentionReport {
+    pub dry_run: bool,
+    pub policies: Vec<RetentionPolicyReport>,
+}
+
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct RetentionPolicyReport {
+    /// The config key of the policy, e.g. `ballot_days`.
+    pub policy: String,
+    /// Data from before it is pruned.
+    pub cutoff: DateTime<Utc>,
+    /// Documents deleted, or matched on a dry run.
+    pub count: u64,
+}
+
+/// One route over one rolling window, latencies in milliseconds.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct RouteSloReport {
+    /// Method and matched path, e.g. `POST /ballot/save`.
+    pub route: String,
+    pub window_mins: u64,
+    pub requests: u64,
+    pub p50_ms: f64,
+    pub p95_ms: f64,
+    pub p99_ms: f64,
+    /// Responses with a 5xx status.
+    pub errors: u64,
+    /// Successful responses slower than the latency target.
+    pub slow: u64,
+    /// Share of the window's error budget left, negative once overspent.
+    pub error_budget_remaining: f64,
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
 pub struct AuditTopicsListResponse {
     pub topics: Vec<VotingTopic>,
@@ -316,6 +910,12 @@ pub struct CharacterPortrait {
     pub name: String,
     pub cn_name: String,
     pub avatar: Vec<String>,
+    /// Name in the requested language, only set when a `lang` was given.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub display_name: Option<String>,
+    /// Image uploaded for this option of the topic, replaces the avatar.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub image: Option<String>,
 }
 
 impl Default for CharacterPortrait {
@@ -325,6 +925,8 @@ impl Default for CharacterPortrait {
             name: "Unknown".to_string(),
             cn_name: "未知".to_string(),
             avatar: vec![],
+            display_name: None,
+            image: None,
         }
     }
 }
@@ -332,6 +934,8 @@ impl Default for CharacterPortrait {
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
 pub struct TopicCandidatePoolRequest {
     pub topic_id: String,
+    #[serde(default)]
+    pub lang: Option<Language>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
@@ -339,3 +943,669 @@ pub struct TopicCandidatePoolResponse {
     pub topic_id: String,
     pub pool: Vec<CharacterPortrait>,
 }
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ApiKeyIssueRequest {
+    pub name: String,
+    pub scopes: Vec<ApiKeyScope>,
+    pub rate_limit_per_minute: Option<u32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ApiKeyIssueResponse {
+    pub name: String,
+    /// The plain key, it is not stored and cannot be retrieved again.
+    pub key: String,
+    /// Secret of signed requests with this key, likewise not stored. Missing
+    /// while `api_key.signing_pepper` is not set.
+    pub signing_secret: Option<String>,
+    pub scopes: Vec<ApiKeyScope>,
+    pub rate_limit_per_minute: u32,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ApiKeyRevokeRequest {
+    pub name: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ApiKeyInfo {
+    pub name: String,
+    pub scopes: Vec<ApiKeyScope>,
+    pub rate_limit_per_minute: u32,
+    pub enabled: bool,
+    pub created_at: DateTime<Utc>,
+    pub last_used_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ApiKeyListResponse {
+    pub keys: Vec<ApiKeyInfo>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookCreateRequest {
+    pub url: String,
+    pub events: Vec<WebhookEvent>,
+    /// A secret is generated when none is given.
+    pub secret: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookCreateResponse {
+    pub id: String,
+    pub url: String,
+    pub events: Vec<WebhookEvent>,
+    pub secret: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookDeleteRequest {
+    pub id: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookInfo {
+    pub id: String,
+    pub url: String,
+    pub events: Vec<WebhookEvent>,
+    pub enabled: bool,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookListResponse {
+    pub webhooks: Vec<WebhookInfo>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookDeliveriesRequest {
+    pub webhook_id: String,
+    pub limit: Option<i64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookDeliveriesResponse {
+    pub deliveries: Vec<WebhookDelivery>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueDeadRequest {
+    /// Only tasks of this kind, e.g. `webhook_delivery`.
+    pub kind: Option<String>,
+    pub limit: Option<i64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueDeadResponse {
+    pub tasks: Vec<QueuedTask>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueTaskRequest {
+    pub id: String,
+}
+
+/// What a scheduled task does once it is due.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(tag = "action", rename_all = "snake_case")]
+pub enum ScheduledAction {
+    /// Turns the maintenance mode on or off, e.g. at the end of a window.
+    Maintenance {
+        enabled: bool,
+        #[serde(default)]
+        message: String,
+    },
+    /// Revokes the API key of this name, e.g. a partner's trial access.
+    ApiKeyRevoke { name: String },
+    /// (Re)publishes the anonymized dataset of a closed topic, e.g. one that
+    /// closed before `[dataset]` was enabled.
+    DatasetPublish { topic_id: String },
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueScheduleRequest {
+    pub run_at: DateTime<Utc>,
+    #[serde(flatten)]
+    pub action: ScheduledAction,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueScheduleResponse {
+    pub id: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct PrivacyErasureRequest {
+    /// Address of the voter as the ballots were cast from.
+    pub ip: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct PrivacyExportRequest {
+    /// Address of the voter as the ballots were cast from.
+    pub ip: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct PrivacyExportResponse {
+    /// Downloadable through `/privacy/export/download` once assembled.
+    pub id: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct PrivacyExportDownloadRequest {
+    pub id: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct PrivacyErasureResponse {
+    /// Queue task of the erasure, `/queue/cancel` withdraws it until it runs.
+    pub id: String,
+    pub run_at: DateTime<Utc>,
+}
+
+/// A comment as listed. Taken down comments keep their place in the thread
+/// with an empty nickname and body.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentView {
+    pub id: String,
+    pub topic_id: String,
+    pub parent_id: Option<String>,
+    pub nickname: String,
+    pub body: String,
+    pub status: CommentStatus,
+    pub reply_count: u64,
+    pub created_at: DateTime<Utc>,
+}
+
+impl From<TopicComment> for CommentView {
+    fn from(comment: TopicComment) -> Self {
+        let shown = matches!(
+            comment.status,
+            CommentStatus::Visible | CommentStatus::Pending
+        );
+        Self {
+            id: comment.id,
+            topic_id: comment.topic_id,
+            parent_id: comment.parent_id,
+            nickname: if shown {
+                comment.nickname
+            } else {
+                String::new()
+            },
+            body: if shown { comment.body } else { String::new() },
+            status: comment.status,
+            reply_count: comment.reply_count,
+            created_at: comment.created_at,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentCreateRequest {
+    pub topic_id: String,
+    /// Replies to this top level comment.
+    pub parent_id: Option<String>,
+    pub nickname: Option<String>,
+    pub body: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentCreateResponse {
+    #[serde(flatten)]
+    pub comment: CommentView,
+    /// Deletes the comment through `/comment/delete`, only shown once.
+    pub delete_token: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentListRequest {
+    pub topic_id: String,
+    /// Lists the replies to this comment instead of the top level.
+    pub parent_id: Option<String>,
+    /// The `next` of the previous page.
+    pub before: Option<String>,
+    pub limit: Option<u32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentListResponse {
+    /// Newest first.
+    pub comments: Vec<CommentView>,
+    /// Cursor of the next page, unset on the last one.
+    pub next: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentDeleteRequest {
+    pub id: String,
+    pub delete_token: String,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum CommentModeration {
+    /// Lists a pending or hidden comment.
+    Approve,
+    Hide,
+    Delete,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CommentModerateRequest {
+    pub id: String,
+    pub action: CommentModeration,
+    /// Kept in the audit log.
+    #[serde(default)]
+    pub reason: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ReportCreateRequest {
+    pub target_kind: ReportTargetKind,
+    pub target_id: String,
+    pub reason: ReportReason,
+    #[serde(default)]
+    pub detail: String,
+}
+
+/// A merged report as the moderators see it, without the reporters.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ContentReportView {
+    pub target_kind: ReportTargetKind,
+    pub target_id: String,
+    pub topic_id: String,
+    pub status: ReportStatus,
+    pub report_count: u64,
+    pub reasons: Vec<ReportReason>,
+    pub details: Vec<String>,
+    pub first_reported_at: DateTime<Utc>,
+    pub last_reported_at: DateTime<Utc>,
+    pub resolved_by: Option<String>,
+    pub resolved_at: Option<DateTime<Utc>>,
+    pub note: Option<String>,
+}
+
+impl From<ContentReport> for ContentReportView {
+    fn from(report: ContentReport) -> Self {
+        Self {
+            target_kind: report.target_kind,
+            target_id: report.target_id,
+            topic_id: report.topic_id,
+            status: report.status,
+            report_count: report.report_count,
+            reasons: report.reasons,
+            details: report.details,
+            first_reported_at: report.first_reported_at,
+            last_reported_at: report.last_reported_at,
+            resolved_by: report.resolved_by,
+            resolved_at: report.resolved_at,
+            note: report.note,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ReportQueueRequest {
+    /// Open by default.
+    pub status: Option<ReportStatus>,
+    pub limit: Option<i64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ReportQueueResponse {
+    /// Most reported first, then the latest reported.
+    pub reports: Vec<ContentReportView>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ReportResolution {
+    Actioned,
+    Dismissed,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ReportResolveRequest {
+    pub target_kind: ReportTargetKind,
+    pub target_id: String,
+    pub resolution: ReportResolution,
+    #[serde(default)]
+    pub note: String,
+}
+
+/// The terms a voter has to accept, and whether the address asking did.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ConsentStatus {
+    /// Unset while no consent is asked for.
+    pub version: Option<String>,
+    pub terms_url: Option<String>,
+    /// When the current version was accepted from this address, unset if it
+    /// was not.
+    pub accepted_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ConsentAcceptRequest {
+    /// The version shown to the voter, sent on as the `x-ark-vote-consent`
+    /// header of every ballot request.
+    pub version: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueScheduledRequest {
+    pub limit: Option<i64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueScheduledResponse {
+    pub tasks: Vec<QueuedTask>,
+}
+
+/// Tasks of one kind. The counts cover the whole queue, the runs only the
+/// instance that answered since it started.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+pub struct QueueKindStats {
+    pub kind: String,
+    pub priority: TaskPriority,
+    pub pending: u64,
+    pub running: u64,
+    pub dead: u64,
+    pub succeeded: u64,
+    /// Failed attempts which will be tried again.
+    pub retried: u64,
+    /// Tasks which ran out of attempts.
+    pub died: u64,
+    pub avg_duration_ms: u64,
+    pub max_duration_ms: u64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueueStatsResponse {
+    pub kinds: Vec<QueueKindStats>,
+}
+
+/// An announcement as the site shows it.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AnnouncementView {
+    pub id: String,
+    pub title: String,
+    pub body: String,
+    pub severity: AnnouncementSeverity,
+    pub topic_id: Option<String>,
+    pub starts_at: Option<DateTime<Utc>>,
+    pub ends_at: Option<DateTime<Utc>>,
+}
+
+impl From<Announcement> for AnnouncementView {
+    fn from(announcement: Announcement) -> Self {
+        Self {
+            id: announcement.id,
+            title: announcement.title,
+            body: announcement.body,
+            severity: announcement.severity,
+            topic_id: announcement.topic_id,
+            starts_at: announcement.starts_at,
+            ends_at: announcement.ends_at,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AnnouncementListResponse {
+    /// Most severe first, then the latest started.
+    pub announcements: Vec<AnnouncementView>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AnnouncementCreateRequest {
+    pub title: String,
+    #[serde(default)]
+    pub body: String,
+    pub severity: AnnouncementSeverity,
+    #[serde(default)]
+    pub topic_id: Option<String>,
+    #[serde(default)]
+    pub starts_at: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub ends_at: Option<DateTime<Utc>>,
+}
+
+/// Replaces every field of the announcement.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AnnouncementUpdateRequest {
+    pub id: String,
+    #[serde(flatten)]
+    pub announcement: AnnouncementCreateRequest,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AnnouncementDeleteRequest {
+    pub id: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AnnouncementAdminListResponse {
+    /// Scheduled and ended ones included, the latest created first.
+    pub announcements: Vec<Announcement>,
+}
+
+/// A bookmark as the address which made it sees it, without the reminder
+/// address.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct BookmarkView {
+    pub topic_id: String,
+    /// Whether a reminder is mailed when voting opens.
+    pub remind: bool,
+    /// The reminder address was confirmed, nothing is mailed before.
+    pub remind_confirmed: bool,
+    pub reminded_at: Option<DateTime<Utc>>,
+    pub created_at: DateTime<Utc>,
+}
+
+impl From<TopicBookmark> for BookmarkView {
+    fn from(bookmark: TopicBookmark) -> Self {
+        Self {
+            topic_id: bookmark.topic_id,
+            remind: bookmark.remind_email.is_some(),
+            remind_confirmed: bookmark.remind_confirmed,
+            reminded_at: bookmark.reminded_at,
+            created_at: bookmark.created_at,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct BookmarkListResponse {
+    /// The latest bookmarked first.
+    pub bookmarks: Vec<BookmarkView>,
+}
+
+/// Bookmarks the topic, or changes the reminder of a bookmark.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct BookmarkAddRequest {
+    pub topic_id: String,
+    /// Mailed once when voting opens, only for topics not open yet. A new
+    /// address gets a confirmation mail first.
+    #[serde(default)]
+    pub remind_email: Option<String>,
+}
+
+/// Confirms the reminder address of a bookmark, with the token of the
+/// confirmation mail.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct BookmarkConfirmRequest {
+    pub token: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct BookmarkRemoveRequest {
+    pub topic_id: String,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct ResultsDigestQuery {
+    /// Only the digest of this topic.
+    #[serde(default)]
+    pub topic_id: Option<String>,
+    /// The UTC day summarized, the latest compiled one when omitted.
+    #[serde(default)]
+    pub date: Option<NaiveDate>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ResultsDigestResponse {
+    /// Unset when nothing was compiled yet.
+    pub date: Option<NaiveDate>,
+    pub digests: Vec<DailyDigest>,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum RegionLevel {
+    #[default]
+    Country,
+    /// Needs the city edition of the database, countries without one are
+    /// listed as a whole.
+    Province,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct SystemRegionsQuery {
+    pub topic_id: String,
+    #[serde(default)]
+    pub level: RegionLevel,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct RegionCount {
+    /// ISO 3166 code, `ZZ` for addresses the database does not know.
+    pub country: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub province: Option<String>,
+    pub ballots: i64,
+}
+
+/// Ballots accepted by region while `geoip.enabled`, the most first.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct SystemRegionsResponse {
+    pub topic_id: String,
+    pub total: i64,
+    pub regions: Vec<RegionCount>,
+    /// Ballots of the regions below `geoip.min_ballots`.
+    pub other: i64,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct ResultsHeatmapQuery {
+    pub topic_id: String,
+}
+
+/// Ballots of a topic by the weekday and hour they were cast, as of the
+/// last rollup.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ResultsHeatmapResponse {
+    pub topic_id: String,
+    /// IANA name of the time zone the weekdays and hours are counted in.
+    pub timezone: String,
+    /// Seven rows from Monday, of 24 hours each.
+    pub cells: Vec<Vec<i64>>,
+    pub total: i64,
+    /// Ballots stored before this are counted, unset until the first
+    /// rollup.
+    pub rolled_up_until: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct ResultsTrendsQuery {
+    pub topic_id: String,
+    /// Window of the momentum, `trends.momentum_hours` when omitted.
+    #[serde(default)]
+    pub hours: Option<u32>,
+    /// Language of the option names.
+    #[serde(default)]
+    pub lang: Language,
+}
+
+/// A lower ranked option leading a much higher ranked one head to head.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct TrendUpset {
+    pub winner_id: i32,
+    pub winner_name: String,
+    pub winner_rank: u32,
+    pub loser_id: i32,
+    pub loser_name: String,
+    pub loser_rank: u32,
+    /// Net votes of the winner over the loser.
+    pub margin: i64,
+}
+
+/// An option whose win rate in the window moved away from the one before.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct TrendMomentum {
+    pub id: i32,
+    pub name: String,
+    /// Win rates in percent.
+    pub rate_before: f64,
+    pub recent_rate: f64,
+    /// Percentage points, negative when losing ground.
+    pub shift: f64,
+    pub recent_battles: i64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ResultsTrendsResponse {
+    pub topic_id: String,
+    /// The largest rank gap first.
+    pub upsets: Vec<TrendUpset>,
+    pub hours: u32,
+    /// The largest shift first.
+    pub momentum: Vec<TrendMomentum>,
+}
+
+#[derive(Debug, Clone, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct ResultsHistoryQuery {
+    /// Operator id, or a name, its pinyin or an alias.
+    pub operator: String,
+    /// Language of the operator name.
+    #[serde(default)]
+    pub lang: Language,
+}
+
+/// Where the operator stood in the final results of one closed topic.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct HistoryEntry {
+    pub topic_id: String,
+    pub topic_title: String,
+    pub closed_at: DateTime<Utc>,
+    /// Valid ballots of the topic.
+    pub ballots: i64,
+    /// 1 based, by win rate.
+    pub rank: u32,
+    /// Options with votes in the topic, pools differ between topics.
+    pub ranked: u32,
+    /// The share of options ranked below, in percent, comparable across
+    /// pools of different sizes.
+    pub percentile: f64,
+    pub win: i64,
+    pub lose: i64,
+    /// In percent.
+    pub rate: f64,
+    pub score: f64,
+    /// Ranks gained since the entry before, negative when lost.
+    pub rank_change: Option<i64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ResultsHistoryResponse {
+    pub operator_id: i32,
+    pub name: String,
+    /// The first closed topic first.
+    pub entries: Vec<HistoryEntry>,
+}
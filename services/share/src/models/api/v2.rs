@@ -0,0 +1,32 @@
+//! Response shapes of the `/api/v2` group, request bodies are shared with v1.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::ResultsGroup;
+
+#[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
+#[schema(as = v2::FinalOrderItem)]
+pub struct FinalOrderItem {
+    pub id: i32,
+    pub name: String,
+    pub win: i64,
+    pub lose: i64,
+    /// `(win - lose) / 100`, unrounded.
+    pub score: f64,
+    /// Win rate in percent, unrounded.
+    pub rate: f64,
+    /// 1-based position in the final order.
+    pub rank: usize,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize, ToSchema)]
+#[schema(as = v2::ResultsFinalOrderResponse)]
+pub struct ResultsFinalOrderResponse {
+    pub topic_id: String,
+    pub items: Vec<FinalOrderItem>,
+    pub valid_ballots_count: i64,
+    /// Only present when `group_by` was requested.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub groups: Option<Vec<ResultsGroup>>,
+}
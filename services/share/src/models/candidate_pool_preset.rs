@@ -219,6 +219,7 @@ mod tests {
                 rarity: RarityRank::Tier6,
                 profession: ProfessionCategory::WARRIOR,
                 sub_profession_id: "centurion".to_string(),
+                faction: None,
                 is_not_obtainable: false,
             },
             CharacterInfo {
@@ -227,6 +228,7 @@ mod tests {
                 rarity: RarityRank::Tier6,
                 profession: ProfessionCategory::WARRIOR,
                 sub_profession_id: "sword".to_string(),
+                faction: None,
                 is_not_obtainable: false,
             },
             CharacterInfo {
@@ -235,6 +237,7 @@ mod tests {
                 rarity: RarityRank::Tier6,
                 profession: ProfessionCategory::CASTER,
                 sub_profession_id: "aoedamage".to_string(),
+                faction: None,
                 is_not_obtainable: false,
             },
             CharacterInfo {
@@ -243,6 +246,7 @@ mod tests {
                 rarity: RarityRank::Tier6,
                 profession: ProfessionCategory::PIONEER,
                 sub_profession_id: "pioneer".to_string(),
+                faction: None,
                 is_not_obtainable: false,
             },
         ]
@@ -1,15 +1,21 @@
-use std::borrow::Cow;
+use std::{borrow::Cow, collections::HashMap};
 
-use chrono::{DateTime, Utc};
+use chrono::{DateTime, NaiveDate, Utc};
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 use uuid::Uuid;
 
-use crate::models::candidate_pool_preset::CandidatePoolPreset;
+use crate::{
+    events::DomainEvent,
+    models::{
+        candidate_pool_preset::CandidatePoolPreset,
+        excel::{CharacterInfo, OperatorNames, ProfessionCategory, RarityRank},
+    },
+};
 
 use super::api::BallotSaveRequest;
 
-#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
 pub enum VotingTopicType {
     Pairwise,  // 两两对比
     Setwise,   // 集合对比
@@ -78,6 +84,10 @@ pub struct VotingTopic {
     pub description: String,
     pub topic_type: VotingTopicType,
     pub candidate_pool: CandidatePoolPreset,
+    /// Dropped from whatever `candidate_pool` selects, e.g. limited operators
+    /// in an otherwise rarity based pool.
+    #[serde(default)]
+    pub excluded_operator_ids: Vec<i32>,
     pub created_at: DateTime<Utc>,
     pub updated_at: Option<DateTime<Utc>>,
 
@@ -89,6 +99,14 @@ pub struct VotingTopic {
 }
 
 impl VotingTopic {
+    /// Candidate pool with the exclusions applied, ballots are drawn from and
+    /// validated against it.
+    pub fn generate_pool(&self, character_infos: &[CharacterInfo]) -> Vec<i32> {
+        let mut pool = self.candidate_pool.generate_pool(character_infos);
+        pool.retain(|id| !self.excluded_operator_ids.contains(id));
+        pool
+    }
+
     pub fn is_topic_active(&self) -> bool {
         self.is_active
             && self.open_time <= chrono::Utc::now()
@@ -96,6 +114,541 @@ impl VotingTopic {
     }
 }
 
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ApiKeyScope {
+    Results,
+    /// Works the comment and report moderation queues, nothing else of the
+    /// admin api.
+    Moderation,
+    Admin,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ApiKey {
+    pub name: String,
+    /// Hex encoded sha256 of the key, the plain key is only shown once on issue.
+    pub key_hash: String,
+    pub scopes: Vec<ApiKeyScope>,
+    pub rate_limit_per_minute: u32,
+    pub enabled: bool,
+    pub created_at: DateTime<Utc>,
+    pub last_used_at: Option<DateTime<Utc>>,
+}
+
+impl ApiKey {
+    pub fn has_scope(&self, scope: ApiKeyScope) -> bool {
+        self.scopes.contains(&scope) || self.scopes.contains(&ApiKeyScope::Admin)
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum WebhookEvent {
+    TopicStarted,
+    TopicEnded,
+    ResultPublished,
+    CommentPosted,
+    DigestPublished,
+}
+
+impl WebhookEvent {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            WebhookEvent::TopicStarted => "topic_started",
+            WebhookEvent::TopicEnded => "topic_ended",
+            WebhookEvent::ResultPublished => "result_published",
+            WebhookEvent::CommentPosted => "comment_posted",
+            WebhookEvent::DigestPublished => "digest_published",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct Webhook {
+    pub id: String,
+    pub url: String,
+    /// Shared secret used to sign the payloads, only shown once on create.
+    pub secret: String,
+    pub events: Vec<WebhookEvent>,
+    pub enabled: bool,
+    pub created_at: DateTime<Utc>,
+}
+
+/// One delivery attempt of an event to a webhook.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct WebhookDelivery {
+    pub webhook_id: String,
+    pub event_id: String,
+    pub event: WebhookEvent,
+    pub attempt: u32,
+    pub success: bool,
+    pub status_code: Option<u16>,
+    pub error: Option<String>,
+    pub duration_ms: u64,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum QueuedTaskStatus {
+    Pending,
+    Running,
+    /// Out of attempts, kept until an admin retries or deletes it.
+    Dead,
+}
+
+/// Tasks of each priority run on their own workers, see
+/// `task_queue.workers`.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Hash, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum TaskPriority {
+    /// Someone is waiting on it, e.g. webhook deliveries.
+    High,
+    #[default]
+    Normal,
+    /// Bulk work such as exports and rollups.
+    Low,
+}
+
+impl TaskPriority {
+    pub const ALL: [TaskPriority; 3] =
+        [TaskPriority::High, TaskPriority::Normal, TaskPriority::Low];
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            TaskPriority::High => "high",
+            TaskPriority::Normal => "normal",
+            TaskPriority::Low => "low",
+        }
+    }
+}
+
+/// A task of the persistent queue, deleted once it succeeds.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct QueuedTask {
+    pub id: String,
+    pub kind: String,
+    #[schema(value_type = Object)]
+    pub payload: serde_json::Value,
+    /// Taken from the kind when the task is queued.
+    #[serde(default)]
+    pub priority: TaskPriority,
+    pub status: QueuedTaskStatus,
+    pub attempts: u32,
+    pub last_error: Option<String>,
+    /// Milliseconds since the epoch, numbers so mongo can compare them.
+    pub run_at: i64,
+    pub locked_until: i64,
+    pub created_at: DateTime<Utc>,
+}
+
+/// A domain event waiting in the `event_outbox` collection, inserted in the
+/// transaction of the change it describes and removed once published.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct OutboxEvent {
+    /// The id of the event, consumers dedupe on it as the relay publishes at
+    /// least once.
+    pub id: String,
+    pub event: DomainEvent,
+    /// Milliseconds since the epoch, set while a relay publishes the event.
+    pub locked_until: i64,
+    /// The relay claim holding the event.
+    pub claim: Option<String>,
+    /// Milliseconds since the epoch, the relay publishes the oldest first.
+    pub created_at: i64,
+}
+
+impl OutboxEvent {
+    pub fn new(event: DomainEvent) -> Self {
+        Self {
+            id: event.id.to_string(),
+            event,
+            locked_until: 0,
+            claim: None,
+            created_at: Utc::now().timestamp_millis(),
+        }
+    }
+}
+
+/// Changes made to topics by the system or by admins outside of the audit
+/// flow, newest entries are appended.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AuditLogEntry {
+    pub id: Uuid,
+    pub topic_id: Option<String>,
+    /// Api key name of the admin, or the job that made the change.
+    pub actor: String,
+    pub action: String,
+    pub detail: String,
+    pub created_at: DateTime<Utc>,
+    /// Link into the hash chain of the log, missing on entries written before
+    /// the log was chained.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub chain: Option<AuditChainLink>,
+}
+
+/// Position of an [`AuditLogEntry`] in the hash chain. `hash` covers the
+/// entry and `prev_hash`, so changing or removing an entry breaks every link
+/// after it.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct AuditChainLink {
+    pub seq: i64,
+    /// `created_at` in milliseconds, hashed and queried by export ranges.
+    pub at: i64,
+    pub prev_hash: String,
+    pub hash: String,
+}
+
+impl AuditLogEntry {
+    pub fn new(
+        topic_id: Option<String>,
+        actor: impl Into<String>,
+        action: impl Into<String>,
+        detail: impl Into<String>,
+    ) -> Self {
+        Self {
+            id: Uuid::new_v4(),
+            topic_id,
+            actor: actor.into(),
+            action: action.into(),
+            detail: detail.into(),
+            created_at: Utc::now(),
+            chain: None,
+        }
+    }
+}
+
+/// Image uploaded by an admin for one option of a topic.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OptionImage {
+    pub topic_id: String,
+    pub option_id: i32,
+    /// Storage key, the public url is derived from it.
+    pub key: String,
+    pub uploaded_by: String,
+    pub created_at: DateTime<Utc>,
+}
+
+/// The redis counters of a topic at one point in time, kept in the
+/// `results_snapshots` collection. Written by the `snapshot` command and
+/// once a topic closed, the results feed is built from them.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ArchivedResults {
+    pub topic_id: String,
+    pub taken_at: DateTime<Utc>,
+    pub count: i64,
+    /// `op_stats` as stored, `id:win` and `id:lose` to the totals.
+    pub stats: HashMap<String, i64>,
+    /// Left out when read for the feed.
+    #[serde(default)]
+    pub matrix: HashMap<String, i64>,
+}
+
+/// An option whose rank changed since the previous digest, ranks are 1
+/// based.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct DigestMover {
+    pub id: i32,
+    pub from: u32,
+    pub to: u32,
+}
+
+/// Two of the top options and their head to head.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct DigestMatchup {
+    pub higher: i32,
+    pub lower: i32,
+    /// Net votes of the higher ranked option over the lower one.
+    pub margin: i64,
+}
+
+/// One day of a topic, compiled by the `daily_digest` task shortly after
+/// the day ended for the frontend's highlights and the bots behind the
+/// webhooks.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct DailyDigest {
+    pub topic_id: String,
+    /// The UTC day summarized.
+    pub date: NaiveDate,
+    /// Valid ballots when it was compiled.
+    pub total_ballots: i64,
+    /// Ballots since the digest of the day before. Unset without one, unless
+    /// the topic opened that day.
+    pub ballots: Option<i64>,
+    /// Options by rank, the next digest's movers are measured against it.
+    pub ranking: Vec<i32>,
+    /// The largest rank changes since the day before.
+    pub movers: Vec<DigestMover>,
+    /// The closest head to heads among the top options.
+    pub closest: Vec<DigestMatchup>,
+    pub compiled_at: DateTime<Utc>,
+}
+
+/// The anonymized ballots of a closed topic, see `[dataset]`. Republishing
+/// replaces the file.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct PublishedDataset {
+    pub topic_id: String,
+    /// Storage key, the public url is derived from it.
+    pub key: String,
+    pub rows: u64,
+    /// Ballots in the file.
+    pub ballots: u64,
+    /// Ballots left out as their group was smaller than `min_group_size`.
+    pub suppressed: u64,
+    pub min_group_size: u64,
+    pub window_secs: u64,
+    pub created_at: DateTime<Utc>,
+}
+
+/// The ballots cast from one voter address, assembled by a queue task for a
+/// data access request.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct VoterExport {
+    pub id: String,
+    pub requested_by: String,
+    pub requested_at: DateTime<Utc>,
+    /// Unset while the export is assembled.
+    pub completed_at: Option<DateTime<Utc>>,
+    pub ballots: u64,
+    /// Set when the address cast more than `privacy.export_max_ballots`.
+    pub truncated: bool,
+    /// The json archive, unset until it is assembled.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub archive: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum CommentStatus {
+    Visible,
+    /// Waiting for a moderator, see `comment.pre_moderation`.
+    Pending,
+    /// Taken down by a moderator.
+    Hidden,
+    /// Deleted by its author or a moderator.
+    Deleted,
+}
+
+/// A comment under a topic, or a reply to one. Threads are one level deep.
+/// Nothing is removed, taken down comments only change their status.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TopicComment {
+    /// The snowflake zero padded to 20 digits, so ids sort by creation.
+    pub id: String,
+    pub topic_id: String,
+    /// Set on replies.
+    pub parent_id: Option<String>,
+    pub nickname: String,
+    pub body: String,
+    /// In the form of `vote.ip_storage`, as in the ballots.
+    pub ip: String,
+    /// Sha256 of the token the author deletes the comment with.
+    pub delete_token_hash: String,
+    pub status: CommentStatus,
+    /// Replies of any status, a taken down comment with replies is still
+    /// listed as a placeholder.
+    pub reply_count: u64,
+    pub created_at: DateTime<Utc>,
+    /// Of the last status change.
+    pub updated_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ReportTargetKind {
+    /// Its body or the nickname it was posted under.
+    Comment,
+    /// The name, title or description of a submitted topic.
+    Topic,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ReportReason {
+    Spam,
+    Abuse,
+    Inappropriate,
+    Other,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ReportStatus {
+    Open,
+    /// A moderator took the content down.
+    Actioned,
+    Dismissed,
+}
+
+/// The open reports of one target merged into one, each reporting address
+/// counted once. Reports of a target resolved before start a new one.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ContentReport {
+    pub target_kind: ReportTargetKind,
+    pub target_id: String,
+    pub topic_id: String,
+    pub status: ReportStatus,
+    /// In the form of `vote.ip_storage`.
+    pub reporters: Vec<String>,
+    pub report_count: u64,
+    pub reasons: Vec<ReportReason>,
+    /// Of the latest reporters who gave one.
+    pub details: Vec<String>,
+    pub first_reported_at: DateTime<Utc>,
+    pub last_reported_at: DateTime<Utc>,
+    #[serde(default)]
+    pub resolved_by: Option<String>,
+    #[serde(default)]
+    pub resolved_at: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub note: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum AnnouncementSeverity {
+    Info,
+    /// E.g. an upcoming maintenance.
+    Warning,
+    /// Shown above everything else, e.g. voting being paused.
+    Critical,
+}
+
+/// A notice shown on the site between its start and end.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct Announcement {
+    pub id: String,
+    pub title: String,
+    pub body: String,
+    pub severity: AnnouncementSeverity,
+    /// The topic it is about, e.g. for a result reveal time.
+    pub topic_id: Option<String>,
+    /// Unset shows it from creation.
+    pub starts_at: Option<DateTime<Utc>>,
+    /// Unset shows it until it is deleted.
+    pub ends_at: Option<DateTime<Utc>>,
+    pub created_by: String,
+    pub created_at: DateTime<Utc>,
+    pub updated_at: Option<DateTime<Utc>>,
+}
+
+impl Announcement {
+    pub fn is_shown_at(&self, now: DateTime<Utc>) -> bool {
+        self.starts_at.is_none_or(|starts_at| starts_at <= now)
+            && self.ends_at.is_none_or(|ends_at| now < ends_at)
+    }
+}
+
+/// A topic kept on the list of a voter address. There are no accounts, the
+/// address owns its bookmarks as it does its consents.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TopicBookmark {
+    /// In the form of `vote.ip_storage`, as in the ballots.
+    pub ip: String,
+    pub topic_id: String,
+    /// Mailed once when voting opens, unset without a reminder.
+    pub remind_email: Option<String>,
+    /// Set once the link of the confirmation mail was followed, only
+    /// confirmed addresses get the reminder.
+    #[serde(default)]
+    pub remind_confirmed: bool,
+    /// Token of the confirmation mail while `remind_email` is unconfirmed.
+    #[serde(default)]
+    pub confirm_token: Option<String>,
+    pub reminded_at: Option<DateTime<Utc>>,
+    pub created_at: DateTime<Utc>,
+}
+
+/// A voter address accepting one version of the terms. Every acceptance is
+/// kept, the latest one counts.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ConsentRecord {
+    /// In the form of `vote.ip_storage`, as in the ballots.
+    pub ip: String,
+    pub version: String,
+    pub accepted_at: DateTime<Utc>,
+}
+
+/// Merkle root over the accepted ballots of a topic at one point in time,
+/// see `share::merkle`. Leaves are ordered by ballot id.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct MerkleCommitment {
+    pub topic_id: String,
+    /// Hex encoded.
+    pub root: String,
+    pub leaves: u64,
+    /// Sum of the multipliers of the leaves, the total weight the rankings
+    /// of the topic were counted from.
+    pub total_multiplier: i64,
+    /// Storage key of the leaf hashes, 32 bytes each in leaf order.
+    pub key: String,
+    pub committed_at: DateTime<Utc>,
+}
+
+/// Ballots of a topic by the weekday and hour they were cast, rolled up by
+/// the `heatmap_rollup` task.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct VoteHeatmap {
+    pub topic_id: String,
+    /// IANA name of the time zone the weekdays and hours are counted in.
+    pub timezone: String,
+    /// Seven rows from Monday, of 24 hours each.
+    pub cells: Vec<Vec<i64>>,
+    pub total: i64,
+    /// Ballots stored before this are counted.
+    pub rolled_up_until: DateTime<Utc>,
+}
+
+/// A community nickname or abbreviation of an operator, e.g. `刺刺` for
+/// 棘刺. `alias` is stored normalized, see `normalize_name`.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct OperatorAlias {
+    pub alias: String,
+    pub operator_id: i32,
+    pub created_by: String,
+    pub created_at: DateTime<Utc>,
+}
+
+/// An operator imported from the game data, see `OperatorService::sync`.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct Operator {
+    pub id: i32,
+    /// Character table key, e.g. `char_002_amiya`.
+    pub char_key: String,
+    /// CN name, kept next to `names` for existing consumers.
+    pub name: String,
+    #[serde(default)]
+    pub names: OperatorNames,
+    pub appellation: Option<String>,
+    pub rarity: RarityRank,
+    pub profession: ProfessionCategory,
+    pub sub_profession_id: String,
+    pub faction: Option<String>,
+    /// Not part of the game data, maintained by hand and kept across syncs.
+    #[serde(default)]
+    pub release_date: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub portraits: Vec<String>,
+    pub is_not_obtainable: bool,
+    pub synced_at: DateTime<Utc>,
+}
+
+impl From<&Operator> for CharacterInfo {
+    fn from(operator: &Operator) -> Self {
+        CharacterInfo {
+            id: operator.id,
+            name: operator.name.clone(),
+            rarity: operator.rarity,
+            profession: operator.profession.clone(),
+            sub_profession_id: operator.sub_profession_id.clone(),
+            faction: operator.faction.clone(),
+            is_not_obtainable: operator.is_not_obtainable,
+        }
+    }
+}
+
 #[derive(Clone, Debug, Deserialize, Serialize)]
 pub struct BallotInfo<'a> {
     pub topic_id: Cow<'a, str>,
@@ -145,9 +698,31 @@ pub enum Ballot<'a> {
     Plurality(PluralityBallot<'a>),
 }
 
+/// Fraud scoring of a stored ballot, which runs after it was accepted. Only
+/// audited ballots are counted into the rankings.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum VoteAudit {
+    Pending,
+    Passed,
+    /// Counted with the low multiplier, e.g. too many votes from one network.
+    Flagged,
+}
+
+impl VoteAudit {
+    pub fn from_multiplier(multiplier: i32, base_multiplier: i32) -> Self {
+        match multiplier < base_multiplier {
+            true => VoteAudit::Flagged,
+            false => VoteAudit::Passed,
+        }
+    }
+}
+
 #[derive(Debug, Serialize)]
 pub struct StoredBallot<'a> {
     #[serde(flatten)]
     pub ballot: Ballot<'a>,
+    /// 0 until the ballot is audited.
     pub multiplier: i32,
+    pub audit: VoteAudit,
 }
@@ -1,6 +1,100 @@
+use pinyin::ToPinyin as _;
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Language {
+    #[default]
+    Cn,
+    En,
+    Jp,
+}
+
+/// Folds a name or alias for exact lookups: lowercase, without whitespace
+/// and the separators people drop when typing names like `Lee·Dichotomy`.
+pub fn normalize_name(name: &str) -> String {
+    name.chars()
+        .filter(|ch| !ch.is_whitespace() && !matches!(ch, '-' | '_' | '.' | '·' | '\'' | '’'))
+        .flat_map(char::to_lowercase)
+        .collect()
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+pub struct OperatorNames {
+    pub cn: String,
+    pub en: Option<String>,
+    pub jp: Option<String>,
+    /// Toneless pinyin of the CN name without spaces, e.g. `amiya`.
+    pub pinyin: String,
+    /// First letters of the pinyin, e.g. `amy`.
+    pub pinyin_initials: String,
+}
+
+impl OperatorNames {
+    pub fn new(cn: String, en: Option<String>, jp: Option<String>) -> Self {
+        let mut pinyin = String::new();
+        let mut pinyin_initials = String::new();
+        for (ch, syllable) in cn.chars().zip(cn.as_str().to_pinyin()) {
+            match syllable {
+                Some(syllable) => {
+                    pinyin.push_str(syllable.plain());
+                    pinyin_initials.push_str(syllable.first_letter());
+                }
+                // latin letters and digits in names like `W` or `12F`
+                None if ch.is_alphanumeric() => {
+                    pinyin.extend(ch.to_lowercase());
+                    pinyin_initials.extend(ch.to_lowercase());
+                }
+                None => {}
+            }
+        }
+
+        Self {
+            cn,
+            en,
+            jp,
+            pinyin,
+            pinyin_initials,
+        }
+    }
+
+    /// Name in the requested language, falls back to the CN name.
+    pub fn localized(&self, language: Language) -> &str {
+        match language {
+            Language::Cn => &self.cn,
+            Language::En => self.en.as_deref().unwrap_or(&self.cn),
+            Language::Jp => self.jp.as_deref().unwrap_or(&self.cn),
+        }
+    }
+
+    /// Case-insensitive substring match against every name and the pinyin,
+    /// `query` must already be lowercase.
+    pub fn matches(&self, query: &str) -> bool {
+        let contains = |name: &str| name.to_lowercase().contains(query);
+        contains(&self.cn)
+            || self.en.as_deref().is_some_and(contains)
+            || self.jp.as_deref().is_some_and(contains)
+            || self.pinyin.contains(query)
+            || self.pinyin_initials.starts_with(query)
+    }
+
+    /// Whether one of the names or the full pinyin equals `name`, which must
+    /// already be normalized with [`normalize_name`].
+    pub fn is_named(&self, name: &str) -> bool {
+        normalize_name(&self.cn) == name
+            || self
+                .en
+                .as_deref()
+                .is_some_and(|en| normalize_name(en) == name)
+            || self
+                .jp
+                .as_deref()
+                .is_some_and(|jp| normalize_name(jp) == name)
+            || self.pinyin == name
+    }
+}
+
 #[derive(Debug, Clone, Copy, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
 pub enum RarityRank {
     #[serde(rename = "TIER_1")]
@@ -56,6 +150,31 @@ pub struct CharacterData {
     pub profession: ProfessionCategory,
     pub sub_profession_id: String,
     pub is_not_obtainable: bool,
+    #[serde(default)]
+    pub appellation: Option<String>,
+    #[serde(default)]
+    pub nation_id: Option<String>,
+    #[serde(default)]
+    pub group_id: Option<String>,
+    #[serde(default)]
+    pub team_id: Option<String>,
+}
+
+impl CharacterData {
+    /// Most specific affiliation of the operator, e.g. `rhodes` or `penguin`.
+    pub fn faction(&self) -> Option<&str> {
+        self.team_id
+            .as_deref()
+            .or(self.group_id.as_deref())
+            .or(self.nation_id.as_deref())
+    }
+}
+
+/// Numeric id of a character table key such as `char_002_amiya`, `None` for
+/// tokens, traps and other non-operator entries.
+pub fn character_numeric_id(key: &str) -> Option<i32> {
+    let rest = key.strip_prefix("char_")?;
+    rest.split('_').next()?.parse().ok()
 }
 
 #[derive(Debug, Clone)]
@@ -65,6 +184,7 @@ pub struct CharacterInfo {
     pub rarity: RarityRank,
     pub profession: ProfessionCategory,
     pub sub_profession_id: String,
+    pub faction: Option<String>,
 
     pub is_not_obtainable: bool,
 }
@@ -82,6 +202,12 @@ impl CharacterInfo {
         sub_professions.contains(&self.sub_profession_id)
     }
 
+    pub fn matches_factions(&self, factions: &[String]) -> bool {
+        self.faction
+            .as_ref()
+            .is_some_and(|faction| factions.contains(faction))
+    }
+
     pub fn is_not_obtainable(&self) -> bool {
         self.is_not_obtainable
     }
@@ -104,3 +230,41 @@ impl CharacterInfo {
         true
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_operator_names_pinyin() {
+        let names = OperatorNames::new("阿米娅".to_string(), Some("Amiya".to_string()), None);
+        assert_eq!(names.pinyin, "amiya");
+        assert_eq!(names.pinyin_initials, "amy");
+        assert!(names.matches("amy"));
+        assert!(names.matches("miya"));
+        assert!(names.matches("amiya"));
+        assert!(names.matches("米"));
+        assert!(!names.matches("ch"));
+        assert_eq!(names.localized(Language::En), "Amiya");
+        assert_eq!(names.localized(Language::Jp), "阿米娅");
+    }
+
+    #[test]
+    fn test_normalize_name() {
+        assert_eq!(normalize_name("Lee · Dichotomy"), "leedichotomy");
+        assert_eq!(normalize_name("Ch'en"), "chen");
+        assert_eq!(normalize_name("棘刺"), "棘刺");
+
+        let names = OperatorNames::new("棘刺".to_string(), Some("Thorns".to_string()), None);
+        assert!(names.is_named(&normalize_name("THORNS")));
+        assert!(names.is_named("jici"));
+        assert!(!names.is_named("jc"));
+    }
+
+    #[test]
+    fn test_operator_names_latin() {
+        let names = OperatorNames::new("W".to_string(), None, None);
+        assert_eq!(names.pinyin, "w");
+        assert!(names.matches("w"));
+    }
+}
@@ -0,0 +1,177 @@
+use serde::{Deserialize, Serialize};
+
+/// Core NATS subject prefix, the topic id is appended as the last token.
+pub const LIVE_SUBJECT_PREFIX: &str = "ark-vote.live";
+
+pub fn live_subject(topic_id: &str) -> String {
+    format!("{LIVE_SUBJECT_PREFIX}.{topic_id}")
+}
+
+/// Core NATS subject prefix for notifications addressed to one API key.
+pub const NOTIFY_SUBJECT_PREFIX: &str = "ark-vote.notify";
+
+pub fn notify_subject(api_key_name: &str) -> String {
+    format!("{NOTIFY_SUBJECT_PREFIX}.{api_key_name}")
+}
+
+/// Core NATS subject every instance listens on to drop stale topic caches.
+pub const TOPIC_CHANGE_SUBJECT: &str = "ark-vote.topic.changed";
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Deserialize, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TopicChangeKind {
+    Created,
+    Audited,
+    Updated,
+    Deleted,
+    /// New operators joined the candidate pool.
+    PoolChanged,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct TopicChange {
+    pub topic_id: String,
+    pub kind: TopicChangeKind,
+}
+
+/// Published by the nats consumer once a batch of ballots has been applied.
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct LiveScoreUpdate {
+    pub topic_id: String,
+    pub deltas: Vec<LiveScoreDelta>,
+    pub ballots: u64,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct LiveScoreDelta {
+    pub win: i32,
+    pub lose: i32,
+    pub multiplier: i32,
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct LiveOperatorDelta {
+    pub id: i32,
+    pub win: i64,
+    pub lose: i64,
+}
+
+/// Position of a client in a topic's event stream. `epoch` changes whenever
+/// the serving instance rebuilds the topic state, sequence numbers are only
+/// comparable within one epoch.
+#[derive(Clone, Debug, PartialEq, Eq, Deserialize, Serialize)]
+pub struct LiveCursor {
+    pub epoch: String,
+    pub seq: u64,
+}
+
+impl LiveCursor {
+    /// `{epoch}:{seq}`, also used as the SSE event id.
+    pub fn encode(&self) -> String {
+        format!("{}:{}", self.epoch, self.seq)
+    }
+
+    pub fn decode(value: &str) -> Option<Self> {
+        let (epoch, seq) = value.rsplit_once(':')?;
+        Some(Self {
+            epoch: epoch.to_string(),
+            seq: seq.parse().ok()?,
+        })
+    }
+}
+
+#[derive(Clone, Debug, Deserialize, Serialize)]
+pub struct LiveRankedOperator {
+    pub id: i32,
+    pub rank: u32,
+    pub win: i64,
+    pub lose: i64,
+}
+
+/// One operator whose rank or score changed. `delta` is the change of
+/// `win - lose` in raw multiplier units, divide by 100 for the score shown by
+/// the results api.
+#[derive(Clone, Debug, PartialEq, Eq, Deserialize, Serialize)]
+pub struct LiveRankChange {
+    pub id: i32,
+    /// `None` for an operator that received its first vote.
+    pub from: Option<u32>,
+    pub to: u32,
+    pub delta: i64,
+}
+
+/// Messages sent to live feed clients.
+#[derive(Clone, Debug, Deserialize, Serialize)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum LiveEvent {
+    /// Full ranking, sent on subscribe, when a resume is not possible and
+    /// periodically so clients can correct any drift.
+    Snapshot {
+        topic_id: String,
+        cursor: LiveCursor,
+        total_ballots: i64,
+        viewers: usize,
+        ranking: Vec<LiveRankedOperator>,
+    },
+    /// Deltas may overlap with a replay right after subscribing, clients
+    /// should skip any delta whose `seq` is not newer than the last applied.
+    Delta {
+        topic_id: String,
+        cursor: LiveCursor,
+        changes: Vec<LiveRankChange>,
+        ballots: u64,
+        total_ballots: i64,
+    },
+    Presence {
+        topic_id: String,
+        viewers: usize,
+    },
+    /// The topic itself changed, clients should refetch its info.
+    TopicChanged {
+        topic_id: String,
+        kind: TopicChangeKind,
+    },
+    /// Events were dropped because the client could not keep up. Clients
+    /// should resubscribe with their last cursor to fill the gap; `topic_id`
+    /// is empty when events of several topics may be affected.
+    Lagged {
+        topic_id: Option<String>,
+        dropped: u64,
+    },
+    Subscribed {
+        topic_id: String,
+    },
+    Unsubscribed {
+        topic_id: String,
+    },
+    Authenticated {
+        name: String,
+    },
+    Notification {
+        payload: serde_json::Value,
+    },
+    Error {
+        message: String,
+    },
+}
+
+/// Messages sent by clients over the multiplexed `/ws` connection.
+#[derive(Clone, Debug, Deserialize, Serialize)]
+#[serde(tag = "action", rename_all = "snake_case")]
+pub enum LiveClientMessage {
+    Subscribe {
+        topic_id: String,
+        /// Last cursor seen before reconnecting, the missed deltas are
+        /// replayed when still buffered, otherwise a snapshot is sent.
+        #[serde(default)]
+        resume: Option<LiveCursor>,
+    },
+    Unsubscribe {
+        topic_id: String,
+    },
+    /// Authenticates the connection with an API key, which raises the
+    /// subscription limit and delivers notifications addressed to that key.
+    Auth {
+        api_key: String,
+    },
+}
@@ -2,3 +2,4 @@ pub mod api;
 pub mod candidate_pool_preset;
 pub mod database;
 pub mod excel;
+pub mod live;
@@ -0,0 +1,227 @@
+use std::{
+    fmt,
+    path::{Path, PathBuf},
+    str::FromStr,
+};
+
+use serde::{Deserialize, Serialize};
+
+use crate::config::{AppConfig, LogFormat, TomlConfig as _};
+
+/// Selects the profile, over the `environment` key of the config file.
+pub const ENV_VAR: &str = "ARK_VOTE_ENV";
+
+/// Where the process runs. Picks the defaults which differ between a laptop
+/// and the live deployment, and which `config/app.{environment}.toml` is laid
+/// over the config file.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Deserialize, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Environment {
+    /// Pretty logs, relaxed rate limits, and the nats stream is recreated on
+    /// every start so a changed stream config applies.
+    Dev,
+    Staging,
+    Prod,
+}
+
+impl Default for Environment {
+    /// Builds without an explicit environment keep behaving like they did,
+    /// debug builds as dev and release builds as prod.
+    fn default() -> Self {
+        match cfg!(debug_assertions) {
+            true => Environment::Dev,
+            false => Environment::Prod,
+        }
+    }
+}
+
+impl Environment {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Environment::Dev => "dev",
+            Environment::Staging => "staging",
+            Environment::Prod => "prod",
+        }
+    }
+
+    pub fn is_dev(self) -> bool {
+        self == Environment::Dev
+    }
+
+    /// How many times the configured rate limits a client gets.
+    fn rate_limit_factor(self) -> u32 {
+        match self {
+            Environment::Dev => 10,
+            Environment::Staging | Environment::Prod => 1,
+        }
+    }
+
+    fn log_format(self) -> LogFormat {
+        match self {
+            Environment::Dev => LogFormat::Pretty,
+            Environment::Staging | Environment::Prod => LogFormat::Json,
+        }
+    }
+}
+
+impl fmt::Display for Environment {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+impl FromStr for Environment {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "dev" => Ok(Environment::Dev),
+            "staging" => Ok(Environment::Staging),
+            "prod" => Ok(Environment::Prod),
+            _ => Err(format!(
+                "unknown environment {s:?}, expected dev, staging or prod"
+            )),
+        }
+    }
+}
+
+#[derive(thiserror::Error, Debug)]
+pub enum ProfileError {
+    #[error("failed to read {0}: {1}")]
+    Read(PathBuf, std::io::Error),
+    #[error("failed to parse {0}: {1}")]
+    Parse(PathBuf, toml::de::Error),
+    #[error("invalid {ENV_VAR}: {0}")]
+    Env(String),
+}
+
+/// `config/app.toml` becomes `config/app.dev.toml`.
+fn overlay_path(path: &Path, environment: Environment) -> PathBuf {
+    let stem = path.file_stem().unwrap_or_default().to_string_lossy();
+    path.with_file_name(format!("{stem}.{environment}.toml"))
+}
+
+/// Tables are merged key by key, anything else in `overlay` replaces the
+/// value in `base`, arrays included.
+fn merge(base: &mut toml::Table, overlay: toml::Table) {
+    for (key, value) in overlay {
+        match (base.get_mut(&key), value) {
+            (Some(toml::Value::Table(base)), toml::Value::Table(overlay)) => merge(base, overlay),
+            (_, value) => {
+                base.insert(key, value);
+            }
+        }
+    }
+}
+
+/// `environment` wins over [`ENV_VAR`], which wins over the file.
+fn select(
+    table: &toml::Table,
+    environment: Option<Environment>,
+) -> Result<Environment, ProfileError> {
+    if let Some(environment) = environment {
+        return Ok(environment);
+    }
+    if let Ok(value) = std::env::var(ENV_VAR) {
+        return value.parse().map_err(ProfileError::Env);
+    }
+    match table.get("environment").and_then(toml::Value::as_str) {
+        Some(value) => value.parse().map_err(ProfileError::Env),
+        None => Ok(Environment::default()),
+    }
+}
+
+/// Lays the environment's overlay over the parsed config file and fills in
+/// the defaults of the environment.
+pub fn resolve(
+    path: &Path,
+    mut table: toml::Table,
+    environment: Option<Environment>,
+) -> Result<AppConfig, ProfileError> {
+    let environment = select(&table, environment)?;
+
+    let overlay = overlay_path(path, environment);
+    match std::fs::read_to_string(&overlay) {
+        Ok(data) => {
+            let overlay_table =
+                toml::from_str(&data).map_err(|e| ProfileError::Parse(overlay.clone(), e))?;
+            merge(&mut table, overlay_table);
+        }
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => {}
+        Err(e) => return Err(ProfileError::Read(overlay, e)),
+    }
+    table.insert(
+        "environment".to_string(),
+        toml::Value::String(environment.to_string()),
+    );
+
+    let mut config: AppConfig = toml::Value::Table(table)
+        .try_into()
+        .map_err(|e| ProfileError::Parse(path.to_path_buf(), e))?;
+    apply_defaults(&mut config);
+    Ok(config)
+}
+
+fn apply_defaults(config: &mut AppConfig) {
+    let environment = config.environment;
+
+    config
+        .tracing
+        .format
+        .get_or_insert_with(|| environment.log_format());
+    config
+        .sentry
+        .environment
+        .get_or_insert_with(|| environment.to_string());
+    for profile in config.rate_limit.profiles.values_mut() {
+        profile.requests = profile
+            .requests
+            .saturating_mul(environment.rate_limit_factor());
+    }
+}
+
+/// [`TomlConfig::load_or_create`] with the profile applied, panics like it
+/// on a broken file.
+pub fn load_or_create(path: &str, environment: Option<Environment>) -> AppConfig {
+    let table: toml::Table = AppConfig::load_or_create(path);
+    resolve(Path::new(path), table, environment).unwrap_or_else(|err| {
+        panic!("failed to load configuration file {path}: {err}");
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn overlay_is_merged_per_key() {
+        let mut base: toml::Table = toml::from_str(
+            r#"
+            [server]
+            host = "127.0.0.1"
+            port = 3000
+            [cors]
+            allow_origin = ["*"]
+            "#,
+        )
+        .unwrap();
+        let overlay: toml::Table = toml::from_str(
+            r#"
+            [server]
+            port = 8080
+            [cors]
+            allow_origin = ["https://vote.example.com"]
+            "#,
+        )
+        .unwrap();
+
+        merge(&mut base, overlay);
+        assert_eq!(base["server"]["host"].as_str(), Some("127.0.0.1"));
+        assert_eq!(base["server"]["port"].as_integer(), Some(8080));
+        assert_eq!(base["cors"]["allow_origin"].as_array().unwrap().len(), 1);
+        assert_eq!(
+            overlay_path(Path::new("config/app.toml"), Environment::Staging),
+            Path::new("config/app.staging.toml")
+        );
+    }
+}
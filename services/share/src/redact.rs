@@ -0,0 +1,172 @@
+//! Redaction of personal data and credentials in log lines, applied to every
+//! formatted line before it is written. Addresses keep their network so a
+//! flood from one provider still shows, credentials are dropped entirely.
+//! `tracing.redact_pii = false` turns it off to debug a single voter.
+
+use std::{
+    borrow::Cow,
+    io,
+    net::{IpAddr, Ipv4Addr},
+    sync::LazyLock,
+};
+
+use regex::{Captures, Regex};
+use tracing_subscriber::fmt::MakeWriter;
+
+/// Runs which may hold an address, possibly with a port. Colour codes are
+/// matched on their own so a value printed right after one is a run of its
+/// own.
+static ADDRESS: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"\x1b\[[0-9;]*m|[0-9A-Za-z_:.%]+").expect("address pattern is valid")
+});
+static CREDENTIAL: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(
+        r#"(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+|\b(akv_)[A-Za-z0-9]+|\b(token|secret|password|api_key|x-ark-vote-key)(["']?\s*[=:]\s*["']?)[^\s"'&,;]+"#,
+    )
+    .expect("credential pattern is valid")
+});
+
+fn mask(ip: IpAddr) -> String {
+    match ip.to_canonical() {
+        IpAddr::V4(v4) => {
+            let [a, b, ..] = v4.octets();
+            format!("{a}.{b}.x.x")
+        }
+        IpAddr::V6(v6) => {
+            let [a, b, ..] = v6.segments();
+            format!("{a:x}:{b:x}::/32")
+        }
+    }
+}
+
+/// The masked form of a run which is an address, or an IPv4 address with a
+/// port. Dots and colons around it, e.g. ending a sentence, are kept.
+fn mask_run(run: &str) -> Option<String> {
+    if let Ok(ip) = run.parse::<IpAddr>() {
+        return Some(mask(ip));
+    }
+    let trimmed = run.trim_matches(['.', ':']);
+    let start = run.len() - run.trim_start_matches(['.', ':']).len();
+    let (before, after) = (&run[..start], &run[start + trimmed.len()..]);
+
+    if let Ok(ip) = trimmed.parse::<IpAddr>() {
+        return Some(format!("{before}{}{after}", mask(ip)));
+    }
+    let (host, port) = trimmed.rsplit_once(':')?;
+    let ip = host.parse::<Ipv4Addr>().ok()?;
+    port.parse::<u16>().ok()?;
+    Some(format!("{before}{}:{port}{after}", mask(IpAddr::V4(ip))))
+}
+
+/// Nearly everything is a run, so unlike `replace_all` this only allocates
+/// when an address is found.
+fn mask_addresses(line: &str) -> Cow<'_, str> {
+    let mut masked = String::new();
+    let mut last = 0;
+    for run in ADDRESS.find_iter(line) {
+        if let Some(replacement) = mask_run(run.as_str()) {
+            masked.push_str(&line[last..run.start()]);
+            masked.push_str(&replacement);
+            last = run.end();
+        }
+    }
+    if last == 0 {
+        return Cow::Borrowed(line);
+    }
+    masked.push_str(&line[last..]);
+    Cow::Owned(masked)
+}
+
+/// `line` with addresses masked to their network and credentials replaced.
+pub fn redact(line: &str) -> Cow<'_, str> {
+    let line = mask_addresses(line);
+    let redacted = match CREDENTIAL.replace_all(&line, |caps: &Captures| {
+        match (caps.get(1), caps.get(2), caps.get(3)) {
+            (Some(scheme), ..) => format!("{} [redacted]", scheme.as_str()),
+            (_, Some(prefix), _) => format!("{}[redacted]", prefix.as_str()),
+            (_, _, Some(key)) => format!("{}{}[redacted]", key.as_str(), &caps[4]),
+            _ => caps[0].to_string(),
+        }
+    }) {
+        Cow::Owned(redacted) => Some(redacted),
+        Cow::Borrowed(_) => None,
+    };
+    redacted.map_or(line, Cow::Owned)
+}
+
+/// Wraps the writer of a fmt layer, see the module docs.
+#[derive(Clone)]
+pub struct RedactingMakeWriter<M> {
+    inner: M,
+    enabled: bool,
+}
+
+impl<M> RedactingMakeWriter<M> {
+    pub fn new(inner: M, enabled: bool) -> Self {
+        Self { inner, enabled }
+    }
+}
+
+impl<'a, M: MakeWriter<'a>> MakeWriter<'a> for RedactingMakeWriter<M> {
+    type Writer = RedactingWriter<M::Writer>;
+
+    fn make_writer(&'a self) -> Self::Writer {
+        RedactingWriter {
+            inner: self.inner.make_writer(),
+            enabled: self.enabled,
+        }
+    }
+}
+
+pub struct RedactingWriter<W> {
+    inner: W,
+    enabled: bool,
+}
+
+impl<W: io::Write> io::Write for RedactingWriter<W> {
+    /// The fmt layers write each event at once, so a line is never split
+    /// between two calls.
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        if !self.enabled {
+            return self.inner.write(buf);
+        }
+        let text = String::from_utf8_lossy(buf);
+        match redact(&text) {
+            Cow::Borrowed(_) => self.inner.write_all(buf)?,
+            Cow::Owned(redacted) => self.inner.write_all(redacted.as_bytes())?,
+        }
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        self.inner.flush()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn addresses_and_credentials_are_masked() {
+        assert_eq!(
+            redact("vote from 203.0.113.7, then [2001:db8:1::7]:443 and 203.0.113.8:5000."),
+            "vote from 203.0.x.x, then [2001:db8::/32]:443 and 203.0.x.x:5000."
+        );
+        assert_eq!(
+            redact(r#"{"ip":"::ffff:198.51.100.1","api_key":"akv_abc123","auth":"Bearer eyJ.x"}"#),
+            r#"{"ip":"198.51.x.x","api_key":"[redacted]","auth":"Bearer [redacted]"}"#
+        );
+        assert_eq!(
+            redact("\x1b[2m=\x1b[0m203.0.113.7 created akv_abc123"),
+            "\x1b[2m=\x1b[0m203.0.x.x created akv_[redacted]"
+        );
+        assert_eq!(redact("token=abc&x=1"), "token=[redacted]&x=1");
+    }
+
+    #[test]
+    fn other_runs_are_left_alone() {
+        let line = "2026-10-15T12:34:56.789+08:00 web_service::api::ballot: saved v1.2.3";
+        assert_eq!(redact(line), line);
+    }
+}
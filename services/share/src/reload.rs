@@ -0,0 +1,231 @@
+use std::{
+    path::{Path, PathBuf},
+    sync::{Arc, LazyLock},
+};
+
+use tokio::sync::watch;
+
+use crate::{
+    config::{AppConfig, VoteConfig},
+    profile::{self, ProfileError},
+    secrets::{SecretError, SecretResolver},
+    validate::ConfigErrors,
+};
+
+/// Bumped by [`request_reload`], every [`ConfigWatch`] of the process
+/// listens to it.
+static RELOAD_REQUESTS: LazyLock<watch::Sender<u64>> = LazyLock::new(|| watch::channel(0).0);
+
+/// Asks every reloader in the process to re-read its config file, the admin
+/// endpoint's way of sending SIGHUP.
+pub fn request_reload() {
+    RELOAD_REQUESTS.send_modify(|generation| *generation += 1);
+}
+
+#[derive(thiserror::Error, Debug)]
+pub enum ReloadError {
+    #[error("failed to read {0}: {1}")]
+    Read(PathBuf, std::io::Error),
+    #[error("failed to parse {0}: {1}")]
+    Parse(PathBuf, toml::de::Error),
+    #[error(transparent)]
+    Profile(#[from] ProfileError),
+    #[error(transparent)]
+    Secret(#[from] SecretError),
+    #[error(transparent)]
+    Invalid(#[from] ConfigErrors),
+}
+
+/// The running config. Only the tunable sections are replaced on reload,
+/// changes to anything else are reported and wait for a restart.
+#[derive(Clone)]
+pub struct ConfigWatch {
+    tx: Arc<watch::Sender<Arc<AppConfig>>>,
+    path: Arc<PathBuf>,
+    secrets: Option<SecretResolver>,
+}
+
+impl ConfigWatch {
+    pub fn new(config: AppConfig, path: impl AsRef<Path>) -> Self {
+        Self {
+            tx: Arc::new(watch::channel(Arc::new(config)).0),
+            path: Arc::new(path.as_ref().to_path_buf()),
+            secrets: None,
+        }
+    }
+
+    /// Resolves the secret references of every reload, and re-reads them
+    /// every `secrets.refresh_secs` so rotated secrets are picked up.
+    pub fn with_secrets(mut self, resolver: SecretResolver) -> Self {
+        self.secrets = Some(resolver);
+        self
+    }
+
+    /// Cheap enough for every request, callers read it where they use it
+    /// instead of keeping copies of the values.
+    pub fn current(&self) -> Arc<AppConfig> {
+        self.tx.borrow().clone()
+    }
+
+    /// For the parts which rebuild state from the config when it changes.
+    pub fn subscribe(&self) -> watch::Receiver<Arc<AppConfig>> {
+        self.tx.subscribe()
+    }
+
+    /// Re-reads the file and returns the names of the sections that changed.
+    pub async fn reload(&self) -> Result<Vec<&'static str>, ReloadError> {
+        let path = self.path.as_path();
+        let data =
+            std::fs::read_to_string(path).map_err(|e| ReloadError::Read(path.to_path_buf(), e))?;
+        let table: toml::Table =
+            toml::from_str(&data).map_err(|e| ReloadError::Parse(path.to_path_buf(), e))?;
+        // the environment is fixed for the life of the process
+        let mut loaded = profile::resolve(path, table, Some(self.current().environment))?;
+        if let Some(secrets) = &self.secrets {
+            secrets.resolve_config(&mut loaded).await?;
+        }
+        // a broken edit keeps the running config
+        loaded.validate()?;
+
+        let current = self.current();
+        let (next, changed) = merge_tunables(&current, &loaded);
+        if changed.is_empty() {
+            return Ok(changed);
+        }
+
+        self.tx.send_replace(Arc::new(next));
+        Ok(changed)
+    }
+
+    /// Reloads on SIGHUP, on [`request_reload`] and for the secret refresh
+    /// until the runtime stops. In prefork mode every worker has its own,
+    /// signal the workers rather than the supervisor.
+    pub fn spawn_reloader(&self) -> tokio::task::JoinHandle<Option<()>> {
+        let watch = self.clone();
+        let mut requests = RELOAD_REQUESTS.subscribe();
+        let refresh_secs = match self.secrets {
+            Some(_) => self.current().secrets.refresh_secs,
+            None => 0,
+        };
+
+        #[cfg(unix)]
+        let mut sighup =
+            tokio::signal::unix::signal(tokio::signal::unix::SignalKind::hangup()).unwrap();
+
+        crate::task::spawn("config_reloader", async move {
+            loop {
+                #[cfg(unix)]
+                let hangup = sighup.recv();
+                #[cfg(not(unix))]
+                let hangup = std::future::pending::<Option<()>>();
+
+                let refresh = async {
+                    match refresh_secs {
+                        0 => std::future::pending().await,
+                        secs => tokio::time::sleep(std::time::Duration::from_secs(secs)).await,
+                    }
+                };
+
+                let source = tokio::select! {
+                    _ = hangup => "SIGHUP",
+                    changed = requests.changed() => match changed {
+                        Ok(()) => "admin",
+                        Err(_) => return,
+                    },
+                    _ = refresh => "secret refresh",
+                };
+
+                match watch.reload().await {
+                    // the refresh runs every few minutes, only rotations are news
+                    Ok(changed) if changed.is_empty() && source == "secret refresh" => {}
+                    Ok(changed) if changed.is_empty() => {
+                        tracing::info!(%source, "config reloaded, nothing changed");
+                    }
+                    Ok(changed) => tracing::warn!(%source, ?changed, "config reloaded"),
+                    Err(e) => tracing::error!(%source, "config reload failed: {}", e),
+                }
+            }
+        })
+    }
+}
+
+/// Takes the tunable sections of `loaded` over `current` and returns which
+/// of them changed.
+fn merge_tunables(current: &AppConfig, loaded: &AppConfig) -> (AppConfig, Vec<&'static str>) {
+    let mut next = current.clone();
+    // `loaded` with the tunables put back, anything still differing from
+    // `current` only takes effect after a restart
+    let mut restart_only = loaded.clone();
+    let mut changed = Vec::new();
+
+    macro_rules! tunable {
+        ($section:ident) => {
+            if format!("{:?}", current.$section) != format!("{:?}", loaded.$section) {
+                next.$section = loaded.$section.clone();
+                changed.push(stringify!($section));
+            }
+            restart_only.$section = current.$section.clone();
+        };
+    }
+
+    // the limits and multipliers are read per ballot, the preset topics are
+    // only synced to the database at startup
+    let vote = VoteConfig {
+        preset_vote_topic: current.vote.preset_vote_topic.clone(),
+        ..loaded.vote.clone()
+    };
+    if format!("{:?}", current.vote) != format!("{vote:?}") {
+        next.vote = vote;
+        changed.push("vote");
+    }
+    restart_only.vote = VoteConfig {
+        preset_vote_topic: loaded.vote.preset_vote_topic.clone(),
+        ..current.vote.clone()
+    };
+
+    // rotated static keys are swapped in by the api key service
+    if format!("{:?}", current.api_key.static_keys) != format!("{:?}", loaded.api_key.static_keys) {
+        next.api_key.static_keys = loaded.api_key.static_keys.clone();
+        changed.push("api_key.static_keys");
+    }
+    restart_only.api_key.static_keys = current.api_key.static_keys.clone();
+
+    tunable!(ballot_flush);
+    tunable!(results_precompute);
+    tunable!(load_shed);
+    tunable!(access_log);
+    tunable!(maintenance);
+    tunable!(word_filter);
+
+    if format!("{restart_only:?}") != format!("{current:?}") {
+        tracing::warn!("config changes outside the tunable sections need a restart");
+    }
+
+    (next, changed)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::TomlConfig as _;
+
+    #[test]
+    fn only_tunables_are_taken_over() {
+        let current: AppConfig = toml::from_str(AppConfig::DEFAULT_TOML).unwrap();
+        let mut loaded = current.clone();
+        loaded.load_shed.max_in_flight = 1;
+        loaded.vote.max_ip_limit = 7;
+        loaded.vote.preset_vote_topic.clear();
+        loaded.server.port = 1;
+
+        let (next, changed) = merge_tunables(&current, &loaded);
+        assert_eq!(changed, ["vote", "load_shed"]);
+        assert_eq!(next.load_shed.max_in_flight, 1);
+        assert_eq!(next.vote.max_ip_limit, 7);
+        assert_eq!(
+            next.vote.preset_vote_topic.len(),
+            current.vote.preset_vote_topic.len()
+        );
+        assert_eq!(next.server.port, current.server.port);
+    }
+}
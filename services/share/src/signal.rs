@@ -11,6 +11,41 @@ pub fn channel(kind: ShutdownKind) -> (ShutdownTx, ShutdownRx) {
     tokio::sync::watch::channel(kind)
 }
 
+/// Held by loops which buffer work worth finishing on shutdown, e.g. a
+/// consumer mid-batch. They check [`DrainGuard::is_shutting_down`] between
+/// batches and drop the guard once their buffer is flushed.
+#[derive(Clone)]
+pub struct DrainGuard {
+    shutdown_rx: ShutdownRx,
+    _done: tokio::sync::mpsc::Sender<()>,
+}
+
+impl DrainGuard {
+    pub fn is_shutting_down(&self) -> bool {
+        self.shutdown_rx.has_changed().unwrap_or(true)
+    }
+}
+
+pub struct DrainWaiter(tokio::sync::mpsc::Receiver<()>);
+
+impl DrainWaiter {
+    /// Waits until every guard is dropped, false if `timeout` ran out first.
+    pub async fn wait(mut self, timeout: std::time::Duration) -> bool {
+        tokio::time::timeout(timeout, self.0.recv()).await.is_ok()
+    }
+}
+
+/// Create before the first `changed().await` on `shutdown_rx`, the guards
+/// see the shutdown as a change.
+pub fn drain(shutdown_rx: &ShutdownRx) -> (DrainGuard, DrainWaiter) {
+    let (done_tx, done_rx) = tokio::sync::mpsc::channel(1);
+    let guard = DrainGuard {
+        shutdown_rx: shutdown_rx.clone(),
+        _done: done_tx,
+    };
+    (guard, DrainWaiter(done_rx))
+}
+
 #[derive(Copy, Clone, PartialEq, Default, Debug)]
 pub enum ShutdownKind {
     #[default]
@@ -24,26 +59,37 @@ impl ShutdownKind {
     fn spawn_signal_handler(shutdown_tx: ShutdownTx) {
         // crate::metrics::shutdown_initiated().set(false as _);
 
-        #[cfg(target_os = "linux")]
+        #[cfg(unix)]
         let mut sig_term_fut =
             tokio::signal::unix::signal(tokio::signal::unix::SignalKind::terminate()).unwrap();
 
-        tokio::spawn(async move {
-            #[cfg(target_os = "linux")]
-            let sig_term = sig_term_fut.recv();
-            #[cfg(not(target_os = "linux"))]
-            let sig_term = std::future::pending();
-
-            let signal = tokio::select! {
-                _ = tokio::signal::ctrl_c() => "SIGINT",
-                _ = sig_term => "SIGTERM",
-            };
-
-            // crate::metrics::shutdown_initiated().set(true as _);
-            tracing::info!(%signal, "shutting down from signal");
-            // Don't unwrap in order to ensure that we execute
-            // any subsequent shutdown tasks.
-            shutdown_tx.send(Self::Normal).ok();
+        crate::task::spawn("signal_handler", async move {
+            let mut received = 0;
+            loop {
+                #[cfg(unix)]
+                let sig_term = sig_term_fut.recv();
+                #[cfg(not(unix))]
+                let sig_term = std::future::pending::<Option<()>>();
+
+                let signal = tokio::select! {
+                    _ = tokio::signal::ctrl_c() => "SIGINT",
+                    _ = sig_term => "SIGTERM",
+                };
+                received += 1;
+
+                // a second signal means the drain is taking too long for
+                // whoever sent it
+                if received > 1 {
+                    tracing::warn!(%signal, "second signal during shutdown, exiting now");
+                    std::process::exit(130);
+                }
+
+                // crate::metrics::shutdown_initiated().set(true as _);
+                tracing::info!(%signal, "shutting down from signal");
+                // Don't unwrap in order to ensure that we execute
+                // any subsequent shutdown tasks.
+                shutdown_tx.send(Self::Normal).ok();
+            }
         });
     }
 }
@@ -2,7 +2,7 @@ use std::sync::Arc;
 
 use chrono::Utc;
 use parking_lot::Mutex;
-use serde::Deserialize;
+use serde::{Deserialize, Serialize};
 
 const BIT_LEN_TIME: u64 = 39;
 const BIT_LEN_SEQUENCE: u64 = 12;
@@ -16,7 +16,7 @@ pub enum SnowflakeError {
     MutexPoisoned,
 }
 
-#[derive(Clone, Debug, Deserialize)]
+#[derive(Clone, Debug, Deserialize, Serialize)]
 pub struct SnowflakeConfig {
     pub datacenter_id: u8,
     pub epoch: u64, // Timestamp in milliseconds
@@ -0,0 +1,86 @@
+//! The `sd_notify` protocol, without linking libsystemd: datagrams to the
+//! socket in `NOTIFY_SOCKET`. Everything here does nothing when the process
+//! isn't run by systemd with `Type=notify`.
+//!
+//! Socket activation is handled by the web service's listener handoff.
+
+use std::time::Duration;
+
+/// Sends `state` to systemd, false when not running under it or sending
+/// failed, which callers only log.
+pub fn notify(state: &str) -> bool {
+    #[cfg(unix)]
+    {
+        use std::os::unix::net::UnixDatagram;
+
+        let Some(path) = std::env::var_os("NOTIFY_SOCKET") else {
+            return false;
+        };
+        let Ok(socket) = UnixDatagram::unbound() else {
+            return false;
+        };
+
+        let path = path.to_string_lossy();
+        let sent = match path.strip_prefix('@') {
+            #[cfg(target_os = "linux")]
+            Some(name) => {
+                use std::os::linux::net::SocketAddrExt as _;
+
+                std::os::unix::net::SocketAddr::from_abstract_name(name)
+                    .and_then(|addr| socket.send_to_addr(state.as_bytes(), &addr))
+            }
+            #[cfg(not(target_os = "linux"))]
+            Some(_) => return false,
+            None => socket.send_to(state.as_bytes(), path.as_ref()),
+        };
+        if let Err(e) = &sent {
+            tracing::warn!("failed to notify systemd: {}", e);
+        }
+        sent.is_ok()
+    }
+    #[cfg(not(unix))]
+    {
+        let _ = state;
+        false
+    }
+}
+
+/// Startup finished. Also names us the main process, after a listener
+/// handoff that is the replacement, which needs `NotifyAccess=all`.
+pub fn ready() {
+    if notify(&format!("READY=1\nMAINPID={}", std::process::id())) {
+        tracing::debug!("notified systemd: ready");
+    }
+}
+
+pub fn stopping() {
+    notify("STOPPING=1");
+}
+
+/// The ping interval `WatchdogSec=` asks for, `None` without a watchdog or
+/// when it is meant for another process.
+fn watchdog_interval() -> Option<Duration> {
+    if let Some(pid) = std::env::var("WATCHDOG_PID").ok()
+        && pid.parse::<u32>().ok() != Some(std::process::id())
+    {
+        return None;
+    }
+    let usec: u64 = std::env::var("WATCHDOG_USEC").ok()?.parse().ok()?;
+    // systemd recommends pinging at half the timeout
+    (usec > 0).then(|| Duration::from_micros(usec / 2))
+}
+
+/// Pings the watchdog from the runtime, a wedged runtime stops the pings
+/// and systemd restarts the service.
+pub fn spawn_watchdog() -> Option<tokio::task::JoinHandle<Option<()>>> {
+    let interval = watchdog_interval()?;
+    tracing::info!("systemd watchdog enabled, pinging every {:?}", interval);
+
+    Some(crate::task::spawn("systemd_watchdog", async move {
+        let mut ticker = tokio::time::interval(interval);
+        loop {
+            ticker.tick().await;
+            notify("WATCHDOG=1");
+        }
+    }))
+}
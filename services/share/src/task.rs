@@ -0,0 +1,130 @@
+//! Spawning that survives panics. A panic in a spawned task or thread is
+//! logged with its backtrace by [`install_panic_hook`] and caught here, so it
+//! ends that task and nothing else.
+
+use std::{
+    any::Any,
+    backtrace::Backtrace,
+    cell::Cell,
+    future::Future,
+    panic::{self, AssertUnwindSafe},
+};
+
+use futures::FutureExt as _;
+use tokio::task::JoinHandle;
+
+pub mod pool;
+
+thread_local! {
+    static GUARDED: Cell<usize> = const { Cell::new(0) };
+}
+
+/// Marks the current thread as running guarded code until dropped.
+struct Guard;
+
+impl Guard {
+    fn enter() -> Self {
+        GUARDED.set(GUARDED.get() + 1);
+        Guard
+    }
+}
+
+impl Drop for Guard {
+    fn drop(&mut self) {
+        GUARDED.set(GUARDED.get() - 1);
+    }
+}
+
+/// Whether a panic on this thread right now would be caught by [`spawn`] or
+/// [`spawn_thread`], for panic hooks which should not treat it as fatal.
+pub fn is_guarded() -> bool {
+    GUARDED.get() > 0
+}
+
+/// The message a panic was started with.
+pub fn panic_message(payload: &(dyn Any + Send)) -> &str {
+    payload
+        .downcast_ref::<&str>()
+        .copied()
+        .or_else(|| payload.downcast_ref::<String>().map(String::as_str))
+        .unwrap_or("unknown panic")
+}
+
+/// Runs `task`, resolving to `None` if it panicked.
+pub async fn guarded<F: Future>(name: &'static str, task: F) -> Option<F::Output> {
+    let mut task = std::pin::pin!(AssertUnwindSafe(task).catch_unwind());
+    let result = std::future::poll_fn(|cx| {
+        let _guard = Guard::enter();
+        task.as_mut().poll(cx)
+    })
+    .await;
+
+    result
+        .inspect_err(|e| tracing::error!(task = name, "task panicked: {}", panic_message(&**e)))
+        .ok()
+}
+
+/// [`tokio::spawn`] for [`guarded`].
+pub fn spawn<F>(name: &'static str, task: F) -> JoinHandle<Option<F::Output>>
+where
+    F: Future + Send + 'static,
+    F::Output: Send + 'static,
+{
+    tokio::spawn(guarded(name, task))
+}
+
+/// A named std thread running `f`, joining to `None` if it panicked.
+pub fn spawn_thread<F, T>(
+    name: impl Into<String>,
+    f: F,
+) -> std::io::Result<std::thread::JoinHandle<Option<T>>>
+where
+    F: FnOnce() -> T + Send + 'static,
+    T: Send + 'static,
+{
+    let name = name.into();
+    std::thread::Builder::new()
+        .name(name.clone())
+        .spawn(move || {
+            let _guard = Guard::enter();
+            panic::catch_unwind(AssertUnwindSafe(f))
+                .inspect_err(
+                    |e| tracing::error!(thread = %name, "thread panicked: {}", panic_message(&**e)),
+                )
+                .ok()
+        })
+}
+
+/// Logs every panic with its location and backtrace through tracing, which
+/// also takes it to Sentry when a DSN is configured. Replaces the default
+/// hook, so it is installed once tracing is up and before any hook that
+/// chains to the previous one.
+pub fn install_panic_hook() {
+    panic::set_hook(Box::new(|info| {
+        let thread = std::thread::current();
+        tracing::error!(
+            thread = thread.name().unwrap_or("unnamed"),
+            location = info.location().map(tracing::field::display),
+            guarded = is_guarded(),
+            backtrace = %Backtrace::force_capture(),
+            "panicked: {}",
+            panic_message(info.payload())
+        );
+    }));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn panics_end_only_the_task() {
+        let panicked = spawn("panics", async { panic!("boom") });
+        assert_eq!(panicked.await.unwrap(), None::<()>);
+        assert_eq!(spawn("returns", async { 1 }).await.unwrap(), Some(1));
+        assert!(!is_guarded());
+
+        let thread = spawn_thread::<_, ()>("panics", || panic!("boom")).unwrap();
+        assert_eq!(thread.join().unwrap(), None);
+    }
+}
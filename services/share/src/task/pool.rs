@@ -0,0 +1,160 @@
+//! A fixed number of workers behind a bounded queue, so a burst of work
+//! waits its turn instead of turning into as many tasks.
+
+use std::{pin::Pin, sync::Arc};
+
+use serde::Serialize;
+use tokio::{
+    sync::{Semaphore, mpsc},
+    task::JoinSet,
+};
+
+use crate::jobs::{JobManager, Stage};
+
+type Task = Pin<Box<dyn Future<Output = ()> + Send + 'static>>;
+
+#[derive(thiserror::Error, Debug)]
+pub enum PoolError {
+    #[error("{0} pool queue is full")]
+    Full(&'static str),
+    #[error("{0} pool is shut down")]
+    Closed(&'static str),
+}
+
+#[derive(Clone, Debug, Serialize)]
+pub struct PoolStats {
+    pub queued: usize,
+    pub running: usize,
+    pub concurrency: usize,
+}
+
+#[derive(Clone)]
+pub struct Pool {
+    name: &'static str,
+    tx: mpsc::Sender<Task>,
+    permits: Arc<Semaphore>,
+    concurrency: usize,
+}
+
+impl Pool {
+    /// Runs at most `concurrency` tasks at once with up to `queue_size`
+    /// waiting. The dispatcher is a flusher job of `jobs`, shutdown runs what
+    /// is queued before it stops.
+    pub fn new(
+        name: &'static str,
+        concurrency: usize,
+        queue_size: usize,
+        jobs: &JobManager,
+    ) -> Self {
+        let (tx, mut rx) = mpsc::channel::<Task>(queue_size.max(1));
+        let permits = Arc::new(Semaphore::new(concurrency.max(1)));
+
+        let dispatch_permits = permits.clone();
+        jobs.spawn_graceful(name, Stage::Flusher, move |mut stopping| async move {
+            let mut running = JoinSet::new();
+            let mut stopped = false;
+            loop {
+                let task = tokio::select! {
+                    task = rx.recv() => match task {
+                        Some(task) => task,
+                        None => break,
+                    },
+                    _ = stopping.wait(), if !stopped => {
+                        // the rest of the queue still comes out of recv
+                        stopped = true;
+                        rx.close();
+                        continue;
+                    }
+                };
+                let permit = dispatch_permits
+                    .clone()
+                    .acquire_owned()
+                    .await
+                    .expect("the pool semaphore is never closed");
+                running.spawn(async move {
+                    super::guarded(name, task).await;
+                    drop(permit);
+                });
+                while running.try_join_next().is_some() {}
+            }
+            while running.join_next().await.is_some() {}
+        });
+
+        Self {
+            name,
+            tx,
+            permits,
+            concurrency: concurrency.max(1),
+        }
+    }
+
+    /// Queues `task`, waiting for room while the queue is full.
+    pub async fn submit<F>(&self, task: F) -> Result<(), PoolError>
+    where
+        F: Future<Output = ()> + Send + 'static,
+    {
+        self.tx
+            .send(Box::pin(task))
+            .await
+            .map_err(|_| PoolError::Closed(self.name))
+    }
+
+    /// Queues `task` unless the queue is full.
+    pub fn try_submit<F>(&self, task: F) -> Result<(), PoolError>
+    where
+        F: Future<Output = ()> + Send + 'static,
+    {
+        self.tx.try_send(Box::pin(task)).map_err(|e| match e {
+            mpsc::error::TrySendError::Full(_) => PoolError::Full(self.name),
+            mpsc::error::TrySendError::Closed(_) => PoolError::Closed(self.name),
+        })
+    }
+
+    pub fn stats(&self) -> PoolStats {
+        PoolStats {
+            queued: self.tx.max_capacity() - self.tx.capacity(),
+            running: self.concurrency - self.permits.available_permits(),
+            concurrency: self.concurrency,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::{
+        sync::atomic::{AtomicUsize, Ordering::SeqCst},
+        time::Duration,
+    };
+
+    use super::*;
+
+    #[tokio::test]
+    async fn runs_bounded_and_drains_on_shutdown() {
+        let jobs = JobManager::new();
+        let pool = Pool::new("test", 2, 16, &jobs);
+        let running = Arc::new(AtomicUsize::new(0));
+        let peak = Arc::new(AtomicUsize::new(0));
+        let done = Arc::new(AtomicUsize::new(0));
+
+        for _ in 0..8 {
+            let (running, peak, done) = (running.clone(), peak.clone(), done.clone());
+            pool.submit(async move {
+                peak.fetch_max(running.fetch_add(1, SeqCst) + 1, SeqCst);
+                tokio::time::sleep(Duration::from_millis(5)).await;
+                running.fetch_sub(1, SeqCst);
+                done.fetch_add(1, SeqCst);
+            })
+            .await
+            .unwrap();
+        }
+        pool.submit(async { panic!("boom") }).await.unwrap();
+
+        jobs.shutdown(Duration::from_secs(5)).await;
+        assert_eq!(done.load(SeqCst), 8);
+        assert!(peak.load(SeqCst) <= 2);
+        assert!(matches!(
+            pool.try_submit(async {}),
+            Err(PoolError::Closed("test"))
+        ));
+    }
+}
@@ -1,15 +1,73 @@
-use std::io::IsTerminal as _;
+use std::{io::IsTerminal as _, sync::OnceLock};
 
+use axum::http::{HeaderMap, HeaderName, HeaderValue};
 use chrono::{DateTime, Utc};
 use chrono_tz::Asia::Shanghai;
+use opentelemetry::{
+    global,
+    propagation::{Extractor, Injector},
+    trace::TracerProvider as _,
+};
+use opentelemetry_otlp::WithExportConfig as _;
+use opentelemetry_sdk::{
+    Resource,
+    propagation::TraceContextPropagator,
+    trace::{Sampler, SdkTracerProvider},
+};
 use tracing_appender::{non_blocking::WorkerGuard, rolling};
+use tracing_opentelemetry::OpenTelemetrySpanExt as _;
 use tracing_subscriber::{
+    EnvFilter, Registry,
+    filter::ParseError,
     fmt::{self, time::FormatTime},
     layer::SubscriberExt as _,
+    reload,
     util::SubscriberInitExt as _,
 };
 
-use crate::config::TracingConfig;
+use crate::{
+    config::{LogFormat, OtlpConfig, TracingConfig},
+    redact::RedactingMakeWriter,
+};
+
+struct LogFilter {
+    handle: reload::Handle<EnvFilter, Registry>,
+    /// What the config asked for, restored by [`reset_log_filter`].
+    initial: String,
+}
+
+static LOG_FILTER: OnceLock<LogFilter> = OnceLock::new();
+
+#[derive(thiserror::Error, Debug)]
+pub enum LogFilterError {
+    #[error("tracing subscriber is not initialized")]
+    Uninitialized,
+    #[error("invalid filter directives: {0}")]
+    Parse(#[from] ParseError),
+    #[error("failed to reload filter: {0}")]
+    Reload(#[from] reload::Error),
+}
+
+/// The filter currently in effect, e.g. `info,web_service=debug`.
+pub fn log_filter() -> Option<String> {
+    let filter = LOG_FILTER.get()?;
+    filter.handle.with_current(ToString::to_string).ok()
+}
+
+/// Replaces the whole filter. Targets not named in `directives` are off, so it
+/// usually starts with a default level: `info,web_service::api=trace`.
+pub fn set_log_filter(directives: &str) -> Result<(), LogFilterError> {
+    let filter = LOG_FILTER.get().ok_or(LogFilterError::Uninitialized)?;
+    let new_filter = EnvFilter::try_new(directives)?;
+    filter.handle.reload(new_filter)?;
+    Ok(())
+}
+
+/// Goes back to the filter built from the config at startup.
+pub fn reset_log_filter() -> Result<(), LogFilterError> {
+    let filter = LOG_FILTER.get().ok_or(LogFilterError::Uninitialized)?;
+    set_log_filter(&filter.initial)
+}
 
 struct East8Time;
 
@@ -25,10 +83,89 @@ impl FormatTime for East8Time {
     }
 }
 
+/// Flushes the log file and the pending spans when dropped.
+pub struct TracingGuard {
+    _file: WorkerGuard,
+    tracer_provider: Option<SdkTracerProvider>,
+}
+
+impl Drop for TracingGuard {
+    fn drop(&mut self) {
+        if let Some(provider) = self.tracer_provider.take()
+            && let Err(e) = provider.shutdown()
+        {
+            eprintln!("failed to flush spans: {e}");
+        }
+    }
+}
+
+fn otlp_tracer_provider(
+    config: &OtlpConfig,
+    service: &str,
+) -> Result<SdkTracerProvider, opentelemetry_otlp::ExporterBuildError> {
+    let exporter = opentelemetry_otlp::SpanExporter::builder()
+        .with_tonic()
+        .with_endpoint(&config.endpoint)
+        .build()?;
+
+    Ok(SdkTracerProvider::builder()
+        .with_batch_exporter(exporter)
+        .with_sampler(Sampler::ParentBased(Box::new(Sampler::TraceIdRatioBased(
+            config.sample_ratio,
+        ))))
+        .with_resource(
+            Resource::builder()
+                .with_service_name(service.to_string())
+                .build(),
+        )
+        .build())
+}
+
+struct HeaderExtractor<'a>(&'a HeaderMap);
+
+impl Extractor for HeaderExtractor<'_> {
+    fn get(&self, key: &str) -> Option<&str> {
+        self.0.get(key).and_then(|value| value.to_str().ok())
+    }
+
+    fn keys(&self) -> Vec<&str> {
+        self.0.keys().map(HeaderName::as_str).collect()
+    }
+}
+
+struct HeaderInjector<'a>(&'a mut HeaderMap);
+
+impl Injector for HeaderInjector<'_> {
+    fn set(&mut self, key: &str, value: String) {
+        if let (Ok(name), Ok(value)) = (
+            HeaderName::from_bytes(key.as_bytes()),
+            HeaderValue::from_str(&value),
+        ) {
+            self.0.insert(name, value);
+        }
+    }
+}
+
+/// Continues the trace of the `traceparent` header in `span`, so a request
+/// made by a traced client shows up inside the client's trace.
+pub fn set_remote_parent(span: &tracing::Span, headers: &HeaderMap) {
+    let context =
+        global::get_text_map_propagator(|propagator| propagator.extract(&HeaderExtractor(headers)));
+    span.set_parent(context);
+}
+
+/// Adds the `traceparent` of the current span to outgoing headers.
+pub fn inject_current_context(headers: &mut HeaderMap) {
+    let context = tracing::Span::current().context();
+    global::get_text_map_propagator(|propagator| {
+        propagator.inject_context(&context, &mut HeaderInjector(headers))
+    });
+}
+
 pub fn init_tracing_subscriber(
     trace_config: &TracingConfig,
     log_target_service: &str,
-) -> WorkerGuard {
+) -> TracingGuard {
     let mut env_filter = tracing_subscriber::EnvFilter::builder()
         .with_default_directive(trace_config.level.parse().unwrap_or_else(|_| {
             tracing::error!("invalid log level in config, defaulting to DEBUG");
@@ -47,11 +184,39 @@ pub fn init_tracing_subscriber(
         }
     }
 
+    let initial = env_filter.to_string();
+    let (env_filter, filter_handle) = reload::Layer::new(env_filter);
+    let _ = LOG_FILTER.set(LogFilter {
+        handle: filter_handle,
+        initial,
+    });
+
+    let redact = trace_config.redact_pii;
     let is_terminal = std::io::stdout().is_terminal();
-    let terminal_layer = fmt::layer()
-        .with_ansi(is_terminal)
-        .with_target(false)
-        .with_timer(East8Time);
+    let (pretty_layer, json_layer) = match trace_config.format.unwrap_or(LogFormat::Pretty) {
+        LogFormat::Pretty => (
+            Some(
+                fmt::layer()
+                    .with_ansi(is_terminal)
+                    .with_target(false)
+                    .with_writer(RedactingMakeWriter::new(std::io::stdout, redact))
+                    .with_timer(East8Time),
+            ),
+            None,
+        ),
+        // for log collectors reading the container output
+        LogFormat::Json => (
+            None,
+            Some(
+                fmt::layer()
+                    .json()
+                    .with_ansi(false)
+                    .with_target(true)
+                    .with_writer(RedactingMakeWriter::new(std::io::stdout, redact))
+                    .with_timer(East8Time),
+            ),
+        ),
+    };
 
     let file_appender = rolling::daily(
         &trace_config.log_file_directory,
@@ -62,15 +227,36 @@ pub fn init_tracing_subscriber(
         .json()
         .with_ansi(false)
         .with_target(true)
-        .with_writer(non_blocking)
+        .with_writer(RedactingMakeWriter::new(non_blocking, redact))
         .with_timer(East8Time);
 
+    let tracer_provider = match trace_config.otlp.enabled {
+        true => match otlp_tracer_provider(&trace_config.otlp, log_target_service) {
+            Ok(provider) => Some(provider),
+            Err(e) => {
+                eprintln!("failed to set up the OTLP exporter, spans are not exported: {e}");
+                None
+            }
+        },
+        false => None,
+    };
+    let otel_layer = tracer_provider.as_ref().map(|provider| {
+        global::set_text_map_propagator(TraceContextPropagator::new());
+        tracing_opentelemetry::layer().with_tracer(provider.tracer("ark-vote"))
+    });
+
     tracing_subscriber::registry()
         .with(env_filter)
-        .with(terminal_layer)
+        .with(pretty_layer)
+        .with(json_layer)
         .with(file_layer)
+        .with(otel_layer)
         .with(sentry::integrations::tracing::layer())
+        .with(crate::alert::ErrorCountLayer)
         .init();
 
-    guard
+    TracingGuard {
+        _file: guard,
+        tracer_provider,
+    }
 }
@@ -0,0 +1,388 @@
+use std::{fmt, str::FromStr as _};
+
+use axum::http::{HeaderName, HeaderValue, Method};
+use reqwest::Url;
+
+use crate::config::{AlertKind, AppConfig, IpStorage, NotifyTarget};
+
+/// Every problem found in the config, reported at once so a broken deploy
+/// takes one round of fixes instead of one per missing value.
+#[derive(thiserror::Error, Debug)]
+pub struct ConfigErrors(pub Vec<String>);
+
+impl fmt::Display for ConfigErrors {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "invalid configuration:")?;
+        for error in &self.0 {
+            write!(f, "\n  - {error}")?;
+        }
+        Ok(())
+    }
+}
+
+fn has_scheme(url: &str, schemes: &[&str]) -> bool {
+    Url::parse(url).is_ok_and(|url| schemes.contains(&url.scheme()))
+}
+
+impl AppConfig {
+    /// Checks the values serde can't, after the secrets were resolved.
+    /// Values which used to be dropped or only failed on first use, such as
+    /// unknown CORS methods or an alert without a url, are errors here.
+    pub fn validate(&self) -> Result<(), ConfigErrors> {
+        let mut errors = Vec::new();
+        let mut check = |ok: bool, error: String| {
+            if !ok {
+                errors.push(error);
+            }
+        };
+
+        check(
+            !self.server.host.is_empty(),
+            "server.host is empty".to_string(),
+        );
+        check(
+            self.server.port != 0,
+            "server.port must not be 0".to_string(),
+        );
+        check(
+            self.server
+                .address()
+                .parse::<std::net::SocketAddr>()
+                .is_ok(),
+            format!("server.host: {:?} is not an ip address", self.server.host),
+        );
+        check(
+            self.server.request_timeout_secs > 0 && self.server.max_body_bytes > 0,
+            "server.request_timeout_secs and server.max_body_bytes must not be 0".to_string(),
+        );
+        check(
+            self.vote.ipv6_prefix_len <= 128,
+            "vote.ipv6_prefix_len must be at most 128".to_string(),
+        );
+        check(
+            self.vote.ip_storage == IpStorage::Raw || !self.vote.ip_hash_key.is_empty(),
+            "vote.ip_hash_key is required for the hashed and truncated ip_storage".to_string(),
+        );
+        check(
+            self.vote.ip_storage != IpStorage::Truncated || self.vote.max_ip_limit < 0,
+            "vote.max_ip_limit must be -1 for the truncated ip_storage, it would count whole networks"
+                .to_string(),
+        );
+        if self.server.unix_socket.is_some() {
+            check(
+                !self.server.prefork(),
+                "server.unix_socket can't be used with prefork_workers".to_string(),
+            );
+            check(
+                HeaderName::from_bytes(self.server.unix_socket_client_ip_header.as_bytes()).is_ok(),
+                format!(
+                    "server.unix_socket_client_ip_header: {:?} is not a header name",
+                    self.server.unix_socket_client_ip_header
+                ),
+            );
+        }
+
+        let database = &self.database;
+        check(
+            has_scheme(
+                &database.redis_url,
+                &["redis", "rediss", "unix", "redis+unix"],
+            ),
+            "database.redis_url is not a redis:// url".to_string(),
+        );
+        check(
+            has_scheme(&database.mongodb_url, &["mongodb", "mongodb+srv"]),
+            "database.mongodb_url is not a mongodb:// url".to_string(),
+        );
+        check(
+            !database.mongodb_database.is_empty(),
+            "database.mongodb_database is empty".to_string(),
+        );
+        check(
+            !self.nats.url.trim().is_empty(),
+            "nats.url is empty".to_string(),
+        );
+
+        check(
+            self.sentry.dsn.is_empty() || self.sentry.dsn.parse::<sentry::types::Dsn>().is_ok(),
+            "sentry.dsn is not a valid dsn".to_string(),
+        );
+
+        for method in &self.cors.allow_methods {
+            check(
+                method.parse::<Method>().is_ok(),
+                format!("cors.allow_methods: {method:?} is not an http method"),
+            );
+        }
+        for origin in &self.cors.allow_origin {
+            check(
+                origin == "*" || HeaderValue::from_str(origin).is_ok(),
+                format!("cors.allow_origin: {origin:?} is not a valid origin"),
+            );
+        }
+        if self.cors.any_origin() {
+            check(
+                self.cors.allow_origin.len() == 1 && self.cors.allow_origin_regex.is_none(),
+                "cors.allow_origin: \"*\" can't be combined with other origins".to_string(),
+            );
+            check(
+                !self.cors.allow_credentials,
+                "cors.allow_credentials can't be used with any origin".to_string(),
+            );
+        }
+        if let Err(e) = self.cors.origin_regex() {
+            check(false, format!("cors.allow_origin_regex is invalid: {e}"));
+        }
+
+        check(
+            HeaderName::from_bytes(self.api_key.header.as_bytes()).is_ok(),
+            format!(
+                "api_key.header: {:?} is not a header name",
+                self.api_key.header
+            ),
+        );
+        check(
+            self.api_key.signature_max_skew_secs > 0,
+            "api_key.signature_max_skew_secs must not be 0".to_string(),
+        );
+        check(
+            !self.api_key.require_signed_admin || !self.api_key.signing_pepper.is_empty(),
+            "api_key.require_signed_admin needs api_key.signing_pepper".to_string(),
+        );
+        for static_key in &self.api_key.static_keys {
+            check(
+                !static_key.key.is_empty(),
+                format!("api_key.static_keys: {} has an empty key", static_key.name),
+            );
+        }
+
+        if self.alert.enabled {
+            check(
+                has_scheme(&self.alert.url, &["http", "https"]),
+                "alert.url must be an http(s) url while alerting is enabled".to_string(),
+            );
+            check(
+                !matches!(self.alert.kind, AlertKind::QqBot) || self.alert.qq_group_id.is_some(),
+                "alert.qq_group_id is required for the qq_bot kind".to_string(),
+            );
+        }
+
+        for (i, channel) in self.notify.channels.iter().enumerate() {
+            check(
+                !channel.name.is_empty()
+                    && !self.notify.channels[..i]
+                        .iter()
+                        .any(|other| other.name == channel.name),
+                format!("notify.channels: {:?} is empty or not unique", channel.name),
+            );
+            match &channel.target {
+                NotifyTarget::Email { smtp_url, to, .. } => {
+                    check(
+                        has_scheme(smtp_url, &["smtp", "smtps"]),
+                        format!("notify.channels: {} needs an smtp(s) url", channel.name),
+                    );
+                    // personal mail is sent to the address of the notification
+                    check(
+                        !to.is_empty()
+                            || (!channel.kinds.is_empty()
+                                && channel.kinds.iter().all(|kind| kind.is_personal())),
+                        format!("notify.channels: {} has no recipients", channel.name),
+                    );
+                }
+                NotifyTarget::Webhook { url } => check(
+                    has_scheme(url, &["http", "https"]),
+                    format!("notify.channels: {} needs an http(s) url", channel.name),
+                ),
+            }
+        }
+
+        if let Some(vault) = &self.secrets.vault {
+            check(
+                has_scheme(&vault.addr, &["http", "https"]),
+                "secrets.vault.addr must be an http(s) url".to_string(),
+            );
+            check(
+                !vault.token.is_empty() || vault.token_file.is_some(),
+                "secrets.vault needs a token or a token_file".to_string(),
+            );
+        }
+
+        if self.tls.enabled {
+            let static_cert = self.tls.cert_path.is_some() || self.tls.key_path.is_some();
+            check(
+                !static_cert || (self.tls.cert_path.is_some() && self.tls.key_path.is_some()),
+                "tls.cert_path and tls.key_path are only valid together".to_string(),
+            );
+            match &self.tls.acme {
+                Some(acme) if !static_cert => {
+                    check(
+                        !acme.domains.is_empty(),
+                        "tls.acme.domains is empty".to_string(),
+                    );
+                    // every worker would order its own certificates
+                    check(
+                        !self.server.prefork(),
+                        "tls.acme can't be used with prefork_workers".to_string(),
+                    );
+                }
+                Some(_) => {}
+                None => check(
+                    static_cert,
+                    "tls needs cert_path and key_path or an [tls.acme] section".to_string(),
+                ),
+            }
+        }
+
+        for (name, profile) in &self.rate_limit.profiles {
+            check(
+                profile.requests > 0 && profile.window_secs > 0,
+                format!("rate_limit.profiles.{name}: requests and window_secs must not be 0"),
+            );
+        }
+
+        check(
+            self.slo.availability_target > 0.0 && self.slo.availability_target <= 1.0,
+            "slo.availability_target must be in (0, 1]".to_string(),
+        );
+        check(
+            self.task_manager.concurrency > 0,
+            "task_manager.concurrency must not be 0".to_string(),
+        );
+        check(
+            self.audit_log.concurrency > 0 && self.audit_log.queue_size > 0,
+            "audit_log.concurrency and audit_log.queue_size must not be 0".to_string(),
+        );
+        check(
+            self.audit_log.export_max_entries > 0,
+            "audit_log.export_max_entries must not be 0".to_string(),
+        );
+        check(
+            !self.events.outbox.enabled || self.events.enabled,
+            "events.outbox needs events.enabled".to_string(),
+        );
+        check(
+            self.events.outbox.batch_size > 0 && self.events.outbox.lease_secs > 0,
+            "events.outbox.batch_size and events.outbox.lease_secs must not be 0".to_string(),
+        );
+        check(
+            self.dataset.min_group_size > 1 && self.dataset.window_secs > 0,
+            "dataset.min_group_size must be above 1 and dataset.window_secs not 0".to_string(),
+        );
+        let comment = &self.comment;
+        check(
+            comment.max_length > 0 && comment.max_nickname_length > 0,
+            "comment.max_length and comment.max_nickname_length must not be 0".to_string(),
+        );
+        check(
+            comment.page_size > 0 && comment.page_size <= comment.max_page_size,
+            "comment.page_size must be between 1 and comment.max_page_size".to_string(),
+        );
+        check(
+            self.embed.topic_url.matches("{id}").count() == 1,
+            "embed.topic_url must contain {id} once".to_string(),
+        );
+        check(
+            self.feeds.max_entries > 0 && self.feeds.top > 0,
+            "feeds.max_entries and feeds.top must not be 0".to_string(),
+        );
+        check(
+            self.affinity.max_ballots > 0,
+            "affinity.max_ballots must not be 0".to_string(),
+        );
+        check(
+            self.geoip.reload_secs > 0,
+            "geoip.reload_secs must not be 0".to_string(),
+        );
+        check(
+            self.trends.upset_min_rank_gap > 0 && self.trends.momentum_hours > 0,
+            "trends.upset_min_rank_gap and trends.momentum_hours must not be 0".to_string(),
+        );
+        check(
+            self.trends.momentum_hours <= self.trends.max_momentum_hours,
+            "trends.momentum_hours must not exceed trends.max_momentum_hours".to_string(),
+        );
+        check(
+            self.heatmap.timezone.parse::<chrono_tz::Tz>().is_ok(),
+            format!(
+                "heatmap.timezone {} is not an IANA time zone",
+                self.heatmap.timezone
+            ),
+        );
+        let share_card = &self.share_card;
+        check(
+            (1..=10).contains(&share_card.max_top)
+                && (1..=share_card.max_top).contains(&share_card.default_top),
+            "share_card.max_top must be between 1 and 10, share_card.default_top between 1 and it"
+                .to_string(),
+        );
+        check(
+            self.word_filter.reload_secs > 0,
+            "word_filter.reload_secs must not be 0".to_string(),
+        );
+        let consent = &self.consent;
+        check(
+            consent.version.is_empty() || !consent.terms_url.is_empty(),
+            "consent.version needs consent.terms_url".to_string(),
+        );
+        check(
+            consent.version.bytes().all(|b| b.is_ascii_graphic()),
+            "consent.version must be printable ascii without spaces, it is sent as a header"
+                .to_string(),
+        );
+        let workers = &self.task_queue.workers;
+        check(
+            workers.high > 0 && workers.normal > 0 && workers.low > 0,
+            "task_queue.workers must not be 0 for any priority".to_string(),
+        );
+        check(
+            self.task_queue.lease_secs > 0,
+            "task_queue.lease_secs must not be 0".to_string(),
+        );
+        for (name, schedule) in self.scheduler.schedules() {
+            check(
+                schedule.is_empty() || cron::Schedule::from_str(schedule).is_ok(),
+                format!("scheduler.{name} is not a valid cron expression: {schedule}"),
+            );
+        }
+        check(
+            self.task_manager.timeout_ms > 0,
+            "task_manager.timeout_ms must not be 0".to_string(),
+        );
+
+        match errors.is_empty() {
+            true => Ok(()),
+            false => Err(ConfigErrors(errors)),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::TomlConfig as _;
+
+    #[test]
+    fn defaults_pass_and_every_error_is_reported() {
+        let mut config: AppConfig = toml::from_str(AppConfig::DEFAULT_TOML).unwrap();
+        config.validate().unwrap();
+
+        config.server.port = 0;
+        config.database.mongodb_url = "localhost:27017".to_string();
+        config.cors.allow_methods.push("FETCH IT".to_string());
+
+        let errors = config.validate().unwrap_err();
+        assert_eq!(errors.0.len(), 3, "{errors}");
+    }
+
+    #[test]
+    fn truncated_ips_need_a_hash_key_and_no_limit() {
+        let mut config: AppConfig = toml::from_str(AppConfig::DEFAULT_TOML).unwrap();
+        config.vote.ip_storage = IpStorage::Truncated;
+        let errors = config.validate().unwrap_err();
+        assert_eq!(errors.0.len(), 2, "{errors}");
+
+        config.vote.ip_hash_key = "key".to_string();
+        config.vote.max_ip_limit = -1;
+        config.validate().unwrap();
+    }
+}
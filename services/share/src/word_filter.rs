@@ -0,0 +1,166 @@
+//! Matching of a sensitive word list against user written text. Words are
+//! matched case-insensitively, full-width latin as half-width, and with
+//! spaces and punctuation between their characters skipped, so `傻 逼` and
+//! `ＢＡＤ` are caught as well.
+
+use std::{borrow::Cow, collections::HashMap, ops::Range};
+
+#[derive(Default)]
+struct Node {
+    children: HashMap<char, Node>,
+    terminal: bool,
+}
+
+/// Characters a word may be split by.
+fn is_separator(c: char) -> bool {
+    !c.is_alphanumeric()
+}
+
+fn fold(c: char) -> char {
+    let c = match c {
+        '\u{ff01}'..='\u{ff5e}' => char::from_u32(c as u32 - 0xfee0).unwrap_or(c),
+        _ => c,
+    };
+    c.to_lowercase().next().unwrap_or(c)
+}
+
+/// A trie of the words, immutable once built. Reloading the list builds a
+/// new one.
+#[derive(Default)]
+pub struct WordFilter {
+    root: Node,
+    words: usize,
+}
+
+impl WordFilter {
+    pub fn new<I, S>(words: I) -> Self
+    where
+        I: IntoIterator<Item = S>,
+        S: AsRef<str>,
+    {
+        let mut filter = Self::default();
+        for word in words {
+            let mut chars = word
+                .as_ref()
+                .chars()
+                .filter(|c| !is_separator(*c))
+                .map(fold)
+                .peekable();
+            if chars.peek().is_none() {
+                continue;
+            }
+            let node = chars.fold(&mut filter.root, |node, c| {
+                node.children.entry(c).or_default()
+            });
+            if !node.terminal {
+                node.terminal = true;
+                filter.words += 1;
+            }
+        }
+        filter
+    }
+
+    /// A word list file, one word per line. Empty lines and lines starting
+    /// with `#` are skipped.
+    pub fn parse(list: &str) -> Self {
+        Self::new(
+            list.lines()
+                .map(str::trim)
+                .filter(|line| !line.is_empty() && !line.starts_with('#')),
+        )
+    }
+
+    /// Number of distinct words.
+    pub fn len(&self) -> usize {
+        self.words
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.words == 0
+    }
+
+    /// Byte ranges of the words in `text`. The longest word starting at a
+    /// position wins and the ranges never overlap.
+    pub fn find(&self, text: &str) -> Vec<Range<usize>> {
+        let chars: Vec<(usize, char)> = text.char_indices().collect();
+        let mut found = Vec::new();
+        let mut i = 0;
+        while i < chars.len() {
+            let mut end = None;
+            if !is_separator(chars[i].1) {
+                let mut node = &self.root;
+                for (j, &(_, c)) in chars.iter().enumerate().skip(i) {
+                    if is_separator(c) {
+                        continue;
+                    }
+                    match node.children.get(&fold(c)) {
+                        Some(next) => node = next,
+                        None => break,
+                    }
+                    if node.terminal {
+                        end = Some(j + 1);
+                    }
+                }
+            }
+            match end {
+                Some(j) => {
+                    let byte_end = chars.get(j).map_or(text.len(), |&(b, _)| b);
+                    found.push(chars[i].0..byte_end);
+                    i = j;
+                }
+                None => i += 1,
+            }
+        }
+        found
+    }
+
+    pub fn contains(&self, text: &str) -> bool {
+        !self.is_empty() && !self.find(text).is_empty()
+    }
+
+    /// `text` with the characters of every word found replaced by `*`, the
+    /// separators between them are kept.
+    pub fn mask<'a>(&self, text: &'a str) -> Cow<'a, str> {
+        let found = self.find(text);
+        if found.is_empty() {
+            return Cow::Borrowed(text);
+        }
+        let mut masked = String::with_capacity(text.len());
+        let mut last = 0;
+        for range in found {
+            masked.push_str(&text[last..range.start]);
+            masked.extend(text[range.clone()].chars().map(|c| match is_separator(c) {
+                true => c,
+                false => '*',
+            }));
+            last = range.end;
+        }
+        masked.push_str(&text[last..]);
+        Cow::Owned(masked)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn words_are_found_through_case_width_and_separators() {
+        let filter = WordFilter::parse("# comment\n\n傻逼\nbad\nbadword\n  BAD \n");
+        assert_eq!(filter.len(), 3);
+
+        assert_eq!(filter.mask("你是傻 逼吗"), "你是* *吗");
+        assert_eq!(filter.mask("so ＢＡＤ!"), "so ***!");
+        assert_eq!(filter.mask("a Bad-Word here"), "a ***-**** here");
+        assert_eq!(filter.find("bad, bad"), vec![0..3, 5..8]);
+        assert!(!filter.contains("good words only"));
+        assert!(matches!(filter.mask("博士好"), Cow::Borrowed(_)));
+    }
+
+    #[test]
+    fn an_empty_list_matches_nothing() {
+        let filter = WordFilter::parse("# nothing yet\n");
+        assert!(filter.is_empty());
+        assert!(!filter.contains("anything"));
+    }
+}
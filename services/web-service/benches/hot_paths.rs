@@ -0,0 +1,141 @@
+//! Ballot generation and the results pipeline at the size of a real season:
+//! 300 operators and 10M votes spread over every pairing.
+//!
+//! `cargo bench -p web-service`
+
+use std::{collections::HashMap, hint::black_box};
+
+use criterion::{Criterion, criterion_group, criterion_main};
+use rand::{Rng as _, SeedableRng as _, rngs::StdRng};
+use share::models::{
+    api::ResultsGroupBy,
+    excel::{CharacterInfo, ProfessionCategory, RarityRank},
+};
+use web_service::bench::{self, ResultsSnapshot};
+
+const OPERATORS: i32 = 300;
+const VOTES: i64 = 10_000_000;
+
+struct Dataset {
+    operator_ids: Vec<i32>,
+    names: HashMap<i32, String>,
+    infos: Vec<CharacterInfo>,
+    op_stats: HashMap<String, i64>,
+    op_matrix: HashMap<String, i64>,
+}
+
+impl Dataset {
+    fn new() -> Self {
+        const RARITIES: [RarityRank; 3] = [RarityRank::Tier4, RarityRank::Tier5, RarityRank::Tier6];
+        const PROFESSIONS: [ProfessionCategory; 8] = [
+            ProfessionCategory::WARRIOR,
+            ProfessionCategory::SNIPER,
+            ProfessionCategory::TANK,
+            ProfessionCategory::MEDIC,
+            ProfessionCategory::SUPPORT,
+            ProfessionCategory::CASTER,
+            ProfessionCategory::SPECIAL,
+            ProfessionCategory::PIONEER,
+        ];
+
+        let mut rng = StdRng::seed_from_u64(0x5eed);
+        let operator_ids: Vec<i32> = (1..=OPERATORS).collect();
+        let names = operator_ids
+            .iter()
+            .map(|&id| (id, format!("operator {id}")))
+            .collect();
+        let infos = operator_ids
+            .iter()
+            .map(|&id| CharacterInfo {
+                id,
+                name: format!("operator {id}"),
+                rarity: RARITIES[id as usize % RARITIES.len()],
+                profession: PROFESSIONS[id as usize % PROFESSIONS.len()].clone(),
+                sub_profession_id: String::new(),
+                faction: Some(format!("faction {}", id % 12)),
+                is_not_obtainable: false,
+            })
+            .collect();
+
+        let pairs = (OPERATORS * (OPERATORS - 1) / 2) as i64;
+        let per_pair = VOTES / pairs;
+        let mut wins: HashMap<i32, i64> = HashMap::new();
+        let mut losses: HashMap<i32, i64> = HashMap::new();
+        let mut op_matrix = HashMap::new();
+        for a in 1..=OPERATORS {
+            for b in a + 1..=OPERATORS {
+                let a_wins = rng.random_range(0..=per_pair);
+                let b_wins = per_pair - a_wins;
+                *wins.entry(a).or_default() += a_wins;
+                *losses.entry(a).or_default() += b_wins;
+                *wins.entry(b).or_default() += b_wins;
+                *losses.entry(b).or_default() += a_wins;
+                op_matrix.insert(format!("{a}:{b}"), a_wins - b_wins);
+                op_matrix.insert(format!("{b}:{a}"), b_wins - a_wins);
+            }
+        }
+
+        let mut op_stats = HashMap::new();
+        for &id in &operator_ids {
+            op_stats.insert(format!("{id}:win"), wins[&id]);
+            op_stats.insert(format!("{id}:lose"), losses[&id]);
+        }
+
+        Self {
+            operator_ids,
+            names,
+            infos,
+            op_stats,
+            op_matrix,
+        }
+    }
+
+    fn snapshot(&self) -> ResultsSnapshot {
+        ResultsSnapshot::new(self.op_stats.clone(), self.op_matrix.clone(), VOTES)
+    }
+}
+
+fn sampler(c: &mut Criterion) {
+    let dataset = Dataset::new();
+
+    c.bench_function("sample_ballot", |b| {
+        b.iter(|| bench::sample_ballot(black_box(&dataset.operator_ids)))
+    });
+}
+
+fn ranking(c: &mut Criterion) {
+    let dataset = Dataset::new();
+    let snapshot = dataset.snapshot();
+
+    c.bench_function("rank", |b| {
+        b.iter(|| bench::rank(black_box(&snapshot), &dataset.operator_ids, &dataset.names))
+    });
+}
+
+fn matrix(c: &mut Criterion) {
+    let dataset = Dataset::new();
+    let snapshot = dataset.snapshot();
+
+    c.bench_function("snapshot_build", |b| {
+        b.iter_batched(
+            || (dataset.op_stats.clone(), dataset.op_matrix.clone()),
+            |(op_stats, op_matrix)| ResultsSnapshot::new(op_stats, op_matrix, VOTES),
+            criterion::BatchSize::LargeInput,
+        )
+    });
+    c.bench_function("matrix", |b| {
+        b.iter(|| bench::matrix(black_box(&snapshot), &dataset.infos, None))
+    });
+    c.bench_function("matrix_by_profession", |b| {
+        b.iter(|| {
+            bench::matrix(
+                black_box(&snapshot),
+                &dataset.infos,
+                Some(ResultsGroupBy::Profession),
+            )
+        })
+    });
+}
+
+criterion_group!(benches, sampler, ranking, matrix);
+criterion_main!(benches);
@@ -0,0 +1,45 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{AnnouncementCreateRequest, ApiData, ApiMsg, ApiResponse},
+    database::{Announcement, ApiKey},
+};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/announcements/create",
+    request_body = AnnouncementCreateRequest,
+    responses(
+        (status = 200, description = "Announcement created", body = ApiResponse<Announcement>),
+        (status = 400, description = "Empty title, too long or ending before it starts", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Announcement",
+    operation_id = "announcementCreate"
+)]
+#[axum::debug_handler]
+pub async fn announcement_create(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<AnnouncementCreateRequest>,
+) -> Result<Json<ApiResponse<Announcement>>, AppError> {
+    let created = state.announcement_service.create(req, &key.name).await?;
+
+    Ok(Json(match created {
+        Ok(announcement) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(announcement),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
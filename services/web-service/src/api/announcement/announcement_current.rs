@@ -0,0 +1,29 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{AnnouncementListResponse, ApiData, ApiMsg, ApiResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/announcements",
+    responses(
+        (status = 200, description = "Announcements shown now", body = ApiResponse<AnnouncementListResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Announcement",
+    operation_id = "announcementCurrent"
+)]
+#[axum::debug_handler]
+pub async fn announcement_current(
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<AnnouncementListResponse>>, AppError> {
+    let announcements = state.announcement_service.current().await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(AnnouncementListResponse { announcements }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,48 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{AnnouncementDeleteRequest, ApiData, ApiMsg, ApiResponse},
+    database::ApiKey,
+};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/announcements/delete",
+    request_body = AnnouncementDeleteRequest,
+    responses(
+        (status = 200, description = "Announcement deleted", body = ApiResponse<String>),
+        (status = 404, description = "Announcement not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Announcement",
+    operation_id = "announcementDelete"
+)]
+#[axum::debug_handler]
+pub async fn announcement_delete(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<AnnouncementDeleteRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    let deleted = state
+        .announcement_service
+        .delete(&req.id, &key.name)
+        .await?;
+
+    Ok(Json(match deleted {
+        Ok(()) => ApiResponse {
+            status: 0,
+            data: ApiData::Empty,
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
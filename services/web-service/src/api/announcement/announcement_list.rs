@@ -0,0 +1,30 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{AnnouncementAdminListResponse, ApiData, ApiMsg, ApiResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/announcements/list",
+    responses(
+        (status = 200, description = "Every announcement, scheduled and ended ones included", body = ApiResponse<AnnouncementAdminListResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Announcement",
+    operation_id = "announcementList"
+)]
+#[axum::debug_handler]
+pub async fn announcement_list(
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<AnnouncementAdminListResponse>>, AppError> {
+    let announcements = state.announcement_service.list().await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(AnnouncementAdminListResponse { announcements }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,49 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{AnnouncementUpdateRequest, ApiData, ApiMsg, ApiResponse},
+    database::{Announcement, ApiKey},
+};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/announcements/update",
+    request_body = AnnouncementUpdateRequest,
+    responses(
+        (status = 200, description = "Announcement replaced", body = ApiResponse<Announcement>),
+        (status = 400, description = "Empty title, too long or ending before it starts", body = ApiResponse<String>),
+        (status = 404, description = "Announcement not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Announcement",
+    operation_id = "announcementUpdate"
+)]
+#[axum::debug_handler]
+pub async fn announcement_update(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<AnnouncementUpdateRequest>,
+) -> Result<Json<ApiResponse<Announcement>>, AppError> {
+    let updated = state
+        .announcement_service
+        .update(&req.id, req.announcement, &key.name)
+        .await?;
+
+    Ok(Json(match updated {
+        Ok(announcement) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(announcement),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
@@ -0,0 +1,41 @@
+use std::sync::Arc;
+
+use axum::{
+    Router,
+    routing::{get, post},
+};
+use share::models::api::ApiMsg;
+
+use crate::state::AppState;
+
+pub mod announcement_create;
+pub mod announcement_current;
+pub mod announcement_delete;
+pub mod announcement_list;
+pub mod announcement_update;
+
+use announcement_create::announcement_create;
+use announcement_current::announcement_current;
+use announcement_delete::announcement_delete;
+use announcement_list::announcement_list;
+use announcement_update::announcement_update;
+
+pub fn announcement_routes() -> Router<Arc<AppState>> {
+    Router::new().route("/", get(announcement_current))
+}
+
+pub fn announcement_admin_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/create", post(announcement_create))
+        .route("/update", post(announcement_update))
+        .route("/delete", post(announcement_delete))
+        .route("/list", post(announcement_list))
+}
+
+/// Status of a rejected announcement request.
+fn rejected_status(message: &ApiMsg) -> i32 {
+    match message {
+        ApiMsg::AnnouncementInvalid(_) => 400,
+        _ => 404,
+    }
+}
@@ -0,0 +1,51 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiKeyIssueRequest, ApiKeyIssueResponse, ApiMsg, ApiResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/api_key/issue",
+    request_body = ApiKeyIssueRequest,
+    responses(
+        (status = 200, description = "API key issued", body = ApiResponse<ApiKeyIssueResponse>),
+        (status = 400, description = "API key name already taken", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "ApiKey",
+    operation_id = "apiKeyIssue"
+)]
+#[axum::debug_handler]
+pub async fn api_key_issue(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ApiKeyIssueRequest>,
+) -> Result<Json<ApiResponse<ApiKeyIssueResponse>>, AppError> {
+    let issued = state
+        .api_key_service
+        .issue(&req.name, req.scopes, req.rate_limit_per_minute)
+        .await?;
+
+    let Some((key, api_key)) = issued else {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::ApiKeyAlreadyExists,
+        }));
+    };
+
+    let signing_secret = state.api_key_service.signing_secret(&api_key);
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ApiKeyIssueResponse {
+            name: api_key.name,
+            key,
+            signing_secret,
+            scopes: api_key.scopes,
+            rate_limit_per_minute: api_key.rate_limit_per_minute,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
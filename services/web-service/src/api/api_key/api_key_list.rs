@@ -0,0 +1,43 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiKeyInfo, ApiKeyListResponse, ApiMsg, ApiResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/api_key/list",
+    responses(
+        (status = 200, description = "List issued API keys", body = ApiResponse<ApiKeyListResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "ApiKey",
+    operation_id = "apiKeyList"
+)]
+#[axum::debug_handler]
+pub async fn api_key_list(
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<ApiKeyListResponse>>, AppError> {
+    let keys = state
+        .api_key_service
+        .list()
+        .await?
+        .into_iter()
+        .map(|key| ApiKeyInfo {
+            name: key.name,
+            scopes: key.scopes,
+            rate_limit_per_minute: key.rate_limit_per_minute,
+            enabled: key.enabled,
+            created_at: key.created_at,
+            last_used_at: key.last_used_at,
+        })
+        .collect();
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ApiKeyListResponse { keys }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,39 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiKeyRevokeRequest, ApiMsg, ApiResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/api_key/revoke",
+    request_body = ApiKeyRevokeRequest,
+    responses(
+        (status = 200, description = "API key revoked", body = ApiResponse<String>),
+        (status = 404, description = "API key not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "ApiKey",
+    operation_id = "apiKeyRevoke"
+)]
+#[axum::debug_handler]
+pub async fn api_key_revoke(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ApiKeyRevokeRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    if !state.api_key_service.revoke(&req.name).await? {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::ApiKeyNotFound,
+        }));
+    }
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Empty,
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,20 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+
+use crate::state::AppState;
+
+pub mod api_key_issue;
+pub mod api_key_list;
+pub mod api_key_revoke;
+
+use api_key_issue::api_key_issue;
+use api_key_list::api_key_list;
+use api_key_revoke::api_key_revoke;
+
+pub fn api_key_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/issue", post(api_key_issue))
+        .route("/revoke", post(api_key_revoke))
+        .route("/list", post(api_key_list))
+}
@@ -1,7 +1,13 @@
 use std::sync::Arc;
 
 use axum::{Json, extract::State};
-use share::models::api::{ApiData, ApiMsg, ApiResponse, AuditTopicRequest};
+use share::{
+    events::{DomainEvent, DomainEventKind, TopicPhase},
+    models::{
+        api::{ApiData, ApiMsg, ApiResponse, AuditTopicRequest},
+        live::TopicChangeKind,
+    },
+};
 
 use crate::{AppState, error::AppError};
 
@@ -23,10 +29,28 @@ pub async fn audit_topic(
     Json(req): Json<AuditTopicRequest>,
 ) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
     let topic_id = req.topic_id;
+    let phase = if req.audit_info.is_approved() {
+        TopicPhase::Approved
+    } else {
+        TopicPhase::Rejected
+    };
+    let mut change = state.outbox.begin().await?;
     state
         .topic_service
-        .audit_topic(&topic_id, req.audit_info)
+        .audit_topic(&topic_id, req.audit_info, change.session())
         .await?;
+    change.push(DomainEvent::new(DomainEventKind::TopicTransition {
+        topic_id: topic_id.clone(),
+        from: Some(TopicPhase::WaitingAudit),
+        to: phase,
+    }));
+    state.outbox.commit(change).await?;
+
+    state.topic_service.reload_topic(&topic_id).await?;
+    state
+        .topic_sync
+        .notify(&topic_id, TopicChangeKind::Audited)
+        .await;
 
     Ok(Json(ApiResponse {
         status: 0,
@@ -54,7 +54,7 @@ pub async fn ballot_bench_new(
     let topic_id = topic.id;
     let candidate_pool = match state
         .topic_service
-        .get_candidate_pool(&topic_id, &state.character_infos)
+        .get_candidate_pool(&topic_id, &state.operator_service.character_infos())
         .await
     {
         Some(pool) => pool,
@@ -87,6 +87,7 @@ pub async fn ballot_bench_new(
                     ballot_id: ballot_id.clone(),
                     winner: left,
                     loser: right,
+                    nonce: None,
                 }),
             );
 
@@ -95,6 +96,7 @@ pub async fn ballot_bench_new(
                 ballot_id,
                 left,
                 right,
+                nonce: None,
             };
 
             Ok(Json(ApiResponse {
@@ -5,9 +5,14 @@ use axum::{
     extract::{ConnectInfo, State},
     http::HeaderMap,
 };
-use share::models::{
-    api::{ApiData, ApiMsg, ApiResponse, BallotSaveRequest, BallotSaveResponse, PairwiseSaveScore},
-    database::{Ballot, BallotInfo, PairwiseBallot},
+use share::{
+    client_ip::stored_ip,
+    models::{
+        api::{
+            ApiData, ApiMsg, ApiResponse, BallotSaveRequest, BallotSaveResponse, PairwiseSaveScore,
+        },
+        database::{Ballot, BallotInfo, PairwiseBallot},
+    },
 };
 
 use crate::{AppState, api::utils::publish_and_ack, error::AppError};
@@ -83,7 +88,8 @@ pub async fn ballot_bench_save(
         }
     };
 
-    let ip = addr.ip().to_string();
+    // v4 clients of a dual-stack listener arrive as ::ffff:a.b.c.d
+    let ip = addr.ip().to_canonical().to_string();
     let user_agent = headers
         .get("User-Agent")
         .and_then(|v| v.to_str().ok())
@@ -95,16 +101,18 @@ pub async fn ballot_bench_save(
             ballot_id,
             winner,
             loser,
+            ..
         }) => {
             if winner == loser {
                 return Err(AppError::SameParticipant);
             }
 
+            let config = state.config.current();
             let ballot = Ballot::Pairwise(PairwiseBallot {
                 info: BallotInfo {
                     topic_id: topic_id.into(),
                     ballot_id: ballot_id.into(),
-                    ip: ip.into(),
+                    ip: stored_ip(&ip, &config.vote),
                     user_agent: user_agent.into(),
                     timestamp: chrono::Utc::now().timestamp_millis(),
                 },
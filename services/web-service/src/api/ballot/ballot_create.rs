@@ -1,19 +1,32 @@
-use std::sync::Arc;
+use std::{net::SocketAddr, sync::Arc};
 
-use axum::{Json, extract::State};
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
 use rand::seq::IndexedRandom as _;
-use redis::AsyncCommands as _;
-use share::models::{
-    api::{ApiData, ApiMsg, ApiResponse, BallotCreateRequest, BallotCreateResponse},
-    database::VotingTopicType,
+use share::{
+    client_ip::voter_key,
+    models::{
+        api::{ApiData, ApiMsg, ApiResponse, BallotCreateRequest, BallotCreateResponse},
+        database::VotingTopicType,
+    },
 };
+use tracing::Instrument as _;
 
 use crate::{
-    AppState, api::utils::generate_random_string, constants::BALLOT_CODE_RANDOM_LENGTH,
+    AppState,
+    api::utils::generate_random_string,
+    constants::{BALLOT_CODE_RANDOM_LENGTH, BALLOT_NONCE_LENGTH},
     error::AppError,
 };
 
-fn select_operators(operator_ids: &[i32]) -> Result<(i32, i32), AppError> {
+/// Holds the nonce issued with a ballot until its vote spends it.
+pub(crate) fn ballot_nonce_key(topic_id: &str, ballot_id: &str) -> String {
+    format!("{topic_id}:ballot_nonce:{ballot_id}")
+}
+
+pub(crate) fn select_operators(operator_ids: &[i32]) -> Result<(i32, i32), AppError> {
     if operator_ids.len() < 2 {
         return Err(AppError::InsufficientOperators);
     }
@@ -38,6 +51,7 @@ fn select_operators(operator_ids: &[i32]) -> Result<(i32, i32), AppError> {
 )]
 #[axum::debug_handler]
 pub async fn ballot_create(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
     State(state): State<Arc<AppState>>,
     Json(req): Json<BallotCreateRequest>,
 ) -> Result<Json<ApiResponse<BallotCreateResponse>>, AppError> {
@@ -59,9 +73,13 @@ pub async fn ballot_create(
         }
     };
     let topic_id = topic.id;
+    let ip = addr.ip().to_canonical().to_string();
+    state
+        .presence_service
+        .touch(&topic_id, &voter_key(&ip, &state.config.current().vote));
     let candidate_pool = match state
         .topic_service
-        .get_candidate_pool(&topic_id, &state.character_infos)
+        .get_candidate_pool(&topic_id, &state.operator_service.character_infos())
         .await
     {
         Some(pool) => pool,
@@ -82,16 +100,29 @@ pub async fn ballot_create(
             let random_string = generate_random_string(BALLOT_CODE_RANDOM_LENGTH);
             let ballot_id = format!("{id}-{random_string}");
 
+            let nonce = generate_random_string(BALLOT_NONCE_LENGTH);
+
             let mut conn = state.redis.connection.clone();
             let ballot_key = format!("{topic_id}:ballot:{ballot_id}");
             let ballot_value = format!("{left},{right}");
-            let _: () = conn.set_ex(&ballot_key, &ballot_value, 86400).await?; // 24 hours expiration
+            let _: () = redis::pipe()
+                .set_ex(&ballot_key, &ballot_value, 86400) // 24 hours expiration
+                .ignore()
+                .set_ex(ballot_nonce_key(&topic_id, &ballot_id), &nonce, 86400)
+                .ignore()
+                .query_async(&mut conn)
+                .instrument(tracing::info_span!(
+                    "redis.set_ballot",
+                    otel.kind = "client"
+                ))
+                .await?;
 
             let rsp = BallotCreateResponse::Pairwise {
                 topic_id,
                 ballot_id,
                 left,
                 right,
+                nonce: Some(nonce),
             };
 
             Ok(Json(ApiResponse {
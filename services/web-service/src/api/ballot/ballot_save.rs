@@ -1,24 +1,110 @@
-use std::{net::SocketAddr, sync::Arc};
+use std::{
+    net::SocketAddr,
+    sync::{Arc, LazyLock},
+};
 
 use axum::{
     Json,
     extract::{ConnectInfo, State},
     http::HeaderMap,
 };
-use share::models::{
-    api::{ApiData, ApiMsg, ApiResponse, BallotSaveRequest, BallotSaveResponse, PairwiseSaveScore},
-    database::{Ballot, BallotInfo, PairwiseBallot},
+use share::{
+    client_ip::{stored_ip, voter_key},
+    models::{
+        api::{
+            ApiData, ApiMsg, ApiResponse, BallotSaveRequest, BallotSaveResponse, PairwiseSaveScore,
+        },
+        database::{Ballot, BallotInfo, PairwiseBallot},
+    },
 };
 
+use super::ballot_create::ballot_nonce_key;
 use crate::{AppState, api::utils::publish_and_ack, error::AppError};
 
+/// Fits a serialized pairwise ballot with a typical user agent, so encoding
+/// does not regrow the buffer.
+const BALLOT_PAYLOAD_CAPACITY: usize = 512;
+
+/// Left in place of a spent nonce until it would have expired. Issued nonces
+/// are alphanumeric, so none is ever equal to it.
+const SPENT_NONCE: &str = ":spent";
+
+/// Spends the nonce at `KEYS[1]` only when `ARGV[1]` is that nonce, a wrong
+/// one leaves it to the vote with the right one. Returns 1 when spent, 0
+/// when rejected and -1 when the ballot was issued without a nonce.
+static SPEND_NONCE_SCRIPT: LazyLock<redis::Script> = LazyLock::new(|| {
+    redis::Script::new(
+        r#"
+local issued = redis.call("GET", KEYS[1])
+if not issued then
+    if ARGV[1] == "" then
+        return -1
+    end
+    return 0
+end
+if issued == ARGV[2] or issued ~= ARGV[1] then
+    return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+return 1
+"#,
+    )
+});
+
+#[derive(Debug, PartialEq, Eq)]
+enum NonceCheck {
+    /// The nonce of the ballot was sent and is spent now.
+    Spent,
+    /// Wrong, left out or spent before.
+    Rejected,
+    /// The ballot has no nonce, it was issued before there were any.
+    NotIssued,
+}
+
+impl NonceCheck {
+    fn from_script(result: i32) -> Self {
+        match result {
+            1 => Self::Spent,
+            -1 => Self::NotIssued,
+            _ => Self::Rejected,
+        }
+    }
+
+    /// Ballots issued with a nonce need it whatever the config says,
+    /// `vote.require_ballot_nonce` only turns away the ones without.
+    fn accepts(&self, require_ballot_nonce: bool) -> bool {
+        match self {
+            Self::Spent => true,
+            Self::Rejected => false,
+            Self::NotIssued => !require_ballot_nonce,
+        }
+    }
+}
+
+/// Checks the nonce sent with a vote against the one issued with its ballot,
+/// a replay of the same request finds it spent.
+async fn spend_nonce(
+    conn: &mut redis::aio::MultiplexedConnection,
+    topic_id: &str,
+    ballot_id: &str,
+    nonce: Option<&str>,
+) -> Result<NonceCheck, AppError> {
+    let result: i32 = SPEND_NONCE_SCRIPT
+        .key(ballot_nonce_key(topic_id, ballot_id))
+        .arg(nonce.unwrap_or(""))
+        .arg(SPENT_NONCE)
+        .invoke_async(conn)
+        .await?;
+    Ok(NonceCheck::from_script(result))
+}
+
 #[utoipa::path(
     post,
     path = "/ballot/save",
     request_body = BallotSaveRequest,
     responses(
         (status = 200, description = "Save ballot successfully", body = ApiResponse<BallotSaveResponse>),
-        (status = 400, description = "Invalid request", body = ApiResponse<String>),
+        (status = 400, description = "Invalid request, option not in the candidate pool or ballot nonce spent", body = ApiResponse<String>),
         (status = 404, description = "Topic not found", body = ApiResponse<String>),
         (status = 500, description = "Internal server error", body = ApiResponse<String>)
     ),
@@ -32,48 +118,44 @@ pub async fn ballot_save(
     State(state): State<Arc<AppState>>,
     Json(req): Json<BallotSaveRequest>,
 ) -> Result<Json<ApiResponse<BallotSaveResponse>>, AppError> {
-    let _target_topic = match state.topic_service.get_topic(req.topic_id()).await {
-        Ok(Some(topic)) if topic.is_topic_active() && topic.topic_type.matches_request(&req) => {
-            topic
-        }
-        Ok(Some(topic)) if !topic.topic_type.matches_request(&req) => {
-            return Ok(Json(ApiResponse {
-                status: 500,
-                data: ApiData::Empty,
-                message: ApiMsg::RequestTopicTypeMismatch,
-            }));
-        }
-        Ok(Some(topic)) if !topic.is_topic_active() => {
-            return Ok(Json(ApiResponse {
-                status: 500,
-                data: ApiData::Empty,
-                message: ApiMsg::TargetTopicNotActive,
-            }));
-        }
-        Ok(None) => {
-            return Ok(Json(ApiResponse {
-                status: 404,
-                data: ApiData::Empty,
-                message: ApiMsg::TargetTopicNotFound,
-            }));
-        }
-        Ok(Some(_)) => {
+    // borrowed from the cache, cloning the topic per ballot showed up in
+    // allocation profiles
+    let rejected = state
+        .topic_service
+        .with_topic(req.topic_id(), |topic| {
+            if !topic.topic_type.matches_request(&req) {
+                Some(ApiMsg::RequestTopicTypeMismatch)
+            } else if !topic.is_topic_active() {
+                Some(ApiMsg::TargetTopicNotActive)
+            } else {
+                None
+            }
+        })
+        .await;
+    match rejected {
+        Ok(Some(None)) => {}
+        Ok(Some(Some(message))) => {
             return Ok(Json(ApiResponse {
                 status: 500,
                 data: ApiData::Empty,
-                message: ApiMsg::InternalError,
+                message,
             }));
         }
-        Err(_) => {
+        Ok(None) | Err(_) => {
             return Ok(Json(ApiResponse {
                 status: 404,
                 data: ApiData::Empty,
                 message: ApiMsg::TargetTopicNotFound,
             }));
         }
-    };
+    }
 
-    let ip = addr.ip().to_string();
+    // v4 clients of a dual-stack listener arrive as ::ffff:a.b.c.d
+    let ip = addr.ip().to_canonical().to_string();
+    state.presence_service.touch(
+        req.topic_id(),
+        &voter_key(&ip, &state.config.current().vote),
+    );
     let user_agent = headers
         .get("User-Agent")
         .and_then(|v| v.to_str().ok())
@@ -85,16 +167,45 @@ pub async fn ballot_save(
             ballot_id,
             winner,
             loser,
+            nonce,
         }) => {
             if winner == loser {
                 return Err(AppError::SameParticipant);
             }
+            // excluded operators are never drawn, a ballot naming one is forged
+            let in_pool = state
+                .topic_service
+                .pool_contains(
+                    &topic_id,
+                    &[winner, loser],
+                    &state.operator_service.character_infos(),
+                )
+                .await;
+            if !in_pool {
+                return Ok(Json(ApiResponse {
+                    status: 400,
+                    data: ApiData::Empty,
+                    message: ApiMsg::OptionNotInCandidatePool,
+                }));
+            }
+            let config = state.config.current();
+            // spent last, so a rejected vote can be corrected and sent again
+            let mut conn = state.redis.connection.clone();
+            let nonce_check =
+                spend_nonce(&mut conn, &topic_id, &ballot_id, nonce.as_deref()).await?;
+            if !nonce_check.accepts(config.vote.require_ballot_nonce) {
+                return Ok(Json(ApiResponse {
+                    status: 400,
+                    data: ApiData::Empty,
+                    message: ApiMsg::BallotNonceInvalid,
+                }));
+            }
 
             let ballot = Ballot::Pairwise(PairwiseBallot {
                 info: BallotInfo {
-                    topic_id: topic_id.into(),
+                    topic_id: topic_id.as_str().into(),
                     ballot_id: ballot_id.into(),
-                    ip: ip.into(),
+                    ip: stored_ip(&ip, &config.vote),
                     user_agent: user_agent.into(),
                     timestamp: chrono::Utc::now().timestamp_millis(),
                 },
@@ -102,29 +213,22 @@ pub async fn ballot_save(
                 lose: loser,
             });
 
-            // state.task_manager.spawn({
-            //     let state = state.clone();
-            //     let ballot = match serde_json::to_vec(&ballot) {
-            //         Ok(data) => data,
-            //         Err(e) => {
-            //             tracing::error!("Failed to serialize ballot: {}", e);
-            //             return Err(AppError::SerdeJson(e));
-            //         }
-            //     };
-            //     move || async move {
-            //         if let Err(e) =
-            //             publish_and_ack(&state.jetstream, "ark-vote.save_score", ballot).await
-            //         {
-            //             tracing::error!("Failed to publish ballot: {}", e);
-            //         }
-            //     }
-            // });
-            publish_and_ack(
-                &state.jetstream,
-                "ark-vote.save_score",
-                serde_json::to_vec(&ballot)?,
-            )
-            .await?;
+            let mut payload = Vec::with_capacity(BALLOT_PAYLOAD_CAPACITY);
+            serde_json::to_writer(&mut payload, &ballot)?;
+            // through the pool, so a slow nats shows up as queue depth for the
+            // load shedder instead of piling up in handlers
+            let jetstream = state.jetstream.clone();
+            let timeout = config.task_manager.timeout();
+            state
+                .task_manager
+                .run(timeout, move || async move {
+                    publish_and_ack(&jetstream, "ark-vote.save_score", payload).await
+                })
+                .await??;
+            state.results_snapshots.record_vote(&topic_id);
+            state
+                .region_service
+                .record(&topic_id, addr.ip().to_canonical());
 
             Ok(Json(ApiResponse {
                 status: 0,
@@ -137,3 +241,98 @@ pub async fn ballot_save(
         )),
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn issued_nonces_are_needed_whatever_the_config() {
+        assert!(NonceCheck::from_script(1).accepts(true));
+        assert!(!NonceCheck::from_script(0).accepts(false));
+        assert!(NonceCheck::from_script(-1).accepts(false));
+        assert!(!NonceCheck::from_script(-1).accepts(true));
+    }
+
+    /// Needs a redis server, run with
+    /// `ARK_VOTE_TEST_REDIS_URL=redis://127.0.0.1/ cargo test -- --ignored`.
+    #[tokio::test]
+    #[ignore = "needs a redis server"]
+    async fn a_nonce_is_spent_once_and_only_by_a_match() {
+        let url = std::env::var("ARK_VOTE_TEST_REDIS_URL")
+            .unwrap_or_else(|_| "redis://127.0.0.1/".to_string());
+        let mut conn = redis::Client::open(url)
+            .unwrap()
+            .get_multiplexed_async_connection()
+            .await
+            .unwrap();
+        let topic_id = format!("nonce-test-{}", std::process::id());
+        let key = ballot_nonce_key(&topic_id, "ballot");
+        let _: () = redis::cmd("SET")
+            .arg(&key)
+            .arg("abc123")
+            .arg("EX")
+            .arg(60)
+            .query_async(&mut conn)
+            .await
+            .unwrap();
+
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "ballot", Some("wrong"))
+                .await
+                .unwrap(),
+            NonceCheck::Rejected
+        );
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "ballot", None)
+                .await
+                .unwrap(),
+            NonceCheck::Rejected
+        );
+        // neither burned it
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "ballot", Some("abc123"))
+                .await
+                .unwrap(),
+            NonceCheck::Spent
+        );
+        // a replay with or without the nonce
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "ballot", Some("abc123"))
+                .await
+                .unwrap(),
+            NonceCheck::Rejected
+        );
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "ballot", None)
+                .await
+                .unwrap(),
+            NonceCheck::Rejected
+        );
+        let ttl: i64 = redis::cmd("TTL")
+            .arg(&key)
+            .query_async(&mut conn)
+            .await
+            .unwrap();
+        assert!(ttl > 0);
+
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "other", None)
+                .await
+                .unwrap(),
+            NonceCheck::NotIssued
+        );
+        assert_eq!(
+            spend_nonce(&mut conn, &topic_id, "other", Some("abc123"))
+                .await
+                .unwrap(),
+            NonceCheck::Rejected
+        );
+
+        let _: () = redis::cmd("DEL")
+            .arg(&key)
+            .query_async(&mut conn)
+            .await
+            .unwrap();
+    }
+}
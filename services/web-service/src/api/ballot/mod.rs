@@ -2,10 +2,11 @@ use std::sync::Arc;
 
 use axum::{
     Router,
+    middleware::from_fn_with_state,
     routing::{get, post},
 };
 
-use crate::state::AppState;
+use crate::{middleware::consent::consent, state::AppState};
 
 pub mod ballot_bench_new;
 pub mod ballot_bench_save;
@@ -19,11 +20,13 @@ use ballot_create::ballot_create;
 use ballot_save::ballot_save;
 use ballot_skip::ballot_skip;
 
-pub fn ballot_routes() -> Router<Arc<AppState>> {
+pub fn ballot_routes(state: &Arc<AppState>) -> Router<Arc<AppState>> {
     Router::new()
         .route("/new", post(ballot_create)) // 创建新 ballot
         .route("/save", post(ballot_save)) // 保存 ballot
         .route("/skip", post(ballot_skip)) // 跳过 ballot
+        // only the routes above, load tests need no consent
+        .route_layer(from_fn_with_state(state.consent_service.clone(), consent))
         .route("/bench_new", get(ballot_bench_new))
         .route("/bench_save", get(ballot_bench_save))
 }
@@ -0,0 +1,49 @@
+//! The hot paths of the ballot and results endpoints without the redis and
+//! mongo around them, for `benches/hot_paths.rs`. Not a stable api.
+
+use std::collections::HashMap;
+
+use share::models::{
+    api::{Results1v1MatrixItem, ResultsGroupBy},
+    excel::CharacterInfo,
+};
+
+pub use crate::service::ResultsSnapshot;
+
+use super::{
+    ballot::ballot_create::select_operators,
+    results::{results_1v1_matrix::aggregate_matrix, results_final_order::rank_operators},
+    utils::generate_random_string,
+};
+use crate::constants::BALLOT_CODE_RANDOM_LENGTH;
+
+/// Picks the pair and the random part of the ballot id like `/ballot/new`.
+pub fn sample_ballot(candidate_pool: &[i32]) -> (i32, i32, String) {
+    let (left, right) = select_operators(candidate_pool).expect("pool of at least 2");
+    (
+        left,
+        right,
+        generate_random_string(BALLOT_CODE_RANDOM_LENGTH),
+    )
+}
+
+/// Operator ids in final order.
+pub fn rank(
+    snapshot: &ResultsSnapshot,
+    operator_ids: &[i32],
+    names: &HashMap<i32, String>,
+) -> Vec<i32> {
+    rank_operators(snapshot, operator_ids, names)
+        .into_iter()
+        .map(|result| result.id)
+        .collect()
+}
+
+pub fn matrix(
+    snapshot: &ResultsSnapshot,
+    infos: &[CharacterInfo],
+    group_by: Option<ResultsGroupBy>,
+) -> HashMap<String, Results1v1MatrixItem> {
+    let infos_by_id = infos.iter().map(|info| (info.id, info)).collect();
+    aggregate_matrix(&snapshot.matrix, &infos_by_id, group_by)
+}
@@ -0,0 +1,46 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, BookmarkAddRequest, BookmarkView};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/bookmark/add",
+    request_body = BookmarkAddRequest,
+    responses(
+        (status = 200, description = "Topic bookmarked from the calling address, a new reminder address is mailed a confirmation", body = ApiResponse<BookmarkView>),
+        (status = 400, description = "Voting closed, a reminder for an open topic, an invalid email or too many bookmarks", body = ApiResponse<String>),
+        (status = 404, description = "Topic not found or not approved", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Bookmark",
+    operation_id = "bookmarkAdd"
+)]
+#[axum::debug_handler]
+pub async fn bookmark_add(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<BookmarkAddRequest>,
+) -> Result<Json<ApiResponse<BookmarkView>>, AppError> {
+    let ip = addr.ip().to_canonical().to_string();
+    let added = state.bookmark_service.add(&ip, req).await?;
+
+    Ok(Json(match added {
+        Ok(bookmark) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(bookmark.into()),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
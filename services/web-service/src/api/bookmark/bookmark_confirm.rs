@@ -0,0 +1,40 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, BookmarkConfirmRequest, BookmarkView};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/bookmark/confirm",
+    request_body = BookmarkConfirmRequest,
+    responses(
+        (status = 200, description = "Reminder address confirmed, the reminder is mailed when voting opens", body = ApiResponse<BookmarkView>),
+        (status = 404, description = "No unconfirmed reminder with this token", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Bookmark",
+    operation_id = "bookmarkConfirm"
+)]
+#[axum::debug_handler]
+pub async fn bookmark_confirm(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<BookmarkConfirmRequest>,
+) -> Result<Json<ApiResponse<BookmarkView>>, AppError> {
+    let confirmed = state.bookmark_service.confirm(&req.token).await?;
+
+    Ok(Json(match confirmed {
+        Ok(bookmark) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(bookmark.into()),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
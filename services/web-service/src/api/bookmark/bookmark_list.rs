@@ -0,0 +1,36 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, BookmarkListResponse, BookmarkView};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/bookmark/list",
+    responses(
+        (status = 200, description = "Topics bookmarked from the calling address", body = ApiResponse<BookmarkListResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Bookmark",
+    operation_id = "bookmarkList"
+)]
+#[axum::debug_handler]
+pub async fn bookmark_list(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<BookmarkListResponse>>, AppError> {
+    let ip = addr.ip().to_canonical().to_string();
+    let bookmarks = state.bookmark_service.list(&ip).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(BookmarkListResponse {
+            bookmarks: bookmarks.into_iter().map(BookmarkView::from).collect(),
+        }),
+        message: ApiMsg::OK,
+    }))
+}
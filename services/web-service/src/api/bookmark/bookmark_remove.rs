@@ -0,0 +1,45 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, BookmarkRemoveRequest};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/bookmark/remove",
+    request_body = BookmarkRemoveRequest,
+    responses(
+        (status = 200, description = "Bookmark and its reminder removed", body = ApiResponse<String>),
+        (status = 404, description = "The calling address has no bookmark of the topic", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Bookmark",
+    operation_id = "bookmarkRemove"
+)]
+#[axum::debug_handler]
+pub async fn bookmark_remove(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<BookmarkRemoveRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    let ip = addr.ip().to_canonical().to_string();
+    let removed = state.bookmark_service.remove(&ip, &req.topic_id).await?;
+
+    Ok(Json(match removed {
+        Ok(()) => ApiResponse {
+            status: 0,
+            data: ApiData::Empty,
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
@@ -0,0 +1,35 @@
+use std::sync::Arc;
+
+use axum::{
+    Router,
+    routing::{get, post},
+};
+use share::models::api::ApiMsg;
+
+use crate::state::AppState;
+
+pub mod bookmark_add;
+pub mod bookmark_confirm;
+pub mod bookmark_list;
+pub mod bookmark_remove;
+
+use bookmark_add::bookmark_add;
+use bookmark_confirm::bookmark_confirm;
+use bookmark_list::bookmark_list;
+use bookmark_remove::bookmark_remove;
+
+pub fn bookmark_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/list", get(bookmark_list))
+        .route("/add", post(bookmark_add))
+        .route("/confirm", post(bookmark_confirm))
+        .route("/remove", post(bookmark_remove))
+}
+
+/// Status of a rejected bookmark request.
+fn rejected_status(message: &ApiMsg) -> i32 {
+    match message {
+        ApiMsg::BookmarkInvalid(_) => 400,
+        _ => 404,
+    }
+}
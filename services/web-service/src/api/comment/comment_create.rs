@@ -0,0 +1,50 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, CommentCreateRequest, CommentCreateResponse,
+};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/comment/create",
+    request_body = CommentCreateRequest,
+    responses(
+        (status = 200, description = "Comment stored, listed right away unless comment.pre_moderation", body = ApiResponse<CommentCreateResponse>),
+        (status = 400, description = "Empty or too long", body = ApiResponse<String>),
+        (status = 403, description = "Comments are disabled", body = ApiResponse<String>),
+        (status = 404, description = "Topic or replied comment not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Comment",
+    operation_id = "commentCreate"
+)]
+#[axum::debug_handler]
+pub async fn comment_create(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<CommentCreateRequest>,
+) -> Result<Json<ApiResponse<CommentCreateResponse>>, AppError> {
+    let id = state.snowflake.next_id()?;
+    let ip = addr.ip().to_canonical().to_string();
+    let created = state.comment_service.create(id, &ip, req).await?;
+
+    Ok(Json(match created {
+        Ok(created) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(created),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
@@ -0,0 +1,44 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, CommentDeleteRequest};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/comment/delete",
+    request_body = CommentDeleteRequest,
+    responses(
+        (status = 200, description = "Comment deleted by its author", body = ApiResponse<String>),
+        (status = 403, description = "Delete token does not match", body = ApiResponse<String>),
+        (status = 404, description = "Comment not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Comment",
+    operation_id = "commentDelete"
+)]
+#[axum::debug_handler]
+pub async fn comment_delete(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<CommentDeleteRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    let deleted = state
+        .comment_service
+        .delete(&req.id, &req.delete_token)
+        .await?;
+
+    Ok(Json(match deleted {
+        Ok(()) => ApiResponse {
+            status: 0,
+            data: ApiData::Empty,
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
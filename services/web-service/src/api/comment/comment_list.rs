@@ -0,0 +1,40 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, CommentListRequest, CommentListResponse};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/comment/list",
+    request_body = CommentListRequest,
+    responses(
+        (status = 200, description = "One page of the comments of a topic, or of the replies to one", body = ApiResponse<CommentListResponse>),
+        (status = 403, description = "Comments are disabled", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Comment",
+    operation_id = "commentList"
+)]
+#[axum::debug_handler]
+pub async fn comment_list(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<CommentListRequest>,
+) -> Result<Json<ApiResponse<CommentListResponse>>, AppError> {
+    let page = state.comment_service.list(&req).await?;
+
+    Ok(Json(match page {
+        Ok(page) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(page),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
@@ -0,0 +1,48 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, CommentModerateRequest, CommentView},
+    database::ApiKey,
+};
+
+use super::rejected_status;
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/comment/moderate",
+    request_body = CommentModerateRequest,
+    responses(
+        (status = 200, description = "Comment approved, hidden or deleted", body = ApiResponse<CommentView>),
+        (status = 404, description = "Comment not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Comment",
+    operation_id = "commentModerate"
+)]
+#[axum::debug_handler]
+pub async fn comment_moderate(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<CommentModerateRequest>,
+) -> Result<Json<ApiResponse<CommentView>>, AppError> {
+    let moderated = state
+        .comment_service
+        .moderate(&req.id, req.action, &req.reason, &key.name)
+        .await?;
+
+    Ok(Json(match moderated {
+        Ok(comment) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(comment),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: rejected_status(&message),
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
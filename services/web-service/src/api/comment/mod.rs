@@ -0,0 +1,36 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+use share::models::api::ApiMsg;
+
+use crate::state::AppState;
+
+pub mod comment_create;
+pub mod comment_delete;
+pub mod comment_list;
+pub mod comment_moderate;
+
+use comment_create::comment_create;
+use comment_delete::comment_delete;
+use comment_list::comment_list;
+use comment_moderate::comment_moderate;
+
+pub fn comment_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/create", post(comment_create))
+        .route("/list", post(comment_list))
+        .route("/delete", post(comment_delete))
+}
+
+pub fn comment_moderation_routes() -> Router<Arc<AppState>> {
+    Router::new().route("/moderate", post(comment_moderate))
+}
+
+/// Status of a rejected comment request.
+fn rejected_status(message: &ApiMsg) -> i32 {
+    match message {
+        ApiMsg::CommentsDisabled | ApiMsg::CommentDeleteTokenInvalid => 403,
+        ApiMsg::CommentInvalid(_) | ApiMsg::ContentRejected(_) => 400,
+        _ => 404,
+    }
+}
@@ -0,0 +1,44 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, ConsentAcceptRequest, ConsentStatus};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/consent/accept",
+    request_body = ConsentAcceptRequest,
+    responses(
+        (status = 200, description = "Acceptance recorded, ballot requests carry the version from now on", body = ApiResponse<ConsentStatus>),
+        (status = 409, description = "Not the current version, the terms changed since they were shown", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Consent",
+    operation_id = "consentAccept"
+)]
+#[axum::debug_handler]
+pub async fn consent_accept(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ConsentAcceptRequest>,
+) -> Result<Json<ApiResponse<ConsentStatus>>, AppError> {
+    let ip = addr.ip().to_canonical().to_string();
+    let accepted = state.consent_service.accept(&ip, &req.version).await?;
+
+    Ok(Json(match accepted {
+        Ok(status) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(status),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: 409,
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
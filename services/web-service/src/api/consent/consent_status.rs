@@ -0,0 +1,34 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, ConsentStatus};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/consent/status",
+    responses(
+        (status = 200, description = "Current terms and when they were accepted from the calling address", body = ApiResponse<ConsentStatus>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Consent",
+    operation_id = "consentStatus"
+)]
+#[axum::debug_handler]
+pub async fn consent_status(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<ConsentStatus>>, AppError> {
+    let ip = addr.ip().to_canonical().to_string();
+    let status = state.consent_service.status(&ip).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(status),
+        message: ApiMsg::OK,
+    }))
+}
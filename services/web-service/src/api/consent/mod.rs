@@ -0,0 +1,20 @@
+use std::sync::Arc;
+
+use axum::{
+    Router,
+    routing::{get, post},
+};
+
+use crate::state::AppState;
+
+pub mod consent_accept;
+pub mod consent_status;
+
+use consent_accept::consent_accept;
+use consent_status::consent_status;
+
+pub fn consent_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/status", get(consent_status))
+        .route("/accept", post(consent_accept))
+}
@@ -0,0 +1,111 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+    http::{StatusCode, header},
+    response::{IntoResponse as _, Response},
+};
+use share::models::{
+    api::{EmbedOEmbedQuery, OEmbedResponse},
+    database::CreateTopicStatus,
+};
+
+use super::{preview_image_url, topic_id_from_url};
+use crate::{AppState, error::AppError, service::ShareCardService};
+
+/// The card scaled down to fit `maxwidth` and `maxheight`, never up.
+fn fit(max_width: Option<u32>, max_height: Option<u32>) -> (u32, u32) {
+    let (width, height) = ShareCardService::SIZE;
+    let scale = [
+        max_width.map(|max| f64::from(max) / f64::from(width)),
+        max_height.map(|max| f64::from(max) / f64::from(height)),
+    ]
+    .into_iter()
+    .flatten()
+    .fold(1.0_f64, f64::min);
+    (
+        (f64::from(width) * scale).round() as u32,
+        (f64::from(height) * scale).round() as u32,
+    )
+}
+
+#[utoipa::path(
+    get,
+    path = "/embed/oembed",
+    params(EmbedOEmbedQuery),
+    responses(
+        (status = 200, description = "oEmbed photo of the topic's share card", body = OEmbedResponse),
+        (status = 404, description = "Not a topic page, or the topic is not approved"),
+        (status = 501, description = "A format other than json was asked for")
+    ),
+    tag = "Embed",
+    operation_id = "embedOEmbed"
+)]
+#[axum::debug_handler]
+pub async fn embed_oembed(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<EmbedOEmbedQuery>,
+) -> Result<Response, AppError> {
+    if query
+        .format
+        .as_deref()
+        .is_some_and(|format| format != "json")
+    {
+        return Ok(StatusCode::NOT_IMPLEMENTED.into_response());
+    }
+    let config = state.config.current();
+    let embed = &config.embed;
+    let Some(id) = topic_id_from_url(embed, &query.url) else {
+        return Ok(StatusCode::NOT_FOUND.into_response());
+    };
+    let topic = match state.topic_service.get_topic(id).await? {
+        Some(topic) if matches!(topic.status, CreateTopicStatus::Approved(_)) => topic,
+        _ => return Ok(StatusCode::NOT_FOUND.into_response()),
+    };
+
+    let (width, height) = fit(query.maxwidth, query.maxheight);
+    let provider_url = match embed.topic_url.split_once("://") {
+        Some((scheme, rest)) => {
+            let host = rest.split('/').next().unwrap_or(rest);
+            format!("{scheme}://{host}")
+        }
+        None => embed.topic_url.clone(),
+    };
+    let response = OEmbedResponse {
+        version: "1.0".to_string(),
+        kind: "photo".to_string(),
+        title: match topic.title.is_empty() {
+            true => topic.name,
+            false => topic.title,
+        },
+        provider_name: embed.site_name.clone(),
+        provider_url,
+        url: preview_image_url(embed, &topic.id),
+        width,
+        height,
+        cache_age: embed.cache_secs,
+    };
+
+    Ok((
+        [(
+            header::CACHE_CONTROL,
+            format!("public, max-age={}", embed.cache_secs),
+        )],
+        Json(response),
+    )
+        .into_response())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn cards_are_scaled_down_to_fit() {
+        assert_eq!(fit(None, None), (1200, 630));
+        assert_eq!(fit(Some(600), None), (600, 315));
+        assert_eq!(fit(Some(600), Some(100)), (190, 100));
+        assert_eq!(fit(Some(5000), None), (1200, 630));
+    }
+}
@@ -0,0 +1,110 @@
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, State},
+    http::{StatusCode, header},
+    response::{Html, IntoResponse as _, Response},
+};
+use share::models::database::CreateTopicStatus;
+
+use super::{escape_html, preview_image_url, topic_url};
+use crate::{AppState, error::AppError, service::ShareCardService};
+
+/// Longer descriptions are cut, the platforms show two or three lines.
+const MAX_DESCRIPTION_CHARS: usize = 200;
+
+#[utoipa::path(
+    get,
+    path = "/embed/topic/{id}",
+    params(("id" = String, Path, description = "Topic id")),
+    responses(
+        (status = 200, description = "Page with the Open Graph tags of the topic, redirecting browsers to the topic page", content_type = "text/html"),
+        (status = 404, description = "Topic not found or not approved")
+    ),
+    tag = "Embed",
+    operation_id = "embedTopic"
+)]
+#[axum::debug_handler]
+pub async fn embed_topic(
+    State(state): State<Arc<AppState>>,
+    Path(id): Path<String>,
+) -> Result<Response, AppError> {
+    let topic = match state.topic_service.get_topic(&id).await? {
+        Some(topic) if matches!(topic.status, CreateTopicStatus::Approved(_)) => topic,
+        _ => return Ok(StatusCode::NOT_FOUND.into_response()),
+    };
+    let config = state.config.current();
+    let embed = &config.embed;
+
+    let page_url = topic_url(embed, &topic.id);
+    let oembed_url = reqwest::Url::parse_with_params(
+        &format!("{}/embed/oembed", embed.api_url.trim_end_matches('/')),
+        &[("url", page_url.as_str()), ("format", "json")],
+    )
+    .map(|url| url.to_string())
+    .unwrap_or_default();
+    let title = match topic.title.is_empty() {
+        true => &topic.name,
+        false => &topic.title,
+    };
+    let description: String = topic
+        .description
+        .chars()
+        .take(MAX_DESCRIPTION_CHARS)
+        .collect();
+    let (width, height) = ShareCardService::SIZE;
+
+    let image_url = preview_image_url(embed, &topic.id);
+    let [
+        site_name,
+        title,
+        description,
+        page_url,
+        image_url,
+        oembed_url,
+    ] = [
+        embed.site_name.as_str(),
+        title.as_str(),
+        description.as_str(),
+        page_url.as_str(),
+        image_url.as_str(),
+        oembed_url.as_str(),
+    ]
+    .map(escape_html);
+    let page = format!(
+        r#"<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{title}</title>
+<meta name="description" content="{description}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="{site_name}">
+<meta property="og:title" content="{title}">
+<meta property="og:description" content="{description}">
+<meta property="og:url" content="{page_url}">
+<meta property="og:image" content="{image_url}">
+<meta property="og:image:width" content="{width}">
+<meta property="og:image:height" content="{height}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{title}">
+<meta name="twitter:description" content="{description}">
+<meta name="twitter:image" content="{image_url}">
+<link rel="canonical" href="{page_url}">
+<link rel="alternate" type="application/json+oembed" href="{oembed_url}" title="{title}">
+<meta http-equiv="refresh" content="0; url={page_url}">
+</head>
+<body><a href="{page_url}">{title}</a></body>
+</html>
+"#
+    );
+
+    Ok((
+        [(
+            header::CACHE_CONTROL,
+            format!("public, max-age={}", embed.cache_secs),
+        )],
+        Html(page),
+    )
+        .into_response())
+}
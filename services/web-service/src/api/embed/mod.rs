@@ -0,0 +1,95 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::get};
+use share::config::EmbedConfig;
+
+use crate::state::AppState;
+
+pub mod embed_oembed;
+pub mod embed_topic;
+
+use embed_oembed::embed_oembed;
+use embed_topic::embed_topic;
+
+pub fn embed_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/topic/{id}", get(embed_topic))
+        .route("/oembed", get(embed_oembed))
+}
+
+/// For text and attribute values of the preview pages and the feeds.
+pub(crate) fn escape_html(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => escaped.push_str("&amp;"),
+            '<' => escaped.push_str("&lt;"),
+            '>' => escaped.push_str("&gt;"),
+            '"' => escaped.push_str("&quot;"),
+            '\'' => escaped.push_str("&#39;"),
+            c => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+/// The frontend page of a topic.
+pub(crate) fn topic_url(config: &EmbedConfig, id: &str) -> String {
+    config.topic_url.replace("{id}", id)
+}
+
+/// The topic of a frontend page url, its query and fragment ignored.
+fn topic_id_from_url<'a>(config: &EmbedConfig, url: &'a str) -> Option<&'a str> {
+    let (prefix, suffix) = config.topic_url.split_once("{id}")?;
+    let path = url.split(['?', '#']).next().unwrap_or(url);
+    let id = path.strip_prefix(prefix)?.strip_suffix(suffix)?;
+    (!id.is_empty() && !id.contains('/')).then_some(id)
+}
+
+/// The share card the previews show.
+fn preview_image_url(config: &EmbedConfig, id: &str) -> String {
+    let base = format!(
+        "{}/results/share_card",
+        config.api_url.trim_end_matches('/')
+    );
+    match reqwest::Url::parse_with_params(&base, &[("topic_id", id)]) {
+        Ok(url) => url.to_string(),
+        Err(_) => base,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn topic_urls_round_trip() {
+        let config = EmbedConfig {
+            topic_url: "https://vote.example.com/topic/{id}/".to_string(),
+            ..Default::default()
+        };
+        let url = topic_url(&config, "2025-annual");
+        assert_eq!(url, "https://vote.example.com/topic/2025-annual/");
+        assert_eq!(topic_id_from_url(&config, &url), Some("2025-annual"));
+        assert_eq!(
+            topic_id_from_url(&config, &format!("{url}?ref=share#top")),
+            Some("2025-annual")
+        );
+        assert_eq!(
+            topic_id_from_url(&config, "https://vote.example.com/topic/a/b/"),
+            None
+        );
+        assert_eq!(
+            topic_id_from_url(&config, "https://evil.example/topic/x/"),
+            None
+        );
+    }
+
+    #[test]
+    fn html_is_escaped() {
+        assert_eq!(
+            escape_html(r#"<b>"Amiya" & 'Kal'tsit'</b>"#),
+            "&lt;b&gt;&quot;Amiya&quot; &amp; &#39;Kal&#39;tsit&#39;&lt;/b&gt;"
+        );
+    }
+}
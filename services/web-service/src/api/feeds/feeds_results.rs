@@ -0,0 +1,103 @@
+use std::{collections::HashMap, sync::Arc};
+
+use axum::{
+    extract::{Query, State},
+    http::header,
+    response::{IntoResponse as _, Response},
+};
+use share::models::{api::FeedsResultsQuery, excel::Language};
+
+use super::{FeedEntry, render_feed};
+use crate::{
+    AppState,
+    api::{embed::topic_url, results::results_final_order::rank_operators},
+    error::AppError,
+    service::ResultsSnapshot,
+};
+
+#[utoipa::path(
+    get,
+    path = "/feeds/results.xml",
+    params(FeedsResultsQuery),
+    responses(
+        (status = 200, description = "Atom feed of the latest closed topics with their top options", content_type = "application/atom+xml"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Feeds",
+    operation_id = "feedsResults"
+)]
+#[axum::debug_handler]
+pub async fn feeds_results(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<FeedsResultsQuery>,
+) -> Result<Response, AppError> {
+    let config = state.config.current();
+    let recent = state.results_archive.recent().await?;
+    let character_infos = state.operator_service.character_infos();
+    let cn_names: HashMap<i32, &str> = character_infos
+        .iter()
+        .map(|info| (info.id, info.name.as_str()))
+        .collect();
+
+    let mut entries = Vec::with_capacity(recent.len());
+    for (topic, archived) in recent.iter() {
+        let Some(pool) = state
+            .topic_service
+            .get_candidate_pool(&topic.id, &character_infos)
+            .await
+        else {
+            continue;
+        };
+        let names: HashMap<i32, String> = pool
+            .iter()
+            .map(|&id| {
+                let localized = match query.lang {
+                    Language::Cn => None,
+                    lang => state.operator_service.localized_name(id, lang),
+                };
+                let name = localized
+                    .or_else(|| cn_names.get(&id).map(|name| name.to_string()))
+                    .unwrap_or_else(|| format!("Unknown Operator {id}"));
+                (id, name)
+            })
+            .collect();
+        let snapshot = ResultsSnapshot::new(archived.stats.clone(), HashMap::new(), archived.count);
+        let mut results = rank_operators(&snapshot, &pool, &names);
+        results.truncate(config.feeds.top);
+
+        entries.push(FeedEntry {
+            title: match topic.title.is_empty() {
+                true => topic.name.clone(),
+                false => topic.title.clone(),
+            },
+            page_url: topic_url(&config.embed, &topic.id),
+            closed_at: topic.close_time,
+            archived_at: archived.taken_at,
+            count: archived.count,
+            top: results
+                .into_iter()
+                .map(|result| (result.name, result.rate))
+                .collect(),
+        });
+    }
+
+    let self_url = format!(
+        "{}/feeds/results.xml",
+        config.embed.api_url.trim_end_matches('/')
+    );
+    let feed = render_feed(&config.embed.site_name, &self_url, query.lang, &entries);
+    Ok((
+        [
+            (
+                header::CONTENT_TYPE,
+                "application/atom+xml; charset=utf-8".to_string(),
+            ),
+            (
+                header::CACHE_CONTROL,
+                format!("public, max-age={}", config.feeds.cache_secs),
+            ),
+        ],
+        feed,
+    )
+        .into_response())
+}
@@ -0,0 +1,128 @@
+use std::{fmt::Write as _, sync::Arc};
+
+use axum::{Router, routing::get};
+use chrono::{DateTime, SecondsFormat, Utc};
+use share::models::excel::Language;
+
+use crate::{api::embed::escape_html, state::AppState};
+
+pub mod feeds_results;
+
+use feeds_results::feeds_results;
+
+pub fn feeds_routes() -> Router<Arc<AppState>> {
+    Router::new().route("/results.xml", get(feeds_results))
+}
+
+/// One closed topic of the results feed.
+struct FeedEntry {
+    title: String,
+    page_url: String,
+    closed_at: DateTime<Utc>,
+    archived_at: DateTime<Utc>,
+    count: i64,
+    /// Names and win rates in percent, best first.
+    top: Vec<(String, f64)>,
+}
+
+fn votes(lang: Language, count: i64) -> String {
+    match lang {
+        Language::Cn | Language::Jp => format!("{count} 票"),
+        Language::En => format!("{count} votes"),
+    }
+}
+
+fn timestamp(at: DateTime<Utc>) -> String {
+    at.to_rfc3339_opts(SecondsFormat::Secs, true)
+}
+
+/// An Atom document of the entries, `self_url` is its own address.
+fn render_feed(site_name: &str, self_url: &str, lang: Language, entries: &[FeedEntry]) -> String {
+    let updated = entries
+        .iter()
+        .map(|entry| entry.archived_at)
+        .max()
+        .unwrap_or(DateTime::UNIX_EPOCH);
+    let mut feed = format!(
+        r#"<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>{site_name}</title>
+<id>{self_url}</id>
+<link rel="self" type="application/atom+xml" href="{self_url}"/>
+<updated>{updated}</updated>
+<author><name>{site_name}</name></author>
+"#,
+        site_name = escape_html(site_name),
+        self_url = escape_html(self_url),
+        updated = timestamp(updated),
+    );
+
+    for entry in entries {
+        let summary = entry
+            .top
+            .iter()
+            .enumerate()
+            .map(|(i, (name, rate))| format!("{}. {name} {rate:.1}%", i + 1))
+            .collect::<Vec<_>>()
+            .join(" / ");
+        let mut content = String::from("<ol>");
+        for (name, rate) in &entry.top {
+            let _ = write!(content, "<li>{} · {rate:.1}%</li>", escape_html(name));
+        }
+        let _ = write!(content, "</ol><p>{}</p>", votes(lang, entry.count));
+
+        let _ = write!(
+            feed,
+            r#"<entry>
+<title>{title}</title>
+<id>{page_url}</id>
+<link rel="alternate" type="text/html" href="{page_url}"/>
+<published>{published}</published>
+<updated>{updated}</updated>
+<summary>{summary}</summary>
+<content type="html">{content}</content>
+</entry>
+"#,
+            title = escape_html(&entry.title),
+            page_url = escape_html(&entry.page_url),
+            published = timestamp(entry.closed_at),
+            updated = timestamp(entry.archived_at),
+            summary = escape_html(&summary),
+            content = escape_html(&content),
+        );
+    }
+    feed.push_str("</feed>\n");
+    feed
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn entries_are_escaped() {
+        let at = DateTime::from_timestamp(1_750_000_000, 0).unwrap();
+        let entry = FeedEntry {
+            title: "Best <Guard> & Defender".to_string(),
+            page_url: "https://vote.example.com/topic/a?b=1&c=2".to_string(),
+            closed_at: at,
+            archived_at: at,
+            count: 42,
+            top: vec![("<b>Saria</b>".to_string(), 61.26)],
+        };
+
+        let feed = render_feed(
+            "Ark Vote",
+            "https://x/feeds/results.xml",
+            Language::En,
+            &[entry],
+        );
+        assert!(feed.contains("<title>Best &lt;Guard&gt; &amp; Defender</title>"));
+        assert!(feed.contains("href=\"https://vote.example.com/topic/a?b=1&amp;c=2\""));
+        assert!(feed.contains("<updated>2025-06-15T15:06:40Z</updated>"));
+        assert!(feed.contains("<summary>1. &lt;b&gt;Saria&lt;/b&gt; 61.3%</summary>"));
+        assert!(feed.contains("&lt;li&gt;&amp;lt;b&amp;gt;Saria"));
+        assert!(feed.contains("42 votes"));
+        assert!(feed.ends_with("</feed>\n"));
+    }
+}
@@ -0,0 +1,33 @@
+use std::sync::Arc;
+
+use async_graphql::{EmptyMutation, EmptySubscription, Schema, http::GraphiQLSource};
+use async_graphql_axum::GraphQL;
+use axum::{Router, response::Html, routing::get};
+use share::config::GraphqlConfig;
+
+use crate::state::AppState;
+
+mod schema;
+
+use schema::{ArkVoteSchema, QueryRoot};
+
+const GRAPHQL_PATH: &str = "/api/graphql";
+
+pub fn graphql_routes(state: &Arc<AppState>, config: &GraphqlConfig) -> Router<Arc<AppState>> {
+    let schema: ArkVoteSchema = Schema::build(QueryRoot, EmptyMutation, EmptySubscription)
+        .data(state.clone())
+        .limit_depth(config.max_depth)
+        .limit_complexity(config.max_complexity)
+        .finish();
+
+    let service = GraphQL::new(schema);
+    let route = if config.graphiql {
+        get(|| async { Html(GraphiQLSource::build().endpoint(GRAPHQL_PATH).finish()) })
+            .post_service(service)
+    } else {
+        axum::routing::post_service(service)
+    };
+
+    let router = Router::new().route(GRAPHQL_PATH, route);
+    super::with_results_guard(state, router)
+}
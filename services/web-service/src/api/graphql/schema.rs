@@ -0,0 +1,206 @@
+use std::sync::Arc;
+
+use async_graphql::{
+    ComplexObject, Context, EmptyMutation, EmptySubscription, Object, SimpleObject,
+};
+use chrono::{DateTime, Utc};
+use share::models::{database::VotingTopic, excel::CharacterInfo};
+
+use crate::{
+    api::results::results_final_order::{FinalOrderOptions, compute_final_order},
+    state::AppState,
+};
+
+pub type ArkVoteSchema = async_graphql::Schema<QueryRoot, EmptyMutation, EmptySubscription>;
+
+fn app_state<'a>(ctx: &Context<'a>) -> &'a Arc<AppState> {
+    ctx.data_unchecked::<Arc<AppState>>()
+}
+
+#[derive(SimpleObject)]
+pub struct Operator {
+    pub id: i32,
+    pub name: String,
+    pub rarity: i32,
+    pub profession: String,
+    pub sub_profession_id: String,
+    pub avatar: Vec<String>,
+}
+
+impl Operator {
+    fn new(state: &AppState, info: &CharacterInfo) -> Self {
+        Self {
+            id: info.id,
+            name: info.name.clone(),
+            rarity: info.rarity.to_numeric(),
+            profession: format!("{:?}", info.profession),
+            sub_profession_id: info.sub_profession_id.clone(),
+            avatar: state
+                .character_portraits
+                .get(&info.id)
+                .map(|portrait| portrait.avatar.clone())
+                .unwrap_or_default(),
+        }
+    }
+}
+
+#[derive(SimpleObject)]
+pub struct RankingItem {
+    pub rank: usize,
+    pub id: i32,
+    pub name: String,
+    pub win: i64,
+    pub lose: i64,
+    pub score: f64,
+    pub rate: f64,
+}
+
+#[derive(SimpleObject)]
+pub struct Ranking {
+    pub valid_ballots_count: i64,
+    pub items: Vec<RankingItem>,
+}
+
+#[derive(SimpleObject)]
+pub struct Matchup {
+    pub winner_id: i32,
+    pub loser_id: i32,
+    /// Sum of the ballot multipliers where `winner_id` beat `loser_id`, minus
+    /// the reverse.
+    pub score: i64,
+}
+
+#[derive(SimpleObject)]
+#[graphql(complex)]
+pub struct Topic {
+    pub id: String,
+    pub name: String,
+    pub title: String,
+    pub description: String,
+    pub topic_type: String,
+    pub open_time: DateTime<Utc>,
+    pub close_time: DateTime<Utc>,
+    pub is_active: bool,
+}
+
+impl From<VotingTopic> for Topic {
+    fn from(topic: VotingTopic) -> Self {
+        Self {
+            id: topic.id,
+            name: topic.name,
+            title: topic.title,
+            description: topic.description,
+            topic_type: format!("{:?}", topic.topic_type),
+            open_time: topic.open_time,
+            close_time: topic.close_time,
+            is_active: topic.is_active,
+        }
+    }
+}
+
+#[ComplexObject]
+impl Topic {
+    /// Operators in the candidate pool of this topic.
+    async fn options(&self, ctx: &Context<'_>) -> async_graphql::Result<Vec<Operator>> {
+        let state = app_state(ctx);
+        let character_infos = state.operator_service.character_infos();
+        let pool = state
+            .topic_service
+            .get_candidate_pool(&self.id, &character_infos)
+            .await
+            .unwrap_or_default();
+
+        Ok(pool
+            .into_iter()
+            .filter_map(|id| character_infos.iter().find(|info| info.id == id))
+            .map(|info| Operator::new(state, info))
+            .collect())
+    }
+
+    /// Final order of this topic, `null` when the topic type has no ranking.
+    async fn ranking(&self, ctx: &Context<'_>) -> async_graphql::Result<Option<Ranking>> {
+        let state = app_state(ctx);
+        let Ok(final_order) =
+            compute_final_order(state, self.id.clone(), FinalOrderOptions::default()).await?
+        else {
+            return Ok(None);
+        };
+
+        Ok(Some(Ranking {
+            valid_ballots_count: final_order.count,
+            items: final_order
+                .items
+                .into_iter()
+                .enumerate()
+                .map(|(i, r)| RankingItem {
+                    rank: i + 1,
+                    id: r.id,
+                    name: r.name,
+                    win: r.win,
+                    lose: r.lose,
+                    score: r.score,
+                    rate: r.rate,
+                })
+                .collect(),
+        }))
+    }
+
+    /// Head-to-head results, optionally narrowed to the matchups of one
+    /// operator.
+    async fn matchups(
+        &self,
+        ctx: &Context<'_>,
+        operator_id: Option<i32>,
+    ) -> async_graphql::Result<Vec<Matchup>> {
+        let state = app_state(ctx);
+        let snapshot = state.results_snapshots.get(&self.id).await?;
+
+        Ok(snapshot
+            .matrix
+            .iter()
+            .filter_map(|(key, &score)| {
+                let (winner, loser) = key.split_once(':')?;
+                Some(Matchup {
+                    winner_id: winner.parse().ok()?,
+                    loser_id: loser.parse().ok()?,
+                    score,
+                })
+            })
+            .filter(|matchup| operator_id.is_none_or(|id| matchup.winner_id == id))
+            .collect())
+    }
+}
+
+pub struct QueryRoot;
+
+#[Object]
+impl QueryRoot {
+    /// All currently active topics.
+    async fn topics(&self, ctx: &Context<'_>) -> async_graphql::Result<Vec<Topic>> {
+        let state = app_state(ctx);
+        let mut topics = Vec::new();
+        for topic_id in state.topic_service.get_active_topic_ids().await? {
+            if let Some(topic) = state.topic_service.get_topic(&topic_id).await? {
+                topics.push(topic.into());
+            }
+        }
+
+        Ok(topics)
+    }
+
+    async fn topic(&self, ctx: &Context<'_>, id: String) -> async_graphql::Result<Option<Topic>> {
+        let state = app_state(ctx);
+        Ok(state.topic_service.get_topic(&id).await?.map(Topic::from))
+    }
+
+    /// Every known operator, independent of any topic.
+    async fn operators(&self, ctx: &Context<'_>) -> Vec<Operator> {
+        let state = app_state(ctx);
+        state
+            .operator_service
+            .character_infos()
+            .iter()
+            .map(|info| Operator::new(state, info))
+            .collect()
+    }
+}
@@ -0,0 +1,83 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, Path, Query, State},
+};
+use share::models::api::{
+    AffinityPick, AffinityQuery, AffinityResponse, ApiData, ApiMsg, ApiResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/me/topics/{id}/affinity",
+    params(("id" = String, Path, description = "Topic id"), AffinityQuery),
+    responses(
+        (status = 200, description = "How the ballots cast from the calling address compare with the results", body = ApiResponse<AffinityResponse>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Not a pairwise topic, or an internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "meTopicAffinity"
+)]
+#[axum::debug_handler]
+pub async fn me_topic_affinity(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+    Path(id): Path<String>,
+    Query(query): Query<AffinityQuery>,
+) -> Result<Json<ApiResponse<AffinityResponse>>, AppError> {
+    let topic = match state.topic_service.get_topic(&id).await? {
+        Some(topic) if topic.topic_type.supports_1v1_matrix() => topic,
+        Some(_) => {
+            return Ok(Json(ApiResponse {
+                status: 500,
+                data: ApiData::Empty,
+                message: ApiMsg::CurTopicNotSupport1v1Matrix,
+            }));
+        }
+        None => {
+            return Ok(Json(ApiResponse {
+                status: 404,
+                data: ApiData::Empty,
+                message: ApiMsg::TargetTopicNotFound,
+            }));
+        }
+    };
+
+    let ip = addr.ip().to_canonical().to_string();
+    let affinity = state.affinity_service.compare(&topic.id, &ip).await?;
+    let name = |id: i32| {
+        state
+            .operator_service
+            .localized_name(id, query.lang)
+            .unwrap_or_else(|| format!("Unknown Operator {id}"))
+    };
+    let decided = affinity.agreed + affinity.disagreed;
+    let response = AffinityResponse {
+        topic_id: topic.id,
+        ballots: affinity.ballots,
+        agreed: affinity.agreed,
+        disagreed: affinity.disagreed,
+        agreement_rate: (decided > 0).then(|| affinity.agreed as f64 * 100.0 / decided as f64),
+        contrarian_picks: affinity
+            .contrarian
+            .into_iter()
+            .map(|(winner_id, loser_id, crowd_margin)| AffinityPick {
+                winner_id,
+                winner_name: name(winner_id),
+                loser_id,
+                loser_name: name(loser_id),
+                crowd_margin,
+            })
+            .collect(),
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(response),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,14 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::get};
+
+use crate::state::AppState;
+
+pub mod me_topic_affinity;
+
+use me_topic_affinity::me_topic_affinity;
+
+/// About the calling address, there are no accounts.
+pub fn me_routes() -> Router<Arc<AppState>> {
+    Router::new().route("/topics/{id}/affinity", get(me_topic_affinity))
+}
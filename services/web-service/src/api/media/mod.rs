@@ -0,0 +1,17 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+
+use crate::state::AppState;
+
+pub mod option_image_delete;
+pub mod option_image_upload;
+
+use option_image_delete::option_image_delete;
+use option_image_upload::option_image_upload;
+
+pub fn media_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/option_image/upload", post(option_image_upload)) // 上传选项图片
+        .route("/option_image/delete", post(option_image_delete)) // 删除选项图片
+}
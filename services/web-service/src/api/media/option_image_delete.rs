@@ -0,0 +1,57 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, OptionImageDeleteRequest},
+    database::{ApiKey, AuditLogEntry},
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/media/option_image/delete",
+    request_body = OptionImageDeleteRequest,
+    responses(
+        (status = 200, description = "Image deleted", body = ApiResponse<String>),
+        (status = 404, description = "Option has no uploaded image", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Media",
+    operation_id = "optionImageDelete"
+)]
+#[axum::debug_handler]
+pub async fn option_image_delete(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<OptionImageDeleteRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    if !state
+        .option_image_service
+        .delete(&req.topic_id, req.option_id)
+        .await?
+    {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::OptionImageNotFound,
+        }));
+    }
+
+    state
+        .audit_log_service
+        .record(AuditLogEntry::new(
+            Some(req.topic_id),
+            &key.name,
+            "option_image_deleted",
+            format!("option {}", req.option_id),
+        ))
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Empty,
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,105 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use base64::Engine as _;
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, OptionImageUploadRequest, OptionImageUploadResponse},
+    database::{ApiKey, AuditLogEntry},
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/media/option_image/upload",
+    request_body = OptionImageUploadRequest,
+    responses(
+        (status = 200, description = "Image stored, replacing any previous one", body = ApiResponse<OptionImageUploadResponse>),
+        (status = 400, description = "Image rejected or option not in the candidate pool", body = ApiResponse<String>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Media",
+    operation_id = "optionImageUpload"
+)]
+#[axum::debug_handler]
+pub async fn option_image_upload(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<OptionImageUploadRequest>,
+) -> Result<Json<ApiResponse<OptionImageUploadResponse>>, AppError> {
+    // base64 inflates by a third, refuse before decoding anything oversized
+    let max_encoded = state.option_image_service.max_upload_bytes() / 3 * 4 + 4;
+    if req.image_base64.len() > max_encoded {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::OptionImageInvalid("image is too large".to_string()),
+        }));
+    }
+    let bytes = match base64::engine::general_purpose::STANDARD.decode(req.image_base64.trim()) {
+        Ok(bytes) => bytes,
+        Err(e) => {
+            return Ok(Json(ApiResponse {
+                status: 400,
+                data: ApiData::Empty,
+                message: ApiMsg::OptionImageInvalid(e.to_string()),
+            }));
+        }
+    };
+
+    let Some(pool) = state
+        .topic_service
+        .get_candidate_pool(&req.topic_id, &state.operator_service.character_infos())
+        .await
+    else {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::TargetTopicNotFound,
+        }));
+    };
+    if !pool.contains(&req.option_id) {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::OptionNotInCandidatePool,
+        }));
+    }
+
+    let url = match state
+        .option_image_service
+        .upload(&req.topic_id, req.option_id, bytes, &key.name)
+        .await?
+    {
+        Ok(url) => url,
+        Err(e) => {
+            return Ok(Json(ApiResponse {
+                status: 400,
+                data: ApiData::Empty,
+                message: ApiMsg::OptionImageInvalid(e.to_string()),
+            }));
+        }
+    };
+
+    state
+        .audit_log_service
+        .record(AuditLogEntry::new(
+            Some(req.topic_id.clone()),
+            &key.name,
+            "option_image_uploaded",
+            format!("option {}", req.option_id),
+        ))
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(OptionImageUploadResponse {
+            topic_id: req.topic_id,
+            option_id: req.option_id,
+            url,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
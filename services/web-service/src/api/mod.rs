@@ -1,27 +1,176 @@
 use std::sync::Arc;
 
-use axum::Router;
+use axum::{Router, extract::DefaultBodyLimit, middleware::from_fn_with_state};
+use share::models::database::ApiKeyScope;
 
-use crate::AppState;
+use crate::{
+    AppState,
+    middleware::api_key::{AnonymousAccess, ApiKeyGuard, api_key_auth},
+};
 
+mod announcement;
+mod api_key;
 mod audit;
 mod ballot;
+pub mod bench;
+mod bookmark;
+mod comment;
+mod consent;
+mod embed;
+mod feeds;
+mod graphql;
+mod me;
+mod media;
 mod openapi;
+mod operator;
+mod privacy;
+mod queue;
+mod report;
 mod results;
+mod system;
 mod topic;
 mod utils;
+mod v2;
+mod webhook;
 
+use announcement::{announcement_admin_routes, announcement_routes};
+use api_key::api_key_routes;
 use audit::audit_routes;
 use ballot::ballot_routes;
+use bookmark::bookmark_routes;
+use comment::{comment_moderation_routes, comment_routes};
+use consent::consent_routes;
+use embed::embed_routes;
+use feeds::feeds_routes;
+use me::me_routes;
+use media::media_routes;
+use operator::{operator_alias_routes, operator_routes};
+use privacy::privacy_routes;
+use queue::queue_routes;
+use report::{report_moderation_routes, report_routes};
 use results::results_routes;
+use system::{system_admin_routes, system_routes};
 use topic::topic_routes;
+use webhook::webhook_routes;
 
+pub use graphql::graphql_routes;
 pub use openapi::ApiDoc;
 
-pub fn routes() -> Router<Arc<AppState>> {
+/// Room for the json around an encoded image.
+const MEDIA_BODY_OVERHEAD: usize = 64 * 1024;
+
+pub fn routes(state: &Arc<AppState>) -> Router<Arc<AppState>> {
+    let v1 = v1_routes(state);
+
+    Router::new()
+        // unversioned paths are v1, the deployed frontend still uses them
+        .merge(v1.clone())
+        .nest("/api/v1", v1)
+        .nest("/api/v2", v2::v2_routes(state))
+}
+
+fn v1_routes(state: &Arc<AppState>) -> Router<Arc<AppState>> {
+    shared_routes(state)
+        .nest("/results", with_results_guard(state, results_routes()))
+        .nest("/me", with_results_guard(state, me_routes()))
+}
+
+/// Groups whose request and response shapes are the same in every version.
+fn shared_routes(state: &Arc<AppState>) -> Router<Arc<AppState>> {
+    let admin_guard = ApiKeyGuard {
+        state: state.clone(),
+        scope: ApiKeyScope::Admin,
+        anonymous: AnonymousAccess::Deny,
+    };
+    let moderation_guard = ApiKeyGuard {
+        state: state.clone(),
+        scope: ApiKeyScope::Moderation,
+        anonymous: AnonymousAccess::Deny,
+    };
+
     Router::new()
         .nest("/topic", topic_routes())
-        .nest("/ballot", ballot_routes())
+        .nest("/ballot", ballot_routes(state))
+        .nest("/consent", consent_routes())
+        .nest("/bookmark", bookmark_routes())
+        .nest(
+            "/comment",
+            comment_routes().merge(
+                comment_moderation_routes()
+                    .route_layer(from_fn_with_state(moderation_guard.clone(), api_key_auth)),
+            ),
+        )
+        .nest(
+            "/report",
+            report_routes().merge(
+                report_moderation_routes()
+                    .route_layer(from_fn_with_state(moderation_guard, api_key_auth)),
+            ),
+        )
+        .nest(
+            "/announcements",
+            announcement_routes().merge(
+                announcement_admin_routes()
+                    .route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth)),
+            ),
+        )
+        .nest("/embed", embed_routes())
+        .nest("/feeds", feeds_routes())
         .nest("/audit", audit_routes())
-        .nest("/results", results_routes())
+        .nest("/operator", operator_routes())
+        .nest(
+            "/system",
+            system_routes().merge(
+                system_admin_routes()
+                    .route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth)),
+            ),
+        )
+        .nest(
+            "/operator/alias",
+            operator_alias_routes()
+                .route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth)),
+        )
+        .nest(
+            "/api_key",
+            api_key_routes().route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth)),
+        )
+        .nest(
+            "/media",
+            media_routes()
+                .route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth))
+                // images come base64 encoded, a third larger than the upload limit
+                .layer(DefaultBodyLimit::max(
+                    state.option_image_service.max_upload_bytes() / 3 * 4 + MEDIA_BODY_OVERHEAD,
+                )),
+        )
+        .nest(
+            "/webhook",
+            webhook_routes().route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth)),
+        )
+        .nest(
+            "/queue",
+            queue_routes().route_layer(from_fn_with_state(admin_guard.clone(), api_key_auth)),
+        )
+        .nest(
+            "/privacy",
+            privacy_routes().route_layer(from_fn_with_state(admin_guard, api_key_auth)),
+        )
+}
+
+fn with_results_guard(
+    state: &Arc<AppState>,
+    router: Router<Arc<AppState>>,
+) -> Router<Arc<AppState>> {
+    let anonymous = if state.api_key_service.config().require_for_results {
+        AnonymousAccess::Deny
+    } else {
+        AnonymousAccess::Allow
+    };
+    let results_guard = ApiKeyGuard {
+        state: state.clone(),
+        scope: ApiKeyScope::Results,
+        anonymous,
+    };
+
+    router.route_layer(from_fn_with_state(results_guard, api_key_auth))
 }
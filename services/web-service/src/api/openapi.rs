@@ -1,35 +1,125 @@
-use utoipa::OpenApi;
+use utoipa::{
+    Modify, OpenApi,
+    openapi::security::{ApiKey, ApiKeyValue, SecurityScheme},
+};
 
 use share::models::api::{
+    ApiKeyInfo, ApiKeyIssueRequest, ApiKeyIssueResponse, ApiKeyListResponse, ApiKeyRevokeRequest,
     ApiMsg, AuditTopicsListResponse, BallotCreateRequest, BallotCreateResponse, BallotSaveRequest,
-    BallotSaveResponse, Results1v1MatrixResponse, ResultsFinalOrderRequest,
-    ResultsFinalOrderResponse, TopicCreateRequest, TopicCreateResponse, TopicInfoRequest,
-    TopicInfoResponse, TopicListActiveResponse,
+    BallotSaveResponse, OperatorSearchItem, OperatorSearchRequest, OperatorSearchResponse,
+    Results1v1MatrixResponse, ResultsFinalOrderRequest, ResultsFinalOrderResponse,
+    TopicCreateRequest, TopicCreateResponse, TopicInfoRequest, TopicInfoResponse,
+    TopicListActiveResponse, TopicPresenceRequest, TopicPresenceResponse, WebhookCreateRequest,
+    WebhookCreateResponse, WebhookDeleteRequest, WebhookDeliveriesRequest,
+    WebhookDeliveriesResponse, WebhookInfo, WebhookListResponse,
 };
 
+use crate::middleware::api_key::KEY_NAME_HEADER;
+
 #[derive(OpenApi)]
 #[openapi(
     info(
         title = "Ark Vote Backend",
         description = "Backend for the Ark Vote application"
     ),
+    modifiers(&SecurityAddon),
     tags(
+        (name = "Announcement", description = "Site announcements and their management"),
+        (name = "ApiKey", description = "Third-party API key management endpoints"),
         (name = "Audit", description = "Topic audit related endpoints"),
         (name = "Ballot", description = "Voting ballot related endpoints"),
+        (name = "Bookmark", description = "Topics voters keep on a list, with reminders when voting opens"),
+        (name = "Embed", description = "Link previews of the topics for chat apps and social media"),
+        (name = "Feeds", description = "Feeds of the published results for aggregators"),
+        (name = "Media", description = "Uploaded media endpoints"),
+        (name = "Operator", description = "Operator lookup endpoints"),
+        (name = "Privacy", description = "Voter data erasure and export endpoints"),
+        (name = "Consent", description = "Terms voters accept before voting"),
+        (name = "Comment", description = "Comment threads under the topics"),
+        (name = "Report", description = "Reports of comments and topics, and their moderation queue"),
+        (name = "Queue", description = "Persistent task queue and scheduled action endpoints"),
         (name = "Results", description = "Voting results related endpoints"),
+        (name = "System", description = "Build and deployment info endpoints"),
         (name = "Topic", description = "Topic info related endpoints"),
+        (name = "Webhook", description = "Outgoing webhook management endpoints"),
     ),
     paths(
+        crate::api::announcement::announcement_current::announcement_current,
+        crate::api::announcement::announcement_list::announcement_list,
+        crate::api::announcement::announcement_create::announcement_create,
+        crate::api::announcement::announcement_update::announcement_update,
+        crate::api::announcement::announcement_delete::announcement_delete,
+        crate::api::api_key::api_key_issue::api_key_issue,
+        crate::api::api_key::api_key_list::api_key_list,
+        crate::api::api_key::api_key_revoke::api_key_revoke,
         crate::api::audit::audit_topic::audit_topic,
         crate::api::audit::audit_topics_list::audit_topics_list,
         crate::api::ballot::ballot_create::ballot_create,
         crate::api::ballot::ballot_save::ballot_save,
+        crate::api::bookmark::bookmark_list::bookmark_list,
+        crate::api::bookmark::bookmark_add::bookmark_add,
+        crate::api::bookmark::bookmark_confirm::bookmark_confirm,
+        crate::api::bookmark::bookmark_remove::bookmark_remove,
+        crate::api::embed::embed_topic::embed_topic,
+        crate::api::embed::embed_oembed::embed_oembed,
+        crate::api::feeds::feeds_results::feeds_results,
+        crate::api::media::option_image_delete::option_image_delete,
+        crate::api::media::option_image_upload::option_image_upload,
+        crate::api::operator::operator_alias_add::operator_alias_add,
+        crate::api::operator::operator_alias_list::operator_alias_list,
+        crate::api::operator::operator_alias_remove::operator_alias_remove,
+        crate::api::operator::operator_avatar::operator_avatar,
+        crate::api::operator::operator_search::operator_search,
+        crate::api::privacy::privacy_erasure::privacy_erasure,
+        crate::api::privacy::privacy_export::privacy_export,
+        crate::api::privacy::privacy_export_download::privacy_export_download,
+        crate::api::consent::consent_status::consent_status,
+        crate::api::consent::consent_accept::consent_accept,
+        crate::api::comment::comment_create::comment_create,
+        crate::api::comment::comment_list::comment_list,
+        crate::api::comment::comment_delete::comment_delete,
+        crate::api::comment::comment_moderate::comment_moderate,
+        crate::api::report::report_create::report_create,
+        crate::api::report::report_queue::report_queue,
+        crate::api::report::report_resolve::report_resolve,
+        crate::api::queue::queue_cancel::queue_cancel,
+        crate::api::queue::queue_dead::queue_dead,
+        crate::api::queue::queue_delete::queue_delete,
+        crate::api::queue::queue_retry::queue_retry,
+        crate::api::queue::queue_schedule::queue_schedule,
+        crate::api::queue::queue_scheduled::queue_scheduled,
+        crate::api::queue::queue_stats::queue_stats,
         crate::api::results::results_1v1_matrix::results_1v1_matrix,
+        crate::api::results::results_aggregates::results_aggregates,
+        crate::api::results::results_dataset::results_dataset,
+        crate::api::results::results_digest::results_digest,
         crate::api::results::results_final_order::results_final_order,
+        crate::api::results::results_heatmap::results_heatmap,
+        crate::api::results::results_history::results_history,
+        crate::api::results::results_merkle_proof::results_merkle_proof,
+        crate::api::results::results_merkle_root::results_merkle_root,
+        crate::api::results::results_share_card::results_share_card,
+        crate::api::results::results_trends::results_trends,
+        crate::api::me::me_topic_affinity::me_topic_affinity,
+        crate::api::system::system_config::system_config,
+        crate::api::system::system_audit_log_export::system_audit_log_export,
+        crate::api::system::system_jobs::system_jobs,
+        crate::api::system::system_maintenance::system_maintenance,
+        crate::api::system::system_maintenance::system_maintenance_set,
+        crate::api::system::system_retention::system_retention,
+        crate::api::system::system_regions::system_regions,
+        crate::api::system::system_slo::system_slo,
+        crate::api::system::system_version::system_version,
         crate::api::topic::topic_candidate_pool::topic_candidate_pool,
         crate::api::topic::topic_create::topic_create,
         crate::api::topic::topic_info::topic_info,
         crate::api::topic::topic_list_active::topic_list_active,
+        crate::api::topic::topic_presence::topic_presence,
+        crate::api::v2::results_final_order::results_final_order,
+        crate::api::webhook::webhook_create::webhook_create,
+        crate::api::webhook::webhook_delete::webhook_delete,
+        crate::api::webhook::webhook_deliveries::webhook_deliveries,
+        crate::api::webhook::webhook_list::webhook_list,
     ),
     components(schemas(
         TopicListActiveResponse,
@@ -37,6 +127,8 @@ use share::models::api::{
         TopicCreateResponse,
         TopicInfoRequest,
         TopicInfoResponse,
+        TopicPresenceRequest,
+        TopicPresenceResponse,
         BallotCreateRequest,
         BallotCreateResponse,
         Results1v1MatrixResponse,
@@ -45,7 +137,150 @@ use share::models::api::{
         ResultsFinalOrderRequest,
         ResultsFinalOrderResponse,
         AuditTopicsListResponse,
+        ApiKeyIssueRequest,
+        ApiKeyIssueResponse,
+        ApiKeyRevokeRequest,
+        ApiKeyInfo,
+        ApiKeyListResponse,
+        WebhookCreateRequest,
+        WebhookCreateResponse,
+        WebhookDeleteRequest,
+        WebhookInfo,
+        WebhookListResponse,
+        WebhookDeliveriesRequest,
+        WebhookDeliveriesResponse,
+        OperatorSearchRequest,
+        OperatorSearchItem,
+        OperatorSearchResponse,
+        share::models::api::OperatorAliasAddRequest,
+        share::models::api::OperatorAliasRemoveRequest,
+        share::models::api::OperatorAliasListRequest,
+        share::models::api::OperatorAliasListResponse,
+        share::models::database::OperatorAlias,
+        share::models::api::PrivacyErasureRequest,
+        share::models::api::PrivacyErasureResponse,
+        share::models::api::PrivacyExportRequest,
+        share::models::api::PrivacyExportResponse,
+        share::models::api::PrivacyExportDownloadRequest,
+        share::models::api::ConsentStatus,
+        share::models::api::ConsentAcceptRequest,
+        share::models::api::CommentView,
+        share::models::api::CommentCreateRequest,
+        share::models::api::CommentCreateResponse,
+        share::models::api::CommentListRequest,
+        share::models::api::CommentListResponse,
+        share::models::api::CommentDeleteRequest,
+        share::models::api::CommentModeration,
+        share::models::api::CommentModerateRequest,
+        share::models::database::CommentStatus,
+        share::models::api::ReportCreateRequest,
+        share::models::api::ContentReportView,
+        share::models::api::ReportQueueRequest,
+        share::models::api::ReportQueueResponse,
+        share::models::api::ReportResolution,
+        share::models::api::ReportResolveRequest,
+        share::models::database::ReportTargetKind,
+        share::models::database::ReportReason,
+        share::models::database::ReportStatus,
+        share::models::api::AnnouncementView,
+        share::models::api::AnnouncementListResponse,
+        share::models::api::AnnouncementCreateRequest,
+        share::models::api::AnnouncementUpdateRequest,
+        share::models::api::AnnouncementDeleteRequest,
+        share::models::api::AnnouncementAdminListResponse,
+        share::models::database::Announcement,
+        share::models::database::AnnouncementSeverity,
+        share::models::api::BookmarkView,
+        share::models::api::BookmarkListResponse,
+        share::models::api::BookmarkAddRequest,
+        share::models::api::BookmarkConfirmRequest,
+        share::models::api::BookmarkRemoveRequest,
+        share::models::api::OEmbedResponse,
+        share::models::api::QueueDeadRequest,
+        share::models::api::QueueDeadResponse,
+        share::models::api::QueueTaskRequest,
+        share::models::api::QueueScheduleRequest,
+        share::models::api::QueueScheduleResponse,
+        share::models::api::QueueScheduledRequest,
+        share::models::api::QueueScheduledResponse,
+        share::models::api::QueueKindStats,
+        share::models::api::QueueStatsResponse,
+        share::models::api::ScheduledAction,
+        share::models::database::QueuedTask,
+        share::models::database::QueuedTaskStatus,
+        share::models::database::TaskPriority,
+        share::models::api::ImageSize,
+        share::models::api::OptionImageUploadRequest,
+        share::models::api::OptionImageUploadResponse,
+        share::models::api::OptionImageDeleteRequest,
+        share::models::api::ResultsFilter,
+        share::models::api::ResultsGroupBy,
+        share::models::api::ResultsGroup,
+        share::models::api::ResultsAggregatesRequest,
+        share::models::api::ResultsAggregate,
+        share::models::api::ResultsAggregateMember,
+        share::build_info::BuildInfo,
+        share::models::api::SystemConfigResponse,
+        share::models::api::MaintenanceStatus,
+        share::models::api::MaintenanceSetRequest,
+        share::models::api::SystemJobsResponse,
+        share::jobs::JobInfo,
+        share::jobs::JobRun,
+        share::jobs::Stage,
+        share::models::api::RetentionReport,
+        share::models::api::RetentionPolicyReport,
+        share::models::api::SystemRegionsResponse,
+        share::models::api::RegionCount,
+        share::models::api::RegionLevel,
+        share::models::api::SystemSloResponse,
+        share::models::api::RouteSloReport,
+        share::models::api::ResultsAggregatesResponse,
+        share::models::api::ResultsDatasetRequest,
+        share::models::api::ResultsDatasetResponse,
+        share::models::api::AffinityResponse,
+        share::models::api::AffinityPick,
+        share::models::database::PublishedDataset,
+        share::models::api::ResultsDigestResponse,
+        share::models::database::DailyDigest,
+        share::models::database::DigestMover,
+        share::models::database::DigestMatchup,
+        share::models::api::ResultsHeatmapResponse,
+        share::models::api::ResultsTrendsResponse,
+        share::models::api::TrendUpset,
+        share::models::api::TrendMomentum,
+        share::models::api::ResultsHistoryResponse,
+        share::models::api::HistoryEntry,
+        share::models::api::ResultsMerkleRootRequest,
+        share::models::api::ResultsMerkleRootResponse,
+        share::models::api::ResultsMerkleProofRequest,
+        share::models::api::ResultsMerkleProofResponse,
+        share::models::api::MerkleProofStep,
+        share::models::database::MerkleCommitment,
+        share::merkle::Side,
+        share::models::excel::Language,
+        share::models::excel::OperatorNames,
+        share::models::api::v2::FinalOrderItem,
+        share::models::api::v2::ResultsFinalOrderResponse,
         ApiMsg
     ))
 )]
 pub struct ApiDoc;
+
+struct SecurityAddon;
+
+impl Modify for SecurityAddon {
+    fn modify(&self, openapi: &mut utoipa::openapi::OpenApi) {
+        let components = openapi.components.get_or_insert_with(Default::default);
+        components.add_security_scheme(
+            "api_key",
+            SecurityScheme::ApiKey(ApiKey::Header(ApiKeyValue::new("x-api-key"))),
+        );
+        components.add_security_scheme(
+            "signed_request",
+            SecurityScheme::ApiKey(ApiKey::Header(ApiKeyValue::with_description(
+                KEY_NAME_HEADER,
+                "Name of the key, together with the timestamp, nonce and signature headers",
+            ))),
+        );
+    }
+}
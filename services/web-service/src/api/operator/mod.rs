@@ -0,0 +1,34 @@
+use std::sync::Arc;
+
+use axum::{
+    Router,
+    routing::{get, post},
+};
+
+use crate::state::AppState;
+
+pub mod operator_alias_add;
+pub mod operator_alias_list;
+pub mod operator_alias_remove;
+pub mod operator_avatar;
+pub mod operator_search;
+
+use operator_alias_add::operator_alias_add;
+use operator_alias_list::operator_alias_list;
+use operator_alias_remove::operator_alias_remove;
+use operator_avatar::operator_avatar;
+use operator_search::operator_search;
+
+pub fn operator_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/search", post(operator_search)) // 按名称或拼音搜索干员
+        .route("/{id}/avatar", get(operator_avatar)) // 代理并缓存干员头像
+}
+
+/// Alias maintenance, nested under `/operator/alias` behind the admin guard.
+pub fn operator_alias_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/add", post(operator_alias_add)) // 添加干员别名
+        .route("/remove", post(operator_alias_remove)) // 删除干员别名
+        .route("/list", post(operator_alias_list)) // 列出干员别名
+}
@@ -0,0 +1,61 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, OperatorAliasAddRequest},
+    database::{ApiKey, OperatorAlias},
+    excel::normalize_name,
+};
+
+use crate::{AppState, error::AppError, service::AliasAdded};
+
+#[utoipa::path(
+    post,
+    path = "/operator/alias/add",
+    request_body = OperatorAliasAddRequest,
+    responses(
+        (status = 200, description = "Alias added", body = ApiResponse<OperatorAlias>),
+        (status = 400, description = "Alias is empty or refers to another operator", body = ApiResponse<String>),
+        (status = 404, description = "Operator not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Operator",
+    operation_id = "operatorAliasAdd"
+)]
+#[axum::debug_handler]
+pub async fn operator_alias_add(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<OperatorAliasAddRequest>,
+) -> Result<Json<ApiResponse<OperatorAlias>>, AppError> {
+    if normalize_name(&req.alias).is_empty() {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::Error("alias must not be empty".to_string()),
+        }));
+    }
+
+    match state
+        .operator_service
+        .add_alias(req.operator_id, &req.alias, &key.name)
+        .await?
+    {
+        AliasAdded::Added(alias) => Ok(Json(ApiResponse {
+            status: 0,
+            data: ApiData::Data(alias),
+            message: ApiMsg::OK,
+        })),
+        AliasAdded::UnknownOperator => Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::OperatorNotFound,
+        })),
+        AliasAdded::Conflict(other) => Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::OperatorAliasConflict(other),
+        })),
+    }
+}
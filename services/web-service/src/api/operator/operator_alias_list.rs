@@ -0,0 +1,34 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, OperatorAliasListRequest, OperatorAliasListResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/operator/alias/list",
+    request_body = OperatorAliasListRequest,
+    responses(
+        (status = 200, description = "Aliases ordered by operator", body = ApiResponse<OperatorAliasListResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Operator",
+    operation_id = "operatorAliasList"
+)]
+#[axum::debug_handler]
+pub async fn operator_alias_list(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<OperatorAliasListRequest>,
+) -> Result<Json<ApiResponse<OperatorAliasListResponse>>, AppError> {
+    let aliases = state.operator_service.list_aliases(req.operator_id).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(OperatorAliasListResponse { aliases }),
+        message: ApiMsg::OK,
+    }))
+}
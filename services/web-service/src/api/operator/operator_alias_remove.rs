@@ -0,0 +1,44 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, OperatorAliasRemoveRequest};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/operator/alias/remove",
+    request_body = OperatorAliasRemoveRequest,
+    responses(
+        (status = 200, description = "Alias removed", body = ApiResponse<String>),
+        (status = 404, description = "Alias not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Operator",
+    operation_id = "operatorAliasRemove"
+)]
+#[axum::debug_handler]
+pub async fn operator_alias_remove(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<OperatorAliasRemoveRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    if state
+        .operator_service
+        .remove_alias(&req.alias)
+        .await?
+        .is_none()
+    {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::OperatorAliasNotFound,
+        }));
+    }
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Empty,
+        message: ApiMsg::OK,
+    }))
+}
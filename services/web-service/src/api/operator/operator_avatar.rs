@@ -0,0 +1,80 @@
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, Query, State},
+    http::{HeaderMap, StatusCode, header},
+    response::{IntoResponse as _, Response},
+};
+use sha2::{Digest as _, Sha256};
+use share::models::api::OperatorAvatarQuery;
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/operator/{id}/avatar",
+    params(
+        ("id" = i32, Path, description = "Operator id"),
+        OperatorAvatarQuery
+    ),
+    responses(
+        (status = 200, description = "Operator avatar image", content_type = "image/png"),
+        (status = 304, description = "Image not modified"),
+        (status = 404, description = "Operator or variant not found"),
+        (status = 502, description = "Upstream image unavailable")
+    ),
+    tag = "Operator",
+    operation_id = "operatorAvatar"
+)]
+#[axum::debug_handler]
+pub async fn operator_avatar(
+    State(state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Query(query): Query<OperatorAvatarQuery>,
+    headers: HeaderMap,
+) -> Result<Response, AppError> {
+    let Some(url) = state
+        .character_portraits
+        .get(&id)
+        .and_then(|portrait| portrait.avatar.get(query.variant))
+    else {
+        return Ok(StatusCode::NOT_FOUND.into_response());
+    };
+
+    let key = format!("{id}_{}", query.variant);
+    let image = match state.image_proxy_service.get(&key, url, query.size).await {
+        Ok(image) => image,
+        Err(e) => {
+            tracing::warn!("failed to proxy avatar {}: {}", key, e);
+            return Ok(StatusCode::BAD_GATEWAY.into_response());
+        }
+    };
+
+    let etag = format!("\"{}\"", hex::encode(&Sha256::digest(&image.bytes)[..16]));
+    let cache_control = format!(
+        "public, max-age={}, immutable",
+        state.image_proxy_service.max_age_secs()
+    );
+
+    let not_modified = headers
+        .get(header::IF_NONE_MATCH)
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| value.split(',').any(|tag| tag.trim() == etag));
+    if not_modified {
+        return Ok((
+            StatusCode::NOT_MODIFIED,
+            [(header::ETAG, etag), (header::CACHE_CONTROL, cache_control)],
+        )
+            .into_response());
+    }
+
+    Ok((
+        [
+            (header::CONTENT_TYPE, image.content_type.to_string()),
+            (header::ETAG, etag),
+            (header::CACHE_CONTROL, cache_control),
+        ],
+        image.bytes,
+    )
+        .into_response())
+}
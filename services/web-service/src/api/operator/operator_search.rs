@@ -0,0 +1,48 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, OperatorSearchItem, OperatorSearchRequest, OperatorSearchResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/operator/search",
+    request_body = OperatorSearchRequest,
+    responses(
+        (status = 200, description = "Search operators by CN, EN or JP name, pinyin or alias", body = ApiResponse<OperatorSearchResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Operator",
+    operation_id = "operatorSearch"
+)]
+#[axum::debug_handler]
+pub async fn operator_search(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<OperatorSearchRequest>,
+) -> Result<Json<ApiResponse<OperatorSearchResponse>>, AppError> {
+    let operators = state
+        .operator_service
+        .search(&req.query, req.limit)
+        .into_iter()
+        .map(|(id, names)| OperatorSearchItem {
+            id,
+            name: names.localized(req.lang).to_string(),
+            avatar: state
+                .character_portraits
+                .get(&id)
+                .map(|portrait| portrait.avatar.clone())
+                .unwrap_or_default(),
+            aliases: state.operator_service.aliases_of(id),
+            names,
+        })
+        .collect();
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(OperatorSearchResponse { operators }),
+        message: ApiMsg::OK,
+    }))
+}
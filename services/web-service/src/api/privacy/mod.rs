@@ -0,0 +1,20 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+
+use crate::state::AppState;
+
+pub mod privacy_erasure;
+pub mod privacy_export;
+pub mod privacy_export_download;
+
+use privacy_erasure::privacy_erasure;
+use privacy_export::privacy_export;
+use privacy_export_download::privacy_export_download;
+
+pub fn privacy_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/erasure", post(privacy_erasure))
+        .route("/export", post(privacy_export))
+        .route("/export/download", post(privacy_export_download))
+}
@@ -0,0 +1,49 @@
+use std::{net::IpAddr, sync::Arc};
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, PrivacyErasureRequest, PrivacyErasureResponse},
+    database::ApiKey,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/privacy/erasure",
+    request_body = PrivacyErasureRequest,
+    responses(
+        (status = 200, description = "Erasure queued to run after privacy.erasure_grace_hours", body = ApiResponse<PrivacyErasureResponse>),
+        (status = 400, description = "Not an ip address", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Privacy",
+    operation_id = "privacyErasure"
+)]
+#[axum::debug_handler]
+pub async fn privacy_erasure(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<PrivacyErasureRequest>,
+) -> Result<Json<ApiResponse<PrivacyErasureResponse>>, AppError> {
+    // ballots store the canonical form the extractor produced
+    let Ok(ip) = req.ip.trim().parse::<IpAddr>() else {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::InvalidIpAddress,
+        }));
+    };
+
+    let (id, run_at) = state
+        .erasure_service
+        .request(&ip.to_canonical().to_string(), &key.name)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(PrivacyErasureResponse { id, run_at }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,49 @@
+use std::{net::IpAddr, sync::Arc};
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, PrivacyExportRequest, PrivacyExportResponse},
+    database::ApiKey,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/privacy/export",
+    request_body = PrivacyExportRequest,
+    responses(
+        (status = 200, description = "Export of the ballots cast from the address queued", body = ApiResponse<PrivacyExportResponse>),
+        (status = 400, description = "Not an ip address", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Privacy",
+    operation_id = "privacyExport"
+)]
+#[axum::debug_handler]
+pub async fn privacy_export(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<PrivacyExportRequest>,
+) -> Result<Json<ApiResponse<PrivacyExportResponse>>, AppError> {
+    // ballots store the canonical form the extractor produced
+    let Ok(ip) = req.ip.trim().parse::<IpAddr>() else {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::InvalidIpAddress,
+        }));
+    };
+
+    let id = state
+        .voter_export_service
+        .request(&ip.to_canonical().to_string(), &key.name)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(PrivacyExportResponse { id }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,58 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::State,
+    http::header,
+    response::{IntoResponse as _, Response},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, PrivacyExportDownloadRequest};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/privacy/export/download",
+    request_body = PrivacyExportDownloadRequest,
+    responses(
+        (status = 200, description = "The ballots cast from the address as a json archive", content_type = "application/json"),
+        (status = 400, description = "Export is still being assembled", body = ApiResponse<String>),
+        (status = 404, description = "Export not found or expired", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Privacy",
+    operation_id = "privacyExportDownload"
+)]
+#[axum::debug_handler]
+pub async fn privacy_export_download(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<PrivacyExportDownloadRequest>,
+) -> Result<Response, AppError> {
+    let Some(export) = state.voter_export_service.get(&req.id).await? else {
+        return Ok(ApiResponse::<()> {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::VoterExportNotFound,
+        }
+        .into_response());
+    };
+    let Some(archive) = export.archive else {
+        return Ok(ApiResponse::<()> {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::VoterExportPending,
+        }
+        .into_response());
+    };
+
+    let filename = format!("attachment; filename=\"voter_export_{}.json\"", export.id);
+    Ok((
+        [
+            (header::CONTENT_TYPE, "application/json".to_string()),
+            (header::CONTENT_DISPOSITION, filename),
+        ],
+        archive,
+    )
+        .into_response())
+}
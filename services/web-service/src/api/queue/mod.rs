@@ -0,0 +1,35 @@
+use std::sync::Arc;
+
+use axum::{
+    Router,
+    routing::{get, post},
+};
+
+use crate::state::AppState;
+
+pub mod queue_cancel;
+pub mod queue_dead;
+pub mod queue_delete;
+pub mod queue_retry;
+pub mod queue_schedule;
+pub mod queue_scheduled;
+pub mod queue_stats;
+
+use queue_cancel::queue_cancel;
+use queue_dead::queue_dead;
+use queue_delete::queue_delete;
+use queue_retry::queue_retry;
+use queue_schedule::queue_schedule;
+use queue_scheduled::queue_scheduled;
+use queue_stats::queue_stats;
+
+pub fn queue_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/dead", post(queue_dead))
+        .route("/retry", post(queue_retry))
+        .route("/delete", post(queue_delete))
+        .route("/schedule", post(queue_schedule))
+        .route("/scheduled", post(queue_scheduled))
+        .route("/cancel", post(queue_cancel))
+        .route("/stats", get(queue_stats))
+}
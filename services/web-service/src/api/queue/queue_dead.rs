@@ -0,0 +1,35 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, QueueDeadRequest, QueueDeadResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/queue/dead",
+    request_body = QueueDeadRequest,
+    responses(
+        (status = 200, description = "Tasks which ran out of attempts, newest first", body = ApiResponse<QueueDeadResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Queue",
+    operation_id = "queueDead"
+)]
+#[axum::debug_handler]
+pub async fn queue_dead(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<QueueDeadRequest>,
+) -> Result<Json<ApiResponse<QueueDeadResponse>>, AppError> {
+    let tasks = state
+        .task_queue
+        .dead(req.kind.as_deref(), req.limit)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(QueueDeadResponse { tasks }),
+        message: ApiMsg::OK,
+    }))
+}
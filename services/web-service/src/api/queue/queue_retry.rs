@@ -0,0 +1,39 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, QueueTaskRequest};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/queue/retry",
+    request_body = QueueTaskRequest,
+    responses(
+        (status = 200, description = "Dead task queued again with fresh attempts", body = ApiResponse<String>),
+        (status = 404, description = "Dead task not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Queue",
+    operation_id = "queueRetry"
+)]
+#[axum::debug_handler]
+pub async fn queue_retry(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<QueueTaskRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    if !state.task_queue.retry(&req.id).await? {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::QueuedTaskNotFound,
+        }));
+    }
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Empty,
+        message: ApiMsg::OK,
+    }))
+}
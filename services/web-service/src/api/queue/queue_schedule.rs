@@ -0,0 +1,37 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, QueueScheduleRequest, QueueScheduleResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/queue/schedule",
+    request_body = QueueScheduleRequest,
+    responses(
+        (status = 200, description = "Action queued to run once at run_at", body = ApiResponse<QueueScheduleResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Queue",
+    operation_id = "queueSchedule"
+)]
+#[axum::debug_handler]
+pub async fn queue_schedule(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<QueueScheduleRequest>,
+) -> Result<Json<ApiResponse<QueueScheduleResponse>>, AppError> {
+    let id = state
+        .scheduled_actions
+        .schedule(req.run_at, &req.action)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(QueueScheduleResponse { id }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,34 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, QueueScheduledRequest, QueueScheduledResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/queue/scheduled",
+    request_body = QueueScheduledRequest,
+    responses(
+        (status = 200, description = "Scheduled actions not run yet, the next due first", body = ApiResponse<QueueScheduledResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Queue",
+    operation_id = "queueScheduled"
+)]
+#[axum::debug_handler]
+pub async fn queue_scheduled(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<QueueScheduledRequest>,
+) -> Result<Json<ApiResponse<QueueScheduledResponse>>, AppError> {
+    let tasks = state.scheduled_actions.list(req.limit).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(QueueScheduledResponse { tasks }),
+        message: ApiMsg::OK,
+    }))
+}
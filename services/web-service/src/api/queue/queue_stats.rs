@@ -0,0 +1,30 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, QueueStatsResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/queue/stats",
+    responses(
+        (status = 200, description = "Depth, retries and run durations per task kind", body = ApiResponse<QueueStatsResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Queue",
+    operation_id = "queueStats"
+)]
+#[axum::debug_handler]
+pub async fn queue_stats(
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<QueueStatsResponse>>, AppError> {
+    let kinds = state.task_queue.stats().await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(QueueStatsResponse { kinds }),
+        message: ApiMsg::OK,
+    }))
+}
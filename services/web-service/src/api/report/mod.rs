@@ -0,0 +1,23 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+
+use crate::state::AppState;
+
+pub mod report_create;
+pub mod report_queue;
+pub mod report_resolve;
+
+use report_create::report_create;
+use report_queue::report_queue;
+use report_resolve::report_resolve;
+
+pub fn report_routes() -> Router<Arc<AppState>> {
+    Router::new().route("/create", post(report_create))
+}
+
+pub fn report_moderation_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/queue", post(report_queue))
+        .route("/resolve", post(report_resolve))
+}
@@ -0,0 +1,50 @@
+use std::{net::SocketAddr, sync::Arc};
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, ReportCreateRequest};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/report/create",
+    request_body = ReportCreateRequest,
+    responses(
+        (status = 200, description = "Report merged into the moderation queue", body = ApiResponse<String>),
+        (status = 400, description = "Detail too long", body = ApiResponse<String>),
+        (status = 404, description = "Reported comment or topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Report",
+    operation_id = "reportCreate"
+)]
+#[axum::debug_handler]
+pub async fn report_create(
+    ConnectInfo(addr): ConnectInfo<SocketAddr>,
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ReportCreateRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    let ip = addr.ip().to_canonical().to_string();
+    let reported = state.report_service.report(&ip, &req).await?;
+
+    Ok(Json(match reported {
+        Ok(()) => ApiResponse {
+            status: 0,
+            data: ApiData::Empty,
+            message: ApiMsg::OK,
+        },
+        Err(message @ ApiMsg::ReportDetailTooLong(_)) => ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message,
+        },
+        Err(message) => ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
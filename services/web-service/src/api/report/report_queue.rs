@@ -0,0 +1,43 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, ReportQueueRequest, ReportQueueResponse},
+    database::ReportStatus,
+};
+
+use crate::{AppState, error::AppError};
+
+const DEFAULT_LIMIT: i64 = 50;
+
+#[utoipa::path(
+    post,
+    path = "/report/queue",
+    request_body = ReportQueueRequest,
+    responses(
+        (status = 200, description = "Reports of one status, most reported first", body = ApiResponse<ReportQueueResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Report",
+    operation_id = "reportQueue"
+)]
+#[axum::debug_handler]
+pub async fn report_queue(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ReportQueueRequest>,
+) -> Result<Json<ApiResponse<ReportQueueResponse>>, AppError> {
+    let reports = state
+        .report_service
+        .queue(
+            req.status.unwrap_or(ReportStatus::Open),
+            req.limit.unwrap_or(DEFAULT_LIMIT),
+        )
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ReportQueueResponse { reports }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,53 @@
+use std::sync::Arc;
+
+use axum::{Extension, Json, extract::State};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, ContentReportView, ReportResolveRequest},
+    database::ApiKey,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/report/resolve",
+    request_body = ReportResolveRequest,
+    responses(
+        (status = 200, description = "Open report of the content closed", body = ApiResponse<ContentReportView>),
+        (status = 404, description = "No open report of the content", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Report",
+    operation_id = "reportResolve"
+)]
+#[axum::debug_handler]
+pub async fn report_resolve(
+    State(state): State<Arc<AppState>>,
+    Extension(key): Extension<ApiKey>,
+    Json(req): Json<ReportResolveRequest>,
+) -> Result<Json<ApiResponse<ContentReportView>>, AppError> {
+    let resolved = state
+        .report_service
+        .resolve(
+            req.target_kind,
+            &req.target_id,
+            req.resolution,
+            &req.note,
+            &key.name,
+        )
+        .await?;
+
+    Ok(Json(match resolved {
+        Ok(report) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(report),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
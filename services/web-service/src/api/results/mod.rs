@@ -1,17 +1,47 @@
 use std::sync::Arc;
 
-use axum::{Router, routing::post};
+use axum::{
+    Router,
+    routing::{get, post},
+};
 
 use crate::state::AppState;
 
 pub mod results_1v1_matrix;
+pub mod results_aggregates;
+pub mod results_dataset;
+pub mod results_digest;
 pub mod results_final_order;
+pub mod results_heatmap;
+pub mod results_history;
+pub mod results_merkle_proof;
+pub mod results_merkle_root;
+pub mod results_share_card;
+pub mod results_trends;
 
 use results_1v1_matrix::results_1v1_matrix;
+use results_aggregates::results_aggregates;
+use results_dataset::results_dataset;
+use results_digest::results_digest;
 use results_final_order::results_final_order;
+use results_heatmap::results_heatmap;
+use results_history::results_history;
+use results_merkle_proof::results_merkle_proof;
+use results_merkle_root::results_merkle_root;
+use results_share_card::results_share_card;
+use results_trends::results_trends;
 
 pub fn results_routes() -> Router<Arc<AppState>> {
     Router::new()
         .route("/1v1_matrix", post(results_1v1_matrix))
+        .route("/aggregates", post(results_aggregates))
+        .route("/dataset", post(results_dataset))
+        .route("/digest", get(results_digest))
         .route("/final_order", post(results_final_order))
+        .route("/heatmap", get(results_heatmap))
+        .route("/history", get(results_history))
+        .route("/merkle/root", post(results_merkle_root))
+        .route("/merkle/proof", post(results_merkle_proof))
+        .route("/share_card", get(results_share_card))
+        .route("/trends", get(results_trends))
 }
@@ -1,10 +1,12 @@
 use std::{collections::HashMap, sync::Arc};
 
 use axum::{Json, extract::State};
-use redis::AsyncCommands;
-use share::models::api::{
-    ApiData, ApiMsg, ApiResponse, Results1v1MatrixItem, Results1v1MatrixRequest,
-    Results1v1MatrixResponse,
+use share::models::{
+    api::{
+        ApiData, ApiMsg, ApiResponse, Results1v1MatrixItem, Results1v1MatrixRequest,
+        Results1v1MatrixResponse, ResultsGroupBy,
+    },
+    excel::CharacterInfo,
 };
 
 use crate::{AppState, error::AppError};
@@ -44,25 +46,78 @@ pub async fn results_1v1_matrix(
         }
     };
 
-    let mut conn = state.redis.connection.clone();
+    let snapshot = state.results_snapshots.get(&target_topic.id).await?;
+    let data = &snapshot.matrix;
 
-    let target_key = format!("{}:op_matrix", target_topic.id);
-    let data: HashMap<String, i64> = conn.hgetall(target_key).await?;
-
-    let mut rsp = HashMap::new();
-    for (key, value) in data {
-        rsp.insert(
-            key,
-            Results1v1MatrixItem {
-                score: value,
-                count: 1,
-            },
-        );
+    if req.filter.is_none() && req.group_by.is_none() {
+        let rsp = data
+            .iter()
+            .map(|(key, &score)| (key.clone(), Results1v1MatrixItem { score, count: 1 }))
+            .collect();
+        return Ok(Json(ApiResponse {
+            status: 0,
+            data: ApiData::Data(Results1v1MatrixResponse(rsp)),
+            message: ApiMsg::OK,
+        }));
     }
 
+    let character_infos = state.operator_service.character_infos();
+    let infos_by_id: HashMap<i32, &CharacterInfo> = character_infos
+        .iter()
+        .filter(|info| {
+            req.filter
+                .as_ref()
+                .is_none_or(|filter| filter.matches(info))
+        })
+        .map(|info| (info.id, info))
+        .collect();
+
+    let rsp = aggregate_matrix(data, &infos_by_id, req.group_by);
+
     Ok(Json(ApiResponse {
         status: 0,
         data: ApiData::Data(Results1v1MatrixResponse(rsp)),
         message: ApiMsg::OK,
     }))
 }
+
+/// Sums the matchups between the operators of `infos_by_id`, or between their
+/// groups.
+pub(crate) fn aggregate_matrix(
+    matrix: &HashMap<String, i64>,
+    infos_by_id: &HashMap<i32, &CharacterInfo>,
+    group_by: Option<ResultsGroupBy>,
+) -> HashMap<String, Results1v1MatrixItem> {
+    let mut rsp: HashMap<String, Results1v1MatrixItem> = HashMap::new();
+    for (key, &score) in matrix {
+        let Some((winner, loser)) = key
+            .split_once(':')
+            .and_then(|(w, l)| Some((w.parse::<i32>().ok()?, l.parse::<i32>().ok()?)))
+        else {
+            continue;
+        };
+        let (Some(winner), Some(loser)) = (infos_by_id.get(&winner), infos_by_id.get(&loser))
+        else {
+            continue;
+        };
+
+        let key = match group_by {
+            Some(group_by) => {
+                let (winner, loser) = (group_by.key_of(winner), group_by.key_of(loser));
+                // matchups inside a group cancel out
+                if winner == loser {
+                    continue;
+                }
+                format!("{winner}:{loser}")
+            }
+            None => key.clone(),
+        };
+        let item = rsp
+            .entry(key)
+            .or_insert(Results1v1MatrixItem { score: 0, count: 0 });
+        item.score += score;
+        item.count += 1;
+    }
+
+    rsp
+}
@@ -0,0 +1,93 @@
+use std::{collections::HashMap, sync::Arc};
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsAggregate, ResultsAggregateMember,
+    ResultsAggregatesRequest, ResultsAggregatesResponse,
+};
+
+use crate::{
+    AppState,
+    api::results::results_final_order::{FinalOrderOptions, OperatorResult, compute_final_order},
+    error::AppError,
+};
+
+#[utoipa::path(
+    post,
+    path = "/results/aggregates",
+    request_body = ResultsAggregatesRequest,
+    responses(
+        (status = 200, description = "Get win rates aggregated per rarity, class or faction", body = ApiResponse<ResultsAggregatesResponse>),
+        (status = 400, description = "Bad request", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsAggregates"
+)]
+#[axum::debug_handler]
+pub async fn results_aggregates(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ResultsAggregatesRequest>,
+) -> Result<Json<ApiResponse<ResultsAggregatesResponse>>, AppError> {
+    let options = FinalOrderOptions {
+        lang: req.lang,
+        filter: req.filter,
+        group_by: Some(req.group_by),
+    };
+    let final_order = match compute_final_order(&state, req.topic_id, options).await? {
+        Ok(final_order) => final_order,
+        Err(rejection) => return Ok(rejection.into_response()),
+    };
+
+    let items: HashMap<i32, &OperatorResult> =
+        final_order.items.iter().map(|r| (r.id, r)).collect();
+    let mut groups: Vec<ResultsAggregate> = final_order
+        .groups
+        .iter()
+        .flatten()
+        .filter_map(|group| {
+            // members are in final order, best first
+            let members: Vec<&OperatorResult> = group
+                .operator_ids
+                .iter()
+                .filter_map(|id| items.get(id).copied())
+                .collect();
+            let (best, worst) = (members.first()?, members.last()?);
+
+            let total = group.win + group.lose;
+            Some(ResultsAggregate {
+                key: group.key.clone(),
+                operators: members.len(),
+                win: group.win,
+                lose: group.lose,
+                average_rate: members.iter().map(|r| r.rate).sum::<f64>() / members.len() as f64,
+                overall_rate: match total {
+                    t if t > 0 => group.win as f64 * 100.0 / t as f64,
+                    _ => 0.0,
+                },
+                best: member(best),
+                worst: member(worst),
+            })
+        })
+        .collect();
+    groups.sort_by(|a, b| b.average_rate.total_cmp(&a.average_rate));
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ResultsAggregatesResponse {
+            topic_id: final_order.topic_id,
+            group_by: req.group_by,
+            groups,
+            count: final_order.count,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
+
+fn member(result: &OperatorResult) -> ResultsAggregateMember {
+    ResultsAggregateMember {
+        id: result.id,
+        name: result.name.clone(),
+        rate: result.rate,
+    }
+}
@@ -0,0 +1,43 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsDatasetRequest, ResultsDatasetResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/results/dataset",
+    request_body = ResultsDatasetRequest,
+    responses(
+        (status = 200, description = "Url of the anonymized ballots of a closed pairwise topic", body = ApiResponse<ResultsDatasetResponse>),
+        (status = 404, description = "No dataset is published for the topic", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsDataset"
+)]
+#[axum::debug_handler]
+pub async fn results_dataset(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ResultsDatasetRequest>,
+) -> Result<Json<ApiResponse<ResultsDatasetResponse>>, AppError> {
+    let Some(dataset) = state.dataset_service.get(&req.topic_id).await? else {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::DatasetNotFound,
+        }));
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ResultsDatasetResponse {
+            url: state.dataset_service.url(&dataset),
+            dataset,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
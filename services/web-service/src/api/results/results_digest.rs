@@ -0,0 +1,37 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, ResultsDigestQuery, ResultsDigestResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/results/digest",
+    params(ResultsDigestQuery),
+    responses(
+        (status = 200, description = "The daily digests of one UTC day, the latest compiled one by default", body = ApiResponse<ResultsDigestResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsDigest"
+)]
+#[axum::debug_handler]
+pub async fn results_digest(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<ResultsDigestQuery>,
+) -> Result<Json<ApiResponse<ResultsDigestResponse>>, AppError> {
+    let (date, digests) = state
+        .digest_service
+        .list(query.topic_id.as_deref(), query.date)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ResultsDigestResponse { date, digests }),
+        message: ApiMsg::OK,
+    }))
+}
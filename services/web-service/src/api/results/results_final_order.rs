@@ -3,23 +3,69 @@ use std::{collections::HashMap, sync::Arc};
 use axum::{Json, extract::State};
 use share::models::{
     api::{
-        ApiData, ApiMsg, ApiResponse, FinalOrderItem, ResultsFinalOrderRequest,
-        ResultsFinalOrderResponse,
+        ApiData, ApiMsg, ApiResponse, FinalOrderItem, ResultsFilter, ResultsFinalOrderRequest,
+        ResultsFinalOrderResponse, ResultsGroup, ResultsGroupBy,
     },
-    excel::CharacterInfo,
+    excel::{CharacterInfo, Language},
 };
 
-use crate::{AppState, error::AppError};
+use crate::{AppState, error::AppError, service::ResultsSnapshot};
 
 #[derive(Debug)]
-struct OperatorResult {
-    id: i32,
-    win: i64,
-    lose: i64,
-
-    name: String,
-    score: f64,
-    rate: f64,
+pub(crate) struct OperatorResult {
+    pub id: i32,
+    pub win: i64,
+    pub lose: i64,
+
+    pub name: String,
+    pub score: f64,
+    pub rate: f64,
+}
+
+/// Ranked results of a topic, shared by every api version which maps it to
+/// its own response shape.
+pub(crate) struct FinalOrder {
+    pub topic_id: String,
+    pub items: Vec<OperatorResult>,
+    pub count: i64,
+    pub groups: Option<Vec<ResultsGroup>>,
+}
+
+#[derive(Default)]
+pub(crate) struct FinalOrderOptions {
+    pub lang: Language,
+    pub filter: Option<ResultsFilter>,
+    pub group_by: Option<ResultsGroupBy>,
+}
+
+impl From<ResultsFinalOrderRequest> for FinalOrderOptions {
+    fn from(req: ResultsFinalOrderRequest) -> Self {
+        Self {
+            lang: req.lang,
+            filter: req.filter,
+            group_by: req.group_by,
+        }
+    }
+}
+
+/// A non-exceptional failure which is reported through `ApiResponse`.
+pub(crate) struct ResultsRejection {
+    pub status: i32,
+    pub message: ApiMsg,
+}
+
+impl ResultsRejection {
+    fn new(status: i32, message: ApiMsg) -> Self {
+        Self { status, message }
+    }
+
+    pub fn into_response<T>(self) -> Json<ApiResponse<T>> {
+        Json(ApiResponse {
+            status: self.status,
+            data: ApiData::Empty,
+            message: self.message,
+        })
+    }
 }
 
 impl OperatorResult {
@@ -47,7 +93,6 @@ struct OperatorsInfo {
     operator_ids: Vec<i32>,
     reverse_operators_id_dict: HashMap<i32, String>,
     num_operators: usize,
-    op_stats_all_fields: Vec<String>,
 }
 
 fn generate_operators_info(
@@ -65,21 +110,11 @@ fn generate_operators_info(
                 .or_else(|| Some((id, format!("Unknown Operator {}", id))))
         })
         .collect();
-    let win_fields: Vec<String> = operator_ids
-        .iter()
-        .map(|oid| format!("{oid}:win"))
-        .collect();
-    let lose_fields: Vec<String> = operator_ids
-        .iter()
-        .map(|oid| format!("{oid}:lose"))
-        .collect();
-    let op_stats_all_fields = [&win_fields[..], &lose_fields[..]].concat();
 
     OperatorsInfo {
         operator_ids: operator_ids.to_vec(),
         num_operators,
         reverse_operators_id_dict,
-        op_stats_all_fields,
     }
 }
 
@@ -100,126 +135,174 @@ pub async fn results_final_order(
     State(state): State<Arc<AppState>>,
     Json(req): Json<ResultsFinalOrderRequest>,
 ) -> Result<Json<ApiResponse<ResultsFinalOrderResponse>>, AppError> {
-    let target_topic = match state.topic_service.get_topic(&req.topic_id).await {
+    let topic_id = req.topic_id.clone();
+    let final_order = match compute_final_order(&state, topic_id, req.into()).await? {
+        Ok(final_order) => final_order,
+        Err(rejection) => return Ok(rejection.into_response()),
+    };
+
+    let response = ResultsFinalOrderResponse {
+        topic_id: final_order.topic_id,
+        items: final_order
+            .items
+            .into_iter()
+            .map(|r| FinalOrderItem {
+                name: r.name,
+                id: r.id,
+                win: r.win,
+                lose: r.lose,
+                score: format!("{:.2}", r.score),
+                rate: format!("{:.1}%", r.rate),
+            })
+            .collect(),
+        count: final_order.count,
+        groups: final_order.groups,
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(response),
+        message: ApiMsg::OK,
+    }))
+}
+
+pub(crate) async fn compute_final_order(
+    state: &AppState,
+    topic_id: String,
+    options: FinalOrderOptions,
+) -> Result<Result<FinalOrder, ResultsRejection>, AppError> {
+    let target_topic = match state.topic_service.get_topic(&topic_id).await {
         Ok(Some(topic)) if topic.topic_type.supports_final_order() => topic,
         Ok(_) => {
-            tracing::debug!("Topic {} does not support final order", req.topic_id);
-            return Ok(Json(ApiResponse {
-                status: 500,
-                data: ApiData::Empty,
-                message: ApiMsg::CurTopicNotSupportFinalOrder,
-            }));
+            tracing::debug!("Topic {} does not support final order", topic_id);
+            return Ok(Err(ResultsRejection::new(
+                500,
+                ApiMsg::CurTopicNotSupportFinalOrder,
+            )));
         }
         Err(_) => {
-            tracing::debug!("Topic {} not found", req.topic_id);
-            return Ok(Json(ApiResponse {
-                status: 404,
-                data: ApiData::Empty,
-                message: ApiMsg::TargetTopicNotFound,
-            }));
+            tracing::debug!("Topic {} not found", topic_id);
+            return Ok(Err(ResultsRejection::new(404, ApiMsg::TargetTopicNotFound)));
         }
     };
 
+    let character_infos = state.operator_service.character_infos();
     let candidate_pool = match state
         .topic_service
-        .get_candidate_pool(&target_topic.id, &state.character_infos)
+        .get_candidate_pool(&target_topic.id, &character_infos)
         .await
     {
         Some(pool) => pool,
         None => {
-            return Ok(Json(ApiResponse {
-                status: 404,
-                data: ApiData::Empty,
-                message: ApiMsg::TargetTopicNotFound,
-            }));
+            return Ok(Err(ResultsRejection::new(404, ApiMsg::TargetTopicNotFound)));
         }
     };
-    let operators_info = generate_operators_info(&candidate_pool, &state.character_infos);
+    let infos_by_id: HashMap<i32, &CharacterInfo> =
+        character_infos.iter().map(|info| (info.id, info)).collect();
+    let candidate_pool: Vec<i32> = match &options.filter {
+        Some(filter) => candidate_pool
+            .into_iter()
+            .filter(|id| infos_by_id.get(id).is_some_and(|info| filter.matches(info)))
+            .collect(),
+        None => candidate_pool,
+    };
+
+    let mut operators_info = generate_operators_info(&candidate_pool, &character_infos);
+    if options.lang != Language::Cn {
+        for (id, name) in operators_info.reverse_operators_id_dict.iter_mut() {
+            if let Some(localized) = state.operator_service.localized_name(*id, options.lang) {
+                *name = localized;
+            }
+        }
+    }
     let num_operators = operators_info.num_operators;
 
     tracing::debug!(
         "Generating final order for topic {} with {} operators",
-        req.topic_id,
+        topic_id,
         num_operators
     );
 
-    let mut conn = state.redis.connection.clone();
-
-    let (operator_values, total_valid_ballots): (Vec<Option<String>>, Option<i64>) = match state
-        .redis
-        .final_order_script
-        .key(&req.topic_id)
-        .arg(&operators_info.op_stats_all_fields)
-        .invoke_async(&mut conn)
-        .await
-    {
-        Ok(result) => result,
+    // precomputed in the background, see `ResultsSnapshotService`
+    let snapshot = match state.results_snapshots.get(&topic_id).await {
+        Ok(snapshot) => snapshot,
         Err(err) => {
-            tracing::error!("Failed to execute Lua script for final order: {}", err);
-            return Ok(Json(ApiResponse {
-                status: 500,
-                data: ApiData::Empty,
-                message: ApiMsg::InternalError,
-            }));
+            tracing::error!("Failed to load results snapshot for final order: {}", err);
+            return Ok(Err(ResultsRejection::new(500, ApiMsg::InternalError)));
         }
     };
 
-    tracing::debug!(
-        "Final order data for topic {}: {:?}",
-        req.topic_id,
-        operator_values
-    );
-
-    let (win_counts, lose_counts) = parse_operator_counts(&operator_values, num_operators);
-
-    let mut results = build_operator_results(
+    let results = rank_operators(
+        &snapshot,
         &operators_info.operator_ids,
         &operators_info.reverse_operators_id_dict,
-        &win_counts,
-        &lose_counts,
     );
 
+    let groups = options
+        .group_by
+        .map(|group_by| group_results(&results, group_by, &infos_by_id));
+
+    Ok(Ok(FinalOrder {
+        topic_id,
+        items: results,
+        count: snapshot.count,
+        groups,
+    }))
+}
+
+/// Groups keep the final order, the group of the best operator comes first.
+fn group_results(
+    results: &[OperatorResult],
+    group_by: ResultsGroupBy,
+    infos_by_id: &HashMap<i32, &CharacterInfo>,
+) -> Vec<ResultsGroup> {
+    let mut groups: Vec<ResultsGroup> = Vec::new();
+    let mut index: HashMap<String, usize> = HashMap::new();
+
+    for result in results {
+        let key = infos_by_id
+            .get(&result.id)
+            .map(|info| group_by.key_of(info))
+            .unwrap_or_else(|| "unknown".to_string());
+        let i = *index.entry(key.clone()).or_insert_with(|| {
+            groups.push(ResultsGroup {
+                key,
+                operator_ids: Vec::new(),
+                win: 0,
+                lose: 0,
+            });
+            groups.len() - 1
+        });
+
+        let group = &mut groups[i];
+        group.operator_ids.push(result.id);
+        group.win += result.win;
+        group.lose += result.lose;
+    }
+
+    groups
+}
+
+/// Operators by win rate, best first.
+pub(crate) fn rank_operators(
+    snapshot: &ResultsSnapshot,
+    operator_ids: &[i32],
+    names: &HashMap<i32, String>,
+) -> Vec<OperatorResult> {
+    let (win_counts, lose_counts) = operator_counts(snapshot, operator_ids);
+    let mut results = build_operator_results(operator_ids, names, &win_counts, &lose_counts);
+
     results.sort_by(|a, b| {
         b.rate
             .partial_cmp(&a.rate)
             .unwrap_or(std::cmp::Ordering::Equal)
     });
 
-    let response = ResultsFinalOrderResponse {
-        topic_id: req.topic_id,
-        items: results
-            .into_iter()
-            .map(|r| FinalOrderItem {
-                name: r.name,
-                id: r.id,
-                win: r.win,
-                lose: r.lose,
-                score: format!("{:.2}", r.score),
-                rate: format!("{:.1}%", r.rate),
-            })
-            .collect(),
-        count: total_valid_ballots.unwrap_or(0),
-    };
-
-    Ok(Json(ApiResponse {
-        status: 0,
-        data: ApiData::Data(response),
-        message: ApiMsg::OK,
-    }))
+    results
 }
 
-fn parse_operator_counts(values: &[Option<String>], num_operators: usize) -> (Vec<i64>, Vec<i64>) {
-    let win_counts: Vec<i64> = values[..num_operators]
-        .iter()
-        .map(|v| v.as_ref().and_then(|s| s.parse().ok()).unwrap_or(0))
-        .collect();
-
-    let lose_counts: Vec<i64> = values[num_operators..]
-        .iter()
-        .map(|v| v.as_ref().and_then(|s| s.parse().ok()).unwrap_or(0))
-        .collect();
-
-    (win_counts, lose_counts)
+fn operator_counts(snapshot: &ResultsSnapshot, operator_ids: &[i32]) -> (Vec<i64>, Vec<i64>) {
+    operator_ids.iter().map(|&id| snapshot.standing(id)).unzip()
 }
 
 fn build_operator_results(
@@ -260,17 +343,15 @@ mod tests {
     }
 
     #[test]
-    fn test_parse_operator_counts() {
-        let values = vec![
-            Some("10".to_string()),
-            Some("20".to_string()),
-            Some("5".to_string()),
-            Some("15".to_string()),
-        ];
-        let (wins, losses) = parse_operator_counts(&values, 2);
-
-        assert_eq!(wins, vec![10, 20]);
-        assert_eq!(losses, vec![5, 15]);
+    fn test_operator_counts() {
+        let snapshot = ResultsSnapshot {
+            stats: HashMap::from([(1, (10, 5)), (2, (20, 15))]),
+            ..Default::default()
+        };
+        let (wins, losses) = operator_counts(&snapshot, &[1, 2, 3]);
+
+        assert_eq!(wins, vec![10, 20, 0]);
+        assert_eq!(losses, vec![5, 15, 0]);
     }
 
     #[test]
@@ -0,0 +1,65 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsHeatmapQuery, ResultsHeatmapResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/results/heatmap",
+    params(ResultsHeatmapQuery),
+    responses(
+        (status = 200, description = "Ballots of the topic by weekday and hour, as of the last rollup", body = ApiResponse<ResultsHeatmapResponse>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsHeatmap"
+)]
+#[axum::debug_handler]
+pub async fn results_heatmap(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<ResultsHeatmapQuery>,
+) -> Result<Json<ApiResponse<ResultsHeatmapResponse>>, AppError> {
+    if state
+        .topic_service
+        .get_topic(&query.topic_id)
+        .await?
+        .is_none()
+    {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::TargetTopicNotFound,
+        }));
+    }
+
+    let response = match state.heatmap_service.get(&query.topic_id).await? {
+        Some(heatmap) => ResultsHeatmapResponse {
+            topic_id: heatmap.topic_id,
+            timezone: heatmap.timezone,
+            cells: heatmap.cells,
+            total: heatmap.total,
+            rolled_up_until: Some(heatmap.rolled_up_until),
+        },
+        None => ResultsHeatmapResponse {
+            topic_id: query.topic_id,
+            timezone: state.config.current().heatmap.timezone.clone(),
+            cells: vec![vec![0; 24]; 7],
+            total: 0,
+            rolled_up_until: None,
+        },
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(response),
+        message: ApiMsg::OK,
+    }))
+}
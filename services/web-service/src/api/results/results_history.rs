@@ -0,0 +1,81 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, HistoryEntry, ResultsHistoryQuery, ResultsHistoryResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/results/history",
+    params(ResultsHistoryQuery),
+    responses(
+        (status = 200, description = "The final results of the operator in every archived topic it got votes in", body = ApiResponse<ResultsHistoryResponse>),
+        (status = 404, description = "Operator not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsHistory"
+)]
+#[axum::debug_handler]
+pub async fn results_history(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<ResultsHistoryQuery>,
+) -> Result<Json<ApiResponse<ResultsHistoryResponse>>, AppError> {
+    let operator_id = query
+        .operator
+        .trim()
+        .parse::<i32>()
+        .ok()
+        .or_else(|| state.operator_service.resolve(&query.operator));
+    let Some((operator_id, name)) = operator_id.and_then(|id| {
+        let name = state.operator_service.localized_name(id, query.lang)?;
+        Some((id, name))
+    }) else {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::OperatorNotFound,
+        }));
+    };
+
+    let history = state.results_archive.history(operator_id).await?;
+    let mut entries: Vec<HistoryEntry> = Vec::with_capacity(history.len());
+    for (topic, ballots, standing) in history {
+        let battles = standing.win + standing.lose;
+        entries.push(HistoryEntry {
+            topic_id: topic.id,
+            topic_title: match topic.title.is_empty() {
+                true => topic.name,
+                false => topic.title,
+            },
+            closed_at: topic.close_time,
+            ballots,
+            rank: standing.rank,
+            ranked: standing.ranked,
+            percentile: (standing.ranked - standing.rank) as f64 * 100.0 / standing.ranked as f64,
+            win: standing.win,
+            lose: standing.lose,
+            rate: standing.win as f64 * 100.0 / battles as f64,
+            score: (standing.win - standing.lose) as f64 / 100.0,
+            rank_change: entries
+                .last()
+                .map(|previous| i64::from(previous.rank) - i64::from(standing.rank)),
+        });
+    }
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ResultsHistoryResponse {
+            operator_id,
+            name,
+            entries,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
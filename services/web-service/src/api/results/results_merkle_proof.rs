@@ -0,0 +1,44 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsMerkleProofRequest, ResultsMerkleProofResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/results/merkle/proof",
+    request_body = ResultsMerkleProofRequest,
+    responses(
+        (status = 200, description = "Inclusion proof of a ballot in the latest merkle root of its topic", body = ApiResponse<ResultsMerkleProofResponse>),
+        (status = 404, description = "No root is committed yet, or the ballot is not among its leaves", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsMerkleProof"
+)]
+#[axum::debug_handler]
+pub async fn results_merkle_proof(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ResultsMerkleProofRequest>,
+) -> Result<Json<ApiResponse<ResultsMerkleProofResponse>>, AppError> {
+    let proof = state
+        .merkle_service
+        .proof(&req.topic_id, &req.ballot_id)
+        .await?;
+
+    Ok(Json(match proof {
+        Ok(proof) => ApiResponse {
+            status: 0,
+            data: ApiData::Data(proof),
+            message: ApiMsg::OK,
+        },
+        Err(message) => ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message,
+        },
+    }))
+}
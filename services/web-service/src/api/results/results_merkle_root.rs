@@ -0,0 +1,43 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsMerkleRootRequest, ResultsMerkleRootResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/results/merkle/root",
+    request_body = ResultsMerkleRootRequest,
+    responses(
+        (status = 200, description = "Latest merkle root over the accepted ballots of a topic", body = ApiResponse<ResultsMerkleRootResponse>),
+        (status = 404, description = "No root is committed for the topic yet", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsMerkleRoot"
+)]
+#[axum::debug_handler]
+pub async fn results_merkle_root(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ResultsMerkleRootRequest>,
+) -> Result<Json<ApiResponse<ResultsMerkleRootResponse>>, AppError> {
+    let Some(commitment) = state.merkle_service.latest(&req.topic_id).await? else {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::MerkleCommitmentNotFound,
+        }));
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(ResultsMerkleRootResponse {
+            leaves_url: state.merkle_service.leaves_url(&commitment),
+            commitment,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
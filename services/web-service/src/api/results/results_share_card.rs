@@ -0,0 +1,171 @@
+use std::sync::Arc;
+
+use axum::{
+    extract::{Query, State},
+    http::{HeaderMap, StatusCode, header},
+    response::{IntoResponse as _, Response},
+};
+use sha2::{Digest as _, Sha256};
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse, ResultsShareCardQuery},
+    excel::Language,
+};
+
+use super::results_final_order::{FinalOrderOptions, compute_final_order};
+use crate::{
+    AppState,
+    error::AppError,
+    service::{ShareCard, ShareCardRow},
+};
+
+fn rejected(status: i32, message: ApiMsg) -> Response {
+    axum::Json(ApiResponse::<ApiData<String>> {
+        status,
+        data: ApiData::Empty,
+        message,
+    })
+    .into_response()
+}
+
+/// Comma separated option ids, at least one.
+fn parse_ranking(ranking: &str) -> Option<Vec<i32>> {
+    let ids: Vec<i32> = ranking
+        .split(',')
+        .map(|id| id.trim().parse().ok())
+        .collect::<Option<_>>()?;
+    (!ids.is_empty()).then_some(ids)
+}
+
+fn subtitle(lang: Language, top: usize, count: i64, personal: bool) -> String {
+    match (lang, personal) {
+        (Language::Cn, false) => format!("前 {top} 名 · {count} 票"),
+        (Language::Cn, true) => "我的排名".to_string(),
+        (Language::En, false) => format!("Top {top} · {count} votes"),
+        (Language::En, true) => "My ranking".to_string(),
+        (Language::Jp, false) => format!("トップ {top} · {count} 票"),
+        (Language::Jp, true) => "私のランキング".to_string(),
+    }
+}
+
+#[utoipa::path(
+    get,
+    path = "/results/share_card",
+    params(ResultsShareCardQuery),
+    responses(
+        (status = 200, description = "PNG card of the top options, or of a voter's own ranking", content_type = "image/png"),
+        (status = 304, description = "Image not modified"),
+        (status = 400, description = "Invalid ranking", body = ApiResponse<String>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsShareCard"
+)]
+#[axum::debug_handler]
+pub async fn results_share_card(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<ResultsShareCardQuery>,
+    headers: HeaderMap,
+) -> Result<Response, AppError> {
+    let config = state.config.current();
+    let top = query
+        .top
+        .unwrap_or(config.share_card.default_top)
+        .clamp(1, config.share_card.max_top);
+    let ranking = match query.ranking.as_deref().map(parse_ranking) {
+        Some(Some(mut ids)) => {
+            ids.truncate(top);
+            Some(ids)
+        }
+        Some(None) => return Ok(rejected(400, ApiMsg::InvalidRanking)),
+        None => None,
+    };
+
+    let key = match &ranking {
+        Some(ids) => format!("{}:{:?}:{:?}", query.topic_id, query.lang, ids),
+        None => format!("{}:{:?}:top{top}", query.topic_id, query.lang),
+    };
+    let png = match state.share_card_service.cached(&key) {
+        Some(png) => png,
+        None => {
+            let options = FinalOrderOptions {
+                lang: query.lang,
+                ..Default::default()
+            };
+            let final_order =
+                match compute_final_order(&state, query.topic_id.clone(), options).await? {
+                    Ok(final_order) => final_order,
+                    Err(rejection) => return Ok(rejected(rejection.status, rejection.message)),
+                };
+            let Some(topic) = state.topic_service.get_topic(&query.topic_id).await? else {
+                return Ok(rejected(404, ApiMsg::TargetTopicNotFound));
+            };
+
+            let mut items = final_order.items;
+            let items = match &ranking {
+                Some(ids) => {
+                    let mut ranked = Vec::with_capacity(ids.len());
+                    for id in ids {
+                        let Some(i) = items.iter().position(|item| item.id == *id) else {
+                            return Ok(rejected(400, ApiMsg::OptionNotInCandidatePool));
+                        };
+                        ranked.push(items.swap_remove(i));
+                    }
+                    ranked
+                }
+                None => {
+                    items.truncate(top);
+                    items
+                }
+            };
+            let card = ShareCard {
+                title: match topic.title.is_empty() {
+                    true => topic.name,
+                    false => topic.title,
+                },
+                subtitle: subtitle(
+                    query.lang,
+                    items.len(),
+                    final_order.count,
+                    ranking.is_some(),
+                ),
+                rows: items
+                    .into_iter()
+                    .map(|item| ShareCardRow {
+                        id: item.id,
+                        name: item.name,
+                        rate: item.rate,
+                    })
+                    .collect(),
+            };
+            state.share_card_service.render(key, card).await?
+        }
+    };
+
+    let etag = format!("\"{}\"", hex::encode(&Sha256::digest(&png)[..16]));
+    let cache_control = format!(
+        "public, max-age={}",
+        state.share_card_service.max_age_secs()
+    );
+    let not_modified = headers
+        .get(header::IF_NONE_MATCH)
+        .and_then(|value| value.to_str().ok())
+        .is_some_and(|value| value.split(',').any(|tag| tag.trim() == etag));
+    if not_modified {
+        return Ok((
+            StatusCode::NOT_MODIFIED,
+            [(header::ETAG, etag), (header::CACHE_CONTROL, cache_control)],
+        )
+            .into_response());
+    }
+
+    Ok((
+        [
+            (header::CONTENT_TYPE, "image/png".to_string()),
+            (header::ETAG, etag),
+            (header::CACHE_CONTROL, cache_control),
+        ],
+        png,
+    )
+        .into_response())
+}
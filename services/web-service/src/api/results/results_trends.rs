@@ -0,0 +1,96 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsTrendsQuery, ResultsTrendsResponse, TrendMomentum,
+    TrendUpset,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/results/trends",
+    params(ResultsTrendsQuery),
+    responses(
+        (status = 200, description = "Upsets of the ranking and the options whose win rate moved in the last hours", body = ApiResponse<ResultsTrendsResponse>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Not a pairwise topic, or an internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsTrends"
+)]
+#[axum::debug_handler]
+pub async fn results_trends(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<ResultsTrendsQuery>,
+) -> Result<Json<ApiResponse<ResultsTrendsResponse>>, AppError> {
+    let topic = match state.topic_service.get_topic(&query.topic_id).await? {
+        Some(topic) if topic.topic_type.supports_1v1_matrix() => topic,
+        Some(_) => {
+            return Ok(Json(ApiResponse {
+                status: 500,
+                data: ApiData::Empty,
+                message: ApiMsg::CurTopicNotSupport1v1Matrix,
+            }));
+        }
+        None => {
+            return Ok(Json(ApiResponse {
+                status: 404,
+                data: ApiData::Empty,
+                message: ApiMsg::TargetTopicNotFound,
+            }));
+        }
+    };
+
+    let config = state.config.current().trends.clone();
+    let hours = query
+        .hours
+        .unwrap_or(config.momentum_hours)
+        .clamp(1, config.max_momentum_hours);
+    let trends = state.trend_service.trends(&topic.id, hours).await?;
+    let name = |id: i32| {
+        state
+            .operator_service
+            .localized_name(id, query.lang)
+            .unwrap_or_else(|| format!("Unknown Operator {id}"))
+    };
+    let response = ResultsTrendsResponse {
+        topic_id: topic.id,
+        upsets: trends
+            .upsets
+            .iter()
+            .map(|upset| TrendUpset {
+                winner_id: upset.winner,
+                winner_name: name(upset.winner),
+                winner_rank: upset.winner_rank,
+                loser_id: upset.loser,
+                loser_name: name(upset.loser),
+                loser_rank: upset.loser_rank,
+                margin: upset.margin,
+            })
+            .collect(),
+        hours,
+        momentum: trends
+            .momentum
+            .iter()
+            .map(|momentum| TrendMomentum {
+                id: momentum.id,
+                name: name(momentum.id),
+                rate_before: momentum.rate_before,
+                recent_rate: momentum.recent_rate,
+                shift: momentum.shift(),
+                recent_battles: momentum.recent_battles,
+            })
+            .collect(),
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(response),
+        message: ApiMsg::OK,
+    }))
+}
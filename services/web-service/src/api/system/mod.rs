@@ -0,0 +1,43 @@
+use std::sync::Arc;
+
+use axum::{
+    Router,
+    routing::{get, post},
+};
+
+use crate::state::AppState;
+
+pub mod system_audit_log_export;
+pub mod system_config;
+pub mod system_jobs;
+pub mod system_maintenance;
+pub mod system_regions;
+pub mod system_retention;
+pub mod system_slo;
+pub mod system_version;
+
+use system_audit_log_export::system_audit_log_export;
+use system_config::system_config;
+use system_jobs::system_jobs;
+use system_maintenance::{system_maintenance, system_maintenance_set};
+use system_regions::system_regions;
+use system_retention::system_retention;
+use system_slo::system_slo;
+use system_version::system_version;
+
+pub fn system_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/version", get(system_version)) // 获取构建信息
+        .route("/maintenance", get(system_maintenance)) // 获取维护状态
+}
+
+pub fn system_admin_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/slo", get(system_slo)) // 获取各路由延迟与错误预算
+        .route("/config", get(system_config)) // 获取脱敏后的运行配置
+        .route("/jobs", get(system_jobs)) // 获取后台任务运行状态
+        .route("/maintenance", post(system_maintenance_set)) // 开关维护模式
+        .route("/audit_log/export", get(system_audit_log_export)) // 导出签名的审计日志
+        .route("/retention", get(system_retention)) // 预览数据保留策略将清理的数据
+        .route("/regions", get(system_regions)) // 按地区统计投票数
+}
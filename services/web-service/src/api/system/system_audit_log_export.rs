@@ -0,0 +1,62 @@
+use std::sync::Arc;
+
+use axum::{
+    extract::{Query, State},
+    http::header,
+    response::{IntoResponse as _, Response},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, AuditLogExportQuery};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/system/audit_log/export",
+    params(AuditLogExportQuery),
+    responses(
+        (status = 200, description = "Hash chained audit log entries of the range as JSON lines, the last line is a trailer with the HMAC signature of every line before it", content_type = "application/x-ndjson"),
+        (status = 400, description = "Range ends before it starts", body = ApiResponse<String>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>),
+        (status = 503, description = "No export signing key is configured", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemAuditLogExport",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_audit_log_export(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<AuditLogExportQuery>,
+) -> Result<Response, AppError> {
+    if query.from >= query.to {
+        return Ok(ApiResponse::<()> {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::InvalidTimeRange,
+        }
+        .into_response());
+    }
+
+    let Some(body) = state.audit_log_service.export(query.from, query.to).await? else {
+        return Ok(ApiResponse::<()> {
+            status: 503,
+            data: ApiData::Empty,
+            message: ApiMsg::AuditLogExportUnavailable,
+        }
+        .into_response());
+    };
+
+    let filename = format!(
+        "attachment; filename=\"audit_log_{}_{}.jsonl\"",
+        query.from.format("%Y%m%dT%H%M%SZ"),
+        query.to.format("%Y%m%dT%H%M%SZ")
+    );
+    Ok((
+        [
+            (header::CONTENT_TYPE, "application/x-ndjson".to_string()),
+            (header::CONTENT_DISPOSITION, filename),
+        ],
+        body,
+    )
+        .into_response())
+}
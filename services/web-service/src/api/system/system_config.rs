@@ -0,0 +1,35 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::{
+    models::api::{ApiData, ApiMsg, ApiResponse, SystemConfigResponse},
+    secrets::redacted_config,
+};
+
+use crate::AppState;
+
+#[utoipa::path(
+    get,
+    path = "/system/config",
+    responses(
+        (status = 200, description = "Effective runtime config, including reloaded sections, with secrets redacted", body = ApiResponse<SystemConfigResponse>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemConfig",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_config(
+    State(state): State<Arc<AppState>>,
+) -> Json<ApiResponse<SystemConfigResponse>> {
+    let config = state.config.current();
+    Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(SystemConfigResponse {
+            environment: config.environment.to_string(),
+            config: redacted_config(&config),
+        }),
+        message: ApiMsg::OK,
+    })
+}
@@ -0,0 +1,30 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, SystemJobsResponse};
+
+use crate::AppState;
+
+#[utoipa::path(
+    get,
+    path = "/system/jobs",
+    responses(
+        (status = 200, description = "Background jobs of this instance, with the last and next run of the recurring ones", body = ApiResponse<SystemJobsResponse>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemJobs",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_jobs(
+    State(state): State<Arc<AppState>>,
+) -> Json<ApiResponse<SystemJobsResponse>> {
+    Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(SystemJobsResponse {
+            jobs: state.jobs.list(),
+        }),
+        message: ApiMsg::OK,
+    })
+}
@@ -0,0 +1,54 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, MaintenanceSetRequest, MaintenanceStatus};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/system/maintenance",
+    responses(
+        (status = 200, description = "Whether writes are paused, and the announcement to show", body = ApiResponse<MaintenanceStatus>),
+    ),
+    tag = "System",
+    operation_id = "systemMaintenance"
+)]
+#[axum::debug_handler]
+pub async fn system_maintenance(
+    State(state): State<Arc<AppState>>,
+) -> Json<ApiResponse<MaintenanceStatus>> {
+    Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(state.maintenance.status()),
+        message: ApiMsg::OK,
+    })
+}
+
+#[utoipa::path(
+    post,
+    path = "/system/maintenance",
+    request_body = MaintenanceSetRequest,
+    responses(
+        (status = 200, description = "Maintenance switched on every instance, returns the resulting status", body = ApiResponse<MaintenanceStatus>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemMaintenanceSet",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_maintenance_set(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<MaintenanceSetRequest>,
+) -> Result<Json<ApiResponse<MaintenanceStatus>>, AppError> {
+    tracing::warn!(enabled = req.enabled, message = %req.message, "maintenance switched");
+    let status = state.maintenance.set(req.enabled, req.message).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(status),
+        message: ApiMsg::OK,
+    }))
+}
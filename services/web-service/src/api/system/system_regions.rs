@@ -0,0 +1,58 @@
+use std::sync::Arc;
+
+use axum::{
+    Json,
+    extract::{Query, State},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, SystemRegionsQuery, SystemRegionsResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/system/regions",
+    params(SystemRegionsQuery),
+    responses(
+        (status = 200, description = "Ballots of the topic by the country or province of the voter addresses, aggregated only", body = ApiResponse<SystemRegionsResponse>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemRegions",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_regions(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<SystemRegionsQuery>,
+) -> Result<Json<ApiResponse<SystemRegionsResponse>>, AppError> {
+    if state
+        .topic_service
+        .get_topic(&query.topic_id)
+        .await?
+        .is_none()
+    {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::TargetTopicNotFound,
+        }));
+    }
+
+    let (regions, other, total) = state
+        .region_service
+        .breakdown(&query.topic_id, query.level)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(SystemRegionsResponse {
+            topic_id: query.topic_id,
+            total,
+            regions,
+            other,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
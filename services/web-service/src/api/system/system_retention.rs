@@ -0,0 +1,31 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, RetentionReport};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    get,
+    path = "/system/retention",
+    responses(
+        (status = 200, description = "What the retention policies would prune now, nothing is deleted", body = ApiResponse<RetentionReport>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemRetention",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_retention(
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<RetentionReport>>, AppError> {
+    let report = state.retention_service.run(true).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(report),
+        message: ApiMsg::OK,
+    }))
+}
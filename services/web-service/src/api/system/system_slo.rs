@@ -0,0 +1,28 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, SystemSloResponse};
+
+use crate::AppState;
+
+#[utoipa::path(
+    get,
+    path = "/system/slo",
+    responses(
+        (status = 200, description = "Per-route latency percentiles and error budgets over the rolling windows", body = ApiResponse<SystemSloResponse>),
+        (status = 401, description = "Missing or invalid admin API key", body = ApiResponse<String>)
+    ),
+    tag = "System",
+    operation_id = "systemSlo",
+    security(("api_key" = []))
+)]
+#[axum::debug_handler]
+pub async fn system_slo(
+    State(state): State<Arc<AppState>>,
+) -> Json<ApiResponse<SystemSloResponse>> {
+    Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(state.route_stats.report()),
+        message: ApiMsg::OK,
+    })
+}
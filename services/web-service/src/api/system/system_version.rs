@@ -0,0 +1,23 @@
+use axum::Json;
+use share::{
+    build_info::{BuildInfo, build_info},
+    models::api::{ApiData, ApiMsg, ApiResponse},
+};
+
+#[utoipa::path(
+    get,
+    path = "/system/version",
+    responses(
+        (status = 200, description = "Version and build of the serving binary", body = ApiResponse<BuildInfo>),
+    ),
+    tag = "System",
+    operation_id = "systemVersion"
+)]
+#[axum::debug_handler]
+pub async fn system_version() -> Json<ApiResponse<BuildInfo>> {
+    Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(build_info().clone()),
+        message: ApiMsg::OK,
+    })
+}
@@ -8,11 +8,13 @@ pub mod topic_candidate_pool;
 pub mod topic_create;
 pub mod topic_info;
 pub mod topic_list_active;
+pub mod topic_presence;
 
 use topic_candidate_pool::topic_candidate_pool;
 use topic_create::topic_create;
 use topic_info::topic_info;
 use topic_list_active::topic_list_active;
+use topic_presence::topic_presence;
 
 pub fn topic_routes() -> Router<Arc<AppState>> {
     Router::new()
@@ -20,4 +22,5 @@ pub fn topic_routes() -> Router<Arc<AppState>> {
         .route("/create", post(topic_create)) // 创建新 topic
         .route("/info", post(topic_info)) // 获取 topic 详情
         .route("/candidate_pool", post(topic_candidate_pool)) // 获取候选池
+        .route("/presence", post(topic_presence)) // 获取在线人数
 }
@@ -27,14 +27,23 @@ pub async fn topic_candidate_pool(
 ) -> Result<Json<ApiResponse<TopicCandidatePoolResponse>>, AppError> {
     let candidate_pool = state
         .topic_service
-        .get_candidate_pool(&payload.topic_id, &state.character_infos)
+        .get_candidate_pool(&payload.topic_id, &state.operator_service.character_infos())
         .await;
 
     match candidate_pool {
         Some(candidate_pool) => {
+            let images = state.option_image_service.urls(&payload.topic_id).await?;
             let mut pool: Vec<CharacterPortrait> = candidate_pool
                 .into_iter()
                 .filter_map(|char_id| state.character_portraits.get(&char_id).cloned())
+                .map(|mut portrait| {
+                    if let Some(lang) = payload.lang {
+                        portrait.display_name =
+                            state.operator_service.localized_name(portrait.id, lang);
+                    }
+                    portrait.image = images.get(&portrait.id).cloned();
+                    portrait
+                })
                 .collect();
 
             pool.sort_unstable_by_key(|info| info.id);
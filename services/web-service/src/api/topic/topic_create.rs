@@ -2,13 +2,17 @@ use std::sync::Arc;
 
 use axum::{Json, extract::State};
 use chrono::Utc;
-use share::models::{
-    api::{ApiData, ApiMsg, ApiResponse, TopicCreateRequest, TopicCreateResponse},
-    database::{CreateTopicStatus, VotingTopic},
+use share::{
+    events::{DomainEvent, DomainEventKind, TopicPhase},
+    models::{
+        api::{ApiData, ApiMsg, ApiResponse, TopicCreateRequest, TopicCreateResponse},
+        database::{AuditLogEntry, CreateTopicStatus, VotingTopic},
+        live::TopicChangeKind,
+    },
 };
 use uuid::Uuid;
 
-use crate::{AppState, error::AppError};
+use crate::{AppState, error::AppError, service::FilterVerdict};
 
 #[utoipa::path(
     post,
@@ -16,6 +20,7 @@ use crate::{AppState, error::AppError};
     request_body = TopicCreateRequest,
     responses(
         (status = 200, description = "Create a new topic", body = ApiResponse<TopicCreateResponse>),
+        (status = 400, description = "A text contains words that are not allowed", body = ApiResponse<String>),
         (status = 500, description = "Internal server error", body = ApiResponse<String>)
     ),
     tag = "Topic",
@@ -24,8 +29,30 @@ use crate::{AppState, error::AppError};
 #[axum::debug_handler]
 pub async fn topic_create(
     State(state): State<Arc<AppState>>,
-    Json(req): Json<TopicCreateRequest>,
+    Json(mut req): Json<TopicCreateRequest>,
 ) -> Result<Json<ApiResponse<TopicCreateResponse>>, AppError> {
+    // the topic waits for an audit either way, review only flags it there
+    let action = state.config.current().word_filter.topic;
+    let mut flagged = Vec::new();
+    let fields = [
+        ("name", &mut req.name),
+        ("title", &mut req.title),
+        ("description", &mut req.description),
+    ];
+    for (field, text) in fields {
+        match state.word_filter_service.check(action, text) {
+            FilterVerdict::Reject => {
+                return Ok(Json(ApiResponse {
+                    status: 400,
+                    data: ApiData::Empty,
+                    message: ApiMsg::ContentRejected(field.to_string()),
+                }));
+            }
+            FilterVerdict::Review => flagged.push(field),
+            FilterVerdict::Clean | FilterVerdict::Masked => {}
+        }
+    }
+
     let topic = VotingTopic {
         id: if req.id.is_empty() {
             Uuid::new_v4().to_string()
@@ -37,6 +64,7 @@ pub async fn topic_create(
         description: req.description,
         topic_type: req.topic_type,
         candidate_pool: req.candidate_pool,
+        excluded_operator_ids: req.excluded_operator_ids,
         created_at: Utc::now(),
         updated_at: None,
         open_time: req.open_time,
@@ -45,16 +73,47 @@ pub async fn topic_create(
         status: CreateTopicStatus::WaitingAudit,
     };
 
-    match state.topic_service.create_topic(&topic).await {
-        Ok(_) => Ok(Json(ApiResponse {
-            status: 0,
-            data: ApiData::Data(TopicCreateResponse {
-                id: topic.id,
-                is_active: topic.is_active,
-                status: topic.status,
-            }),
-            message: ApiMsg::OK,
-        })),
+    let mut change = state.outbox.begin().await?;
+    let created = state
+        .topic_service
+        .create_topic(&topic, change.session())
+        .await;
+    change.push(DomainEvent::new(DomainEventKind::TopicTransition {
+        topic_id: topic.id.clone(),
+        from: None,
+        to: TopicPhase::WaitingAudit,
+    }));
+
+    match created {
+        Ok(()) => {
+            state.outbox.commit(change).await?;
+            if !flagged.is_empty() {
+                let entry = AuditLogEntry::new(
+                    Some(topic.id.clone()),
+                    "word_filter",
+                    "topic_flagged",
+                    format!("sensitive words in {}", flagged.join(", ")),
+                );
+                // the topic is created, only its flag for the audit is lost
+                if let Err(e) = state.audit_log_service.record(entry).await {
+                    tracing::warn!("Failed to flag topic {}: {}", topic.id, e);
+                }
+            }
+            state
+                .topic_sync
+                .notify(&topic.id, TopicChangeKind::Created)
+                .await;
+
+            Ok(Json(ApiResponse {
+                status: 0,
+                data: ApiData::Data(TopicCreateResponse {
+                    id: topic.id,
+                    is_active: topic.is_active,
+                    status: topic.status,
+                }),
+                message: ApiMsg::OK,
+            }))
+        }
         Err(e) => {
             tracing::error!("Failed to create topic: {}", e);
             Ok(Json(ApiResponse {
@@ -0,0 +1,50 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, TopicPresenceRequest, TopicPresenceResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/topic/presence",
+    request_body = TopicPresenceRequest,
+    responses(
+        (status = 200, description = "Get the number of current viewers of a topic", body = ApiResponse<TopicPresenceResponse>),
+        (status = 404, description = "Topic not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Topic",
+    operation_id = "topicPresence"
+)]
+#[axum::debug_handler]
+pub async fn topic_presence(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<TopicPresenceRequest>,
+) -> Result<Json<ApiResponse<TopicPresenceResponse>>, AppError> {
+    if state
+        .topic_service
+        .get_topic(&req.topic_id)
+        .await?
+        .is_none()
+    {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::TargetTopicNotFound,
+        }));
+    }
+
+    let viewers = state.presence_service.count(&req.topic_id).await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(TopicPresenceResponse {
+            topic_id: req.topic_id,
+            viewers,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -1,13 +1,24 @@
 use rand::{Rng as _, distr::Alphanumeric};
 
-use crate::error::AppError;
+use crate::{error::AppError, middleware::request_id};
 
+#[tracing::instrument(name = "nats.publish", skip_all, fields(otel.kind = "producer", subject = subject))]
 pub async fn publish_and_ack(
     jetstream: &async_nats::jetstream::Context,
     subject: &'static str,
     data: Vec<u8>,
 ) -> Result<(), AppError> {
-    let _publish_ack = jetstream.publish(subject, data.into()).await?;
+    // carry the request id over to the consumers so their logs can be correlated
+    let _publish_ack = match request_id::current().filter(|id| !id.is_empty()) {
+        Some(id) => {
+            let mut headers = async_nats::HeaderMap::new();
+            headers.insert(request_id::REQUEST_ID_HEADER.as_str(), id.as_str());
+            jetstream
+                .publish_with_headers(subject, headers, data.into())
+                .await?
+        }
+        None => jetstream.publish(subject, data.into()).await?,
+    };
     // publish_ack.await?;
     Ok(())
 }
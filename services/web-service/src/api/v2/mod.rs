@@ -0,0 +1,21 @@
+//! `/api/v2` reuses the v1 handlers wherever the shapes did not change, and
+//! only the endpoints listed here map the shared results to v2 DTOs.
+
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+
+use crate::state::AppState;
+
+pub mod results_final_order;
+
+use super::results::results_1v1_matrix::results_1v1_matrix;
+use results_final_order::results_final_order;
+
+pub fn v2_routes(state: &Arc<AppState>) -> Router<Arc<AppState>> {
+    let results = Router::new()
+        .route("/1v1_matrix", post(results_1v1_matrix))
+        .route("/final_order", post(results_final_order));
+
+    super::shared_routes(state).nest("/results", super::with_results_guard(state, results))
+}
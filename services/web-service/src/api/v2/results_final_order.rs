@@ -0,0 +1,59 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, ResultsFinalOrderRequest,
+    v2::{FinalOrderItem, ResultsFinalOrderResponse},
+};
+
+use crate::{AppState, api::results::results_final_order::compute_final_order, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/api/v2/results/final_order",
+    request_body = ResultsFinalOrderRequest,
+    responses(
+        (status = 200, description = "Get final order for a topic with numeric score and rate", body = ApiResponse<ResultsFinalOrderResponse>),
+        (status = 400, description = "Bad request", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    tag = "Results",
+    operation_id = "resultsFinalOrderV2"
+)]
+#[axum::debug_handler]
+pub async fn results_final_order(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<ResultsFinalOrderRequest>,
+) -> Result<Json<ApiResponse<ResultsFinalOrderResponse>>, AppError> {
+    let topic_id = req.topic_id.clone();
+    let final_order = match compute_final_order(&state, topic_id, req.into()).await? {
+        Ok(final_order) => final_order,
+        Err(rejection) => return Ok(rejection.into_response()),
+    };
+
+    let response = ResultsFinalOrderResponse {
+        topic_id: final_order.topic_id,
+        items: final_order
+            .items
+            .into_iter()
+            .enumerate()
+            .map(|(i, r)| FinalOrderItem {
+                id: r.id,
+                name: r.name,
+                win: r.win,
+                lose: r.lose,
+                score: r.score,
+                rate: r.rate,
+                rank: i + 1,
+            })
+            .collect(),
+        valid_ballots_count: final_order.count,
+        groups: final_order.groups,
+    };
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(response),
+        message: ApiMsg::OK,
+    }))
+}
@@ -0,0 +1,23 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::post};
+
+use crate::state::AppState;
+
+pub mod webhook_create;
+pub mod webhook_delete;
+pub mod webhook_deliveries;
+pub mod webhook_list;
+
+use webhook_create::webhook_create;
+use webhook_delete::webhook_delete;
+use webhook_deliveries::webhook_deliveries;
+use webhook_list::webhook_list;
+
+pub fn webhook_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/create", post(webhook_create))
+        .route("/delete", post(webhook_delete))
+        .route("/list", post(webhook_list))
+        .route("/deliveries", post(webhook_deliveries))
+}
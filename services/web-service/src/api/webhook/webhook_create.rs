@@ -0,0 +1,53 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, WebhookCreateRequest, WebhookCreateResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/webhook/create",
+    request_body = WebhookCreateRequest,
+    responses(
+        (status = 200, description = "Webhook registered", body = ApiResponse<WebhookCreateResponse>),
+        (status = 400, description = "Invalid webhook url", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Webhook",
+    operation_id = "webhookCreate"
+)]
+#[axum::debug_handler]
+pub async fn webhook_create(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<WebhookCreateRequest>,
+) -> Result<Json<ApiResponse<WebhookCreateResponse>>, AppError> {
+    let valid_url = reqwest::Url::parse(&req.url)
+        .is_ok_and(|url| matches!(url.scheme(), "http" | "https") && url.host().is_some());
+    if !valid_url {
+        return Ok(Json(ApiResponse {
+            status: 400,
+            data: ApiData::Empty,
+            message: ApiMsg::WebhookInvalidUrl,
+        }));
+    }
+
+    let webhook = state
+        .webhook_service
+        .create(req.url, req.events, req.secret)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(WebhookCreateResponse {
+            id: webhook.id,
+            url: webhook.url,
+            events: webhook.events,
+            secret: webhook.secret,
+        }),
+        message: ApiMsg::OK,
+    }))
+}
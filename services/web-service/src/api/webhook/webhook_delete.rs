@@ -0,0 +1,39 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, WebhookDeleteRequest};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/webhook/delete",
+    request_body = WebhookDeleteRequest,
+    responses(
+        (status = 200, description = "Webhook deleted", body = ApiResponse<String>),
+        (status = 404, description = "Webhook not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Webhook",
+    operation_id = "webhookDelete"
+)]
+#[axum::debug_handler]
+pub async fn webhook_delete(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<WebhookDeleteRequest>,
+) -> Result<Json<ApiResponse<ApiData<String>>>, AppError> {
+    if !state.webhook_service.delete(&req.id).await? {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::WebhookNotFound,
+        }));
+    }
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Empty,
+        message: ApiMsg::OK,
+    }))
+}
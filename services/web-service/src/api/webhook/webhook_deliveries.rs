@@ -0,0 +1,46 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{
+    ApiData, ApiMsg, ApiResponse, WebhookDeliveriesRequest, WebhookDeliveriesResponse,
+};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/webhook/deliveries",
+    request_body = WebhookDeliveriesRequest,
+    responses(
+        (status = 200, description = "Recent delivery attempts of a webhook", body = ApiResponse<WebhookDeliveriesResponse>),
+        (status = 404, description = "Webhook not found", body = ApiResponse<String>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Webhook",
+    operation_id = "webhookDeliveries"
+)]
+#[axum::debug_handler]
+pub async fn webhook_deliveries(
+    State(state): State<Arc<AppState>>,
+    Json(req): Json<WebhookDeliveriesRequest>,
+) -> Result<Json<ApiResponse<WebhookDeliveriesResponse>>, AppError> {
+    if !state.webhook_service.exists(&req.webhook_id).await? {
+        return Ok(Json(ApiResponse {
+            status: 404,
+            data: ApiData::Empty,
+            message: ApiMsg::WebhookNotFound,
+        }));
+    }
+
+    let deliveries = state
+        .webhook_service
+        .deliveries(&req.webhook_id, req.limit)
+        .await?;
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(WebhookDeliveriesResponse { deliveries }),
+        message: ApiMsg::OK,
+    }))
+}
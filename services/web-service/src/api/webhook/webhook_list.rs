@@ -0,0 +1,42 @@
+use std::sync::Arc;
+
+use axum::{Json, extract::State};
+use share::models::api::{ApiData, ApiMsg, ApiResponse, WebhookInfo, WebhookListResponse};
+
+use crate::{AppState, error::AppError};
+
+#[utoipa::path(
+    post,
+    path = "/webhook/list",
+    responses(
+        (status = 200, description = "List registered webhooks", body = ApiResponse<WebhookListResponse>),
+        (status = 500, description = "Internal server error", body = ApiResponse<String>)
+    ),
+    security(("api_key" = [])),
+    tag = "Webhook",
+    operation_id = "webhookList"
+)]
+#[axum::debug_handler]
+pub async fn webhook_list(
+    State(state): State<Arc<AppState>>,
+) -> Result<Json<ApiResponse<WebhookListResponse>>, AppError> {
+    let webhooks = state
+        .webhook_service
+        .list()
+        .await?
+        .into_iter()
+        .map(|webhook| WebhookInfo {
+            id: webhook.id,
+            url: webhook.url,
+            events: webhook.events,
+            enabled: webhook.enabled,
+            created_at: webhook.created_at,
+        })
+        .collect();
+
+    Ok(Json(ApiResponse {
+        status: 0,
+        data: ApiData::Data(WebhookListResponse { webhooks }),
+        message: ApiMsg::OK,
+    }))
+}
@@ -1,7 +1,10 @@
 use axum::{Json, http::StatusCode};
 use redis::RedisError;
+use serde::Serialize;
 use share::models::api::{ApiData, ApiMsg, ApiResponse};
 
+use crate::middleware::request_id;
+
 #[derive(thiserror::Error, Debug)]
 pub enum AppError {
     #[error("redis error: {0}")]
@@ -26,11 +29,33 @@ pub enum AppError {
     MissingCharacterTableJson,
     #[error("reqwest error: {0}")]
     Reqwest(#[from] reqwest::Error),
+    #[error("bson serialization error: {0}")]
+    BsonSer(#[from] mongodb::bson::ser::Error),
+    #[error("image error: {0}")]
+    Image(#[from] image::ImageError),
+    #[error("{0}")]
+    Task(#[from] crate::task::TaskError),
+    #[error("{0}")]
+    Pool(#[from] share::task::pool::PoolError),
+    #[error("smtp error: {0}")]
+    Smtp(#[from] lettre::transport::smtp::Error),
+    #[error("mail error: {0}")]
+    Mail(#[from] lettre::error::Error),
+    #[error("mail address error: {0}")]
+    MailAddress(#[from] lettre::address::AddressError),
+}
+
+#[derive(Serialize)]
+struct ErrorBody {
+    #[serde(flatten)]
+    response: ApiResponse<()>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    request_id: Option<String>,
 }
 
 impl axum::response::IntoResponse for AppError {
     fn into_response(self) -> axum::response::Response {
-        let (status, message) = match self {
+        let (status, message) = match &self {
             AppError::SameParticipant => (
                 StatusCode::BAD_REQUEST,
                 ApiResponse::<()> {
@@ -49,6 +74,29 @@ impl axum::response::IntoResponse for AppError {
             ),
         };
 
-        (status, Json(message)).into_response()
+        let request_id = request_id::current().filter(|id| !id.is_empty());
+        if status.is_server_error() {
+            tracing::error!(
+                request_id = request_id.as_deref(),
+                "request failed: {}",
+                self
+            );
+        }
+
+        let body = ErrorBody {
+            response: message,
+            request_id,
+        };
+        (status, Json(body)).into_response()
     }
 }
+
+/// Turns a panicking handler into the usual 500. The panic itself has already
+/// been reported by the panic hook, Sentry's included.
+pub fn recover_panic(payload: Box<dyn std::any::Any + Send + 'static>) -> axum::response::Response {
+    let message = share::task::panic_message(&*payload);
+
+    axum::response::IntoResponse::into_response(AppError::InternalError(format!(
+        "handler panicked: {message}"
+    )))
+}
@@ -0,0 +1,199 @@
+//! Restarts without closing the listening socket: on SIGUSR2 the running
+//! process starts its replacement with the listener's fd inherited and a
+//! pipe to report readiness on, and only drains once the new process is
+//! serving. Connections queue on the shared socket the whole time, so none
+//! are refused or reset while the binary is swapped.
+//!
+//! Sockets passed by systemd (`LISTEN_FDS`, `ListenStream=` in a `.socket`
+//! unit) are picked up the same way, the service can then be restarted with
+//! plain `systemctl restart`. SIGUSR2 works under systemd as well when the
+//! unit has `Type=notify` and `NotifyAccess=all`, the replacement reports
+//! itself as the new main process once it is ready.
+
+use std::time::Duration;
+
+/// Fd of the inherited listener, set for the replacement process.
+pub const LISTEN_FD_ENV: &str = "ARK_VOTE_LISTEN_FD";
+/// Write end of the readiness pipe, a byte on it tells the old process to
+/// drain.
+pub const READY_FD_ENV: &str = "ARK_VOTE_READY_FD";
+
+/// The first fd systemd passes, `SD_LISTEN_FDS_START`.
+#[cfg(unix)]
+const SYSTEMD_FD_START: i32 = 3;
+
+/// The listener handed over by the previous process or systemd, `None` when
+/// this process has to bind its own.
+#[cfg(unix)]
+pub fn inherited_listener() -> eyre::Result<Option<std::net::TcpListener>> {
+    use std::os::fd::FromRawFd as _;
+
+    let fd = match from_systemd() {
+        Some(fd) => fd,
+        None => match std::env::var(LISTEN_FD_ENV) {
+            Ok(fd) => fd
+                .parse()
+                .map_err(|_| eyre::eyre!("invalid {}: {}", LISTEN_FD_ENV, fd))?,
+            Err(_) => return Ok(None),
+        },
+    };
+
+    // SAFETY: the fd was left open for us by the parent, nothing else in the
+    // process owns it
+    let listener = unsafe { std::net::TcpListener::from_raw_fd(fd) };
+    listener.set_nonblocking(true)?;
+    // a leftover variable pointing at some other fd fails here
+    listener.local_addr()?;
+    set_cloexec(fd, true)?;
+    Ok(Some(listener))
+}
+
+#[cfg(not(unix))]
+pub fn inherited_listener() -> eyre::Result<Option<std::net::TcpListener>> {
+    Ok(None)
+}
+
+#[cfg(unix)]
+fn from_systemd() -> Option<i32> {
+    let pid: u32 = std::env::var("LISTEN_PID").ok()?.parse().ok()?;
+    let fds: i32 = std::env::var("LISTEN_FDS").ok()?.parse().ok()?;
+    // the variables are inherited by our own children as well
+    (pid == std::process::id() && fds >= 1).then_some(SYSTEMD_FD_START)
+}
+
+#[cfg(unix)]
+fn set_cloexec(fd: i32, cloexec: bool) -> std::io::Result<()> {
+    // SAFETY: plain fcntl calls on an fd we own
+    unsafe {
+        let flags = libc::fcntl(fd, libc::F_GETFD);
+        if flags < 0 {
+            return Err(std::io::Error::last_os_error());
+        }
+        let flags = match cloexec {
+            true => flags | libc::FD_CLOEXEC,
+            false => flags & !libc::FD_CLOEXEC,
+        };
+        if libc::fcntl(fd, libc::F_SETFD, flags) < 0 {
+            return Err(std::io::Error::last_os_error());
+        }
+    }
+    Ok(())
+}
+
+/// Tells the process which started us that we are serving. Does nothing
+/// when there is none.
+pub fn notify_ready() {
+    #[cfg(unix)]
+    {
+        use std::{io::Write as _, os::fd::FromRawFd as _};
+
+        static NOTIFIED: std::sync::Once = std::sync::Once::new();
+        let Some(fd) = std::env::var(READY_FD_ENV)
+            .ok()
+            .and_then(|fd| fd.parse::<i32>().ok())
+        else {
+            return;
+        };
+        NOTIFIED.call_once(|| {
+            // SAFETY: the write end of the pipe was left open for us, dropping
+            // the file closes it
+            let mut pipe = unsafe { std::fs::File::from_raw_fd(fd) };
+            if let Err(e) = pipe.write_all(b"1") {
+                tracing::warn!("failed to report readiness to the old process: {}", e);
+            }
+        });
+    }
+}
+
+/// Starts the replacement process on `listener` and waits until it is
+/// ready. On an error the replacement is killed and this process keeps
+/// serving.
+#[cfg(unix)]
+pub async fn restart(listener: &std::net::TcpListener, timeout: Duration) -> eyre::Result<()> {
+    use std::os::fd::{AsRawFd as _, FromRawFd as _, OwnedFd};
+
+    use tokio::io::AsyncReadExt as _;
+
+    let listen_fd = listener.as_raw_fd();
+    let mut pipe = [0; 2];
+    // SAFETY: pipe fills both fds on success
+    if unsafe { libc::pipe(pipe.as_mut_ptr()) } < 0 {
+        return Err(std::io::Error::last_os_error().into());
+    }
+    // SAFETY: both ends were just created and are owned here
+    let (read_end, write_end) =
+        unsafe { (OwnedFd::from_raw_fd(pipe[0]), OwnedFd::from_raw_fd(pipe[1])) };
+    // only the replacement gets the write end, not whatever else we spawn
+    set_cloexec(pipe[0], true)?;
+    set_cloexec(pipe[1], true)?;
+    let ready_fd = pipe[1];
+
+    let mut command = tokio::process::Command::new(std::env::current_exe()?);
+    command
+        .args(std::env::args_os().skip(1))
+        .env(LISTEN_FD_ENV, listen_fd.to_string())
+        .env(READY_FD_ENV, ready_fd.to_string())
+        // the replacement reads its listener from our variable, not systemd's
+        .env_remove("LISTEN_PID")
+        .env_remove("LISTEN_FDS")
+        // names us, the replacement pings the watchdog as the new main pid
+        .env_remove("WATCHDOG_PID");
+    // SAFETY: only async-signal-safe fcntl calls between fork and exec
+    unsafe {
+        command.pre_exec(move || {
+            set_cloexec(listen_fd, false)?;
+            set_cloexec(ready_fd, false)
+        });
+    }
+    let mut child = command.spawn()?;
+    // only the child may hold the write end, or the read below never sees
+    // it exit
+    drop(write_end);
+
+    let mut ready = tokio::fs::File::from_std(std::fs::File::from(read_end));
+    let mut buf = [0u8; 1];
+    let result = tokio::select! {
+        read = ready.read(&mut buf) => match read {
+            Ok(1) => Ok(()),
+            Ok(_) => Err(eyre::eyre!("replacement exited before it was ready")),
+            Err(e) => Err(e.into()),
+        },
+        _ = tokio::time::sleep(timeout) => {
+            Err(eyre::eyre!("replacement was not ready within {:?}", timeout))
+        }
+    };
+
+    match result {
+        // left running when we exit, it is reparented to init
+        Ok(()) => {
+            tracing::info!(pid = child.id(), "replacement process is ready");
+            Ok(())
+        }
+        Err(e) => {
+            let _ = child.kill().await;
+            Err(e)
+        }
+    }
+}
+
+#[cfg(not(unix))]
+pub async fn restart(_listener: &std::net::TcpListener, _timeout: Duration) -> eyre::Result<()> {
+    eyre::bail!("restarting with the socket handed over is unix only")
+}
+
+/// Resolves on SIGUSR2, pending forever where there are no signals.
+pub async fn restart_requested() {
+    #[cfg(unix)]
+    {
+        use tokio::signal::unix::{SignalKind, signal};
+
+        match signal(SignalKind::user_defined2()) {
+            Ok(mut sigusr2) => {
+                sigusr2.recv().await;
+                return;
+            }
+            Err(e) => tracing::warn!("failed to listen for SIGUSR2: {}", e),
+        }
+    }
+    std::future::pending::<()>().await
+}
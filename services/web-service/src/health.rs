@@ -0,0 +1,103 @@
+use std::{
+    collections::BTreeMap,
+    sync::{Arc, atomic::Ordering},
+    time::Duration,
+};
+
+use axum::{Json, extract::State, http::StatusCode};
+use mongodb::bson::doc;
+use serde::Serialize;
+
+use crate::state::AppState;
+
+/// Longest a single dependency may take to answer a readiness probe.
+const CHECK_TIMEOUT: Duration = Duration::from_secs(2);
+
+#[derive(Serialize)]
+pub struct HealthResponse {
+    status: &'static str,
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    checks: BTreeMap<&'static str, String>,
+}
+
+/// Liveness, answers as long as the process serves requests at all.
+pub async fn healthz() -> Json<HealthResponse> {
+    Json(HealthResponse {
+        status: "ok",
+        checks: BTreeMap::new(),
+    })
+}
+
+pub(crate) async fn check<F, E>(timeout: Duration, check: F) -> Result<(), String>
+where
+    F: Future<Output = Result<(), E>>,
+    E: std::fmt::Display,
+{
+    match tokio::time::timeout(timeout, check).await {
+        Ok(Ok(())) => Ok(()),
+        Ok(Err(e)) => Err(e.to_string()),
+        Err(_) => Err("timed out".to_string()),
+    }
+}
+
+/// Readiness, 503 until mongodb, redis and nats answer and the operators
+/// are loaded so ballots can be drawn.
+pub async fn readyz(State(state): State<Arc<AppState>>) -> (StatusCode, Json<HealthResponse>) {
+    if state.draining.load(Ordering::Relaxed) {
+        return (
+            StatusCode::SERVICE_UNAVAILABLE,
+            Json(HealthResponse {
+                status: "draining",
+                checks: BTreeMap::new(),
+            }),
+        );
+    }
+
+    let mongodb = check(CHECK_TIMEOUT, async {
+        state
+            ._mongodb
+            .run_command(doc! { "ping": 1 })
+            .await
+            .map(|_| ())
+    });
+    let redis = check(CHECK_TIMEOUT, async {
+        let mut conn = state.redis.connection.clone();
+        redis::cmd("PING").query_async::<()>(&mut conn).await
+    });
+    let (mongodb, redis) = tokio::join!(mongodb, redis);
+    let nats = match state.nats.connection_state() {
+        async_nats::connection::State::Connected => Ok(()),
+        other => Err(format!("{other:?}")),
+    };
+    let operators = match state.operator_service.character_infos().is_empty() {
+        false => Ok(()),
+        true => Err("no operators loaded".to_string()),
+    };
+
+    let results = [
+        ("mongodb", mongodb),
+        ("redis", redis),
+        ("nats", nats),
+        ("operators", operators),
+    ];
+    let ready = results.iter().all(|(_, result)| result.is_ok());
+    let checks: BTreeMap<_, _> = results
+        .into_iter()
+        .map(|(name, result)| (name, result.err().unwrap_or_else(|| "ok".to_string())))
+        .collect();
+    if !ready {
+        tracing::warn!(?checks, "readiness check failed");
+    }
+
+    let (status, label) = match ready {
+        true => (StatusCode::OK, "ok"),
+        false => (StatusCode::SERVICE_UNAVAILABLE, "unavailable"),
+    };
+    (
+        status,
+        Json(HealthResponse {
+            status: label,
+            checks,
+        }),
+    )
+}
@@ -0,0 +1,254 @@
+//! Import of vote dumps exported by the previous backend.
+//!
+//! The dump is one JSON document:
+//!
+//! ```json
+//! {
+//!   "topics": [{
+//!     "id": "2024-summer",
+//!     "name": "2024 夏季人气投票",
+//!     "open_time": "2024-07-01T00:00:00Z",
+//!     "close_time": "2024-07-14T00:00:00Z",
+//!     "valid_ballots": 120034,
+//!     "options": [{ "id": 1, "name": "棘刺" }, { "id": 2, "name": "Thorns" }],
+//!     "results": [{ "winner": 1, "loser": 2, "count": 42 }]
+//!   }]
+//! }
+//! ```
+//!
+//! Old option ids only mean something inside their dump, options are matched
+//! to current operators by name or alias. The `export` command writes the
+//! same format, see `ops::export`.
+
+use std::{collections::HashMap, path::Path};
+
+use chrono::{DateTime, Utc};
+use mongodb::bson::doc;
+use serde::{Deserialize, Serialize};
+use share::{
+    config::AppConfig,
+    http_client::HttpClient,
+    jobs::JobManager,
+    models::{
+        candidate_pool_preset::CandidatePoolPreset,
+        database::{
+            AuditCategory, AuditLogEntry, CreateTopicStatus, TopicAuditInfo, VotingTopic,
+            VotingTopicType,
+        },
+    },
+};
+use uuid::Uuid;
+
+use crate::{
+    load_local_character_infos,
+    service::{AuditLogService, OperatorService},
+};
+
+const AUDIT_ACTOR: &str = "legacy-import";
+
+#[derive(Debug, Deserialize, Serialize)]
+pub(crate) struct LegacyDump {
+    pub(crate) topics: Vec<LegacyTopic>,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+pub(crate) struct LegacyTopic {
+    pub(crate) id: String,
+    pub(crate) name: String,
+    #[serde(default)]
+    pub(crate) title: Option<String>,
+    #[serde(default)]
+    pub(crate) description: String,
+    pub(crate) open_time: DateTime<Utc>,
+    pub(crate) close_time: DateTime<Utc>,
+    #[serde(default)]
+    pub(crate) valid_ballots: i64,
+    pub(crate) options: Vec<LegacyOption>,
+    #[serde(default)]
+    pub(crate) results: Vec<LegacyMatchup>,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+pub(crate) struct LegacyOption {
+    pub(crate) id: i32,
+    pub(crate) name: String,
+}
+
+/// `count` ballots preferred `winner` over `loser`.
+#[derive(Debug, Deserialize, Serialize)]
+pub(crate) struct LegacyMatchup {
+    pub(crate) winner: i32,
+    pub(crate) loser: i32,
+    pub(crate) count: i64,
+}
+
+#[derive(Debug, Default)]
+struct TopicImport {
+    operator_ids: Vec<i32>,
+    /// Option names that resolve to no operator, their matchups are dropped.
+    unmapped: Vec<String>,
+    stats: HashMap<String, i64>,
+    matrix: HashMap<String, i64>,
+    dropped_matchups: usize,
+}
+
+impl TopicImport {
+    fn new(topic: &LegacyTopic, operators: &OperatorService) -> Self {
+        let mut import = TopicImport::default();
+        let mut option_map = HashMap::new();
+        for option in &topic.options {
+            match operators.resolve(&option.name) {
+                Some(id) => {
+                    option_map.insert(option.id, id);
+                    if !import.operator_ids.contains(&id) {
+                        import.operator_ids.push(id);
+                    }
+                }
+                None => import.unmapped.push(option.name.clone()),
+            }
+        }
+        import.operator_ids.sort_unstable();
+
+        for matchup in &topic.results {
+            let (Some(win), Some(lose)) = (
+                option_map.get(&matchup.winner),
+                option_map.get(&matchup.loser),
+            ) else {
+                import.dropped_matchups += 1;
+                continue;
+            };
+            // two old options can be the same operator, e.g. by CN and EN name
+            if win == lose {
+                import.dropped_matchups += 1;
+                continue;
+            }
+
+            // same layout the ballot consumer writes
+            *import.stats.entry(format!("{win}:win")).or_default() += matchup.count;
+            *import.stats.entry(format!("{lose}:lose")).or_default() += matchup.count;
+            *import.matrix.entry(format!("{win}:{lose}")).or_default() += matchup.count;
+            *import.matrix.entry(format!("{lose}:{win}")).or_default() -= matchup.count;
+        }
+
+        import
+    }
+}
+
+fn legacy_topic(topic: &LegacyTopic, operator_ids: Vec<i32>) -> VotingTopic {
+    let now = Utc::now();
+    VotingTopic {
+        id: topic.id.clone(),
+        name: topic.name.clone(),
+        title: topic.title.clone().unwrap_or_else(|| topic.name.clone()),
+        description: topic.description.clone(),
+        topic_type: VotingTopicType::Pairwise,
+        candidate_pool: CandidatePoolPreset::Custom { operator_ids },
+        excluded_operator_ids: Vec::new(),
+        created_at: now,
+        updated_at: None,
+        open_time: topic.open_time,
+        close_time: topic.close_time,
+        // closed topics are kept for their results only
+        is_active: false,
+        status: CreateTopicStatus::Approved(TopicAuditInfo {
+            auditor_id: Uuid::nil(),
+            auditor_name: AUDIT_ACTOR.to_string(),
+            audit_time: now,
+            audit_reason: "imported from the previous backend".to_string(),
+            audit_category: AuditCategory::ContentCompliance,
+        }),
+    }
+}
+
+/// Imports the topics of a legacy dump, backs the `import-legacy` command.
+/// Topics whose id already exists are skipped so the import can be rerun
+/// after adding aliases for unmapped options.
+pub async fn import_legacy(config: AppConfig, path: &Path, dry_run: bool) -> eyre::Result<()> {
+    let dump: LegacyDump = serde_json::from_slice(&tokio::fs::read(path).await?)?;
+
+    let mongodb = mongodb::Client::with_uri_str(&config.database.mongodb_url)
+        .await?
+        .database(&config.database.mongodb_database);
+    let redis_client = redis::Client::open(&*config.database.redis_url)?;
+    let mut connection = redis_client.get_multiplexed_async_connection().await?;
+
+    let http = HttpClient::new(&config.http_client)?;
+    let operators = OperatorService::new(mongodb.clone(), config.operator_sync, http);
+    if operators.refresh().await? == 0 {
+        tracing::warn!("operators collection is empty, resolving against the local table");
+        operators.set_character_infos(load_local_character_infos()?, HashMap::new());
+    }
+    let jobs = JobManager::new();
+    let audit_log = AuditLogService::new(mongodb.clone(), &config.audit_log, &jobs);
+    let topics = mongodb.collection::<VotingTopic>("topics");
+
+    let result = async {
+        for topic in &dump.topics {
+            if topics.find_one(doc! { "id": &topic.id }).await?.is_some() {
+                tracing::info!("topic {} already exists, skipped", topic.id);
+                continue;
+            }
+
+            let import = TopicImport::new(topic, &operators);
+            tracing::info!(
+                "topic {}: {} operators, {} matchups dropped",
+                topic.id,
+                import.operator_ids.len(),
+                import.dropped_matchups
+            );
+            if !import.unmapped.is_empty() {
+                tracing::warn!(
+                    "topic {}: no operator for options {}, add aliases and rerun to keep them",
+                    topic.id,
+                    import.unmapped.join(", ")
+                );
+            }
+            if dry_run || import.operator_ids.is_empty() {
+                continue;
+            }
+
+            // results first, a topic document only exists once they are complete
+            let stats_key = format!("{}:op_stats", topic.id);
+            let matrix_key = format!("{}:op_matrix", topic.id);
+            let stats: Vec<_> = import.stats.iter().collect();
+            let matrix: Vec<_> = import.matrix.iter().collect();
+            let mut pipe = redis::pipe();
+            pipe.atomic().del(&[&stats_key, &matrix_key]).set(
+                format!("{}:valid_ballots_count", topic.id),
+                topic.valid_ballots,
+            );
+            if !stats.is_empty() {
+                pipe.hset_multiple(&stats_key, &stats);
+            }
+            if !matrix.is_empty() {
+                pipe.hset_multiple(&matrix_key, &matrix);
+            }
+            pipe.query_async::<()>(&mut connection).await?;
+
+            let operator_count = import.operator_ids.len();
+            topics
+                .insert_one(legacy_topic(topic, import.operator_ids))
+                .await?;
+            audit_log
+                .record(AuditLogEntry::new(
+                    Some(topic.id.clone()),
+                    AUDIT_ACTOR,
+                    "topic_imported",
+                    format!(
+                        "{} operators, {} matchups dropped, unmapped: {}",
+                        operator_count,
+                        import.dropped_matchups,
+                        import.unmapped.join(", ")
+                    ),
+                ))
+                .await?;
+        }
+
+        Ok::<_, eyre::Report>(())
+    }
+    .await;
+    // the audit entries are written in the background
+    jobs.shutdown(config.server.shutdown_timeout()).await;
+
+    result
+}
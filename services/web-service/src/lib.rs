@@ -1,38 +1,97 @@
-use std::{net::SocketAddr, sync::Arc, time::Duration};
+use std::{
+    collections::HashMap,
+    net::SocketAddr,
+    sync::{
+        Arc,
+        atomic::{AtomicBool, Ordering},
+    },
+    time::Duration,
+};
 
 mod api;
 mod constants;
 mod error;
+mod handoff;
+mod health;
+mod legacy_import;
+mod live;
+mod middleware;
+mod notify;
+mod ops;
+mod outbox;
+mod prefork;
+mod queue;
+mod scheduler;
 mod service;
+mod startup_check;
 mod state;
+mod storage;
 mod task;
+mod tls;
+mod unix_socket;
 mod utils;
 mod worker_id;
 
 use async_nats::jetstream;
-use axum::{Json, Router, extract::State, routing::get};
+use axum::{
+    Json, Router,
+    extract::{DefaultBodyLimit, Request, State},
+    http::{HeaderValue, request},
+    middleware::{from_fn, from_fn_with_state},
+    routing::get,
+};
 use axum_prometheus::PrometheusMetricLayer;
 use dashmap::DashMap;
 use eyre::Context;
 use sentry::integrations::tower::{NewSentryLayer, SentryHttpLayer};
 use share::{
-    config::AppConfig,
-    models::{database::VotingTopic, excel::CharacterInfo},
+    config::{AppConfig, Dependency},
+    events::EventBus,
+    http_client::HttpClient,
+    jobs::{JobManager, Stage},
+    models::excel::{CharacterInfo, character_numeric_id},
+    reload::ConfigWatch,
+    secrets::SecretResolver,
     snowflake::Snowflake,
 };
 use socket2::{Domain, Socket, Type};
 use tower::ServiceBuilder;
-use tower_http::{cors::CorsLayer, timeout::TimeoutLayer, trace::TraceLayer};
+use tower_http::{
+    catch_panic::CatchPanicLayer,
+    cors::{AllowHeaders, AllowOrigin, CorsLayer},
+    request_id::{MakeRequestUuid, PropagateRequestIdLayer, SetRequestIdLayer},
+    timeout::TimeoutLayer,
+    trace::TraceLayer,
+};
 use utoipa::OpenApi as _;
 use utoipa_scalar::{Scalar, Servable as _};
 use utoipa_swagger_ui::SwaggerUi;
 
+#[doc(hidden)]
+pub use api::bench;
+pub use legacy_import::import_legacy;
+pub use ops::{export, migrate, seed, signing_secret, snapshot};
+pub use prefork::child_index as prefork_child_index;
+
 use crate::{
     api::ApiDoc,
-    constants::LUA_SCRIPT_GET_FINAL_ORDER,
     error::AppError,
-    service::TopicService,
+    live::LiveHub,
+    middleware::{load_shed::LoadShedder, rate_limit::RateLimits, route_stats::RouteStats},
+    outbox::Outbox,
+    queue::TaskQueue,
+    scheduler::{Scheduler, Scope},
+    service::{
+        AffinityService, AnnouncementService, ApiKeyService, AuditLogService, BookmarkService,
+        CommentService, ConsentService, DatasetService, DigestService, ErasureService,
+        HeatmapService, ImageProxyService, MaintenanceService, MerkleService, NotificationService,
+        OperatorService, OptionImageService, PresenceService, RegionService, ReportService,
+        ResultsArchiveService, ResultsSnapshotService, RetentionService, ScheduledActions,
+        ShareCardService, TopicPhaseWatcher, TopicService, TopicSync, TrendService,
+        VoterExportService, WebhookService, WordFilterService,
+    },
     state::{AppState, RedisService},
+    storage::Storage,
     task::TaskManager,
     worker_id::WorkerIdManager,
 };
@@ -42,7 +101,13 @@ pub async fn get_task_stats(State(state): State<Arc<AppState>>) -> Json<task::Ta
     Json(state.task_manager.get_stats())
 }
 
-fn make_reuseport_listener(addr: SocketAddr) -> eyre::Result<std::net::TcpListener> {
+/// `reuse_port` lets the prefork workers bind the same address, a single
+/// process binds exclusively so a stray second instance fails loudly.
+fn make_listener(
+    addr: SocketAddr,
+    reuse_port: bool,
+    dual_stack: bool,
+) -> eyre::Result<std::net::TcpListener> {
     let domain = match addr {
         SocketAddr::V4(_) => Domain::IPV4,
         SocketAddr::V6(_) => Domain::IPV6,
@@ -50,15 +115,68 @@ fn make_reuseport_listener(addr: SocketAddr) -> eyre::Result<std::net::TcpListen
     let socket = Socket::new(domain, Type::STREAM, None)?;
     socket.set_nonblocking(true)?;
     socket.set_reuse_address(true)?;
+    // the default differs between systems, and `::` would take v4 clients
+    // on linux only
+    if addr.is_ipv6() {
+        socket.set_only_v6(!dual_stack)?;
+    }
     #[cfg(unix)]
-    {
+    if reuse_port {
         socket.set_reuse_port(true)?;
     }
+    #[cfg(not(unix))]
+    if reuse_port {
+        eyre::bail!("prefork needs SO_REUSEPORT, which is unix only");
+    }
     socket.bind(&addr.into())?;
     socket.listen(8192)?;
     Ok(socket.into())
 }
 
+fn load_local_character_infos() -> Result<Vec<CharacterInfo>, AppError> {
+    let infos = utils::load_character_table()?
+        .into_iter()
+        .filter_map(|(name, data)| {
+            Some(CharacterInfo {
+                id: character_numeric_id(&name)?,
+                faction: data.faction().map(str::to_string),
+                name: data.name,
+                rarity: data.rarity,
+                profession: data.profession,
+                sub_profession_id: data.sub_profession_id,
+                is_not_obtainable: data.is_not_obtainable,
+            })
+        })
+        .collect();
+    Ok(infos)
+}
+
+/// Imports the operators once, backs the `sync-operators` command.
+pub async fn sync_operators(config: AppConfig) -> eyre::Result<()> {
+    let mongodb = mongodb::Client::with_uri_str(&config.database.mongodb_url)
+        .await
+        .context("failed to connect to MongoDB")?
+        .database(&config.database.mongodb_database);
+    let http = HttpClient::new(&config.http_client)?;
+
+    let portraits = utils::fetch_portrait_image_url(&http)
+        .await
+        .inspect_err(|e| tracing::warn!("failed to fetch portraits, syncing without: {}", e))
+        .unwrap_or_default();
+
+    let report = OperatorService::new(mongodb, config.operator_sync, http)
+        .sync(&portraits)
+        .await?;
+    for operator in &report.added {
+        tracing::info!("new operator {} ({})", operator.name, operator.id);
+    }
+    if !report.added.is_empty() {
+        // registration needs the topic caches of a running web server
+        tracing::info!("new operators join ongoing topics on the next scheduled sync");
+    }
+    Ok(())
+}
+
 pub struct WebService {
     config: AppConfig,
 }
@@ -69,6 +187,16 @@ impl WebService {
     }
 
     pub async fn run(self, mut shutdown_rx: share::signal::ShutdownRx) -> eyre::Result<()> {
+        let prefork_child = prefork::child_index();
+        if self.config.server.prefork() && prefork_child.is_none() {
+            return prefork::supervise(&self.config.server, shutdown_rx).await;
+        }
+
+        let http_client = HttpClient::new(&self.config.http_client)?;
+        tracing::debug!("HttpClient initialized");
+
+        let down = startup_check::run(&self.config, &http_client).await?;
+
         let nats_client = async_nats::connect(&self.config.nats.url)
             .await
             .context("failed to connect to nats")?;
@@ -80,8 +208,15 @@ impl WebService {
         let connection = redis_client.get_multiplexed_async_connection().await?;
         tracing::debug!("connected to redis at {}", &self.config.database.redis_url);
 
-        let mongodb_client = mongodb::Client::with_uri_str(&self.config.database.mongodb_url)
-            .await
+        let mut mongodb_options =
+            mongodb::options::ClientOptions::parse(&self.config.database.mongodb_url)
+                .await
+                .context("failed to parse the MongoDB url")?;
+        // fail a call rather than hold its request while no server is reachable
+        let mongodb_timeout = Duration::from_millis(self.config.database.mongodb_timeout_ms);
+        mongodb_options.server_selection_timeout = Some(mongodb_timeout);
+        mongodb_options.connect_timeout = Some(mongodb_timeout);
+        let mongodb_client = mongodb::Client::with_options(mongodb_options)
             .context("failed to connect to MongoDB")?;
         let mongodb = mongodb_client.database(&self.config.database.mongodb_database);
         tracing::debug!(
@@ -89,33 +224,19 @@ impl WebService {
             &self.config.database.mongodb_url,
             &self.config.database.mongodb_database
         );
-        use mongodb::bson::doc;
-        let collection = mongodb.collection::<VotingTopic>("topics");
+        ops::seed_preset_topics(&mongodb, &self.config.vote.preset_vote_topic).await?;
 
-        for preset_topic in &self.config.vote.preset_vote_topic {
-            let filter = doc! { "id": &preset_topic.id };
-
-            match collection.find_one(filter).await {
-                Ok(Some(_)) => {
-                    let query = doc! { "id": &preset_topic.id };
-                    collection.replace_one(query, preset_topic).await?;
-                    tracing::info!("updated preset voting topic: {}", preset_topic.id);
-                }
-                Ok(None) => {
-                    tracing::info!("inserting preset voting topic: {}", preset_topic.id);
-                    collection.insert_one(preset_topic).await?;
-                }
-                Err(_) => {
-                    // maybe data structure has changed, we force replace
-                    let query = doc! { "id": &preset_topic.id };
-                    collection.replace_one(query, preset_topic).await?;
-                }
-            }
-        }
+        // every loop outliving a request is spawned here, shutdown stops
+        // them stage by stage once the servers are down
+        let jobs = JobManager::new();
 
         let manager = Arc::new(WorkerIdManager::new(connection.clone(), 255)?);
         let worker_id = manager.acquire().await?;
-        manager.clone().keep_alive().await;
+        jobs.spawn(
+            "worker_id_keep_alive",
+            Stage::Worker,
+            manager.clone().keep_alive(),
+        );
         tracing::info!("acquired worker_id: {}", worker_id);
 
         let snowflake = Snowflake::new(
@@ -128,52 +249,463 @@ impl WebService {
             &self.config.snowflake
         );
 
-        let character_infos: Vec<CharacterInfo> = utils::load_character_table()?
-            .into_iter()
-            .filter_map(|(name, data)| {
-                if !name.starts_with("char_") {
-                    return None;
+        // the tunable sections and rotated secrets are re-read on SIGHUP, from
+        // the admin server and every `secrets.refresh_secs`
+        let reload =
+            ConfigWatch::new(self.config.clone(), share::config::CONFIG_PATH).with_secrets(
+                SecretResolver::new(&self.config.secrets, http_client.clone()),
+            );
+        reload.spawn_reloader();
+
+        let portraits_required = self
+            .config
+            .startup_check
+            .required
+            .contains(&Dependency::Prts);
+        let character_portraits = match down.contains(&Dependency::Prts) {
+            true => HashMap::new(),
+            false => match utils::fetch_portrait_image_url(&http_client).await {
+                Ok(portraits) => portraits,
+                Err(e) if !portraits_required => {
+                    tracing::warn!("failed to fetch character portraits: {}", e);
+                    HashMap::new()
                 }
-                let mut parts = name["char_".len()..].splitn(2, '_');
-                let charid = parts.next()?.parse::<i32>().ok()?;
-                Some(CharacterInfo {
-                    id: charid,
-                    name: data.name,
-                    rarity: data.rarity,
-                    profession: data.profession,
-                    sub_profession_id: data.sub_profession_id,
-                    is_not_obtainable: data.is_not_obtainable,
-                })
-            })
-            .collect();
-        tracing::debug!("Character infos loaded: {}", character_infos.len());
+                Err(e) => return Err(e.into()),
+            },
+        };
+        tracing::debug!("Character portraits fetched: {}", character_portraits.len());
 
-        let character_portraits = utils::fetch_portrait_image_url().await?;
-        tracing::debug!("Character portraits fetched");
+        let operator_service = OperatorService::new(
+            mongodb.clone(),
+            self.config.operator_sync.clone(),
+            http_client.clone(),
+        );
+        match operator_service.refresh().await {
+            Ok(count) if count > 0 => {}
+            result => {
+                if let Err(e) = result {
+                    tracing::warn!("failed to load synced operators: {}", e);
+                }
+                operator_service.set_character_infos(load_local_character_infos()?, HashMap::new());
+            }
+        }
+        tracing::debug!(
+            "Character infos loaded: {}",
+            operator_service.character_infos().len()
+        );
 
         let topic_service = TopicService::new(mongodb.clone());
+        jobs.spawn(
+            "topic_cache",
+            Stage::Worker,
+            topic_service.keep_cache_fresh(),
+        );
         tracing::debug!("TopicService initialized");
 
+        let audit_log_service =
+            AuditLogService::new(mongodb.clone(), &self.config.audit_log, &jobs);
+        tracing::debug!("AuditLogService initialized");
+
+        let api_key_service = ApiKeyService::new(mongodb.clone(), self.config.api_key.clone());
+        tracing::debug!("ApiKeyService initialized");
+
+        let mut config_rx = reload.subscribe();
+        let static_keys = api_key_service.clone();
+        jobs.spawn("static_api_keys", Stage::Worker, async move {
+            while config_rx.changed().await.is_ok() {
+                let keys = config_rx.borrow_and_update().api_key.static_keys.clone();
+                static_keys.set_static_keys(&keys);
+            }
+        });
+
+        let presence_service = PresenceService::new(connection.clone());
+        jobs.spawn(
+            "presence_heartbeat",
+            Stage::Worker,
+            presence_service.clone().heartbeat(),
+        );
+        jobs.spawn_graceful("presence_flush", Stage::Flusher, {
+            let presence_service = presence_service.clone();
+            |stopping| presence_service.flush_touched(stopping)
+        });
+        let live_hub = LiveHub::new(
+            nats_client.clone(),
+            connection.clone(),
+            presence_service.clone(),
+            &self.config.live,
+        );
+        jobs.spawn("live_hub", Stage::Worker, {
+            let live_hub = live_hub.clone();
+            async move {
+                if let Err(e) = live_hub.run().await {
+                    tracing::error!("live hub stopped: {}", e);
+                }
+            }
+        });
+        tracing::debug!("LiveHub initialized");
+
+        let region_service = RegionService::new(connection.clone(), reload.clone());
+        jobs.spawn("geoip_watch", Stage::Worker, region_service.clone().watch());
+        jobs.spawn_graceful("region_flush", Stage::Flusher, {
+            let region_service = region_service.clone();
+            |stopping| region_service.flush_counts(stopping)
+        });
+        tracing::debug!("RegionService initialized");
+
+        let topic_sync = TopicSync::new(
+            nats_client.clone(),
+            topic_service.clone(),
+            operator_service.clone(),
+            live_hub.clone(),
+        );
+        jobs.spawn("topic_sync", Stage::Worker, {
+            let topic_sync = topic_sync.clone();
+            async move {
+                if let Err(e) = topic_sync.run().await {
+                    tracing::error!("topic sync stopped: {}", e);
+                }
+            }
+        });
+        tracing::debug!("TopicSync initialized");
+
+        if self.config.operator_sync.enabled {
+            jobs.spawn(
+                "operator_sync",
+                Stage::Scheduler,
+                operator_service.clone().run(
+                    character_portraits.clone(),
+                    topic_service.clone(),
+                    topic_sync.clone(),
+                    audit_log_service.clone(),
+                ),
+            );
+        }
+        tracing::debug!("OperatorService initialized");
+
+        let image_proxy_service =
+            ImageProxyService::new(self.config.image_proxy.clone(), http_client.clone());
+        tracing::debug!("ImageProxyService initialized");
+
+        let storage = Storage::from_config(&self.config.storage);
+        let option_image_service =
+            OptionImageService::new(mongodb.clone(), storage.clone(), &self.config.storage);
+        tracing::debug!("OptionImageService initialized");
+
+        let events = EventBus::connect(&self.config.events, &nats_client)
+            .await
+            .context("failed to connect event bus")?;
+        tracing::debug!("EventBus initialized");
+
+        let outbox = Outbox::new(mongodb.clone(), events, self.config.events.outbox.clone());
+        outbox.start(&jobs);
+        tracing::debug!("Outbox initialized");
+
+        let task_queue = TaskQueue::new(mongodb.clone(), self.config.task_queue.clone());
+        tracing::debug!("TaskQueue initialized");
+
+        let webhook_service = WebhookService::new(
+            mongodb.clone(),
+            self.config.webhook.clone(),
+            http_client.clone(),
+            task_queue.clone(),
+        );
+        tracing::debug!("WebhookService initialized");
+
+        let notification_service =
+            NotificationService::new(&self.config.notify, http_client.clone(), task_queue.clone())
+                .context("failed to set up notification channels")?;
+        tracing::debug!("NotificationService initialized");
+
+        let retention_service =
+            RetentionService::new(mongodb.clone(), topic_service.clone(), reload.clone());
+        tracing::debug!("RetentionService initialized");
+
+        let dataset_service = DatasetService::new(
+            mongodb.clone(),
+            storage.clone(),
+            task_queue.clone(),
+            topic_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("DatasetService initialized");
+
+        let results_archive = ResultsArchiveService::new(
+            mongodb.clone(),
+            connection.clone(),
+            task_queue.clone(),
+            topic_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("ResultsArchiveService initialized");
+
+        let bookmark_service = BookmarkService::new(
+            mongodb.clone(),
+            connection.clone(),
+            topic_service.clone(),
+            notification_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("BookmarkService initialized");
+
+        let merkle_service =
+            MerkleService::new(mongodb.clone(), storage.clone(), topic_service.clone());
+        tracing::debug!("MerkleService initialized");
+
+        let results_snapshots = ResultsSnapshotService::new(connection.clone(), reload.clone());
+        results_snapshots.start(topic_service.clone(), &jobs);
+        tracing::debug!("ResultsSnapshotService initialized");
+
+        let digest_service = DigestService::new(
+            mongodb.clone(),
+            results_snapshots.clone(),
+            topic_service.clone(),
+            webhook_service.clone(),
+            outbox.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("DigestService initialized");
+
+        let heatmap_service =
+            HeatmapService::new(mongodb.clone(), topic_service.clone(), reload.clone());
+        tracing::debug!("HeatmapService initialized");
+
+        let mut scheduler = Scheduler::new(self.config.scheduler.clone(), connection.clone());
+        let phase_watcher = Arc::new(TopicPhaseWatcher::new(
+            topic_service.clone(),
+            connection.clone(),
+            outbox.clone(),
+            webhook_service.clone(),
+            notification_service.clone(),
+            dataset_service.clone(),
+            results_archive.clone(),
+            bookmark_service.clone(),
+        ));
+        scheduler.add("topic_phase", Scope::Once, move || {
+            let phase_watcher = phase_watcher.clone();
+            async move {
+                phase_watcher.check_all().await;
+                Ok(())
+            }
+        });
+        scheduler.add("topic_cache_refresh", Scope::Local, {
+            let topic_service = topic_service.clone();
+            move || {
+                let topic_service = topic_service.clone();
+                async move {
+                    topic_service.refresh_cache().await?;
+                    Ok(())
+                }
+            }
+        });
+        scheduler.add("webhook_delivery_prune", Scope::Once, {
+            let webhook_service = webhook_service.clone();
+            move || {
+                let webhook_service = webhook_service.clone();
+                async move {
+                    let deleted = webhook_service.prune_deliveries().await?;
+                    tracing::info!("pruned {} webhook deliveries", deleted);
+                    Ok(())
+                }
+            }
+        });
+        scheduler.add("retention_prune", Scope::Once, {
+            let retention_service = retention_service.clone();
+            move || {
+                let retention_service = retention_service.clone();
+                async move { retention_service.scheduled_run().await }
+            }
+        });
+        scheduler.add("merkle_commit", Scope::Once, {
+            let merkle_service = merkle_service.clone();
+            move || {
+                let merkle_service = merkle_service.clone();
+                async move { merkle_service.commit_all().await }
+            }
+        });
+        scheduler.add("daily_digest", Scope::Once, {
+            let digest_service = digest_service.clone();
+            move || {
+                let digest_service = digest_service.clone();
+                async move { digest_service.compile_all().await }
+            }
+        });
+        scheduler.add("heatmap_rollup", Scope::Once, {
+            let heatmap_service = heatmap_service.clone();
+            move || {
+                let heatmap_service = heatmap_service.clone();
+                async move { heatmap_service.rollup_all().await }
+            }
+        });
+        scheduler.start(&jobs);
+        tracing::debug!("Scheduler initialized");
+
+        let affinity_service =
+            AffinityService::new(mongodb.clone(), results_snapshots.clone(), reload.clone());
+        tracing::debug!("AffinityService initialized");
+
+        let trend_service =
+            TrendService::new(mongodb.clone(), results_snapshots.clone(), reload.clone());
+        tracing::debug!("TrendService initialized");
+
         let task_manager = TaskManager::new(self.config.task_manager.concurrency);
         tracing::debug!("TaskManager initialized");
 
+        let load_shedder = LoadShedder::new(reload.clone(), task_manager.clone());
+        jobs.spawn("load_shed", Stage::Worker, load_shedder.clone().run());
+        tracing::debug!("LoadShedder initialized");
+
+        let rate_limits = RateLimits::new(
+            &self.config.rate_limit,
+            &self.config.api_key.header,
+            self.config.vote.ipv6_prefix_len,
+        );
+        jobs.spawn(
+            "rate_limit_prune",
+            Stage::Worker,
+            rate_limits.clone().prune(),
+        );
+        tracing::debug!("RateLimits initialized");
+
+        let route_stats = RouteStats::new(self.config.slo.clone());
+        tracing::debug!("RouteStats initialized");
+
+        let maintenance = MaintenanceService::new(
+            connection.clone(),
+            reload.clone(),
+            notification_service.clone(),
+        );
+        jobs.spawn(
+            "maintenance_poll",
+            Stage::Worker,
+            maintenance.clone().poll(),
+        );
+        tracing::debug!("MaintenanceService initialized");
+
+        let scheduled_actions = ScheduledActions::new(
+            task_queue.clone(),
+            maintenance.clone(),
+            api_key_service.clone(),
+            dataset_service.clone(),
+        );
+        tracing::debug!("ScheduledActions initialized");
+
+        let erasure_service = ErasureService::new(
+            mongodb.clone(),
+            task_queue.clone(),
+            audit_log_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("ErasureService initialized");
+
+        let voter_export_service = VoterExportService::new(
+            mongodb.clone(),
+            task_queue.clone(),
+            audit_log_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("VoterExportService initialized");
+
+        let consent_service =
+            ConsentService::new(mongodb.clone(), connection.clone(), reload.clone());
+        tracing::debug!("ConsentService initialized");
+
+        let word_filter_service = WordFilterService::new(reload.clone());
+        jobs.spawn(
+            "word_filter_watch",
+            Stage::Worker,
+            word_filter_service.clone().watch(),
+        );
+        tracing::debug!("WordFilterService initialized");
+
+        let comment_service = CommentService::new(
+            mongodb.clone(),
+            topic_service.clone(),
+            webhook_service.clone(),
+            outbox.clone(),
+            audit_log_service.clone(),
+            word_filter_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("CommentService initialized");
+
+        let report_service = ReportService::new(
+            mongodb.clone(),
+            comment_service.clone(),
+            topic_service.clone(),
+            audit_log_service.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("ReportService initialized");
+
+        let announcement_service =
+            AnnouncementService::new(mongodb.clone(), audit_log_service.clone());
+        tracing::debug!("AnnouncementService initialized");
+
+        let share_card_service = ShareCardService::new(
+            image_proxy_service.clone(),
+            character_portraits.clone(),
+            reload.clone(),
+        );
+        tracing::debug!("ShareCardService initialized");
+
+        // after every handler is registered, a due task of an unknown kind
+        // would be marked dead
+        task_queue.start(&jobs);
+        tracing::debug!("TaskQueue started");
+
         let state = AppState {
+            nats: nats_client.clone(),
             jetstream,
             redis: RedisService {
                 _client: redis_client,
                 connection,
-                final_order_script: redis::Script::new(LUA_SCRIPT_GET_FINAL_ORDER),
             },
             _mongodb: mongodb,
             snowflake,
-            character_infos,
+            config: reload.clone(),
             character_portraits,
 
             topic_service,
+            topic_sync,
+            api_key_service,
+            audit_log_service,
+            operator_service,
+            image_proxy_service,
+            option_image_service,
+            live_hub,
+            presence_service,
+            region_service,
+            results_snapshots,
+            affinity_service,
+            digest_service,
+            heatmap_service,
+            trend_service,
+            outbox,
+            webhook_service,
+            task_queue,
+            scheduled_actions,
+            erasure_service,
+            voter_export_service,
+            consent_service,
+            comment_service,
+            report_service,
+            word_filter_service,
+            announcement_service,
+            share_card_service,
+            retention_service,
+            dataset_service,
+            results_archive,
+            bookmark_service,
+            merkle_service,
+            route_stats: route_stats.clone(),
+            maintenance: maintenance.clone(),
 
             bench_ballot_store: DashMap::new(),
+            draining: AtomicBool::new(false),
             task_manager,
+            jobs: jobs.clone(),
         };
+        let state = Arc::new(state);
         tracing::debug!("AppState initialized");
 
         let sentry_layer = ServiceBuilder::new()
@@ -185,47 +717,121 @@ impl WebService {
         tracing::debug!("Prometheus metrics layer initialized");
 
         let cors_layer = {
-            let allow_methods = self
-                .config
-                .cors
+            let cors = &self.config.cors;
+            let allow_methods = cors
                 .allow_methods
                 .iter()
                 .filter_map(|s| s.parse().ok())
                 .collect::<Vec<_>>();
 
+            // mirroring instead of `*` works with credentials as well
             let cors_builder = CorsLayer::new()
                 .allow_methods(allow_methods)
-                .allow_headers(tower_http::cors::Any)
+                .allow_headers(AllowHeaders::mirror_request())
+                .allow_credentials(cors.allow_credentials)
                 .max_age(Duration::from_secs(3600));
 
-            match self.config.cors.allow_origin.as_slice() {
-                [single] if single == "*" => cors_builder.allow_origin(tower_http::cors::Any),
-                origins => {
-                    let allow_origin = origins
+            match cors.any_origin() {
+                true => cors_builder.allow_origin(tower_http::cors::Any),
+                false => {
+                    let origins = cors
+                        .allow_origin
                         .iter()
-                        .filter_map(|s| s.parse().ok())
+                        .filter_map(|s| s.parse::<HeaderValue>().ok())
                         .collect::<Vec<_>>();
-                    cors_builder.allow_origin(allow_origin)
+                    let regex = cors
+                        .origin_regex()
+                        .context("invalid cors.allow_origin_regex")?;
+                    cors_builder.allow_origin(AllowOrigin::predicate(
+                        move |origin: &HeaderValue, _: &request::Parts| {
+                            origins.contains(origin)
+                                || regex.as_ref().is_some_and(|regex| {
+                                    origin.to_str().is_ok_and(|origin| regex.is_match(origin))
+                                })
+                        },
+                    ))
                 }
             }
         };
         tracing::debug!("CORS layer initialized");
 
-        let app = Router::new()
+        let mut router = Router::new()
             .route("/", get(|| async { "Hello, world!" }))
             .route("/metrics", get(|| async move { metric_handle.render() }))
             .route("/task_stats", get(get_task_stats))
-            .merge(api::routes())
+            .route("/healthz", get(health::healthz))
+            .route("/readyz", get(health::readyz))
+            .merge(
+                api::routes(&state)
+                    .layer(from_fn_with_state(
+                        maintenance,
+                        middleware::maintenance::maintenance,
+                    ))
+                    .layer(from_fn_with_state(
+                        rate_limits,
+                        middleware::rate_limit::rate_limit,
+                    ))
+                    .layer(from_fn_with_state(
+                        load_shedder,
+                        middleware::load_shed::load_shed,
+                    )),
+            )
+            .merge(live::live_routes())
             .merge(SwaggerUi::new("/docs").url("/api-doc/openapi.json", ApiDoc::openapi()))
-            .merge(Scalar::with_url("/scalar", ApiDoc::openapi()))
-            .with_state(Arc::new(state))
+            .merge(Scalar::with_url("/scalar", ApiDoc::openapi()));
+        if self.config.graphql.enabled {
+            router = router.merge(api::graphql_routes(&state, &self.config.graphql));
+            tracing::debug!("GraphQL endpoint enabled");
+        }
+        if let Some((root, prefix)) = storage.local_mount() {
+            router = router.nest_service(prefix, tower_http::services::ServeDir::new(root));
+            tracing::debug!("serving uploads from {} at {}", root.display(), prefix);
+        }
+
+        let app = router
+            .with_state(state.clone())
+            .layer(CatchPanicLayer::custom(error::recover_panic))
+            .layer(from_fn_with_state(
+                route_stats,
+                middleware::route_stats::track_route,
+            ))
+            .layer(from_fn(middleware::request_id::scope_request_id))
             .layer(cors_layer)
             .layer(sentry_layer)
             .layer((
-                TraceLayer::new_for_http(),
-                TimeoutLayer::new(Duration::from_secs(60)),
+                TraceLayer::new_for_http()
+                    .make_span_with(|request: &Request| {
+                        let request_id = request
+                            .headers()
+                            .get(&middleware::request_id::REQUEST_ID_HEADER)
+                            .and_then(middleware::request_id::parse)
+                            .unwrap_or_default();
+                        let span = tracing::info_span!(
+                            "request",
+                            otel.name = %format!("{} {}", request.method(), request.uri().path()),
+                            otel.kind = "server",
+                            method = %request.method(),
+                            uri = %request.uri(),
+                            version = ?request.version(),
+                            request_id,
+                        );
+                        share::tracing::set_remote_parent(&span, request.headers());
+                        span
+                    })
+                    // the access log covers failures too, and handlers log the
+                    // cause of a 5xx themselves
+                    .on_request(())
+                    .on_response(middleware::access_log::AccessLog::new(reload.clone()))
+                    .on_failure(()),
+                TimeoutLayer::new(self.config.server.request_timeout()),
+                DefaultBodyLimit::max(self.config.server.max_body_bytes),
             ))
-            .layer(prometheus_layer);
+            .layer(prometheus_layer)
+            .layer((
+                from_fn(middleware::request_id::sanitize_request_id),
+                SetRequestIdLayer::new(middleware::request_id::REQUEST_ID_HEADER, MakeRequestUuid),
+                PropagateRequestIdLayer::new(middleware::request_id::REQUEST_ID_HEADER),
+            ));
         tracing::debug!("Router initialized");
 
         let bind_addr = self
@@ -236,25 +842,138 @@ impl WebService {
             .context("invalid bind address")?;
         tracing::debug!("Parsed bind address: {}", bind_addr);
 
-        let listener = make_reuseport_listener(bind_addr)?;
-        let listener = tokio::net::TcpListener::from_std(listener)?;
+        // prefork workers each bind their own socket
+        let inherited = match self.config.server.prefork() {
+            true => None,
+            false => handoff::inherited_listener()?,
+        };
+        let listener = match inherited {
+            Some(listener) => {
+                tracing::info!(
+                    "serving on the inherited listener {}",
+                    listener.local_addr()?
+                );
+                listener
+            }
+            None => make_listener(
+                bind_addr,
+                self.config.server.prefork(),
+                self.config.server.dual_stack,
+            )?,
+        };
+        // kept to hand over on SIGUSR2
+        let handoff_listener = listener.try_clone()?;
 
-        tracing::info!("starting web service on {}", bind_addr);
+        match prefork_child {
+            Some(index) => tracing::info!("starting prefork worker {} on {}", index, bind_addr),
+            None => tracing::info!("starting web service on {}", bind_addr),
+        }
 
-        tokio::spawn(async move {
-            axum::serve(
-                listener,
-                app.into_make_service_with_connect_info::<SocketAddr>(),
-            )
-            .await
-            .expect("failed to start web service");
-        });
+        let stop = Arc::new(tokio::sync::Notify::new());
+        let unix_server = match &self.config.server.unix_socket {
+            Some(path) => Some(unix_socket::serve(
+                &self.config.server,
+                path,
+                app.clone(),
+                stop.clone(),
+            )?),
+            None => None,
+        };
+        let server = match self.config.tls.enabled {
+            true => tls::serve(&self.config.tls, listener, app, stop.clone(), &jobs).await?,
+            false => {
+                let listener = tokio::net::TcpListener::from_std(listener)?;
+                let stop = stop.clone();
+                tokio::spawn(async move {
+                    axum::serve(
+                        listener,
+                        app.into_make_service_with_connect_info::<SocketAddr>(),
+                    )
+                    .with_graceful_shutdown(async move { stop.notified().await })
+                    .await
+                })
+            }
+        };
 
         tracing::info!("web service started successfully");
-        shutdown_rx.changed().await?;
+        handoff::notify_ready();
+        // the supervisor reports for the prefork workers
+        if prefork_child.is_none() {
+            share::systemd::ready();
+        }
+
+        let handed_over = loop {
+            tokio::select! {
+                changed = shutdown_rx.changed() => {
+                    changed?;
+                    break false;
+                }
+                _ = prefork::parent_gone(), if prefork_child.is_some() => break false,
+                _ = handoff::restart_requested(), if !self.config.server.prefork() => {
+                    tracing::info!("SIGUSR2, starting the replacement process");
+                    match handoff::restart(
+                        &handoff_listener,
+                        self.config.server.restart_timeout(),
+                    )
+                    .await
+                    {
+                        Ok(()) => break true,
+                        Err(e) => tracing::error!("restart failed, still serving: {}", e),
+                    }
+                }
+            }
+        };
+        drop(handoff_listener);
+        // the replacement is the main process now, it is not stopping
+        if prefork_child.is_none() && !handed_over {
+            share::systemd::stopping();
+        }
+
+        // after a handover the replacement accepts on the same socket, there
+        // is nothing for the load balancer to notice
+        if !handed_over {
+            // keep serving while the load balancer notices the failing
+            // readiness probe, closing the listener first would refuse its
+            // last requests
+            state.draining.store(true, Ordering::Relaxed);
+            let drain_delay = self.config.server.drain_delay();
+            tracing::info!("draining web service for {:?}", drain_delay);
+            tokio::time::sleep(drain_delay).await;
+        }
 
         tracing::info!("shutting down web service");
+        stop.notify_waiters();
+        let servers = async {
+            let result = server.await;
+            if let Some(unix_server) = unix_server {
+                match unix_server.await {
+                    Ok(Ok(())) => {}
+                    Ok(Err(e)) => tracing::error!("unix socket server failed: {}", e),
+                    Err(e) => tracing::error!("unix socket server task failed: {}", e),
+                }
+            }
+            result
+        };
+        let shutdown_timeout = self.config.server.shutdown_timeout();
+        match tokio::time::timeout(shutdown_timeout, servers).await {
+            Ok(Ok(result)) => result.context("web service failed")?,
+            Ok(Err(e)) => tracing::error!("web service task failed: {}", e),
+            Err(_) => tracing::warn!(
+                "in-flight requests did not finish within {:?}, closing them",
+                shutdown_timeout
+            ),
+        }
+
+        // the ballots are written by the consumer, what is left here are the
+        // presence batch and the publishes still buffered in the nats client
+        jobs.shutdown(shutdown_timeout).await;
         nats_client.drain().await?;
+        mongodb_client.shutdown().await;
+        // after a handoff the path is the replacement's socket
+        if !handed_over && let Some(path) = &self.config.server.unix_socket {
+            let _ = std::fs::remove_file(path);
+        }
+        tracing::info!("web service stopped");
 
         Ok(())
     }
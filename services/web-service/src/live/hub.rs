@@ -0,0 +1,382 @@
+use std::{collections::HashMap, sync::Arc, time::Duration};
+
+use share::config::{LiveConfig, SlowClientPolicy};
+
+use dashmap::DashMap;
+use futures::StreamExt as _;
+use redis::AsyncCommands as _;
+use share::models::live::{
+    LIVE_SUBJECT_PREFIX, LiveCursor, LiveEvent, LiveOperatorDelta, LiveScoreUpdate, notify_subject,
+};
+use tokio::sync::broadcast;
+
+use crate::{
+    error::AppError,
+    live::{TopicSubscription, queue::ConnectionQueue, ranking::RankingState},
+    service::PresenceService,
+};
+
+/// Messages buffered per topic before slow subscribers start lagging.
+const TOPIC_CHANNEL_CAPACITY: usize = 256;
+const PRESENCE_BROADCAST_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Score changes of a topic received since the last broadcast.
+#[derive(Default)]
+struct PendingDelta {
+    operators: HashMap<i32, LiveOperatorDelta>,
+    ballots: u64,
+}
+
+impl PendingDelta {
+    fn merge(&mut self, update: &LiveScoreUpdate) {
+        for delta in &update.deltas {
+            let multiplier = i64::from(delta.multiplier);
+            self.operator(delta.win).win += multiplier;
+            self.operator(delta.lose).lose += multiplier;
+        }
+        self.ballots += update.ballots;
+    }
+
+    fn operator(&mut self, id: i32) -> &mut LiveOperatorDelta {
+        self.operators.entry(id).or_insert(LiveOperatorDelta {
+            id,
+            win: 0,
+            lose: 0,
+        })
+    }
+}
+
+/// Fans live updates out to every connection watching a topic. Updates are
+/// coalesced so each topic gets at most one delta per broadcast interval, and
+/// a full snapshot is re-read from redis every snapshot interval.
+#[derive(Clone)]
+pub struct LiveHub {
+    topics: Arc<DashMap<String, broadcast::Sender<Arc<LiveEvent>>>>,
+    pending: Arc<DashMap<String, PendingDelta>>,
+    rankings: Arc<DashMap<String, RankingState>>,
+    broadcast_interval: Duration,
+    snapshot_interval: Duration,
+    connection_buffer_size: usize,
+    slow_client_policy: SlowClientPolicy,
+    write_timeout: Duration,
+    nats: async_nats::Client,
+    redis: redis::aio::MultiplexedConnection,
+    presence: PresenceService,
+}
+
+impl LiveHub {
+    pub fn new(
+        nats: async_nats::Client,
+        redis: redis::aio::MultiplexedConnection,
+        presence: PresenceService,
+        config: &LiveConfig,
+    ) -> Self {
+        Self {
+            topics: Arc::new(DashMap::new()),
+            pending: Arc::new(DashMap::new()),
+            rankings: Arc::new(DashMap::new()),
+            broadcast_interval: Duration::from_millis(config.broadcast_interval_ms.max(1)),
+            snapshot_interval: Duration::from_secs(config.snapshot_interval_secs.max(1)),
+            connection_buffer_size: config.connection_buffer_size,
+            slow_client_policy: config.slow_client_policy,
+            write_timeout: Duration::from_secs(config.write_timeout_secs.max(1)),
+            nats,
+            redis,
+            presence,
+        }
+    }
+
+    pub fn subscribe(&self, topic_id: &str) -> TopicSubscription {
+        let receiver = self
+            .topics
+            .entry(topic_id.to_string())
+            .or_insert_with(|| broadcast::channel(TOPIC_CHANNEL_CAPACITY).0)
+            .subscribe();
+
+        TopicSubscription::new(
+            self.clone(),
+            topic_id.to_string(),
+            receiver,
+            self.presence.register_connection(topic_id),
+        )
+    }
+
+    pub fn connection_queue(&self) -> Arc<ConnectionQueue> {
+        ConnectionQueue::new(self.connection_buffer_size, self.slow_client_policy)
+    }
+
+    pub fn write_timeout(&self) -> Duration {
+        self.write_timeout
+    }
+
+    /// Subscribes to the notifications addressed to an API key.
+    pub async fn subscribe_notifications(
+        &self,
+        api_key_name: &str,
+    ) -> Result<async_nats::Subscriber, AppError> {
+        self.nats
+            .subscribe(notify_subject(api_key_name))
+            .await
+            .map_err(|e| AppError::InternalError(format!("notify subscribe failed: {e}")))
+    }
+
+    /// Drops the channel of a topic once its last subscriber is gone.
+    pub fn release(&self, topic_id: &str) {
+        if self
+            .topics
+            .remove_if(topic_id, |_, sender| sender.receiver_count() == 0)
+            .is_some()
+        {
+            self.rankings.remove(topic_id);
+            self.pending.remove(topic_id);
+        }
+    }
+
+    /// Sends an event to the subscribers of a topic on this instance.
+    pub fn broadcast(&self, topic_id: &str, event: LiveEvent) {
+        if let Some(sender) = self.topics.get(topic_id) {
+            let _ = sender.send(Arc::new(event));
+        }
+    }
+
+    pub async fn total_ballots(&self, topic_id: &str) -> Result<i64, AppError> {
+        let mut conn = self.redis.clone();
+        let total: Option<i64> = conn.get(format!("{topic_id}:valid_ballots_count")).await?;
+        Ok(total.unwrap_or(0))
+    }
+
+    /// Win/lose totals per operator as stored by the nats consumer.
+    async fn load_standings(&self, topic_id: &str) -> Result<Vec<(i32, i64, i64)>, AppError> {
+        let mut conn = self.redis.clone();
+        let stats: HashMap<String, i64> = conn.hgetall(format!("{topic_id}:op_stats")).await?;
+
+        let mut standings: HashMap<i32, (i64, i64)> = HashMap::new();
+        for (field, value) in stats {
+            let Some((id, side)) = field.split_once(':') else {
+                continue;
+            };
+            let Ok(id) = id.parse::<i32>() else {
+                continue;
+            };
+            let standing = standings.entry(id).or_default();
+            match side {
+                "win" => standing.0 = value,
+                "lose" => standing.1 = value,
+                _ => {}
+            }
+        }
+
+        Ok(standings
+            .into_iter()
+            .map(|(id, (win, lose))| (id, win, lose))
+            .collect())
+    }
+
+    async fn ensure_ranking(&self, topic_id: &str) -> Result<(), AppError> {
+        if self.rankings.contains_key(topic_id) {
+            return Ok(());
+        }
+
+        let standings = self.load_standings(topic_id).await?;
+        self.rankings
+            .entry(topic_id.to_string())
+            .or_insert_with(|| RankingState::new(topic_id, standings));
+        Ok(())
+    }
+
+    pub async fn snapshot(&self, topic_id: &str) -> Result<LiveEvent, AppError> {
+        self.ensure_ranking(topic_id).await?;
+        let total_ballots = self.total_ballots(topic_id).await?;
+        let viewers = self.presence.count(topic_id).await?;
+
+        if let Some(ranking) = self.rankings.get(topic_id) {
+            return Ok(ranking.snapshot(total_ballots, viewers));
+        }
+        // released while loading, the state only lives for this snapshot
+        let standings = self.load_standings(topic_id).await?;
+        Ok(RankingState::new(topic_id, standings).snapshot(total_ballots, viewers))
+    }
+
+    /// Events which bring a client from `cursor` up to date: the buffered
+    /// deltas when the cursor is still known, a fresh snapshot otherwise.
+    pub async fn resume(
+        &self,
+        topic_id: &str,
+        cursor: Option<&LiveCursor>,
+    ) -> Result<Vec<Arc<LiveEvent>>, AppError> {
+        if let Some(cursor) = cursor
+            && let Some(events) = self
+                .rankings
+                .get(topic_id)
+                .and_then(|ranking| ranking.replay(cursor))
+        {
+            return Ok(events);
+        }
+
+        Ok(vec![Arc::new(self.snapshot(topic_id).await?)])
+    }
+
+    fn queue(&self, update: LiveScoreUpdate) {
+        // nobody is watching on this instance, nothing to coalesce
+        if !self.topics.contains_key(&update.topic_id) {
+            return;
+        }
+
+        self.pending
+            .entry(update.topic_id.clone())
+            .or_default()
+            .merge(&update);
+    }
+
+    async fn flush(&self) {
+        let topic_ids: Vec<String> = self
+            .pending
+            .iter()
+            .map(|entry| entry.key().clone())
+            .collect();
+
+        for topic_id in topic_ids {
+            let Some((topic_id, pending)) = self.pending.remove(&topic_id) else {
+                continue;
+            };
+            let Some(sender) = self.topics.get(&topic_id).map(|sender| sender.clone()) else {
+                continue;
+            };
+
+            let total_ballots = match self.total_ballots(&topic_id).await {
+                Ok(total) => total,
+                Err(e) => {
+                    tracing::warn!("failed to read total ballots for live update: {}", e);
+                    continue;
+                }
+            };
+
+            let Some(mut ranking) = self.rankings.get_mut(&topic_id) else {
+                // the state is loaded from redis on subscribe and already
+                // contains these scores
+                continue;
+            };
+            let event = ranking.apply(
+                pending
+                    .operators
+                    .into_values()
+                    .map(|delta| (delta.id, delta.win, delta.lose)),
+                pending.ballots,
+                total_ballots,
+            );
+            drop(ranking);
+
+            // an error only means every subscriber left in the meantime
+            let _ = sender.send(event);
+        }
+    }
+
+    /// Rebuilds every watched ranking from redis, correcting deltas lost on
+    /// the best effort live subjects.
+    async fn refresh_snapshots(&self) {
+        let topic_ids: Vec<String> = self
+            .topics
+            .iter()
+            .map(|entry| entry.key().clone())
+            .collect();
+
+        for topic_id in topic_ids {
+            // scores are written to redis before their live update is
+            // published, so pending deltas are part of what is loaded below
+            self.pending.remove(&topic_id);
+
+            let standings = match self.load_standings(&topic_id).await {
+                Ok(standings) => standings,
+                Err(e) => {
+                    tracing::warn!("failed to load standings for {}: {}", topic_id, e);
+                    continue;
+                }
+            };
+            match self.rankings.get_mut(&topic_id) {
+                Some(mut ranking) => ranking.reset(standings),
+                None => {
+                    self.rankings
+                        .insert(topic_id.clone(), RankingState::new(&topic_id, standings));
+                }
+            }
+
+            match self.snapshot(&topic_id).await {
+                Ok(snapshot) => self.broadcast(&topic_id, snapshot),
+                Err(e) => tracing::warn!("failed to build live snapshot: {}", e),
+            }
+        }
+    }
+
+    async fn broadcast_presence(&self) {
+        let topics: Vec<(String, broadcast::Sender<Arc<LiveEvent>>)> = self
+            .topics
+            .iter()
+            .map(|entry| (entry.key().clone(), entry.value().clone()))
+            .collect();
+
+        for (topic_id, sender) in topics {
+            match self.presence.count(&topic_id).await {
+                Ok(viewers) => {
+                    let _ = sender.send(Arc::new(LiveEvent::Presence { topic_id, viewers }));
+                }
+                Err(e) => tracing::debug!("failed to count presence for {}: {}", topic_id, e),
+            }
+        }
+    }
+
+    /// Listens on the core NATS live subjects until the connection is drained,
+    /// with the broadcast loops running alongside in the same task.
+    pub async fn run(self) -> Result<(), AppError> {
+        let presence = async {
+            let mut interval = tokio::time::interval(PRESENCE_BROADCAST_INTERVAL);
+            loop {
+                interval.tick().await;
+                self.broadcast_presence().await;
+            }
+        };
+
+        let flush = async {
+            let mut interval = tokio::time::interval(self.broadcast_interval);
+            interval.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Delay);
+            loop {
+                interval.tick().await;
+                self.flush().await;
+            }
+        };
+
+        let snapshots = async {
+            let mut interval = tokio::time::interval(self.snapshot_interval);
+            interval.set_missed_tick_behavior(tokio::time::MissedTickBehavior::Delay);
+            // the first tick completes immediately, subscribers got a snapshot already
+            interval.tick().await;
+            loop {
+                interval.tick().await;
+                self.refresh_snapshots().await;
+            }
+        };
+
+        tokio::select! {
+            result = self.listen_live_subjects() => result,
+            _ = presence => Ok(()),
+            _ = flush => Ok(()),
+            _ = snapshots => Ok(()),
+        }
+    }
+
+    async fn listen_live_subjects(&self) -> Result<(), AppError> {
+        let mut subscriber = self
+            .nats
+            .subscribe(format!("{LIVE_SUBJECT_PREFIX}.>"))
+            .await
+            .map_err(|e| AppError::InternalError(format!("live subscribe failed: {e}")))?;
+
+        while let Some(message) = subscriber.next().await {
+            match serde_json::from_slice::<LiveScoreUpdate>(&message.payload) {
+                Ok(update) => self.queue(update),
+                Err(e) => tracing::warn!("invalid live update on {}: {}", message.subject, e),
+            }
+        }
+
+        Ok(())
+    }
+}
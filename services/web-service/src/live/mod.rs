@@ -0,0 +1,23 @@
+use std::sync::Arc;
+
+use axum::{Router, routing::get};
+
+use crate::state::AppState;
+
+mod hub;
+mod queue;
+mod ranking;
+mod sse;
+mod subscription;
+mod ws;
+
+pub use hub::LiveHub;
+pub use subscription::TopicSubscription;
+
+/// The WebSocket feed and its SSE fallback share the same hub.
+pub fn live_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route("/ws", get(ws::live_ws))
+        .route("/ws/topics/{id}", get(ws::topic_live_ws))
+        .route("/sse/topics/{id}", get(sse::topic_live_sse))
+}
@@ -0,0 +1,100 @@
+use std::{collections::VecDeque, sync::Arc};
+
+use parking_lot::Mutex;
+use share::{config::SlowClientPolicy, models::live::LiveEvent};
+use tokio::sync::Notify;
+
+#[derive(Default)]
+struct QueueState {
+    events: VecDeque<Arc<LiveEvent>>,
+    dropped: u64,
+    closed: bool,
+    overflowed: bool,
+}
+
+/// Outgoing events of one connection. Pushing never waits, so a client that
+/// stops reading only ever costs `capacity` queued events and cannot stall
+/// the tasks feeding it.
+pub struct ConnectionQueue {
+    state: Mutex<QueueState>,
+    notify: Notify,
+    capacity: usize,
+    policy: SlowClientPolicy,
+}
+
+impl ConnectionQueue {
+    pub(super) fn new(capacity: usize, policy: SlowClientPolicy) -> Arc<Self> {
+        Arc::new(Self {
+            state: Mutex::new(QueueState::default()),
+            notify: Notify::new(),
+            capacity: capacity.max(1),
+            policy,
+        })
+    }
+
+    /// Returns `false` once the queue is closed and the event was discarded.
+    pub fn push(&self, event: Arc<LiveEvent>) -> bool {
+        {
+            let mut state = self.state.lock();
+            if state.closed {
+                return false;
+            }
+
+            if state.events.len() >= self.capacity {
+                match self.policy {
+                    SlowClientPolicy::DropOldest => {
+                        state.events.pop_front();
+                        state.dropped += 1;
+                    }
+                    SlowClientPolicy::Disconnect => {
+                        state.closed = true;
+                        state.overflowed = true;
+                        state.events.clear();
+                        drop(state);
+                        self.notify.notify_one();
+                        return false;
+                    }
+                }
+            }
+            state.events.push_back(event);
+        }
+
+        self.notify.notify_one();
+        true
+    }
+
+    /// Waits for the next event. Dropped events are reported with a
+    /// [`LiveEvent::Lagged`] before the events that survived.
+    pub async fn pop(&self) -> Option<Arc<LiveEvent>> {
+        loop {
+            {
+                let mut state = self.state.lock();
+                if state.dropped > 0 {
+                    let dropped = std::mem::take(&mut state.dropped);
+                    return Some(Arc::new(LiveEvent::Lagged {
+                        topic_id: None,
+                        dropped,
+                    }));
+                }
+                if let Some(event) = state.events.pop_front() {
+                    return Some(event);
+                }
+                if state.closed {
+                    return None;
+                }
+            }
+
+            self.notify.notified().await;
+        }
+    }
+
+    pub fn close(&self) {
+        self.state.lock().closed = true;
+        self.notify.notify_one();
+    }
+
+    /// Whether the queue was closed because the client fell too far behind.
+    pub fn overflowed(&self) -> bool {
+        self.state.lock().overflowed
+    }
+}
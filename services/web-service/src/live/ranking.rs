@@ -0,0 +1,246 @@
+use std::{
+    collections::{HashMap, VecDeque},
+    sync::Arc,
+};
+
+use share::models::live::{LiveCursor, LiveEvent, LiveRankChange, LiveRankedOperator};
+
+/// Deltas kept per topic for clients resuming after a short disconnect.
+const HISTORY_CAPACITY: usize = 64;
+
+#[derive(Clone, Copy, Default)]
+struct Standing {
+    win: i64,
+    lose: i64,
+}
+
+impl Standing {
+    fn score(&self) -> i64 {
+        self.win - self.lose
+    }
+}
+
+/// Ranking of one topic as seen by this instance, rebuilt from redis on
+/// every snapshot and advanced by the coalesced score deltas in between.
+pub(super) struct RankingState {
+    topic_id: String,
+    epoch: String,
+    seq: u64,
+    standings: HashMap<i32, Standing>,
+    ranks: HashMap<i32, u32>,
+    history: VecDeque<(u64, Arc<LiveEvent>)>,
+}
+
+impl RankingState {
+    pub fn new(topic_id: &str, standings: impl IntoIterator<Item = (i32, i64, i64)>) -> Self {
+        let mut state = Self {
+            topic_id: topic_id.to_string(),
+            epoch: uuid::Uuid::new_v4().simple().to_string(),
+            seq: 0,
+            standings: HashMap::new(),
+            ranks: HashMap::new(),
+            history: VecDeque::with_capacity(HISTORY_CAPACITY),
+        };
+        state.reset(standings);
+        state
+    }
+
+    pub fn cursor(&self) -> LiveCursor {
+        LiveCursor {
+            epoch: self.epoch.clone(),
+            seq: self.seq,
+        }
+    }
+
+    /// Replaces the standings without changing the cursor, so clients can
+    /// keep resuming across periodic snapshots.
+    pub fn reset(&mut self, standings: impl IntoIterator<Item = (i32, i64, i64)>) {
+        self.standings = standings
+            .into_iter()
+            .map(|(id, win, lose)| (id, Standing { win, lose }))
+            .collect();
+        self.ranks = self.compute_ranks();
+    }
+
+    fn compute_ranks(&self) -> HashMap<i32, u32> {
+        let mut order: Vec<(i32, i64)> = self
+            .standings
+            .iter()
+            .map(|(id, standing)| (*id, standing.score()))
+            .collect();
+        order.sort_by(|a, b| b.1.cmp(&a.1).then(a.0.cmp(&b.0)));
+
+        order
+            .into_iter()
+            .enumerate()
+            .map(|(index, (id, _))| (id, index as u32 + 1))
+            .collect()
+    }
+
+    /// Applies win/lose deltas and returns the delta event listing the
+    /// operators whose rank or score moved.
+    pub fn apply(
+        &mut self,
+        deltas: impl IntoIterator<Item = (i32, i64, i64)>,
+        ballots: u64,
+        total_ballots: i64,
+    ) -> Arc<LiveEvent> {
+        let mut score_deltas: HashMap<i32, i64> = HashMap::new();
+        for (id, win, lose) in deltas {
+            let standing = self.standings.entry(id).or_default();
+            standing.win += win;
+            standing.lose += lose;
+            *score_deltas.entry(id).or_default() += win - lose;
+        }
+
+        let ranks = self.compute_ranks();
+        let mut changes: Vec<LiveRankChange> = ranks
+            .iter()
+            .filter_map(|(id, &to)| {
+                let from = self.ranks.get(id).copied();
+                let delta = score_deltas.get(id).copied().unwrap_or(0);
+                (from != Some(to) || delta != 0).then_some(LiveRankChange {
+                    id: *id,
+                    from,
+                    to,
+                    delta,
+                })
+            })
+            .collect();
+        changes.sort_by_key(|change| change.to);
+        self.ranks = ranks;
+
+        self.seq += 1;
+        let event = Arc::new(LiveEvent::Delta {
+            topic_id: self.topic_id.clone(),
+            cursor: self.cursor(),
+            changes,
+            ballots,
+            total_ballots,
+        });
+        if self.history.len() == HISTORY_CAPACITY {
+            self.history.pop_front();
+        }
+        self.history.push_back((self.seq, event.clone()));
+
+        event
+    }
+
+    /// Deltas after `cursor`, or `None` when the client has to start over
+    /// from a snapshot.
+    pub fn replay(&self, cursor: &LiveCursor) -> Option<Vec<Arc<LiveEvent>>> {
+        if cursor.epoch != self.epoch || cursor.seq > self.seq {
+            return None;
+        }
+        if cursor.seq == self.seq {
+            return Some(Vec::new());
+        }
+
+        let (oldest, _) = self.history.front()?;
+        if cursor.seq + 1 < *oldest {
+            return None;
+        }
+
+        Some(
+            self.history
+                .iter()
+                .filter(|(seq, _)| *seq > cursor.seq)
+                .map(|(_, event)| event.clone())
+                .collect(),
+        )
+    }
+
+    pub fn snapshot(&self, total_ballots: i64, viewers: usize) -> LiveEvent {
+        LiveEvent::Snapshot {
+            topic_id: self.topic_id.clone(),
+            cursor: self.cursor(),
+            total_ballots,
+            viewers,
+            ranking: self.ranking(),
+        }
+    }
+
+    fn ranking(&self) -> Vec<LiveRankedOperator> {
+        let mut ranking: Vec<LiveRankedOperator> = self
+            .standings
+            .iter()
+            .map(|(id, standing)| LiveRankedOperator {
+                id: *id,
+                rank: self.ranks.get(id).copied().unwrap_or(0),
+                win: standing.win,
+                lose: standing.lose,
+            })
+            .collect();
+        ranking.sort_by_key(|operator| operator.rank);
+        ranking
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn apply_reports_rank_moves() {
+        let mut state = RankingState::new("t", [(1, 300, 100), (2, 200, 100), (3, 0, 0)]);
+        let start = state.cursor();
+
+        let event = state.apply([(3, 400, 0), (1, 0, 100)], 2, 10);
+        let LiveEvent::Delta {
+            cursor, changes, ..
+        } = &*event
+        else {
+            panic!("expected a delta");
+        };
+
+        assert_eq!(cursor.seq, start.seq + 1);
+        assert_eq!(
+            *changes,
+            vec![
+                LiveRankChange {
+                    id: 3,
+                    from: Some(3),
+                    to: 1,
+                    delta: 400,
+                },
+                LiveRankChange {
+                    id: 1,
+                    from: Some(1),
+                    to: 2,
+                    delta: -100,
+                },
+                LiveRankChange {
+                    id: 2,
+                    from: Some(2),
+                    to: 3,
+                    delta: 0,
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn replay_requires_buffered_history() {
+        let mut state = RankingState::new("t", [(1, 100, 0)]);
+        let start = state.cursor();
+        state.apply([(1, 100, 0)], 1, 1);
+        state.apply([(2, 100, 0)], 1, 2);
+
+        assert_eq!(state.replay(&start).map(|deltas| deltas.len()), Some(2));
+        assert_eq!(
+            state.replay(&state.cursor()).map(|deltas| deltas.len()),
+            Some(0)
+        );
+
+        let other_epoch = LiveCursor {
+            epoch: "other".to_string(),
+            seq: 0,
+        };
+        assert!(state.replay(&other_epoch).is_none());
+
+        for _ in 0..HISTORY_CAPACITY {
+            state.apply([(1, 100, 0)], 1, 1);
+        }
+        assert!(state.replay(&start).is_none());
+    }
+}
@@ -0,0 +1,72 @@
+use std::{convert::Infallible, sync::Arc, time::Duration};
+
+use crate::{error::AppError, live::TopicSubscription, state::AppState};
+use axum::{
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::{
+        IntoResponse as _, Response,
+        sse::{Event, KeepAlive, Sse},
+    },
+};
+use futures::{Stream, stream};
+use share::models::live::{LiveCursor, LiveEvent};
+
+const KEEP_ALIVE_INTERVAL: Duration = Duration::from_secs(15);
+
+fn to_sse_event(event: &LiveEvent) -> Event {
+    let name = match event {
+        LiveEvent::Snapshot { .. } => "snapshot",
+        LiveEvent::Delta { .. } => "delta",
+        LiveEvent::Presence { .. } => "presence",
+        LiveEvent::TopicChanged { .. } => "topic_changed",
+        LiveEvent::Lagged { .. } => "lagged",
+        _ => "message",
+    };
+
+    let sse_event = Event::default()
+        .event(name)
+        .json_data(event)
+        .unwrap_or_else(|_| Event::default().comment("serialization failed"));
+
+    // browsers send the last id back as Last-Event-ID when reconnecting
+    match event {
+        LiveEvent::Snapshot { cursor, .. } | LiveEvent::Delta { cursor, .. } => {
+            sse_event.id(cursor.encode())
+        }
+        _ => sse_event,
+    }
+}
+
+#[axum::debug_handler]
+pub async fn topic_live_sse(
+    headers: HeaderMap,
+    Path(topic_id): Path<String>,
+    State(state): State<Arc<AppState>>,
+) -> Result<Response, AppError> {
+    if state.topic_service.get_topic(&topic_id).await?.is_none() {
+        return Ok(StatusCode::NOT_FOUND.into_response());
+    }
+
+    let resume = headers
+        .get("last-event-id")
+        .and_then(|value| value.to_str().ok())
+        .and_then(LiveCursor::decode);
+
+    let subscription = state.live_hub.subscribe(&topic_id);
+    let initial = state.live_hub.resume(&topic_id, resume.as_ref()).await?;
+
+    let initial = stream::iter(initial.into_iter().map(|event| Ok(to_sse_event(&event))));
+    let updates = live_stream(subscription);
+
+    Ok(Sse::new(futures::StreamExt::chain(initial, updates))
+        .keep_alive(KeepAlive::new().interval(KEEP_ALIVE_INTERVAL))
+        .into_response())
+}
+
+fn live_stream(subscription: TopicSubscription) -> impl Stream<Item = Result<Event, Infallible>> {
+    stream::unfold(subscription, |mut subscription| async move {
+        let event = subscription.recv().await?;
+        Some((Ok(to_sse_event(&event)), subscription))
+    })
+}
@@ -0,0 +1,63 @@
+use std::sync::Arc;
+
+use share::models::live::LiveEvent;
+use tokio::sync::broadcast::{self, error::RecvError};
+
+use crate::{live::LiveHub, service::PresenceGuard};
+
+/// A connection's interest in one topic. Dropping it removes the connection
+/// from the viewer count and releases the hub channel if nobody else listens.
+pub struct TopicSubscription {
+    hub: LiveHub,
+    topic_id: String,
+    receiver: Option<broadcast::Receiver<Arc<LiveEvent>>>,
+    _presence: PresenceGuard,
+}
+
+impl TopicSubscription {
+    pub(super) fn new(
+        hub: LiveHub,
+        topic_id: String,
+        receiver: broadcast::Receiver<Arc<LiveEvent>>,
+        presence: PresenceGuard,
+    ) -> Self {
+        Self {
+            hub,
+            topic_id,
+            receiver: Some(receiver),
+            _presence: presence,
+        }
+    }
+
+    pub fn topic_id(&self) -> &str {
+        &self.topic_id
+    }
+
+    /// Waits for the next event. Messages missed while lagging are reported
+    /// as a [`LiveEvent::Lagged`] so the client can resume from its cursor.
+    pub async fn recv(&mut self) -> Option<Arc<LiveEvent>> {
+        let receiver = self.receiver.as_mut()?;
+        match receiver.recv().await {
+            Ok(event) => Some(event),
+            Err(RecvError::Lagged(skipped)) => {
+                tracing::debug!(
+                    "live subscriber on {} lagged by {} messages",
+                    self.topic_id,
+                    skipped
+                );
+                Some(Arc::new(LiveEvent::Lagged {
+                    topic_id: Some(self.topic_id.clone()),
+                    dropped: skipped,
+                }))
+            }
+            Err(RecvError::Closed) => None,
+        }
+    }
+}
+
+impl Drop for TopicSubscription {
+    fn drop(&mut self) {
+        drop(self.receiver.take());
+        self.hub.release(&self.topic_id);
+    }
+}
@@ -0,0 +1,265 @@
+use std::{collections::HashMap, sync::Arc, time::Duration};
+
+use axum::{
+    extract::{
+        Path, State,
+        ws::{CloseFrame, Message, WebSocket, WebSocketUpgrade, close_code},
+    },
+    http::StatusCode,
+    response::{IntoResponse as _, Response},
+};
+use futures::{SinkExt as _, StreamExt as _, stream::SplitSink};
+use share::models::{
+    database::ApiKeyScope,
+    live::{LiveClientMessage, LiveCursor, LiveEvent},
+};
+use tokio::task::JoinHandle;
+
+use crate::{error::AppError, live::queue::ConnectionQueue, service::ApiKeyCheck, state::AppState};
+
+const ANONYMOUS_MAX_SUBSCRIPTIONS: usize = 5;
+const AUTHENTICATED_MAX_SUBSCRIPTIONS: usize = 50;
+
+/// Single topic feed, kept for clients that open one connection per topic.
+#[axum::debug_handler]
+pub async fn topic_live_ws(
+    ws: WebSocketUpgrade,
+    Path(topic_id): Path<String>,
+    State(state): State<Arc<AppState>>,
+) -> Result<Response, AppError> {
+    if state.topic_service.get_topic(&topic_id).await?.is_none() {
+        return Ok(StatusCode::NOT_FOUND.into_response());
+    }
+
+    Ok(ws.on_upgrade(move |socket| handle_socket(socket, state, Some(topic_id))))
+}
+
+/// Multiplexed feed, topics are picked with subscribe/unsubscribe messages.
+#[axum::debug_handler]
+pub async fn live_ws(ws: WebSocketUpgrade, State(state): State<Arc<AppState>>) -> Response {
+    ws.on_upgrade(move |socket| handle_socket(socket, state, None))
+}
+
+struct Connection {
+    state: Arc<AppState>,
+    queue: Arc<ConnectionQueue>,
+    subscriptions: HashMap<String, JoinHandle<Option<()>>>,
+    authenticated: Option<String>,
+    notifications: Option<JoinHandle<Option<()>>>,
+}
+
+impl Connection {
+    fn max_subscriptions(&self) -> usize {
+        if self.authenticated.is_some() {
+            AUTHENTICATED_MAX_SUBSCRIPTIONS
+        } else {
+            ANONYMOUS_MAX_SUBSCRIPTIONS
+        }
+    }
+
+    async fn reply(&self, event: LiveEvent) {
+        self.queue.push(Arc::new(event));
+    }
+
+    async fn error(&self, message: impl Into<String>) {
+        self.reply(LiveEvent::Error {
+            message: message.into(),
+        })
+        .await;
+    }
+
+    async fn subscribe(&mut self, topic_id: String, resume: Option<LiveCursor>) {
+        if self.subscriptions.contains_key(&topic_id) {
+            self.reply(LiveEvent::Subscribed { topic_id }).await;
+            return;
+        }
+        if self.subscriptions.len() >= self.max_subscriptions() {
+            self.error("subscription limit reached").await;
+            return;
+        }
+        match self.state.topic_service.get_topic(&topic_id).await {
+            Ok(Some(_)) => {}
+            Ok(None) => {
+                self.error(format!("topic {topic_id} not found")).await;
+                return;
+            }
+            Err(e) => {
+                tracing::warn!("failed to look up topic {} for live feed: {}", topic_id, e);
+                self.error("internal error").await;
+                return;
+            }
+        }
+
+        let hub = self.state.live_hub.clone();
+        let mut subscription = hub.subscribe(&topic_id);
+        let queue = self.queue.clone();
+        let task = share::task::spawn("live_subscription", async move {
+            match hub.resume(subscription.topic_id(), resume.as_ref()).await {
+                Ok(events) => {
+                    for event in events {
+                        if !queue.push(event) {
+                            return;
+                        }
+                    }
+                }
+                Err(e) => tracing::warn!("failed to build live snapshot: {}", e),
+            }
+
+            while let Some(event) = subscription.recv().await {
+                if !queue.push(event) {
+                    break;
+                }
+            }
+        });
+
+        self.subscriptions.insert(topic_id.clone(), task);
+        self.reply(LiveEvent::Subscribed { topic_id }).await;
+    }
+
+    async fn unsubscribe(&mut self, topic_id: String) {
+        if let Some(task) = self.subscriptions.remove(&topic_id) {
+            task.abort();
+        }
+        self.reply(LiveEvent::Unsubscribed { topic_id }).await;
+    }
+
+    async fn authenticate(&mut self, api_key: &str) {
+        let check = self
+            .state
+            .api_key_service
+            .check(api_key, ApiKeyScope::Results)
+            .await;
+
+        let key = match check {
+            Ok(ApiKeyCheck::Allowed(key)) => key,
+            Ok(ApiKeyCheck::RateLimited) => return self.error("api key rate limited").await,
+            Ok(_) => return self.error("invalid api key").await,
+            Err(e) => {
+                tracing::warn!("failed to check live feed api key: {}", e);
+                return self.error("internal error").await;
+            }
+        };
+
+        let mut subscriber = match self.state.live_hub.subscribe_notifications(&key.name).await {
+            Ok(subscriber) => subscriber,
+            Err(e) => {
+                tracing::warn!("failed to subscribe to notifications: {}", e);
+                return self.error("internal error").await;
+            }
+        };
+
+        let queue = self.queue.clone();
+        let task = share::task::spawn("live_notifications", async move {
+            while let Some(message) = subscriber.next().await {
+                let payload = serde_json::from_slice(&message.payload).unwrap_or_else(|_| {
+                    serde_json::Value::String(String::from_utf8_lossy(&message.payload).into())
+                });
+                let event = Arc::new(LiveEvent::Notification { payload });
+                if !queue.push(event) {
+                    break;
+                }
+            }
+        });
+        if let Some(previous) = self.notifications.replace(task) {
+            previous.abort();
+        }
+
+        self.authenticated = Some(key.name.clone());
+        self.reply(LiveEvent::Authenticated { name: key.name })
+            .await;
+    }
+
+    async fn handle_text(&mut self, text: &str) {
+        match serde_json::from_str::<LiveClientMessage>(text) {
+            Ok(LiveClientMessage::Subscribe { topic_id, resume }) => {
+                self.subscribe(topic_id, resume).await
+            }
+            Ok(LiveClientMessage::Unsubscribe { topic_id }) => self.unsubscribe(topic_id).await,
+            Ok(LiveClientMessage::Auth { api_key }) => self.authenticate(&api_key).await,
+            Err(e) => self.error(format!("invalid message: {e}")).await,
+        }
+    }
+}
+
+impl Drop for Connection {
+    fn drop(&mut self) {
+        for (_, task) in self.subscriptions.drain() {
+            task.abort();
+        }
+        if let Some(task) = self.notifications.take() {
+            task.abort();
+        }
+    }
+}
+
+/// Drains the connection queue into the socket. A socket that does not take
+/// a frame within `write_timeout` is given up on.
+async fn write_events(
+    mut sink: SplitSink<WebSocket, Message>,
+    queue: Arc<ConnectionQueue>,
+    write_timeout: Duration,
+) {
+    while let Some(event) = queue.pop().await {
+        let text = match serde_json::to_string(&*event) {
+            Ok(text) => text,
+            Err(e) => {
+                tracing::warn!("failed to serialize live event: {}", e);
+                continue;
+            }
+        };
+        match tokio::time::timeout(write_timeout, sink.send(Message::Text(text.into()))).await {
+            Ok(Ok(())) => {}
+            Ok(Err(_)) => break,
+            Err(_) => {
+                tracing::debug!("live client did not accept a frame in time, closing");
+                queue.close();
+                return;
+            }
+        }
+    }
+
+    if queue.overflowed() {
+        let frame = CloseFrame {
+            code: close_code::AGAIN,
+            reason: "client too slow".into(),
+        };
+        let _ = tokio::time::timeout(write_timeout, sink.send(Message::Close(Some(frame)))).await;
+        return;
+    }
+    let _ = tokio::time::timeout(write_timeout, sink.close()).await;
+}
+
+async fn handle_socket(socket: WebSocket, state: Arc<AppState>, topic_id: Option<String>) {
+    let (sink, mut stream) = socket.split();
+    let queue = state.live_hub.connection_queue();
+    let mut writer = share::task::spawn(
+        "live_writer",
+        write_events(sink, queue.clone(), state.live_hub.write_timeout()),
+    );
+
+    let mut connection = Connection {
+        state,
+        queue: queue.clone(),
+        subscriptions: HashMap::new(),
+        authenticated: None,
+        notifications: None,
+    };
+    if let Some(topic_id) = topic_id {
+        connection.subscribe(topic_id, None).await;
+    }
+
+    loop {
+        tokio::select! {
+            _ = &mut writer => break,
+            incoming = stream.next() => match incoming {
+                Some(Ok(Message::Text(text))) => connection.handle_text(&text).await,
+                Some(Ok(Message::Close(_))) | Some(Err(_)) | None => break,
+                Some(Ok(_)) => {}
+            },
+        }
+    }
+
+    drop(connection);
+    queue.close();
+    writer.abort();
+}
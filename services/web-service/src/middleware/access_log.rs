@@ -0,0 +1,63 @@
+use std::{
+    sync::{
+        Arc,
+        atomic::{AtomicU64, Ordering::Relaxed},
+    },
+    time::Duration,
+};
+
+use axum::http::Response;
+use share::reload::ConfigWatch;
+use tower_http::trace::OnResponse;
+use tracing::Span;
+
+/// `on_response` of the `TraceLayer`, recorded inside the request span so
+/// every line carries the method, uri and request id.
+#[derive(Clone)]
+pub struct AccessLog {
+    config: ConfigWatch,
+    seen: Arc<AtomicU64>,
+}
+
+impl AccessLog {
+    pub fn new(config: ConfigWatch) -> Self {
+        Self {
+            config,
+            seen: Arc::new(AtomicU64::new(0)),
+        }
+    }
+
+    fn sampled(&self, sample_rate: u64) -> bool {
+        // counting instead of a random draw keeps the rate exact under load
+        sample_rate > 0 && self.seen.fetch_add(1, Relaxed) % sample_rate == 0
+    }
+}
+
+impl<B> OnResponse<B> for AccessLog {
+    fn on_response(self, response: &Response<B>, latency: Duration, span: &Span) {
+        let app_config = self.config.current();
+        let config = &app_config.access_log;
+        if !config.enabled {
+            return;
+        }
+
+        let status = response.status();
+        let latency_ms = latency.as_millis() as u64;
+        let _entered = span.enter();
+
+        // 5xx causes are logged as errors where they happen, one warning here
+        // is enough to find the request
+        if status.is_server_error() || status.is_client_error() {
+            tracing::warn!(status = status.as_u16(), latency_ms, "request failed");
+        } else if latency >= Duration::from_millis(config.slow_threshold_ms) {
+            tracing::warn!(status = status.as_u16(), latency_ms, "slow request");
+        } else if self.sampled(config.sample_rate) {
+            tracing::info!(
+                status = status.as_u16(),
+                latency_ms,
+                sample_rate = config.sample_rate,
+                "request finished"
+            );
+        }
+    }
+}
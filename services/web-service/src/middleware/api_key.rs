@@ -0,0 +1,197 @@
+//! Api keys are accepted in two forms. A plain key in the `api_key.header`
+//! header, or a signed request which never sends the key: it names the key in
+//! [`KEY_NAME_HEADER`] and signs the method, path, timestamp, nonce and body
+//! with the signing secret handed out with the key, see [`verify_request`].
+//! A signed request is only valid within `api_key.signature_max_skew_secs` of
+//! its timestamp and only once per nonce.
+//!
+//! There are no session cookies, a browser never attaches either form to a
+//! cross-site request by itself, so the admin routes need no CSRF token. A
+//! cookie session added later would need one.
+
+use std::sync::Arc;
+
+use axum::{
+    body::Body,
+    extract::{OriginalUri, Request, State},
+    http::HeaderMap,
+    middleware::Next,
+    response::{IntoResponse as _, Response},
+};
+use chrono::Utc;
+use share::models::{
+    api::{ApiData, ApiMsg, ApiResponse},
+    database::ApiKeyScope,
+};
+
+use crate::{
+    error::AppError,
+    service::{ApiKeyCheck, verify_request},
+    state::AppState,
+};
+
+pub const KEY_NAME_HEADER: &str = "x-ark-vote-key";
+pub const TIMESTAMP_HEADER: &str = "x-ark-vote-timestamp";
+pub const NONCE_HEADER: &str = "x-ark-vote-nonce";
+pub const SIGNATURE_HEADER: &str = "x-ark-vote-signature";
+
+const MAX_NONCE_LENGTH: usize = 128;
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub enum AnonymousAccess {
+    /// Requests without a key go through, a present key is still validated.
+    Allow,
+    /// Requests without a key are rejected.
+    Deny,
+}
+
+#[derive(Clone)]
+pub struct ApiKeyGuard {
+    pub state: Arc<AppState>,
+    pub scope: ApiKeyScope,
+    pub anonymous: AnonymousAccess,
+}
+
+fn reject(status: i32, message: ApiMsg) -> Response {
+    ApiResponse::<()> {
+        status,
+        data: ApiData::Empty,
+        message,
+    }
+    .into_response()
+}
+
+fn header<'a>(headers: &'a HeaderMap, name: &str) -> Option<&'a str> {
+    headers.get(name).and_then(|value| value.to_str().ok())
+}
+
+pub async fn api_key_auth(
+    State(guard): State<ApiKeyGuard>,
+    request: Request,
+    next: Next,
+) -> Response {
+    if request.headers().contains_key(KEY_NAME_HEADER) {
+        return signed_auth(guard, request, next).await;
+    }
+
+    let api_key_service = &guard.state.api_key_service;
+    let raw_key = request
+        .headers()
+        .get(api_key_service.config().header.as_str())
+        .and_then(|value| value.to_str().ok())
+        .map(str::to_owned);
+
+    let Some(raw_key) = raw_key else {
+        return match guard.anonymous {
+            AnonymousAccess::Allow => next.run(request).await,
+            AnonymousAccess::Deny => reject(401, ApiMsg::ApiKeyMissing),
+        };
+    };
+
+    if guard.scope == ApiKeyScope::Admin && api_key_service.config().require_signed_admin {
+        return reject(401, ApiMsg::SignatureRequired);
+    }
+
+    match api_key_service.check(&raw_key, guard.scope).await {
+        Ok(check) => run_checked(check, request, next).await,
+        Err(e) => e.into_response(),
+    }
+}
+
+async fn run_checked(check: ApiKeyCheck, mut request: Request, next: Next) -> Response {
+    match check {
+        ApiKeyCheck::Allowed(key) => {
+            tracing::debug!(api_key = %key.name, "request authenticated with api key");
+            request.extensions_mut().insert(key);
+            next.run(request).await
+        }
+        ApiKeyCheck::Invalid => reject(401, ApiMsg::ApiKeyInvalid),
+        ApiKeyCheck::Forbidden => reject(403, ApiMsg::ApiKeyScopeForbidden),
+        ApiKeyCheck::RateLimited => reject(429, ApiMsg::ApiKeyRateLimited),
+    }
+}
+
+async fn signed_auth(guard: ApiKeyGuard, request: Request, next: Next) -> Response {
+    let api_key_service = &guard.state.api_key_service;
+    let config = api_key_service.config();
+
+    let headers = request.headers();
+    let (Some(name), Some(timestamp), Some(nonce), Some(signature)) = (
+        header(headers, KEY_NAME_HEADER),
+        header(headers, TIMESTAMP_HEADER).and_then(|value| value.parse::<i64>().ok()),
+        header(headers, NONCE_HEADER),
+        header(headers, SIGNATURE_HEADER),
+    ) else {
+        return reject(401, ApiMsg::SignatureInvalid);
+    };
+    if nonce.is_empty() || nonce.len() > MAX_NONCE_LENGTH {
+        return reject(401, ApiMsg::SignatureInvalid);
+    }
+    if Utc::now().timestamp().abs_diff(timestamp) > config.signature_max_skew_secs {
+        return reject(401, ApiMsg::SignatureExpired);
+    }
+    let (name, nonce, signature) = (name.to_owned(), nonce.to_owned(), signature.to_owned());
+
+    let key = match api_key_service.find_by_name(&name).await {
+        Ok(Some(key)) => key,
+        Ok(None) => return reject(401, ApiMsg::ApiKeyInvalid),
+        Err(e) => return e.into_response(),
+    };
+    let Some(secret) = api_key_service.signing_secret(&key) else {
+        return reject(401, ApiMsg::SignatureInvalid);
+    };
+
+    // the body is part of the signature, so it has to be read up front
+    let (parts, body) = request.into_parts();
+    let Ok(body) = axum::body::to_bytes(body, config.signature_max_body_bytes).await else {
+        return reject(413, ApiMsg::RequestBodyTooLarge);
+    };
+    // nested routers strip their prefix, the client signed the full path
+    let uri = parts
+        .extensions
+        .get::<OriginalUri>()
+        .map_or(&parts.uri, |original| &original.0);
+    let path_and_query = uri
+        .path_and_query()
+        .map_or(uri.path(), |path| path.as_str());
+    if !verify_request(
+        &secret,
+        parts.method.as_str(),
+        path_and_query,
+        timestamp,
+        &nonce,
+        &body,
+        &signature,
+    ) {
+        return reject(401, ApiMsg::SignatureInvalid);
+    }
+
+    // only a verified request may burn a nonce
+    match claim_nonce(&guard.state, &name, &nonce, config.signature_max_skew_secs).await {
+        Ok(true) => {}
+        Ok(false) => return reject(401, ApiMsg::NonceReused),
+        Err(e) => return e.into_response(),
+    }
+
+    let check = api_key_service.check_signed(key, guard.scope);
+    run_checked(check, Request::from_parts(parts, Body::from(body)), next).await
+}
+
+/// Remembers the nonce until its request would be stale anyway.
+async fn claim_nonce(
+    state: &AppState,
+    name: &str,
+    nonce: &str,
+    max_skew_secs: u64,
+) -> Result<bool, AppError> {
+    let mut redis = state.redis.connection.clone();
+    let claimed: Option<String> = redis::cmd("SET")
+        .arg(format!("api_key_nonce:{name}:{nonce}"))
+        .arg(1)
+        .arg("NX")
+        .arg("EX")
+        .arg(max_skew_secs * 2)
+        .query_async(&mut redis)
+        .await?;
+    Ok(claimed.is_some())
+}
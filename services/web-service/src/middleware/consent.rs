@@ -0,0 +1,58 @@
+use std::net::SocketAddr;
+
+use axum::{
+    Json,
+    extract::{ConnectInfo, Request, State},
+    http::StatusCode,
+    middleware::Next,
+    response::{IntoResponse as _, Response},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse};
+
+use crate::service::ConsentService;
+
+/// Carries the `consent.version` the voter accepted.
+pub const CONSENT_HEADER: &str = "x-ark-vote-consent";
+
+/// Answers ballot requests with 428 and the terms to accept while the caller
+/// has not accepted the current `consent.version`, so a version bump asks
+/// every voter again. The header is only a hint: a client which doesn't
+/// send the current version is asked right away, one which does is checked
+/// against the recorded acceptance of its address.
+pub async fn consent(
+    State(consent): State<ConsentService>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let Some(required) = consent.required() else {
+        return next.run(request).await;
+    };
+    let version = required.version.as_deref().unwrap_or_default();
+    let hinted = request
+        .headers()
+        .get(CONSENT_HEADER)
+        .and_then(|value| value.to_str().ok())
+        == Some(version);
+    let ip = request
+        .extensions()
+        .get::<ConnectInfo<SocketAddr>>()
+        .map(|ConnectInfo(addr)| addr.ip().to_canonical().to_string());
+
+    if hinted && let Some(ip) = ip {
+        match consent.has_accepted(&ip, version).await {
+            Ok(true) => return next.run(request).await,
+            Ok(false) => {}
+            Err(e) => return e.into_response(),
+        }
+    }
+
+    (
+        StatusCode::PRECONDITION_REQUIRED,
+        Json(ApiResponse {
+            status: 428,
+            data: ApiData::Data(required),
+            message: ApiMsg::ConsentRequired,
+        }),
+    )
+        .into_response()
+}
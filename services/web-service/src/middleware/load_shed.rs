@@ -0,0 +1,207 @@
+use std::{
+    sync::{
+        Arc,
+        atomic::{AtomicBool, AtomicU64, AtomicUsize, Ordering},
+    },
+    time::{Duration, Instant},
+};
+
+use axum::{
+    extract::{Request, State},
+    http::{HeaderValue, header::RETRY_AFTER},
+    middleware::Next,
+    response::{IntoResponse as _, Response},
+};
+use share::{
+    config::{AppConfig, LoadShedConfig},
+    models::api::{ApiData, ApiMsg, ApiResponse},
+    reload::ConfigWatch,
+};
+
+use crate::task::TaskManager;
+
+/// Latency buckets with upper bounds doubling from 1ms to about a minute.
+const LATENCY_BUCKETS: usize = 17;
+/// A window with fewer requests says nothing about the p99.
+const MIN_WINDOW_SAMPLES: u64 = 100;
+
+/// Request latencies since the last tick, in buckets so that recording is a
+/// single atomic add.
+struct LatencyWindow {
+    buckets: [AtomicU64; LATENCY_BUCKETS],
+}
+
+impl LatencyWindow {
+    fn record(&self, latency: Duration) {
+        let ms = latency.as_millis() as u64;
+        // bucket i holds (2^(i-1), 2^i] ms, bucket 0 everything up to 1ms
+        let index = (u64::BITS - ms.saturating_sub(1).leading_zeros()) as usize;
+        self.buckets[index.min(LATENCY_BUCKETS - 1)].fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Empties the window and returns the upper bound of the bucket holding
+    /// its p99.
+    fn take_p99_ms(&self) -> Option<u64> {
+        let counts = self
+            .buckets
+            .each_ref()
+            .map(|bucket| bucket.swap(0, Ordering::Relaxed));
+        let total: u64 = counts.iter().sum();
+        if total < MIN_WINDOW_SAMPLES {
+            return None;
+        }
+
+        let threshold = total - total / 100;
+        let mut seen = 0;
+        counts.iter().enumerate().find_map(|(index, count)| {
+            seen += count;
+            (seen >= threshold).then_some(1 << index)
+        })
+    }
+}
+
+struct Inner {
+    config: ConfigWatch,
+    task_manager: Arc<TaskManager>,
+    in_flight: AtomicUsize,
+    window: LatencyWindow,
+    /// Set by the ticker while the queue or the p99 is over its limit.
+    pressured: AtomicBool,
+}
+
+/// Tracks in-flight requests, the task queue and the recent p99 latency, and
+/// rejects low priority requests with 503 while any of them is over its limit.
+#[derive(Clone)]
+pub struct LoadShedder {
+    inner: Arc<Inner>,
+}
+
+/// Counts a request as in flight until it completes or is dropped.
+struct InFlight<'a>(&'a AtomicUsize);
+
+impl Drop for InFlight<'_> {
+    fn drop(&mut self) {
+        self.0.fetch_sub(1, Ordering::Relaxed);
+    }
+}
+
+impl LoadShedder {
+    pub fn new(config: ConfigWatch, task_manager: Arc<TaskManager>) -> Self {
+        Self {
+            inner: Arc::new(Inner {
+                config,
+                task_manager,
+                in_flight: AtomicUsize::new(0),
+                window: LatencyWindow {
+                    buckets: std::array::from_fn(|_| AtomicU64::new(0)),
+                },
+                pressured: AtomicBool::new(false),
+            }),
+        }
+    }
+
+    /// Read per use, the limits can be changed by a config reload.
+    fn config(&self) -> Arc<AppConfig> {
+        self.inner.config.current()
+    }
+
+    fn is_low_priority(config: &LoadShedConfig, path: &str) -> bool {
+        config
+            .low_priority_prefixes
+            .iter()
+            .any(|prefix| path.starts_with(prefix.as_str()))
+    }
+
+    fn overloaded(&self, config: &LoadShedConfig) -> bool {
+        self.inner.pressured.load(Ordering::Relaxed)
+            || self.inner.in_flight.load(Ordering::Relaxed) >= config.max_in_flight
+    }
+
+    fn reject(config: &LoadShedConfig) -> Response {
+        let mut response = ApiResponse::<()> {
+            status: 503,
+            data: ApiData::Empty,
+            message: ApiMsg::ServiceOverloaded,
+        }
+        .into_response();
+        response
+            .headers_mut()
+            .insert(RETRY_AFTER, HeaderValue::from(config.retry_after_secs));
+        response
+    }
+
+    /// Re-evaluates the queue depth and the p99 once per window.
+    pub async fn run(self) {
+        loop {
+            let app_config = self.config();
+            let config = &app_config.load_shed;
+            tokio::time::sleep(Duration::from_secs(config.window_secs.max(1))).await;
+
+            let queued = self.inner.task_manager.get_stats().queued;
+            let p99_ms = self.inner.window.take_p99_ms();
+            let pressured = queued > config.max_queue_depth
+                || p99_ms.is_some_and(|p99| p99 > config.p99_threshold_ms);
+
+            if self.inner.pressured.swap(pressured, Ordering::Relaxed) != pressured {
+                if pressured {
+                    tracing::warn!(queued, p99_ms, "overloaded, shedding low priority requests");
+                } else {
+                    tracing::info!(queued, p99_ms, "load back to normal, no longer shedding");
+                }
+            }
+        }
+    }
+}
+
+pub async fn load_shed(
+    State(shedder): State<LoadShedder>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let app_config = shedder.config();
+    let config = &app_config.load_shed;
+    if !config.enabled {
+        return next.run(request).await;
+    }
+
+    if LoadShedder::is_low_priority(config, request.uri().path()) && shedder.overloaded(config) {
+        tracing::debug!(path = %request.uri().path(), "shedding request");
+        return LoadShedder::reject(config);
+    }
+
+    shedder.inner.in_flight.fetch_add(1, Ordering::Relaxed);
+    let _in_flight = InFlight(&shedder.inner.in_flight);
+    let started = Instant::now();
+    let response = next.run(request).await;
+    shedder.inner.window.record(started.elapsed());
+    response
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn p99_is_the_bound_of_its_bucket() {
+        let window = LatencyWindow {
+            buckets: std::array::from_fn(|_| AtomicU64::new(0)),
+        };
+        assert_eq!(window.take_p99_ms(), None);
+
+        for _ in 0..990 {
+            window.record(Duration::from_millis(3));
+        }
+        for _ in 0..10 {
+            window.record(Duration::from_millis(700));
+        }
+        assert_eq!(window.take_p99_ms(), Some(4));
+
+        for _ in 0..980 {
+            window.record(Duration::from_micros(500));
+        }
+        for _ in 0..20 {
+            window.record(Duration::from_millis(700));
+        }
+        assert_eq!(window.take_p99_ms(), Some(1024));
+    }
+}
@@ -0,0 +1,35 @@
+use axum::{
+    Json,
+    extract::{Request, State},
+    http::StatusCode,
+    middleware::Next,
+    response::{IntoResponse as _, Response},
+};
+use share::models::api::{ApiData, ApiMsg, ApiResponse};
+
+use super::unversioned;
+use crate::service::MaintenanceService;
+
+/// Answers write endpoints with 503 and the announcement while maintenance
+/// is on, so the frontend can show why instead of a generic error. Reads
+/// keep working, results stay up during the maintenance.
+pub async fn maintenance(
+    State(maintenance): State<MaintenanceService>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let Some(status) = maintenance.blocks(unversioned(request.uri().path())) else {
+        return next.run(request).await;
+    };
+
+    tracing::debug!(path = %request.uri().path(), "rejecting write during maintenance");
+    (
+        StatusCode::SERVICE_UNAVAILABLE,
+        Json(ApiResponse {
+            status: 503,
+            data: ApiData::Data(status),
+            message: ApiMsg::UnderMaintenance,
+        }),
+    )
+        .into_response()
+}
@@ -0,0 +1,17 @@
+pub mod access_log;
+pub mod api_key;
+pub mod consent;
+pub mod load_shed;
+pub mod maintenance;
+pub mod rate_limit;
+pub mod request_id;
+pub mod route_stats;
+
+/// `path` without its `/api/v1` or `/api/v2` prefix, the unversioned
+/// paths are served as v1.
+pub fn unversioned(path: &str) -> &str {
+    ["/api/v1", "/api/v2"]
+        .iter()
+        .find_map(|prefix| path.strip_prefix(prefix))
+        .unwrap_or(path)
+}
@@ -0,0 +1,142 @@
+use std::{net::SocketAddr, num::NonZeroU32, sync::Arc, time::Duration};
+
+use axum::{
+    extract::{ConnectInfo, Request, State},
+    http::{HeaderValue, header::RETRY_AFTER},
+    middleware::Next,
+    response::{IntoResponse as _, Response},
+};
+use governor::{DefaultKeyedRateLimiter, Quota, RateLimiter, clock::Clock as _};
+use share::{
+    client_ip::limit_key,
+    config::{RateLimitConfig, RateLimitKey},
+    models::api::{ApiData, ApiMsg, ApiResponse},
+};
+
+use super::{api_key::KEY_NAME_HEADER, unversioned};
+
+/// How often keys which are back to a full bucket are dropped.
+const PRUNE_INTERVAL: Duration = Duration::from_secs(60);
+
+struct Profile {
+    name: String,
+    key: RateLimitKey,
+    paths: Vec<String>,
+    limiter: DefaultKeyedRateLimiter<String>,
+}
+
+/// The configured limiter profiles. Changing them needs a restart, the
+/// buckets would not survive a rebuild anyway.
+#[derive(Clone)]
+pub struct RateLimits {
+    profiles: Arc<Vec<Profile>>,
+    api_key_header: String,
+    /// IPv6 clients share a bucket per network, like the vote limits.
+    ipv6_prefix_len: u8,
+}
+
+impl RateLimits {
+    pub fn new(config: &RateLimitConfig, api_key_header: &str, ipv6_prefix_len: u8) -> Self {
+        let profiles = config
+            .profiles
+            .iter()
+            .filter(|_| config.enabled)
+            .filter_map(|(name, profile)| {
+                let requests = NonZeroU32::new(profile.requests)?;
+                let period = Duration::from_secs(profile.window_secs.max(1)) / requests.get();
+                let quota = Quota::with_period(period)?.allow_burst(requests);
+                Some(Profile {
+                    name: name.clone(),
+                    key: profile.key,
+                    paths: profile.paths.clone(),
+                    limiter: RateLimiter::keyed(quota),
+                })
+            })
+            .collect();
+
+        Self {
+            profiles: Arc::new(profiles),
+            api_key_header: api_key_header.to_string(),
+            ipv6_prefix_len,
+        }
+    }
+
+    /// Returns the profile which rejected the request and when to retry.
+    fn check(&self, request: &Request) -> Result<(), (&str, Duration)> {
+        let path = unversioned(request.uri().path());
+        let ip = || {
+            request
+                .extensions()
+                .get::<ConnectInfo<SocketAddr>>()
+                .map(|ConnectInfo(addr)| {
+                    limit_key(&addr.ip().to_string(), self.ipv6_prefix_len).into_owned()
+                })
+                .unwrap_or_default()
+        };
+
+        for profile in self.profiles.iter() {
+            if !profile
+                .paths
+                .iter()
+                .any(|prefix| path.starts_with(prefix.as_str()))
+            {
+                continue;
+            }
+
+            let api_key = match profile.key {
+                RateLimitKey::Ip => None,
+                // signed requests name their key instead of sending it
+                RateLimitKey::ApiKey => request
+                    .headers()
+                    .get(self.api_key_header.as_str())
+                    .or_else(|| request.headers().get(KEY_NAME_HEADER))
+                    .and_then(|value| value.to_str().ok()),
+            };
+            let key = match api_key {
+                Some(api_key) => format!("key:{api_key}"),
+                None => format!("ip:{}", ip()),
+            };
+
+            if let Err(not_until) = profile.limiter.check_key(&key) {
+                let wait = not_until.wait_time_from(profile.limiter.clock().now());
+                return Err((&profile.name, wait));
+            }
+        }
+        Ok(())
+    }
+
+    /// Keeps the keyed buckets from growing with every address ever seen.
+    pub async fn prune(self) {
+        let mut interval = tokio::time::interval(PRUNE_INTERVAL);
+        loop {
+            interval.tick().await;
+            for profile in self.profiles.iter() {
+                profile.limiter.retain_recent();
+                profile.limiter.shrink_to_fit();
+            }
+        }
+    }
+}
+
+pub async fn rate_limit(
+    State(limits): State<RateLimits>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let Err((profile, wait)) = limits.check(&request) else {
+        return next.run(request).await;
+    };
+
+    tracing::debug!(path = %request.uri().path(), %profile, "rate limited");
+    let mut response = ApiResponse::<()> {
+        status: 429,
+        data: ApiData::Empty,
+        message: ApiMsg::RateLimited,
+    }
+    .into_response();
+    response.headers_mut().insert(
+        RETRY_AFTER,
+        HeaderValue::from(wait.as_secs_f64().ceil().max(1.0) as u64),
+    );
+    response
+}
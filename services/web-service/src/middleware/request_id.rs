@@ -0,0 +1,68 @@
+use axum::{
+    extract::Request,
+    http::{HeaderName, HeaderValue},
+    middleware::Next,
+    response::Response,
+};
+use tower_http::request_id::RequestId;
+
+pub const REQUEST_ID_HEADER: HeaderName = HeaderName::from_static("x-request-id");
+
+/// Upper bound for client supplied ids, longer values are replaced.
+const MAX_REQUEST_ID_LEN: usize = 128;
+
+tokio::task_local! {
+    static REQUEST_ID: String;
+}
+
+/// Returns the request id of the request currently being handled, if any.
+pub fn current() -> Option<String> {
+    REQUEST_ID.try_with(|id| id.clone()).ok()
+}
+
+/// Runs `fut` with `request_id` as [`current`], for work moved off the
+/// request's task.
+pub async fn scope<F: Future>(request_id: Option<String>, fut: F) -> F::Output {
+    REQUEST_ID.scope(request_id.unwrap_or_default(), fut).await
+}
+
+/// Reads the request id out of a header value, rejecting anything that is not
+/// a short printable ascii token so it can be written into logs as-is.
+pub fn parse(value: &HeaderValue) -> Option<&str> {
+    let value = value.to_str().ok()?;
+    let valid = !value.is_empty()
+        && value.len() <= MAX_REQUEST_ID_LEN
+        && value
+            .bytes()
+            .all(|b| b.is_ascii_alphanumeric() || matches!(b, b'-' | b'_' | b'.'));
+    valid.then_some(value)
+}
+
+/// Drops client supplied request ids that are malformed, so that
+/// `SetRequestIdLayer` generates a fresh one instead.
+pub async fn sanitize_request_id(mut request: Request, next: Next) -> Response {
+    if let Some(value) = request.headers().get(&REQUEST_ID_HEADER)
+        && parse(value).is_none()
+    {
+        request.headers_mut().remove(&REQUEST_ID_HEADER);
+    }
+    next.run(request).await
+}
+
+/// Makes the request id available to handlers and error responses through
+/// [`current`], and tags the Sentry events of the request with it.
+pub async fn scope_request_id(request: Request, next: Next) -> Response {
+    let request_id = request
+        .extensions()
+        .get::<RequestId>()
+        .and_then(|id| parse(id.header_value()))
+        .unwrap_or_default()
+        .to_owned();
+    // the sentry layer gives every request a hub of its own, so the tag only
+    // lands on events of this request
+    if !request_id.is_empty() {
+        sentry::configure_scope(|scope| scope.set_tag("request_id", &request_id));
+    }
+
+    REQUEST_ID.scope(request_id, next.run(request)).await
+}
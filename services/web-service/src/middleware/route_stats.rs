@@ -0,0 +1,244 @@
+use std::{
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use axum::{
+    extract::{MatchedPath, Request, State},
+    middleware::Next,
+    response::Response,
+};
+use dashmap::DashMap;
+use hdrhistogram::Histogram;
+use parking_lot::Mutex;
+use share::{
+    config::SloConfig,
+    models::api::{RouteSloReport, SystemSloResponse},
+};
+
+/// Latencies are recorded in microseconds, up to a minute.
+const MAX_LATENCY_US: u64 = 60_000_000;
+
+/// One minute of one route.
+struct Slot {
+    minute: u64,
+    latencies: Histogram<u64>,
+    errors: u64,
+    slow: u64,
+}
+
+impl Slot {
+    fn new() -> Self {
+        Self {
+            minute: u64::MAX,
+            latencies: Histogram::new_with_bounds(1, MAX_LATENCY_US, 2)
+                .expect("histogram bounds are valid"),
+            errors: 0,
+            slow: 0,
+        }
+    }
+}
+
+/// A ring of per-minute slots covering the longest window.
+struct RouteSlots {
+    slots: Vec<Slot>,
+}
+
+impl RouteSlots {
+    fn slot_mut(&mut self, minute: u64) -> &mut Slot {
+        let len = self.slots.len() as u64;
+        let slot = &mut self.slots[(minute % len) as usize];
+        if slot.minute != minute {
+            slot.minute = minute;
+            slot.latencies.reset();
+            slot.errors = 0;
+            slot.slow = 0;
+        }
+        slot
+    }
+}
+
+struct Inner {
+    config: SloConfig,
+    started: Instant,
+    slots_per_route: usize,
+    routes: DashMap<String, Mutex<RouteSlots>>,
+}
+
+/// Latency histograms and error counts per matched route over rolling
+/// windows, so the SLO can be checked from the app itself when the
+/// monitoring stack is the thing that is down.
+#[derive(Clone)]
+pub struct RouteStats {
+    inner: Arc<Inner>,
+}
+
+impl RouteStats {
+    pub fn new(config: SloConfig) -> Self {
+        let slots_per_route = config
+            .windows_mins
+            .iter()
+            .copied()
+            .max()
+            .unwrap_or(1)
+            .max(1) as usize;
+        Self {
+            inner: Arc::new(Inner {
+                config,
+                started: Instant::now(),
+                slots_per_route,
+                routes: DashMap::new(),
+            }),
+        }
+    }
+
+    fn current_minute(&self) -> u64 {
+        self.inner.started.elapsed().as_secs() / 60
+    }
+
+    fn record(&self, route: &str, latency: Duration, failed: bool) {
+        let minute = self.current_minute();
+        let slow = latency.as_millis() as u64 > self.inner.config.latency_target_ms;
+
+        if !self.inner.routes.contains_key(route) {
+            self.inner
+                .routes
+                .entry(route.to_string())
+                .or_insert_with(|| {
+                    Mutex::new(RouteSlots {
+                        slots: (0..self.inner.slots_per_route)
+                            .map(|_| Slot::new())
+                            .collect(),
+                    })
+                });
+        }
+        let Some(slots) = self.inner.routes.get(route) else {
+            return;
+        };
+
+        let mut slots = slots.lock();
+        let slot = slots.slot_mut(minute);
+        slot.latencies
+            .saturating_record((latency.as_micros() as u64).max(1));
+        if failed {
+            slot.errors += 1;
+        } else if slow {
+            slot.slow += 1;
+        }
+    }
+
+    pub fn report(&self) -> SystemSloResponse {
+        let config = &self.inner.config;
+        let now = self.current_minute();
+        let mut routes = Vec::new();
+
+        for entry in self.inner.routes.iter() {
+            let slots = entry.value().lock();
+            for &window_mins in &config.windows_mins {
+                let mut latencies = Histogram::<u64>::new_with_bounds(1, MAX_LATENCY_US, 2)
+                    .expect("histogram bounds are valid");
+                let (mut errors, mut slow) = (0, 0);
+                for slot in &slots.slots {
+                    // the current minute counts as part of every window
+                    if slot.minute <= now && now - slot.minute < window_mins {
+                        let _ = latencies.add(&slot.latencies);
+                        errors += slot.errors;
+                        slow += slot.slow;
+                    }
+                }
+
+                let requests = latencies.len();
+                if requests == 0 {
+                    continue;
+                }
+
+                let allowed_bad = (1.0 - config.availability_target) * requests as f64;
+                let bad = (errors + slow) as f64;
+                // a target of 1.0 leaves no budget, any bad request spends it all
+                let error_budget_remaining = match allowed_bad > 0.0 {
+                    true => 1.0 - bad / allowed_bad,
+                    false if bad > 0.0 => 0.0,
+                    false => 1.0,
+                };
+                let ms = |quantile| latencies.value_at_quantile(quantile) as f64 / 1000.0;
+
+                routes.push(RouteSloReport {
+                    route: entry.key().clone(),
+                    window_mins,
+                    requests,
+                    p50_ms: ms(0.5),
+                    p95_ms: ms(0.95),
+                    p99_ms: ms(0.99),
+                    errors,
+                    slow,
+                    error_budget_remaining,
+                });
+            }
+        }
+
+        routes.sort_by(|a, b| {
+            a.route
+                .cmp(&b.route)
+                .then(a.window_mins.cmp(&b.window_mins))
+        });
+        SystemSloResponse {
+            latency_target_ms: config.latency_target_ms,
+            availability_target: config.availability_target,
+            routes,
+        }
+    }
+}
+
+/// Applied with `Router::layer` so the matched path is known, requests which
+/// match no route are left out instead of adding a key per scanned url.
+pub async fn track_route(
+    State(stats): State<RouteStats>,
+    request: Request,
+    next: Next,
+) -> Response {
+    let Some(path) = request.extensions().get::<MatchedPath>() else {
+        return next.run(request).await;
+    };
+    let route = format!("{} {}", request.method(), path.as_str());
+
+    let started = Instant::now();
+    let response = next.run(request).await;
+    stats.record(
+        &route,
+        started.elapsed(),
+        response.status().is_server_error(),
+    );
+
+    response
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn budget_is_spent_by_errors_and_slow_requests() {
+        let stats = RouteStats::new(SloConfig {
+            latency_target_ms: 100,
+            availability_target: 0.9,
+            windows_mins: vec![5],
+        });
+
+        for _ in 0..95 {
+            stats.record("GET /a", Duration::from_millis(10), false);
+        }
+        for _ in 0..4 {
+            stats.record("GET /a", Duration::from_millis(200), false);
+        }
+        stats.record("GET /a", Duration::from_millis(10), true);
+
+        let report = stats.report();
+        let route = &report.routes[0];
+        assert_eq!(route.requests, 100);
+        assert_eq!(route.errors, 1);
+        assert_eq!(route.slow, 4);
+        // 10 bad requests allowed, 5 spent
+        assert!((route.error_budget_remaining - 0.5).abs() < 1e-9);
+        assert!(route.p50_ms < 11.0);
+    }
+}
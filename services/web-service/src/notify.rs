@@ -0,0 +1,111 @@
+use std::time::Duration;
+
+use lettre::{
+    AsyncSmtpTransport, AsyncTransport as _, Message, Tokio1Executor,
+    message::{Mailbox, header::ContentType},
+};
+use serde::{Deserialize, Serialize};
+use share::{
+    config::{NotificationKind, NotifyTarget},
+    http_client::HttpClient,
+};
+
+use crate::error::AppError;
+
+/// Something to tell people rather than systems, sent to every channel of
+/// `[notify]` which takes its kind.
+#[derive(Clone, Debug, Serialize, Deserialize)]
+pub struct Notification {
+    pub kind: NotificationKind,
+    pub subject: String,
+    pub body: String,
+    /// Mails this address instead of the recipients of the channel, e.g. for
+    /// a password reset. Webhook channels never get these.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub to: Option<String>,
+}
+
+impl Notification {
+    pub fn new(kind: NotificationKind, subject: String, body: String) -> Self {
+        Self {
+            kind,
+            subject,
+            body,
+            to: None,
+        }
+    }
+}
+
+/// Sends notifications over one `[[notify.channels]]` entry.
+pub enum Notifier {
+    Email {
+        transport: AsyncSmtpTransport<Tokio1Executor>,
+        from: Mailbox,
+        to: Vec<Mailbox>,
+    },
+    /// Posts the [`Notification`] as JSON.
+    Webhook {
+        http: HttpClient,
+        url: String,
+        timeout: Duration,
+    },
+}
+
+impl Notifier {
+    pub fn from_config(
+        target: &NotifyTarget,
+        http: &HttpClient,
+        timeout: Duration,
+    ) -> Result<Self, AppError> {
+        match target {
+            NotifyTarget::Email { smtp_url, from, to } => Ok(Notifier::Email {
+                transport: AsyncSmtpTransport::<Tokio1Executor>::from_url(smtp_url)?
+                    .timeout(Some(timeout))
+                    .build(),
+                from: from.parse()?,
+                to: to.iter().map(|to| to.parse()).collect::<Result<_, _>>()?,
+            }),
+            NotifyTarget::Webhook { url } => Ok(Notifier::Webhook {
+                http: http.clone(),
+                url: url.clone(),
+                timeout,
+            }),
+        }
+    }
+
+    pub fn is_email(&self) -> bool {
+        matches!(self, Notifier::Email { .. })
+    }
+
+    pub async fn send(&self, notification: &Notification) -> Result<(), AppError> {
+        match self {
+            Notifier::Email {
+                transport,
+                from,
+                to,
+            } => {
+                let recipients = match &notification.to {
+                    Some(address) => vec![address.parse::<Mailbox>()?],
+                    None => to.clone(),
+                };
+                let mut message = Message::builder()
+                    .from(from.clone())
+                    .subject(&notification.subject)
+                    .header(ContentType::TEXT_PLAIN);
+                for recipient in recipients {
+                    message = message.to(recipient);
+                }
+                transport
+                    .send(message.body(notification.body.clone())?)
+                    .await?;
+            }
+            Notifier::Webhook { http, url, timeout } => {
+                http.send(http.post(url).timeout(*timeout).json(notification))
+                    .await?
+                    .error_for_status()?;
+            }
+        }
+
+        Ok(())
+    }
+}
@@ -0,0 +1,375 @@
+//! One-off operational commands of the cli, run against the configured
+//! databases without starting a server.
+
+use std::{collections::HashMap, path::Path};
+
+use chrono::Utc;
+use eyre::Context as _;
+use futures::TryStreamExt as _;
+use mongodb::{
+    IndexModel,
+    bson::{Document, doc},
+    options::IndexOptions,
+};
+use share::{
+    config::AppConfig,
+    http_client::HttpClient,
+    models::database::{ArchivedResults, VotingTopic},
+};
+
+use crate::{
+    legacy_import::{LegacyDump, LegacyMatchup, LegacyOption, LegacyTopic},
+    load_local_character_infos,
+    service::{OperatorService, derive_signing_secret, hash_api_key},
+};
+
+async fn connect_mongodb(config: &AppConfig) -> eyre::Result<mongodb::Database> {
+    Ok(mongodb::Client::with_uri_str(&config.database.mongodb_url)
+        .await
+        .context("failed to connect to MongoDB")?
+        .database(&config.database.mongodb_database))
+}
+
+async fn connect_redis(config: &AppConfig) -> eyre::Result<redis::aio::MultiplexedConnection> {
+    let client = redis::Client::open(&*config.database.redis_url)
+        .context("failed to create Redis client")?;
+    Ok(client.get_multiplexed_async_connection().await?)
+}
+
+/// `(collection, keys, unique)` of every index the services query by.
+fn indexes() -> Vec<(&'static str, Document, bool)> {
+    vec![
+        ("topics", doc! { "id": 1 }, true),
+        ("api_keys", doc! { "key_hash": 1 }, true),
+        ("api_keys", doc! { "name": 1 }, true),
+        ("operators", doc! { "id": 1 }, true),
+        ("operator_aliases", doc! { "alias": 1 }, true),
+        (
+            "option_images",
+            doc! { "topic_id": 1, "option_id": 1 },
+            false,
+        ),
+        ("webhooks", doc! { "id": 1 }, true),
+        (
+            "webhook_deliveries",
+            doc! { "webhook_id": 1, "created_at": -1 },
+            false,
+        ),
+        ("audit_log", doc! { "topic_id": 1, "created_at": -1 }, false),
+        ("audit_log", doc! { "chain.at": 1 }, false),
+        ("task_queue", doc! { "id": 1 }, true),
+        ("task_queue", doc! { "status": 1, "run_at": 1 }, false),
+        (
+            "task_queue",
+            doc! { "priority": 1, "status": 1, "run_at": 1 },
+            false,
+        ),
+        ("event_outbox", doc! { "id": 1 }, true),
+        (
+            "event_outbox",
+            doc! { "locked_until": 1, "created_at": 1 },
+            false,
+        ),
+        ("event_outbox", doc! { "claim": 1 }, false),
+        (
+            "results_snapshots",
+            doc! { "topic_id": 1, "taken_at": -1 },
+            false,
+        ),
+        ("datasets", doc! { "topic_id": 1 }, true),
+        ("voter_exports", doc! { "id": 1 }, true),
+        ("consents", doc! { "ip": 1, "version": 1 }, false),
+        ("comments", doc! { "id": 1 }, true),
+        (
+            "comments",
+            doc! { "topic_id": 1, "parent_id": 1, "id": -1 },
+            false,
+        ),
+        (
+            "content_reports",
+            doc! { "target_kind": 1, "target_id": 1, "status": 1 },
+            false,
+        ),
+        (
+            "content_reports",
+            doc! { "status": 1, "report_count": -1, "last_reported_at": -1 },
+            false,
+        ),
+        ("announcements", doc! { "id": 1 }, true),
+        ("topic_bookmarks", doc! { "ip": 1, "topic_id": 1 }, true),
+        (
+            "topic_bookmarks",
+            doc! { "topic_id": 1, "reminded_at": 1 },
+            false,
+        ),
+        ("topic_bookmarks", doc! { "confirm_token": 1 }, false),
+        (
+            "merkle_commitments",
+            doc! { "topic_id": 1, "committed_at": -1 },
+            false,
+        ),
+        ("daily_digests", doc! { "topic_id": 1, "date": 1 }, true),
+        ("daily_digests", doc! { "date": -1 }, false),
+        ("vote_heatmaps", doc! { "topic_id": 1 }, true),
+    ]
+}
+
+/// Creates the indexes, backs the `migrate` command. Creating an existing
+/// index is a no-op, so it runs on every deploy.
+pub async fn migrate(config: AppConfig) -> eyre::Result<()> {
+    let mongodb = connect_mongodb(&config).await?;
+
+    for (collection, keys, unique) in indexes() {
+        let index = IndexModel::builder()
+            .keys(keys.clone())
+            .options(IndexOptions::builder().unique(unique).build())
+            .build();
+        mongodb
+            .collection::<Document>(collection)
+            .create_index(index)
+            .await
+            .with_context(|| format!("failed to create index {keys} on {collection}"))?;
+        tracing::info!("index {} on {} is in place", keys, collection);
+    }
+
+    // entries from before the audit log was chained have no sequence number
+    let chain_index = IndexModel::builder()
+        .keys(doc! { "chain.seq": 1 })
+        .options(
+            IndexOptions::builder()
+                .unique(true)
+                .partial_filter_expression(doc! { "chain.seq": { "$exists": true } })
+                .build(),
+        )
+        .build();
+    mongodb
+        .collection::<Document>("audit_log")
+        .create_index(chain_index)
+        .await
+        .context("failed to create the chain index on audit_log")?;
+    tracing::info!("index {{ chain.seq: 1 }} on audit_log is in place");
+
+    Ok(())
+}
+
+/// Inserts the preset topics of the config, replacing stored ones with the
+/// same id.
+pub(crate) async fn seed_preset_topics(
+    mongodb: &mongodb::Database,
+    preset_topics: &[VotingTopic],
+) -> Result<(), mongodb::error::Error> {
+    let collection = mongodb.collection::<VotingTopic>("topics");
+
+    for preset_topic in preset_topics {
+        let filter = doc! { "id": &preset_topic.id };
+
+        match collection.find_one(filter).await {
+            Ok(Some(_)) => {
+                let query = doc! { "id": &preset_topic.id };
+                collection.replace_one(query, preset_topic).await?;
+                tracing::info!("updated preset voting topic: {}", preset_topic.id);
+            }
+            Ok(None) => {
+                tracing::info!("inserting preset voting topic: {}", preset_topic.id);
+                collection.insert_one(preset_topic).await?;
+            }
+            Err(_) => {
+                // maybe data structure has changed, we force replace
+                let query = doc! { "id": &preset_topic.id };
+                collection.replace_one(query, preset_topic).await?;
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Backs the `seed` command, the web server does the same on startup.
+pub async fn seed(config: AppConfig) -> eyre::Result<()> {
+    let mongodb = connect_mongodb(&config).await?;
+    seed_preset_topics(&mongodb, &config.vote.preset_vote_topic).await?;
+    Ok(())
+}
+
+/// `topic_ids`, or every stored topic when empty.
+async fn select_topics(
+    mongodb: &mongodb::Database,
+    topic_ids: &[String],
+) -> eyre::Result<Vec<VotingTopic>> {
+    let filter = match topic_ids {
+        [] => doc! {},
+        ids => doc! { "id": { "$in": ids } },
+    };
+    let topics: Vec<VotingTopic> = mongodb
+        .collection::<VotingTopic>("topics")
+        .find(filter)
+        .await?
+        .try_collect()
+        .await?;
+
+    for id in topic_ids {
+        if !topics.iter().any(|topic| &topic.id == id) {
+            eyre::bail!("topic {id} not found");
+        }
+    }
+    Ok(topics)
+}
+
+struct TopicResults {
+    stats: HashMap<String, i64>,
+    matrix: HashMap<String, i64>,
+    count: i64,
+}
+
+async fn read_results(
+    connection: &mut redis::aio::MultiplexedConnection,
+    topic_id: &str,
+) -> eyre::Result<TopicResults> {
+    let (stats, matrix, count): (HashMap<String, i64>, HashMap<String, i64>, Option<i64>) =
+        redis::pipe()
+            .hgetall(format!("{topic_id}:op_stats"))
+            .hgetall(format!("{topic_id}:op_matrix"))
+            .get(format!("{topic_id}:valid_ballots_count"))
+            .query_async(connection)
+            .await?;
+    Ok(TopicResults {
+        stats,
+        matrix,
+        count: count.unwrap_or(0),
+    })
+}
+
+/// Writes the topics in the format `import-legacy` reads, backs the `export`
+/// command. The matrix only keeps the net count per pair, so the results
+/// carry that: importing the export reproduces the matrix and the ranking,
+/// not the raw win and loss totals.
+pub async fn export(
+    config: AppConfig,
+    topic_ids: &[String],
+    output: Option<&Path>,
+) -> eyre::Result<()> {
+    let mongodb = connect_mongodb(&config).await?;
+    let mut connection = connect_redis(&config).await?;
+
+    let http = HttpClient::new(&config.http_client)?;
+    let operators = OperatorService::new(mongodb.clone(), config.operator_sync, http);
+    if operators.refresh().await? == 0 {
+        operators.set_character_infos(load_local_character_infos()?, HashMap::new());
+    }
+    let names: HashMap<i32, String> = operators
+        .character_infos()
+        .iter()
+        .map(|info| (info.id, info.name.clone()))
+        .collect();
+
+    let mut dump = LegacyDump { topics: Vec::new() };
+    for topic in select_topics(&mongodb, topic_ids).await? {
+        let results = read_results(&mut connection, &topic.id).await?;
+
+        let mut option_ids: Vec<i32> = results
+            .stats
+            .keys()
+            .filter_map(|field| field.split_once(':')?.0.parse().ok())
+            .collect();
+        option_ids.sort_unstable();
+        option_ids.dedup();
+
+        let mut matchups: Vec<LegacyMatchup> = results
+            .matrix
+            .iter()
+            .filter(|&(_, &count)| count > 0)
+            .filter_map(|(field, &count)| {
+                let (winner, loser) = field.split_once(':')?;
+                Some(LegacyMatchup {
+                    winner: winner.parse().ok()?,
+                    loser: loser.parse().ok()?,
+                    count,
+                })
+            })
+            .collect();
+        matchups.sort_unstable_by_key(|matchup| (matchup.winner, matchup.loser));
+
+        let unnamed = option_ids
+            .iter()
+            .filter(|id| !names.contains_key(id))
+            .count();
+        if unnamed > 0 {
+            tracing::warn!("topic {}: {} operators without a name", topic.id, unnamed);
+        }
+        tracing::info!(
+            "topic {}: {} operators, {} matchups",
+            topic.id,
+            option_ids.len(),
+            matchups.len()
+        );
+
+        dump.topics.push(LegacyTopic {
+            id: topic.id,
+            name: topic.name,
+            title: Some(topic.title),
+            description: topic.description,
+            open_time: topic.open_time,
+            close_time: topic.close_time,
+            valid_ballots: results.count,
+            options: option_ids
+                .into_iter()
+                .map(|id| LegacyOption {
+                    id,
+                    name: names.get(&id).cloned().unwrap_or_else(|| id.to_string()),
+                })
+                .collect(),
+            results: matchups,
+        });
+    }
+
+    let data = serde_json::to_vec_pretty(&dump)?;
+    match output {
+        Some(path) => tokio::fs::write(path, data)
+            .await
+            .with_context(|| format!("failed to write {}", path.display()))?,
+        None => println!("{}", String::from_utf8_lossy(&data)),
+    }
+    Ok(())
+}
+
+/// Copies the results of the topics from redis to the `results_snapshots`
+/// collection, backs the `snapshot` command. Meant for before anything that
+/// could lose the redis data, e.g. a migration or a flush.
+pub async fn snapshot(config: AppConfig, topic_ids: &[String]) -> eyre::Result<()> {
+    let mongodb = connect_mongodb(&config).await?;
+    let mut connection = connect_redis(&config).await?;
+    let backups = mongodb.collection::<ArchivedResults>("results_snapshots");
+
+    for topic in select_topics(&mongodb, topic_ids).await? {
+        let results = read_results(&mut connection, &topic.id).await?;
+        tracing::info!("topic {}: {} ballots", topic.id, results.count);
+        backups
+            .insert_one(ArchivedResults {
+                topic_id: topic.id,
+                taken_at: Utc::now(),
+                count: results.count,
+                stats: results.stats,
+                matrix: results.matrix,
+            })
+            .await?;
+    }
+    Ok(())
+}
+
+/// Prints the signing secret of the key read from stdin, backs the
+/// `signing-secret` command. Issued keys get theirs in the response, the
+/// static keys of the config only this way.
+pub fn signing_secret(config: AppConfig) -> eyre::Result<()> {
+    let pepper = &config.api_key.signing_pepper;
+    if pepper.is_empty() {
+        eyre::bail!("api_key.signing_pepper is not set");
+    }
+    let mut key = String::new();
+    std::io::stdin()
+        .read_line(&mut key)
+        .context("failed to read the key from stdin")?;
+    println!(
+        "{}",
+        derive_signing_secret(pepper, &hash_api_key(key.trim()))
+    );
+    Ok(())
+}
@@ -0,0 +1,178 @@
+//! Transactional outbox of the domain events. A change begins with
+//! [`Outbox::begin`], makes its writes in the session of the returned
+//! [`Change`] and commits them together with its events, which a relay then
+//! publishes to the bus. Events are published at least once, consumers
+//! dedupe them by id. Without `[events.outbox]` a change writes without a
+//! session and publishes its events directly once it is committed.
+
+use std::time::Duration;
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{ClientSession, Collection, bson::doc};
+use share::{
+    config::OutboxConfig,
+    events::{DomainEvent, EventBus},
+    jobs::{JobManager, Stage, Stopping},
+    models::database::OutboxEvent,
+};
+use uuid::Uuid;
+
+use crate::error::AppError;
+
+/// Writes of one change and the events describing them.
+pub struct Change {
+    session: Option<ClientSession>,
+    events: Vec<DomainEvent>,
+}
+
+impl Change {
+    /// The session every write of the change has to use, `None` without the
+    /// outbox. A change dropped before its commit is aborted.
+    pub fn session(&mut self) -> Option<&mut ClientSession> {
+        self.session.as_mut()
+    }
+
+    pub fn push(&mut self, event: DomainEvent) {
+        self.events.push(event);
+    }
+}
+
+#[derive(Clone)]
+pub struct Outbox {
+    mongo: mongodb::Database,
+    rows: Collection<OutboxEvent>,
+    events: EventBus,
+    config: OutboxConfig,
+}
+
+impl Outbox {
+    pub fn new(mongo: mongodb::Database, events: EventBus, config: OutboxConfig) -> Self {
+        Self {
+            rows: mongo.collection::<OutboxEvent>("event_outbox"),
+            mongo,
+            events,
+            config,
+        }
+    }
+
+    pub async fn begin(&self) -> Result<Change, AppError> {
+        let session = match self.config.enabled {
+            true => {
+                let mut session = self.mongo.client().start_session().await?;
+                session.start_transaction().await?;
+                Some(session)
+            }
+            false => None,
+        };
+
+        Ok(Change {
+            session,
+            events: Vec::new(),
+        })
+    }
+
+    pub async fn commit(&self, change: Change) -> Result<(), AppError> {
+        let Change { session, events } = change;
+        let Some(mut session) = session else {
+            self.events.publish_all(events).await;
+            return Ok(());
+        };
+
+        if !events.is_empty() {
+            self.rows
+                .insert_many(events.into_iter().map(OutboxEvent::new))
+                .session(&mut session)
+                .await?;
+        }
+        session.commit_transaction().await?;
+        Ok(())
+    }
+
+    /// Events which don't go with a database write, e.g. phases derived from
+    /// the clock. They still go through the outbox so a bus outage doesn't
+    /// lose them.
+    pub async fn publish(&self, events: Vec<DomainEvent>) -> Result<(), AppError> {
+        let mut change = self.begin().await?;
+        for event in events {
+            change.push(event);
+        }
+        self.commit(change).await
+    }
+
+    /// Runs the relay as a worker job of `jobs`, a no-op without the outbox.
+    pub fn start(&self, jobs: &JobManager) {
+        if !self.config.enabled {
+            return;
+        }
+        let outbox = self.clone();
+        jobs.spawn_graceful("event_outbox", Stage::Worker, |stopping| {
+            outbox.relay(stopping)
+        });
+    }
+
+    async fn relay(self, mut stopping: Stopping) {
+        let idle = Duration::from_millis(self.config.poll_interval_ms.max(10));
+        loop {
+            let published = tokio::select! {
+                published = self.relay_batch() => published,
+                _ = stopping.wait() => return,
+            };
+            match published {
+                // a full batch suggests more are waiting
+                Ok(count) if count >= self.config.batch_size as usize => continue,
+                Ok(_) => {}
+                Err(e) => tracing::warn!("Failed to relay outbox events: {}", e),
+            }
+            tokio::select! {
+                _ = tokio::time::sleep(idle) => {}
+                _ = stopping.wait() => return,
+            }
+        }
+    }
+
+    /// Claims the oldest unclaimed events, publishes them in order and removes
+    /// them. Events of a failed publish stay claimed until their lease is up.
+    async fn relay_batch(&self) -> Result<usize, AppError> {
+        let now = Utc::now().timestamp_millis();
+        let lease = Duration::from_secs(self.config.lease_secs).as_millis() as i64;
+
+        let candidates: Vec<String> = self
+            .rows
+            .find(doc! { "locked_until": { "$lt": now } })
+            .sort(doc! { "created_at": 1 })
+            .limit(self.config.batch_size.into())
+            .await?
+            .map_ok(|row| row.id)
+            .try_collect()
+            .await?;
+        if candidates.is_empty() {
+            return Ok(0);
+        }
+
+        // another instance may have claimed some of them in between
+        let claim = Uuid::new_v4().to_string();
+        self.rows
+            .update_many(
+                doc! { "id": { "$in": candidates }, "locked_until": { "$lt": now } },
+                doc! { "$set": { "locked_until": now + lease, "claim": &claim } },
+            )
+            .await?;
+        let rows: Vec<OutboxEvent> = self
+            .rows
+            .find(doc! { "claim": &claim })
+            .sort(doc! { "created_at": 1 })
+            .await?
+            .try_collect()
+            .await?;
+
+        let events: Vec<DomainEvent> = rows.into_iter().map(|row| row.event).collect();
+        self.events
+            .try_publish(&events)
+            .await
+            .map_err(|e| AppError::InternalError(e.to_string()))?;
+        self.rows.delete_many(doc! { "claim": &claim }).await?;
+
+        Ok(events.len())
+    }
+}
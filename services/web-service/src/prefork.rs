@@ -0,0 +1,116 @@
+//! Prefork mode: the process started by the operator only supervises, every
+//! child is a full web service bound to the same port with `SO_REUSEPORT`
+//! and the kernel spreads connections across them.
+//!
+//! Children share the parent's arguments and find their index in
+//! [`CHILD_ENV`]. The parent holds the write end of each child's stdin,
+//! closing it asks the child to drain, so children also stop when the
+//! parent dies without cleaning up.
+
+use std::{process::Stdio, time::Duration};
+
+use share::{config::ServerConfig, signal::ShutdownRx};
+use tokio::{
+    io::AsyncReadExt as _,
+    process::{Child, Command},
+    task::JoinSet,
+};
+
+pub const CHILD_ENV: &str = "ARK_VOTE_PREFORK_CHILD";
+
+const RESPAWN_DELAY: Duration = Duration::from_secs(1);
+
+/// Index of this process among the prefork children, `None` outside prefork.
+pub fn child_index() -> Option<usize> {
+    std::env::var(CHILD_ENV).ok()?.parse().ok()
+}
+
+/// Resolves once the supervising parent closed our stdin.
+pub async fn parent_gone() {
+    let mut stdin = tokio::io::stdin();
+    let mut buf = [0u8; 64];
+    while matches!(stdin.read(&mut buf).await, Ok(n) if n > 0) {}
+}
+
+fn spawn_child(index: usize) -> std::io::Result<Child> {
+    Command::new(std::env::current_exe()?)
+        .args(std::env::args_os().skip(1))
+        .env(CHILD_ENV, index.to_string())
+        .stdin(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()
+}
+
+/// Keeps one child per configured worker running until `shutdown_rx`
+/// fires, then drains them all.
+pub async fn supervise(config: &ServerConfig, shutdown_rx: ShutdownRx) -> eyre::Result<()> {
+    tracing::info!(
+        "prefork supervisor starting {} workers on {}",
+        config.prefork_workers,
+        config.address()
+    );
+
+    let mut workers = JoinSet::new();
+    for index in 0..config.prefork_workers {
+        workers.spawn(run_child(
+            index,
+            shutdown_rx.clone(),
+            config.shutdown_timeout(),
+        ));
+    }
+    // without waiting for the workers, systemd only needs to know the
+    // supervisor is up, a crashing worker is respawned here
+    share::systemd::ready();
+    let mut stopping_rx = shutdown_rx.clone();
+    share::task::spawn("systemd_stopping", async move {
+        if stopping_rx.changed().await.is_ok() {
+            share::systemd::stopping();
+        }
+    });
+    while workers.join_next().await.is_some() {}
+
+    tracing::info!("prefork supervisor stopped");
+    Ok(())
+}
+
+async fn run_child(index: usize, mut shutdown_rx: ShutdownRx, shutdown_timeout: Duration) {
+    loop {
+        let mut child = match spawn_child(index) {
+            Ok(child) => child,
+            Err(e) => {
+                tracing::error!("failed to spawn prefork worker {}: {}", index, e);
+                tokio::select! {
+                    _ = tokio::time::sleep(RESPAWN_DELAY) => continue,
+                    _ = shutdown_rx.changed() => return,
+                }
+            }
+        };
+        let stdin = child.stdin.take();
+
+        let exited = tokio::select! {
+            status = child.wait() => Some(status),
+            _ = shutdown_rx.changed() => None,
+        };
+        if let Some(status) = exited {
+            tracing::error!(
+                "prefork worker {} exited with {:?}, respawning",
+                index,
+                status
+            );
+            tokio::select! {
+                _ = tokio::time::sleep(RESPAWN_DELAY) => continue,
+                _ = shutdown_rx.changed() => return,
+            }
+        }
+
+        drop(stdin);
+        match tokio::time::timeout(shutdown_timeout, child.wait()).await {
+            Ok(status) => tracing::info!("prefork worker {} stopped with {:?}", index, status),
+            Err(_) => {
+                tracing::warn!("prefork worker {} did not stop in time, killing", index);
+                let _ = child.kill().await;
+            }
+        }
+        return;
+    }
+}
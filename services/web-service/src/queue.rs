@@ -0,0 +1,527 @@
+//! Async work kept in mongo so it survives restarts. Instances claim due
+//! tasks with a lease, failed ones are retried with exponential backoff and
+//! those out of attempts stay in the collection as dead tasks for admins to
+//! retry or delete. A task may be due later, which makes one-shot actions
+//! at a given time. A task is removed only after its handler succeeded, so
+//! it runs at least once and handlers should tolerate running twice. Every
+//! [`TaskPriority`] is claimed and run by its own workers.
+
+use std::{
+    collections::{BTreeMap, HashMap},
+    pin::Pin,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::{DateTime, Utc};
+use futures::TryStreamExt as _;
+use mongodb::{Collection, bson::doc, options::ReturnDocument};
+use parking_lot::{Mutex, RwLock};
+use serde::{Serialize, de::DeserializeOwned};
+use share::{
+    config::TaskQueueConfig,
+    jobs::{JobManager, Stage, Stopping},
+    models::{
+        api::QueueKindStats,
+        database::{QueuedTask, QueuedTaskStatus, TaskPriority},
+    },
+    task::pool::Pool,
+};
+use uuid::Uuid;
+
+use crate::error::AppError;
+
+const LIST_DEFAULT_LIMIT: i64 = 50;
+const LIST_MAX_LIMIT: i64 = 500;
+/// How often the task counts are exported as metrics.
+const METRICS_INTERVAL: Duration = Duration::from_secs(15);
+
+type BoxFuture = Pin<Box<dyn Future<Output = Result<(), String>> + Send + 'static>>;
+type Handler = Arc<dyn Fn(serde_json::Value, u32) -> BoxFuture + Send + Sync>;
+
+#[derive(Copy, Clone, Debug)]
+pub struct RetryPolicy {
+    pub max_attempts: u32,
+    /// Delay before the first retry, doubled after every failed attempt.
+    pub initial_backoff: Duration,
+    pub max_backoff: Duration,
+}
+
+impl RetryPolicy {
+    fn backoff(&self, attempt: u32) -> Duration {
+        self.initial_backoff
+            .saturating_mul(2u32.saturating_pow(attempt.saturating_sub(1)))
+            .min(self.max_backoff)
+    }
+}
+
+#[derive(Clone, Copy)]
+enum Outcome {
+    Succeeded,
+    Retried,
+    Dead,
+}
+
+impl Outcome {
+    fn as_str(&self) -> &'static str {
+        match self {
+            Outcome::Succeeded => "succeeded",
+            Outcome::Retried => "retried",
+            Outcome::Dead => "dead",
+        }
+    }
+}
+
+/// Attempts of one kind run by this instance since it started.
+#[derive(Default)]
+struct RunStats {
+    succeeded: u64,
+    retried: u64,
+    died: u64,
+    total_ms: u64,
+    max_ms: u64,
+}
+
+#[derive(Clone)]
+pub struct TaskQueue {
+    tasks: Collection<QueuedTask>,
+    config: TaskQueueConfig,
+    handlers: Arc<RwLock<HashMap<&'static str, (TaskPriority, RetryPolicy, Handler)>>>,
+    runs: Arc<Mutex<HashMap<String, RunStats>>>,
+}
+
+/// Names of the claiming job and of the worker pool of a priority.
+fn job_names(priority: TaskPriority) -> (&'static str, &'static str) {
+    match priority {
+        TaskPriority::High => ("task_queue_high", "task_queue_high_run"),
+        TaskPriority::Normal => ("task_queue_normal", "task_queue_normal_run"),
+        TaskPriority::Low => ("task_queue_low", "task_queue_low_run"),
+    }
+}
+
+impl TaskQueue {
+    pub fn new(mongo: mongodb::Database, config: TaskQueueConfig) -> Self {
+        Self {
+            tasks: mongo.collection::<QueuedTask>("task_queue"),
+            config,
+            handlers: Arc::default(),
+            runs: Arc::default(),
+        }
+    }
+
+    /// Runs tasks of `kind` with `handler`, which gets the payload and the
+    /// number of the attempt. An `Err` is retried under `policy`.
+    pub fn register<T, F, Fut>(
+        &self,
+        kind: &'static str,
+        priority: TaskPriority,
+        policy: RetryPolicy,
+        handler: F,
+    ) where
+        T: DeserializeOwned + 'static,
+        F: Fn(T, u32) -> Fut + Send + Sync + 'static,
+        Fut: Future<Output = Result<(), String>> + Send + 'static,
+    {
+        let handler: Handler =
+            Arc::new(
+                move |payload, attempt| match serde_json::from_value(payload) {
+                    Ok(payload) => Box::pin(handler(payload, attempt)) as BoxFuture,
+                    Err(e) => {
+                        let e = format!("invalid payload: {e}");
+                        Box::pin(async move { Err(e) })
+                    }
+                },
+            );
+        self.handlers
+            .write()
+            .insert(kind, (priority, policy, handler));
+    }
+
+    pub async fn enqueue(
+        &self,
+        kind: &'static str,
+        payload: &impl Serialize,
+    ) -> Result<String, AppError> {
+        self.enqueue_at(kind, payload, Utc::now()).await
+    }
+
+    /// Queues a task which is not due before `run_at`, returns its id.
+    pub async fn enqueue_at(
+        &self,
+        kind: &'static str,
+        payload: &impl Serialize,
+        run_at: DateTime<Utc>,
+    ) -> Result<String, AppError> {
+        let priority = self
+            .handlers
+            .read()
+            .get(kind)
+            .map(|(priority, ..)| *priority)
+            .unwrap_or_default();
+        let task = QueuedTask {
+            id: Uuid::new_v4().to_string(),
+            kind: kind.to_string(),
+            payload: serde_json::to_value(payload)?,
+            priority,
+            status: QueuedTaskStatus::Pending,
+            attempts: 0,
+            last_error: None,
+            run_at: run_at.timestamp_millis(),
+            locked_until: 0,
+            created_at: Utc::now(),
+        };
+        self.tasks.insert_one(&task).await?;
+        Ok(task.id)
+    }
+
+    /// Claims and runs due tasks as worker jobs of `jobs`, one per priority
+    /// with at most `task_queue.workers` of its tasks at once.
+    pub fn start(&self, jobs: &JobManager) {
+        for priority in TaskPriority::ALL {
+            let workers = match priority {
+                TaskPriority::High => self.config.workers.high,
+                TaskPriority::Normal => self.config.workers.normal,
+                TaskPriority::Low => self.config.workers.low,
+            };
+            let (job_name, pool_name) = job_names(priority);
+            let pool = Pool::new(pool_name, workers, workers, jobs);
+            let queue = self.clone();
+            jobs.spawn_graceful(job_name, Stage::Worker, move |stopping| {
+                queue.poll(priority, pool, stopping)
+            });
+        }
+        jobs.spawn(
+            "task_queue_metrics",
+            Stage::Worker,
+            self.clone().export_metrics(),
+        );
+    }
+
+    /// Task counts as gauges, the runs are recorded as they finish.
+    async fn export_metrics(self) {
+        let mut interval = tokio::time::interval(METRICS_INTERVAL);
+        loop {
+            interval.tick().await;
+            let stats = match self.stats().await {
+                Ok(stats) => stats,
+                Err(e) => {
+                    tracing::debug!("Failed to count queued tasks: {}", e);
+                    continue;
+                }
+            };
+            for kind in stats {
+                for (status, count) in [
+                    ("pending", kind.pending),
+                    ("running", kind.running),
+                    ("dead", kind.dead),
+                ] {
+                    metrics::gauge!(
+                        "task_queue_tasks",
+                        "kind" => kind.kind.clone(),
+                        "status" => status
+                    )
+                    .set(count as f64);
+                }
+            }
+        }
+    }
+
+    async fn poll(self, priority: TaskPriority, pool: Pool, mut stopping: Stopping) {
+        let idle = Duration::from_millis(self.config.poll_interval_ms.max(10));
+        loop {
+            let task = tokio::select! {
+                task = self.claim(priority) => task,
+                _ = stopping.wait() => return,
+            };
+            match task {
+                Ok(Some(task)) => {
+                    let queue = self.clone();
+                    if pool
+                        .submit(async move { queue.run(task).await })
+                        .await
+                        .is_err()
+                    {
+                        return;
+                    }
+                    continue;
+                }
+                Ok(None) => {}
+                Err(e) => tracing::warn!("Failed to claim a queued task: {}", e),
+            }
+            tokio::select! {
+                _ = tokio::time::sleep(idle) => {}
+                _ = stopping.wait() => return,
+            }
+        }
+    }
+
+    /// The next due task of `priority`, pending or with an expired lease.
+    async fn claim(
+        &self,
+        priority: TaskPriority,
+    ) -> Result<Option<QueuedTask>, mongodb::error::Error> {
+        let now = Utc::now().timestamp_millis();
+        let lease = Duration::from_secs(self.config.lease_secs).as_millis() as i64;
+        // tasks queued before priorities existed have none and run as normal
+        let priority = match priority {
+            TaskPriority::Normal => doc! { "$in": ["normal", null] },
+            priority => doc! { "$eq": priority.as_str() },
+        };
+        self.tasks
+            .find_one_and_update(
+                doc! {
+                    "priority": priority,
+                    "$or": [
+                        { "status": "pending", "run_at": { "$lte": now } },
+                        { "status": "running", "locked_until": { "$lt": now } },
+                    ],
+                },
+                doc! {
+                    "$set": { "status": "running", "locked_until": now + lease },
+                    "$inc": { "attempts": 1 },
+                },
+            )
+            .sort(doc! { "run_at": 1 })
+            .return_document(ReturnDocument::After)
+            .await
+    }
+
+    async fn run(&self, task: QueuedTask) {
+        let waited = (Utc::now().timestamp_millis() - task.run_at).max(0);
+        metrics::histogram!("task_queue_wait_seconds", "priority" => task.priority.as_str())
+            .record(waited as f64 / 1000.0);
+
+        let handler = self.handlers.read().get(task.kind.as_str()).cloned();
+        let Some((_, policy, handler)) = handler else {
+            let outcome = self
+                .fail(&task, None, format!("no handler for {}", task.kind))
+                .await;
+            self.record(&task.kind, outcome, Duration::ZERO);
+            return;
+        };
+
+        let started = Instant::now();
+        let result =
+            share::task::guarded("queued_task", handler(task.payload.clone(), task.attempts))
+                .await
+                .unwrap_or_else(|| Err("panicked".to_string()));
+        let outcome = match result {
+            Ok(()) => {
+                if let Err(e) = self.tasks.delete_one(doc! { "id": &task.id }).await {
+                    tracing::warn!("Failed to remove finished task {}: {}", task.id, e);
+                }
+                Outcome::Succeeded
+            }
+            Err(e) => self.fail(&task, Some(policy), e).await,
+        };
+        self.record(&task.kind, outcome, started.elapsed());
+    }
+
+    fn record(&self, kind: &str, outcome: Outcome, duration: Duration) {
+        metrics::counter!(
+            "task_queue_runs_total",
+            "kind" => kind.to_string(),
+            "outcome" => outcome.as_str()
+        )
+        .increment(1);
+        metrics::histogram!("task_queue_run_duration_seconds", "kind" => kind.to_string())
+            .record(duration.as_secs_f64());
+
+        let mut runs = self.runs.lock();
+        let stats = runs.entry(kind.to_string()).or_default();
+        match outcome {
+            Outcome::Succeeded => stats.succeeded += 1,
+            Outcome::Retried => stats.retried += 1,
+            Outcome::Dead => stats.died += 1,
+        }
+        let ms = duration.as_millis() as u64;
+        stats.total_ms += ms;
+        stats.max_ms = stats.max_ms.max(ms);
+    }
+
+    /// Schedules the retry, or marks the task dead once `policy` is out of
+    /// attempts or there is none.
+    async fn fail(&self, task: &QueuedTask, policy: Option<RetryPolicy>, error: String) -> Outcome {
+        let (update, outcome) = match policy.filter(|policy| task.attempts < policy.max_attempts) {
+            Some(policy) => {
+                let run_at = Utc::now().timestamp_millis()
+                    + policy.backoff(task.attempts).as_millis() as i64;
+                (
+                    doc! { "$set": { "status": "pending", "run_at": run_at, "last_error": &error } },
+                    Outcome::Retried,
+                )
+            }
+            None => {
+                tracing::warn!(
+                    "Task {} ({}) is dead after {} attempts: {}",
+                    task.id,
+                    task.kind,
+                    task.attempts,
+                    error
+                );
+                (
+                    doc! { "$set": { "status": "dead", "last_error": &error } },
+                    Outcome::Dead,
+                )
+            }
+        };
+        if let Err(e) = self.tasks.update_one(doc! { "id": &task.id }, update).await {
+            tracing::warn!("Failed to record the failure of task {}: {}", task.id, e);
+        }
+        outcome
+    }
+
+    /// Every registered kind and every kind in the collection, with the task
+    /// counts of the whole queue and the runs of this instance.
+    pub async fn stats(&self) -> Result<Vec<QueueKindStats>, AppError> {
+        let mut kinds: BTreeMap<String, QueueKindStats> = self
+            .handlers
+            .read()
+            .iter()
+            .map(|(kind, (priority, ..))| {
+                let stats = QueueKindStats {
+                    kind: kind.to_string(),
+                    priority: *priority,
+                    ..Default::default()
+                };
+                (kind.to_string(), stats)
+            })
+            .collect();
+
+        let mut groups = self
+            .tasks
+            .aggregate([doc! { "$group": {
+                "_id": { "kind": "$kind", "status": "$status" },
+                "count": { "$sum": 1 },
+            } }])
+            .await?;
+        while let Some(group) = groups.try_next().await? {
+            let (Ok(id), Ok(count)) = (group.get_document("_id"), group.get_i32("count")) else {
+                continue;
+            };
+            let (Ok(kind), Ok(status)) = (id.get_str("kind"), id.get_str("status")) else {
+                continue;
+            };
+            let stats = kinds
+                .entry(kind.to_string())
+                .or_insert_with(|| QueueKindStats {
+                    kind: kind.to_string(),
+                    ..Default::default()
+                });
+            match status {
+                "pending" => stats.pending = count as u64,
+                "running" => stats.running = count as u64,
+                "dead" => stats.dead = count as u64,
+                _ => {}
+            }
+        }
+
+        let runs = self.runs.lock();
+        for (kind, stats) in &mut kinds {
+            let Some(run) = runs.get(kind) else {
+                continue;
+            };
+            let attempts = run.succeeded + run.retried + run.died;
+            stats.succeeded = run.succeeded;
+            stats.retried = run.retried;
+            stats.died = run.died;
+            stats.avg_duration_ms = run.total_ms / attempts.max(1);
+            stats.max_duration_ms = run.max_ms;
+        }
+
+        Ok(kinds.into_values().collect())
+    }
+
+    /// Newest first.
+    pub async fn dead(
+        &self,
+        kind: Option<&str>,
+        limit: Option<i64>,
+    ) -> Result<Vec<QueuedTask>, AppError> {
+        self.list(QueuedTaskStatus::Dead, kind, limit, -1).await
+    }
+
+    /// Tasks waiting for their `run_at`, the next due first.
+    pub async fn pending(
+        &self,
+        kind: Option<&str>,
+        limit: Option<i64>,
+    ) -> Result<Vec<QueuedTask>, AppError> {
+        self.list(QueuedTaskStatus::Pending, kind, limit, 1).await
+    }
+
+    async fn list(
+        &self,
+        status: QueuedTaskStatus,
+        kind: Option<&str>,
+        limit: Option<i64>,
+        order: i32,
+    ) -> Result<Vec<QueuedTask>, AppError> {
+        let limit = limit.unwrap_or(LIST_DEFAULT_LIMIT).clamp(1, LIST_MAX_LIMIT);
+        let mut filter = doc! { "status": mongodb::bson::to_bson(&status)? };
+        if let Some(kind) = kind {
+            filter.insert("kind", kind);
+        }
+
+        Ok(self
+            .tasks
+            .find(filter)
+            .sort(doc! { "run_at": order })
+            .limit(limit)
+            .await?
+            .try_collect()
+            .await?)
+    }
+
+    /// Gives a dead task a fresh set of attempts.
+    pub async fn retry(&self, id: &str) -> Result<bool, AppError> {
+        let result = self
+            .tasks
+            .update_one(
+                doc! { "id": id, "status": "dead" },
+                doc! { "$set": {
+                    "status": "pending",
+                    "attempts": 0,
+                    "run_at": Utc::now().timestamp_millis(),
+                } },
+            )
+            .await?;
+        Ok(result.matched_count > 0)
+    }
+
+    pub async fn delete(&self, id: &str) -> Result<bool, AppError> {
+        let result = self
+            .tasks
+            .delete_one(doc! { "id": id, "status": "dead" })
+            .await?;
+        Ok(result.deleted_count > 0)
+    }
+
+    /// Drops a task before it runs, not once an instance claimed it.
+    pub async fn cancel(&self, id: &str) -> Result<bool, AppError> {
+        let result = self
+            .tasks
+            .delete_one(doc! { "id": id, "status": "pending" })
+            .await?;
+        Ok(result.deleted_count > 0)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn backoff_doubles_up_to_the_max() {
+        let policy = RetryPolicy {
+            max_attempts: 40,
+            initial_backoff: Duration::from_secs(1),
+            max_backoff: Duration::from_secs(60),
+        };
+        assert_eq!(policy.backoff(1), Duration::from_secs(1));
+        assert_eq!(policy.backoff(2), Duration::from_secs(2));
+        assert_eq!(policy.backoff(4), Duration::from_secs(8));
+        assert_eq!(policy.backoff(7), Duration::from_secs(60));
+        assert_eq!(policy.backoff(40), Duration::from_secs(60));
+    }
+}
@@ -0,0 +1,176 @@
+//! Recurring work on cron schedules. Every run starts after a random jitter,
+//! and tasks that must happen once per slot across all instances claim the
+//! slot in redis first.
+
+use std::{
+    pin::Pin,
+    str::FromStr as _,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::{DateTime, Utc};
+use cron::Schedule;
+use rand::Rng as _;
+use redis::aio::MultiplexedConnection;
+use share::{
+    config::SchedulerConfig,
+    jobs::{JobManager, JobRun, Stage, claim_slot},
+};
+
+use crate::error::AppError;
+
+type BoxFuture = Pin<Box<dyn Future<Output = Result<(), AppError>> + Send + 'static>>;
+type TaskFn = Arc<dyn Fn() -> BoxFuture + Send + Sync>;
+
+#[derive(Copy, Clone, Debug, PartialEq, Eq)]
+pub enum Scope {
+    /// Runs on every instance, e.g. refreshing a local cache.
+    Local,
+    /// Runs on the one instance that claims the slot.
+    Once,
+}
+
+struct Task {
+    name: &'static str,
+    schedule: Schedule,
+    scope: Scope,
+    run: TaskFn,
+}
+
+pub struct Scheduler {
+    config: SchedulerConfig,
+    redis: MultiplexedConnection,
+    tasks: Vec<Task>,
+}
+
+impl Scheduler {
+    pub fn new(config: SchedulerConfig, redis: MultiplexedConnection) -> Self {
+        Self {
+            config,
+            redis,
+            tasks: Vec::new(),
+        }
+    }
+
+    /// Adds `task` on the schedule configured for `name`, nothing when it is
+    /// empty. The schedules were checked by the config validation. An `Err`
+    /// is logged and shown as a failed run by the job list.
+    pub fn add<F, Fut>(&mut self, name: &'static str, scope: Scope, task: F)
+    where
+        F: Fn() -> Fut + Send + Sync + 'static,
+        Fut: Future<Output = Result<(), AppError>> + Send + 'static,
+    {
+        let Some((_, expression)) = self
+            .config
+            .schedules()
+            .into_iter()
+            .find(|(task, _)| *task == name)
+        else {
+            tracing::error!("no schedule configured for {}", name);
+            return;
+        };
+        if expression.is_empty() {
+            tracing::info!("scheduled task {} is turned off", name);
+            return;
+        }
+        let schedule = match Schedule::from_str(expression) {
+            Ok(schedule) => schedule,
+            Err(e) => {
+                tracing::error!("invalid schedule for {}: {}", name, e);
+                return;
+            }
+        };
+
+        self.tasks.push(Task {
+            name,
+            schedule,
+            scope,
+            run: Arc::new(move || Box::pin(task()) as BoxFuture),
+        });
+    }
+
+    /// Runs every task as a scheduler job of `jobs`.
+    pub fn start(self, jobs: &JobManager) {
+        if !self.config.enabled {
+            tracing::info!("scheduler is turned off");
+            return;
+        }
+
+        let jitter = Duration::from_millis(self.config.jitter_ms);
+        for task in self.tasks {
+            tracing::info!(scope = ?task.scope, "scheduled {}: {}", task.name, task.schedule);
+            jobs.spawn(
+                task.name,
+                Stage::Scheduler,
+                run(task, self.redis.clone(), jitter, jobs.clone()),
+            );
+        }
+    }
+}
+
+async fn run(task: Task, mut redis: MultiplexedConnection, jitter: Duration, jobs: JobManager) {
+    let mut upcoming = task.schedule.upcoming(Utc);
+    loop {
+        let slot = upcoming.next();
+        jobs.set_next_run(task.name, slot);
+        let Some(slot) = slot else {
+            return;
+        };
+        let delay = (slot - Utc::now()).to_std().unwrap_or_default() + random_jitter(jitter);
+        tokio::time::sleep(delay).await;
+
+        if task.scope == Scope::Once {
+            match claim(&mut redis, &task, slot).await {
+                Ok(true) => {}
+                Ok(false) => continue,
+                Err(e) => {
+                    tracing::warn!("failed to claim {} for {}: {}", task.name, slot, e);
+                    continue;
+                }
+            }
+        }
+
+        tracing::debug!("running scheduled {}", task.name);
+        let started_at = Utc::now();
+        let started = Instant::now();
+        let error = match share::task::guarded(task.name, (task.run)()).await {
+            Some(Ok(())) => None,
+            Some(Err(e)) => {
+                tracing::warn!("scheduled {} failed: {}", task.name, e);
+                Some(e.to_string())
+            }
+            None => Some("panicked".to_string()),
+        };
+        jobs.record_run(
+            task.name,
+            JobRun {
+                started_at,
+                duration_ms: started.elapsed().as_millis() as u64,
+                success: error.is_none(),
+                error,
+            },
+        );
+        // a run longer than the interval skips the slots it overlapped
+        upcoming = task.schedule.upcoming(Utc);
+    }
+}
+
+fn random_jitter(max: Duration) -> Duration {
+    match max.is_zero() {
+        true => Duration::ZERO,
+        false => rand::rng().random_range(Duration::ZERO..=max),
+    }
+}
+
+/// Takes the slot unless another instance did, the claim lasts until the
+/// next slot.
+async fn claim(
+    redis: &mut MultiplexedConnection,
+    task: &Task,
+    slot: DateTime<Utc>,
+) -> redis::RedisResult<bool> {
+    let next = task.schedule.after(&slot).next().unwrap_or(slot);
+    let ttl = (next - slot).to_std().unwrap_or_default();
+    claim_slot(redis, task.name, slot, ttl).await
+}
@@ -0,0 +1,157 @@
+use std::{cmp::Ordering, collections::HashMap};
+
+use futures::TryStreamExt as _;
+use mongodb::bson::doc;
+use serde::Deserialize;
+use share::{client_ip::voter_ip_forms, reload::ConfigWatch};
+
+use crate::{error::AppError, service::ResultsSnapshotService};
+
+/// The fields of a stored pairwise ballot read here.
+#[derive(Debug, Deserialize)]
+struct Choice {
+    win: i32,
+    lose: i32,
+    /// 0 until audited, the matrix holds the ballot with this weight.
+    multiplier: i64,
+}
+
+/// The pairwise ballots of one address next to everyone else's.
+#[derive(Debug, Default, PartialEq)]
+pub struct Affinity {
+    pub ballots: u64,
+    pub agreed: u64,
+    pub disagreed: u64,
+    /// Winner, loser and the net votes of everyone else for the loser, the
+    /// largest margin first.
+    pub contrarian: Vec<(i32, i32, i64)>,
+}
+
+/// Takes the address's own weight out of the matrix first, a voter would
+/// otherwise always agree with a matchup only they voted on.
+fn compare(choices: &[Choice], matrix: &HashMap<String, i64>, max_picks: usize) -> Affinity {
+    let mut own: HashMap<(i32, i32), i64> = HashMap::new();
+    for choice in choices {
+        *own.entry((choice.win, choice.lose)).or_default() += choice.multiplier;
+        *own.entry((choice.lose, choice.win)).or_default() -= choice.multiplier;
+    }
+
+    let mut affinity = Affinity {
+        ballots: choices.len() as u64,
+        ..Default::default()
+    };
+    let mut contrarian: HashMap<(i32, i32), i64> = HashMap::new();
+    for choice in choices {
+        let pair = (choice.win, choice.lose);
+        let net = matrix
+            .get(&format!("{}:{}", choice.win, choice.lose))
+            .copied()
+            .unwrap_or(0)
+            - own.get(&pair).copied().unwrap_or(0);
+        match net.cmp(&0) {
+            Ordering::Greater => affinity.agreed += 1,
+            Ordering::Less => {
+                affinity.disagreed += 1;
+                contrarian.insert(pair, -net);
+            }
+            Ordering::Equal => {}
+        }
+    }
+
+    affinity.contrarian = contrarian
+        .into_iter()
+        .map(|((win, lose), margin)| (win, lose, margin))
+        .collect();
+    affinity
+        .contrarian
+        .sort_by(|a, b| b.2.cmp(&a.2).then(a.0.cmp(&b.0)).then(a.1.cmp(&b.1)));
+    affinity.contrarian.truncate(max_picks);
+    affinity
+}
+
+/// Compares the ballots of a voter address with the results of a pairwise
+/// topic. There are no accounts, so "the voter" is everyone behind the
+/// address, like for the data exports.
+#[derive(Clone)]
+pub struct AffinityService {
+    mongo: mongodb::Database,
+    results_snapshots: ResultsSnapshotService,
+    reload: ConfigWatch,
+}
+
+impl AffinityService {
+    pub fn new(
+        mongo: mongodb::Database,
+        results_snapshots: ResultsSnapshotService,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            mongo,
+            results_snapshots,
+            reload,
+        }
+    }
+
+    pub async fn compare(&self, topic_id: &str, ip: &str) -> Result<Affinity, AppError> {
+        let config = self.reload.current();
+        let choices: Vec<Choice> = self
+            .mongo
+            .collection::<Choice>(&format!("ballots_{topic_id}"))
+            .find(doc! {
+                "topic_type": "pairwise",
+                "info.ip": { "$in": voter_ip_forms(ip, &config.vote) },
+            })
+            .projection(doc! { "_id": 0, "win": 1, "lose": 1, "multiplier": 1 })
+            .sort(doc! { "info.timestamp": 1 })
+            .limit(config.affinity.max_ballots as i64)
+            .await?
+            .try_collect()
+            .await?;
+
+        let snapshot = self.results_snapshots.get(topic_id).await?;
+        Ok(compare(
+            &choices,
+            &snapshot.matrix,
+            config.affinity.contrarian_picks,
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn choice(win: i32, lose: i32) -> Choice {
+        Choice {
+            win,
+            lose,
+            multiplier: 1,
+        }
+    }
+
+    #[test]
+    fn own_ballots_are_left_out_of_the_crowd() {
+        // 1 beats 2 by 3 with the voter's ballot, 4 beats 3 by 5 against it
+        let matrix = HashMap::from([
+            ("1:2".to_string(), 3),
+            ("2:1".to_string(), -3),
+            ("3:4".to_string(), -5),
+            ("4:3".to_string(), 5),
+            ("5:6".to_string(), 1),
+            ("6:5".to_string(), -1),
+        ]);
+        let choices = [choice(1, 2), choice(3, 4), choice(3, 4), choice(5, 6)];
+
+        let affinity = compare(&choices, &matrix, 5);
+        assert_eq!(
+            affinity,
+            Affinity {
+                ballots: 4,
+                agreed: 1,
+                disagreed: 2,
+                // the only vote on 5 against 6 was the voter's
+                contrarian: vec![(3, 4, 7)],
+            }
+        );
+    }
+}
@@ -0,0 +1,237 @@
+use std::{
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{Collection, bson::doc};
+use parking_lot::RwLock;
+use share::models::{
+    api::{AnnouncementCreateRequest, AnnouncementView, ApiMsg},
+    database::{Announcement, AuditLogEntry},
+};
+use uuid::Uuid;
+
+use crate::{error::AppError, service::AuditLogService};
+
+/// How long a change made on another instance takes to show here.
+const CACHE_TTL: Duration = Duration::from_secs(10);
+
+const MAX_TITLE_LENGTH: usize = 200;
+const MAX_BODY_LENGTH: usize = 5000;
+
+/// Checks the lengths and the schedule.
+fn validate(req: &mut AnnouncementCreateRequest) -> Result<(), String> {
+    req.title = req.title.trim().to_string();
+    req.body = req.body.trim().to_string();
+    if req.title.is_empty() {
+        return Err("title is empty".to_string());
+    }
+    if req.title.chars().count() > MAX_TITLE_LENGTH {
+        return Err(format!("title is over {MAX_TITLE_LENGTH} characters"));
+    }
+    if req.body.chars().count() > MAX_BODY_LENGTH {
+        return Err(format!("body is over {MAX_BODY_LENGTH} characters"));
+    }
+    if let (Some(starts_at), Some(ends_at)) = (req.starts_at, req.ends_at)
+        && ends_at <= starts_at
+    {
+        return Err("ends_at is not after starts_at".to_string());
+    }
+    Ok(())
+}
+
+/// Site announcements, e.g. maintenance notices and result reveal times.
+/// Every page asks for the current ones, so they are served from a copy
+/// refreshed every [`CACHE_TTL`].
+#[derive(Clone)]
+pub struct AnnouncementService {
+    announcements: Collection<Announcement>,
+    audit_log: AuditLogService,
+    cache: Arc<RwLock<Option<(Instant, Arc<Vec<Announcement>>)>>>,
+}
+
+impl AnnouncementService {
+    pub fn new(mongo: mongodb::Database, audit_log: AuditLogService) -> Self {
+        Self {
+            announcements: mongo.collection::<Announcement>("announcements"),
+            audit_log,
+            cache: Arc::new(RwLock::new(None)),
+        }
+    }
+
+    /// The ones shown now, most severe first, then the latest started.
+    pub async fn current(&self) -> Result<Vec<AnnouncementView>, AppError> {
+        let fresh = self
+            .cache
+            .read()
+            .as_ref()
+            .filter(|(at, _)| at.elapsed() < CACHE_TTL)
+            .map(|(_, all)| all.clone());
+        let all = match fresh {
+            Some(all) => all,
+            None => {
+                let all = Arc::new(self.list().await?);
+                *self.cache.write() = Some((Instant::now(), all.clone()));
+                all
+            }
+        };
+
+        let now = Utc::now();
+        let mut shown: Vec<&Announcement> = all.iter().filter(|a| a.is_shown_at(now)).collect();
+        shown.sort_by(|a, b| {
+            b.severity.cmp(&a.severity).then(
+                b.starts_at
+                    .unwrap_or(b.created_at)
+                    .cmp(&a.starts_at.unwrap_or(a.created_at)),
+            )
+        });
+        Ok(shown
+            .into_iter()
+            .cloned()
+            .map(AnnouncementView::from)
+            .collect())
+    }
+
+    /// Every announcement, the latest created first.
+    pub async fn list(&self) -> Result<Vec<Announcement>, AppError> {
+        Ok(self
+            .announcements
+            .find(doc! {})
+            .sort(doc! { "created_at": -1 })
+            .await?
+            .try_collect()
+            .await?)
+    }
+
+    /// The outer error is reserved for database failures.
+    pub async fn create(
+        &self,
+        mut req: AnnouncementCreateRequest,
+        actor: &str,
+    ) -> Result<Result<Announcement, ApiMsg>, AppError> {
+        if let Err(msg) = validate(&mut req) {
+            return Ok(Err(ApiMsg::AnnouncementInvalid(msg)));
+        }
+        let announcement = Announcement {
+            id: Uuid::new_v4().to_string(),
+            title: req.title,
+            body: req.body,
+            severity: req.severity,
+            topic_id: req.topic_id,
+            starts_at: req.starts_at,
+            ends_at: req.ends_at,
+            created_by: actor.to_string(),
+            created_at: Utc::now(),
+            updated_at: None,
+        };
+        self.announcements.insert_one(&announcement).await?;
+        self.changed(&announcement, "announcement_created", actor)
+            .await?;
+        Ok(Ok(announcement))
+    }
+
+    pub async fn update(
+        &self,
+        id: &str,
+        mut req: AnnouncementCreateRequest,
+        actor: &str,
+    ) -> Result<Result<Announcement, ApiMsg>, AppError> {
+        if let Err(msg) = validate(&mut req) {
+            return Ok(Err(ApiMsg::AnnouncementInvalid(msg)));
+        }
+        let Some(current) = self.announcements.find_one(doc! { "id": id }).await? else {
+            return Ok(Err(ApiMsg::AnnouncementNotFound));
+        };
+        let announcement = Announcement {
+            title: req.title,
+            body: req.body,
+            severity: req.severity,
+            topic_id: req.topic_id,
+            starts_at: req.starts_at,
+            ends_at: req.ends_at,
+            updated_at: Some(Utc::now()),
+            ..current
+        };
+        let result = self
+            .announcements
+            .replace_one(doc! { "id": id }, &announcement)
+            .await?;
+        if result.matched_count == 0 {
+            return Ok(Err(ApiMsg::AnnouncementNotFound));
+        }
+        self.changed(&announcement, "announcement_updated", actor)
+            .await?;
+        Ok(Ok(announcement))
+    }
+
+    pub async fn delete(&self, id: &str, actor: &str) -> Result<Result<(), ApiMsg>, AppError> {
+        let Some(announcement) = self
+            .announcements
+            .find_one_and_delete(doc! { "id": id })
+            .await?
+        else {
+            return Ok(Err(ApiMsg::AnnouncementNotFound));
+        };
+        self.changed(&announcement, "announcement_deleted", actor)
+            .await?;
+        Ok(Ok(()))
+    }
+
+    /// Drops the cached copy so this instance shows the change at once, and
+    /// records it in the audit log.
+    async fn changed(
+        &self,
+        announcement: &Announcement,
+        action: &str,
+        actor: &str,
+    ) -> Result<(), AppError> {
+        *self.cache.write() = None;
+        self.audit_log
+            .record(AuditLogEntry::new(
+                announcement.topic_id.clone(),
+                actor,
+                action,
+                format!(
+                    "announcement {} ({:?}): {}",
+                    announcement.id, announcement.severity, announcement.title
+                ),
+            ))
+            .await?;
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use share::models::database::AnnouncementSeverity;
+
+    use super::*;
+
+    fn request(title: &str) -> AnnouncementCreateRequest {
+        AnnouncementCreateRequest {
+            title: title.to_string(),
+            body: String::new(),
+            severity: AnnouncementSeverity::Info,
+            topic_id: None,
+            starts_at: None,
+            ends_at: None,
+        }
+    }
+
+    #[test]
+    fn announcements_need_a_title_and_an_ordered_schedule() {
+        let mut req = request("  Maintenance tonight ");
+        assert_eq!(validate(&mut req), Ok(()));
+        assert_eq!(req.title, "Maintenance tonight");
+
+        assert!(validate(&mut request(" ")).is_err());
+
+        let now = Utc::now();
+        let mut req = request("Results at 20:00");
+        req.starts_at = Some(now);
+        req.ends_at = Some(now - chrono::Duration::hours(1));
+        assert!(validate(&mut req).is_err());
+    }
+}
@@ -0,0 +1,420 @@
+use std::{
+    num::NonZeroU32,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::Utc;
+use dashmap::DashMap;
+use futures::TryStreamExt as _;
+use governor::{DefaultDirectRateLimiter, Quota, RateLimiter};
+use hmac::{Hmac, Mac as _};
+use mongodb::{Collection, bson::doc};
+use rand::{Rng as _, distr::Alphanumeric};
+use sha2::{Digest as _, Sha256};
+use share::{
+    config::{ApiKeyConfig, StaticApiKeyConfig},
+    models::database::{ApiKey, ApiKeyScope},
+};
+
+use crate::error::AppError;
+
+const API_KEY_PREFIX: &str = "akv_";
+const API_KEY_RANDOM_LENGTH: usize = 40;
+const API_KEY_CACHE_TTL: Duration = Duration::from_secs(30);
+
+/// Keys are generated with ~238 bits of randomness, nothing a slow password
+/// hash like argon2id would protect further. A fast unsalted hash keeps keys
+/// indexable by their hash.
+pub fn hash_api_key(key: &str) -> String {
+    hex::encode(Sha256::digest(key.as_bytes()))
+}
+
+/// The secret of signed requests, the hex HMAC-SHA256 of the stored hash
+/// under `api_key.signing_pepper`. It is never stored, so the `api_keys`
+/// collection alone cannot sign, and handed out when the key is issued.
+pub fn derive_signing_secret(pepper: &str, key_hash: &str) -> String {
+    let mut mac =
+        Hmac::<Sha256>::new_from_slice(pepper.as_bytes()).expect("hmac accepts keys of any length");
+    mac.update(key_hash.as_bytes());
+    hex::encode(mac.finalize().into_bytes())
+}
+
+/// Checks a `sha256=<hex>` signature over the method, the path with its
+/// query, the timestamp, the nonce and the body, each but the body followed by
+/// a newline. The secret is the one of [`derive_signing_secret`]. Compared in
+/// constant time.
+pub fn verify_request(
+    secret: &str,
+    method: &str,
+    path_and_query: &str,
+    timestamp: i64,
+    nonce: &str,
+    body: &[u8],
+    signature: &str,
+) -> bool {
+    let Some(signature) = signature
+        .strip_prefix("sha256=")
+        .and_then(|signature| hex::decode(signature).ok())
+    else {
+        return false;
+    };
+    request_mac(secret, method, path_and_query, timestamp, nonce, body)
+        .verify_slice(&signature)
+        .is_ok()
+}
+
+fn request_mac(
+    secret: &str,
+    method: &str,
+    path_and_query: &str,
+    timestamp: i64,
+    nonce: &str,
+    body: &[u8],
+) -> Hmac<Sha256> {
+    let mut mac =
+        Hmac::<Sha256>::new_from_slice(secret.as_bytes()).expect("hmac accepts keys of any length");
+    let timestamp = timestamp.to_string();
+    for part in [method, path_and_query, timestamp.as_str(), nonce] {
+        mac.update(part.as_bytes());
+        mac.update(b"\n");
+    }
+    mac.update(body);
+    mac
+}
+
+fn generate_api_key() -> String {
+    let random: String = rand::rng()
+        .sample_iter(&Alphanumeric)
+        .take(API_KEY_RANDOM_LENGTH)
+        .map(char::from)
+        .collect();
+    format!("{API_KEY_PREFIX}{random}")
+}
+
+pub enum ApiKeyCheck {
+    Allowed(ApiKey),
+    Invalid,
+    Forbidden,
+    RateLimited,
+}
+
+#[derive(Clone)]
+struct CachedKey {
+    key: Option<ApiKey>,
+    fetched_at: Instant,
+}
+
+#[derive(Clone)]
+pub struct ApiKeyService {
+    collection: Collection<ApiKey>,
+    config: ApiKeyConfig,
+
+    static_keys: Arc<DashMap<String, ApiKey>>,
+    cache: Arc<DashMap<String, CachedKey>>,
+    limiters: Arc<DashMap<String, Arc<DefaultDirectRateLimiter>>>,
+}
+
+impl ApiKeyService {
+    pub fn new(mongo: mongodb::Database, config: ApiKeyConfig) -> Self {
+        let service = Self {
+            collection: mongo.collection::<ApiKey>("api_keys"),
+            static_keys: Arc::new(DashMap::new()),
+            cache: Arc::new(DashMap::new()),
+            limiters: Arc::new(DashMap::new()),
+            config,
+        };
+        service.set_static_keys(&service.config.static_keys);
+        service
+    }
+
+    /// Replaces the keys from the config file, called again when a reload
+    /// brings rotated keys.
+    pub fn set_static_keys(&self, static_keys: &[StaticApiKeyConfig]) {
+        let keys = static_keys.iter().map(|static_key| ApiKey {
+            name: static_key.name.clone(),
+            key_hash: hash_api_key(&static_key.key),
+            scopes: static_key.scopes.clone(),
+            rate_limit_per_minute: static_key
+                .rate_limit_per_minute
+                .unwrap_or(self.config.default_rate_limit_per_minute),
+            enabled: true,
+            created_at: Utc::now(),
+            last_used_at: None,
+        });
+        let keys: Vec<_> = keys.collect();
+
+        self.static_keys
+            .retain(|hash, _| keys.iter().any(|key| &key.key_hash == hash));
+        for key in keys {
+            self.static_keys.insert(key.key_hash.clone(), key);
+        }
+    }
+
+    pub fn config(&self) -> &ApiKeyConfig {
+        &self.config
+    }
+
+    /// `None` while `api_key.signing_pepper` is empty, no key can sign then.
+    pub fn signing_secret(&self, key: &ApiKey) -> Option<String> {
+        let pepper = &self.config.signing_pepper;
+        (!pepper.is_empty()).then(|| derive_signing_secret(pepper, &key.key_hash))
+    }
+
+    /// Validates the key, its scope and its rate limit in one go.
+    pub async fn check(&self, raw_key: &str, scope: ApiKeyScope) -> Result<ApiKeyCheck, AppError> {
+        let key = self.find(raw_key).await?;
+        Ok(self.authorize(key, scope))
+    }
+
+    /// Same as [`check`](Self::check) for a key whose request signature was
+    /// already verified against it.
+    pub fn check_signed(&self, key: ApiKey, scope: ApiKeyScope) -> ApiKeyCheck {
+        self.authorize(Some(key), scope)
+    }
+
+    fn authorize(&self, key: Option<ApiKey>, scope: ApiKeyScope) -> ApiKeyCheck {
+        let Some(key) = key else {
+            return ApiKeyCheck::Invalid;
+        };
+
+        if !key.enabled {
+            return ApiKeyCheck::Invalid;
+        }
+        if !key.has_scope(scope) {
+            return ApiKeyCheck::Forbidden;
+        }
+        if !self.acquire(&key) {
+            return ApiKeyCheck::RateLimited;
+        }
+
+        ApiKeyCheck::Allowed(key)
+    }
+
+    /// Looks a key up by its name for a signed request, which doesn't carry
+    /// the key itself.
+    pub async fn find_by_name(&self, name: &str) -> Result<Option<ApiKey>, AppError> {
+        if let Some(key) = self.static_keys.iter().find(|key| key.name == name) {
+            return Ok(Some(key.clone()));
+        }
+
+        let cache_key = format!("name:{name}");
+        if let Some(cached) = self.cache.get(&cache_key)
+            && cached.fetched_at.elapsed() < API_KEY_CACHE_TTL
+        {
+            return Ok(cached.key.clone());
+        }
+
+        let key = self.collection.find_one(doc! { "name": name }).await?;
+        self.cache.insert(
+            cache_key,
+            CachedKey {
+                key: key.clone(),
+                fetched_at: Instant::now(),
+            },
+        );
+
+        Ok(key)
+    }
+
+    async fn find(&self, raw_key: &str) -> Result<Option<ApiKey>, AppError> {
+        let key_hash = hash_api_key(raw_key);
+
+        if let Some(key) = self.static_keys.get(&key_hash) {
+            return Ok(Some(key.clone()));
+        }
+
+        if let Some(cached) = self.cache.get(&key_hash)
+            && cached.fetched_at.elapsed() < API_KEY_CACHE_TTL
+        {
+            return Ok(cached.key.clone());
+        }
+
+        let key = self
+            .collection
+            .find_one(doc! { "key_hash": &key_hash })
+            .await?;
+
+        if key.is_some() {
+            let collection = self.collection.clone();
+            let filter = doc! { "key_hash": &key_hash };
+            let update = doc! {
+                "$set": { "last_used_at": mongodb::bson::to_bson(&Utc::now()).unwrap() }
+            };
+            share::task::spawn("api_key_last_used", async move {
+                if let Err(e) = collection.update_one(filter, update).await {
+                    tracing::warn!("Failed to update api key last_used_at: {}", e);
+                }
+            });
+        }
+
+        // negative results are cached as well, so random keys can't hammer mongo
+        self.cache.insert(
+            key_hash,
+            CachedKey {
+                key: key.clone(),
+                fetched_at: Instant::now(),
+            },
+        );
+
+        Ok(key)
+    }
+
+    fn acquire(&self, key: &ApiKey) -> bool {
+        let limiter = self
+            .limiters
+            .entry(key.key_hash.clone())
+            .or_insert_with(|| {
+                let per_minute = NonZeroU32::new(key.rate_limit_per_minute)
+                    .unwrap_or(NonZeroU32::new(1).unwrap());
+                Arc::new(RateLimiter::direct(Quota::per_minute(per_minute)))
+            })
+            .clone();
+
+        limiter.check().is_ok()
+    }
+
+    /// Issues a new key and returns the plain key alongside the stored record.
+    pub async fn issue(
+        &self,
+        name: &str,
+        scopes: Vec<ApiKeyScope>,
+        rate_limit_per_minute: Option<u32>,
+    ) -> Result<Option<(String, ApiKey)>, AppError> {
+        let exists = self.collection.find_one(doc! { "name": name }).await?;
+        if exists.is_some() || self.static_keys.iter().any(|key| key.name == name) {
+            return Ok(None);
+        }
+
+        let raw_key = generate_api_key();
+        let key = ApiKey {
+            name: name.to_string(),
+            key_hash: hash_api_key(&raw_key),
+            scopes,
+            rate_limit_per_minute: rate_limit_per_minute
+                .unwrap_or(self.config.default_rate_limit_per_minute),
+            enabled: true,
+            created_at: Utc::now(),
+            last_used_at: None,
+        };
+        self.collection.insert_one(&key).await?;
+        tracing::info!("Issued api key: {}", name);
+
+        Ok(Some((raw_key, key)))
+    }
+
+    pub async fn revoke(&self, name: &str) -> Result<bool, AppError> {
+        let Some(key) = self.collection.find_one(doc! { "name": name }).await? else {
+            return Ok(false);
+        };
+
+        self.collection
+            .update_one(doc! { "name": name }, doc! { "$set": { "enabled": false } })
+            .await?;
+        self.cache.remove(&key.key_hash);
+        self.cache.remove(&format!("name:{name}"));
+        self.limiters.remove(&key.key_hash);
+        tracing::info!("Revoked api key: {}", name);
+
+        Ok(true)
+    }
+
+    pub async fn list(&self) -> Result<Vec<ApiKey>, AppError> {
+        let mut keys: Vec<ApiKey> = self
+            .static_keys
+            .iter()
+            .map(|entry| entry.value().clone())
+            .collect();
+
+        let mut cursor = self.collection.find(doc! {}).await?;
+        while let Some(key) = cursor.try_next().await? {
+            keys.push(key);
+        }
+
+        Ok(keys)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn sign_request(
+        secret: &str,
+        method: &str,
+        path_and_query: &str,
+        timestamp: i64,
+        nonce: &str,
+        body: &[u8],
+    ) -> String {
+        let mac = request_mac(secret, method, path_and_query, timestamp, nonce, body);
+        format!("sha256={}", hex::encode(mac.finalize().into_bytes()))
+    }
+
+    #[test]
+    fn signature_covers_every_part_of_the_request() {
+        let secret = derive_signing_secret("pepper", &hash_api_key("akv_test"));
+        // the stored hash is not the secret, nor is the secret without the pepper
+        assert_ne!(secret, hash_api_key("akv_test"));
+        assert_ne!(
+            secret,
+            derive_signing_secret("other", &hash_api_key("akv_test"))
+        );
+        let signature = sign_request(&secret, "POST", "/topic/create?x=1", 100, "n1", b"{}");
+        assert!(verify_request(
+            &secret,
+            "POST",
+            "/topic/create?x=1",
+            100,
+            "n1",
+            b"{}",
+            &signature
+        ));
+
+        assert!(!verify_request(
+            &secret,
+            "POST",
+            "/topic/create?x=2",
+            100,
+            "n1",
+            b"{}",
+            &signature
+        ));
+        assert!(!verify_request(
+            &secret,
+            "POST",
+            "/topic/create?x=1",
+            101,
+            "n1",
+            b"{}",
+            &signature
+        ));
+        assert!(!verify_request(
+            &secret,
+            "POST",
+            "/topic/create?x=1",
+            100,
+            "n2",
+            b"{}",
+            &signature
+        ));
+        assert!(!verify_request(
+            &secret,
+            "POST",
+            "/topic/create?x=1",
+            100,
+            "n1",
+            b"[]",
+            &signature
+        ));
+        assert!(!verify_request(
+            &derive_signing_secret("pepper", &hash_api_key("akv_other")),
+            "POST",
+            "/topic/create?x=1",
+            100,
+            "n1",
+            b"{}",
+            &signature
+        ));
+    }
+}
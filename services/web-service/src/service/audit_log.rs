@@ -0,0 +1,253 @@
+use std::sync::Arc;
+
+use chrono::{DateTime, Utc};
+use futures::TryStreamExt as _;
+use hmac::{Hmac, Mac as _};
+use mongodb::{
+    Collection,
+    bson::doc,
+    error::{ErrorKind, WriteFailure},
+};
+use serde::Serialize;
+use sha2::{Digest as _, Sha256};
+use share::{
+    config::AuditLogConfig,
+    jobs::JobManager,
+    models::database::{AuditChainLink, AuditLogEntry},
+    task::pool::Pool,
+};
+use tokio::sync::Mutex;
+
+use crate::error::AppError;
+
+/// `prev_hash` of the first chained entry.
+const GENESIS_HASH: &str = "0000000000000000000000000000000000000000000000000000000000000000";
+/// Appends racing another instance for the same sequence number.
+const APPEND_ATTEMPTS: usize = 5;
+
+/// Hash of `entry` as number `seq` of the chain, after `prev_hash`.
+fn chain_hash(entry: &AuditLogEntry, seq: i64, at: i64, prev_hash: &str) -> String {
+    let content = serde_json::to_vec(&(
+        seq,
+        at,
+        prev_hash,
+        &entry.id,
+        &entry.topic_id,
+        &entry.actor,
+        &entry.action,
+        &entry.detail,
+    ))
+    .expect("audit entries serialize");
+    hex::encode(Sha256::digest(content))
+}
+
+/// Last line of an export, the signature covers every byte before it.
+#[derive(Serialize)]
+struct ExportTrailer {
+    from: DateTime<Utc>,
+    to: DateTime<Utc>,
+    count: usize,
+    /// False when the hash of an entry doesn't match or sequence numbers are
+    /// missing in between, `broken_at` is the first such entry.
+    intact: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    broken_at: Option<i64>,
+    /// Set when the range held more entries than `export_max_entries`.
+    truncated: bool,
+    signature: String,
+}
+
+#[derive(Clone)]
+pub struct AuditLogService {
+    entries: Collection<AuditLogEntry>,
+    pool: Pool,
+    /// Appends of this instance take turns, so only other instances race.
+    append_lock: Arc<Mutex<()>>,
+    export_signing_key: String,
+    export_max_entries: u64,
+}
+
+impl AuditLogService {
+    pub fn new(mongo: mongodb::Database, config: &AuditLogConfig, jobs: &JobManager) -> Self {
+        Self {
+            entries: mongo.collection::<AuditLogEntry>("audit_log"),
+            pool: Pool::new("audit_log", config.concurrency, config.queue_size, jobs),
+            append_lock: Arc::new(Mutex::new(())),
+            export_signing_key: config.export_signing_key.clone(),
+            export_max_entries: config.export_max_entries,
+        }
+    }
+
+    /// Queues the entry, it is written in the background and shutdown writes
+    /// whatever is still queued.
+    pub async fn record(&self, entry: AuditLogEntry) -> Result<(), AppError> {
+        tracing::info!(
+            "Audit: {} by {} on {:?}: {}",
+            entry.action,
+            entry.actor,
+            entry.topic_id,
+            entry.detail
+        );
+
+        let service = self.clone();
+        self.pool
+            .submit(async move {
+                if let Err(e) = service.append(entry.clone()).await {
+                    tracing::error!("Failed to write audit log entry {}: {}", entry.action, e);
+                }
+            })
+            .await?;
+
+        Ok(())
+    }
+
+    /// Links the entry after the last chained one and inserts it. The unique
+    /// index on `chain.seq` turns a race with another instance into a retry.
+    async fn append(&self, mut entry: AuditLogEntry) -> Result<(), AppError> {
+        let _turn = self.append_lock.lock().await;
+
+        for _ in 0..APPEND_ATTEMPTS {
+            let last = self
+                .entries
+                .find_one(doc! { "chain.seq": { "$exists": true } })
+                .sort(doc! { "chain.seq": -1 })
+                .await?
+                .and_then(|last| last.chain);
+            let (seq, prev_hash) = match last {
+                Some(link) => (link.seq + 1, link.hash),
+                None => (1, GENESIS_HASH.to_string()),
+            };
+            let at = entry.created_at.timestamp_millis();
+            entry.chain = Some(AuditChainLink {
+                seq,
+                at,
+                hash: chain_hash(&entry, seq, at, &prev_hash),
+                prev_hash,
+            });
+
+            match self.entries.insert_one(&entry).await {
+                Ok(_) => return Ok(()),
+                Err(e) if is_duplicate_key(&e) => continue,
+                Err(e) => return Err(e.into()),
+            }
+        }
+
+        Err(AppError::InternalError(format!(
+            "audit log entry {} lost {APPEND_ATTEMPTS} races for a sequence number",
+            entry.id
+        )))
+    }
+
+    /// The chained entries recorded in `[from, to)` as JSON lines in chain
+    /// order, followed by a trailer signed with `export_signing_key`. `None`
+    /// without a key.
+    pub async fn export(
+        &self,
+        from: DateTime<Utc>,
+        to: DateTime<Utc>,
+    ) -> Result<Option<Vec<u8>>, AppError> {
+        if self.export_signing_key.is_empty() {
+            return Ok(None);
+        }
+
+        let entries: Vec<AuditLogEntry> = self
+            .entries
+            .find(doc! { "chain.at": {
+                "$gte": from.timestamp_millis(),
+                "$lt": to.timestamp_millis(),
+            } })
+            .sort(doc! { "chain.seq": 1 })
+            .limit(self.export_max_entries.saturating_add(1) as i64)
+            .await?
+            .try_collect()
+            .await?;
+        let truncated = entries.len() as u64 > self.export_max_entries;
+        let entries = &entries[..entries.len().min(self.export_max_entries as usize)];
+
+        let mut body = Vec::new();
+        for entry in entries {
+            serde_json::to_writer(&mut body, entry)?;
+            body.push(b'\n');
+        }
+
+        let broken_at = first_broken_link(entries);
+        let mut mac = Hmac::<Sha256>::new_from_slice(self.export_signing_key.as_bytes())
+            .expect("hmac accepts keys of any length");
+        mac.update(&body);
+        let trailer = ExportTrailer {
+            from,
+            to,
+            count: entries.len(),
+            intact: broken_at.is_none(),
+            broken_at,
+            truncated,
+            signature: format!("sha256={}", hex::encode(mac.finalize().into_bytes())),
+        };
+        serde_json::to_writer(&mut body, &trailer)?;
+        body.push(b'\n');
+
+        Ok(Some(body))
+    }
+}
+
+/// Sequence number of the first entry whose hash doesn't match its content or
+/// which doesn't follow the entry before it.
+fn first_broken_link(entries: &[AuditLogEntry]) -> Option<i64> {
+    let mut prev: Option<&AuditChainLink> = None;
+    for entry in entries {
+        let Some(link) = &entry.chain else {
+            continue;
+        };
+        let follows =
+            prev.is_none_or(|prev| link.seq == prev.seq + 1 && link.prev_hash == prev.hash);
+        if !follows || chain_hash(entry, link.seq, link.at, &link.prev_hash) != link.hash {
+            return Some(link.seq);
+        }
+        prev = Some(link);
+    }
+    None
+}
+
+fn is_duplicate_key(e: &mongodb::error::Error) -> bool {
+    matches!(
+        e.kind.as_ref(),
+        ErrorKind::Write(WriteFailure::WriteError(write)) if write.code == 11000
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn chained(count: usize) -> Vec<AuditLogEntry> {
+        let mut prev_hash = GENESIS_HASH.to_string();
+        (1..=count as i64)
+            .map(|seq| {
+                let mut entry = AuditLogEntry::new(None, "admin", "topic_closed", "by hand");
+                let at = entry.created_at.timestamp_millis();
+                let hash = chain_hash(&entry, seq, at, &prev_hash);
+                entry.chain = Some(AuditChainLink {
+                    seq,
+                    at,
+                    prev_hash: std::mem::replace(&mut prev_hash, hash.clone()),
+                    hash,
+                });
+                entry
+            })
+            .collect()
+    }
+
+    #[test]
+    fn edits_and_gaps_break_the_chain() {
+        let entries = chained(4);
+        assert_eq!(first_broken_link(&entries), None);
+
+        let mut edited = entries.clone();
+        edited[2].detail = "rewritten".to_string();
+        assert_eq!(first_broken_link(&edited), Some(3));
+
+        let mut removed = entries.clone();
+        removed.remove(1);
+        assert_eq!(first_broken_link(&removed), Some(3));
+    }
+}
@@ -0,0 +1,324 @@
+use std::time::Duration;
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{Collection, bson::doc, options::ReturnDocument};
+use rand::{Rng as _, distr::Alphanumeric};
+use redis::AsyncCommands as _;
+use sha2::{Digest as _, Sha256};
+use share::{
+    client_ip::voter_key,
+    config::NotificationKind,
+    models::{
+        api::{ApiMsg, BookmarkAddRequest},
+        database::{CreateTopicStatus, TopicBookmark, VotingTopic},
+    },
+    reload::ConfigWatch,
+};
+
+use crate::{
+    error::AppError,
+    notify::Notification,
+    service::{NotificationService, TopicService},
+};
+
+const CONFIRM_TOKEN_LENGTH: usize = 32;
+/// An address is mailed at most one confirmation in this time while none
+/// was confirmed, whoever names it.
+const CONFIRM_MAIL_INTERVAL: Duration = Duration::from_secs(24 * 60 * 60);
+
+/// Set while a confirmation mailed to `email` is pending. The address is
+/// hashed, redis holds no mail addresses.
+fn confirm_mail_key(email: &str) -> String {
+    let hash = Sha256::digest(email.to_lowercase().as_bytes());
+    format!("bookmark:confirm_mail:{}", hex::encode(hash))
+}
+
+fn topic_title(topic: &VotingTopic) -> &str {
+    match topic.title.is_empty() {
+        true => &topic.name,
+        false => &topic.title,
+    }
+}
+
+fn generate_confirm_token() -> String {
+    rand::rng()
+        .sample_iter(&Alphanumeric)
+        .take(CONFIRM_TOKEN_LENGTH)
+        .map(char::from)
+        .collect()
+}
+
+/// Bookmarks of the voter addresses, and the reminders some of them asked
+/// for. A reminder is one mail over the `[notify]` channels taking
+/// `topic_reminder`, sent when the phase watcher sees voting open. Anyone
+/// can name any address, so a new one is mailed a confirmation link first,
+/// at most one a day, and only confirmed ones get the reminder.
+#[derive(Clone)]
+pub struct BookmarkService {
+    bookmarks: Collection<TopicBookmark>,
+    redis: redis::aio::MultiplexedConnection,
+    topic_service: TopicService,
+    notifications: NotificationService,
+    reload: ConfigWatch,
+}
+
+impl BookmarkService {
+    pub fn new(
+        mongo: mongodb::Database,
+        redis: redis::aio::MultiplexedConnection,
+        topic_service: TopicService,
+        notifications: NotificationService,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            bookmarks: mongo.collection::<TopicBookmark>("topic_bookmarks"),
+            redis,
+            topic_service,
+            notifications,
+            reload,
+        }
+    }
+
+    /// The bookmarks of `ip`, the latest first.
+    pub async fn list(&self, ip: &str) -> Result<Vec<TopicBookmark>, AppError> {
+        let config = self.reload.current();
+        Ok(self
+            .bookmarks
+            .find(doc! { "ip": voter_key(ip, &config.vote).as_ref() })
+            .sort(doc! { "created_at": -1 })
+            .await?
+            .try_collect()
+            .await?)
+    }
+
+    /// Bookmarks an approved topic which has not closed, or replaces the
+    /// reminder of the bookmark `ip` already has. A new reminder address is
+    /// mailed a confirmation link unless `ip` confirmed it before, and is
+    /// turned away while another confirmation to it is pending. The outer
+    /// error is reserved for database failures.
+    pub async fn add(
+        &self,
+        ip: &str,
+        req: BookmarkAddRequest,
+    ) -> Result<Result<TopicBookmark, ApiMsg>, AppError> {
+        let config = self.reload.current();
+        let now = Utc::now();
+        let topic = match self.topic_service.get_topic(&req.topic_id).await? {
+            Some(topic) if matches!(topic.status, CreateTopicStatus::Approved(_)) => topic,
+            _ => return Ok(Err(ApiMsg::TargetTopicNotFound)),
+        };
+        if topic.close_time <= now {
+            return Ok(Err(ApiMsg::BookmarkInvalid(
+                "voting already closed".to_string(),
+            )));
+        }
+        let remind_email = match req.remind_email.as_deref().map(str::trim) {
+            None | Some("") => None,
+            Some(_) if topic.open_time <= now => {
+                return Ok(Err(ApiMsg::BookmarkInvalid(
+                    "voting already opened, there is nothing to remind of".to_string(),
+                )));
+            }
+            Some(email) => match email.parse::<lettre::Address>() {
+                Ok(address) => Some(address.to_string()),
+                Err(_) => {
+                    return Ok(Err(ApiMsg::BookmarkInvalid(
+                        "not an email address".to_string(),
+                    )));
+                }
+            },
+        };
+
+        let ip = voter_key(ip, &config.vote).into_owned();
+        let filter = doc! { "ip": &ip, "topic_id": &topic.id };
+        let existing = self.bookmarks.find_one(filter.clone()).await?;
+        if existing.is_none() {
+            let count = self.bookmarks.count_documents(doc! { "ip": &ip }).await?;
+            if count >= config.bookmark.max_per_address {
+                return Ok(Err(ApiMsg::BookmarkInvalid(format!(
+                    "at most {} topics can be bookmarked",
+                    config.bookmark.max_per_address
+                ))));
+            }
+        }
+
+        // the same address keeps its confirmation, and is not mailed again
+        let unchanged = existing
+            .as_ref()
+            .filter(|bookmark| remind_email.is_some() && bookmark.remind_email == remind_email);
+        let (remind_confirmed, confirm_token) = match (unchanged, &remind_email) {
+            (Some(bookmark), _) => (bookmark.remind_confirmed, bookmark.confirm_token.clone()),
+            (None, None) => (false, None),
+            (None, Some(email)) if self.has_confirmed(&ip, email).await? => (true, None),
+            (None, Some(email)) => {
+                if !self.claim_confirm_mail(email).await? {
+                    return Ok(Err(ApiMsg::BookmarkInvalid(
+                        "a confirmation was already mailed to this address today".to_string(),
+                    )));
+                }
+                (false, Some(generate_confirm_token()))
+            }
+        };
+        let confirm = unchanged.is_none().then(|| confirm_token.clone()).flatten();
+        let title = topic_title(&topic).to_string();
+
+        let bookmark = TopicBookmark {
+            ip,
+            topic_id: topic.id,
+            remind_email,
+            remind_confirmed,
+            confirm_token,
+            reminded_at: existing.as_ref().and_then(|bookmark| bookmark.reminded_at),
+            created_at: existing.map_or(now, |bookmark| bookmark.created_at),
+        };
+        self.bookmarks
+            .replace_one(filter, &bookmark)
+            .upsert(true)
+            .await?;
+        if let (Some(token), Some(email)) = (confirm, &bookmark.remind_email) {
+            self.send_confirmation(&title, email, &token).await;
+        }
+        Ok(Ok(bookmark))
+    }
+
+    /// Whether `ip` confirmed `email` for another of its bookmarks.
+    async fn has_confirmed(&self, ip: &str, email: &str) -> Result<bool, AppError> {
+        Ok(self
+            .bookmarks
+            .find_one(doc! { "ip": ip, "remind_email": email, "remind_confirmed": true })
+            .await?
+            .is_some())
+    }
+
+    /// Takes the one pending confirmation `email` may have, false while
+    /// another one is.
+    async fn claim_confirm_mail(&self, email: &str) -> Result<bool, AppError> {
+        let mut conn = self.redis.clone();
+        let claimed: Option<String> = redis::cmd("SET")
+            .arg(confirm_mail_key(email))
+            .arg(1)
+            .arg("NX")
+            .arg("EX")
+            .arg(CONFIRM_MAIL_INTERVAL.as_secs())
+            .query_async(&mut conn)
+            .await?;
+        Ok(claimed.is_some())
+    }
+
+    async fn send_confirmation(&self, title: &str, email: &str, token: &str) {
+        let config = self.reload.current();
+        let body = format!(
+            "Someone asked for a reminder when voting on {title} opens to be \
+             mailed to this address. Confirm it here:\n{}\n\n\
+             Without the confirmation nothing more is sent.",
+            config.bookmark.confirm_url.replace("{token}", token),
+        );
+        self.notifications
+            .notify(Notification {
+                to: Some(email.to_string()),
+                ..Notification::new(
+                    NotificationKind::TopicReminder,
+                    format!("Confirm your reminder for {title}"),
+                    body,
+                )
+            })
+            .await;
+    }
+
+    /// Confirms the reminder address the mail with `token` went to, for
+    /// every bookmark of the same voter naming it.
+    pub async fn confirm(&self, token: &str) -> Result<Result<TopicBookmark, ApiMsg>, AppError> {
+        let confirmed = self
+            .bookmarks
+            .find_one_and_update(
+                doc! { "confirm_token": token },
+                doc! { "$set": { "remind_confirmed": true, "confirm_token": null } },
+            )
+            .return_document(ReturnDocument::After)
+            .await?;
+        let Some(confirmed) = confirmed else {
+            return Ok(Err(ApiMsg::BookmarkNotFound));
+        };
+
+        if let Some(email) = &confirmed.remind_email {
+            self.bookmarks
+                .update_many(
+                    doc! { "ip": &confirmed.ip, "remind_email": email, "remind_confirmed": false },
+                    doc! { "$set": { "remind_confirmed": true, "confirm_token": null } },
+                )
+                .await?;
+            let mut conn = self.redis.clone();
+            let _: () = conn.del(confirm_mail_key(email)).await?;
+        }
+        Ok(Ok(confirmed))
+    }
+
+    pub async fn remove(&self, ip: &str, topic_id: &str) -> Result<Result<(), ApiMsg>, AppError> {
+        let config = self.reload.current();
+        let deleted = self
+            .bookmarks
+            .delete_one(doc! {
+                "ip": voter_key(ip, &config.vote).as_ref(),
+                "topic_id": topic_id,
+            })
+            .await?
+            .deleted_count;
+        Ok(match deleted {
+            0 => Err(ApiMsg::BookmarkNotFound),
+            _ => Ok(()),
+        })
+    }
+
+    /// Queues the reminders of a topic whose voting just opened, each once.
+    pub async fn topic_opened(&self, topic: &VotingTopic) -> Result<(), AppError> {
+        let pending: Vec<TopicBookmark> = self
+            .bookmarks
+            .find(doc! {
+                "topic_id": &topic.id,
+                "remind_email": { "$ne": null },
+                "remind_confirmed": true,
+                "reminded_at": null,
+            })
+            .await?
+            .try_collect()
+            .await?;
+        if pending.is_empty() {
+            return Ok(());
+        }
+
+        let config = self.reload.current();
+        let title = topic_title(topic);
+        let subject = format!("Voting opened: {title}");
+        let body = format!(
+            "{title} is open for votes until {}.\n{}\n\n\
+             You asked for this reminder when bookmarking the topic, it is sent once.",
+            topic.close_time.to_rfc3339(),
+            config.embed.topic_url.replace("{id}", &topic.id),
+        );
+        for bookmark in pending {
+            let Some(email) = bookmark.remind_email else {
+                continue;
+            };
+            // marked first, a failed update must not mail the voter again
+            self.bookmarks
+                .update_one(
+                    doc! { "ip": &bookmark.ip, "topic_id": &topic.id },
+                    doc! { "$set": { "reminded_at": mongodb::bson::to_bson(&Utc::now())? } },
+                )
+                .await?;
+            self.notifications
+                .notify(Notification {
+                    to: Some(email),
+                    ..Notification::new(
+                        NotificationKind::TopicReminder,
+                        subject.clone(),
+                        body.clone(),
+                    )
+                })
+                .await;
+        }
+        tracing::info!("queued the reminders of {}", topic.id);
+        Ok(())
+    }
+}
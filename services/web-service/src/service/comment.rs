@@ -0,0 +1,352 @@
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{Collection, bson::doc, options::ReturnDocument};
+use rand::{Rng as _, distr::Alphanumeric};
+use sha2::{Digest as _, Sha256};
+use share::{
+    client_ip::voter_key,
+    config::CommentConfig,
+    events::{DomainEvent, DomainEventKind},
+    models::{
+        api::{
+            ApiMsg, CommentCreateRequest, CommentCreateResponse, CommentListRequest,
+            CommentListResponse, CommentModeration, CommentView,
+        },
+        database::{AuditLogEntry, CommentStatus, CreateTopicStatus, TopicComment},
+    },
+    reload::ConfigWatch,
+};
+
+use crate::{
+    error::AppError,
+    outbox::Outbox,
+    service::{AuditLogService, FilterVerdict, TopicService, WebhookService, WordFilterService},
+};
+
+const DELETE_TOKEN_LENGTH: usize = 32;
+
+fn hash_delete_token(token: &str) -> String {
+    hex::encode(Sha256::digest(token.as_bytes()))
+}
+
+/// Trims the body and the nickname and checks their lengths.
+fn normalize(req: &mut CommentCreateRequest, config: &CommentConfig) -> Result<(), String> {
+    req.body = req.body.trim().to_string();
+    let nickname = req.nickname.as_deref().map_or("", str::trim);
+    req.nickname = (!nickname.is_empty()).then(|| nickname.to_string());
+
+    if req.body.is_empty() {
+        return Err("body is empty".to_string());
+    }
+    if req.body.chars().count() > config.max_length {
+        return Err(format!("body is over {} characters", config.max_length));
+    }
+    if nickname.chars().count() > config.max_nickname_length {
+        return Err(format!(
+            "nickname is over {} characters",
+            config.max_nickname_length
+        ));
+    }
+    Ok(())
+}
+
+/// Comment threads under approved topics. Comments are never removed, the
+/// author or a moderator only changes their status, so replies keep their
+/// place. New comments are announced as `comment_posted` events for
+/// moderation bots, those the word filter holds arrive there as pending.
+#[derive(Clone)]
+pub struct CommentService {
+    comments: Collection<TopicComment>,
+    topic_service: TopicService,
+    webhooks: WebhookService,
+    outbox: Outbox,
+    audit_log: AuditLogService,
+    word_filter: WordFilterService,
+    reload: ConfigWatch,
+}
+
+impl CommentService {
+    pub fn new(
+        mongo: mongodb::Database,
+        topic_service: TopicService,
+        webhooks: WebhookService,
+        outbox: Outbox,
+        audit_log: AuditLogService,
+        word_filter: WordFilterService,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            comments: mongo.collection::<TopicComment>("comments"),
+            topic_service,
+            webhooks,
+            outbox,
+            audit_log,
+            word_filter,
+            reload,
+        }
+    }
+
+    /// Stores the comment under the snowflake `id`. The outer error is
+    /// reserved for database failures.
+    pub async fn create(
+        &self,
+        id: u64,
+        ip: &str,
+        mut req: CommentCreateRequest,
+    ) -> Result<Result<CommentCreateResponse, ApiMsg>, AppError> {
+        let config = self.reload.current();
+        if !config.comment.enabled {
+            return Ok(Err(ApiMsg::CommentsDisabled));
+        }
+        match self.topic_service.get_topic(&req.topic_id).await? {
+            Some(topic) if matches!(topic.status, CreateTopicStatus::Approved(_)) => {}
+            _ => return Ok(Err(ApiMsg::TargetTopicNotFound)),
+        }
+        if let Err(msg) = normalize(&mut req, &config.comment) {
+            return Ok(Err(ApiMsg::CommentInvalid(msg)));
+        }
+        let mut held = config.comment.pre_moderation;
+        let fields = [
+            (
+                "nickname",
+                config.word_filter.nickname,
+                req.nickname.as_mut(),
+            ),
+            ("comment", config.word_filter.comment, Some(&mut req.body)),
+        ];
+        for (field, action, text) in fields {
+            let Some(text) = text else { continue };
+            match self.word_filter.check(action, text) {
+                FilterVerdict::Reject => {
+                    return Ok(Err(ApiMsg::ContentRejected(field.to_string())));
+                }
+                FilterVerdict::Review => held = true,
+                FilterVerdict::Clean | FilterVerdict::Masked => {}
+            }
+        }
+        if let Some(parent_id) = &req.parent_id {
+            let parent = self
+                .comments
+                .find_one(doc! {
+                    "id": parent_id,
+                    "topic_id": &req.topic_id,
+                    "parent_id": null,
+                    "status": "visible",
+                })
+                .await?;
+            if parent.is_none() {
+                return Ok(Err(ApiMsg::CommentNotFound));
+            }
+        }
+
+        let delete_token: String = rand::rng()
+            .sample_iter(&Alphanumeric)
+            .take(DELETE_TOKEN_LENGTH)
+            .map(char::from)
+            .collect();
+        let comment = TopicComment {
+            id: format!("{id:020}"),
+            topic_id: req.topic_id,
+            parent_id: req.parent_id,
+            nickname: req.nickname.unwrap_or_default(),
+            body: req.body,
+            ip: voter_key(ip, &config.vote).into_owned(),
+            delete_token_hash: hash_delete_token(&delete_token),
+            status: match held {
+                true => CommentStatus::Pending,
+                false => CommentStatus::Visible,
+            },
+            reply_count: 0,
+            created_at: Utc::now(),
+            updated_at: None,
+        };
+        self.comments.insert_one(&comment).await?;
+        if let Some(parent_id) = &comment.parent_id {
+            self.comments
+                .update_one(
+                    doc! { "id": parent_id },
+                    doc! { "$inc": { "reply_count": 1_i64 } },
+                )
+                .await?;
+        }
+
+        // the comment is stored, a lost announcement must not fail the request
+        let event = DomainEvent::new(DomainEventKind::CommentPosted {
+            topic_id: comment.topic_id.clone(),
+            comment_id: comment.id.clone(),
+            parent_id: comment.parent_id.clone(),
+            nickname: comment.nickname.clone(),
+            body: comment.body.clone(),
+            pending: comment.status == CommentStatus::Pending,
+        });
+        self.webhooks.dispatch(&event).await;
+        if let Err(e) = self.outbox.publish(vec![event]).await {
+            tracing::warn!("Failed to publish comment {}: {}", comment.id, e);
+        }
+
+        Ok(Ok(CommentCreateResponse {
+            comment: comment.into(),
+            delete_token,
+        }))
+    }
+
+    /// One page of the top level comments of a topic, or of the replies to
+    /// one, newest first.
+    pub async fn list(
+        &self,
+        req: &CommentListRequest,
+    ) -> Result<Result<CommentListResponse, ApiMsg>, AppError> {
+        let config = self.reload.current();
+        if !config.comment.enabled {
+            return Ok(Err(ApiMsg::CommentsDisabled));
+        }
+        let limit = req
+            .limit
+            .unwrap_or(config.comment.page_size)
+            .clamp(1, config.comment.max_page_size);
+
+        let mut filter = doc! {
+            "topic_id": &req.topic_id,
+            "parent_id": req.parent_id.clone(),
+            "$or": [
+                { "status": "visible" },
+                { "status": { "$in": ["hidden", "deleted"] }, "reply_count": { "$gt": 0_i64 } },
+            ],
+        };
+        if let Some(before) = &req.before {
+            filter.insert("id", doc! { "$lt": before });
+        }
+        let mut comments: Vec<TopicComment> = self
+            .comments
+            .find(filter)
+            .sort(doc! { "id": -1 })
+            .limit(i64::from(limit) + 1)
+            .await?
+            .try_collect()
+            .await?;
+
+        let next = match comments.len() > limit as usize {
+            true => {
+                comments.truncate(limit as usize);
+                comments.last().map(|comment| comment.id.clone())
+            }
+            false => None,
+        };
+        Ok(Ok(CommentListResponse {
+            comments: comments.into_iter().map(CommentView::from).collect(),
+            next,
+        }))
+    }
+
+    pub async fn get(&self, id: &str) -> Result<Option<TopicComment>, AppError> {
+        Ok(self.comments.find_one(doc! { "id": id }).await?)
+    }
+
+    /// Takes a listed comment back to pending, for a moderator to decide.
+    pub async fn hold(&self, id: &str) -> Result<bool, AppError> {
+        let result = self
+            .comments
+            .update_one(
+                doc! { "id": id, "status": "visible" },
+                doc! { "$set": {
+                    "status": "pending",
+                    "updated_at": mongodb::bson::to_bson(&Utc::now())?,
+                } },
+            )
+            .await?;
+        Ok(result.modified_count > 0)
+    }
+
+    /// Deletion by the author, who holds the token handed out on create.
+    pub async fn delete(
+        &self,
+        id: &str,
+        delete_token: &str,
+    ) -> Result<Result<(), ApiMsg>, AppError> {
+        let Some(comment) = self.comments.find_one(doc! { "id": id }).await? else {
+            return Ok(Err(ApiMsg::CommentNotFound));
+        };
+        if comment.delete_token_hash != hash_delete_token(delete_token) {
+            return Ok(Err(ApiMsg::CommentDeleteTokenInvalid));
+        }
+        self.set_status(id, CommentStatus::Deleted).await?;
+        Ok(Ok(()))
+    }
+
+    /// A moderator's decision, recorded in the audit log.
+    pub async fn moderate(
+        &self,
+        id: &str,
+        action: CommentModeration,
+        reason: &str,
+        actor: &str,
+    ) -> Result<Result<CommentView, ApiMsg>, AppError> {
+        let (status, audit_action) = match action {
+            CommentModeration::Approve => (CommentStatus::Visible, "comment_approved"),
+            CommentModeration::Hide => (CommentStatus::Hidden, "comment_hidden"),
+            CommentModeration::Delete => (CommentStatus::Deleted, "comment_deleted"),
+        };
+        let Some(comment) = self.set_status(id, status).await? else {
+            return Ok(Err(ApiMsg::CommentNotFound));
+        };
+
+        self.audit_log
+            .record(AuditLogEntry::new(
+                Some(comment.topic_id.clone()),
+                actor,
+                audit_action,
+                format!("comment {id}: {reason}"),
+            ))
+            .await?;
+        Ok(Ok(comment.into()))
+    }
+
+    async fn set_status(
+        &self,
+        id: &str,
+        status: CommentStatus,
+    ) -> Result<Option<TopicComment>, AppError> {
+        Ok(self
+            .comments
+            .find_one_and_update(
+                doc! { "id": id },
+                doc! { "$set": {
+                    "status": mongodb::bson::to_bson(&status)?,
+                    "updated_at": mongodb::bson::to_bson(&Utc::now())?,
+                } },
+            )
+            .return_document(ReturnDocument::After)
+            .await?)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(nickname: Option<&str>, body: &str) -> CommentCreateRequest {
+        CommentCreateRequest {
+            topic_id: "t".to_string(),
+            parent_id: None,
+            nickname: nickname.map(str::to_string),
+            body: body.to_string(),
+        }
+    }
+
+    #[test]
+    fn comments_are_trimmed_and_checked() {
+        let config = CommentConfig {
+            max_length: 5,
+            max_nickname_length: 3,
+            ..Default::default()
+        };
+
+        let mut req = request(Some("  "), " 博士好 \n");
+        assert_eq!(normalize(&mut req, &config), Ok(()));
+        assert_eq!((req.nickname, req.body.as_str()), (None, "博士好"));
+
+        assert!(normalize(&mut request(None, "   "), &config).is_err());
+        assert!(normalize(&mut request(None, "too long"), &config).is_err());
+        assert!(normalize(&mut request(Some("Amiya"), "hi"), &config).is_err());
+    }
+}
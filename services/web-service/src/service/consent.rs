@@ -0,0 +1,148 @@
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use mongodb::{Collection, bson::doc};
+use redis::AsyncCommands as _;
+use share::{
+    client_ip::voter_key,
+    config::ConsentConfig,
+    models::{
+        api::{ApiMsg, ConsentStatus},
+        database::ConsentRecord,
+    },
+    reload::ConfigWatch,
+};
+
+use crate::error::AppError;
+
+/// How long an address having accepted a version is remembered, a new
+/// version is a new key.
+const ACCEPTED_CACHE_TTL: Duration = Duration::from_secs(60 * 60);
+/// Not having accepted is remembered briefly, accepting overwrites it.
+const MISSING_CACHE_TTL: Duration = Duration::from_secs(30);
+
+fn accepted_key(version: &str, voter: &str) -> String {
+    format!("consent:{version}:{voter}")
+}
+
+fn status(consent: &ConsentConfig, accepted_at: Option<DateTime<Utc>>) -> ConsentStatus {
+    ConsentStatus {
+        version: Some(consent.version.clone()),
+        terms_url: Some(consent.terms_url.clone()),
+        accepted_at,
+    }
+}
+
+/// Acceptance of the `[consent]` terms per voter address. There are no
+/// accounts, the records here are what ballot requests are checked against,
+/// see `middleware::consent`, and the proof of when the terms were accepted.
+#[derive(Clone)]
+pub struct ConsentService {
+    consents: Collection<ConsentRecord>,
+    redis: redis::aio::MultiplexedConnection,
+    reload: ConfigWatch,
+}
+
+impl ConsentService {
+    pub fn new(
+        mongo: mongodb::Database,
+        redis: redis::aio::MultiplexedConnection,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            consents: mongo.collection::<ConsentRecord>("consents"),
+            redis,
+            reload,
+        }
+    }
+
+    /// The terms ballot requests have to carry, `None` while no consent is
+    /// asked for.
+    pub fn required(&self) -> Option<ConsentStatus> {
+        let config = self.reload.current();
+        (!config.consent.version.is_empty()).then(|| status(&config.consent, None))
+    }
+
+    /// Whether the latest acceptance recorded for `ip` is of `version`. Runs
+    /// on every ballot, so the answer is cached in redis per address and
+    /// version.
+    pub async fn has_accepted(&self, ip: &str, version: &str) -> Result<bool, AppError> {
+        let config = self.reload.current();
+        let voter = voter_key(ip, &config.vote);
+        let key = accepted_key(version, &voter);
+
+        let mut conn = self.redis.clone();
+        let cached: Option<u8> = conn.get(&key).await?;
+        if let Some(cached) = cached {
+            return Ok(cached == 1);
+        }
+
+        let latest = self
+            .consents
+            .find_one(doc! { "ip": voter.as_ref() })
+            .sort(doc! { "accepted_at": -1 })
+            .await?;
+        let accepted = latest.is_some_and(|record| record.version == version);
+        let ttl = match accepted {
+            true => ACCEPTED_CACHE_TTL,
+            false => MISSING_CACHE_TTL,
+        };
+        let _: () = conn.set_ex(&key, accepted as u8, ttl.as_secs()).await?;
+        Ok(accepted)
+    }
+
+    /// The current terms and when `ip` accepted them.
+    pub async fn status(&self, ip: &str) -> Result<ConsentStatus, AppError> {
+        let config = self.reload.current();
+        if config.consent.version.is_empty() {
+            return Ok(ConsentStatus {
+                version: None,
+                terms_url: None,
+                accepted_at: None,
+            });
+        }
+
+        let accepted = self
+            .consents
+            .find_one(doc! {
+                "ip": voter_key(ip, &config.vote).as_ref(),
+                "version": &config.consent.version,
+            })
+            .sort(doc! { "accepted_at": -1 })
+            .await?;
+        Ok(status(
+            &config.consent,
+            accepted.map(|record| record.accepted_at),
+        ))
+    }
+
+    /// Records `ip` accepting `version`, which has to be the current one. The
+    /// outer error is reserved for database failures.
+    pub async fn accept(
+        &self,
+        ip: &str,
+        version: &str,
+    ) -> Result<Result<ConsentStatus, ApiMsg>, AppError> {
+        let config = self.reload.current();
+        if config.consent.version.is_empty() || version != config.consent.version {
+            return Ok(Err(ApiMsg::ConsentVersionOutdated));
+        }
+
+        let record = ConsentRecord {
+            ip: voter_key(ip, &config.vote).into_owned(),
+            version: version.to_string(),
+            accepted_at: Utc::now(),
+        };
+        self.consents.insert_one(&record).await?;
+
+        let mut conn = self.redis.clone();
+        let _: () = conn
+            .set_ex(
+                accepted_key(version, &record.ip),
+                1u8,
+                ACCEPTED_CACHE_TTL.as_secs(),
+            )
+            .await?;
+        Ok(Ok(status(&config.consent, Some(record.accepted_at))))
+    }
+}
@@ -0,0 +1,251 @@
+use std::{fmt::Write as _, time::Duration};
+
+use chrono::{DateTime, Utc};
+use futures::TryStreamExt as _;
+use mongodb::{
+    Collection,
+    bson::{Document, doc},
+};
+use serde::{Deserialize, Serialize};
+use share::{
+    models::database::{PublishedDataset, TaskPriority, VotingTopic, VotingTopicType},
+    reload::ConfigWatch,
+};
+
+use crate::{
+    error::AppError,
+    queue::{RetryPolicy, TaskQueue},
+    service::TopicService,
+    storage::Storage,
+};
+
+/// Kind of the queue tasks publishing the dataset of one topic.
+const DATASET_TASK: &str = "dataset_publish";
+
+const RETRY_POLICY: RetryPolicy = RetryPolicy {
+    max_attempts: 5,
+    initial_backoff: Duration::from_secs(60),
+    max_backoff: Duration::from_secs(1800),
+};
+
+/// Payload of a [`DATASET_TASK`].
+#[derive(Debug, Serialize, Deserialize)]
+struct DatasetTask {
+    topic_id: String,
+}
+
+/// Ballots of one matchup within one window, as counted by mongo.
+#[derive(Debug, Deserialize)]
+struct Group {
+    #[serde(rename = "_id")]
+    key: GroupKey,
+    count: u64,
+}
+
+#[derive(Debug, Deserialize, PartialEq, Eq, PartialOrd, Ord)]
+struct GroupKey {
+    window: i64,
+    win: i32,
+    lose: i32,
+}
+
+/// The groups of at least `min_group_size` ballots in a stable order, which
+/// says nothing about the order the ballots came in, and the number of
+/// ballots left out.
+fn anonymize(mut groups: Vec<Group>, min_group_size: u64) -> (Vec<Group>, u64) {
+    let suppressed = groups
+        .iter()
+        .filter(|group| group.count < min_group_size)
+        .map(|group| group.count)
+        .sum();
+    groups.retain(|group| group.count >= min_group_size);
+    groups.sort_by(|a, b| a.key.cmp(&b.key));
+    (groups, suppressed)
+}
+
+/// Publishes the passed ballots of closed pairwise topics without anything
+/// pointing at a voter: no address, no exact time, and no row for fewer
+/// than `dataset.min_group_size` ballots.
+#[derive(Clone)]
+pub struct DatasetService {
+    mongo: mongodb::Database,
+    datasets: Collection<PublishedDataset>,
+    storage: Storage,
+    queue: TaskQueue,
+    topic_service: TopicService,
+    reload: ConfigWatch,
+}
+
+impl DatasetService {
+    pub fn new(
+        mongo: mongodb::Database,
+        storage: Storage,
+        queue: TaskQueue,
+        topic_service: TopicService,
+        reload: ConfigWatch,
+    ) -> Self {
+        let service = Self {
+            datasets: mongo.collection::<PublishedDataset>("datasets"),
+            mongo,
+            storage,
+            queue,
+            topic_service,
+            reload,
+        };
+        service
+            .queue
+            .register(DATASET_TASK, TaskPriority::Low, RETRY_POLICY, {
+                let service = service.clone();
+                move |task: DatasetTask, _| {
+                    let service = service.clone();
+                    async move {
+                        service
+                            .publish(&task.topic_id)
+                            .await
+                            .map(|_| ())
+                            .map_err(|e| e.to_string())
+                    }
+                }
+            });
+
+        service
+    }
+
+    /// Queues the publication of a topic which just closed, while
+    /// `dataset.enabled` is set.
+    pub async fn topic_closed(&self, topic: &VotingTopic) -> Result<(), AppError> {
+        if !self.reload.current().dataset.enabled || topic.topic_type != VotingTopicType::Pairwise {
+            return Ok(());
+        }
+        let task = DatasetTask {
+            topic_id: topic.id.clone(),
+        };
+        self.queue.enqueue(DATASET_TASK, &task).await?;
+        Ok(())
+    }
+
+    pub async fn get(&self, topic_id: &str) -> Result<Option<PublishedDataset>, AppError> {
+        Ok(self
+            .datasets
+            .find_one(doc! { "topic_id": topic_id })
+            .await?)
+    }
+
+    pub fn url(&self, dataset: &PublishedDataset) -> String {
+        self.storage.url(&dataset.key)
+    }
+
+    /// Counts the ballots of the topic and writes the file, replacing an
+    /// earlier one. Fails for a topic still open, its dataset would leak the
+    /// standings.
+    pub async fn publish(&self, topic_id: &str) -> Result<PublishedDataset, AppError> {
+        match self.topic_service.get_topic(topic_id).await? {
+            Some(topic)
+                if topic.topic_type == VotingTopicType::Pairwise
+                    && topic.close_time < Utc::now() => {}
+            _ => {
+                return Err(AppError::InternalError(format!(
+                    "topic {topic_id} is not a closed pairwise topic"
+                )));
+            }
+        }
+        let config = self.reload.current().dataset.clone();
+        let window_ms = config.window_secs.max(1) as i64 * 1000;
+
+        let groups: Vec<Group> = self
+            .mongo
+            .collection::<Document>(&format!("ballots_{topic_id}"))
+            .aggregate([
+                doc! { "$match": { "topic_type": "pairwise", "audit": "passed" } },
+                doc! { "$group": {
+                    "_id": {
+                        "window": { "$subtract": [
+                            "$info.timestamp",
+                            { "$mod": ["$info.timestamp", window_ms] },
+                        ] },
+                        "win": "$win",
+                        "lose": "$lose",
+                    },
+                    "count": { "$sum": 1_i64 },
+                } },
+            ])
+            .with_type::<Group>()
+            .await?
+            .try_collect()
+            .await?;
+        let (groups, suppressed) = anonymize(groups, config.min_group_size);
+
+        let mut csv = String::from("window_start,winner,loser,ballots\n");
+        for group in &groups {
+            let window = DateTime::<Utc>::from_timestamp_millis(group.key.window)
+                .unwrap_or_default()
+                .to_rfc3339();
+            let _ = writeln!(
+                csv,
+                "{window},{},{},{}",
+                group.key.win, group.key.lose, group.count
+            );
+        }
+
+        let created_at = Utc::now();
+        let dataset = PublishedDataset {
+            topic_id: topic_id.to_string(),
+            key: format!("datasets/{topic_id}-{}.csv", created_at.timestamp_millis()),
+            rows: groups.len() as u64,
+            ballots: groups.iter().map(|group| group.count).sum(),
+            suppressed,
+            min_group_size: config.min_group_size,
+            window_secs: config.window_secs,
+            created_at,
+        };
+        self.storage.put(&dataset.key, csv.as_bytes()).await?;
+
+        let previous = self
+            .datasets
+            .find_one_and_replace(doc! { "topic_id": topic_id }, &dataset)
+            .upsert(true)
+            .await?;
+        if let Some(previous) = previous
+            && let Err(e) = self.storage.delete(&previous.key).await
+        {
+            tracing::warn!("Failed to delete replaced dataset {}: {}", previous.key, e);
+        }
+
+        tracing::info!(
+            "published dataset of {}: {} ballots, {} suppressed",
+            topic_id,
+            dataset.ballots,
+            suppressed
+        );
+        Ok(dataset)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn group(window: i64, win: i32, lose: i32, count: u64) -> Group {
+        Group {
+            key: GroupKey { window, win, lose },
+            count,
+        }
+    }
+
+    #[test]
+    fn small_groups_are_left_out() {
+        let groups = vec![
+            group(7_200_000, 1, 2, 5),
+            group(3_600_000, 2, 1, 4),
+            group(3_600_000, 1, 2, 9),
+        ];
+
+        let (groups, suppressed) = anonymize(groups, 5);
+        assert_eq!(suppressed, 4);
+        let keys: Vec<_> = groups
+            .iter()
+            .map(|group| (group.key.window, group.count))
+            .collect();
+        assert_eq!(keys, [(3_600_000, 9), (7_200_000, 5)]);
+    }
+}
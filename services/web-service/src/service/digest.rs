@@ -0,0 +1,311 @@
+use std::collections::HashMap;
+
+use chrono::{Days, NaiveDate, Utc};
+use futures::TryStreamExt as _;
+use mongodb::{Collection, bson::doc};
+use share::{
+    events::{DomainEvent, DomainEventKind},
+    models::database::{CreateTopicStatus, DailyDigest, DigestMatchup, DigestMover, VotingTopic},
+    reload::ConfigWatch,
+};
+
+use crate::{
+    error::AppError,
+    outbox::Outbox,
+    service::{ResultsSnapshotService, TopicService, WebhookService},
+};
+
+/// The options with votes by win rate, ties by id so the ranking of equal
+/// totals does not move between digests.
+pub(super) fn ranking(stats: &HashMap<i32, (i64, i64)>) -> Vec<i32> {
+    let mut rated: Vec<(i32, f64)> = stats
+        .iter()
+        .filter(|(_, (win, lose))| win + lose > 0)
+        .map(|(&id, &(win, lose))| (id, win as f64 * 100.0 / (win + lose) as f64))
+        .collect();
+    rated.sort_by(|a, b| {
+        b.1.partial_cmp(&a.1)
+            .unwrap_or(std::cmp::Ordering::Equal)
+            .then(a.0.cmp(&b.0))
+    });
+    rated.into_iter().map(|(id, _)| id).collect()
+}
+
+/// The options ranked in both, by the size of their rank change, the
+/// better new rank first on equal changes.
+fn movers(previous: &[i32], current: &[i32], max: usize) -> Vec<DigestMover> {
+    let previous: HashMap<i32, u32> = previous
+        .iter()
+        .enumerate()
+        .map(|(rank, &id)| (id, rank as u32 + 1))
+        .collect();
+    let mut movers: Vec<DigestMover> = current
+        .iter()
+        .enumerate()
+        .filter_map(|(rank, &id)| {
+            let from = *previous.get(&id)?;
+            let to = rank as u32 + 1;
+            (from != to).then_some(DigestMover { id, from, to })
+        })
+        .collect();
+    movers.sort_by(|a, b| {
+        b.from
+            .abs_diff(b.to)
+            .cmp(&a.from.abs_diff(a.to))
+            .then(a.to.cmp(&b.to))
+    });
+    movers.truncate(max);
+    movers
+}
+
+/// The pairs among the top `among` options which met head to head, the
+/// smallest margin first, then by rank.
+fn closest(
+    ranking: &[i32],
+    matrix: &HashMap<String, i64>,
+    among: usize,
+    max: usize,
+) -> Vec<DigestMatchup> {
+    let top = &ranking[..ranking.len().min(among)];
+    let mut matchups = Vec::new();
+    for (i, &higher) in top.iter().enumerate() {
+        for &lower in &top[i + 1..] {
+            if let Some(&margin) = matrix.get(&format!("{higher}:{lower}")) {
+                matchups.push(DigestMatchup {
+                    higher,
+                    lower,
+                    margin,
+                });
+            }
+        }
+    }
+    // stable, pairs of equal margins keep the rank order
+    matchups.sort_by_key(|matchup| matchup.margin.abs());
+    matchups.truncate(max);
+    matchups
+}
+
+/// Compiles a summary of the day before for every topic open during it, run
+/// by the scheduler, and keeps them in `daily_digests` for the frontend's
+/// highlights. Each one is also sent to the webhooks and the event bus.
+#[derive(Clone)]
+pub struct DigestService {
+    digests: Collection<DailyDigest>,
+    results_snapshots: ResultsSnapshotService,
+    topic_service: TopicService,
+    webhooks: WebhookService,
+    outbox: Outbox,
+    reload: ConfigWatch,
+}
+
+impl DigestService {
+    pub fn new(
+        mongo: mongodb::Database,
+        results_snapshots: ResultsSnapshotService,
+        topic_service: TopicService,
+        webhooks: WebhookService,
+        outbox: Outbox,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            digests: mongo.collection::<DailyDigest>("daily_digests"),
+            results_snapshots,
+            topic_service,
+            webhooks,
+            outbox,
+            reload,
+        }
+    }
+
+    /// Compiles the UTC day before for the approved pairwise topics open at
+    /// any time of it.
+    pub async fn compile_all(&self) -> Result<(), AppError> {
+        let today = Utc::now().date_naive();
+        let Some(date) = today.checked_sub_days(Days::new(1)) else {
+            return Ok(());
+        };
+        let day_start = date.and_time(chrono::NaiveTime::MIN).and_utc();
+        let day_end = today.and_time(chrono::NaiveTime::MIN).and_utc();
+        for topic in self.topic_service.cached_topics() {
+            let compiled = topic.is_active
+                && matches!(topic.status, CreateTopicStatus::Approved(_))
+                && topic.topic_type.supports_final_order()
+                && topic.open_time < day_end
+                && topic.close_time >= day_start;
+            if !compiled {
+                continue;
+            }
+            if let Err(e) = self.compile(&topic, date).await {
+                tracing::warn!("Failed to compile the digest of {}: {}", topic.id, e);
+            }
+        }
+        Ok(())
+    }
+
+    /// Replaces the digest of `date`, a rerun of the task on the same day
+    /// measures against the same day before.
+    async fn compile(&self, topic: &VotingTopic, date: NaiveDate) -> Result<(), AppError> {
+        let config = self.reload.current().digest.clone();
+        let snapshot = self.results_snapshots.get(&topic.id).await?;
+        let previous = match date.checked_sub_days(Days::new(1)) {
+            Some(day) => self.get(&topic.id, day).await?,
+            None => None,
+        };
+
+        let ranking = ranking(&snapshot.stats);
+        let ballots = match &previous {
+            Some(previous) => Some(snapshot.count - previous.total_ballots),
+            None if topic.open_time.date_naive() == date => Some(snapshot.count),
+            None => None,
+        };
+        let digest = DailyDigest {
+            topic_id: topic.id.clone(),
+            date,
+            total_ballots: snapshot.count,
+            ballots,
+            movers: previous
+                .map(|previous| movers(&previous.ranking, &ranking, config.movers))
+                .unwrap_or_default(),
+            closest: closest(
+                &ranking,
+                &snapshot.matrix,
+                config.closest_among,
+                config.closest,
+            ),
+            ranking,
+            compiled_at: Utc::now(),
+        };
+        self.digests
+            .replace_one(
+                doc! { "topic_id": &topic.id, "date": date.to_string() },
+                &digest,
+            )
+            .upsert(true)
+            .await?;
+
+        let event = DomainEvent::new(DomainEventKind::DigestPublished {
+            topic_id: topic.id.clone(),
+            digest,
+        });
+        self.webhooks.dispatch(&event).await;
+        if let Err(e) = self.outbox.publish(vec![event]).await {
+            tracing::warn!("Failed to publish the digest of {}: {}", topic.id, e);
+        }
+        tracing::info!("compiled the digest of {} for {}", topic.id, date);
+        Ok(())
+    }
+
+    pub async fn get(
+        &self,
+        topic_id: &str,
+        date: NaiveDate,
+    ) -> Result<Option<DailyDigest>, AppError> {
+        Ok(self
+            .digests
+            .find_one(doc! { "topic_id": topic_id, "date": date.to_string() })
+            .await?)
+    }
+
+    /// The digests of `date`, of the latest compiled date without one.
+    pub async fn list(
+        &self,
+        topic_id: Option<&str>,
+        date: Option<NaiveDate>,
+    ) -> Result<(Option<NaiveDate>, Vec<DailyDigest>), AppError> {
+        let mut filter = doc! {};
+        if let Some(topic_id) = topic_id {
+            filter.insert("topic_id", topic_id);
+        }
+        let date = match date {
+            Some(date) => Some(date),
+            None => self
+                .digests
+                .find_one(filter.clone())
+                .sort(doc! { "date": -1 })
+                .await?
+                .map(|digest| digest.date),
+        };
+        let Some(date) = date else {
+            return Ok((None, Vec::new()));
+        };
+        filter.insert("date", date.to_string());
+        let digests = self
+            .digests
+            .find(filter)
+            .sort(doc! { "topic_id": 1 })
+            .await?
+            .try_collect()
+            .await?;
+        Ok((Some(date), digests))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn ranks_move_and_matchups_are_close() {
+        // 3 and 4 tie on rate, the lower id ranks first
+        let stats = HashMap::from([
+            (1, (6, 4)),
+            (2, (9, 1)),
+            (3, (1, 1)),
+            (4, (2, 2)),
+            (5, (0, 0)),
+        ]);
+        let current = ranking(&stats);
+        assert_eq!(current, vec![2, 1, 3, 4]);
+
+        let moved = movers(&[3, 4, 1, 2], &current, 5);
+        assert_eq!(
+            moved,
+            vec![
+                DigestMover {
+                    id: 2,
+                    from: 4,
+                    to: 1
+                },
+                DigestMover {
+                    id: 3,
+                    from: 1,
+                    to: 3
+                },
+                DigestMover {
+                    id: 4,
+                    from: 2,
+                    to: 4
+                },
+                DigestMover {
+                    id: 1,
+                    from: 3,
+                    to: 2
+                },
+            ]
+        );
+
+        let matrix = HashMap::from([
+            ("2:1".to_string(), 4),
+            ("1:2".to_string(), -4),
+            ("1:3".to_string(), -1),
+            ("3:1".to_string(), 1),
+            ("3:4".to_string(), 1),
+            ("4:3".to_string(), -1),
+        ]);
+        assert_eq!(
+            closest(&current, &matrix, 3, 5),
+            vec![
+                DigestMatchup {
+                    higher: 1,
+                    lower: 3,
+                    margin: -1
+                },
+                DigestMatchup {
+                    higher: 2,
+                    lower: 1,
+                    margin: 4
+                },
+            ]
+        );
+    }
+}
@@ -0,0 +1,177 @@
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use mongodb::bson::{Document, doc};
+use serde::{Deserialize, Serialize};
+use share::{
+    client_ip::voter_ip_forms,
+    models::database::{AuditLogEntry, TaskPriority},
+    reload::ConfigWatch,
+};
+use uuid::Uuid;
+
+use crate::{
+    error::AppError,
+    queue::{RetryPolicy, TaskQueue},
+    service::AuditLogService,
+};
+
+/// Kind of the queue tasks erasing one voter address.
+const ERASURE_TASK: &str = "voter_erasure";
+
+const RETRY_POLICY: RetryPolicy = RetryPolicy {
+    max_attempts: 10,
+    initial_backoff: Duration::from_secs(60),
+    max_backoff: Duration::from_secs(3600),
+};
+
+/// Payload of an [`ERASURE_TASK`].
+#[derive(Debug, Serialize, Deserialize)]
+struct ErasureTask {
+    /// Every form the address may be stored in.
+    ips: Vec<String>,
+    /// Replaces the address, the ballots of the voter stay linked to each
+    /// other but not to the voter.
+    pseudonym: String,
+    actor: String,
+    requested_at: DateTime<Utc>,
+}
+
+/// Erasure of a voter address after a grace period. The ballots keep their
+/// choices and multipliers, so the rankings are untouched, only the address
+/// and the user agent are replaced.
+#[derive(Clone)]
+pub struct ErasureService {
+    mongo: mongodb::Database,
+    queue: TaskQueue,
+    audit_log: AuditLogService,
+    reload: ConfigWatch,
+}
+
+impl ErasureService {
+    pub fn new(
+        mongo: mongodb::Database,
+        queue: TaskQueue,
+        audit_log: AuditLogService,
+        reload: ConfigWatch,
+    ) -> Self {
+        let service = Self {
+            mongo,
+            queue,
+            audit_log,
+            reload,
+        };
+        service
+            .queue
+            .register(ERASURE_TASK, TaskPriority::Low, RETRY_POLICY, {
+                let service = service.clone();
+                move |task: ErasureTask, _| {
+                    let service = service.clone();
+                    async move { service.erase(task).await.map_err(|e| e.to_string()) }
+                }
+            });
+
+        service
+    }
+
+    /// Queues the erasure of `ip` to run once the grace period is over and
+    /// returns the task id with the time it runs at.
+    pub async fn request(
+        &self,
+        ip: &str,
+        actor: &str,
+    ) -> Result<(String, DateTime<Utc>), AppError> {
+        let config = self.reload.current();
+        let requested_at = Utc::now();
+        let run_at =
+            requested_at + chrono::Duration::hours(config.privacy.erasure_grace_hours as i64);
+        let task = ErasureTask {
+            ips: voter_ip_forms(ip, &config.vote),
+            pseudonym: format!("erased:{}", Uuid::new_v4()),
+            actor: actor.to_string(),
+            requested_at,
+        };
+        let id = self.queue.enqueue_at(ERASURE_TASK, &task, run_at).await?;
+
+        self.audit_log
+            .record(AuditLogEntry::new(
+                None,
+                actor,
+                "erasure_requested",
+                format!("task {id}, runs at {run_at}"),
+            ))
+            .await?;
+
+        Ok((id, run_at))
+    }
+
+    /// One attempt of an [`ERASURE_TASK`], repeating it is harmless.
+    async fn erase(&self, task: ErasureTask) -> Result<(), AppError> {
+        let collections = self
+            .mongo
+            .list_collection_names()
+            .filter(doc! { "name": { "$regex": "^ballots_" } })
+            .await?;
+
+        let mut erased = 0;
+        for name in &collections {
+            let result = self
+                .mongo
+                .collection::<Document>(name)
+                .update_many(
+                    doc! { "info.ip": { "$in": &task.ips } },
+                    doc! { "$set": {
+                        "info.ip": &task.pseudonym,
+                        "info.user_agent": "erased",
+                    } },
+                )
+                .await?;
+            erased += result.modified_count;
+        }
+        // the acceptances would still tie the address to a time of voting
+        let consents = self
+            .mongo
+            .collection::<Document>("consents")
+            .delete_many(doc! { "ip": { "$in": &task.ips } })
+            .await?
+            .deleted_count;
+        // they hold the reminder addresses
+        let bookmarks = self
+            .mongo
+            .collection::<Document>("topic_bookmarks")
+            .delete_many(doc! { "ip": { "$in": &task.ips } })
+            .await?
+            .deleted_count;
+        let comments = self
+            .mongo
+            .collection::<Document>("comments")
+            .update_many(
+                doc! { "ip": { "$in": &task.ips } },
+                doc! { "$set": { "ip": &task.pseudonym } },
+            )
+            .await?
+            .modified_count;
+        self.mongo
+            .collection::<Document>("content_reports")
+            .update_many(
+                doc! { "reporters": { "$in": &task.ips } },
+                doc! { "$set": { "reporters.$[reporter]": &task.pseudonym } },
+            )
+            .array_filters(vec![doc! { "reporter": { "$in": &task.ips } }])
+            .await?;
+
+        self.audit_log
+            .record(AuditLogEntry::new(
+                None,
+                &task.actor,
+                "erasure_completed",
+                format!(
+                    "{erased} ballots and {comments} comments as {}, {consents} consents and {bookmarks} bookmarks, requested at {}",
+                    task.pseudonym, task.requested_at
+                ),
+            ))
+            .await?;
+
+        Ok(())
+    }
+}
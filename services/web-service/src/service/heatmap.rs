@@ -0,0 +1,195 @@
+use chrono::{DateTime, Utc};
+use futures::TryStreamExt as _;
+use mongodb::{
+    Collection,
+    bson::{Document, doc, oid::ObjectId},
+};
+use serde::Deserialize;
+use share::{
+    models::database::{CreateTopicStatus, VoteHeatmap, VotingTopic},
+    reload::ConfigWatch,
+};
+
+use crate::{error::AppError, service::TopicService};
+
+/// How long after its close a topic is still rolled up, ballots cast last
+/// may still be in the consumers.
+const FINAL_ROLLUP_HOURS: i64 = 1;
+
+#[derive(Debug, Deserialize)]
+struct CellKey {
+    /// 1 for Monday.
+    weekday: i64,
+    hour: i64,
+}
+
+#[derive(Debug, Deserialize)]
+struct Cell {
+    #[serde(rename = "_id")]
+    key: CellKey,
+    count: i64,
+}
+
+/// The first object id the driver could generate at `at`, the stored
+/// ballots get theirs on insert so they are ordered by storage time.
+pub(super) fn boundary(at: DateTime<Utc>) -> ObjectId {
+    let mut bytes = [0; 12];
+    bytes[..4].copy_from_slice(&(at.timestamp().max(0) as u32).to_be_bytes());
+    ObjectId::from_bytes(bytes)
+}
+
+fn empty_cells() -> Vec<Vec<i64>> {
+    vec![vec![0; 24]; 7]
+}
+
+/// Adds the counted cells, ones out of the week are dropped.
+fn add_cells(cells: &mut [Vec<i64>], counted: &[Cell]) -> i64 {
+    let mut added = 0;
+    for cell in counted {
+        let slot = usize::try_from(cell.key.weekday - 1)
+            .ok()
+            .zip(usize::try_from(cell.key.hour).ok())
+            .and_then(|(weekday, hour)| cells.get_mut(weekday)?.get_mut(hour));
+        if let Some(slot) = slot {
+            *slot += cell.count;
+            added += cell.count;
+        }
+    }
+    added
+}
+
+/// Rolls the stored ballots of every topic up into weekday by hour counts,
+/// run by the scheduler. Each run only reads the ballots stored since the
+/// last one, by their object ids.
+#[derive(Clone)]
+pub struct HeatmapService {
+    mongo: mongodb::Database,
+    heatmaps: Collection<VoteHeatmap>,
+    topic_service: TopicService,
+    reload: ConfigWatch,
+}
+
+impl HeatmapService {
+    pub fn new(mongo: mongodb::Database, topic_service: TopicService, reload: ConfigWatch) -> Self {
+        Self {
+            heatmaps: mongo.collection::<VoteHeatmap>("vote_heatmaps"),
+            mongo,
+            topic_service,
+            reload,
+        }
+    }
+
+    pub async fn get(&self, topic_id: &str) -> Result<Option<VoteHeatmap>, AppError> {
+        Ok(self
+            .heatmaps
+            .find_one(doc! { "topic_id": topic_id })
+            .await?)
+    }
+
+    /// Rolls up the topics which opened until [`FINAL_ROLLUP_HOURS`] after
+    /// their close. Closed topics without a heatmap are rolled up once.
+    pub async fn rollup_all(&self) -> Result<(), AppError> {
+        let now = Utc::now();
+        for topic in self.topic_service.cached_topics() {
+            let rolled = topic.is_active
+                && matches!(topic.status, CreateTopicStatus::Approved(_))
+                && topic.open_time <= now;
+            if !rolled {
+                continue;
+            }
+            if let Err(e) = self.rollup(&topic).await {
+                tracing::warn!("Failed to roll up the heatmap of {}: {}", topic.id, e);
+            }
+        }
+        Ok(())
+    }
+
+    async fn rollup(&self, topic: &VotingTopic) -> Result<(), AppError> {
+        let config = self.reload.current().heatmap.clone();
+        let last = topic.close_time + chrono::Duration::hours(FINAL_ROLLUP_HOURS);
+        let existing = self
+            .get(&topic.id)
+            .await?
+            // counted in another time zone, the cells cannot be shifted
+            .filter(|heatmap| heatmap.timezone == config.timezone);
+        if existing
+            .as_ref()
+            .is_some_and(|heatmap| heatmap.rolled_up_until >= last)
+        {
+            return Ok(());
+        }
+
+        let until = (Utc::now() - chrono::Duration::seconds(config.lag_secs as i64)).min(last);
+        let mut stored = doc! { "$lt": boundary(until) };
+        if let Some(heatmap) = &existing {
+            stored.insert("$gte", boundary(heatmap.rolled_up_until));
+        }
+        let date = doc! { "$toDate": "$info.timestamp" };
+        let counted: Vec<Cell> = self
+            .mongo
+            .collection::<Document>(&format!("ballots_{}", topic.id))
+            .aggregate([
+                doc! { "$match": { "_id": stored } },
+                doc! { "$group": {
+                    "_id": {
+                        "weekday": { "$isoDayOfWeek": {
+                            "date": date.clone(),
+                            "timezone": &config.timezone,
+                        } },
+                        "hour": { "$hour": { "date": date, "timezone": &config.timezone } },
+                    },
+                    "count": { "$sum": 1_i64 },
+                } },
+            ])
+            .with_type::<Cell>()
+            .await?
+            .try_collect()
+            .await?;
+
+        let mut heatmap = existing.unwrap_or_else(|| VoteHeatmap {
+            topic_id: topic.id.clone(),
+            timezone: config.timezone.clone(),
+            cells: empty_cells(),
+            total: 0,
+            rolled_up_until: DateTime::UNIX_EPOCH,
+        });
+        heatmap.total += add_cells(&mut heatmap.cells, &counted);
+        heatmap.rolled_up_until = until;
+        self.heatmaps
+            .replace_one(doc! { "topic_id": &topic.id }, &heatmap)
+            .upsert(true)
+            .await?;
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn cell(weekday: i64, hour: i64, count: i64) -> Cell {
+        Cell {
+            key: CellKey { weekday, hour },
+            count,
+        }
+    }
+
+    #[test]
+    fn cells_are_added_by_weekday_and_hour() {
+        let mut cells = empty_cells();
+        let added = add_cells(
+            &mut cells,
+            &[cell(1, 0, 3), cell(7, 23, 2), cell(1, 0, 1), cell(8, 0, 9)],
+        );
+        assert_eq!(added, 6);
+        assert_eq!(cells[0][0], 4);
+        assert_eq!(cells[6][23], 2);
+
+        let at = DateTime::from_timestamp(1_750_000_000, 0).unwrap();
+        assert_eq!(
+            boundary(at).timestamp().timestamp_millis(),
+            1_750_000_000_000
+        );
+        assert_eq!(boundary(at).bytes()[4..], [0; 8]);
+    }
+}
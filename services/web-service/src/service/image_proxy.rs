@@ -0,0 +1,167 @@
+use std::{
+    io::Cursor,
+    path::{Path, PathBuf},
+    sync::Arc,
+    time::Duration,
+};
+
+use axum::body::Bytes;
+use dashmap::DashMap;
+use image::{ImageFormat, imageops::FilterType};
+use share::{config::ImageProxyConfig, http_client::HttpClient, models::api::ImageSize};
+use tokio::sync::Mutex;
+
+use crate::error::AppError;
+
+/// A cached image and its mime type.
+pub struct ProxiedImage {
+    pub bytes: Bytes,
+    pub content_type: &'static str,
+}
+
+/// Fetches upstream images once and serves them from a disk cache, resized
+/// variants are derived from the cached original.
+#[derive(Clone)]
+pub struct ImageProxyService {
+    http: HttpClient,
+    config: ImageProxyConfig,
+    /// Serialises fetches of the same image so a cold cache costs one
+    /// upstream request.
+    locks: Arc<DashMap<String, Arc<Mutex<()>>>>,
+}
+
+fn content_type(bytes: &[u8]) -> &'static str {
+    match image::guess_format(bytes) {
+        Ok(format) => format.to_mime_type(),
+        Err(_) => "application/octet-stream",
+    }
+}
+
+impl ImageProxyService {
+    pub fn new(config: ImageProxyConfig, http: HttpClient) -> Self {
+        Self {
+            http,
+            config,
+            locks: Arc::new(DashMap::new()),
+        }
+    }
+
+    pub fn max_age_secs(&self) -> u64 {
+        self.config.max_age_secs
+    }
+
+    fn cache_path(&self, key: &str, size: ImageSize) -> PathBuf {
+        Path::new(&self.config.cache_dir).join(format!("{key}_{}", size.as_str()))
+    }
+
+    /// `key` names the image in the cache and must be unique per `url`.
+    pub async fn get(
+        &self,
+        key: &str,
+        url: &str,
+        size: ImageSize,
+    ) -> Result<ProxiedImage, AppError> {
+        let path = self.cache_path(key, size);
+        if let Ok(bytes) = tokio::fs::read(&path).await {
+            return Ok(ProxiedImage {
+                content_type: content_type(&bytes),
+                bytes: bytes.into(),
+            });
+        }
+
+        let lock = self
+            .locks
+            .entry(key.to_string())
+            .or_insert_with(|| Arc::new(Mutex::new(())))
+            .clone();
+        let _guard = lock.lock().await;
+        // another request may have filled the cache while we waited
+        if let Ok(bytes) = tokio::fs::read(&path).await {
+            return Ok(ProxiedImage {
+                content_type: content_type(&bytes),
+                bytes: bytes.into(),
+            });
+        }
+
+        let original_path = self.cache_path(key, ImageSize::Original);
+        let original: Bytes = match tokio::fs::read(&original_path).await {
+            Ok(bytes) => bytes.into(),
+            Err(_) => {
+                let bytes = self.fetch(url).await?;
+                self.store(&original_path, &bytes).await;
+                bytes
+            }
+        };
+
+        let bytes = match size.max_edge() {
+            None => original,
+            Some(max_edge) => {
+                let resized = tokio::task::spawn_blocking(move || resize(&original, max_edge))
+                    .await
+                    .map_err(|e| AppError::InternalError(format!("resize task failed: {e}")))??;
+                self.store(&path, &resized).await;
+                resized
+            }
+        };
+
+        Ok(ProxiedImage {
+            content_type: content_type(&bytes),
+            bytes,
+        })
+    }
+
+    async fn fetch(&self, url: &str) -> Result<Bytes, AppError> {
+        let parsed = reqwest::Url::parse(url)
+            .map_err(|e| AppError::InternalError(format!("invalid image url {url}: {e}")))?;
+        let allowed = parsed
+            .host_str()
+            .is_some_and(|host| self.config.allowed_hosts.iter().any(|h| h == host));
+        if !allowed {
+            return Err(AppError::InternalError(format!(
+                "image host of {url} is not allowed"
+            )));
+        }
+
+        tracing::debug!("Fetching image {}", url);
+        let request = self
+            .http
+            .get(parsed)
+            .timeout(Duration::from_millis(self.config.timeout_ms));
+        let bytes = self
+            .http
+            .send(request)
+            .await?
+            .error_for_status()?
+            .bytes()
+            .await?;
+        Ok(bytes)
+    }
+
+    /// Failing to cache only costs another upstream fetch later.
+    async fn store(&self, path: &Path, bytes: &[u8]) {
+        let write = async {
+            if let Some(parent) = path.parent() {
+                tokio::fs::create_dir_all(parent).await?;
+            }
+            let tmp = path.with_extension("tmp");
+            tokio::fs::write(&tmp, bytes).await?;
+            tokio::fs::rename(&tmp, path).await
+        };
+        if let Err(e) = write.await {
+            tracing::warn!("Failed to cache image {}: {}", path.display(), e);
+        }
+    }
+}
+
+fn resize(original: &[u8], max_edge: u32) -> Result<Bytes, AppError> {
+    let image = image::load_from_memory(original)?;
+    let resized = if image.width().max(image.height()) > max_edge {
+        image.resize(max_edge, max_edge, FilterType::Lanczos3)
+    } else {
+        image
+    };
+
+    let mut buf = Cursor::new(Vec::new());
+    resized.write_to(&mut buf, ImageFormat::Png)?;
+    Ok(buf.into_inner().into())
+}
@@ -0,0 +1,140 @@
+use std::{sync::Arc, time::Duration};
+
+use parking_lot::RwLock;
+use redis::AsyncCommands as _;
+use share::{config::NotificationKind, models::api::MaintenanceStatus, reload::ConfigWatch};
+
+use crate::{error::AppError, notify::Notification, service::NotificationService};
+
+/// Set by the admin endpoint, shared by every instance.
+const MAINTENANCE_KEY: &str = "system:maintenance";
+/// How long a switch made on another instance takes to arrive here.
+const POLL_INTERVAL: Duration = Duration::from_secs(2);
+
+/// Whether writes are paused. The config switch covers planned maintenance
+/// that starts with a deploy, the redis override lets an operator pause
+/// voting on every instance at once without one.
+#[derive(Clone)]
+pub struct MaintenanceService {
+    redis: redis::aio::MultiplexedConnection,
+    config: ConfigWatch,
+    notifications: NotificationService,
+    /// The last override read from redis, the middleware must not wait on it.
+    override_status: Arc<RwLock<Option<MaintenanceStatus>>>,
+}
+
+impl MaintenanceService {
+    pub fn new(
+        redis: redis::aio::MultiplexedConnection,
+        config: ConfigWatch,
+        notifications: NotificationService,
+    ) -> Self {
+        let service = Self {
+            redis,
+            config,
+            notifications,
+            override_status: Arc::new(RwLock::new(None)),
+        };
+
+        service
+    }
+
+    pub fn status(&self) -> MaintenanceStatus {
+        if let Some(status) = self.override_status.read().as_ref().filter(|s| s.enabled) {
+            return status.clone();
+        }
+
+        let config = self.config.current();
+        MaintenanceStatus {
+            enabled: config.maintenance.enabled,
+            message: config.maintenance.message.clone(),
+            since: None,
+        }
+    }
+
+    /// Whether `path` is paused, with the version prefix already stripped.
+    pub fn blocks(&self, path: &str) -> Option<MaintenanceStatus> {
+        let status = self.status();
+        if !status.enabled {
+            return None;
+        }
+
+        let config = self.config.current();
+        config
+            .maintenance
+            .write_paths
+            .iter()
+            .any(|write_path| path == write_path)
+            .then_some(status)
+    }
+
+    /// Switching off only clears the override, maintenance enabled in the
+    /// config stays on until the config says otherwise.
+    pub async fn set(&self, enabled: bool, message: String) -> Result<MaintenanceStatus, AppError> {
+        let mut conn = self.redis.clone();
+        let status = MaintenanceStatus {
+            enabled,
+            message,
+            since: enabled.then(|| chrono::Utc::now().timestamp()),
+        };
+
+        if enabled {
+            let _: () = conn
+                .set(MAINTENANCE_KEY, serde_json::to_string(&status)?)
+                .await?;
+        } else {
+            let _: () = conn.del(MAINTENANCE_KEY).await?;
+        }
+        *self.override_status.write() = enabled.then_some(status.clone());
+
+        let (subject, body) = match enabled {
+            true => ("Maintenance started", status.message),
+            false => ("Maintenance ended", String::new()),
+        };
+        self.notifications
+            .notify(Notification::new(
+                NotificationKind::AdminAlert,
+                subject.to_string(),
+                body,
+            ))
+            .await;
+
+        Ok(self.status())
+    }
+
+    /// Picks up changes made by the other instances, run as a background
+    /// job.
+    pub async fn poll(self) {
+        let mut interval = tokio::time::interval(POLL_INTERVAL);
+        loop {
+            interval.tick().await;
+
+            let mut conn = self.redis.clone();
+            let value: Option<String> = match conn.get(MAINTENANCE_KEY).await {
+                Ok(value) => value,
+                Err(e) => {
+                    // keep the last known state rather than reopening writes
+                    tracing::debug!("failed to read maintenance status: {}", e);
+                    continue;
+                }
+            };
+
+            let status = match value.map(|v| serde_json::from_str::<MaintenanceStatus>(&v)) {
+                Some(Ok(status)) => Some(status),
+                Some(Err(e)) => {
+                    tracing::warn!("invalid maintenance status in redis: {}", e);
+                    None
+                }
+                None => None,
+            };
+
+            let mut current = self.override_status.write();
+            let was = current.as_ref().is_some_and(|s| s.enabled);
+            let is = status.as_ref().is_some_and(|s| s.enabled);
+            if was != is {
+                tracing::warn!(enabled = is, "maintenance override changed");
+            }
+            *current = status;
+        }
+    }
+}
@@ -0,0 +1,209 @@
+use std::collections::BTreeMap;
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{
+    Collection,
+    bson::{Document, doc},
+};
+use share::{
+    merkle::{self, Hash},
+    models::{
+        api::{ApiMsg, MerkleProofStep, ResultsMerkleProofResponse},
+        database::{CreateTopicStatus, MerkleCommitment, VotingTopic},
+    },
+};
+
+use crate::{error::AppError, service::TopicService, storage::Storage};
+
+/// How long after its close a topic is still committed, ballots cast last
+/// may still be audited.
+const FINAL_COMMIT_HOURS: i64 = 1;
+
+/// The bytes of the leaf of a stored ballot and its multiplier. The leaf
+/// holds the ballot id, the choices and the multiplier, nothing about the
+/// voter, as json with sorted keys.
+fn leaf(ballot: &Document) -> Option<(Vec<u8>, i64)> {
+    let ballot_id = ballot
+        .get_document("info")
+        .ok()?
+        .get_str("ballot_id")
+        .ok()?;
+    let multiplier = ballot.get_i32("multiplier").ok()?;
+
+    let mut fields = BTreeMap::new();
+    for (key, value) in ballot {
+        if !matches!(key.as_str(), "_id" | "info" | "audit") {
+            fields.insert(key.as_str(), value.clone().into_relaxed_extjson());
+        }
+    }
+    fields.insert("ballot_id", ballot_id.into());
+
+    Some((serde_json::to_vec(&fields).ok()?, multiplier.into()))
+}
+
+fn accepted() -> Document {
+    doc! { "audit": { "$ne": "pending" }, "multiplier": { "$gt": 0 } }
+}
+
+/// Commits merkle roots over the accepted ballots, run by the scheduler, and
+/// proves single ballots against the latest root. Only the leaf hashes of
+/// the latest root are kept in the storage, older commitments stay listed.
+#[derive(Clone)]
+pub struct MerkleService {
+    mongo: mongodb::Database,
+    commitments: Collection<MerkleCommitment>,
+    storage: Storage,
+    topic_service: TopicService,
+}
+
+impl MerkleService {
+    pub fn new(mongo: mongodb::Database, storage: Storage, topic_service: TopicService) -> Self {
+        Self {
+            commitments: mongo.collection::<MerkleCommitment>("merkle_commitments"),
+            mongo,
+            storage,
+            topic_service,
+        }
+    }
+
+    pub async fn latest(&self, topic_id: &str) -> Result<Option<MerkleCommitment>, AppError> {
+        Ok(self
+            .commitments
+            .find_one(doc! { "topic_id": topic_id })
+            .sort(doc! { "committed_at": -1 })
+            .await?)
+    }
+
+    pub fn leaves_url(&self, commitment: &MerkleCommitment) -> String {
+        self.storage.url(&commitment.key)
+    }
+
+    /// Commits the open topics and the ones closed within
+    /// [`FINAL_COMMIT_HOURS`].
+    pub async fn commit_all(&self) -> Result<(), AppError> {
+        let now = Utc::now();
+        for topic in self.topic_service.cached_topics() {
+            let committed = topic.is_active
+                && matches!(topic.status, CreateTopicStatus::Approved(_))
+                && topic.open_time <= now
+                && now <= topic.close_time + chrono::Duration::hours(FINAL_COMMIT_HOURS);
+            if !committed {
+                continue;
+            }
+            if let Err(e) = self.commit(&topic).await {
+                tracing::warn!("Failed to commit the merkle root of {}: {}", topic.id, e);
+            }
+        }
+        Ok(())
+    }
+
+    /// A new commitment unless the root is the same as the latest one.
+    async fn commit(&self, topic: &VotingTopic) -> Result<(), AppError> {
+        let mut cursor = self
+            .mongo
+            .collection::<Document>(&format!("ballots_{}", topic.id))
+            .find(accepted())
+            .sort(doc! { "info.ballot_id": 1 })
+            .await?;
+        let mut leaves: Vec<Hash> = Vec::new();
+        let mut total_multiplier = 0;
+        while let Some(ballot) = cursor.try_next().await? {
+            if let Some((content, multiplier)) = leaf(&ballot) {
+                leaves.push(merkle::leaf_hash(&content));
+                total_multiplier += multiplier;
+            }
+        }
+
+        let root = hex::encode(merkle::root(&leaves));
+        let previous = self.latest(&topic.id).await?;
+        if previous
+            .as_ref()
+            .is_some_and(|previous| previous.root == root)
+        {
+            return Ok(());
+        }
+
+        let committed_at = Utc::now();
+        let commitment = MerkleCommitment {
+            topic_id: topic.id.clone(),
+            root,
+            leaves: leaves.len() as u64,
+            total_multiplier,
+            key: format!(
+                "merkle/{}-{}.bin",
+                topic.id,
+                committed_at.timestamp_millis()
+            ),
+            committed_at,
+        };
+        self.storage.put(&commitment.key, &leaves.concat()).await?;
+        self.commitments.insert_one(&commitment).await?;
+
+        if let Some(previous) = previous
+            && let Err(e) = self.storage.delete(&previous.key).await
+        {
+            tracing::warn!("Failed to delete merkle leaves {}: {}", previous.key, e);
+        }
+        tracing::info!(
+            "committed merkle root {} over {} ballots of {}",
+            commitment.root,
+            commitment.leaves,
+            topic.id
+        );
+        Ok(())
+    }
+
+    /// The inclusion proof of the ballot in the latest root of its topic.
+    /// The outer error is reserved for database and storage failures.
+    pub async fn proof(
+        &self,
+        topic_id: &str,
+        ballot_id: &str,
+    ) -> Result<Result<ResultsMerkleProofResponse, ApiMsg>, AppError> {
+        let Some(commitment) = self.latest(topic_id).await? else {
+            return Ok(Err(ApiMsg::MerkleCommitmentNotFound));
+        };
+        let Some(hashes) = self.storage.get(&commitment.key).await? else {
+            return Ok(Err(ApiMsg::MerkleCommitmentNotFound));
+        };
+
+        let mut filter = accepted();
+        filter.insert("info.ballot_id", ballot_id);
+        let ballot = self
+            .mongo
+            .collection::<Document>(&format!("ballots_{topic_id}"))
+            .find_one(filter)
+            .await?;
+        let Some((content, _)) = ballot.as_ref().and_then(leaf) else {
+            return Ok(Err(ApiMsg::BallotNotCommitted));
+        };
+
+        // a ballot accepted after the commitment, or changed since, is not
+        // among the leaves
+        let leaves: Vec<Hash> = hashes
+            .chunks_exact(32)
+            .map(|hash| hash.try_into().expect("chunks are 32 bytes"))
+            .collect();
+        let leaf_hash = merkle::leaf_hash(&content);
+        let Some(index) = leaves.iter().position(|hash| *hash == leaf_hash) else {
+            return Ok(Err(ApiMsg::BallotNotCommitted));
+        };
+
+        let path = merkle::proof(&leaves, index)
+            .into_iter()
+            .map(|(side, hash)| MerkleProofStep {
+                side,
+                hash: hex::encode(hash),
+            })
+            .collect();
+        Ok(Ok(ResultsMerkleProofResponse {
+            leaf: String::from_utf8(content).expect("json is utf-8"),
+            leaf_hash: hex::encode(leaf_hash),
+            index: index as u64,
+            path,
+            root: commitment.root,
+            committed_at: commitment.committed_at,
+        }))
+    }
+}
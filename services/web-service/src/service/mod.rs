@@ -1,3 +1,67 @@
+mod affinity;
+mod announcement;
+mod api_key;
+mod audit_log;
+mod bookmark;
+mod comment;
+mod consent;
+mod dataset;
+mod digest;
+mod erasure;
+mod heatmap;
+mod image_proxy;
+mod maintenance;
+mod merkle;
+mod notification;
+mod operator;
+mod option_image;
+mod presence;
+mod region;
+mod report;
+mod results_archive;
+mod results_snapshot;
+mod retention;
+mod scheduled_action;
+mod share_card;
 mod topic;
+mod topic_phase;
+mod topic_sync;
+mod trend;
+mod voter_export;
+mod webhook;
+mod word_filter;
 
+pub use affinity::AffinityService;
+pub use announcement::AnnouncementService;
+pub use api_key::{
+    ApiKeyCheck, ApiKeyService, derive_signing_secret, hash_api_key, verify_request,
+};
+pub use audit_log::AuditLogService;
+pub use bookmark::BookmarkService;
+pub use comment::CommentService;
+pub use consent::ConsentService;
+pub use dataset::DatasetService;
+pub use digest::DigestService;
+pub use erasure::ErasureService;
+pub use heatmap::HeatmapService;
+pub use image_proxy::ImageProxyService;
+pub use maintenance::MaintenanceService;
+pub use merkle::MerkleService;
+pub use notification::NotificationService;
+pub use operator::{AliasAdded, OperatorService};
+pub use option_image::OptionImageService;
+pub use presence::{PresenceGuard, PresenceService};
+pub use region::RegionService;
+pub use report::ReportService;
+pub use results_archive::ResultsArchiveService;
+pub use results_snapshot::{ResultsSnapshot, ResultsSnapshotService};
+pub use retention::RetentionService;
+pub use scheduled_action::ScheduledActions;
+pub use share_card::{ShareCard, ShareCardRow, ShareCardService};
 pub use topic::TopicService;
+pub use topic_phase::TopicPhaseWatcher;
+pub use topic_sync::TopicSync;
+pub use trend::TrendService;
+pub use voter_export::VoterExportService;
+pub use webhook::WebhookService;
+pub use word_filter::{FilterVerdict, WordFilterService};
@@ -0,0 +1,117 @@
+use std::{sync::Arc, time::Duration};
+
+use serde::{Deserialize, Serialize};
+use share::{
+    config::{NotifyChannelConfig, NotifyConfig},
+    http_client::HttpClient,
+    models::database::TaskPriority,
+};
+
+use crate::{
+    error::AppError,
+    notify::{Notification, Notifier},
+    queue::{RetryPolicy, TaskQueue},
+};
+
+/// Kind of the queue tasks sending one notification to one channel.
+const NOTIFICATION_TASK: &str = "notification";
+
+/// Payload of a [`NOTIFICATION_TASK`].
+#[derive(Debug, Serialize, Deserialize)]
+struct NotificationTask {
+    channel: String,
+    notification: Notification,
+}
+
+struct Channel {
+    config: NotifyChannelConfig,
+    notifier: Notifier,
+}
+
+/// Fans notifications out to the channels of `[notify]`, one queue task per
+/// channel so a failing mail server doesn't hold back the webhooks.
+#[derive(Clone)]
+pub struct NotificationService {
+    channels: Arc<Vec<Channel>>,
+    queue: TaskQueue,
+}
+
+impl NotificationService {
+    pub fn new(
+        config: &NotifyConfig,
+        http: HttpClient,
+        queue: TaskQueue,
+    ) -> Result<Self, AppError> {
+        let timeout = Duration::from_millis(config.timeout_ms);
+        let channels = config
+            .channels
+            .iter()
+            .map(|channel| {
+                Ok(Channel {
+                    notifier: Notifier::from_config(&channel.target, &http, timeout)?,
+                    config: channel.clone(),
+                })
+            })
+            .collect::<Result<Vec<_>, AppError>>()?;
+
+        let service = Self {
+            channels: Arc::new(channels),
+            queue,
+        };
+        let policy = RetryPolicy {
+            max_attempts: config.max_attempts.max(1),
+            initial_backoff: Duration::from_millis(config.initial_backoff_ms),
+            max_backoff: Duration::from_millis(config.max_backoff_ms),
+        };
+        service
+            .queue
+            .register(NOTIFICATION_TASK, TaskPriority::Normal, policy, {
+                let service = service.clone();
+                move |task: NotificationTask, _| {
+                    let service = service.clone();
+                    async move { service.send(task).await }
+                }
+            });
+
+        Ok(service)
+    }
+
+    /// Queues the notification for every channel taking its kind. Failures
+    /// are logged, a notification never fails what caused it.
+    pub async fn notify(&self, notification: Notification) {
+        for channel in self.channels.iter().filter(|channel| {
+            channel.config.accepts(notification.kind)
+                && (notification.to.is_none() || channel.notifier.is_email())
+        }) {
+            let task = NotificationTask {
+                channel: channel.config.name.clone(),
+                notification: notification.clone(),
+            };
+            if let Err(e) = self.queue.enqueue(NOTIFICATION_TASK, &task).await {
+                tracing::warn!(
+                    "Failed to queue notification for {}: {}",
+                    channel.config.name,
+                    e
+                );
+            }
+        }
+    }
+
+    /// One attempt of a [`NOTIFICATION_TASK`]. A channel removed from the
+    /// config since leaves the task dead rather than dropping it silently.
+    async fn send(&self, task: NotificationTask) -> Result<(), String> {
+        let Some(channel) = self
+            .channels
+            .iter()
+            .find(|channel| channel.config.name == task.channel)
+        else {
+            return Err(format!("unknown notification channel {}", task.channel));
+        };
+
+        channel
+            .notifier
+            .send(&task.notification)
+            .await
+            .map_err(|e| e.to_string())
+    }
+}
@@ -0,0 +1,454 @@
+use std::{collections::HashMap, sync::Arc, time::Duration};
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{
+    Collection,
+    bson::{self, doc},
+};
+use parking_lot::RwLock;
+use share::{
+    config::OperatorSyncConfig,
+    http_client::HttpClient,
+    models::{
+        api::CharacterPortrait,
+        database::{AuditLogEntry, CreateTopicStatus, Operator, OperatorAlias},
+        excel::{
+            CharacterData, CharacterInfo, Language, OperatorNames, character_numeric_id,
+            normalize_name,
+        },
+        live::TopicChangeKind,
+    },
+};
+
+use crate::{
+    error::AppError,
+    service::{AuditLogService, TopicService, TopicSync},
+    utils,
+};
+
+const AUDIT_ACTOR: &str = "operator-sync";
+const SEARCH_DEFAULT_LIMIT: usize = 20;
+const SEARCH_MAX_LIMIT: usize = 100;
+
+/// Outcome of [`OperatorService::add_alias`].
+pub enum AliasAdded {
+    Added(OperatorAlias),
+    UnknownOperator,
+    /// The alias already names another operator, by alias or by name.
+    Conflict(i32),
+}
+
+pub struct SyncReport {
+    pub synced: usize,
+    /// Operators that were not stored before this sync.
+    pub added: Vec<Operator>,
+}
+
+#[derive(Clone)]
+pub struct OperatorService {
+    operators: Collection<Operator>,
+    aliases: Collection<OperatorAlias>,
+    http: HttpClient,
+    config: OperatorSyncConfig,
+    character_infos: Arc<RwLock<Arc<Vec<CharacterInfo>>>>,
+    names: Arc<RwLock<Arc<HashMap<i32, OperatorNames>>>>,
+    /// Normalized alias to operator id.
+    alias_map: Arc<RwLock<Arc<HashMap<String, i32>>>>,
+}
+
+impl OperatorService {
+    pub fn new(mongo: mongodb::Database, config: OperatorSyncConfig, http: HttpClient) -> Self {
+        Self {
+            operators: mongo.collection::<Operator>("operators"),
+            aliases: mongo.collection::<OperatorAlias>("operator_aliases"),
+            http,
+            config,
+            character_infos: Arc::new(RwLock::new(Arc::new(Vec::new()))),
+            names: Arc::new(RwLock::new(Arc::new(HashMap::new()))),
+            alias_map: Arc::new(RwLock::new(Arc::new(HashMap::new()))),
+        }
+    }
+
+    pub async fn list(&self) -> Result<Vec<Operator>, AppError> {
+        let cursor = self.operators.find(doc! {}).sort(doc! { "id": 1 }).await?;
+        Ok(cursor.try_collect().await?)
+    }
+
+    /// Operators as used to build candidate pools.
+    pub fn character_infos(&self) -> Arc<Vec<CharacterInfo>> {
+        self.character_infos.read().clone()
+    }
+
+    /// Replaces the candidate pool operators, names default to the CN name
+    /// when `names` lacks an operator.
+    pub fn set_character_infos(
+        &self,
+        infos: Vec<CharacterInfo>,
+        mut names: HashMap<i32, OperatorNames>,
+    ) {
+        for info in &infos {
+            names
+                .entry(info.id)
+                .or_insert_with(|| OperatorNames::new(info.name.clone(), None, None));
+        }
+
+        *self.character_infos.write() = Arc::new(infos);
+        *self.names.write() = Arc::new(names);
+    }
+
+    /// Reloads the candidate pool operators from the synced collection, an
+    /// empty collection keeps the current ones. Aliases are reloaded either way.
+    pub async fn refresh(&self) -> Result<usize, AppError> {
+        self.load_aliases().await?;
+
+        let operators = self.list().await?;
+        let count = operators.len();
+        if count > 0 {
+            let infos = operators.iter().map(CharacterInfo::from).collect();
+            let names = operators
+                .into_iter()
+                .filter(|operator| !operator.names.cn.is_empty())
+                .map(|operator| (operator.id, operator.names))
+                .collect();
+            self.set_character_infos(infos, names);
+        }
+
+        Ok(count)
+    }
+
+    pub fn localized_name(&self, id: i32, language: Language) -> Option<String> {
+        self.names
+            .read()
+            .get(&id)
+            .map(|names| names.localized(language).to_string())
+    }
+
+    async fn load_aliases(&self) -> Result<(), AppError> {
+        let cursor = self.aliases.find(doc! {}).await?;
+        let aliases: Vec<OperatorAlias> = cursor.try_collect().await?;
+        *self.alias_map.write() = Arc::new(
+            aliases
+                .into_iter()
+                .map(|alias| (alias.alias, alias.operator_id))
+                .collect(),
+        );
+        Ok(())
+    }
+
+    pub fn aliases_of(&self, id: i32) -> Vec<String> {
+        let mut aliases: Vec<String> = self
+            .alias_map
+            .read()
+            .iter()
+            .filter(|(_, operator_id)| **operator_id == id)
+            .map(|(alias, _)| alias.clone())
+            .collect();
+        aliases.sort_unstable();
+        aliases
+    }
+
+    /// Resolves a name, its full pinyin or an alias to the one operator it
+    /// refers to. Used wherever operators are given by name, e.g. imports.
+    pub fn resolve(&self, name: &str) -> Option<i32> {
+        let name = normalize_name(name);
+        if name.is_empty() {
+            return None;
+        }
+
+        if let Some(id) = self.alias_map.read().get(&name) {
+            return Some(*id);
+        }
+        let names = self.names.read().clone();
+        let mut matched = names.iter().filter(|(_, names)| names.is_named(&name));
+        match (matched.next(), matched.next()) {
+            (Some((id, _)), None) => Some(*id),
+            // homophones such as 夜烟 and 夜莺 share nothing but the pinyin
+            _ => None,
+        }
+    }
+
+    pub async fn list_aliases(&self, id: Option<i32>) -> Result<Vec<OperatorAlias>, AppError> {
+        let filter = match id {
+            Some(id) => doc! { "operator_id": id },
+            None => doc! {},
+        };
+        let cursor = self
+            .aliases
+            .find(filter)
+            .sort(doc! { "operator_id": 1, "alias": 1 })
+            .await?;
+        Ok(cursor.try_collect().await?)
+    }
+
+    /// Adding an alias the operator already has is a no-op.
+    pub async fn add_alias(
+        &self,
+        id: i32,
+        alias: &str,
+        created_by: &str,
+    ) -> Result<AliasAdded, AppError> {
+        if !self.names.read().contains_key(&id) {
+            return Ok(AliasAdded::UnknownOperator);
+        }
+        let alias = normalize_name(alias);
+        if let Some(other) = self.resolve(&alias)
+            && other != id
+        {
+            return Ok(AliasAdded::Conflict(other));
+        }
+
+        let entry = OperatorAlias {
+            alias,
+            operator_id: id,
+            created_by: created_by.to_string(),
+            created_at: Utc::now(),
+        };
+        self.aliases
+            .replace_one(doc! { "alias": &entry.alias }, &entry)
+            .upsert(true)
+            .await?;
+        self.load_aliases().await?;
+
+        Ok(AliasAdded::Added(entry))
+    }
+
+    pub async fn remove_alias(&self, alias: &str) -> Result<Option<OperatorAlias>, AppError> {
+        let removed = self
+            .aliases
+            .find_one_and_delete(doc! { "alias": normalize_name(alias) })
+            .await?;
+        if removed.is_some() {
+            self.load_aliases().await?;
+        }
+        Ok(removed)
+    }
+
+    /// Operators whose CN, EN or JP name, pinyin or one of whose aliases
+    /// contains `query`, ordered by id.
+    pub fn search(&self, query: &str, limit: Option<usize>) -> Vec<(i32, OperatorNames)> {
+        let query = query.trim().to_lowercase();
+        if query.is_empty() {
+            return Vec::new();
+        }
+        let limit = limit
+            .unwrap_or(SEARCH_DEFAULT_LIMIT)
+            .clamp(1, SEARCH_MAX_LIMIT);
+
+        let normalized = normalize_name(&query);
+        let aliased: Vec<i32> = self
+            .alias_map
+            .read()
+            .iter()
+            .filter(|(alias, _)| !normalized.is_empty() && alias.contains(&normalized))
+            .map(|(_, id)| *id)
+            .collect();
+        let names = self.names.read().clone();
+        let mut matches: Vec<(i32, OperatorNames)> = names
+            .iter()
+            .filter(|(id, names)| names.matches(&query) || aliased.contains(id))
+            .map(|(id, names)| (*id, names.clone()))
+            .collect();
+        matches.sort_unstable_by_key(|(id, _)| *id);
+        matches.truncate(limit);
+        matches
+    }
+
+    async fn fetch_table(&self, url: &str) -> Result<HashMap<String, CharacterData>, AppError> {
+        let table = self
+            .http
+            .send(self.http.get(url))
+            .await?
+            .error_for_status()?
+            .json::<HashMap<String, CharacterData>>()
+            .await?;
+        Ok(table)
+    }
+
+    /// Names of a localized character table keyed by character key, missing
+    /// tables only cost the translations.
+    async fn fetch_names(&self, url: &str) -> HashMap<String, String> {
+        if url.is_empty() {
+            return HashMap::new();
+        }
+
+        match self.fetch_table(url).await {
+            Ok(table) => table
+                .into_iter()
+                .map(|(key, data)| (key, data.name))
+                .collect(),
+            Err(e) => {
+                tracing::warn!("Failed to fetch localized names from {}: {}", url, e);
+                HashMap::new()
+            }
+        }
+    }
+
+    /// Upserts every operator of the game data. Hand maintained fields such
+    /// as the release date are left untouched.
+    pub async fn sync(
+        &self,
+        portraits: &HashMap<i32, CharacterPortrait>,
+    ) -> Result<SyncReport, AppError> {
+        let table = if self.config.source_url.is_empty() {
+            utils::load_character_table()?
+        } else {
+            self.fetch_table(&self.config.source_url).await?
+        };
+        let mut en_names = self.fetch_names(&self.config.en_source_url).await;
+        let mut jp_names = self.fetch_names(&self.config.jp_source_url).await;
+        let synced_at = Utc::now();
+        // everything is new on the first import, none of it was just released
+        let first_import = self.operators.estimated_document_count().await? == 0;
+
+        let mut report = SyncReport {
+            synced: 0,
+            added: Vec::new(),
+        };
+        for (key, data) in table {
+            let Some(id) = character_numeric_id(&key) else {
+                continue;
+            };
+
+            let names = OperatorNames::new(
+                data.name.clone(),
+                en_names.remove(&key),
+                jp_names.remove(&key),
+            );
+            let operator = Operator {
+                id,
+                faction: data.faction().map(str::to_string),
+                char_key: key,
+                name: data.name,
+                names,
+                appellation: data.appellation,
+                rarity: data.rarity,
+                profession: data.profession,
+                sub_profession_id: data.sub_profession_id,
+                release_date: None,
+                portraits: portraits
+                    .get(&id)
+                    .map(|portrait| portrait.avatar.clone())
+                    .unwrap_or_default(),
+                is_not_obtainable: data.is_not_obtainable,
+                synced_at,
+            };
+
+            let mut fields = bson::to_document(&operator)?;
+            fields.remove("release_date");
+            if operator.portraits.is_empty() {
+                fields.remove("portraits");
+            }
+
+            let result = self
+                .operators
+                .update_one(
+                    doc! { "id": id },
+                    doc! {
+                        "$set": fields,
+                        "$setOnInsert": { "release_date": bson::Bson::Null },
+                    },
+                )
+                .upsert(true)
+                .await?;
+            if result.upserted_id.is_some() && !first_import {
+                report.added.push(operator);
+            }
+            report.synced += 1;
+        }
+
+        tracing::info!(
+            "Synced {} operators, {} new",
+            report.synced,
+            report.added.len()
+        );
+        Ok(report)
+    }
+
+    /// Adds newly released operators to the ongoing topics of the configured
+    /// types. An operator joins a topic when the topic's candidate pool rule
+    /// selects it, so custom pools only pick up ids listed in advance.
+    pub async fn register_new_operators(
+        &self,
+        added: &[Operator],
+        topic_service: &TopicService,
+        topic_sync: &TopicSync,
+        audit_log: &AuditLogService,
+    ) -> Result<(), AppError> {
+        if !self.config.auto_register || added.is_empty() {
+            return Ok(());
+        }
+
+        let added_infos: Vec<CharacterInfo> = added.iter().map(CharacterInfo::from).collect();
+        for topic in topic_service.cached_topics() {
+            if !topic.is_topic_active()
+                || !matches!(topic.status, CreateTopicStatus::Approved(_))
+                || !self
+                    .config
+                    .auto_register_topic_types
+                    .contains(&topic.topic_type)
+            {
+                continue;
+            }
+
+            let matched = topic.generate_pool(&added_infos);
+            if matched.is_empty() {
+                continue;
+            }
+
+            let names: Vec<&str> = added_infos
+                .iter()
+                .filter(|info| matched.contains(&info.id))
+                .map(|info| info.name.as_str())
+                .collect();
+            audit_log
+                .record(AuditLogEntry::new(
+                    Some(topic.id.clone()),
+                    AUDIT_ACTOR,
+                    "operators_registered",
+                    format!("added {} ({:?})", names.join(", "), matched),
+                ))
+                .await?;
+            topic_sync
+                .notify(&topic.id, TopicChangeKind::PoolChanged)
+                .await;
+        }
+
+        Ok(())
+    }
+
+    pub async fn run(
+        self,
+        portraits: HashMap<i32, CharacterPortrait>,
+        topic_service: TopicService,
+        topic_sync: TopicSync,
+        audit_log: AuditLogService,
+    ) {
+        let mut interval =
+            tokio::time::interval(Duration::from_secs(self.config.interval_secs.max(60)));
+        loop {
+            interval.tick().await;
+            let report = match self.sync(&portraits).await {
+                Ok(report) => report,
+                Err(e) => {
+                    tracing::warn!("Failed to sync operators: {}", e);
+                    continue;
+                }
+            };
+            if report.added.is_empty() {
+                continue;
+            }
+
+            if let Err(e) = self.refresh().await {
+                tracing::warn!("Failed to reload operators: {}", e);
+                continue;
+            }
+            if let Err(e) = self
+                .register_new_operators(&report.added, &topic_service, &topic_sync, &audit_log)
+                .await
+            {
+                tracing::warn!("Failed to register new operators: {}", e);
+            }
+        }
+    }
+}
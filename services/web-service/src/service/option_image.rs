@@ -0,0 +1,160 @@
+use std::{collections::HashMap, io::Cursor};
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use image::{ImageFormat, imageops::FilterType};
+use mongodb::{Collection, bson::doc};
+use share::{config::StorageConfig, models::database::OptionImage};
+
+use crate::{error::AppError, storage::Storage};
+
+/// Longest edge an upload may have before it is rejected outright, protects
+/// the decoder from decompression bombs.
+const MAX_SOURCE_EDGE: u32 = 8192;
+
+#[derive(Debug, thiserror::Error)]
+pub enum OptionImageError {
+    #[error("image is larger than {0} bytes")]
+    TooLarge(usize),
+    #[error("only png, jpeg and webp images are accepted")]
+    UnsupportedFormat,
+    #[error("image dimensions exceed {MAX_SOURCE_EDGE}px")]
+    TooManyPixels,
+    #[error("image could not be decoded: {0}")]
+    Decode(#[from] image::ImageError),
+}
+
+#[derive(Clone)]
+pub struct OptionImageService {
+    images: Collection<OptionImage>,
+    storage: Storage,
+    max_upload_bytes: usize,
+    max_image_edge: u32,
+}
+
+/// Validates the upload and re-encodes it as a PNG no larger than
+/// `max_edge`, which also strips any metadata.
+fn process(bytes: &[u8], max_edge: u32) -> Result<Vec<u8>, OptionImageError> {
+    let format = image::guess_format(bytes).map_err(|_| OptionImageError::UnsupportedFormat)?;
+    if !matches!(
+        format,
+        ImageFormat::Png | ImageFormat::Jpeg | ImageFormat::WebP
+    ) {
+        return Err(OptionImageError::UnsupportedFormat);
+    }
+
+    let mut reader = image::ImageReader::with_format(Cursor::new(bytes), format);
+    let mut limits = image::Limits::default();
+    limits.max_image_width = Some(MAX_SOURCE_EDGE);
+    limits.max_image_height = Some(MAX_SOURCE_EDGE);
+    reader.limits(limits);
+    let image = reader.decode().map_err(|e| match e {
+        image::ImageError::Limits(_) => OptionImageError::TooManyPixels,
+        e => OptionImageError::Decode(e),
+    })?;
+
+    let image = if image.width().max(image.height()) > max_edge {
+        image.resize(max_edge, max_edge, FilterType::Lanczos3)
+    } else {
+        image
+    };
+
+    let mut buf = Cursor::new(Vec::new());
+    image.write_to(&mut buf, ImageFormat::Png)?;
+    Ok(buf.into_inner())
+}
+
+impl OptionImageService {
+    pub fn new(mongo: mongodb::Database, storage: Storage, config: &StorageConfig) -> Self {
+        Self {
+            images: mongo.collection::<OptionImage>("option_images"),
+            storage,
+            max_upload_bytes: config.max_upload_bytes,
+            max_image_edge: config.max_image_edge,
+        }
+    }
+
+    pub fn max_upload_bytes(&self) -> usize {
+        self.max_upload_bytes
+    }
+
+    /// Stores the image and replaces any previous one of the option, the
+    /// outer error is reserved for storage failures.
+    pub async fn upload(
+        &self,
+        topic_id: &str,
+        option_id: i32,
+        bytes: Vec<u8>,
+        uploaded_by: &str,
+    ) -> Result<Result<String, OptionImageError>, AppError> {
+        if bytes.len() > self.max_upload_bytes {
+            return Ok(Err(OptionImageError::TooLarge(self.max_upload_bytes)));
+        }
+
+        let max_edge = self.max_image_edge;
+        let processed = tokio::task::spawn_blocking(move || process(&bytes, max_edge))
+            .await
+            .map_err(|e| AppError::InternalError(format!("image task failed: {e}")))?;
+        let processed = match processed {
+            Ok(processed) => processed,
+            Err(e) => return Ok(Err(e)),
+        };
+
+        // a fresh key per upload keeps long-lived client caches correct
+        let key = format!(
+            "topics/{topic_id}/options/{option_id}-{}.png",
+            Utc::now().timestamp_millis()
+        );
+        self.storage.put(&key, &processed).await?;
+
+        let previous = self
+            .images
+            .find_one_and_replace(
+                doc! { "topic_id": topic_id, "option_id": option_id },
+                OptionImage {
+                    topic_id: topic_id.to_string(),
+                    option_id,
+                    key: key.clone(),
+                    uploaded_by: uploaded_by.to_string(),
+                    created_at: Utc::now(),
+                },
+            )
+            .upsert(true)
+            .await?;
+        if let Some(previous) = previous
+            && let Err(e) = self.storage.delete(&previous.key).await
+        {
+            tracing::warn!(
+                "Failed to delete replaced option image {}: {}",
+                previous.key,
+                e
+            );
+        }
+
+        Ok(Ok(self.storage.url(&key)))
+    }
+
+    pub async fn delete(&self, topic_id: &str, option_id: i32) -> Result<bool, AppError> {
+        let Some(image) = self
+            .images
+            .find_one_and_delete(doc! { "topic_id": topic_id, "option_id": option_id })
+            .await?
+        else {
+            return Ok(false);
+        };
+
+        self.storage.delete(&image.key).await?;
+        Ok(true)
+    }
+
+    /// Public urls of the uploaded images of a topic by option id.
+    pub async fn urls(&self, topic_id: &str) -> Result<HashMap<i32, String>, AppError> {
+        let cursor = self.images.find(doc! { "topic_id": topic_id }).await?;
+        let images: Vec<OptionImage> = cursor.try_collect().await?;
+
+        Ok(images
+            .into_iter()
+            .map(|image| (image.option_id, self.storage.url(&image.key)))
+            .collect())
+    }
+}
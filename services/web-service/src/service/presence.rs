@@ -0,0 +1,186 @@
+use std::{
+    collections::{HashMap, HashSet},
+    sync::Arc,
+    time::Duration,
+};
+
+use dashmap::DashMap;
+use parking_lot::Mutex;
+use share::jobs::Stopping;
+
+use crate::error::AppError;
+
+/// Members older than this are no longer counted as present.
+const PRESENCE_WINDOW: Duration = Duration::from_secs(60);
+/// How often open live connections refresh their presence entry.
+const CONNECTION_HEARTBEAT_INTERVAL: Duration = Duration::from_secs(20);
+/// How long HTTP activity is collected before it is written in one pipeline.
+const TOUCH_FLUSH_INTERVAL: Duration = Duration::from_secs(2);
+
+fn presence_key(topic_id: &str) -> String {
+    format!("{topic_id}:presence")
+}
+
+/// Counts viewers per topic across all instances: open live connections plus
+/// clients that recently hit the topic over plain HTTP. Both are kept in one
+/// redis sorted set scored by the last seen unix timestamp.
+#[derive(Clone)]
+pub struct PresenceService {
+    redis: redis::aio::MultiplexedConnection,
+    connections: Arc<DashMap<String, String>>,
+    /// Clients touched since the last flush by topic.
+    touched: Arc<Mutex<HashMap<String, HashSet<String>>>>,
+}
+
+impl PresenceService {
+    pub fn new(redis: redis::aio::MultiplexedConnection) -> Self {
+        Self {
+            redis,
+            connections: Arc::new(DashMap::new()),
+            touched: Arc::new(Mutex::new(HashMap::new())),
+        }
+    }
+
+    async fn add_members(&self, members: &[(String, String)]) -> Result<(), AppError> {
+        if members.is_empty() {
+            return Ok(());
+        }
+
+        let now = chrono::Utc::now().timestamp();
+        let mut pipe = redis::pipe();
+        for (topic_id, member) in members {
+            let key = presence_key(topic_id);
+            pipe.zadd(&key, member, now).ignore();
+            pipe.expire(&key, PRESENCE_WINDOW.as_secs() as i64 * 2)
+                .ignore();
+        }
+
+        let mut conn = self.redis.clone();
+        let _: () = pipe.query_async(&mut conn).await?;
+        Ok(())
+    }
+
+    /// Records HTTP activity. Runs on every ballot, so the client is only
+    /// noted here and written in batches by a background task, repeat
+    /// touches within a flush allocate nothing.
+    pub fn touch(&self, topic_id: &str, client: &str) {
+        let mut touched = self.touched.lock();
+        if let Some(clients) = touched.get_mut(topic_id) {
+            if !clients.contains(client) {
+                clients.insert(client.to_string());
+            }
+            return;
+        }
+        touched.insert(topic_id.to_string(), HashSet::from([client.to_string()]));
+    }
+
+    /// Registers an open live connection until the returned guard is dropped.
+    pub fn register_connection(&self, topic_id: &str) -> PresenceGuard {
+        let connection_id = format!("conn:{}", uuid::Uuid::new_v4());
+        self.connections
+            .insert(connection_id.clone(), topic_id.to_string());
+
+        let service = self.clone();
+        let member = (topic_id.to_string(), connection_id.clone());
+        share::task::spawn("presence_register", async move {
+            if let Err(e) = service.add_members(&[member]).await {
+                tracing::debug!("failed to record live connection presence: {}", e);
+            }
+        });
+
+        PresenceGuard {
+            service: self.clone(),
+            connection_id,
+        }
+    }
+
+    pub async fn count(&self, topic_id: &str) -> Result<usize, AppError> {
+        let key = presence_key(topic_id);
+        let cutoff = chrono::Utc::now().timestamp() - PRESENCE_WINDOW.as_secs() as i64;
+
+        let mut conn = self.redis.clone();
+        let (count,): (usize,) = redis::pipe()
+            .zrembyscore(&key, "-inf", cutoff)
+            .ignore()
+            .zcard(&key)
+            .query_async(&mut conn)
+            .await?;
+
+        Ok(count)
+    }
+
+    /// Writes the clients touched since the last flush.
+    async fn flush(&self) {
+        let touched = std::mem::take(&mut *self.touched.lock());
+        let members: Vec<(String, String)> = touched
+            .into_iter()
+            .flat_map(|(topic_id, clients)| {
+                clients
+                    .into_iter()
+                    .map(move |client| (topic_id.clone(), format!("ip:{client}")))
+            })
+            .collect();
+
+        if let Err(e) = self.add_members(&members).await {
+            tracing::debug!("failed to record presence: {}", e);
+        }
+    }
+
+    /// Run as a background job, flushes once more when stopped.
+    pub async fn flush_touched(self, mut stopping: Stopping) {
+        let mut interval = tokio::time::interval(TOUCH_FLUSH_INTERVAL);
+        loop {
+            tokio::select! {
+                _ = interval.tick() => self.flush().await,
+                _ = stopping.wait() => {
+                    self.flush().await;
+                    return;
+                }
+            }
+        }
+    }
+
+    /// Run as a background job.
+    pub async fn heartbeat(self) {
+        let mut interval = tokio::time::interval(CONNECTION_HEARTBEAT_INTERVAL);
+        loop {
+            interval.tick().await;
+
+            let members: Vec<(String, String)> = self
+                .connections
+                .iter()
+                .map(|entry| (entry.value().clone(), entry.key().clone()))
+                .collect();
+
+            if let Err(e) = self.add_members(&members).await {
+                tracing::warn!("failed to refresh live connection presence: {}", e);
+            }
+        }
+    }
+}
+
+pub struct PresenceGuard {
+    service: PresenceService,
+    connection_id: String,
+}
+
+impl Drop for PresenceGuard {
+    fn drop(&mut self) {
+        let Some((connection_id, topic_id)) = self.service.connections.remove(&self.connection_id)
+        else {
+            return;
+        };
+
+        let mut conn = self.service.redis.clone();
+        share::task::spawn("presence_unregister", async move {
+            let result: Result<(), _> = redis::cmd("ZREM")
+                .arg(presence_key(&topic_id))
+                .arg(connection_id)
+                .query_async(&mut conn)
+                .await;
+            if let Err(e) = result {
+                tracing::debug!("failed to remove live connection presence: {}", e);
+            }
+        });
+    }
+}
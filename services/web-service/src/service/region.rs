@@ -0,0 +1,272 @@
+use std::{
+    collections::HashMap,
+    net::IpAddr,
+    path::{Path, PathBuf},
+    sync::Arc,
+    time::{Duration, SystemTime},
+};
+
+use parking_lot::{Mutex, RwLock};
+use redis::AsyncCommands as _;
+use share::{
+    geoip::{GeoIpDatabase, UNKNOWN_COUNTRY},
+    jobs::Stopping,
+    models::api::{RegionCount, RegionLevel},
+    reload::ConfigWatch,
+};
+
+use crate::error::AppError;
+
+/// How long ballots are counted here before they are written in one
+/// pipeline.
+const COUNT_FLUSH_INTERVAL: Duration = Duration::from_secs(5);
+
+fn regions_key(topic_id: &str) -> String {
+    format!("{topic_id}:regions")
+}
+
+/// The stored counts by country or province, the most first, with the
+/// regions below `min_ballots` summed up separately.
+fn breakdown(
+    counts: HashMap<String, i64>,
+    level: RegionLevel,
+    min_ballots: i64,
+) -> (Vec<RegionCount>, i64) {
+    let mut merged: HashMap<(String, Option<String>), i64> = HashMap::new();
+    for (key, ballots) in counts {
+        let (country, province) = match key.split_once(':') {
+            Some((country, province)) => (country.to_string(), Some(province.to_string())),
+            None => (key, None),
+        };
+        let province = match level {
+            RegionLevel::Country => None,
+            RegionLevel::Province => province,
+        };
+        *merged.entry((country, province)).or_default() += ballots;
+    }
+
+    let mut other = 0;
+    let mut regions: Vec<RegionCount> = Vec::new();
+    for ((country, province), ballots) in merged {
+        if ballots < min_ballots {
+            other += ballots;
+            continue;
+        }
+        regions.push(RegionCount {
+            country,
+            province,
+            ballots,
+        });
+    }
+    regions.sort_by(|a, b| {
+        b.ballots
+            .cmp(&a.ballots)
+            .then_with(|| a.country.cmp(&b.country))
+            .then_with(|| a.province.cmp(&b.province))
+    });
+    (regions, other)
+}
+
+/// The database in use and the file version it was built from.
+#[derive(Default)]
+struct LoadedDatabase {
+    database: Option<Arc<GeoIpDatabase>>,
+    source: Option<(PathBuf, SystemTime)>,
+}
+
+/// Counts the ballots of every topic by the region of the voter address in
+/// a redis hash, `{topic}:regions`. The region is looked up while the full
+/// address is still at hand and never stored with the ballot, only the
+/// counts leave the process.
+#[derive(Clone)]
+pub struct RegionService {
+    redis: redis::aio::MultiplexedConnection,
+    database: Arc<RwLock<LoadedDatabase>>,
+    /// Ballots counted since the last flush by topic and region.
+    counted: Arc<Mutex<HashMap<String, HashMap<Arc<str>, i64>>>>,
+    unknown: Arc<str>,
+    reload: ConfigWatch,
+}
+
+impl RegionService {
+    pub fn new(redis: redis::aio::MultiplexedConnection, reload: ConfigWatch) -> Self {
+        Self {
+            redis,
+            database: Arc::new(RwLock::new(LoadedDatabase::default())),
+            counted: Arc::new(Mutex::new(HashMap::new())),
+            unknown: Arc::from(UNKNOWN_COUNTRY),
+            reload,
+        }
+    }
+
+    /// Counts an accepted ballot. Runs on every ballot, so it is only noted
+    /// here and written in batches by [`Self::flush_counts`].
+    pub fn record(&self, topic_id: &str, ip: IpAddr) {
+        if !self.reload.current().geoip.enabled {
+            return;
+        }
+        let region = {
+            let loaded = self.database.read();
+            let Some(database) = &loaded.database else {
+                // nothing is counted before the database is loaded, the
+                // breakdown would lean on `ZZ` otherwise
+                return;
+            };
+            database.lookup(ip).unwrap_or(&self.unknown).clone()
+        };
+
+        let mut counted = self.counted.lock();
+        if let Some(regions) = counted.get_mut(topic_id) {
+            *regions.entry(region).or_default() += 1;
+            return;
+        }
+        counted.insert(topic_id.to_string(), HashMap::from([(region, 1)]));
+    }
+
+    /// Writes the ballots counted since the last flush.
+    async fn flush(&self) {
+        let counted = std::mem::take(&mut *self.counted.lock());
+        if counted.is_empty() {
+            return;
+        }
+        let mut pipe = redis::pipe();
+        for (topic_id, regions) in &counted {
+            let key = regions_key(topic_id);
+            for (region, ballots) in regions {
+                pipe.hincr(&key, region.as_ref(), *ballots).ignore();
+            }
+        }
+
+        let mut conn = self.redis.clone();
+        let result: Result<(), _> = pipe.query_async(&mut conn).await;
+        if let Err(e) = result {
+            tracing::warn!("failed to record region counts: {}", e);
+        }
+    }
+
+    /// Run as a background job, flushes once more when stopped.
+    pub async fn flush_counts(self, mut stopping: Stopping) {
+        let mut interval = tokio::time::interval(COUNT_FLUSH_INTERVAL);
+        loop {
+            tokio::select! {
+                _ = interval.tick() => self.flush().await,
+                _ = stopping.wait() => {
+                    self.flush().await;
+                    return;
+                }
+            }
+        }
+    }
+
+    /// Keeps the database current, run as a background job.
+    pub async fn watch(self) {
+        loop {
+            let config = self.reload.current();
+            if config.geoip.enabled {
+                self.refresh(&config.geoip.database_path).await;
+            }
+            tokio::time::sleep(Duration::from_secs(config.geoip.reload_secs)).await;
+        }
+    }
+
+    /// A database which fails to load keeps the last one in use.
+    async fn refresh(&self, path: &Path) {
+        let modified = match tokio::fs::metadata(path).await.and_then(|m| m.modified()) {
+            Ok(modified) => modified,
+            Err(e) => {
+                tracing::warn!("failed to read geoip database {}: {}", path.display(), e);
+                return;
+            }
+        };
+        let unchanged = self
+            .database
+            .read()
+            .source
+            .as_ref()
+            .is_some_and(|(loaded, at)| loaded == path && *at == modified);
+        if unchanged {
+            return;
+        }
+
+        let csv = match tokio::fs::read_to_string(path).await {
+            Ok(csv) => csv,
+            Err(e) => {
+                tracing::warn!("failed to read geoip database {}: {}", path.display(), e);
+                return;
+            }
+        };
+        // a city edition has millions of rows
+        let parsed = tokio::task::spawn_blocking(move || GeoIpDatabase::parse(&csv))
+            .await
+            .map_err(|e| e.to_string())
+            .and_then(|parsed| parsed);
+        match parsed {
+            Ok(database) => {
+                tracing::info!(
+                    "loaded {} geoip ranges from {}",
+                    database.len(),
+                    path.display()
+                );
+                *self.database.write() = LoadedDatabase {
+                    database: Some(Arc::new(database)),
+                    source: Some((path.to_path_buf(), modified)),
+                };
+            }
+            Err(e) => tracing::warn!("failed to parse geoip database {}: {}", path.display(), e),
+        }
+    }
+
+    /// The ballots of a topic by region and the total counted, which
+    /// includes `other`.
+    pub async fn breakdown(
+        &self,
+        topic_id: &str,
+        level: RegionLevel,
+    ) -> Result<(Vec<RegionCount>, i64, i64), AppError> {
+        let mut conn = self.redis.clone();
+        let counts: HashMap<String, i64> = conn.hgetall(regions_key(topic_id)).await?;
+        let total = counts.values().sum();
+        let min_ballots = self.reload.current().geoip.min_ballots;
+        let (regions, other) = breakdown(counts, level, min_ballots);
+        Ok((regions, other, total))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn small_regions_are_only_counted_in_other() {
+        let counts = HashMap::from([
+            ("CN:Fujian".to_string(), 30),
+            ("CN:Guangdong".to_string(), 50),
+            ("CN".to_string(), 5),
+            ("JP:Tokyo".to_string(), 12),
+            ("ZZ".to_string(), 3),
+        ]);
+
+        let (regions, other) = breakdown(counts.clone(), RegionLevel::Country, 10);
+        let countries: Vec<(&str, i64)> = regions
+            .iter()
+            .map(|region| (region.country.as_str(), region.ballots))
+            .collect();
+        assert_eq!(countries, vec![("CN", 85), ("JP", 12)]);
+        assert_eq!(other, 3);
+
+        let (regions, other) = breakdown(counts, RegionLevel::Province, 10);
+        let provinces: Vec<(&str, Option<&str>)> = regions
+            .iter()
+            .map(|region| (region.country.as_str(), region.province.as_deref()))
+            .collect();
+        assert_eq!(
+            provinces,
+            vec![
+                ("CN", Some("Guangdong")),
+                ("CN", Some("Fujian")),
+                ("JP", Some("Tokyo"))
+            ]
+        );
+        assert_eq!(other, 8);
+    }
+}
@@ -0,0 +1,217 @@
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{
+    Collection,
+    bson::{doc, to_bson},
+    options::ReturnDocument,
+};
+use share::{
+    client_ip::voter_key,
+    models::{
+        api::{ApiMsg, ContentReportView, ReportCreateRequest, ReportResolution},
+        database::{AuditLogEntry, CommentStatus, ContentReport, ReportStatus, ReportTargetKind},
+    },
+    reload::ConfigWatch,
+};
+
+use crate::{
+    error::AppError,
+    service::{AuditLogService, CommentService, TopicService},
+};
+
+const MAX_QUEUE_PAGE: i64 = 500;
+
+/// The moderation queue of reported comments and topics. Reports of the
+/// same content are merged while it is open, so the queue sorts by how many
+/// addresses reported it. Resolving only closes the report, the content is
+/// taken down through its own moderation endpoint.
+#[derive(Clone)]
+pub struct ReportService {
+    reports: Collection<ContentReport>,
+    comment_service: CommentService,
+    topic_service: TopicService,
+    audit_log: AuditLogService,
+    reload: ConfigWatch,
+}
+
+impl ReportService {
+    pub fn new(
+        mongo: mongodb::Database,
+        comment_service: CommentService,
+        topic_service: TopicService,
+        audit_log: AuditLogService,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            reports: mongo.collection::<ContentReport>("content_reports"),
+            comment_service,
+            topic_service,
+            audit_log,
+            reload,
+        }
+    }
+
+    /// Merges the report into the open one of its target. A second report
+    /// from the same address only adds its reason. The outer error is
+    /// reserved for database failures.
+    pub async fn report(
+        &self,
+        ip: &str,
+        req: &ReportCreateRequest,
+    ) -> Result<Result<(), ApiMsg>, AppError> {
+        let config = self.reload.current();
+        let detail = req.detail.trim();
+        if detail.chars().count() > config.report.max_detail_length {
+            return Ok(Err(ApiMsg::ReportDetailTooLong(
+                config.report.max_detail_length,
+            )));
+        }
+        let topic_id = match req.target_kind {
+            ReportTargetKind::Comment => match self.comment_service.get(&req.target_id).await? {
+                Some(comment) if comment.status == CommentStatus::Visible => comment.topic_id,
+                _ => return Ok(Err(ApiMsg::ReportTargetNotFound)),
+            },
+            ReportTargetKind::Topic => match self.topic_service.get_topic(&req.target_id).await? {
+                Some(topic) => topic.id,
+                None => return Ok(Err(ApiMsg::ReportTargetNotFound)),
+            },
+        };
+
+        // everything from the request is wrapped in $literal, a string
+        // starting with $ would be read as a field path
+        let reporter = voter_key(ip, &config.vote).into_owned();
+        let target_kind = to_bson(&req.target_kind)?;
+        let now = to_bson(&Utc::now())?;
+        let reporters = doc! { "$ifNull": ["$reporters", []] };
+        let known = doc! { "$in": [{ "$literal": &reporter }, reporters.clone()] };
+        let reason = to_bson(&req.reason)?;
+        let mut merged = doc! {
+            "target_kind": target_kind.clone(),
+            "target_id": { "$literal": &req.target_id },
+            "topic_id": { "$literal": &topic_id },
+            "status": "open",
+            "reporters": { "$setUnion": [reporters, [{ "$literal": &reporter }]] },
+            "reasons": { "$setUnion": [
+                { "$ifNull": ["$reasons", []] },
+                [reason],
+            ] },
+            "details": { "$ifNull": ["$details", []] },
+            "first_reported_at": { "$ifNull": ["$first_reported_at", now.clone()] },
+            "last_reported_at": now,
+        };
+        if !detail.is_empty() && config.report.max_details > 0 {
+            let kept = -(config.report.max_details as i64);
+            merged.insert(
+                "details",
+                doc! { "$cond": [
+                    known,
+                    { "$ifNull": ["$details", []] },
+                    { "$slice": [
+                        { "$concatArrays": [
+                            { "$ifNull": ["$details", []] },
+                            [{ "$literal": detail }],
+                        ] },
+                        kept,
+                    ] },
+                ] },
+            );
+        }
+
+        let report = self
+            .reports
+            .find_one_and_update(
+                doc! {
+                    "target_kind": target_kind,
+                    "target_id": &req.target_id,
+                    "status": "open",
+                },
+                vec![
+                    doc! { "$set": merged },
+                    doc! { "$set": { "report_count": { "$size": "$reporters" } } },
+                ],
+            )
+            .upsert(true)
+            .return_document(ReturnDocument::After)
+            .await?;
+
+        let hold_at = config.report.hold_comment_at;
+        if let Some(report) = report
+            && report.target_kind == ReportTargetKind::Comment
+            && hold_at > 0
+            && report.report_count >= hold_at
+            && self.comment_service.hold(&report.target_id).await?
+        {
+            tracing::info!(
+                "held comment {} for review after {} reports",
+                report.target_id,
+                report.report_count
+            );
+        }
+        Ok(Ok(()))
+    }
+
+    /// Most reported first, then the latest reported.
+    pub async fn queue(
+        &self,
+        status: ReportStatus,
+        limit: i64,
+    ) -> Result<Vec<ContentReportView>, AppError> {
+        Ok(self
+            .reports
+            .find(doc! { "status": to_bson(&status)? })
+            .sort(doc! { "report_count": -1, "last_reported_at": -1 })
+            .limit(limit.clamp(1, MAX_QUEUE_PAGE))
+            .await?
+            .map_ok(ContentReportView::from)
+            .try_collect()
+            .await?)
+    }
+
+    /// Closes the open report of a target, recorded in the audit log.
+    pub async fn resolve(
+        &self,
+        target_kind: ReportTargetKind,
+        target_id: &str,
+        resolution: ReportResolution,
+        note: &str,
+        actor: &str,
+    ) -> Result<Result<ContentReportView, ApiMsg>, AppError> {
+        let (status, action) = match resolution {
+            ReportResolution::Actioned => (ReportStatus::Actioned, "report_actioned"),
+            ReportResolution::Dismissed => (ReportStatus::Dismissed, "report_dismissed"),
+        };
+        let report = self
+            .reports
+            .find_one_and_update(
+                doc! {
+                    "target_kind": to_bson(&target_kind)?,
+                    "target_id": target_id,
+                    "status": "open",
+                },
+                doc! { "$set": {
+                    "status": to_bson(&status)?,
+                    "resolved_by": actor,
+                    "resolved_at": to_bson(&Utc::now())?,
+                    "note": note,
+                } },
+            )
+            .return_document(ReturnDocument::After)
+            .await?;
+        let Some(report) = report else {
+            return Ok(Err(ApiMsg::ReportNotFound));
+        };
+
+        self.audit_log
+            .record(AuditLogEntry::new(
+                Some(report.topic_id.clone()),
+                actor,
+                action,
+                format!(
+                    "{:?} {} after {} reports: {note}",
+                    report.target_kind, report.target_id, report.report_count
+                ),
+            ))
+            .await?;
+        Ok(Ok(report.into()))
+    }
+}
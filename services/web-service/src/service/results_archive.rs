@@ -0,0 +1,291 @@
+use std::{
+    collections::HashMap,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::Utc;
+use mongodb::{Collection, bson::doc};
+use parking_lot::RwLock;
+use serde::{Deserialize, Serialize};
+use share::{
+    models::database::{ArchivedResults, CreateTopicStatus, TaskPriority, VotingTopic},
+    reload::ConfigWatch,
+};
+
+use super::digest::ranking;
+use crate::{
+    error::AppError,
+    queue::{RetryPolicy, TaskQueue},
+    service::{ResultsSnapshot, TopicService},
+};
+
+/// Kind of the queue tasks archiving the results of one topic.
+const ARCHIVE_TASK: &str = "results_archive";
+
+const RETRY_POLICY: RetryPolicy = RetryPolicy {
+    max_attempts: 5,
+    initial_backoff: Duration::from_secs(60),
+    max_backoff: Duration::from_secs(1800),
+};
+
+/// Where an option stood in the final results of one topic.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Standing {
+    /// 1 based, by win rate.
+    pub rank: u32,
+    /// Options with votes in the topic.
+    pub ranked: u32,
+    pub win: i64,
+    pub lose: i64,
+}
+
+/// The standing of `id` in archived `op_stats`, none without votes.
+fn standing(stats: &HashMap<String, i64>, id: i32) -> Option<Standing> {
+    let snapshot = ResultsSnapshot::new(stats.clone(), HashMap::new(), 0);
+    let ranking = ranking(&snapshot.stats);
+    let rank = ranking.iter().position(|&ranked| ranked == id)?;
+    let (win, lose) = snapshot.standing(id);
+    Some(Standing {
+        rank: rank as u32 + 1,
+        ranked: ranking.len() as u32,
+        win,
+        lose,
+    })
+}
+
+/// Payload of an [`ARCHIVE_TASK`].
+#[derive(Debug, Serialize, Deserialize)]
+struct ArchiveTask {
+    topic_id: String,
+}
+
+/// Copies the results of closed topics from redis to the
+/// `results_snapshots` collection, next to the ones the `snapshot` command
+/// takes, and serves the latest closed topics with them to the results feed
+/// and the history of the options across topics.
+#[derive(Clone)]
+pub struct ResultsArchiveService {
+    archives: Collection<ArchivedResults>,
+    redis: redis::aio::MultiplexedConnection,
+    queue: TaskQueue,
+    topic_service: TopicService,
+    reload: ConfigWatch,
+    recent: Arc<RwLock<Option<(Instant, Arc<Vec<(VotingTopic, ArchivedResults)>>)>>>,
+    finals: Arc<RwLock<Option<(Instant, Arc<Vec<(VotingTopic, ArchivedResults)>>)>>>,
+}
+
+impl ResultsArchiveService {
+    pub fn new(
+        mongo: mongodb::Database,
+        redis: redis::aio::MultiplexedConnection,
+        queue: TaskQueue,
+        topic_service: TopicService,
+        reload: ConfigWatch,
+    ) -> Self {
+        let service = Self {
+            archives: mongo.collection::<ArchivedResults>("results_snapshots"),
+            redis,
+            queue,
+            topic_service,
+            reload,
+            recent: Arc::new(RwLock::new(None)),
+            finals: Arc::new(RwLock::new(None)),
+        };
+        service
+            .queue
+            .register(ARCHIVE_TASK, TaskPriority::Low, RETRY_POLICY, {
+                let service = service.clone();
+                move |task: ArchiveTask, _| {
+                    let service = service.clone();
+                    async move {
+                        service
+                            .archive(&task.topic_id)
+                            .await
+                            .map_err(|e| e.to_string())
+                    }
+                }
+            });
+
+        service
+    }
+
+    /// Queues the archive of a topic which just closed, due
+    /// `feeds.archive_delay_secs` later.
+    pub async fn topic_closed(&self, topic: &VotingTopic) -> Result<(), AppError> {
+        if !topic.topic_type.supports_final_order() {
+            return Ok(());
+        }
+        let delay = self.reload.current().feeds.archive_delay_secs;
+        let run_at = Utc::now() + chrono::Duration::seconds(delay as i64);
+        let task = ArchiveTask {
+            topic_id: topic.id.clone(),
+        };
+        self.queue.enqueue_at(ARCHIVE_TASK, &task, run_at).await?;
+        Ok(())
+    }
+
+    #[tracing::instrument(name = "redis.results_archive", skip(self), fields(otel.kind = "client"))]
+    pub async fn archive(&self, topic_id: &str) -> Result<(), AppError> {
+        let mut conn = self.redis.clone();
+        let (stats, matrix, count): (HashMap<String, i64>, HashMap<String, i64>, Option<i64>) =
+            redis::pipe()
+                .hgetall(format!("{topic_id}:op_stats"))
+                .hgetall(format!("{topic_id}:op_matrix"))
+                .get(format!("{topic_id}:valid_ballots_count"))
+                .query_async(&mut conn)
+                .await?;
+
+        let archived = ArchivedResults {
+            topic_id: topic_id.to_string(),
+            taken_at: Utc::now(),
+            count: count.unwrap_or(0),
+            stats,
+            matrix,
+        };
+        self.archives.insert_one(&archived).await?;
+        tracing::info!(
+            "archived results of {}: {} ballots",
+            topic_id,
+            archived.count
+        );
+        Ok(())
+    }
+
+    /// The latest results of a topic taken after it closed, without the
+    /// matrix.
+    async fn final_results(
+        &self,
+        topic: &VotingTopic,
+    ) -> Result<Option<ArchivedResults>, AppError> {
+        let latest = self
+            .archives
+            .find_one(doc! { "topic_id": &topic.id })
+            .sort(doc! { "taken_at": -1 })
+            .projection(doc! { "matrix": 0 })
+            .await?;
+        Ok(latest.filter(|archived| archived.taken_at > topic.close_time))
+    }
+
+    /// Up to `feeds.max_entries` approved topics which closed and have final
+    /// results, the latest closed first. Kept for `feeds.cache_secs`.
+    pub async fn recent(&self) -> Result<Arc<Vec<(VotingTopic, ArchivedResults)>>, AppError> {
+        let config = self.reload.current().feeds.clone();
+        let ttl = Duration::from_secs(config.cache_secs);
+        let fresh = self
+            .recent
+            .read()
+            .as_ref()
+            .filter(|(at, _)| at.elapsed() < ttl)
+            .map(|(_, recent)| recent.clone());
+        if let Some(recent) = fresh {
+            return Ok(recent);
+        }
+
+        let now = Utc::now();
+        let mut topics: Vec<VotingTopic> = self
+            .topic_service
+            .cached_topics()
+            .into_iter()
+            .filter(|topic| {
+                matches!(topic.status, CreateTopicStatus::Approved(_))
+                    && topic.topic_type.supports_final_order()
+                    && topic.close_time < now
+            })
+            .collect();
+        topics.sort_by(|a, b| b.close_time.cmp(&a.close_time));
+
+        let mut recent = Vec::new();
+        for topic in topics {
+            if recent.len() >= config.max_entries {
+                break;
+            }
+            // topics which closed before the archive existed have none
+            if let Some(archived) = self.final_results(&topic).await? {
+                recent.push((topic, archived));
+            }
+        }
+        let recent = Arc::new(recent);
+        *self.recent.write() = Some((Instant::now(), recent.clone()));
+        Ok(recent)
+    }
+
+    /// Every approved pairwise topic with final results, the first closed
+    /// first. Kept for `history.cache_secs`.
+    async fn finals(&self) -> Result<Arc<Vec<(VotingTopic, ArchivedResults)>>, AppError> {
+        let ttl = Duration::from_secs(self.reload.current().history.cache_secs);
+        let fresh = self
+            .finals
+            .read()
+            .as_ref()
+            .filter(|(at, _)| at.elapsed() < ttl)
+            .map(|(_, finals)| finals.clone());
+        if let Some(finals) = fresh {
+            return Ok(finals);
+        }
+
+        let now = Utc::now();
+        let mut topics: Vec<VotingTopic> = self
+            .topic_service
+            .cached_topics()
+            .into_iter()
+            .filter(|topic| {
+                matches!(topic.status, CreateTopicStatus::Approved(_))
+                    && topic.topic_type.supports_final_order()
+                    && topic.close_time < now
+            })
+            .collect();
+        topics.sort_by(|a, b| a.close_time.cmp(&b.close_time));
+
+        let mut finals = Vec::new();
+        for topic in topics {
+            if let Some(archived) = self.final_results(&topic).await? {
+                finals.push((topic, archived));
+            }
+        }
+        let finals = Arc::new(finals);
+        *self.finals.write() = Some((Instant::now(), finals.clone()));
+        Ok(finals)
+    }
+
+    /// The standings of an option in the final results of every topic it
+    /// got votes in with the valid ballots of the topic, the first closed
+    /// first.
+    pub async fn history(&self, id: i32) -> Result<Vec<(VotingTopic, i64, Standing)>, AppError> {
+        let finals = self.finals().await?;
+        Ok(finals
+            .iter()
+            .filter_map(|(topic, archived)| {
+                let standing = standing(&archived.stats, id)?;
+                Some((topic.clone(), archived.count, standing))
+            })
+            .collect())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn standing_is_ranked_by_win_rate() {
+        let stats = HashMap::from([
+            ("1:win".to_string(), 6),
+            ("1:lose".to_string(), 4),
+            ("2:win".to_string(), 9),
+            ("2:lose".to_string(), 1),
+            ("3:win".to_string(), 1),
+            ("3:lose".to_string(), 9),
+        ]);
+        assert_eq!(
+            standing(&stats, 1),
+            Some(Standing {
+                rank: 2,
+                ranked: 3,
+                win: 6,
+                lose: 4,
+            })
+        );
+        assert_eq!(standing(&stats, 4), None);
+    }
+}
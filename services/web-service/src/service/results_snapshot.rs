@@ -0,0 +1,215 @@
+use std::{
+    collections::HashMap,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::{DateTime, Utc};
+use dashmap::DashMap;
+use redis::AsyncCommands as _;
+use share::{
+    config::ResultsPrecomputeConfig,
+    counter::ShardedCounters,
+    jobs::{JobManager, JobRun, Stage},
+    reload::ConfigWatch,
+};
+
+use crate::{error::AppError, service::TopicService};
+
+const JOB_NAME: &str = "results_precompute";
+
+/// Vote totals of a topic as of `computed_at`, the results endpoints derive
+/// their responses from it instead of reading redis per request.
+#[derive(Debug, Default)]
+pub struct ResultsSnapshot {
+    /// Wins and losses by operator id.
+    pub stats: HashMap<i32, (i64, i64)>,
+    /// `winner:loser` to score, as stored by the nats consumer.
+    pub matrix: HashMap<String, i64>,
+    pub count: i64,
+    pub computed_at: DateTime<Utc>,
+}
+
+impl ResultsSnapshot {
+    /// Builds the snapshot from the raw `op_stats` and `op_matrix` hashes.
+    pub fn new(op_stats: HashMap<String, i64>, matrix: HashMap<String, i64>, count: i64) -> Self {
+        let mut stats: HashMap<i32, (i64, i64)> = HashMap::new();
+        for (field, value) in op_stats {
+            let Some((id, side)) = field.split_once(':') else {
+                continue;
+            };
+            let Ok(id) = id.parse::<i32>() else {
+                continue;
+            };
+            let entry = stats.entry(id).or_default();
+            match side {
+                "win" => entry.0 = value,
+                "lose" => entry.1 = value,
+                _ => {}
+            }
+        }
+
+        Self {
+            stats,
+            matrix,
+            count,
+            computed_at: Utc::now(),
+        }
+    }
+
+    pub fn standing(&self, id: i32) -> (i64, i64) {
+        self.stats.get(&id).copied().unwrap_or_default()
+    }
+}
+
+/// Keeps a snapshot of every active topic fresh in the background. Other
+/// topics are computed on first request and only recomputed when their
+/// ballot count still moves, e.g. while the consumer drains after closing.
+#[derive(Clone)]
+pub struct ResultsSnapshotService {
+    redis: redis::aio::MultiplexedConnection,
+    snapshots: Arc<DashMap<String, Arc<ResultsSnapshot>>>,
+    /// Ballots accepted by this instance since the last snapshot, bumped by
+    /// every vote so it must not share a lock.
+    accepted: Arc<ShardedCounters>,
+    config: ConfigWatch,
+}
+
+impl ResultsSnapshotService {
+    pub fn new(redis: redis::aio::MultiplexedConnection, config: ConfigWatch) -> Self {
+        Self {
+            redis,
+            snapshots: Arc::new(DashMap::new()),
+            accepted: Arc::new(ShardedCounters::default()),
+            config,
+        }
+    }
+
+    pub async fn get(&self, topic_id: &str) -> Result<Arc<ResultsSnapshot>, AppError> {
+        if let Some(snapshot) = self.snapshots.get(topic_id) {
+            return Ok(snapshot.clone());
+        }
+        self.compute(topic_id).await
+    }
+
+    /// Read per use, the thresholds can be changed by a config reload.
+    fn precompute_config(&self) -> ResultsPrecomputeConfig {
+        self.config.current().results_precompute.clone()
+    }
+
+    pub fn record_vote(&self, topic_id: &str) {
+        self.accepted.add(topic_id, 1);
+    }
+
+    #[tracing::instrument(name = "redis.results_snapshot", skip(self), fields(otel.kind = "client"))]
+    async fn compute(&self, topic_id: &str) -> Result<Arc<ResultsSnapshot>, AppError> {
+        let mut conn = self.redis.clone();
+        let (stats, matrix, count): (HashMap<String, i64>, HashMap<String, i64>, Option<i64>) =
+            redis::pipe()
+                .hgetall(format!("{topic_id}:op_stats"))
+                .hgetall(format!("{topic_id}:op_matrix"))
+                .get(format!("{topic_id}:valid_ballots_count"))
+                .query_async(&mut conn)
+                .await?;
+
+        self.accepted.take(topic_id);
+        let snapshot = Arc::new(ResultsSnapshot::new(stats, matrix, count.unwrap_or(0)));
+        self.snapshots
+            .insert(topic_id.to_string(), snapshot.clone());
+        Ok(snapshot)
+    }
+
+    /// Whether enough votes arrived or enough time passed since `snapshot`.
+    fn is_stale(&self, snapshot: &ResultsSnapshot, count: i64) -> bool {
+        let config = self.precompute_config();
+        let age = (Utc::now() - snapshot.computed_at)
+            .to_std()
+            .unwrap_or_default();
+        count - snapshot.count >= config.vote_trigger
+            || (count != snapshot.count && age >= Duration::from_secs(config.max_age_secs))
+    }
+
+    async fn refresh(&self, topic_id: &str) -> Result<(), AppError> {
+        let current = self.snapshots.get(topic_id).map(|entry| entry.clone());
+        let vote_trigger = self.precompute_config().vote_trigger;
+        let stale = match current {
+            // the votes accepted here are enough, no need to ask redis
+            Some(_) if self.accepted.get(topic_id) >= vote_trigger as u64 => true,
+            Some(snapshot) => {
+                let mut conn = self.redis.clone();
+                let count: Option<i64> =
+                    conn.get(format!("{topic_id}:valid_ballots_count")).await?;
+                self.is_stale(&snapshot, count.unwrap_or(0))
+            }
+            None => true,
+        };
+        if stale {
+            self.compute(topic_id).await?;
+        }
+        Ok(())
+    }
+
+    /// Refreshes the snapshots every `interval_secs` as a scheduler job of
+    /// `jobs`, which lists every pass.
+    pub fn start(&self, topic_service: TopicService, jobs: &JobManager) {
+        jobs.spawn(
+            JOB_NAME,
+            Stage::Scheduler,
+            self.clone().run(topic_service, jobs.clone()),
+        );
+    }
+
+    async fn run(self, topic_service: TopicService, jobs: JobManager) {
+        loop {
+            let interval = Duration::from_secs(self.precompute_config().interval_secs.max(1));
+            jobs.set_next_run(
+                JOB_NAME,
+                chrono::Duration::from_std(interval)
+                    .ok()
+                    .map(|interval| Utc::now() + interval),
+            );
+            tokio::time::sleep(interval).await;
+
+            let started_at = Utc::now();
+            let started = Instant::now();
+            let error = self.refresh_all(&topic_service).await.err();
+            jobs.record_run(
+                JOB_NAME,
+                JobRun {
+                    started_at,
+                    duration_ms: started.elapsed().as_millis() as u64,
+                    success: error.is_none(),
+                    error,
+                },
+            );
+        }
+    }
+
+    /// One pass of the job, the error counts the topics which failed.
+    async fn refresh_all(&self, topic_service: &TopicService) -> Result<(), String> {
+        let mut topic_ids = match topic_service.get_active_topic_ids().await {
+            Ok(topic_ids) => topic_ids,
+            Err(e) => {
+                tracing::warn!("Failed to list active topics for results: {}", e);
+                return Err(format!("failed to list active topics: {e}"));
+            }
+        };
+        for entry in self.snapshots.iter() {
+            if !topic_ids.contains(entry.key()) {
+                topic_ids.push(entry.key().clone());
+            }
+        }
+
+        let mut failed = 0;
+        for topic_id in topic_ids {
+            if let Err(e) = self.refresh(&topic_id).await {
+                tracing::warn!("Failed to precompute results of {}: {}", topic_id, e);
+                failed += 1;
+            }
+        }
+        match failed {
+            0 => Ok(()),
+            failed => Err(format!("{failed} topics failed")),
+        }
+    }
+}
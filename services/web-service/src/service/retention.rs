@@ -0,0 +1,136 @@
+use chrono::{DateTime, Utc};
+use mongodb::bson::{Document, doc};
+use share::{
+    models::api::{RetentionPolicyReport, RetentionReport},
+    reload::ConfigWatch,
+};
+
+use crate::{error::AppError, service::TopicService};
+
+/// Applies the `[retention]` policies, run by the scheduler. Every policy
+/// deletes by age only, so a run interrupted halfway is completed by the
+/// next.
+#[derive(Clone)]
+pub struct RetentionService {
+    mongo: mongodb::Database,
+    topic_service: TopicService,
+    reload: ConfigWatch,
+}
+
+impl RetentionService {
+    pub fn new(mongo: mongodb::Database, topic_service: TopicService, reload: ConfigWatch) -> Self {
+        Self {
+            mongo,
+            topic_service,
+            reload,
+        }
+    }
+
+    /// Prunes what the policies match, or only counts it with `dry_run`.
+    pub async fn run(&self, dry_run: bool) -> Result<RetentionReport, AppError> {
+        let config = self.reload.current();
+        let retention = &config.retention;
+        let mut policies = Vec::new();
+
+        if let Some(cutoff) = cutoff(retention.ballot_days) {
+            policies.push(RetentionPolicyReport {
+                policy: "ballot_days".to_string(),
+                cutoff,
+                count: self.prune_ballots(cutoff, dry_run).await?,
+            });
+        }
+        if let Some(cutoff) = cutoff(retention.audit_log_days) {
+            let filter = doc! { "created_at": { "$lt": mongodb::bson::to_bson(&cutoff)? } };
+            policies.push(RetentionPolicyReport {
+                policy: "audit_log_days".to_string(),
+                cutoff,
+                count: self.prune("audit_log", filter, dry_run).await?,
+            });
+        }
+        if let Some(cutoff) = cutoff(retention.dead_task_days) {
+            let filter = doc! {
+                "status": "dead",
+                "created_at": { "$lt": mongodb::bson::to_bson(&cutoff)? },
+            };
+            policies.push(RetentionPolicyReport {
+                policy: "dead_task_days".to_string(),
+                cutoff,
+                count: self.prune("task_queue", filter, dry_run).await?,
+            });
+        }
+        if let Some(cutoff) = cutoff(retention.voter_export_days) {
+            let filter = doc! { "requested_at": { "$lt": mongodb::bson::to_bson(&cutoff)? } };
+            policies.push(RetentionPolicyReport {
+                policy: "voter_export_days".to_string(),
+                cutoff,
+                count: self.prune("voter_exports", filter, dry_run).await?,
+            });
+        }
+
+        Ok(RetentionReport { dry_run, policies })
+    }
+
+    /// The scheduled run, a dry one while `retention.dry_run` is set.
+    pub async fn scheduled_run(&self) -> Result<(), AppError> {
+        let dry_run = self.reload.current().retention.dry_run;
+        let report = self.run(dry_run).await?;
+        for policy in &report.policies {
+            match dry_run {
+                true => tracing::info!(
+                    "retention {} would prune {} documents from before {}",
+                    policy.policy,
+                    policy.count,
+                    policy.cutoff
+                ),
+                false => tracing::info!(
+                    "retention {} pruned {} documents from before {}",
+                    policy.policy,
+                    policy.count,
+                    policy.cutoff
+                ),
+            }
+        }
+        Ok(())
+    }
+
+    async fn prune(
+        &self,
+        collection: &str,
+        filter: Document,
+        dry_run: bool,
+    ) -> Result<u64, AppError> {
+        let collection = self.mongo.collection::<Document>(collection);
+        let count = match dry_run {
+            true => collection.count_documents(filter).await?,
+            false => collection.delete_many(filter).await?.deleted_count,
+        };
+        Ok(count)
+    }
+
+    /// Drops the ballot collections of the topics closed before `cutoff`.
+    async fn prune_ballots(&self, cutoff: DateTime<Utc>, dry_run: bool) -> Result<u64, AppError> {
+        let mut count = 0;
+        for topic in self.topic_service.cached_topics() {
+            if topic.close_time >= cutoff {
+                continue;
+            }
+            let ballots = self
+                .mongo
+                .collection::<Document>(&format!("ballots_{}", topic.id));
+            let stored = ballots.estimated_document_count().await?;
+            if stored == 0 {
+                continue;
+            }
+            if !dry_run {
+                ballots.drop().await?;
+            }
+            count += stored;
+        }
+        Ok(count)
+    }
+}
+
+/// Start of the kept range of a policy, `None` keeps everything.
+fn cutoff(days: u32) -> Option<DateTime<Utc>> {
+    (days > 0).then(|| Utc::now() - chrono::Duration::days(days.into()))
+}
@@ -0,0 +1,97 @@
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use share::models::{
+    api::ScheduledAction,
+    database::{QueuedTask, TaskPriority},
+};
+
+use crate::{
+    error::AppError,
+    queue::{RetryPolicy, TaskQueue},
+    service::{ApiKeyService, DatasetService, MaintenanceService},
+};
+
+/// Kind of the queue tasks running a [`ScheduledAction`].
+const SCHEDULED_ACTION_TASK: &str = "scheduled_action";
+
+const RETRY_POLICY: RetryPolicy = RetryPolicy {
+    max_attempts: 5,
+    initial_backoff: Duration::from_secs(10),
+    max_backoff: Duration::from_secs(300),
+};
+
+/// One-shot admin actions at a given time, kept in the task queue so they
+/// survive restarts. Every action is idempotent as the queue may run it
+/// twice.
+#[derive(Clone)]
+pub struct ScheduledActions {
+    queue: TaskQueue,
+}
+
+impl ScheduledActions {
+    pub fn new(
+        queue: TaskQueue,
+        maintenance: MaintenanceService,
+        api_keys: ApiKeyService,
+        datasets: DatasetService,
+    ) -> Self {
+        queue.register(
+            SCHEDULED_ACTION_TASK,
+            TaskPriority::High,
+            RETRY_POLICY,
+            move |action: ScheduledAction, _| {
+                let maintenance = maintenance.clone();
+                let api_keys = api_keys.clone();
+                let datasets = datasets.clone();
+                async move {
+                    run(action, &maintenance, &api_keys, &datasets)
+                        .await
+                        .map_err(|e| e.to_string())
+                }
+            },
+        );
+
+        Self { queue }
+    }
+
+    /// Returns the id of the queued task.
+    pub async fn schedule(
+        &self,
+        run_at: DateTime<Utc>,
+        action: &ScheduledAction,
+    ) -> Result<String, AppError> {
+        self.queue
+            .enqueue_at(SCHEDULED_ACTION_TASK, action, run_at)
+            .await
+    }
+
+    /// Actions not run yet, the next due first.
+    pub async fn list(&self, limit: Option<i64>) -> Result<Vec<QueuedTask>, AppError> {
+        self.queue.pending(Some(SCHEDULED_ACTION_TASK), limit).await
+    }
+}
+
+async fn run(
+    action: ScheduledAction,
+    maintenance: &MaintenanceService,
+    api_keys: &ApiKeyService,
+    datasets: &DatasetService,
+) -> Result<(), AppError> {
+    match action {
+        ScheduledAction::Maintenance { enabled, message } => {
+            maintenance.set(enabled, message).await?;
+            tracing::info!("scheduled maintenance switched {}", enabled);
+        }
+        ScheduledAction::ApiKeyRevoke { name } => {
+            // a missing key was deleted or revoked already
+            if !api_keys.revoke(&name).await? {
+                tracing::info!("scheduled revocation of {} found no api key", name);
+            }
+        }
+        ScheduledAction::DatasetPublish { topic_id } => {
+            datasets.publish(&topic_id).await?;
+        }
+    }
+    Ok(())
+}
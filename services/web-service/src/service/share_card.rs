@@ -0,0 +1,332 @@
+use std::{
+    collections::HashMap,
+    io::Cursor,
+    path::Path,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use ab_glyph::{Font, FontArc, PxScale, ScaleFont, point};
+use axum::body::Bytes;
+use dashmap::DashMap;
+use image::{ImageFormat, Rgba, RgbaImage, imageops};
+use share::{
+    models::api::{CharacterPortrait, ImageSize},
+    reload::ConfigWatch,
+};
+
+use crate::{error::AppError, service::ImageProxyService};
+
+/// The size the social platforms show without cropping.
+const WIDTH: u32 = 1200;
+const HEIGHT: u32 = 630;
+const MARGIN: u32 = 60;
+const ROWS_PER_COLUMN: usize = 5;
+const ROW_HEIGHT: u32 = 88;
+const AVATAR_EDGE: u32 = 72;
+
+const BACKGROUND: Rgba<u8> = Rgba([27, 29, 35, 255]);
+const TEXT: Rgba<u8> = Rgba([240, 240, 240, 255]);
+const MUTED: Rgba<u8> = Rgba([150, 154, 165, 255]);
+const TRACK: Rgba<u8> = Rgba([52, 56, 66, 255]);
+const ACCENT: Rgba<u8> = Rgba([242, 183, 5, 255]);
+
+/// One option on a card, in the order shown.
+pub struct ShareCardRow {
+    pub id: i32,
+    pub name: String,
+    /// Win rate over every ballot of the topic, in percent.
+    pub rate: f64,
+}
+
+pub struct ShareCard {
+    pub title: String,
+    pub subtitle: String,
+    pub rows: Vec<ShareCardRow>,
+}
+
+/// Renders the PNG cards of `/results/share_card` and keeps them for
+/// `share_card.cache_secs`, a shared link is fetched by every platform and
+/// every client it is posted to.
+#[derive(Clone)]
+pub struct ShareCardService {
+    image_proxy: ImageProxyService,
+    portraits: Arc<HashMap<i32, CharacterPortrait>>,
+    font: Option<FontArc>,
+    cache: Arc<DashMap<String, (Instant, Bytes)>>,
+    reload: ConfigWatch,
+}
+
+fn load_font(path: &Path) -> Option<FontArc> {
+    let loaded = std::fs::read(path)
+        .map_err(|e| e.to_string())
+        .and_then(|bytes| FontArc::try_from_vec(bytes).map_err(|e| e.to_string()));
+    match loaded {
+        Ok(font) => Some(font),
+        Err(e) => {
+            tracing::warn!("failed to load share card font {}: {}", path.display(), e);
+            None
+        }
+    }
+}
+
+impl ShareCardService {
+    /// Width and height of every card.
+    pub const SIZE: (u32, u32) = (WIDTH, HEIGHT);
+
+    pub fn new(
+        image_proxy: ImageProxyService,
+        portraits: HashMap<i32, CharacterPortrait>,
+        reload: ConfigWatch,
+    ) -> Self {
+        let font = reload
+            .current()
+            .share_card
+            .font_path
+            .as_deref()
+            .and_then(load_font);
+        Self {
+            image_proxy,
+            portraits: Arc::new(portraits),
+            font,
+            cache: Arc::new(DashMap::new()),
+            reload,
+        }
+    }
+
+    pub fn max_age_secs(&self) -> u64 {
+        self.reload.current().share_card.cache_secs
+    }
+
+    /// The card rendered under `key` within the last `cache_secs`.
+    pub fn cached(&self, key: &str) -> Option<Bytes> {
+        let ttl = Duration::from_secs(self.max_age_secs());
+        self.cache
+            .get(key)
+            .filter(|entry| entry.0.elapsed() < ttl)
+            .map(|entry| entry.1.clone())
+    }
+
+    pub async fn render(&self, key: String, card: ShareCard) -> Result<Bytes, AppError> {
+        let mut avatars = Vec::with_capacity(card.rows.len());
+        for row in &card.rows {
+            avatars.push(self.avatar(row.id).await);
+        }
+        let font = self.font.clone();
+        let png = tokio::task::spawn_blocking(move || draw(&card, font.as_ref(), &avatars))
+            .await
+            .map_err(|e| AppError::InternalError(format!("share card task failed: {e}")))??;
+        let png = Bytes::from(png);
+
+        let config = self.reload.current();
+        if self.cache.len() >= config.share_card.max_cached {
+            let ttl = Duration::from_secs(config.share_card.cache_secs);
+            self.cache.retain(|_, (at, _)| at.elapsed() < ttl);
+            if self.cache.len() >= config.share_card.max_cached {
+                self.cache.clear();
+            }
+        }
+        self.cache.insert(key, (Instant::now(), png.clone()));
+        Ok(png)
+    }
+
+    /// The default avatar through the image proxy cache, a card is still
+    /// drawn without it.
+    async fn avatar(&self, id: i32) -> Option<Bytes> {
+        let url = self.portraits.get(&id)?.avatar.first()?;
+        let key = format!("{id}_0");
+        match self.image_proxy.get(&key, url, ImageSize::Medium).await {
+            Ok(image) => Some(image.bytes),
+            Err(e) => {
+                tracing::warn!("failed to fetch avatar {} for a share card: {}", key, e);
+                None
+            }
+        }
+    }
+}
+
+fn blend(pixel: &mut Rgba<u8>, color: Rgba<u8>, coverage: f32) {
+    let alpha = coverage.clamp(0.0, 1.0) * f32::from(color[3]) / 255.0;
+    for channel in 0..3 {
+        let mixed = f32::from(pixel[channel]) * (1.0 - alpha) + f32::from(color[channel]) * alpha;
+        pixel[channel] = mixed.round() as u8;
+    }
+}
+
+fn fill_rect(canvas: &mut RgbaImage, x: u32, y: u32, width: u32, height: u32, color: Rgba<u8>) {
+    for py in y..(y + height).min(canvas.height()) {
+        for px in x..(x + width).min(canvas.width()) {
+            canvas.put_pixel(px, py, color);
+        }
+    }
+}
+
+/// A font at one size and color.
+struct Pen<'a> {
+    font: &'a FontArc,
+    size: f32,
+    color: Rgba<u8>,
+}
+
+impl Pen<'_> {
+    /// Draws `text` with its top at `y`, cut at `max_width`.
+    fn write(&self, canvas: &mut RgbaImage, x: u32, y: u32, max_width: u32, text: &str) {
+        let scaled = self.font.as_scaled(PxScale::from(self.size));
+        let baseline = y as f32 + scaled.ascent();
+        let end = (x + max_width) as f32;
+        let mut caret = x as f32;
+        let mut previous = None;
+        for c in text.chars() {
+            let id = scaled.glyph_id(c);
+            if let Some(previous) = previous {
+                caret += scaled.kern(previous, id);
+            }
+            let advance = scaled.h_advance(id);
+            if caret + advance > end {
+                break;
+            }
+            let glyph = id.with_scale_and_position(scaled.scale(), point(caret, baseline));
+            if let Some(outlined) = self.font.outline_glyph(glyph) {
+                let bounds = outlined.px_bounds();
+                outlined.draw(|gx, gy, coverage| {
+                    let px = bounds.min.x as i64 + i64::from(gx);
+                    let py = bounds.min.y as i64 + i64::from(gy);
+                    if px >= 0
+                        && py >= 0
+                        && (px as u32) < canvas.width()
+                        && (py as u32) < canvas.height()
+                    {
+                        blend(
+                            canvas.get_pixel_mut(px as u32, py as u32),
+                            self.color,
+                            coverage,
+                        );
+                    }
+                });
+            }
+            caret += advance;
+            previous = Some(id);
+        }
+    }
+}
+
+/// Up to five rows fill the width, more are split into two columns.
+fn draw(
+    card: &ShareCard,
+    font: Option<&FontArc>,
+    avatars: &[Option<Bytes>],
+) -> Result<Vec<u8>, image::ImageError> {
+    let mut canvas = RgbaImage::from_pixel(WIDTH, HEIGHT, BACKGROUND);
+    fill_rect(&mut canvas, 0, 0, WIDTH, 8, ACCENT);
+    if let Some(font) = font {
+        let width = WIDTH - 2 * MARGIN;
+        let title = Pen {
+            font,
+            size: 46.0,
+            color: TEXT,
+        };
+        title.write(&mut canvas, MARGIN, 40, width, &card.title);
+        let subtitle = Pen {
+            font,
+            size: 26.0,
+            color: MUTED,
+        };
+        subtitle.write(&mut canvas, MARGIN, 100, width, &card.subtitle);
+    }
+
+    let columns = card.rows.len().div_ceil(ROWS_PER_COLUMN).max(1) as u32;
+    let gap = 40;
+    let column_width = (WIDTH - 2 * MARGIN - gap * (columns - 1)) / columns;
+    for (i, row) in card.rows.iter().enumerate() {
+        let x = MARGIN + (i / ROWS_PER_COLUMN) as u32 * (column_width + gap);
+        let y = 160 + (i % ROWS_PER_COLUMN) as u32 * ROW_HEIGHT;
+        let avatar_x = x + 56;
+        let text_x = avatar_x + AVATAR_EDGE + 16;
+        let bar_width = column_width.saturating_sub(text_x - x + 100);
+
+        let avatar = avatars
+            .get(i)
+            .and_then(Option::as_ref)
+            .and_then(|bytes| image::load_from_memory(bytes).ok());
+        match avatar {
+            Some(avatar) => {
+                let avatar = imageops::resize(
+                    &avatar.to_rgba8(),
+                    AVATAR_EDGE,
+                    AVATAR_EDGE,
+                    imageops::FilterType::Triangle,
+                );
+                imageops::overlay(&mut canvas, &avatar, avatar_x.into(), (y + 4).into());
+            }
+            None => fill_rect(
+                &mut canvas,
+                avatar_x,
+                y + 4,
+                AVATAR_EDGE,
+                AVATAR_EDGE,
+                TRACK,
+            ),
+        }
+
+        fill_rect(&mut canvas, text_x, y + 54, bar_width, 14, TRACK);
+        let filled = (f64::from(bar_width) * row.rate.clamp(0.0, 100.0) / 100.0) as u32;
+        fill_rect(&mut canvas, text_x, y + 54, filled, 14, ACCENT);
+
+        if let Some(font) = font {
+            let rank = Pen {
+                font,
+                size: 34.0,
+                color: ACCENT,
+            };
+            rank.write(&mut canvas, x, y + 20, 52, &(i + 1).to_string());
+            let name = Pen {
+                font,
+                size: 28.0,
+                color: TEXT,
+            };
+            let name_width = column_width.saturating_sub(text_x - x);
+            name.write(&mut canvas, text_x, y + 10, name_width, &row.name);
+            let rate = Pen {
+                font,
+                size: 22.0,
+                color: MUTED,
+            };
+            let rate_x = text_x + bar_width + 12;
+            rate.write(
+                &mut canvas,
+                rate_x,
+                y + 48,
+                88,
+                &format!("{:.1}%", row.rate),
+            );
+        }
+    }
+
+    let mut png = Vec::new();
+    canvas.write_to(&mut Cursor::new(&mut png), ImageFormat::Png)?;
+    Ok(png)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn cards_render_without_a_font_or_avatars() {
+        let card = ShareCard {
+            title: "年度干员".to_string(),
+            subtitle: "前 7 名".to_string(),
+            rows: (0..7)
+                .map(|i| ShareCardRow {
+                    id: i,
+                    name: format!("operator {i}"),
+                    rate: 90.0 - f64::from(i) * 10.0,
+                })
+                .collect(),
+        };
+
+        let png = draw(&card, None, &[]).unwrap();
+        let image = image::load_from_memory(&png).unwrap();
+        assert_eq!((image.width(), image.height()), (WIDTH, HEIGHT));
+    }
+}
@@ -3,13 +3,14 @@ use std::{sync::Arc, time::Instant};
 use chrono::{DateTime, Utc};
 use dashmap::DashMap;
 use futures::TryStreamExt as _;
-use mongodb::{Collection, bson::doc};
+use mongodb::{ClientSession, Collection, bson::doc};
 use parking_lot::RwLock;
 use share::models::{
     database::{CreateTopicStatus, TopicAuditInfo, VotingTopic},
     excel::CharacterInfo,
 };
 use tokio::sync::RwLock as AsyncRwLock;
+use tracing::Instrument as _;
 
 use crate::error::AppError;
 
@@ -30,9 +31,13 @@ impl CacheEntry {
     }
 
     fn access(&self) -> VotingTopic {
-        *self.last_accessed.write() = Instant::now();
+        self.touch();
         self.data.clone()
     }
+
+    fn touch(&self) {
+        *self.last_accessed.write() = Instant::now();
+    }
 }
 
 #[derive(Clone)]
@@ -46,6 +51,15 @@ impl TopicCache {
         self.cache.get(topic_id).map(|entry| entry.access())
     }
 
+    /// `None` when the pool is not cached yet.
+    pub fn pool_contains(&self, topic_id: &str, ids: &[i32]) -> Option<bool> {
+        let entry = self.cache.get(topic_id)?;
+        if entry.pool.is_empty() {
+            return None;
+        }
+        Some(ids.iter().all(|id| entry.pool.contains(id)))
+    }
+
     pub fn get_pool(&self, topic_id: &str) -> Option<Vec<i32>> {
         self.cache.get(topic_id).map(|entry| entry.pool.clone())
     }
@@ -95,6 +109,13 @@ impl TopicCache {
         inserted_count
     }
 
+    pub fn topics(&self) -> Vec<VotingTopic> {
+        self.cache
+            .iter()
+            .map(|entry| entry.value().data.clone())
+            .collect()
+    }
+
     pub fn get_active_topic_ids(&self) -> Vec<String> {
         self.cache
             .iter()
@@ -140,11 +161,6 @@ impl TopicService {
         };
         let refresh_lock = Arc::new(AsyncRwLock::new(()));
 
-        tokio::spawn(Self::cache_updater(
-            topic_collection.clone(),
-            topic_cache.clone(),
-        ));
-
         Self {
             topic_collection,
             cache: topic_cache,
@@ -152,8 +168,16 @@ impl TopicService {
         }
     }
 
-    pub async fn create_topic(&self, topic: &VotingTopic) -> Result<(), AppError> {
-        self.topic_collection.insert_one(topic).await?;
+    pub async fn create_topic(
+        &self,
+        topic: &VotingTopic,
+        session: Option<&mut ClientSession>,
+    ) -> Result<(), AppError> {
+        let mut insert = self.topic_collection.insert_one(topic);
+        if let Some(session) = session {
+            insert = insert.session(session);
+        }
+        insert.await?;
 
         Ok(())
     }
@@ -183,7 +207,16 @@ impl TopicService {
         let _read_lock = self.refresh_lock.read().await;
         let filter = doc! { "id": topic_id };
 
-        if let Some(topic) = self.topic_collection.find_one(filter).await? {
+        let found = self
+            .topic_collection
+            .find_one(filter)
+            .instrument(tracing::info_span!(
+                "mongo.find_topic",
+                otel.kind = "client",
+                topic_id
+            ))
+            .await?;
+        if let Some(topic) = found {
             self.cache.insert(&topic);
             Ok(Some(topic))
         } else {
@@ -193,6 +226,47 @@ impl TopicService {
         }
     }
 
+    /// Like [`Self::get_topic`] but borrows cached topics instead of cloning
+    /// them, for checks on the ballot path.
+    pub async fn with_topic<R>(
+        &self,
+        topic_id: &str,
+        f: impl FnOnce(&VotingTopic) -> R,
+    ) -> Result<Option<R>, AppError> {
+        if let Some(entry) = self.cache.cache.get(topic_id) {
+            entry.touch();
+            return Ok(Some(f(&entry.data)));
+        }
+        // not cached, loading it caches it for the next ballot
+        Ok(self.get_topic(topic_id).await?.map(|topic| f(&topic)))
+    }
+
+    pub fn cached_topics(&self) -> Vec<VotingTopic> {
+        self.cache.topics()
+    }
+
+    /// Replaces the cached copy with the stored topic, regardless of
+    /// `updated_at`, so changes made by other instances show up at once.
+    pub async fn reload_topic(&self, topic_id: &str) -> Result<Option<VotingTopic>, AppError> {
+        let topic = self
+            .topic_collection
+            .find_one(doc! { "id": topic_id })
+            .await?;
+
+        match &topic {
+            Some(topic) => {
+                self.cache
+                    .cache
+                    .insert(topic_id.to_string(), CacheEntry::new(topic.clone()));
+            }
+            None => {
+                self.cache.cache.remove(topic_id);
+            }
+        }
+
+        Ok(topic)
+    }
+
     pub async fn get_active_topic_ids(&self) -> Result<Vec<String>, AppError> {
         Ok(self.cache.get_active_topic_ids())
     }
@@ -209,10 +283,13 @@ impl TopicService {
         Ok(topics)
     }
 
+    /// Only writes the status, the cached copy is replaced by
+    /// [`Self::reload_topic`] once the session is committed.
     pub async fn audit_topic(
         &self,
         topic_id: &str,
         audit_info: TopicAuditInfo,
+        session: Option<&mut ClientSession>,
     ) -> Result<(), AppError> {
         let status = if audit_info.is_approved() {
             CreateTopicStatus::Approved(audit_info)
@@ -228,10 +305,11 @@ impl TopicService {
             }
         };
 
-        self.topic_collection.update_one(filter, update).await?;
-        if let Some(topic) = self.get_topic(topic_id).await? {
-            self.cache.insert(&topic);
+        let mut update = self.topic_collection.update_one(filter, update);
+        if let Some(session) = session {
+            update = update.session(session);
         }
+        update.await?;
 
         Ok(())
     }
@@ -249,7 +327,7 @@ impl TopicService {
 
         match self.get_topic(topic_id).await {
             Ok(Some(topic)) => {
-                let pool = topic.candidate_pool.generate_pool(character_infos);
+                let pool = topic.generate_pool(character_infos);
                 if !pool.is_empty() {
                     self.cache.cache_topic_pool(topic_id, pool.clone());
                     Some(pool)
@@ -262,7 +340,23 @@ impl TopicService {
         }
     }
 
-    pub async fn _refresh_cache(&self) -> Result<usize, AppError> {
+    /// Whether every id is in the candidate pool, without copying the pool.
+    pub async fn pool_contains(
+        &self,
+        topic_id: &str,
+        ids: &[i32],
+        character_infos: &[CharacterInfo],
+    ) -> bool {
+        if let Some(contains) = self.cache.pool_contains(topic_id, ids) {
+            return contains;
+        }
+
+        self.get_candidate_pool(topic_id, character_infos)
+            .await
+            .is_some_and(|pool| ids.iter().all(|id| pool.contains(id)))
+    }
+
+    pub async fn refresh_cache(&self) -> Result<usize, AppError> {
         let _write_lock = self.refresh_lock.write().await;
         self._update_cache_internal().await
     }
@@ -282,6 +376,11 @@ impl TopicService {
         Ok(inserted_count)
     }
 
+    /// Warms the cache and keeps it in sync, run as a background job.
+    pub fn keep_cache_fresh(&self) -> impl Future<Output = ()> + Send + 'static {
+        Self::cache_updater(self.topic_collection.clone(), self.cache.clone())
+    }
+
     async fn cache_updater(topic_collection: Collection<VotingTopic>, topic_cache: TopicCache) {
         const CACHE_UPDATE_INTERVAL: std::time::Duration = std::time::Duration::from_secs(1);
 
@@ -404,6 +503,7 @@ mod tests {
         let db = client.database("test_db");
 
         let topic_service = TopicService::new(db.clone());
+        tokio::spawn(topic_service.keep_cache_fresh());
 
         let test_topic = VotingTopic {
             id: "test_topic_1".to_string(),
@@ -415,6 +515,7 @@ mod tests {
                 rarities: vec![RarityRank::Tier6],
                 include_not_obtainable: false,
             },
+            excluded_operator_ids: Vec::new(),
             created_at: chrono::Utc::now(),
             updated_at: None,
             open_time: chrono::Utc::now(),
@@ -424,7 +525,7 @@ mod tests {
         };
 
         // Test create_topic
-        topic_service.create_topic(&test_topic).await.unwrap();
+        topic_service.create_topic(&test_topic, None).await.unwrap();
 
         // Test get_topic_by_id
         let fetched_topic = topic_service
@@ -0,0 +1,155 @@
+use chrono::Utc;
+use redis::AsyncCommands as _;
+use share::{
+    config::NotificationKind,
+    events::{DomainEvent, DomainEventKind, TopicPhase},
+    models::database::{CreateTopicStatus, VotingTopic},
+};
+
+use crate::{
+    error::AppError,
+    notify::Notification,
+    outbox::Outbox,
+    service::{
+        BookmarkService, DatasetService, NotificationService, ResultsArchiveService, TopicService,
+        WebhookService,
+    },
+};
+
+/// Emits open/close transitions of approved topics and publishes the result
+/// once a topic closes. The last seen phase lives in redis so only one
+/// instance reports a given transition, the scheduler runs the checks.
+pub struct TopicPhaseWatcher {
+    topic_service: TopicService,
+    redis: redis::aio::MultiplexedConnection,
+    outbox: Outbox,
+    webhooks: WebhookService,
+    notifications: NotificationService,
+    datasets: DatasetService,
+    archives: ResultsArchiveService,
+    bookmarks: BookmarkService,
+}
+
+impl TopicPhaseWatcher {
+    pub fn new(
+        topic_service: TopicService,
+        redis: redis::aio::MultiplexedConnection,
+        outbox: Outbox,
+        webhooks: WebhookService,
+        notifications: NotificationService,
+        datasets: DatasetService,
+        archives: ResultsArchiveService,
+        bookmarks: BookmarkService,
+    ) -> Self {
+        Self {
+            topic_service,
+            redis,
+            outbox,
+            webhooks,
+            notifications,
+            datasets,
+            archives,
+            bookmarks,
+        }
+    }
+
+    pub async fn check_all(&self) {
+        for topic in self.topic_service.cached_topics() {
+            if let Err(e) = self.check(&topic).await {
+                tracing::warn!("failed to check phase of topic {}: {}", topic.id, e);
+            }
+        }
+    }
+
+    fn phase_of(topic: &VotingTopic) -> Option<TopicPhase> {
+        if !topic.is_active || !matches!(topic.status, CreateTopicStatus::Approved(_)) {
+            return None;
+        }
+
+        let now = Utc::now();
+        if now < topic.open_time {
+            None
+        } else if now <= topic.close_time {
+            Some(TopicPhase::Open)
+        } else {
+            Some(TopicPhase::Closed)
+        }
+    }
+
+    fn announcement(topic: &VotingTopic, phase: TopicPhase) -> Notification {
+        let (subject, body) = match phase {
+            TopicPhase::Open => (
+                format!("Voting opened: {}", topic.title),
+                format!(
+                    "{} is open for votes until {}.",
+                    topic.title,
+                    topic.close_time.to_rfc3339()
+                ),
+            ),
+            _ => (
+                format!("Voting closed: {}", topic.title),
+                format!("{} is closed, the results are published.", topic.title),
+            ),
+        };
+        Notification::new(NotificationKind::TopicLifecycle, subject, body)
+    }
+
+    async fn check(&self, topic: &VotingTopic) -> Result<(), AppError> {
+        let Some(phase) = Self::phase_of(topic) else {
+            return Ok(());
+        };
+
+        let mut conn = self.redis.clone();
+        let previous: Option<String> = redis::cmd("SET")
+            .arg(format!("{}:phase", topic.id))
+            .arg(phase.as_str())
+            .arg("GET")
+            .query_async(&mut conn)
+            .await?;
+        let previous = previous.as_deref().and_then(TopicPhase::parse);
+
+        if previous == Some(phase) {
+            return Ok(());
+        }
+        // topics that closed before the watcher existed are not announced again
+        if previous.is_none() && phase == TopicPhase::Closed {
+            return Ok(());
+        }
+
+        let mut events = vec![DomainEvent::new(DomainEventKind::TopicTransition {
+            topic_id: topic.id.clone(),
+            from: previous.or(Some(TopicPhase::Approved)),
+            to: phase,
+        })];
+
+        if phase == TopicPhase::Open
+            && let Err(e) = self.bookmarks.topic_opened(topic).await
+        {
+            tracing::warn!("Failed to queue the reminders of {}: {}", topic.id, e);
+        }
+        if phase == TopicPhase::Closed {
+            let valid_ballots_count: Option<i64> = conn
+                .get(format!("{}:valid_ballots_count", topic.id))
+                .await?;
+            events.push(DomainEvent::new(DomainEventKind::ResultPublished {
+                topic_id: topic.id.clone(),
+                valid_ballots_count: valid_ballots_count.unwrap_or(0),
+            }));
+            if let Err(e) = self.datasets.topic_closed(topic).await {
+                tracing::warn!("Failed to queue the dataset of {}: {}", topic.id, e);
+            }
+            if let Err(e) = self.archives.topic_closed(topic).await {
+                tracing::warn!("Failed to queue the results archive of {}: {}", topic.id, e);
+            }
+        }
+
+        for event in &events {
+            self.webhooks.dispatch(event).await;
+        }
+        self.notifications
+            .notify(Self::announcement(topic, phase))
+            .await;
+        self.outbox.publish(events).await?;
+        Ok(())
+    }
+}
@@ -0,0 +1,96 @@
+use futures::StreamExt as _;
+use share::models::live::{LiveEvent, TOPIC_CHANGE_SUBJECT, TopicChange, TopicChangeKind};
+
+use crate::{
+    error::AppError,
+    live::LiveHub,
+    service::{OperatorService, TopicService},
+};
+
+/// Propagates topic changes to every running instance over core NATS. The
+/// periodic cache refresh in [`TopicService`] stays as a fallback for lost
+/// messages.
+#[derive(Clone)]
+pub struct TopicSync {
+    nats: async_nats::Client,
+    topic_service: TopicService,
+    operator_service: OperatorService,
+    live_hub: LiveHub,
+}
+
+impl TopicSync {
+    pub fn new(
+        nats: async_nats::Client,
+        topic_service: TopicService,
+        operator_service: OperatorService,
+        live_hub: LiveHub,
+    ) -> Self {
+        Self {
+            nats,
+            topic_service,
+            operator_service,
+            live_hub,
+        }
+    }
+
+    pub async fn notify(&self, topic_id: &str, kind: TopicChangeKind) {
+        let change = TopicChange {
+            topic_id: topic_id.to_string(),
+            kind,
+        };
+        let payload = match serde_json::to_vec(&change) {
+            Ok(payload) => payload,
+            Err(e) => {
+                tracing::warn!("failed to serialize topic change: {}", e);
+                return;
+            }
+        };
+
+        if let Err(e) = self
+            .nats
+            .publish(TOPIC_CHANGE_SUBJECT, payload.into())
+            .await
+        {
+            // the sender still applies its own change, others catch up on refresh
+            tracing::warn!("failed to publish topic change for {}: {}", topic_id, e);
+            self.apply(change).await;
+        }
+    }
+
+    async fn apply(&self, change: TopicChange) {
+        // the pool is rebuilt from the operators on the next lookup
+        if change.kind == TopicChangeKind::PoolChanged
+            && let Err(e) = self.operator_service.refresh().await
+        {
+            tracing::warn!("failed to reload operators: {}", e);
+        }
+        if let Err(e) = self.topic_service.reload_topic(&change.topic_id).await {
+            tracing::warn!("failed to reload topic {}: {}", change.topic_id, e);
+        }
+
+        self.live_hub.broadcast(
+            &change.topic_id,
+            LiveEvent::TopicChanged {
+                topic_id: change.topic_id.clone(),
+                kind: change.kind,
+            },
+        );
+    }
+
+    pub async fn run(self) -> Result<(), AppError> {
+        let mut subscriber = self
+            .nats
+            .subscribe(TOPIC_CHANGE_SUBJECT)
+            .await
+            .map_err(|e| AppError::InternalError(format!("topic change subscribe failed: {e}")))?;
+
+        while let Some(message) = subscriber.next().await {
+            match serde_json::from_slice::<TopicChange>(&message.payload) {
+                Ok(change) => self.apply(change).await,
+                Err(e) => tracing::warn!("invalid topic change message: {}", e),
+            }
+        }
+
+        Ok(())
+    }
+}
@@ -0,0 +1,268 @@
+use std::{
+    collections::HashMap,
+    sync::Arc,
+    time::{Duration, Instant},
+};
+
+use chrono::Utc;
+use dashmap::DashMap;
+use futures::TryStreamExt as _;
+use mongodb::bson::{Document, doc};
+use serde::Deserialize;
+use share::{config::TrendsConfig, reload::ConfigWatch};
+
+use super::{digest::ranking, heatmap::boundary};
+use crate::{error::AppError, service::ResultsSnapshotService};
+
+#[derive(Debug, Deserialize)]
+struct PairKey {
+    win: i32,
+    lose: i32,
+}
+
+#[derive(Debug, Deserialize)]
+struct Pair {
+    #[serde(rename = "_id")]
+    key: PairKey,
+    weight: i64,
+}
+
+/// A lower ranked option ahead of a much higher ranked one head to head.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Upset {
+    pub winner: i32,
+    pub winner_rank: u32,
+    pub loser: i32,
+    pub loser_rank: u32,
+    /// Net votes of the winner over the loser.
+    pub margin: i64,
+}
+
+/// An option whose win rate in the window differs from the one before.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Momentum {
+    pub id: i32,
+    pub rate_before: f64,
+    pub recent_rate: f64,
+    pub recent_battles: i64,
+}
+
+impl Momentum {
+    pub fn shift(&self) -> f64 {
+        self.recent_rate - self.rate_before
+    }
+}
+
+#[derive(Debug, Default)]
+pub struct Trends {
+    pub upsets: Vec<Upset>,
+    pub momentum: Vec<Momentum>,
+}
+
+fn rate(win: i64, lose: i64) -> Option<f64> {
+    (win + lose > 0).then(|| win as f64 * 100.0 / (win + lose) as f64)
+}
+
+/// The matchups a lower ranked option leads by `min_margin` against one
+/// `min_rank_gap` ranks above it, the largest gap first.
+fn upsets(ranking: &[i32], matrix: &HashMap<String, i64>, config: &TrendsConfig) -> Vec<Upset> {
+    let ranks: HashMap<i32, u32> = ranking
+        .iter()
+        .enumerate()
+        .map(|(rank, &id)| (id, rank as u32 + 1))
+        .collect();
+    let mut upsets: Vec<Upset> = matrix
+        .iter()
+        .filter(|(_, margin)| **margin >= config.upset_min_margin)
+        .filter_map(|(pair, &margin)| {
+            let (winner, loser) = pair.split_once(':')?;
+            let (winner, loser) = (winner.parse().ok()?, loser.parse().ok()?);
+            let (winner_rank, loser_rank) = (*ranks.get(&winner)?, *ranks.get(&loser)?);
+            (winner_rank >= loser_rank + config.upset_min_rank_gap).then_some(Upset {
+                winner,
+                winner_rank,
+                loser,
+                loser_rank,
+                margin,
+            })
+        })
+        .collect();
+    upsets.sort_by(|a, b| {
+        (b.winner_rank - b.loser_rank)
+            .cmp(&(a.winner_rank - a.loser_rank))
+            .then(b.margin.cmp(&a.margin))
+            .then(a.loser_rank.cmp(&b.loser_rank))
+    });
+    upsets.truncate(config.max_upsets);
+    upsets
+}
+
+/// The options whose win rate in the window moved by `momentum_min_shift`
+/// points from the one before it, the largest shift first. `stats` are the
+/// totals including the window.
+fn momentum(
+    stats: &HashMap<i32, (i64, i64)>,
+    recent: &HashMap<i32, (i64, i64)>,
+    config: &TrendsConfig,
+) -> Vec<Momentum> {
+    let mut momentum: Vec<Momentum> = recent
+        .iter()
+        .filter(|(_, (win, lose))| win + lose >= config.momentum_min_battles)
+        .filter_map(|(&id, &(recent_win, recent_lose))| {
+            let (win, lose) = stats.get(&id).copied()?;
+            let momentum = Momentum {
+                id,
+                rate_before: rate(win - recent_win, lose - recent_lose)?,
+                recent_rate: rate(recent_win, recent_lose)?,
+                recent_battles: recent_win + recent_lose,
+            };
+            (momentum.shift().abs() >= config.momentum_min_shift).then_some(momentum)
+        })
+        .collect();
+    momentum.sort_by(|a, b| {
+        b.shift()
+            .abs()
+            .partial_cmp(&a.shift().abs())
+            .unwrap_or(std::cmp::Ordering::Equal)
+            .then(a.id.cmp(&b.id))
+    });
+    momentum.truncate(config.max_momentum);
+    momentum
+}
+
+/// Upsets of the ranking and the options gaining or losing ground in the
+/// last hours of a pairwise topic. The window is read from the ballots
+/// stored in it, by their object ids, so results are kept per topic and
+/// window for `trends.cache_secs`.
+#[derive(Clone)]
+pub struct TrendService {
+    mongo: mongodb::Database,
+    results_snapshots: ResultsSnapshotService,
+    cache: Arc<DashMap<(String, u32), (Instant, Arc<Trends>)>>,
+    reload: ConfigWatch,
+}
+
+impl TrendService {
+    pub fn new(
+        mongo: mongodb::Database,
+        results_snapshots: ResultsSnapshotService,
+        reload: ConfigWatch,
+    ) -> Self {
+        Self {
+            mongo,
+            results_snapshots,
+            cache: Arc::new(DashMap::new()),
+            reload,
+        }
+    }
+
+    /// Wins and losses by option in the last `hours`, weighted like the
+    /// rankings.
+    async fn recent(
+        &self,
+        topic_id: &str,
+        hours: u32,
+    ) -> Result<HashMap<i32, (i64, i64)>, AppError> {
+        let since = Utc::now() - chrono::Duration::hours(hours.into());
+        let pairs: Vec<Pair> = self
+            .mongo
+            .collection::<Document>(&format!("ballots_{topic_id}"))
+            .aggregate([
+                doc! { "$match": {
+                    "_id": { "$gte": boundary(since) },
+                    "topic_type": "pairwise",
+                    "audit": { "$ne": "pending" },
+                    "multiplier": { "$gt": 0 },
+                } },
+                doc! { "$group": {
+                    "_id": { "win": "$win", "lose": "$lose" },
+                    "weight": { "$sum": { "$toLong": "$multiplier" } },
+                } },
+            ])
+            .with_type::<Pair>()
+            .await?
+            .try_collect()
+            .await?;
+
+        let mut recent: HashMap<i32, (i64, i64)> = HashMap::new();
+        for pair in pairs {
+            recent.entry(pair.key.win).or_default().0 += pair.weight;
+            recent.entry(pair.key.lose).or_default().1 += pair.weight;
+        }
+        Ok(recent)
+    }
+
+    /// `hours` is clamped to `trends.max_momentum_hours`.
+    pub async fn trends(&self, topic_id: &str, hours: u32) -> Result<Arc<Trends>, AppError> {
+        let config = self.reload.current().trends.clone();
+        let hours = hours.clamp(1, config.max_momentum_hours);
+        let key = (topic_id.to_string(), hours);
+        let ttl = Duration::from_secs(config.cache_secs);
+        if let Some(entry) = self.cache.get(&key)
+            && entry.0.elapsed() < ttl
+        {
+            return Ok(entry.1.clone());
+        }
+
+        let snapshot = self.results_snapshots.get(topic_id).await?;
+        let recent = self.recent(topic_id, hours).await?;
+        let trends = Arc::new(Trends {
+            upsets: upsets(&ranking(&snapshot.stats), &snapshot.matrix, &config),
+            momentum: momentum(&snapshot.stats, &recent, &config),
+        });
+        self.cache.retain(|_, (at, _)| at.elapsed() < ttl);
+        self.cache.insert(key, (Instant::now(), trends.clone()));
+        Ok(trends)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn upsets_and_momentum_are_flagged() {
+        let config = TrendsConfig {
+            upset_min_rank_gap: 2,
+            upset_min_margin: 2,
+            momentum_min_battles: 10,
+            momentum_min_shift: 10.0,
+            ..Default::default()
+        };
+
+        let ranking = [1, 2, 3, 4];
+        let matrix = HashMap::from([
+            // 4 leads 1 by 3 three ranks up, 3 leads 1 by 5 two ranks up
+            ("4:1".to_string(), 3),
+            ("1:4".to_string(), -3),
+            ("3:1".to_string(), 5),
+            ("1:3".to_string(), -5),
+            // one rank apart, not an upset
+            ("3:2".to_string(), 9),
+            ("2:3".to_string(), -9),
+            // too close
+            ("4:2".to_string(), 1),
+            ("2:4".to_string(), -1),
+        ]);
+        let found: Vec<(i32, i32)> = upsets(&ranking, &matrix, &config)
+            .iter()
+            .map(|upset| (upset.winner, upset.loser))
+            .collect();
+        assert_eq!(found, vec![(4, 1), (3, 1)]);
+
+        let stats = HashMap::from([(1, (60, 40)), (2, (50, 50)), (3, (30, 30))]);
+        let recent = HashMap::from([
+            // 40% in the window after 65% before
+            (1, (8, 12)),
+            // 50% throughout
+            (2, (10, 10)),
+            // too few battles
+            (3, (5, 0)),
+        ]);
+        let moved = momentum(&stats, &recent, &config);
+        assert_eq!(moved.len(), 1);
+        assert_eq!(moved[0].id, 1);
+        assert_eq!(moved[0].recent_battles, 20);
+        assert!((moved[0].shift() + 25.0).abs() < 1e-9);
+    }
+}
@@ -0,0 +1,230 @@
+use std::time::Duration;
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use mongodb::{
+    Collection,
+    bson::{Document, doc},
+};
+use serde::{Deserialize, Serialize};
+use share::{
+    client_ip::voter_ip_forms,
+    models::{
+        api::ContentReportView,
+        database::{
+            AuditLogEntry, ConsentRecord, ContentReport, TaskPriority, TopicBookmark, TopicComment,
+            VoterExport,
+        },
+    },
+    reload::ConfigWatch,
+};
+use uuid::Uuid;
+
+use crate::{
+    error::AppError,
+    queue::{RetryPolicy, TaskQueue},
+    service::AuditLogService,
+};
+
+/// Kind of the queue tasks assembling one [`VoterExport`].
+const EXPORT_TASK: &str = "voter_export";
+
+const RETRY_POLICY: RetryPolicy = RetryPolicy {
+    max_attempts: 5,
+    initial_backoff: Duration::from_secs(30),
+    max_backoff: Duration::from_secs(600),
+};
+
+/// Payload of an [`EXPORT_TASK`].
+#[derive(Debug, Serialize, Deserialize)]
+struct ExportTask {
+    id: String,
+    /// Every form the address may be stored in.
+    ips: Vec<String>,
+}
+
+/// The json file handed out.
+#[derive(Serialize)]
+struct Archive<'a> {
+    ip: &'a str,
+    generated_at: chrono::DateTime<Utc>,
+    truncated: bool,
+    /// The stored ballots as they are, each with the topic it was cast in.
+    ballots: Vec<serde_json::Value>,
+    consents: Vec<ConsentRecord>,
+    /// As stored, the taken down ones included.
+    comments: Vec<TopicComment>,
+    /// The reports the address is among the reporters of.
+    reports: Vec<ContentReportView>,
+    /// With the reminder addresses.
+    bookmarks: Vec<TopicBookmark>,
+}
+
+/// Assembles the ballots of a voter address for a data access request. There
+/// are no accounts, the address is all a ballot knows of its voter.
+#[derive(Clone)]
+pub struct VoterExportService {
+    mongo: mongodb::Database,
+    exports: Collection<VoterExport>,
+    queue: TaskQueue,
+    audit_log: AuditLogService,
+    reload: ConfigWatch,
+}
+
+impl VoterExportService {
+    pub fn new(
+        mongo: mongodb::Database,
+        queue: TaskQueue,
+        audit_log: AuditLogService,
+        reload: ConfigWatch,
+    ) -> Self {
+        let service = Self {
+            exports: mongo.collection::<VoterExport>("voter_exports"),
+            mongo,
+            queue,
+            audit_log,
+            reload,
+        };
+        service
+            .queue
+            .register(EXPORT_TASK, TaskPriority::Low, RETRY_POLICY, {
+                let service = service.clone();
+                move |task: ExportTask, _| {
+                    let service = service.clone();
+                    async move { service.assemble(task).await.map_err(|e| e.to_string()) }
+                }
+            });
+
+        service
+    }
+
+    /// Queues the export of `ip` and returns its id.
+    pub async fn request(&self, ip: &str, actor: &str) -> Result<String, AppError> {
+        let config = self.reload.current();
+        let export = VoterExport {
+            id: Uuid::new_v4().to_string(),
+            requested_by: actor.to_string(),
+            requested_at: Utc::now(),
+            completed_at: None,
+            ballots: 0,
+            truncated: false,
+            archive: None,
+        };
+        self.exports.insert_one(&export).await?;
+
+        let task = ExportTask {
+            id: export.id.clone(),
+            ips: voter_ip_forms(ip, &config.vote),
+        };
+        self.queue.enqueue(EXPORT_TASK, &task).await?;
+
+        self.audit_log
+            .record(AuditLogEntry::new(
+                None,
+                actor,
+                "voter_export_requested",
+                format!("export {}", export.id),
+            ))
+            .await?;
+
+        Ok(export.id)
+    }
+
+    pub async fn get(&self, id: &str) -> Result<Option<VoterExport>, AppError> {
+        Ok(self.exports.find_one(doc! { "id": id }).await?)
+    }
+
+    /// One attempt of an [`EXPORT_TASK`], repeating it replaces the archive.
+    async fn assemble(&self, task: ExportTask) -> Result<(), AppError> {
+        let max_ballots = self.reload.current().privacy.export_max_ballots;
+        let collections = self
+            .mongo
+            .list_collection_names()
+            .filter(doc! { "name": { "$regex": "^ballots_" } })
+            .await?;
+
+        let mut ballots = Vec::new();
+        let mut truncated = false;
+        for name in &collections {
+            let limit = max_ballots.saturating_sub(ballots.len() as u64);
+            let found: Vec<Document> = self
+                .mongo
+                .collection::<Document>(name)
+                .find(doc! { "info.ip": { "$in": &task.ips } })
+                .projection(doc! { "_id": 0 })
+                .sort(doc! { "info.timestamp": 1 })
+                .limit(limit.saturating_add(1) as i64)
+                .await?
+                .try_collect()
+                .await?;
+            truncated |= found.len() as u64 > limit;
+            ballots.extend(
+                found
+                    .into_iter()
+                    .take(limit as usize)
+                    .map(|ballot| mongodb::bson::Bson::Document(ballot).into_relaxed_extjson()),
+            );
+            if truncated {
+                break;
+            }
+        }
+
+        let consents = self
+            .mongo
+            .collection::<ConsentRecord>("consents")
+            .find(doc! { "ip": { "$in": &task.ips } })
+            .sort(doc! { "accepted_at": 1 })
+            .await?
+            .try_collect()
+            .await?;
+        let comments = self
+            .mongo
+            .collection::<TopicComment>("comments")
+            .find(doc! { "ip": { "$in": &task.ips } })
+            .sort(doc! { "id": 1 })
+            .await?
+            .try_collect()
+            .await?;
+        let reports = self
+            .mongo
+            .collection::<ContentReport>("content_reports")
+            .find(doc! { "reporters": { "$in": &task.ips } })
+            .sort(doc! { "first_reported_at": 1 })
+            .await?
+            .map_ok(ContentReportView::from)
+            .try_collect()
+            .await?;
+        let bookmarks = self
+            .mongo
+            .collection::<TopicBookmark>("topic_bookmarks")
+            .find(doc! { "ip": { "$in": &task.ips } })
+            .sort(doc! { "created_at": 1 })
+            .await?
+            .try_collect()
+            .await?;
+
+        let archive = Archive {
+            ip: &task.ips[0],
+            generated_at: Utc::now(),
+            truncated,
+            ballots,
+            consents,
+            comments,
+            reports,
+            bookmarks,
+        };
+        self.exports
+            .update_one(
+                doc! { "id": &task.id },
+                doc! { "$set": {
+                    "completed_at": mongodb::bson::to_bson(&archive.generated_at)?,
+                    "ballots": archive.ballots.len() as i64,
+                    "truncated": truncated,
+                    "archive": serde_json::to_string(&archive)?,
+                } },
+            )
+            .await?;
+
+        Ok(())
+    }
+}
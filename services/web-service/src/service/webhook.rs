@@ -0,0 +1,306 @@
+use std::time::{Duration, Instant};
+
+use chrono::Utc;
+use futures::TryStreamExt as _;
+use hmac::{Hmac, Mac as _};
+use mongodb::{Collection, bson::doc};
+use rand::{Rng as _, distr::Alphanumeric};
+use serde::{Deserialize, Serialize};
+use sha2::Sha256;
+use share::{
+    config::WebhookConfig,
+    events::{DomainEvent, DomainEventKind, TopicPhase},
+    http_client::HttpClient,
+    models::database::{TaskPriority, Webhook, WebhookDelivery, WebhookEvent},
+};
+use uuid::Uuid;
+
+use crate::{
+    error::AppError,
+    queue::{RetryPolicy, TaskQueue},
+};
+
+const WEBHOOK_SECRET_PREFIX: &str = "whsec_";
+const WEBHOOK_SECRET_RANDOM_LENGTH: usize = 32;
+const DELIVERY_LOG_DEFAULT_LIMIT: i64 = 50;
+const DELIVERY_LOG_MAX_LIMIT: i64 = 500;
+
+pub const SIGNATURE_HEADER: &str = "x-ark-vote-signature";
+pub const TIMESTAMP_HEADER: &str = "x-ark-vote-timestamp";
+pub const EVENT_HEADER: &str = "x-ark-vote-event";
+pub const DELIVERY_HEADER: &str = "x-ark-vote-delivery";
+
+/// Kind of the queue tasks delivering one event to one webhook.
+const DELIVERY_TASK: &str = "webhook_delivery";
+
+/// `sha256=<hex>` over `{timestamp}.{body}`, receivers should reject stale
+/// timestamps to prevent replays.
+pub fn sign_payload(secret: &str, timestamp: i64, body: &[u8]) -> String {
+    let mut mac =
+        Hmac::<Sha256>::new_from_slice(secret.as_bytes()).expect("hmac accepts keys of any length");
+    mac.update(timestamp.to_string().as_bytes());
+    mac.update(b".");
+    mac.update(body);
+    format!("sha256={}", hex::encode(mac.finalize().into_bytes()))
+}
+
+fn generate_secret() -> String {
+    let random: String = rand::rng()
+        .sample_iter(&Alphanumeric)
+        .take(WEBHOOK_SECRET_RANDOM_LENGTH)
+        .map(char::from)
+        .collect();
+    format!("{WEBHOOK_SECRET_PREFIX}{random}")
+}
+
+fn webhook_event(event: &DomainEvent) -> Option<WebhookEvent> {
+    match &event.kind {
+        DomainEventKind::TopicTransition {
+            to: TopicPhase::Open,
+            ..
+        } => Some(WebhookEvent::TopicStarted),
+        DomainEventKind::TopicTransition {
+            to: TopicPhase::Closed,
+            ..
+        } => Some(WebhookEvent::TopicEnded),
+        DomainEventKind::ResultPublished { .. } => Some(WebhookEvent::ResultPublished),
+        DomainEventKind::CommentPosted { .. } => Some(WebhookEvent::CommentPosted),
+        DomainEventKind::DigestPublished { .. } => Some(WebhookEvent::DigestPublished),
+        _ => None,
+    }
+}
+
+/// Payload of a [`DELIVERY_TASK`].
+#[derive(Debug, Serialize, Deserialize)]
+struct DeliveryTask {
+    webhook_id: String,
+    event: WebhookEvent,
+    event_id: String,
+    /// The serialized event, signed as it is.
+    body: String,
+}
+
+#[derive(Clone)]
+pub struct WebhookService {
+    webhooks: Collection<Webhook>,
+    deliveries: Collection<WebhookDelivery>,
+    http: HttpClient,
+    config: WebhookConfig,
+    queue: TaskQueue,
+}
+
+impl WebhookService {
+    pub fn new(
+        mongo: mongodb::Database,
+        config: WebhookConfig,
+        http: HttpClient,
+        queue: TaskQueue,
+    ) -> Self {
+        let service = Self {
+            webhooks: mongo.collection::<Webhook>("webhooks"),
+            deliveries: mongo.collection::<WebhookDelivery>("webhook_deliveries"),
+            http,
+            config,
+            queue,
+        };
+        service
+            .queue
+            .register(DELIVERY_TASK, TaskPriority::High, service.retry_policy(), {
+                let service = service.clone();
+                move |task: DeliveryTask, attempt| {
+                    let service = service.clone();
+                    async move { service.deliver(task, attempt).await }
+                }
+            });
+        service
+    }
+
+    /// Retries of [`DELIVERY_TASK`]s, from `[webhook]`.
+    fn retry_policy(&self) -> RetryPolicy {
+        RetryPolicy {
+            max_attempts: self.config.max_attempts.max(1),
+            initial_backoff: Duration::from_millis(self.config.initial_backoff_ms),
+            max_backoff: Duration::from_millis(self.config.max_backoff_ms),
+        }
+    }
+
+    pub async fn create(
+        &self,
+        url: String,
+        events: Vec<WebhookEvent>,
+        secret: Option<String>,
+    ) -> Result<Webhook, AppError> {
+        let webhook = Webhook {
+            id: Uuid::new_v4().to_string(),
+            url,
+            secret: secret.unwrap_or_else(generate_secret),
+            events,
+            enabled: true,
+            created_at: Utc::now(),
+        };
+        self.webhooks.insert_one(&webhook).await?;
+        tracing::info!("Registered webhook {} for {}", webhook.id, webhook.url);
+
+        Ok(webhook)
+    }
+
+    pub async fn delete(&self, id: &str) -> Result<bool, AppError> {
+        let result = self.webhooks.delete_one(doc! { "id": id }).await?;
+        Ok(result.deleted_count > 0)
+    }
+
+    pub async fn list(&self) -> Result<Vec<Webhook>, AppError> {
+        let mut cursor = self.webhooks.find(doc! {}).await?;
+        let mut webhooks = Vec::new();
+        while let Some(webhook) = cursor.try_next().await? {
+            webhooks.push(webhook);
+        }
+
+        Ok(webhooks)
+    }
+
+    pub async fn exists(&self, id: &str) -> Result<bool, AppError> {
+        Ok(self.webhooks.find_one(doc! { "id": id }).await?.is_some())
+    }
+
+    /// Newest delivery attempts first.
+    pub async fn deliveries(
+        &self,
+        webhook_id: &str,
+        limit: Option<i64>,
+    ) -> Result<Vec<WebhookDelivery>, AppError> {
+        let limit = limit
+            .unwrap_or(DELIVERY_LOG_DEFAULT_LIMIT)
+            .clamp(1, DELIVERY_LOG_MAX_LIMIT);
+        let mut cursor = self
+            .deliveries
+            .find(doc! { "webhook_id": webhook_id })
+            .sort(doc! { "created_at": -1 })
+            .limit(limit)
+            .await?;
+        let mut deliveries = Vec::new();
+        while let Some(delivery) = cursor.try_next().await? {
+            deliveries.push(delivery);
+        }
+
+        Ok(deliveries)
+    }
+
+    /// Deletes the delivery logs past the retention, run by the scheduler.
+    pub async fn prune_deliveries(&self) -> Result<u64, AppError> {
+        let cutoff =
+            Utc::now() - chrono::Duration::days(self.config.delivery_retention_days.into());
+        let result = self
+            .deliveries
+            .delete_many(doc! { "created_at": { "$lt": mongodb::bson::to_bson(&cutoff)? } })
+            .await?;
+        Ok(result.deleted_count)
+    }
+
+    /// Queues a delivery of the event to every subscribed webhook.
+    pub async fn dispatch(&self, event: &DomainEvent) {
+        let Some(kind) = webhook_event(event) else {
+            return;
+        };
+
+        let webhooks = match self
+            .webhooks
+            .find(doc! { "enabled": true, "events": kind.as_str() })
+            .await
+        {
+            Ok(cursor) => match cursor.try_collect::<Vec<_>>().await {
+                Ok(webhooks) => webhooks,
+                Err(e) => {
+                    tracing::warn!("Failed to load webhooks: {}", e);
+                    return;
+                }
+            },
+            Err(e) => {
+                tracing::warn!("Failed to load webhooks: {}", e);
+                return;
+            }
+        };
+        if webhooks.is_empty() {
+            return;
+        }
+
+        let body = match serde_json::to_string(event) {
+            Ok(body) => body,
+            Err(e) => {
+                tracing::warn!("Failed to serialize webhook payload: {}", e);
+                return;
+            }
+        };
+
+        for webhook in webhooks {
+            let task = DeliveryTask {
+                webhook_id: webhook.id,
+                event: kind,
+                event_id: event.id.to_string(),
+                body: body.clone(),
+            };
+            if let Err(e) = self.queue.enqueue(DELIVERY_TASK, &task).await {
+                tracing::warn!("Failed to queue webhook delivery: {}", e);
+            }
+        }
+    }
+
+    /// One attempt of a [`DELIVERY_TASK`], logged to the deliveries. Webhooks
+    /// deleted or disabled since the event are skipped.
+    async fn deliver(&self, task: DeliveryTask, attempt: u32) -> Result<(), String> {
+        let webhook = match self
+            .webhooks
+            .find_one(doc! { "id": &task.webhook_id, "enabled": true })
+            .await
+        {
+            Ok(Some(webhook)) => webhook,
+            Ok(None) => return Ok(()),
+            Err(e) => return Err(format!("failed to load webhook: {e}")),
+        };
+
+        let timestamp = Utc::now().timestamp();
+        let started = Instant::now();
+        let request = self
+            .http
+            .post(&webhook.url)
+            .timeout(Duration::from_millis(self.config.timeout_ms))
+            .header(reqwest::header::CONTENT_TYPE, "application/json")
+            .header(EVENT_HEADER, task.event.as_str())
+            .header(DELIVERY_HEADER, &task.event_id)
+            .header(TIMESTAMP_HEADER, timestamp.to_string())
+            .header(
+                SIGNATURE_HEADER,
+                sign_payload(&webhook.secret, timestamp, task.body.as_bytes()),
+            )
+            .body(task.body);
+        let result = self.http.send(request).await;
+
+        let (status_code, error) = match result {
+            Ok(response) if response.status().is_success() => {
+                (Some(response.status().as_u16()), None)
+            }
+            Ok(response) => (
+                Some(response.status().as_u16()),
+                Some(format!("unexpected status {}", response.status())),
+            ),
+            Err(e) => (None, Some(e.to_string())),
+        };
+
+        let delivery = WebhookDelivery {
+            webhook_id: webhook.id,
+            event_id: task.event_id,
+            event: task.event,
+            attempt,
+            success: error.is_none(),
+            status_code,
+            error: error.clone(),
+            duration_ms: started.elapsed().as_millis() as u64,
+            created_at: Utc::now(),
+        };
+        if let Err(e) = self.deliveries.insert_one(&delivery).await {
+            tracing::warn!("Failed to record webhook delivery: {}", e);
+        }
+
+        error.map_or(Ok(()), Err)
+    }
+}
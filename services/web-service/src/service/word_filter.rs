@@ -0,0 +1,111 @@
+use std::{
+    path::{Path, PathBuf},
+    sync::Arc,
+    time::SystemTime,
+};
+
+use parking_lot::RwLock;
+use share::{config::WordFilterAction, reload::ConfigWatch, word_filter::WordFilter};
+
+/// What [`WordFilterService::check`] did with a text.
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub enum FilterVerdict {
+    Clean,
+    /// The words were replaced in place.
+    Masked,
+    /// Kept as written, the caller holds it for a moderator.
+    Review,
+    /// The caller fails the request.
+    Reject,
+}
+
+/// The list in use and the file version it was built from.
+#[derive(Default)]
+struct LoadedList {
+    filter: Arc<WordFilter>,
+    source: Option<(PathBuf, SystemTime)>,
+}
+
+/// The `[word_filter]` list, re-read by [`Self::watch`] whenever the file or
+/// its configured path changes. A list which fails to load keeps the last
+/// one in use.
+#[derive(Clone)]
+pub struct WordFilterService {
+    list: Arc<RwLock<LoadedList>>,
+    reload: ConfigWatch,
+}
+
+impl WordFilterService {
+    pub fn new(reload: ConfigWatch) -> Self {
+        Self {
+            list: Arc::new(RwLock::new(LoadedList::default())),
+            reload,
+        }
+    }
+
+    /// Applies `action` to `text` if it contains a word of the list.
+    pub fn check(&self, action: WordFilterAction, text: &mut String) -> FilterVerdict {
+        if !self.reload.current().word_filter.enabled {
+            return FilterVerdict::Clean;
+        }
+        let filter = self.list.read().filter.clone();
+        if !filter.contains(text) {
+            return FilterVerdict::Clean;
+        }
+        match action {
+            WordFilterAction::Reject => FilterVerdict::Reject,
+            WordFilterAction::Review => FilterVerdict::Review,
+            WordFilterAction::Mask => {
+                *text = filter.mask(text).into_owned();
+                FilterVerdict::Masked
+            }
+        }
+    }
+
+    /// Keeps the list current, run as a background job.
+    pub async fn watch(self) {
+        loop {
+            let config = self.reload.current();
+            if config.word_filter.enabled {
+                self.refresh(&config.word_filter.list_path).await;
+            }
+            let interval = std::time::Duration::from_secs(config.word_filter.reload_secs);
+            tokio::time::sleep(interval).await;
+        }
+    }
+
+    async fn refresh(&self, path: &Path) {
+        let modified = match tokio::fs::metadata(path).await.and_then(|m| m.modified()) {
+            Ok(modified) => modified,
+            Err(e) => {
+                tracing::warn!("failed to read word list {}: {}", path.display(), e);
+                return;
+            }
+        };
+        let unchanged = self
+            .list
+            .read()
+            .source
+            .as_ref()
+            .is_some_and(|(loaded, at)| loaded == path && *at == modified);
+        if unchanged {
+            return;
+        }
+
+        match tokio::fs::read_to_string(path).await {
+            Ok(list) => {
+                let filter = WordFilter::parse(&list);
+                tracing::info!(
+                    "loaded {} sensitive words from {}",
+                    filter.len(),
+                    path.display()
+                );
+                *self.list.write() = LoadedList {
+                    filter: Arc::new(filter),
+                    source: Some((path.to_path_buf(), modified)),
+                };
+            }
+            Err(e) => tracing::warn!("failed to read word list {}: {}", path.display(), e),
+        }
+    }
+}
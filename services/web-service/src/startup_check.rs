@@ -0,0 +1,78 @@
+use std::time::Duration;
+
+use mongodb::bson::doc;
+use share::{
+    config::{AppConfig, Dependency},
+    http_client::HttpClient,
+};
+
+use crate::{health::check, utils};
+
+/// Probes every dependency with its own short-lived connection, before the
+/// real ones are made, so all of them are reported instead of the first
+/// that fails to connect.
+///
+/// Returns the ones which are down, an error when any of them is required.
+pub async fn run(config: &AppConfig, http: &HttpClient) -> eyre::Result<Vec<Dependency>> {
+    let check_config = &config.startup_check;
+    if !check_config.enabled {
+        return Ok(Vec::new());
+    }
+    let timeout = Duration::from_secs(check_config.timeout_secs.max(1));
+
+    let mongodb = check(timeout, async {
+        let client = mongodb::Client::with_uri_str(&config.database.mongodb_url).await?;
+        client
+            .database(&config.database.mongodb_database)
+            .run_command(doc! { "ping": 1 })
+            .await?;
+        Ok::<_, mongodb::error::Error>(())
+    });
+    let redis = check(timeout, async {
+        let client = redis::Client::open(&*config.database.redis_url)?;
+        let mut conn = client.get_multiplexed_async_connection().await?;
+        redis::cmd("PING").query_async::<()>(&mut conn).await
+    });
+    let nats = check(timeout, async {
+        async_nats::connect(&config.nats.url).await?.flush().await?;
+        Ok::<_, eyre::Error>(())
+    });
+    let prts = check(timeout, async {
+        http.send(http.get(utils::PORTRAIT_IMAGE_STORE_URL))
+            .await?
+            .error_for_status()
+            .map(|_| ())
+    });
+    let (mongodb, redis, nats, prts) = tokio::join!(mongodb, redis, nats, prts);
+
+    let mut down = Vec::new();
+    for (dependency, result) in Dependency::ALL
+        .into_iter()
+        .zip([mongodb, redis, nats, prts])
+    {
+        let required = check_config.required.contains(&dependency);
+        match result {
+            Ok(()) => tracing::info!(dependency = dependency.name(), required, "up"),
+            Err(e) => {
+                tracing::error!(dependency = dependency.name(), required, "down: {}", e);
+                down.push(dependency);
+            }
+        }
+    }
+
+    let missing: Vec<_> = down
+        .iter()
+        .filter(|dependency| check_config.required.contains(dependency))
+        .map(|dependency| dependency.name())
+        .collect();
+    if !missing.is_empty() {
+        eyre::bail!("required dependencies are down: {}", missing.join(", "));
+    }
+    if down.is_empty() {
+        tracing::info!("startup check passed");
+    } else {
+        tracing::warn!(?down, "starting with optional dependencies down");
+    }
+
+    Ok(down)
+}
@@ -1,36 +1,91 @@
-use std::{collections::HashMap, sync::Arc};
+use std::{
+    collections::HashMap,
+    sync::{Arc, atomic::AtomicBool},
+};
 
 use dashmap::DashMap;
 use share::{
-    models::{
-        api::{BallotSaveRequest, CharacterPortrait},
-        excel::CharacterInfo,
-    },
+    jobs::JobManager,
+    models::api::{BallotSaveRequest, CharacterPortrait},
+    reload::ConfigWatch,
     snowflake::Snowflake,
 };
 
-use crate::{service::TopicService, task::TaskManager};
+use crate::{
+    live::LiveHub,
+    middleware::route_stats::RouteStats,
+    outbox::Outbox,
+    queue::TaskQueue,
+    service::{
+        AffinityService, AnnouncementService, ApiKeyService, AuditLogService, BookmarkService,
+        CommentService, ConsentService, DatasetService, DigestService, ErasureService,
+        HeatmapService, ImageProxyService, MaintenanceService, MerkleService, OperatorService,
+        OptionImageService, PresenceService, RegionService, ReportService, ResultsArchiveService,
+        ResultsSnapshotService, RetentionService, ScheduledActions, ShareCardService, TopicService,
+        TopicSync, TrendService, VoterExportService, WebhookService, WordFilterService,
+    },
+    task::TaskManager,
+};
 
 #[derive(Clone)]
 pub struct RedisService {
     pub _client: redis::Client,
     pub connection: redis::aio::MultiplexedConnection,
-    pub final_order_script: redis::Script,
 }
 
 #[derive(Clone)]
 pub struct AppState {
     pub redis: RedisService,
     pub _mongodb: mongodb::Database,
+    pub nats: async_nats::Client,
     pub jetstream: async_nats::jetstream::Context,
     pub snowflake: Snowflake,
+    /// The running config, reloaded sections included.
+    pub config: ConfigWatch,
 
-    pub character_infos: Vec<CharacterInfo>,
     pub character_portraits: HashMap<i32, CharacterPortrait>,
 
     pub topic_service: TopicService,
+    pub topic_sync: TopicSync,
+    pub api_key_service: ApiKeyService,
+    pub audit_log_service: AuditLogService,
+    pub operator_service: OperatorService,
+    pub image_proxy_service: ImageProxyService,
+    pub option_image_service: OptionImageService,
+    pub live_hub: LiveHub,
+    pub presence_service: PresenceService,
+    pub region_service: RegionService,
+    pub results_snapshots: ResultsSnapshotService,
+    pub affinity_service: AffinityService,
+    pub digest_service: DigestService,
+    pub heatmap_service: HeatmapService,
+    pub trend_service: TrendService,
+    pub outbox: Outbox,
+    pub webhook_service: WebhookService,
+    pub task_queue: TaskQueue,
+    pub scheduled_actions: ScheduledActions,
+    pub erasure_service: ErasureService,
+    pub voter_export_service: VoterExportService,
+    pub consent_service: ConsentService,
+    pub comment_service: CommentService,
+    pub report_service: ReportService,
+    pub word_filter_service: WordFilterService,
+    pub announcement_service: AnnouncementService,
+    pub share_card_service: ShareCardService,
+    pub retention_service: RetentionService,
+    pub dataset_service: DatasetService,
+    pub results_archive: ResultsArchiveService,
+    pub bookmark_service: BookmarkService,
+    pub merkle_service: MerkleService,
+    pub route_stats: RouteStats,
+    pub maintenance: MaintenanceService,
 
     pub bench_ballot_store: DashMap<String, BallotSaveRequest>,
 
+    /// Set once shutdown starts, fails the readiness probe.
+    pub draining: AtomicBool,
+
     pub task_manager: Arc<TaskManager>,
+    /// The background jobs, listed by `/system/jobs`.
+    pub jobs: JobManager,
 }
@@ -0,0 +1,97 @@
+use std::path::{Component, Path, PathBuf};
+
+use share::config::{StorageBackend, StorageConfig};
+
+use crate::error::AppError;
+
+/// Where uploaded files end up. Keys are relative, `/` separated paths such
+/// as `topics/{topic}/options/{id}-{millis}.png`.
+#[derive(Clone)]
+pub enum Storage {
+    Local { root: PathBuf, public_url: String },
+}
+
+impl Storage {
+    pub fn from_config(config: &StorageConfig) -> Self {
+        match config.backend {
+            StorageBackend::Local => Storage::Local {
+                root: PathBuf::from(&config.local_root),
+                public_url: config.public_url.trim_end_matches('/').to_string(),
+            },
+        }
+    }
+
+    /// Rejects keys that would escape the storage root.
+    fn local_path(root: &Path, key: &str) -> Result<PathBuf, AppError> {
+        let relative = Path::new(key);
+        if !relative
+            .components()
+            .all(|component| matches!(component, Component::Normal(_)))
+        {
+            return Err(AppError::InternalError(format!(
+                "invalid storage key {key}"
+            )));
+        }
+
+        Ok(root.join(relative))
+    }
+
+    pub async fn put(&self, key: &str, bytes: &[u8]) -> Result<(), AppError> {
+        match self {
+            Storage::Local { root, .. } => {
+                let path = Self::local_path(root, key)?;
+                if let Some(parent) = path.parent() {
+                    tokio::fs::create_dir_all(parent).await?;
+                }
+                let tmp = path.with_extension("tmp");
+                tokio::fs::write(&tmp, bytes).await?;
+                tokio::fs::rename(&tmp, &path).await?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// `None` for a key never written or deleted since.
+    pub async fn get(&self, key: &str) -> Result<Option<Vec<u8>>, AppError> {
+        match self {
+            Storage::Local { root, .. } => {
+                match tokio::fs::read(Self::local_path(root, key)?).await {
+                    Ok(bytes) => Ok(Some(bytes)),
+                    Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(None),
+                    Err(e) => Err(e.into()),
+                }
+            }
+        }
+    }
+
+    pub async fn delete(&self, key: &str) -> Result<(), AppError> {
+        match self {
+            Storage::Local { root, .. } => {
+                match tokio::fs::remove_file(Self::local_path(root, key)?).await {
+                    Ok(()) => {}
+                    Err(e) if e.kind() == std::io::ErrorKind::NotFound => {}
+                    Err(e) => return Err(e.into()),
+                }
+            }
+        }
+
+        Ok(())
+    }
+
+    pub fn url(&self, key: &str) -> String {
+        match self {
+            Storage::Local { public_url, .. } => format!("{public_url}/{key}"),
+        }
+    }
+
+    /// Directory and path prefix the web server has to serve itself.
+    pub fn local_mount(&self) -> Option<(&Path, &str)> {
+        match self {
+            Storage::Local { root, public_url } if public_url.starts_with('/') => {
+                Some((root.as_path(), public_url.as_str()))
+            }
+            Storage::Local { .. } => None,
+        }
+    }
+}
@@ -1,20 +1,30 @@
-use futures::FutureExt;
 use serde::Serialize;
 use std::{
     future::Future,
-    panic::AssertUnwindSafe,
     pin::Pin,
     sync::{
         Arc,
         atomic::{AtomicUsize, Ordering},
     },
+    time::Duration,
 };
-use tokio::sync::{Semaphore, mpsc};
-use tracing::error;
+use tokio::sync::{Semaphore, mpsc, oneshot};
+use tracing::{Instrument as _, error};
+
+use crate::middleware::request_id;
 
 type BoxFuture = Pin<Box<dyn Future<Output = ()> + Send + 'static>>;
 type Task = Box<dyn FnOnce() -> BoxFuture + Send + 'static>;
 
+#[derive(thiserror::Error, Debug)]
+pub enum TaskError {
+    #[error("background task did not finish within {0:?}")]
+    Timeout(Duration),
+    /// The queue is closed or the task panicked.
+    #[error("background task ended without a result")]
+    Lost,
+}
+
 #[derive(Default, Debug, Clone, Serialize)]
 pub struct TaskStats {
     pub queued: usize,
@@ -57,13 +67,7 @@ impl TaskManager {
                     let stats_worker = stats_for_dispatch.clone();
 
                     tokio::spawn(async move {
-                        let fut = (task)();
-
-                        let res = AssertUnwindSafe(fut).catch_unwind().await;
-
-                        if let Err(e) = res {
-                            error!("Background task panicked: {:?}", e);
-                        }
+                        share::task::guarded("background_task", (task)()).await;
 
                         stats_worker.running.fetch_sub(1, Ordering::Relaxed);
                         stats_worker.completed.fetch_add(1, Ordering::Relaxed);
@@ -97,6 +101,43 @@ impl TaskManager {
         }
     }
 
+    /// Runs `f` on the pool and waits up to `timeout` for its result. The
+    /// result is delivered over a oneshot so the task never waits on its
+    /// caller, and a caller that times out or is dropped cancels the task,
+    /// before it starts or at its next await. The request id and span of the
+    /// caller carry over.
+    pub async fn run<T, Fut, F>(&self, timeout: Duration, f: F) -> Result<T, TaskError>
+    where
+        T: Send + 'static,
+        Fut: Future<Output = T> + Send + 'static,
+        F: FnOnce() -> Fut + Send + 'static,
+    {
+        let (tx, rx) = oneshot::channel();
+        let request_id = request_id::current();
+        let span = tracing::Span::current();
+
+        self.spawn(move || {
+            let mut tx = tx;
+            let task = async move {
+                if tx.is_closed() {
+                    return;
+                }
+                let result = tokio::select! {
+                    result = f() => result,
+                    _ = tx.closed() => return,
+                };
+                let _ = tx.send(result);
+            };
+            request_id::scope(request_id, task).instrument(span)
+        });
+
+        match tokio::time::timeout(timeout, rx).await {
+            Ok(Ok(result)) => Ok(result),
+            Ok(Err(_)) => Err(TaskError::Lost),
+            Err(_) => Err(TaskError::Timeout(timeout)),
+        }
+    }
+
     pub fn get_stats(&self) -> TaskStats {
         TaskStats {
             queued: self.queued.load(Ordering::Relaxed),
@@ -0,0 +1,110 @@
+//! Https on the tcp listener, with a static certificate or one from ACME.
+
+use std::sync::Arc;
+
+use axum::Router;
+use share::{
+    config::TlsConfig,
+    jobs::{JobManager, Stage},
+};
+use tokio::{sync::Notify, task::JoinHandle};
+
+/// Serves `app` over TLS on `listener` until `stop` is notified.
+#[cfg(feature = "tls")]
+pub async fn serve(
+    config: &TlsConfig,
+    listener: std::net::TcpListener,
+    app: Router,
+    stop: Arc<Notify>,
+    jobs: &JobManager,
+) -> eyre::Result<JoinHandle<std::io::Result<()>>> {
+    use std::net::SocketAddr;
+
+    use axum_server::{Handle, tls_rustls::RustlsConfig};
+
+    let handle = Handle::new();
+    tokio::spawn({
+        let handle = handle.clone();
+        // the caller bounds the wait for in-flight requests
+        async move {
+            stop.notified().await;
+            handle.graceful_shutdown(None);
+        }
+    });
+    let make_service = app.into_make_service_with_connect_info::<SocketAddr>();
+
+    if let (Some(cert_path), Some(key_path)) = (&config.cert_path, &config.key_path) {
+        let rustls = RustlsConfig::from_pem_file(cert_path, key_path).await?;
+        tracing::info!("serving https with the certificate {}", cert_path.display());
+        jobs.spawn(
+            "tls_reload",
+            Stage::Worker,
+            reload_certificate(rustls.clone(), cert_path.clone(), key_path.clone()),
+        );
+
+        return Ok(tokio::spawn(
+            axum_server::from_tcp_rustls(listener, rustls)
+                .handle(handle)
+                .serve(make_service),
+        ));
+    }
+
+    let Some(acme) = &config.acme else {
+        eyre::bail!("tls needs cert_path and key_path or an [tls.acme] section");
+    };
+    let mut state = rustls_acme::AcmeConfig::new(acme.domains.clone())
+        .contact(acme.contacts.iter().map(|email| format!("mailto:{email}")))
+        .cache(rustls_acme::caches::DirCache::new(acme.cache_dir.clone()))
+        .directory_lets_encrypt(acme.production)
+        .state();
+    let acceptor = state.axum_acceptor(state.default_rustls_config());
+    tracing::info!(domains = ?acme.domains, production = acme.production, "serving https with acme");
+
+    // orders and renews the certificates, the acceptor serves them
+    jobs.spawn("acme", Stage::Worker, async move {
+        use futures::StreamExt as _;
+
+        while let Some(event) = state.next().await {
+            match event {
+                Ok(event) => tracing::info!("acme: {:?}", event),
+                Err(e) => tracing::error!("acme: {}", e),
+            }
+        }
+    });
+
+    Ok(tokio::spawn(
+        axum_server::from_tcp(listener)
+            .acceptor(acceptor)
+            .handle(handle)
+            .serve(make_service),
+    ))
+}
+
+#[cfg(not(feature = "tls"))]
+pub async fn serve(
+    _config: &TlsConfig,
+    _listener: std::net::TcpListener,
+    _app: Router,
+    _stop: Arc<Notify>,
+    _jobs: &JobManager,
+) -> eyre::Result<JoinHandle<std::io::Result<()>>> {
+    eyre::bail!("tls.enabled needs a build with the tls feature")
+}
+
+#[cfg(feature = "tls")]
+async fn reload_certificate(
+    rustls: axum_server::tls_rustls::RustlsConfig,
+    cert_path: std::path::PathBuf,
+    key_path: std::path::PathBuf,
+) {
+    const RELOAD_INTERVAL: std::time::Duration = std::time::Duration::from_secs(3600);
+
+    let mut interval = tokio::time::interval(RELOAD_INTERVAL);
+    interval.tick().await;
+    loop {
+        interval.tick().await;
+        if let Err(e) = rustls.reload_from_pem_file(&cert_path, &key_path).await {
+            tracing::error!("failed to reload {}: {}", cert_path.display(), e);
+        }
+    }
+}
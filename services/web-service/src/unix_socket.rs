@@ -0,0 +1,91 @@
+//! The unix socket listener next to the tcp one, for a proxy on the same
+//! host where the loopback hop is only overhead.
+
+use std::{
+    net::{IpAddr, Ipv4Addr, SocketAddr},
+    path::Path,
+    sync::Arc,
+};
+
+use axum::{
+    Router,
+    extract::{ConnectInfo, Request, State},
+    http::HeaderName,
+    middleware::{Next, from_fn_with_state},
+    response::Response,
+};
+use share::config::ServerConfig;
+use tokio::{sync::Notify, task::JoinHandle};
+
+/// Binds `path` with `mode`. The socket is bound next to it and renamed
+/// over it, so a replacement after a handoff takes over without a moment
+/// where connecting fails.
+#[cfg(unix)]
+fn bind(path: &Path, mode: u32) -> eyre::Result<tokio::net::UnixListener> {
+    use std::os::unix::fs::PermissionsExt as _;
+
+    let mut staging = path.as_os_str().to_owned();
+    staging.push(format!(".{}", std::process::id()));
+    let staging = std::path::PathBuf::from(staging);
+    // left behind by a crashed process with our pid
+    let _ = std::fs::remove_file(&staging);
+
+    let listener = tokio::net::UnixListener::bind(&staging)?;
+    std::fs::set_permissions(&staging, std::fs::Permissions::from_mode(mode))?;
+    std::fs::rename(&staging, path)?;
+    Ok(listener)
+}
+
+/// Serves `app` on the configured socket until `stop` is notified.
+#[cfg(unix)]
+pub fn serve(
+    config: &ServerConfig,
+    path: &Path,
+    app: Router,
+    stop: Arc<Notify>,
+) -> eyre::Result<JoinHandle<std::io::Result<()>>> {
+    let listener = bind(path, config.unix_socket_mode)?;
+    let header = HeaderName::from_bytes(config.unix_socket_client_ip_header.as_bytes())?;
+    let app = app.layer(from_fn_with_state(header, client_addr));
+    tracing::info!("also serving on {}", path.display());
+
+    Ok(tokio::spawn(async move {
+        axum::serve(listener, app.into_make_service())
+            .with_graceful_shutdown(async move { stop.notified().await })
+            .await
+    }))
+}
+
+#[cfg(not(unix))]
+pub fn serve(
+    _config: &ServerConfig,
+    _path: &Path,
+    _app: Router,
+    _stop: Arc<Notify>,
+) -> eyre::Result<JoinHandle<std::io::Result<()>>> {
+    eyre::bail!("server.unix_socket is unix only")
+}
+
+/// Gives requests over the socket the [`ConnectInfo`] the handlers and the
+/// rate limits expect, from the header the proxy sets. Only local processes
+/// can connect, so the header is trusted here and nowhere else.
+async fn client_addr(
+    State(header): State<HeaderName>,
+    mut request: Request,
+    next: Next,
+) -> Response {
+    let ip = request
+        .headers()
+        .get(&header)
+        .and_then(|value| value.to_str().ok())
+        // the proxy appends the peer it saw to x-forwarded-for, the entries
+        // before it are whatever the client sent
+        .and_then(|value| value.rsplit(',').next())
+        .and_then(|value| value.trim().parse::<IpAddr>().ok())
+        .unwrap_or(IpAddr::V4(Ipv4Addr::LOCALHOST));
+    request
+        .extensions_mut()
+        .insert(ConnectInfo(SocketAddr::new(ip, 0)));
+
+    next.run(request).await
+}
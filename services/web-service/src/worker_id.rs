@@ -39,23 +39,21 @@ impl WorkerIdManager {
         eyre::bail!("No available worker_id");
     }
 
+    /// Keeps the acquired id reserved, run as a background job.
     pub async fn keep_alive(self: Arc<Self>) {
-        let worker_id = self.worker_id.clone();
         let mut connection = self.connection.clone();
 
-        tokio::spawn(async move {
-            loop {
-                if let Some(id) = *worker_id.lock().await {
-                    let key = format!("snowflake:worker:{}", id);
-                    let _: () = redis::cmd("EXPIRE")
-                        .arg(&key)
-                        .arg(60)
-                        .query_async(&mut connection)
-                        .await
-                        .unwrap_or(());
-                }
-                tokio::time::sleep(std::time::Duration::from_secs(30)).await;
+        loop {
+            if let Some(id) = *self.worker_id.lock().await {
+                let key = format!("snowflake:worker:{}", id);
+                let _: () = redis::cmd("EXPIRE")
+                    .arg(&key)
+                    .arg(60)
+                    .query_async(&mut connection)
+                    .await
+                    .unwrap_or(());
             }
-        });
+            tokio::time::sleep(std::time::Duration::from_secs(30)).await;
+        }
     }
 }
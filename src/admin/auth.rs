@@ -0,0 +1,36 @@
+use axum::{
+    body::Body,
+    extract::{Request, State},
+    http::{self, Response, StatusCode},
+    middleware::Next,
+};
+
+use super::{AdminState, plain};
+
+/// Rejects anything without `Authorization: Bearer <admin token>`. The
+/// routes are only mounted when a token is configured.
+pub async fn require_token(
+    State(state): State<AdminState>,
+    request: Request,
+    next: Next,
+) -> Response<Body> {
+    let provided = request
+        .headers()
+        .get(http::header::AUTHORIZATION)
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "));
+
+    let authorized = match (provided, state.token.as_deref()) {
+        (Some(provided), Some(token)) => constant_time_eq(provided.as_bytes(), token.as_bytes()),
+        _ => false,
+    };
+    if !authorized {
+        return plain(StatusCode::UNAUTHORIZED, "unauthorized");
+    }
+
+    next.run(request).await
+}
+
+fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    a.len() == b.len() && a.iter().zip(b).fold(0u8, |acc, (x, y)| acc | (x ^ y)) == 0
+}
@@ -0,0 +1,14 @@
+use axum::{
+    body::Body,
+    http::{Response, StatusCode},
+};
+
+use super::plain;
+
+/// Same as sending SIGHUP. The services reload in the background and log
+/// which sections changed, or why the file was rejected.
+pub async fn reload() -> Response<Body> {
+    tracing::info!("config reload requested from the admin endpoint");
+    share::reload::request_reload();
+    plain(StatusCode::ACCEPTED, "reload requested")
+}
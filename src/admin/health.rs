@@ -28,10 +28,14 @@ impl Health {
         let shutdown_tx = health.shutdown_tx.clone();
         let default_hook = panic::take_hook();
         panic::set_hook(Box::new(move |panic_info| {
-            tracing::error!(%panic_info, "panic has occurred. moving to Unhealthy");
+            default_hook(panic_info);
+            // caught by share::task, only that task ends
+            if share::task::is_guarded() {
+                return;
+            }
+            tracing::error!("panic has occurred. moving to Unhealthy");
             healthy.swap(false, Relaxed);
             let _ = shutdown_tx.send(share::signal::ShutdownKind::Normal);
-            default_hook(panic_info);
         }));
 
         health
@@ -0,0 +1,47 @@
+use axum::{
+    body::Body,
+    http::{Response, StatusCode},
+};
+
+use super::plain;
+
+fn current() -> Response<Body> {
+    match share::tracing::log_filter() {
+        Some(filter) => Response::builder()
+            .header(axum::http::header::CONTENT_TYPE, "text/plain")
+            .body(Body::from(filter))
+            .unwrap(),
+        None => plain(StatusCode::SERVICE_UNAVAILABLE, "tracing not initialized"),
+    }
+}
+
+fn applied(result: Result<(), share::tracing::LogFilterError>) -> Response<Body> {
+    match result {
+        Ok(()) => {
+            tracing::warn!(filter = share::tracing::log_filter(), "log filter changed");
+            current()
+        }
+        Err(e @ share::tracing::LogFilterError::Parse(_)) => Response::builder()
+            .status(StatusCode::BAD_REQUEST)
+            .header(axum::http::header::CONTENT_TYPE, "text/plain")
+            .body(Body::from(e.to_string()))
+            .unwrap(),
+        Err(e) => {
+            tracing::warn!(%e, "failed to change the log filter");
+            plain(StatusCode::INTERNAL_SERVER_ERROR, "internal error")
+        }
+    }
+}
+
+pub async fn get_level() -> Response<Body> {
+    current()
+}
+
+/// Takes the new `RUST_LOG` style directives as the plain text body.
+pub async fn set_level(body: String) -> Response<Body> {
+    applied(share::tracing::set_log_filter(body.trim()))
+}
+
+pub async fn reset_level() -> Response<Body> {
+    applied(share::tracing::reset_log_filter())
+}
@@ -0,0 +1,153 @@
+use std::time::Duration;
+
+use axum::{
+    body::Body,
+    extract::{Query, State},
+    http::{self, Response, StatusCode},
+};
+use serde::Deserialize;
+
+use super::{AdminState, plain};
+
+const DEFAULT_SECONDS: u64 = 30;
+const MAX_SECONDS: u64 = 300;
+
+#[derive(Debug, Deserialize)]
+pub struct ProfileQuery {
+    seconds: Option<u64>,
+}
+
+impl ProfileQuery {
+    fn duration(&self) -> Duration {
+        Duration::from_secs(
+            self.seconds
+                .unwrap_or(DEFAULT_SECONDS)
+                .clamp(1, MAX_SECONDS),
+        )
+    }
+}
+
+fn internal_error(error: eyre::Error) -> Response<Body> {
+    tracing::warn!(%error, "admin http server error");
+    plain(StatusCode::INTERNAL_SERVER_ERROR, "internal error")
+}
+
+/// Counterpart of the goroutine dump: a snapshot of the main runtime's
+/// scheduler. Per-task dumps need `tokio_unstable`, which we don't build with.
+pub async fn runtime(State(state): State<AdminState>) -> Response<Body> {
+    let metrics = state.runtime.metrics();
+    let body = serde_json::json!({
+        "workers": metrics.num_workers(),
+        "alive_tasks": metrics.num_alive_tasks(),
+        "global_queue_depth": metrics.global_queue_depth(),
+    });
+
+    Response::builder()
+        .header(http::header::CONTENT_TYPE, "application/json")
+        .body(Body::from(body.to_string()))
+        .unwrap()
+}
+
+/// CPU profile in gzipped pprof protobuf, readable by `go tool pprof`.
+#[cfg(target_os = "linux")]
+pub async fn profile(Query(query): Query<ProfileQuery>) -> Response<Body> {
+    match collect_cpu(query.duration()).await {
+        Ok(report) => match gzip_pprof(&report) {
+            Ok(body) => Response::builder()
+                .header(http::header::CONTENT_LENGTH, body.len() as u64)
+                .header(http::header::CONTENT_TYPE, "application/octet-stream")
+                .header(http::header::CONTENT_ENCODING, "gzip")
+                .header(
+                    http::header::CONTENT_DISPOSITION,
+                    "attachment; filename=\"profile.pb.gz\"",
+                )
+                .body(Body::from(body))
+                .unwrap(),
+            Err(error) => internal_error(error),
+        },
+        Err(error) => internal_error(error),
+    }
+}
+
+/// The same sampling as [`profile`], rendered as an SVG flamegraph.
+#[cfg(target_os = "linux")]
+pub async fn flamegraph(Query(query): Query<ProfileQuery>) -> Response<Body> {
+    let report = match collect_cpu(query.duration()).await {
+        Ok(report) => report,
+        Err(error) => return internal_error(error),
+    };
+
+    let mut svg = Vec::new();
+    if let Err(error) = report.flamegraph(&mut svg) {
+        return internal_error(error.into());
+    }
+
+    Response::builder()
+        .header(http::header::CONTENT_TYPE, "image/svg+xml")
+        .body(Body::from(svg))
+        .unwrap()
+}
+
+#[cfg(target_os = "linux")]
+async fn collect_cpu(duration: Duration) -> Result<pprof::Report, eyre::Error> {
+    tracing::debug!(duration_seconds = duration.as_secs(), "profiling");
+
+    // only one profiler can be attached to the process at a time, a second
+    // concurrent request fails here instead of corrupting the first
+    let guard = pprof::ProfilerGuardBuilder::default()
+        .frequency(1000)
+        // From the pprof docs, this blocklist helps prevent deadlock with
+        // libgcc's unwind.
+        .blocklist(&["libc", "libgcc", "pthread", "vdso"])
+        .build()?;
+
+    tokio::time::sleep(duration).await;
+
+    Ok(guard.report().build()?)
+}
+
+#[cfg(target_os = "linux")]
+fn gzip_pprof(report: &pprof::Report) -> Result<Vec<u8>, eyre::Error> {
+    use pprof::protos::Message;
+
+    let data = report.pprof()?;
+    let mut buf = Vec::with_capacity(data.encoded_len());
+    data.encode(&mut buf)?;
+
+    let mut encoder = libflate::gzip::Encoder::new(Vec::new())?;
+    std::io::copy(&mut &buf[..], &mut encoder)?;
+    Ok(encoder.finish().into_result()?)
+}
+
+/// Heap profile from jemalloc's sampler, already gzipped pprof protobuf.
+#[cfg(all(target_os = "linux", feature = "heap-profiling"))]
+pub async fn heap() -> Response<Body> {
+    let Some(prof_ctl) = jemalloc_pprof::PROF_CTL.as_ref() else {
+        return plain(StatusCode::NOT_IMPLEMENTED, "heap profiling unavailable");
+    };
+
+    let mut prof_ctl = prof_ctl.lock().await;
+    if !prof_ctl.activated() {
+        return plain(StatusCode::CONFLICT, "heap profiling not activated");
+    }
+
+    match prof_ctl.dump_pprof() {
+        Ok(body) => Response::builder()
+            .header(http::header::CONTENT_TYPE, "application/octet-stream")
+            .header(
+                http::header::CONTENT_DISPOSITION,
+                "attachment; filename=\"heap.pb.gz\"",
+            )
+            .body(Body::from(body))
+            .unwrap(),
+        Err(error) => internal_error(eyre::eyre!(error)),
+    }
+}
+
+#[cfg(not(all(target_os = "linux", feature = "heap-profiling")))]
+pub async fn heap() -> Response<Body> {
+    plain(
+        StatusCode::NOT_IMPLEMENTED,
+        "built without the heap-profiling feature",
+    )
+}
@@ -2,8 +2,7 @@ use std::fmt;
 
 use clap::crate_version;
 use git_testament::{git_testament, render_testament};
-use share::config::AppConfig;
-use share::{config::TomlConfig as _, tracing::init_tracing_subscriber};
+use share::tracing::init_tracing_subscriber;
 
 use crate::admin;
 
@@ -24,6 +23,78 @@ pub struct AdminCli {
     enabled: bool,
     #[clap(long = "admin.address", env = "ARK_VOTE_ADMIN_ADDRESS")]
     pub address: Option<std::net::SocketAddr>,
+    /// Bearer token for the `/debug` endpoints, which stay unmounted without
+    /// it.
+    #[clap(long = "admin.token", env = "ARK_VOTE_ADMIN_TOKEN")]
+    pub token: Option<String>,
+}
+
+/// Values which differ per run or per host, applied over the config file.
+#[derive(Debug, clap::Parser)]
+#[command(next_help_heading = "Config Overrides")]
+pub struct OverrideCli {
+    #[clap(long = "server.host", env = "ARK_VOTE_SERVER_HOST", global = true)]
+    host: Option<String>,
+    #[clap(long = "server.port", env = "ARK_VOTE_SERVER_PORT", global = true)]
+    port: Option<u16>,
+    #[clap(long = "database.redis-url", env = "ARK_VOTE_REDIS_URL", global = true)]
+    redis_url: Option<String>,
+    #[clap(
+        long = "database.mongodb-url",
+        env = "ARK_VOTE_MONGODB_URL",
+        global = true
+    )]
+    mongodb_url: Option<String>,
+    #[clap(
+        long = "database.mongodb-database",
+        env = "ARK_VOTE_MONGODB_DATABASE",
+        global = true
+    )]
+    mongodb_database: Option<String>,
+    #[clap(long = "nats.url", env = "ARK_VOTE_NATS_URL", global = true)]
+    nats_url: Option<String>,
+    /// Origins of the frontend allowed by CORS, comma separated, in place of
+    /// `cors.allow_origin`.
+    #[clap(
+        long = "cors.allow-origin",
+        env = "ARK_VOTE_CORS_ALLOW_ORIGIN",
+        value_delimiter = ',',
+        global = true
+    )]
+    cors_allow_origin: Option<Vec<String>>,
+    /// Default level of the log filter, e.g. `info`.
+    #[clap(long = "log.level", env = "ARK_VOTE_LOG_LEVEL", global = true)]
+    log_level: Option<String>,
+}
+
+impl OverrideCli {
+    /// Before the secrets are resolved, so the overrides take references too.
+    fn apply(&self, config: &mut share::config::AppConfig) {
+        let set = |target: &mut String, value: &Option<String>| {
+            if let Some(value) = value {
+                target.clone_from(value);
+            }
+        };
+        set(&mut config.server.host, &self.host);
+        if let Some(port) = self.port {
+            config.server.port = port;
+        }
+        set(&mut config.database.redis_url, &self.redis_url);
+        set(&mut config.database.mongodb_url, &self.mongodb_url);
+        set(
+            &mut config.database.mongodb_database,
+            &self.mongodb_database,
+        );
+        set(&mut config.nats.url, &self.nats_url);
+        if let Some(origins) = &self.cors_allow_origin {
+            config.cors.allow_origin = origins
+                .iter()
+                .map(|origin| origin.trim().to_string())
+                .filter(|origin| !origin.is_empty())
+                .collect();
+        }
+        set(&mut config.tracing.level, &self.log_level);
+    }
 }
 
 #[derive(Debug, clap::Parser)]
@@ -32,16 +103,71 @@ pub struct AdminCli {
 pub struct Cli {
     #[clap(subcommand)]
     pub command: Option<Commands>,
+    /// dev, staging or prod, over the `environment` key of the config.
+    #[arg(long = "env", env = "ARK_VOTE_ENV", global = true)]
+    pub environment: Option<share::profile::Environment>,
     #[command(flatten)]
     pub admin: AdminCli,
+    #[command(flatten)]
+    pub overrides: OverrideCli,
 }
 
 #[derive(Clone, Debug, clap::Subcommand)]
 pub enum Commands {
+    #[command(alias = "serve")]
     WebServer,
     NatsConsumer,
     ServiceTest,
+    /// Simulate voters against a running instance and report latencies.
+    LoadTest {
+        /// Base URL of the instance, defaults to `test.base_url`.
+        #[arg(long)]
+        target: Option<String>,
+        /// Pairwise topic to vote on.
+        #[arg(long)]
+        topic: String,
+        #[arg(long, default_value_t = 50)]
+        voters: usize,
+        #[arg(long, default_value_t = 60)]
+        duration_secs: u64,
+        /// Time a simulated voter spends on the captcha.
+        #[arg(long, default_value_t = 0)]
+        captcha_delay_ms: u64,
+    },
     PortableServer,
+    /// Import the operators from the configured game data and exit.
+    SyncOperators,
+    /// Create the MongoDB indexes, safe to run on every deploy.
+    Migrate,
+    /// Insert or update the preset topics of the config.
+    Seed,
+    /// Import the topics and results of a previous backend's vote dump.
+    #[command(alias = "import")]
+    ImportLegacy {
+        /// JSON dump, see `web_service::import_legacy` for the format.
+        path: std::path::PathBuf,
+        /// Only report how the options map to operators.
+        #[arg(long)]
+        dry_run: bool,
+    },
+    /// Write topics and their results in the format `import-legacy` reads.
+    Export {
+        /// Topics to export, all of them when not given.
+        #[arg(long)]
+        topic: Vec<String>,
+        /// Written to stdout when not given.
+        #[arg(long, short)]
+        output: Option<std::path::PathBuf>,
+    },
+    /// Copy the results of topics from redis to the `results_snapshots`
+    /// collection.
+    Snapshot {
+        /// Topics to snapshot, all of them when not given.
+        #[arg(long)]
+        topic: Vec<String>,
+    },
+    /// Print the signing secret of the api key given on stdin.
+    SigningSecret,
 }
 
 impl fmt::Display for Commands {
@@ -50,52 +176,136 @@ impl fmt::Display for Commands {
             Commands::WebServer => write!(f, "web-server"),
             Commands::NatsConsumer => write!(f, "nats-consumer"),
             Commands::ServiceTest => write!(f, "service-test"),
+            Commands::LoadTest { .. } => write!(f, "load-test"),
             Commands::PortableServer => write!(f, "portable-server"),
+            Commands::SyncOperators => write!(f, "sync-operators"),
+            Commands::Migrate => write!(f, "migrate"),
+            Commands::Seed => write!(f, "seed"),
+            Commands::ImportLegacy { .. } => write!(f, "import-legacy"),
+            Commands::Export { .. } => write!(f, "export"),
+            Commands::Snapshot { .. } => write!(f, "snapshot"),
+            Commands::SigningSecret => write!(f, "signing-secret"),
         }
     }
 }
 
 impl Cli {
     pub async fn drive(self) -> Result<(), eyre::Error> {
-        let config: AppConfig = AppConfig::load_or_create("config/app.toml");
+        let mut config =
+            share::profile::load_or_create(share::config::CONFIG_PATH, self.environment);
+        self.overrides.apply(&mut config);
         let service_name = self
             .command
             .as_ref()
             .map(|cmd| format!("{cmd}"))
             .unwrap_or_else(|| "ark_vote".to_string());
+        // prefork workers log to files of their own
+        let service_name = match web_service::prefork_child_index() {
+            Some(index) => format!("{service_name}-{index}"),
+            None => service_name,
+        };
 
-        let guard = init_tracing_subscriber(&config.tracing, &service_name);
-        std::mem::forget(guard);
+        // held until the command returns so the logs and spans get flushed
+        let _tracing = init_tracing_subscriber(&config.tracing, &service_name);
+        // before sentry and the admin health hook, which chain to it
+        share::task::install_panic_hook();
 
+        let build = share::build_info::build_info();
         tracing::info!(
             version = crate_version!(),
-            commit = option_env!("GIT_COMMIT_HASH"),
+            commit = build.commit.as_deref(),
+            build_time = build.build_time.as_deref(),
+            rustc = build.rustc.as_deref(),
+            environment = %config.environment,
             "starting ark-vote cli application"
         );
 
+        // file:, env: and vault: references in the secret fields
+        let http = share::http_client::HttpClient::new(&config.http_client)?;
+        share::secrets::SecretResolver::new(&config.secrets, http)
+            .resolve_config(&mut config)
+            .await?;
+        // fail before connecting anywhere, with every problem at once
+        config.validate()?;
+
         let version = render_testament!(TESTAMENT).leak();
 
         let sentry_config = &config.sentry;
-        if !sentry_config.dsn.is_empty() {
+        // the client stops sending once the guard is dropped, so it lives as
+        // long as the command and flushes the queued events on the way out
+        let _sentry = (!sentry_config.dsn.is_empty()).then(|| {
             tracing::info!("sentry DSN is set, initializing Sentry");
-            let dsn = sentry_config.dsn.clone();
-            let _guard = sentry::init((
-                dsn,
+            let guard = sentry::init((
+                sentry_config.dsn.clone(),
                 sentry::ClientOptions {
                     release: Some(std::borrow::Cow::Borrowed(version)),
+                    environment: sentry_config.environment.clone().map(Into::into),
+                    attach_stacktrace: true,
                     traces_sample_rate: 1.0,
                     ..Default::default()
                 },
             ));
+            sentry::configure_scope(|scope| scope.set_tag("service", &service_name));
+            guard
+        });
+
+        if config.alert.enabled {
+            match share::http_client::HttpClient::new(&config.http_client) {
+                Ok(http) => {
+                    share::alert::ErrorMonitor::new(config.alert.clone(), &service_name, http)
+                        .spawn();
+                }
+                Err(e) => tracing::error!("failed to build the alert http client: {}", e),
+            }
         }
 
         if matches!(self.command, Some(Commands::ServiceTest)) {
             return service_test::ServiceTester::new(config).run().await;
         }
+        if let Some(Commands::LoadTest {
+            target,
+            topic,
+            voters,
+            duration_secs,
+            captcha_delay_ms,
+        }) = &self.command
+        {
+            let options = service_test::LoadTestOptions {
+                base_url: target.clone().unwrap_or(config.test.base_url.clone()),
+                topic_id: topic.clone(),
+                voters: (*voters).max(1),
+                duration: std::time::Duration::from_secs(*duration_secs),
+                captcha_delay: std::time::Duration::from_millis(*captcha_delay_ms),
+            };
+            return service_test::LoadTester::new(options).run().await;
+        }
+        if matches!(self.command, Some(Commands::SyncOperators)) {
+            return web_service::sync_operators(config).await;
+        }
+        match &self.command {
+            Some(Commands::Migrate) => return web_service::migrate(config).await,
+            Some(Commands::Seed) => return web_service::seed(config).await,
+            Some(Commands::ImportLegacy { path, dry_run }) => {
+                return web_service::import_legacy(config, path, *dry_run).await;
+            }
+            Some(Commands::Export { topic, output }) => {
+                return web_service::export(config, topic, output.as_deref()).await;
+            }
+            Some(Commands::Snapshot { topic }) => {
+                return web_service::snapshot(config, topic).await;
+            }
+            Some(Commands::SigningSecret) => return web_service::signing_secret(config),
+            _ => {}
+        }
 
         let (shutdown_tx, shutdown_rx) = share::signal::spawn_handler();
-        if self.admin.enabled {
-            admin::server(shutdown_tx, self.admin.address);
+        // WatchdogSec= in the unit, prefork workers are not the main process
+        if web_service::prefork_child_index().is_none() {
+            share::systemd::spawn_watchdog();
+        }
+        // the supervisor owns the admin address in prefork mode
+        if self.admin.enabled && web_service::prefork_child_index().is_none() {
+            admin::server(shutdown_tx, self.admin.address, self.admin.token.clone());
         }
 
         match self.command {
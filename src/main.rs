@@ -1,7 +1,16 @@
-use mimalloc::MiMalloc;
+#[cfg(not(all(target_os = "linux", feature = "heap-profiling")))]
+#[global_allocator]
+static GLOBAL: mimalloc::MiMalloc = mimalloc::MiMalloc;
 
+// jemalloc only for heap profiling builds, mimalloc has no sampling profiler
+#[cfg(all(target_os = "linux", feature = "heap-profiling"))]
 #[global_allocator]
-static GLOBAL: MiMalloc = MiMalloc;
+static GLOBAL: tikv_jemallocator::Jemalloc = tikv_jemallocator::Jemalloc;
+
+#[cfg(all(target_os = "linux", feature = "heap-profiling"))]
+#[allow(non_upper_case_globals)]
+#[unsafe(export_name = "malloc_conf")]
+pub static malloc_conf: &[u8] = b"prof:true,prof_active:true,lg_prof_sample:19\0";
 
 fn main() {
     tokio::runtime::Builder::new_multi_thread()